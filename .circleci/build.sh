@@ -5,7 +5,7 @@ echo "Building for Linux"
 env GOOS=linux go build ./...
 
 echo "Building for FreeBSD"
-env GOOS=freebsd go build ./ntp/...
+env GOOS=freebsd go build ./ntp/... ./timestamp/... ./ptp/protocol/... ./ptp/simpleclient/...
 
 echo "Building for Mac"
-env GOOS=darwin go build ./ntp/...
+env GOOS=darwin go build ./ntp/... ./timestamp/... ./ptp/protocol/... ./ptp/simpleclient/...
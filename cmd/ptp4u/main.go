@@ -22,8 +22,15 @@ import (
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/facebook/time/phc"
+	"github.com/facebook/time/ptp/profile"
+	ptp "github.com/facebook/time/ptp/protocol"
 	"github.com/facebook/time/ptp/ptp4u/server"
 	"github.com/facebook/time/ptp/ptp4u/stats"
 	"github.com/facebook/time/timestamp"
@@ -34,26 +41,134 @@ func main() {
 	c := &server.Config{}
 
 	var ipaddr string
+	var upstream string
+	var upstreamIP string
+	var upstreamPanicExit bool
+	var upstreamSecondaries string
+	var upstreamNTP string
 	var pprofaddr string
+	var profileName string
+	var domain int
+	var version string
+	var aclPath string
+	var policiesPath string
+	var extraDomains string
+	var interopDomains string
+	var oscillatordAddr string
+	var holdoverSpec time.Duration
+	var configPath string
+	var configWatch time.Duration
+	var logFormat string
 
-	flag.IntVar(&c.DSCP, "dscp", 0, "DSCP for PTP packets, valid values are between 0-63 (used by send workers)")
-	flag.StringVar(&ipaddr, "ip", "::", "IP to bind on")
+	flag.IntVar(&c.MaxSubscribers, "maxsubscribers", 0, "Max number of granted subscriptions, 0 is unlimited")
+	flag.Var(&c.Eviction, "eviction", fmt.Sprintf("Policy once maxsubscribers is reached. Can be: %s (new subscribers), %s (evict subscription with the shortest remaining grant)", server.EvictNone, server.EvictShortest))
+	flag.StringVar(&c.StateFile, "statefile", "", "File to save granted subscriptions to and restore them from on startup, empty disables it")
+	flag.DurationVar(&c.StateInterval, "stateinterval", 10*time.Second, "Interval of saving granted subscriptions to the statefile")
+	flag.StringVar(&c.AdminSocket, "adminsocket", "", "Unix socket to serve admin API (drain, undrain, subscribers, cancel) on, empty disables it")
+	flag.StringVar(&configPath, "config", "", "JSON file with interface, timestamptype, dscp, generaldscp, workers, extradomains and upstream, upstreaminterval, upstreamfilter, upstreamservo, upstreamfirststep, upstreamstep overriding the flags. Reloaded on SIGHUP")
+	flag.DurationVar(&configWatch, "configwatch", 0, "Interval of checking if the config file changed and reloading it, 0 only reloads on SIGHUP")
+	flag.StringVar(&policiesPath, "policies", "", "File with '<prefix|clock identity> [type=sync,announce,delay_resp] [mininterval=1s] [maxduration=1h]' rules clamping grants of client groups. Reloaded on SIGHUP")
+	flag.StringVar(&aclPath, "acl", "", "File with 'allow <prefix>' and 'deny <prefix>' rules restricting clients which may subscribe. Reloaded on SIGHUP")
+	flag.DurationVar(&c.BusyPoll, "busypoll", 0, "Busy poll event socket for this long to reduce RX timestamp jitter, 0 disables busy polling")
+	flag.IntVar(&c.BusyPollBudget, "busypollbudget", 0, "Max number of packets processed per busy poll, 0 uses kernel default")
+	flag.IntVar(&c.DSCP, "dscp", 0, "DSCP for PTP event packets (Sync, Delay_Resp), valid values are between 0-63 (used by send workers)")
+	flag.IntVar(&c.GeneralDSCP, "generaldscp", -1, "DSCP for PTP general packets (Announce, Follow_Up, Signaling), valid values are between 0-63, -1 uses dscp")
+	flag.IntVar(&domain, "domain", -1, "PTP domain number, -1 uses the default domain of the profile")
+	flag.StringVar(&interopDomains, "interop", "", "Comma separated list of domains to serve as a plain IEEE 1588 unicast master, for standard two-step unicast clients")
+	flag.StringVar(&extraDomains, "extradomains", "", "Comma separated list of additional domains to serve, as domain[:clockClass[:clockAccuracy[:offsetScaledLogVariance]]]")
+	flag.StringVar(&ipaddr, "ip", "::", "IP to bind on. IPv4 and IPv6 addresses separated by comma are served simultaneously")
+	flag.StringVar(&upstream, "upstream", "", "IP of the grandmaster to sync PHC of the interface from as a boundary clock")
+	flag.StringVar(&upstreamIP, "upstreamip", "", "IP to talk to the upstream grandmaster from, must not be served to clients")
+	flag.DurationVar(&c.UpstreamInterval, "upstreaminterval", 1*time.Second, "Interval of Announce, Sync and Delay_Resp requested from the upstream grandmaster")
+	flag.DurationVar(&c.UpstreamAsymmetry, "upstreamasymmetry", 0, "Static delay asymmetry of the path to the upstream grandmaster, positive when the path from it is longer")
+	flag.BoolVar(&c.UpstreamMonitor, "upstreammonitor", false, "Only measure offset of PHC from the upstream grandmaster, without disciplining PHC or announcing the grandmaster")
+	flag.StringVar(&c.UpstreamFilter, "upstreamfilter", "", "Comma separated filters of offsets from the upstream grandmaster applied before the servo: median:N, mad:K[:N], lucky:N")
+	flag.StringVar(&c.UpstreamServo, "upstreamservo", server.ServoPI, fmt.Sprintf("Servo disciplining PHC to the upstream grandmaster: %s, %s", server.ServoPI, server.ServoLinreg))
+	flag.DurationVar(&c.UpstreamFirstStep, "upstreamfirststep", 20*time.Microsecond, "Step PHC if its offset from the upstream grandmaster is above this until synced")
+	flag.DurationVar(&c.UpstreamStep, "upstreamstep", time.Second, "Step PHC if its offset from the upstream grandmaster is above this once synced")
+	flag.DurationVar(&c.UpstreamPanic, "upstreampanic", 0, "Ignore offsets from the upstream grandmaster above this once synced, or exit with upstreampanicexit. 0 disables it")
+	flag.BoolVar(&upstreamPanicExit, "upstreampanicexit", false, "Exit if offset from the upstream grandmaster is above upstreampanic")
+	flag.Var(&c.UpstreamLeap, "upstreamleap", fmt.Sprintf("What to do with leap seconds announced by the upstream grandmaster. Can be: %s (to clients), %s (also arm CLOCK_REALTIME), %s (with leapsmear mode and window)", server.UpstreamLeapPropagate, server.UpstreamLeapKernel, server.UpstreamLeapSmear))
+	flag.StringVar(&upstreamSecondaries, "upstreamsecondaries", "", "Comma separated interfaces whose PHCs are kept in sync with the one disciplined to the upstream grandmaster")
+	flag.StringVar(&upstreamNTP, "upstreamntp", "", "Comma separated NTP servers to cross-check time of the boundary clock against, empty disables it")
+	flag.DurationVar(&c.UpstreamNTPInterval, "upstreamntpinterval", time.Minute, "Interval of cross-checking time of the boundary clock against upstreamntp")
+	flag.DurationVar(&c.UpstreamNTPThreshold, "upstreamntpthreshold", 100*time.Millisecond, "Alert if correction by the upstream grandmaster leaves the boundary clock further than this from NTP time")
+	flag.BoolVar(&c.UpstreamNTPRefuse, "upstreamntprefuse", false, "Refuse corrections leaving the boundary clock further than upstreamntpthreshold from NTP time instead of only alerting")
+	flag.StringVar(&c.UpstreamStateFile, "upstreamstatefile", "", "File to save frequency and filter state of the boundary clock to every stateinterval and resume from on startup, empty disables it")
+	flag.DurationVar(&c.Holdover, "holdover", 0, "For how long to announce holdover after losing the upstream grandmaster, before announcing free running clock")
 	flag.StringVar(&pprofaddr, "pprofaddr", "", "host:port for the pprof to bind")
 	flag.StringVar(&c.Interface, "iface", "eth0", "Set the interface")
+	flag.StringVar(&profileName, "profile", "", fmt.Sprintf("PTP profile to enforce message rates and domains of. Can be: %s. Empty means no profile", strings.Join(profile.Names(), ", ")))
 	flag.StringVar(&c.LogLevel, "loglevel", "warning", "Set a log level. Can be: debug, info, warning, error")
+	flag.StringVar(&logFormat, "logformat", "text", "Set a log format. Can be: text, json")
+	flag.IntVar(&c.LogRateLimit, "lograte", 100, "Max number of log lines of each level about subscribers per second, 0 is unlimited")
 	flag.DurationVar(&c.MinSubInterval, "minsubinterval", 1*time.Second, "Minimum interval of the sync/announce subscription messages")
+	flag.DurationVar(&c.MulticastAnnounceInterval, "multicastannounce", 0, "Interval of multicast announce messages, 0 disables multicast announce")
+	flag.DurationVar(&c.MulticastSyncInterval, "multicastsync", 0, "Interval of multicast sync messages, 0 disables multicast sync")
+	flag.BoolVar(&c.MulticastHybrid, "hybrid", false, "Send delay responses to multicast clients via unicast")
 	flag.DurationVar(&c.MaxSubDuration, "maxsubduration", 1*time.Hour, "Maximum sync/announce/delay_resp subscription duration")
-	flag.StringVar(&c.TimestampType, "timestamptype", timestamp.HWTIMESTAMP, fmt.Sprintf("Timestamp type. Can be: %s, %s", timestamp.HWTIMESTAMP, timestamp.SWTIMESTAMP))
+	c.TimestampType = timestamp.HW
+	flag.Var(&c.TimestampType, "timestamptype", fmt.Sprintf("Timestamp type. Can be: %s, %s", timestamp.HW, timestamp.SW))
+	flag.IntVar(&c.TXTSFallbackFailures, "txtsfallback", 100, fmt.Sprintf("Fall back to %s timestamps after that many %s TX timestamps are missing in a row, 0 disables fallback", timestamp.SW, timestamp.HW))
+	flag.DurationVar(&c.TXTSRetryInterval, "txtsretry", 5*time.Minute, fmt.Sprintf("Interval of retrying %s timestamps after fallback", timestamp.HW))
 	flag.DurationVar(&c.UTCOffset, "utcoffset", 37*time.Second, "Set the number of workers. Ignored if shm is set")
+	flag.BoolVar(&c.OneStep, "onestep", false, "Send one-step sync without follow up if the NIC supports it, fall back to two-step otherwise")
+	flag.BoolVar(&c.PeerDelay, "peerdelay", false, "Answer peer delay requests")
+	flag.StringVar(&oscillatordAddr, "oscillatord", "", "host:port of oscillatord monitoring to follow the clock quality of, empty announces static clock quality")
+	flag.DurationVar(&holdoverSpec, "holdoverspec", 4*time.Hour, "How long the clock stays within holdover specification after losing the lock, used with oscillatord")
+	flag.DurationVar(&c.ClockQualityInterval, "clockqualityinterval", 1*time.Second, "Interval of refreshing the clock quality from oscillatord")
+	flag.StringVar(&c.LeapSecondsFile, "leapseconds", "", "leap-seconds.list or time zone file (like /usr/share/zoneinfo/right/UTC) to follow UTC offset and leap second flags from. Overrides utcoffset and shm")
+	flag.Var(&c.LeapSmear, "leapsmear", fmt.Sprintf("Smear leap seconds from leapseconds file instead of announcing them. Can be: %s, %s, %s", server.SmearNone, server.SmearLinear, server.SmearCosine))
+	flag.DurationVar(&c.LeapSmearWindow, "leapsmearwindow", 24*time.Hour, "Window centered at the leap second to smear it over")
 	flag.BoolVar(&c.SHM, "shm", false, "Use Share Memory Segment to determine UTC offset periodically")
 	flag.IntVar(&c.SendWorkers, "workers", 100, "Set the number of send workers")
+	flag.IntVar(&c.MinSendWorkers, "minworkers", 1, "Min number of send workers when autoscaling")
+	flag.IntVar(&c.MaxSendWorkers, "maxworkers", 0, "Max number of send workers when autoscaling, 0 disables autoscaling")
+	flag.DurationVar(&c.AutoscaleInterval, "autoscaleinterval", 1*time.Minute, "Interval of resizing send workers to the load")
+	flag.IntVar(&c.AutoscaleQueue, "autoscalequeue", 100, "Queue depth of any send worker to add workers at, 0 ignores the queue. Requires queue to be set")
+	flag.DurationVar(&c.AutoscaleTXTSLatency, "autoscaletxts", 1*time.Millisecond, "TX timestamp latency of any send worker to add workers at, 0 ignores the latency")
 	flag.IntVar(&c.RecvWorkers, "recvworkers", 10, "Set the number of receive workers")
+	flag.BoolVar(&c.ReusePort, "reuseport", false, "Give every receive worker own socket bound with SO_REUSEPORT, so the kernel spreads clients across them")
+	flag.BoolVar(&c.IOURing, "iouring", false, "Read event messages with io_uring, falling back to recvmsg if the kernel doesn't support it")
 	flag.IntVar(&c.MonitoringPort, "monitoringport", 8888, "Port to run monitoring server on")
+	flag.StringVar(&version, "ptpversion", ptp.VersionString(ptp.Version), "PTP version (major.minor) to emit. Lower minor version of the client is used if it asks for it")
 	flag.IntVar(&c.QueueSize, "queue", 0, "Size of the queue to send out packets")
 	flag.DurationVar(&c.MetricInterval, "metricinterval", 1*time.Minute, "Interval of resetting metrics")
 
 	flag.Parse()
 
+	if configPath != "" {
+		dc := &server.DynamicConfig{
+			Interface:     c.Interface,
+			TimestampType: c.TimestampType,
+			DSCP:          c.DSCP,
+			GeneralDSCP:   c.GeneralDSCP,
+			SendWorkers:   c.SendWorkers,
+			ExtraDomains:  extraDomains,
+			UpstreamDynamicConfig: server.UpstreamDynamicConfig{
+				Upstream:          upstream,
+				UpstreamInterval:  c.UpstreamInterval.String(),
+				UpstreamFilter:    c.UpstreamFilter,
+				UpstreamServo:     c.UpstreamServo,
+				UpstreamFirstStep: c.UpstreamFirstStep.String(),
+				UpstreamStep:      c.UpstreamStep.String(),
+			},
+		}
+		if err := server.ReadDynamicConfig(configPath, dc); err != nil {
+			log.Fatalf("Failed to read config: %v", err)
+		}
+		if err := dc.UpstreamDynamicConfig.Apply(c); err != nil {
+			log.Fatalf("Failed to read config: %v", err)
+		}
+		upstream = dc.Upstream
+		c.Interface = dc.Interface
+		c.TimestampType = dc.TimestampType
+		c.DSCP = dc.DSCP
+		c.GeneralDSCP = dc.GeneralDSCP
+		c.SendWorkers = dc.SendWorkers
+		extraDomains = dc.ExtraDomains
+	}
+
 	switch c.LogLevel {
 	case "debug":
 		log.SetLevel(log.DebugLevel)
@@ -67,27 +182,175 @@ func main() {
 		log.Fatalf("Unrecognized log level: %v", c.LogLevel)
 	}
 
+	switch logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatalf("Unrecognized log format: %v", logFormat)
+	}
+
+	if c.TXTSFallbackFailures > 0 && c.TXTSRetryInterval <= 0 {
+		log.Fatalf("TX timestamp retry interval must be positive, got %v", c.TXTSRetryInterval)
+	}
+
+	if (c.StateFile != "" || c.UpstreamStateFile != "") && c.StateInterval <= 0 {
+		log.Fatalf("State saving interval must be positive, got %v", c.StateInterval)
+	}
+
+	if c.Autoscale() {
+		if c.MinSendWorkers < 1 || c.MaxSendWorkers < c.MinSendWorkers {
+			log.Fatalf("Unsupported autoscaling bounds %d-%d", c.MinSendWorkers, c.MaxSendWorkers)
+		}
+		if c.AutoscaleQueue <= 0 && c.AutoscaleTXTSLatency <= 0 {
+			log.Fatalf("Autoscaling requires autoscalequeue or autoscaletxts")
+		}
+		if c.AutoscaleInterval <= 0 {
+			log.Fatalf("Autoscaling interval must be positive, got %v", c.AutoscaleInterval)
+		}
+		if c.SendWorkers < c.MinSendWorkers {
+			c.SendWorkers = c.MinSendWorkers
+		}
+		if c.SendWorkers > c.MaxSendWorkers {
+			c.SendWorkers = c.MaxSendWorkers
+		}
+	}
+
 	if c.DSCP < 0 || c.DSCP > 63 {
 		log.Fatalf("Unsupported DSCP value %v", c.DSCP)
 	}
+	if c.GeneralDSCP > 63 {
+		log.Fatalf("Unsupported general DSCP value %v", c.GeneralDSCP)
+	}
+
+	if profileName != "" {
+		p, err := profile.ByName(profileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if p.Transport != profile.TransportUDP {
+			log.Fatalf("Profile %s requires %s transport, which is not supported", p.Name, p.Transport)
+		}
+		c.Profile = p
+		c.DomainNumber = p.DefaultDomain
+	}
+	if domain >= 0 {
+		if domain > 255 || (c.Profile != nil && !c.Profile.ValidDomain(uint8(domain))) {
+			log.Fatalf("Unsupported domain %d", domain)
+		}
+		c.DomainNumber = uint8(domain)
+	}
+
+	if oscillatordAddr != "" {
+		c.ClockQualitySource = server.NewOscillatordSource(oscillatordAddr, holdoverSpec)
+	}
+
+	domains, err := server.ParseDomains(extraDomains)
+	if err != nil {
+		log.Fatalf("Unsupported extra domains %q: %v", extraDomains, err)
+	}
+	for _, d := range domains {
+		if d.DomainNumber == c.DomainNumber || (c.Profile != nil && !c.Profile.ValidDomain(d.DomainNumber)) {
+			log.Fatalf("Unsupported extra domain %d", d.DomainNumber)
+		}
+	}
+	c.Domains = domains
+
+	interop, err := server.ParseInteropDomains(interopDomains)
+	if err != nil {
+		log.Fatalf("Unsupported interop domains %q: %v", interopDomains, err)
+	}
+	c.InteropDomains = interop
+
+	v, err := ptp.ParseVersion(version)
+	if err != nil {
+		log.Fatalf("Unsupported PTP version %s: %v", version, err)
+	}
+	c.Version = v
+
+	if aclPath != "" {
+		acl, err := server.ReadACL(aclPath)
+		if err != nil {
+			log.Fatalf("Failed to read ACL: %v", err)
+		}
+		c.ACL = acl
+	}
+
+	if policiesPath != "" {
+		policies, err := server.ReadPolicies(policiesPath)
+		if err != nil {
+			log.Fatalf("Failed to read policies: %v", err)
+		}
+		c.Policies = policies
+	}
+
+	if c.LeapSmear != server.SmearNone {
+		if c.LeapSecondsFile == "" && c.UpstreamLeap != server.UpstreamLeapSmear || c.LeapSmearWindow <= 0 {
+			log.Fatalf("Leap smearing requires leapseconds file or upstreamleap %s, and positive leapsmearwindow", server.UpstreamLeapSmear)
+		}
+		if c.OneStep {
+			log.Fatalf("Leap smearing cannot be combined with one-step sync as NIC sets Sync timestamps")
+		}
+	}
+
+	if c.BusyPoll < 0 || c.BusyPollBudget < 0 {
+		log.Fatalf("Unsupported busy poll settings %v, %v", c.BusyPoll, c.BusyPollBudget)
+	}
 
 	switch c.TimestampType {
-	case timestamp.SWTIMESTAMP:
+	case timestamp.SW:
 		log.Warning("Software timestamps greatly reduce the precision")
 		fallthrough
-	case timestamp.HWTIMESTAMP:
+	case timestamp.HW:
 		log.Debugf("Using %s timestamps", c.TimestampType)
 	default:
 		log.Fatalf("Unrecognized timestamp type: %s", c.TimestampType)
 	}
 
-	c.IP = net.ParseIP(ipaddr)
+	if err := c.SetIPs(ipaddr); err != nil {
+		log.Fatal(err)
+	}
 	found, err := c.IfaceHasIP()
 	if err != nil {
 		log.Fatal(err)
 	}
 	if !found {
-		log.Fatalf("IP '%s' is not found on interface '%s'", c.IP, c.Interface)
+		log.Fatalf("IP '%s' is not found on interface '%s'", ipaddr, c.Interface)
+	}
+
+	if upstream != "" {
+		c.Upstream = net.ParseIP(upstream)
+		if c.Upstream == nil {
+			log.Fatalf("Unsupported upstream grandmaster IP '%s'", upstream)
+		}
+		c.UpstreamIP = net.ParseIP(upstreamIP)
+		if upstreamSecondaries != "" {
+			c.UpstreamSecondaries = strings.Split(upstreamSecondaries, ",")
+		}
+		if upstreamNTP != "" {
+			c.UpstreamNTP = strings.Split(upstreamNTP, ",")
+		}
+		if err := c.ValidateUpstream(); err != nil {
+			log.Fatalf("Unsupported boundary clock config: %v", err)
+		}
+		if upstreamPanicExit {
+			c.OnUpstreamPanic = func(offset time.Duration) bool {
+				log.Fatalf("Offset %v from upstream grandmaster %s is above panic threshold %v", offset, c.Upstream, c.UpstreamPanic)
+				return false
+			}
+		}
+	} else if c.UpstreamMonitor {
+		log.Fatalf("Upstream grandmaster to monitor is not set")
+	}
+
+	if c.TimestampType == timestamp.HW {
+		info, err := phc.IfaceInfo(c.Interface)
+		if err != nil {
+			log.Fatalf("Failed to get timestamping capabilities of interface '%s': %v", c.Interface, err)
+		}
+		if err := info.CheckHWTimestamping(); err != nil {
+			log.Fatalf("Interface '%s' doesn't support hardware timestamps: %v", c.Interface, err)
+		}
 	}
 
 	if pprofaddr != "" {
@@ -97,7 +360,7 @@ func main() {
 		}()
 	}
 
-	if c.SHM {
+	if c.SHM && c.LeapSecondsFile == "" {
 		if err := c.SetUTCOffsetFromSHM(); err != nil {
 			log.Fatalf("Failed to set UTC offset: %v", err)
 		}
@@ -108,12 +371,48 @@ func main() {
 	// Replace with your implementation of Stats
 	st := stats.NewJSONStats()
 	go st.Start(c.MonitoringPort)
+	timestamp.SetStats(st)
 
-	s := server.Server{
+	s := &server.Server{
 		Config: c,
 		Stats:  st,
 	}
 
+	reloadConfig := func() {
+		if err := s.ReloadConfig(configPath); err != nil {
+			log.Errorf("Failed to reload config: %v", err)
+		} else {
+			log.Infof("Reloaded config from %s", configPath)
+		}
+	}
+	if configPath != "" && configWatch > 0 {
+		go server.WatchConfig(configPath, configWatch, reloadConfig)
+	}
+
+	sigHup := make(chan os.Signal, 1)
+	signal.Notify(sigHup, syscall.SIGHUP)
+	go func() {
+		for range sigHup {
+			if c.ACL != nil {
+				if err := c.ACL.Reload(); err != nil {
+					log.Errorf("Failed to reload ACL: %v", err)
+				} else {
+					log.Infof("Reloaded ACL from %s", aclPath)
+				}
+			}
+			if c.Policies != nil {
+				if err := c.Policies.Reload(); err != nil {
+					log.Errorf("Failed to reload policies: %v", err)
+				} else {
+					log.Infof("Reloaded policies from %s", policiesPath)
+				}
+			}
+			if configPath != "" {
+				reloadConfig()
+			}
+		}
+	}()
+
 	if err := s.Start(); err != nil {
 		log.Fatalf("Server run failed: %v", err)
 	}
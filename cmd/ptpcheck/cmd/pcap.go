@@ -0,0 +1,92 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/facebook/time/ptp/pcap"
+)
+
+var pcapFileFlag string
+
+func init() {
+	RootCmd.AddCommand(pcapCmd)
+	pcapCmd.Flags().StringVarP(&pcapFileFlag, "file", "f", "", "pcap or pcapng file to read")
+}
+
+func runPcap(input string) error {
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := pcap.NewReader(f)
+	if err != nil {
+		return err
+	}
+	msgs := []*pcap.Message{}
+	for {
+		msg, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+	log.Infof("Read %d PTP messages, %d failed to decode", len(msgs), reader.Skipped)
+
+	results := pcap.Exchanges(msgs)
+	if len(results) == 0 {
+		fmt.Println("No complete Sync/Delay_Req exchanges found")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	fmt.Fprintln(w, "time\tserver\tclient\tsync seq\tdelay seq\toffset\tdelay\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%v\t%v\t\n",
+			r.T3.Format("15:04:05.000000"), r.ServerAddr, r.ClientAddr, r.SyncSequenceID, r.DelaySequenceID, r.Offset, r.Delay)
+	}
+	return w.Flush()
+}
+
+var pcapCmd = &cobra.Command{
+	Use:   "pcap",
+	Short: "Compute offsets and delays from PTP exchanges in pcap file",
+	Long: `Decode PTP over UDP and L2 messages from pcap or pcapng file, pair Sync/Follow_Up and Delay_Req/Delay_Resp exchanges
+and print offsets and path delays computed from them.
+Capture timestamps are used as client timestamps, so capture must be taken on the client.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ConfigureVerbosity()
+
+		if pcapFileFlag == "" {
+			log.Fatal("pcap file must be specified")
+		}
+		if err := runPcap(pcapFileFlag); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
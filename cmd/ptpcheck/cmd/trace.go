@@ -20,13 +20,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/facebook/time/ptp/profile"
 	client "github.com/facebook/time/ptp/simpleclient"
 )
 
@@ -35,14 +38,36 @@ var traceDurationFlag time.Duration
 var traceTimeoutFlag time.Duration
 var traceIfaceFlag string
 var traceTimestampingFlag string
+var traceProfileFlag string
+var traceDomainFlag int
+var tracePolicyFlag string
+var traceAsymmetryFlag string
+var traceDSCPFlag int
+var traceMonitoringFlag string
+var traceCompatFlag bool
+var tracePrometheusFlag string
+var tracePushURLFlag string
+var traceExportIntervalFlag time.Duration
+var traceKeysFlag string
 
 func init() {
 	RootCmd.AddCommand(traceCmd)
-	traceCmd.Flags().StringVarP(&traceRemoteServerFlag, "server", "S", "", "server to connect to")
+	traceCmd.Flags().StringVarP(&traceRemoteServerFlag, "server", "S", "", "server to connect to, comma-separated list to poll multiple servers")
 	traceCmd.Flags().StringVarP(&traceIfaceFlag, "iface", "i", "eth0", "network interface to use")
 	traceCmd.Flags().StringVarP(&traceTimestampingFlag, "timestamping", "T", "", fmt.Sprintf("timestamping to use, either %q or %q. empty means auto-detection", client.HWTIMESTAMP, client.SWTIMESTAMP))
 	traceCmd.Flags().DurationVarP(&traceTimeoutFlag, "timeout", "t", 15*time.Second, "global timeout")
 	traceCmd.Flags().DurationVarP(&traceDurationFlag, "duration", "d", 10*time.Second, "duration of the exchange")
+	traceCmd.Flags().StringVarP(&traceProfileFlag, "profile", "p", profile.NameDefault, fmt.Sprintf("PTP profile server operates under, one of %s", strings.Join(profile.Names(), ", ")))
+	traceCmd.Flags().IntVarP(&traceDomainFlag, "domain", "D", -1, "PTP domain number, -1 means default domain of the profile")
+	traceCmd.Flags().StringVarP(&tracePolicyFlag, "policy", "P", "bmca", fmt.Sprintf("policy selecting one of multiple servers, one of %s", strings.Join(client.PolicyNames(), ", ")))
+	traceCmd.Flags().StringVarP(&traceAsymmetryFlag, "asymmetry", "A", "", "comma-separated static delay asymmetries of paths to servers as prefix=duration, positive when path from the server is longer")
+	traceCmd.Flags().IntVar(&traceDSCPFlag, "dscp", 0, "DSCP of sent packets, 0 means default")
+	traceCmd.Flags().StringVar(&traceMonitoringFlag, "monitoringaddr", "", "host:port to serve JSON state of multiple servers on, empty disables it")
+	traceCmd.Flags().BoolVar(&traceCompatFlag, "compat", false, "negotiate like a standard unicast slave, to talk to ptp4l or other non-ptp4u servers")
+	traceCmd.Flags().StringVar(&tracePrometheusFlag, "prometheusaddr", "", "host:port to serve Prometheus metrics of multiple servers on at /metrics, empty disables it")
+	traceCmd.Flags().StringVar(&tracePushURLFlag, "pushurl", "", "URL to POST JSON state of multiple servers to every exportinterval, empty disables it")
+	traceCmd.Flags().DurationVar(&traceExportIntervalFlag, "exportinterval", client.DefaultExportInterval, "interval of exporting state of multiple servers to prometheusaddr and pushurl")
+	traceCmd.Flags().StringVar(&traceKeysFlag, "keys", "", "file with '<server|*> <key id> <hex key>' lines of keys servers sign messages with, messages without valid AUTHENTICATION TLV are dropped. Empty disables authentication")
 }
 
 // reportMeasurements prints all data we collected over the course of communication
@@ -89,6 +114,61 @@ func runTrace(cfg *client.Config) error {
 	return nil
 }
 
+// readTraceKeys reads keys of servers from the file, it's empty without one
+func readTraceKeys(path string) (client.GMKeys, error) {
+	if path == "" {
+		return client.GMKeys{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return client.ParseGMKeys(f)
+}
+
+func runTraceMulti(cfg *client.MultiConfig) error {
+	if tracePrometheusFlag != "" {
+		p := &client.PrometheusExporter{}
+		cfg.Exporters = append(cfg.Exporters, p)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", p)
+		go func() {
+			if err := http.ListenAndServe(tracePrometheusFlag, mux); err != nil {
+				log.Errorf("failed to serve metrics of servers: %v", err)
+			}
+		}()
+	}
+	if tracePushURLFlag != "" {
+		cfg.Exporters = append(cfg.Exporters, client.NewJSONPushExporter(tracePushURLFlag, traceExportIntervalFlag))
+	}
+	history := []*client.MeasurementResult{}
+	c := client.NewMulti(cfg, func(address string, m *client.MeasurementResult) {
+		log.Infof("current numbers from %s: delay = %v, offset = %v, clientToServerDiff = %v, serverToClientDiff = %v", address, m.Delay, m.Offset, m.ClientToServerDiff, m.ServerToClientDiff)
+		history = append(history, m)
+	})
+	defer c.Close()
+	if traceMonitoringFlag != "" {
+		go func() {
+			if err := http.ListenAndServe(traceMonitoringFlag, c); err != nil {
+				log.Errorf("failed to serve state of servers: %v", err)
+			}
+		}()
+	}
+
+	err := c.Run()
+	// try to report in any case, we may have collected some data before failure
+	reportMeasurements(history)
+	if err != nil && !(errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return err
+	}
+	if selected := c.Selected(); selected != nil {
+		log.Infof("selected server: %s", selected.Address)
+	}
+	log.Infof("done")
+	return nil
+}
+
 var traceCmd = &cobra.Command{
 	Use:   "trace",
 	Short: "Talk to PTP unicast server, logging every step in human-friendly form",
@@ -142,13 +222,59 @@ to notify about this event. Clients responds with ACKNOWLEDGE_CANCEL_UNICAST_TRA
 			log.Fatal("duration must be less than timeout")
 		}
 
+		p, err := profile.ByName(traceProfileFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		domain := p.DefaultDomain
+		if traceDomainFlag >= 0 {
+			if traceDomainFlag > 255 || !p.ValidDomain(uint8(traceDomainFlag)) {
+				log.Fatalf("domain %d is not valid for profile %s", traceDomainFlag, p.Name)
+			}
+			domain = uint8(traceDomainFlag)
+		}
+		asymmetries, err := client.ParseAsymmetries(traceAsymmetryFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if traceDSCPFlag < 0 || traceDSCPFlag > 63 {
+			log.Fatalf("dscp %d is not valid, must be between 0-63", traceDSCPFlag)
+		}
+		keys, err := readTraceKeys(traceKeysFlag)
+		if err != nil {
+			log.Fatalf("reading keys: %v", err)
+		}
+
 		cfg := &client.Config{
 			Address:      traceRemoteServerFlag,
 			Iface:        traceIfaceFlag,
 			Timeout:      traceTimeoutFlag,
 			Duration:     traceDurationFlag,
 			Timestamping: traceTimestampingFlag,
+			DomainNumber: domain,
+			Asymmetries:  asymmetries,
+			DSCP:         traceDSCPFlag,
+			Compat:       traceCompatFlag,
+		}
+		servers := strings.Split(traceRemoteServerFlag, ",")
+		if len(servers) > 1 {
+			policy, err := client.PolicyByName(tracePolicyFlag)
+			if err != nil {
+				log.Fatal(err)
+			}
+			multi := &client.MultiConfig{
+				Config:         *cfg,
+				Addresses:      servers,
+				Policy:         policy,
+				ExportInterval: traceExportIntervalFlag,
+				GMKeys:         keys,
+			}
+			if err := runTraceMulti(multi); err != nil {
+				log.Fatal(err)
+			}
+			return
 		}
+		cfg.AuthKeys = keys.For(traceRemoteServerFlag)
 		if err := runTrace(cfg); err != nil {
 			log.Fatal(err)
 		}
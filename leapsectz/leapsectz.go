@@ -20,17 +20,27 @@ limitations under the License.
 package leapsectz
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const file = "/usr/share/zoneinfo/right/UTC"
 
+// ntpEpochOffset is the number of seconds between NTP epoch (1900) and Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// initialOffset is TAI-UTC offset in seconds at the start of leap second era in 1972
+const initialOffset = 10
+
 var errBadData = errors.New("malformed time zone information")
 var errBadVersion = errors.New("version in file is not supported")
 
@@ -76,6 +86,60 @@ func Parse(srcfile string) ([]LeapSecond, error) {
 
 }
 
+// ParseList returns the list of leap seconds from leap-seconds.list file as published by IERS and NIST
+func ParseList(srcfile string) ([]LeapSecond, error) {
+	f, err := os.Open(srcfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseList(f)
+}
+
+// parseList reads lines of NTP timestamp and TAI-UTC offset taking effect at it, skipping comments
+func parseList(r io.Reader) ([]LeapSecond, error) {
+	var ret []LeapSecond
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed leap seconds list at line %d", line)
+		}
+		ntpTime, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil || ntpTime < ntpEpochOffset {
+			return nil, fmt.Errorf("malformed leap seconds list at line %d: bad time %q", line, fields[0])
+		}
+		offset, err := strconv.ParseInt(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed leap seconds list at line %d: bad offset %q", line, fields[1])
+		}
+		nleap := int32(offset - initialOffset)
+		// initial offset is not a leap second
+		if nleap == 0 {
+			continue
+		}
+		// same representation as in time zone files, so LeapSecond.Time works
+		ret = append(ret, LeapSecond{
+			Tleap: ntpTime - ntpEpochOffset + uint64(nleap) - 1,
+			Nleap: nleap,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 func parseVx(r io.Reader) ([]LeapSecond, error) {
 	var ret []LeapSecond
 	var v byte
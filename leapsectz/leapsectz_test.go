@@ -19,6 +19,7 @@ package leapsectz
 import (
 	"bytes"
 	"encoding/binary"
+	"strings"
 	"testing"
 	"time"
 )
@@ -336,3 +337,45 @@ func TestWriteV2(t *testing.T) {
 	}
 
 }
+
+func TestParseList(t *testing.T) {
+	data := `#	Updated through IERS Bulletin C64
+#$	 3676924800
+#@	 3928521600
+#
+2272060800	10	# 1 Jan 1972
+2287785600	11	# 1 Jul 1972
+2303683200	12	# 1 Jan 1973
+3692217600	37	# 1 Jan 2017
+#h	16edd0f0 3666784f 37db6bdd e74ced87 59af48f1
+`
+	ls, err := parseList(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ls) != 3 {
+		t.Fatalf("wrong leap second list length %d", len(ls))
+	}
+
+	// the same as in time zone files
+	if ls[0] != (LeapSecond{78796800, 1}) {
+		t.Errorf("wrong first leap second %+v", ls[0])
+	}
+
+	if ls[1].Time().UTC() != time.Date(1973, time.January, 1, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("wrong leap second time %v", ls[1].Time().UTC())
+	}
+
+	if ls[2].Time().UTC() != time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC) || ls[2].Nleap != 27 {
+		t.Errorf("wrong last leap second %+v", ls[2])
+	}
+}
+
+func TestParseListMalformed(t *testing.T) {
+	for _, data := range []string{"2272060800", "2272060800 10 10", "nope 10", "2272060800 nope", "10 10"} {
+		if _, err := parseList(strings.NewReader(data)); err == nil {
+			t.Errorf("expected error parsing %q", data)
+		}
+	}
+}
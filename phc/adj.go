@@ -0,0 +1,159 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phc
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ppbToFreq converts parts per billion to timex freq, which is ppm with 16 bit fractional part
+func ppbToFreq(ppb float64) int64 {
+	return int64(ppb * 65.536)
+}
+
+// freqToPPB converts timex freq to parts per billion
+func freqToPPB(freq int64) float64 {
+	return float64(freq) / 65.536
+}
+
+// stepTimex returns timex stepping the clock by the offset.
+// Kernel requires nanoseconds of the offset to be non-negative.
+func stepTimex(step time.Duration) *unix.Timex {
+	sec := int64(step / time.Second)
+	nsec := int64(step % time.Second)
+	if nsec < 0 {
+		sec--
+		nsec += int64(time.Second)
+	}
+	tx := &unix.Timex{Modes: unix.ADJ_SETOFFSET | unix.ADJ_NANO}
+	setTimexTime(tx, sec, nsec)
+	return tx
+}
+
+func clockAdjtime(clockid int32, tx *unix.Timex) error {
+	_, _, errno := unix.Syscall(unix.SYS_CLOCK_ADJTIME, uintptr(clockid), uintptr(unsafe.Pointer(tx)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// adjtimeDevice runs clock_adjtime on the PTP device
+func adjtimeDevice(device string, tx *unix.Timex) error {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := clockAdjtime(fdToClockID(f.Fd()), tx); err != nil {
+		return fmt.Errorf("failed clock_adjtime: %w", err)
+	}
+	return nil
+}
+
+// FrequencyPPBFromDevice returns the frequency offset of PTP device in parts per billion
+func FrequencyPPBFromDevice(device string) (float64, error) {
+	tx := &unix.Timex{}
+	if err := adjtimeDevice(device, tx); err != nil {
+		return 0, err
+	}
+	return freqToPPB(int64(tx.Freq)), nil
+}
+
+// freqTimex returns timex setting the frequency offset in parts per billion
+func freqTimex(ppb float64) *unix.Timex {
+	tx := &unix.Timex{Modes: unix.ADJ_FREQUENCY}
+	setTimexFreq(tx, ppbToFreq(ppb))
+	return tx
+}
+
+// AdjFreqPPBFromDevice sets the frequency offset of PTP device in parts per billion
+func AdjFreqPPBFromDevice(device string, ppb float64) error {
+	return adjtimeDevice(device, freqTimex(ppb))
+}
+
+// StepFromDevice steps the time of PTP device by the offset
+func StepFromDevice(device string, step time.Duration) error {
+	return adjtimeDevice(device, stepTimex(step))
+}
+
+// adjtimeRealtime runs clock_adjtime on CLOCK_REALTIME
+func adjtimeRealtime(tx *unix.Timex) error {
+	if err := clockAdjtime(unix.CLOCK_REALTIME, tx); err != nil {
+		return fmt.Errorf("failed clock_adjtime: %w", err)
+	}
+	return nil
+}
+
+// FrequencyPPBRealtime returns the frequency offset of CLOCK_REALTIME in parts per billion
+func FrequencyPPBRealtime() (float64, error) {
+	tx := &unix.Timex{}
+	if err := adjtimeRealtime(tx); err != nil {
+		return 0, err
+	}
+	return freqToPPB(int64(tx.Freq)), nil
+}
+
+// AdjFreqPPBRealtime sets the frequency offset of CLOCK_REALTIME in parts per billion
+func AdjFreqPPBRealtime(ppb float64) error {
+	return adjtimeRealtime(freqTimex(ppb))
+}
+
+// StepRealtime steps the time of CLOCK_REALTIME by the offset
+func StepRealtime(step time.Duration) error {
+	return adjtimeRealtime(stepTimex(step))
+}
+
+// SetLeapRealtime arms the kernel to insert (positive leap) or delete (negative leap) a leap second
+// in CLOCK_REALTIME at the next UTC midnight, zero disarms it
+func SetLeapRealtime(leap int) error {
+	tx := &unix.Timex{}
+	if err := adjtimeRealtime(tx); err != nil {
+		return err
+	}
+	tx.Status = leapStatus(tx.Status, leap)
+	tx.Modes = unix.ADJ_STATUS
+	return adjtimeRealtime(tx)
+}
+
+// leapStatus returns timex status with leap bits set for the leap
+func leapStatus(status int32, leap int) int32 {
+	status &^= unix.STA_INS | unix.STA_DEL
+	if leap > 0 {
+		status |= unix.STA_INS
+	} else if leap < 0 {
+		status |= unix.STA_DEL
+	}
+	return status
+}
+
+// DeviceFromIface returns the path of PTP device of the interface
+func DeviceFromIface(iface string) (string, error) {
+	info, err := IfaceInfo(iface)
+	if err != nil {
+		return "", fmt.Errorf("getting interface info: %w", err)
+	}
+	if info.PHCIndex < 0 {
+		return "", fmt.Errorf("%s doesn't support PHC", iface)
+	}
+	return fmt.Sprintf("/dev/ptp%d", info.PHCIndex), nil
+}
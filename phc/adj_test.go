@@ -0,0 +1,68 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestFreqPPB(t *testing.T) {
+	require.Equal(t, int64(65536), ppbToFreq(1000))
+	require.Equal(t, int64(-6553), ppbToFreq(-100))
+	require.InDelta(t, 1000.0, freqToPPB(65536), 0.001)
+	require.InDelta(t, -100.0, freqToPPB(ppbToFreq(-100)), 0.1)
+}
+
+func TestStepTimex(t *testing.T) {
+	tx := stepTimex(1500 * time.Millisecond)
+	require.Equal(t, uint32(unix.ADJ_SETOFFSET|unix.ADJ_NANO), tx.Modes)
+	require.Equal(t, int64(1), int64(tx.Time.Sec))
+	require.Equal(t, int64(500000000), int64(tx.Time.Usec))
+
+	// nanoseconds are never negative
+	tx = stepTimex(-1500 * time.Millisecond)
+	require.Equal(t, int64(-2), int64(tx.Time.Sec))
+	require.Equal(t, int64(500000000), int64(tx.Time.Usec))
+
+	tx = stepTimex(-time.Second)
+	require.Equal(t, int64(-1), int64(tx.Time.Sec))
+	require.Equal(t, int64(0), int64(tx.Time.Usec))
+}
+
+func TestFreqTimex(t *testing.T) {
+	tx := freqTimex(-100)
+	require.Equal(t, uint32(unix.ADJ_FREQUENCY), tx.Modes)
+	require.Equal(t, int64(-6553), int64(tx.Freq))
+}
+
+func TestFrequencyPPBRealtime(t *testing.T) {
+	// reading doesn't need privileges, and the kernel limits frequency to 500ppm
+	ppb, err := FrequencyPPBRealtime()
+	require.NoError(t, err)
+	require.InDelta(t, 0, ppb, 500000)
+}
+
+func TestLeapStatus(t *testing.T) {
+	// STA_PLL is kept
+	require.Equal(t, int32(unix.STA_PLL|unix.STA_INS), leapStatus(unix.STA_PLL|unix.STA_DEL, 1))
+	require.Equal(t, int32(unix.STA_DEL), leapStatus(unix.STA_INS, -1))
+	require.Equal(t, int32(unix.STA_PLL), leapStatus(unix.STA_PLL|unix.STA_INS, 0))
+}
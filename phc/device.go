@@ -31,6 +31,15 @@ const (
 	ptpClkMagic   = '='
 )
 
+// Missing from sys/unix package, defined in Linux include/uapi/linux/net_tstamp.h
+const (
+	hwtstampTXOn               = 1
+	hwtstampTXOneStepSync      = 2
+	hwtstampFilterAll          = 1
+	hwtstampFilterPTPv2L4Event = 6
+	hwtstampFilterPTPv2Event   = 12
+)
+
 // ioctlPTPSysOffsetExtended is an IOCTL to get extended offset
 var ioctlPTPSysOffsetExtended = ioctl.IOWR(ptpClkMagic, 9, unsafe.Sizeof(PTPSysOffsetExtended{}))
 
@@ -53,6 +62,44 @@ type EthtoolTSinfo struct {
 	RXReserved     [3]uint32
 }
 
+// HasPHC returns whether the device is associated with a PHC
+func (i *EthtoolTSinfo) HasPHC() bool {
+	return i.PHCIndex >= 0
+}
+
+// HWTXTimestamping returns whether the device supports hardware TX timestamps
+func (i *EthtoolTSinfo) HWTXTimestamping() bool {
+	return i.SOtimestamping&unix.SOF_TIMESTAMPING_TX_HARDWARE != 0 && i.TXTypes&(1<<hwtstampTXOn) != 0
+}
+
+// HWRXTimestamping returns whether the device supports hardware RX timestamps of PTP packets
+func (i *EthtoolTSinfo) HWRXTimestamping() bool {
+	filters := uint32(1<<hwtstampFilterAll | 1<<hwtstampFilterPTPv2Event | 1<<hwtstampFilterPTPv2L4Event)
+	return i.SOtimestamping&unix.SOF_TIMESTAMPING_RX_HARDWARE != 0 && i.RXFilters&filters != 0
+}
+
+// OneStepTX returns whether the device can insert TX timestamps into Sync packets on the fly
+func (i *EthtoolTSinfo) OneStepTX() bool {
+	return i.TXTypes&(1<<hwtstampTXOneStepSync) != 0
+}
+
+// CheckHWTimestamping returns an error explaining what is missing if the device can't do hardware timestamping of PTP packets
+func (i *EthtoolTSinfo) CheckHWTimestamping() error {
+	if i.SOtimestamping&unix.SOF_TIMESTAMPING_RAW_HARDWARE == 0 {
+		return fmt.Errorf("no raw hardware timestamps support")
+	}
+	if !i.HWTXTimestamping() {
+		return fmt.Errorf("no hardware TX timestamps support")
+	}
+	if !i.HWRXTimestamping() {
+		return fmt.Errorf("no hardware RX timestamps support for PTP packets")
+	}
+	if !i.HasPHC() {
+		return fmt.Errorf("no PHC associated")
+	}
+	return nil
+}
+
 // PTPSysOffsetExtended as defined in linux/ptp_clock.h
 type PTPSysOffsetExtended struct {
 	NSamples uint32    /* Desired number of measurements. */
@@ -0,0 +1,75 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestEthtoolTSinfoCapabilities(t *testing.T) {
+	// what a typical PTP capable NIC reports
+	info := &EthtoolTSinfo{
+		SOtimestamping: unix.SOF_TIMESTAMPING_TX_HARDWARE |
+			unix.SOF_TIMESTAMPING_TX_SOFTWARE |
+			unix.SOF_TIMESTAMPING_RX_HARDWARE |
+			unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+			unix.SOF_TIMESTAMPING_SOFTWARE |
+			unix.SOF_TIMESTAMPING_RAW_HARDWARE,
+		PHCIndex:  0,
+		TXTypes:   1<<0 | 1<<1,
+		RXFilters: 1<<0 | 1<<1,
+	}
+	require.True(t, info.HasPHC())
+	require.True(t, info.HWTXTimestamping())
+	require.True(t, info.HWRXTimestamping())
+	require.False(t, info.OneStepTX())
+	require.NoError(t, info.CheckHWTimestamping())
+
+	info.TXTypes |= 1 << 2
+	require.True(t, info.OneStepTX())
+
+	info.PHCIndex = -1
+	require.False(t, info.HasPHC())
+	require.Error(t, info.CheckHWTimestamping())
+}
+
+func TestEthtoolTSinfoSoftwareOnly(t *testing.T) {
+	// what loopback reports
+	info := &EthtoolTSinfo{
+		SOtimestamping: unix.SOF_TIMESTAMPING_TX_SOFTWARE |
+			unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+			unix.SOF_TIMESTAMPING_SOFTWARE,
+		PHCIndex: -1,
+	}
+	require.False(t, info.HasPHC())
+	require.False(t, info.HWTXTimestamping())
+	require.False(t, info.HWRXTimestamping())
+	require.False(t, info.OneStepTX())
+	require.EqualError(t, info.CheckHWTimestamping(), "no raw hardware timestamps support")
+}
+
+func TestIfaceInfoLoopback(t *testing.T) {
+	info, err := IfaceInfo("lo")
+	if err != nil {
+		t.Skipf("ethtool ioctl is not available: %v", err)
+	}
+	require.False(t, info.HWTXTimestamping())
+	require.Error(t, info.CheckHWTimestamping())
+}
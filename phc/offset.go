@@ -76,6 +76,25 @@ func sysoffEstimateExtended(extended *PTPSysOffsetExtended) SysoffResult {
 	}
 }
 
+// offsetBetween returns offset of PHC from reference PHC, both measured against sys clock
+func offsetBetween(device, reference SysoffResult) time.Duration {
+	return reference.Offset - device.Offset
+}
+
+// OffsetFromDevices returns offset of PHC device from reference PHC device, positive when it's ahead.
+// Both are compared to sys clock one right after another, like phc2sys does for PHCs without cross timestamping
+func OffsetFromDevices(device, reference string) (time.Duration, error) {
+	ref, err := TimeAndOffsetFromDevice(reference, MethodIoctlSysOffsetExtended)
+	if err != nil {
+		return 0, err
+	}
+	dev, err := TimeAndOffsetFromDevice(device, MethodIoctlSysOffsetExtended)
+	if err != nil {
+		return 0, err
+	}
+	return offsetBetween(dev, ref), nil
+}
+
 // TimeAndOffset returns time we got from network card + offset
 func TimeAndOffset(iface string, method TimeMethod) (SysoffResult, error) {
 	info, err := IfaceInfo(iface)
@@ -0,0 +1,33 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetBetween(t *testing.T) {
+	sys := time.Unix(1653574589, 0)
+	// reference PHC is 37s behind sys clock, the other one is 2us ahead of it
+	ref := SysoffResult{SysTime: sys, PHCTime: sys.Add(-37 * time.Second), Offset: 37 * time.Second}
+	dev := SysoffResult{SysTime: sys, PHCTime: sys.Add(-37*time.Second + 2*time.Microsecond), Offset: 37*time.Second - 2*time.Microsecond}
+	require.Equal(t, 2*time.Microsecond, offsetBetween(dev, ref))
+	require.Equal(t, -2*time.Microsecond, offsetBetween(ref, dev))
+}
@@ -56,14 +56,10 @@ var SupportedMethods = []TimeMethod{MethodSyscallClockGettime, MethodIoctlSysOff
 
 // Time returns time we got from network card
 func Time(iface string, method TimeMethod) (time.Time, error) {
-	info, err := IfaceInfo(iface)
+	device, err := DeviceFromIface(iface)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("getting interface info: %w", err)
-	}
-	if info.PHCIndex < 0 {
-		return time.Time{}, fmt.Errorf("%s doesn't support PHC", iface)
+		return time.Time{}, err
 	}
-	device := fmt.Sprintf("/dev/ptp%d", info.PHCIndex)
 	switch method {
 	case MethodSyscallClockGettime:
 		return TimeFromDevice(device)
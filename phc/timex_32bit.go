@@ -0,0 +1,33 @@
+//go:build 386 || arm || mips || mipsle
+// +build 386 arm mips mipsle
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package phc
+
+import "golang.org/x/sys/unix"
+
+// setTimexTime sets time of the timex, fields are 32 bit on this arch
+func setTimexTime(tx *unix.Timex, sec, nsec int64) {
+	tx.Time.Sec = int32(sec)
+	tx.Time.Usec = int32(nsec)
+}
+
+// setTimexFreq sets frequency of the timex, field is 32 bit on this arch
+func setTimexFreq(tx *unix.Timex, freq int64) {
+	tx.Freq = int32(freq)
+}
@@ -0,0 +1,396 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmca
+
+import (
+	"fmt"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// Dataset is the data set comparison data as per IEEE 1588-2019 Table 30
+type Dataset struct {
+	GrandmasterPriority1    uint8
+	GrandmasterClockQuality ptp.ClockQuality
+	GrandmasterPriority2    uint8
+	GrandmasterIdentity     ptp.ClockIdentity
+	StepsRemoved            uint16
+	// SenderIdentity is the identity of the port which sent the Announce message
+	SenderIdentity ptp.PortIdentity
+	// ReceiverIdentity is the identity of the port which received the Announce message
+	ReceiverIdentity ptp.PortIdentity
+	// LocalPriority is only used by CompareAlternate. It's not carried by Announce message,
+	// but is assigned to the data set by the receiving port, as per ITU-T G.8275.1 6.3.2
+	LocalPriority uint8
+}
+
+// FromAnnounce builds Dataset from Announce message received by the port with receiver identity
+func FromAnnounce(a *ptp.Announce, receiver ptp.PortIdentity) *Dataset {
+	return &Dataset{
+		GrandmasterPriority1:    a.GrandmasterPriority1,
+		GrandmasterClockQuality: a.GrandmasterClockQuality,
+		GrandmasterPriority2:    a.GrandmasterPriority2,
+		GrandmasterIdentity:     a.GrandmasterIdentity,
+		StepsRemoved:            a.StepsRemoved,
+		SenderIdentity:          a.SourcePortIdentity,
+		ReceiverIdentity:        receiver,
+	}
+}
+
+// FromDefaults builds Dataset describing the local clock from its default data set, as per IEEE 1588-2019 9.3.4 NOTE 1
+func FromDefaults(identity ptp.ClockIdentity, priority1 uint8, quality ptp.ClockQuality, priority2 uint8) *Dataset {
+	port := ptp.PortIdentity{ClockIdentity: identity}
+	return &Dataset{
+		GrandmasterPriority1:    priority1,
+		GrandmasterClockQuality: quality,
+		GrandmasterPriority2:    priority2,
+		GrandmasterIdentity:     identity,
+		StepsRemoved:            0,
+		SenderIdentity:          port,
+		ReceiverIdentity:        port,
+	}
+}
+
+// Result is the result of data set comparison as per IEEE 1588-2019 Figure 34
+type Result int8
+
+// Results of data set comparison
+const (
+	// ABetter means A is better than B
+	ABetter Result = iota
+	// ABetterByTopology means A is better than B by topology, both have the same grandmaster
+	ABetterByTopology
+	// BBetter means B is better than A
+	BBetter
+	// BBetterByTopology means B is better than A by topology, both have the same grandmaster
+	BBetterByTopology
+	// Error1 means A and B are received on the same port from the same clock, or the message was sent by the receiver itself
+	Error1
+	// Error2 means A and B are the same message
+	Error2
+)
+
+// ResultToString is a map from Result to string
+var ResultToString = map[Result]string{
+	ABetter:           "A_BETTER",
+	ABetterByTopology: "A_BETTER_BY_TOPOLOGY",
+	BBetter:           "B_BETTER",
+	BBetterByTopology: "B_BETTER_BY_TOPOLOGY",
+	Error1:            "ERROR_1",
+	Error2:            "ERROR_2",
+}
+
+func (r Result) String() string {
+	if s, ok := ResultToString[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("UNKNOWN_RESULT=%d", int8(r))
+}
+
+// ABetterOrEqual returns true if A is not worse than B
+func (r Result) ABetterOrEqual() bool {
+	return r != BBetter && r != BBetterByTopology
+}
+
+// Reason is the attribute which decided the data set comparison
+type Reason uint8
+
+// Reasons of data set comparison result, in order they are compared
+const (
+	ReasonPriority1 Reason = iota
+	ReasonClockClass
+	ReasonClockAccuracy
+	ReasonOffsetScaledLogVariance
+	ReasonPriority2
+	ReasonLocalPriority
+	ReasonGrandmasterIdentity
+	ReasonStepsRemoved
+	ReasonSenderIdentity
+	ReasonReceiverIdentity
+	ReasonReceiverPortNumber
+	ReasonEqual
+)
+
+// ReasonToString is a map from Reason to string
+var ReasonToString = map[Reason]string{
+	ReasonPriority1:               "priority1",
+	ReasonClockClass:              "clockClass",
+	ReasonClockAccuracy:           "clockAccuracy",
+	ReasonOffsetScaledLogVariance: "offsetScaledLogVariance",
+	ReasonPriority2:               "priority2",
+	ReasonLocalPriority:           "localPriority",
+	ReasonGrandmasterIdentity:     "grandmasterIdentity",
+	ReasonStepsRemoved:            "stepsRemoved",
+	ReasonSenderIdentity:          "senderIdentity",
+	ReasonReceiverIdentity:        "receiverIdentity",
+	ReasonReceiverPortNumber:      "receiverPortNumber",
+	ReasonEqual:                   "equal",
+}
+
+func (r Reason) String() string {
+	if s, ok := ReasonToString[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("UNKNOWN_REASON=%d", uint8(r))
+}
+
+// lower values are better for every attribute
+func better(a, b uint64) (Result, bool) {
+	if a < b {
+		return ABetter, true
+	}
+	if a > b {
+		return BBetter, true
+	}
+	return Error2, false
+}
+
+// Compare compares data sets A and B as per IEEE 1588-2019 9.3.4 and returns the result and the attribute which decided it
+func Compare(a, b *Dataset) (Result, Reason) {
+	if a.GrandmasterIdentity != b.GrandmasterIdentity {
+		return compareGrandmasters(a, b)
+	}
+	return compareTopology(a, b)
+}
+
+// compareGrandmasters is the part 1 of data set comparison algorithm, Figure 34
+func compareGrandmasters(a, b *Dataset) (Result, Reason) {
+	aq, bq := a.GrandmasterClockQuality, b.GrandmasterClockQuality
+	attrs := []struct {
+		a, b   uint64
+		reason Reason
+	}{
+		{uint64(a.GrandmasterPriority1), uint64(b.GrandmasterPriority1), ReasonPriority1},
+		{uint64(aq.ClockClass), uint64(bq.ClockClass), ReasonClockClass},
+		{uint64(aq.ClockAccuracy), uint64(bq.ClockAccuracy), ReasonClockAccuracy},
+		{uint64(aq.OffsetScaledLogVariance), uint64(bq.OffsetScaledLogVariance), ReasonOffsetScaledLogVariance},
+		{uint64(a.GrandmasterPriority2), uint64(b.GrandmasterPriority2), ReasonPriority2},
+	}
+	for _, attr := range attrs {
+		if r, ok := better(attr.a, attr.b); ok {
+			return r, attr.reason
+		}
+	}
+	r, _ := better(uint64(a.GrandmasterIdentity), uint64(b.GrandmasterIdentity))
+	return r, ReasonGrandmasterIdentity
+}
+
+// CompareFunc is the data set comparison algorithm, like Compare or CompareAlternate
+type CompareFunc func(a, b *Dataset) (Result, Reason)
+
+// CompareAlternate compares data sets A and B using alternate BMCA of telecom profiles, ITU-T G.8275.1 6.3.1 and G.8275.2 6.7.1.
+// priority1 is not used, localPriority is compared right after priority2,
+// and grandmaster identity is only compared if the grandmaster is not grandmaster-capable (clockClass above 127).
+func CompareAlternate(a, b *Dataset) (Result, Reason) {
+	aq, bq := a.GrandmasterClockQuality, b.GrandmasterClockQuality
+	attrs := []struct {
+		a, b   uint64
+		reason Reason
+	}{
+		{uint64(aq.ClockClass), uint64(bq.ClockClass), ReasonClockClass},
+		{uint64(aq.ClockAccuracy), uint64(bq.ClockAccuracy), ReasonClockAccuracy},
+		{uint64(aq.OffsetScaledLogVariance), uint64(bq.OffsetScaledLogVariance), ReasonOffsetScaledLogVariance},
+		{uint64(a.GrandmasterPriority2), uint64(b.GrandmasterPriority2), ReasonPriority2},
+		{uint64(a.LocalPriority), uint64(b.LocalPriority), ReasonLocalPriority},
+	}
+	for _, attr := range attrs {
+		if r, ok := better(attr.a, attr.b); ok {
+			return r, attr.reason
+		}
+	}
+	if aq.ClockClass <= 127 || a.GrandmasterIdentity == b.GrandmasterIdentity {
+		return compareTopology(a, b)
+	}
+	r, _ := better(uint64(a.GrandmasterIdentity), uint64(b.GrandmasterIdentity))
+	return r, ReasonGrandmasterIdentity
+}
+
+// comparePortIdentity compares port identities, first by clock identity, then by port number
+func comparePortIdentity(a, b ptp.PortIdentity) int {
+	if a.ClockIdentity != b.ClockIdentity {
+		if a.ClockIdentity < b.ClockIdentity {
+			return -1
+		}
+		return 1
+	}
+	if a.PortNumber != b.PortNumber {
+		if a.PortNumber < b.PortNumber {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// compareTopology is the part 2 of data set comparison algorithm, Figure 35
+func compareTopology(a, b *Dataset) (Result, Reason) {
+	aSteps, bSteps := int(a.StepsRemoved), int(b.StepsRemoved)
+	switch {
+	case aSteps > bSteps+1:
+		return BBetter, ReasonStepsRemoved
+	case aSteps+1 < bSteps:
+		return ABetter, ReasonStepsRemoved
+	case aSteps > bSteps:
+		switch comparePortIdentity(a.ReceiverIdentity, a.SenderIdentity) {
+		case -1:
+			return BBetter, ReasonStepsRemoved
+		case 1:
+			return BBetterByTopology, ReasonStepsRemoved
+		}
+		return Error1, ReasonReceiverIdentity
+	case aSteps < bSteps:
+		switch comparePortIdentity(b.ReceiverIdentity, b.SenderIdentity) {
+		case -1:
+			return ABetter, ReasonStepsRemoved
+		case 1:
+			return ABetterByTopology, ReasonStepsRemoved
+		}
+		return Error1, ReasonReceiverIdentity
+	}
+	switch comparePortIdentity(a.SenderIdentity, b.SenderIdentity) {
+	case -1:
+		return ABetterByTopology, ReasonSenderIdentity
+	case 1:
+		return BBetterByTopology, ReasonSenderIdentity
+	}
+	switch {
+	case a.ReceiverIdentity.PortNumber < b.ReceiverIdentity.PortNumber:
+		return ABetterByTopology, ReasonReceiverPortNumber
+	case a.ReceiverIdentity.PortNumber > b.ReceiverIdentity.PortNumber:
+		return BBetterByTopology, ReasonReceiverPortNumber
+	}
+	return Error2, ReasonEqual
+}
+
+// Best returns the best data set among the local clock data set and data sets of candidates.
+// Local can be nil if the local clock can't become the grandmaster, for example if it's a client only.
+// Returned Reason is the attribute which made the best data set better than the next best one,
+// it's ReasonEqual if there is only one data set to choose from.
+func Best(local *Dataset, candidates []*Dataset) (*Dataset, Reason) {
+	return BestBy(Compare, local, candidates)
+}
+
+// BestBy is Best using provided data set comparison algorithm
+func BestBy(compare CompareFunc, local *Dataset, candidates []*Dataset) (*Dataset, Reason) {
+	all := candidates
+	if local != nil {
+		all = append([]*Dataset{local}, candidates...)
+	}
+	best := -1
+	for i, d := range all {
+		if best < 0 {
+			best = i
+			continue
+		}
+		if r, _ := compare(all[best], d); !r.ABetterOrEqual() {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, ReasonEqual
+	}
+	next := -1
+	for i, d := range all {
+		if i == best {
+			continue
+		}
+		if next < 0 {
+			next = i
+			continue
+		}
+		if r, _ := compare(all[next], d); !r.ABetterOrEqual() {
+			next = i
+		}
+	}
+	if next < 0 {
+		return all[best], ReasonEqual
+	}
+	_, reason := compare(all[best], all[next])
+	return all[best], reason
+}
+
+// Comparison is a detailed result of comparing two data sets, meant for logging and debugging of grandmaster selection
+type Comparison struct {
+	Result Result
+	Reason Reason
+	// AValue and BValue are human-readable values of the attribute which made the decision
+	AValue string
+	BValue string
+}
+
+// Winner returns which of the compared data sets won: "A", "B", or empty string if the comparison failed
+func (c *Comparison) Winner() string {
+	switch c.Result {
+	case ABetter, ABetterByTopology:
+		return "A"
+	case BBetter, BBetterByTopology:
+		return "B"
+	}
+	return ""
+}
+
+func (c *Comparison) String() string {
+	if c.Reason == ReasonEqual {
+		return fmt.Sprintf("%s: data sets are equal", c.Result)
+	}
+	return fmt.Sprintf("%s by %s (%s vs %s)", c.Result, c.Reason, c.AValue, c.BValue)
+}
+
+// attrValue returns human-readable value of data set attribute corresponding to the reason
+func attrValue(d *Dataset, reason Reason) string {
+	switch reason {
+	case ReasonPriority1:
+		return fmt.Sprintf("%d", d.GrandmasterPriority1)
+	case ReasonClockClass:
+		return fmt.Sprintf("%d", d.GrandmasterClockQuality.ClockClass)
+	case ReasonClockAccuracy:
+		return fmt.Sprintf("%#x", d.GrandmasterClockQuality.ClockAccuracy)
+	case ReasonOffsetScaledLogVariance:
+		return fmt.Sprintf("%#x", d.GrandmasterClockQuality.OffsetScaledLogVariance)
+	case ReasonPriority2:
+		return fmt.Sprintf("%d", d.GrandmasterPriority2)
+	case ReasonLocalPriority:
+		return fmt.Sprintf("%d", d.LocalPriority)
+	case ReasonGrandmasterIdentity:
+		return d.GrandmasterIdentity.String()
+	case ReasonStepsRemoved:
+		return fmt.Sprintf("%d", d.StepsRemoved)
+	case ReasonSenderIdentity:
+		return d.SenderIdentity.String()
+	case ReasonReceiverIdentity:
+		return d.ReceiverIdentity.String()
+	case ReasonReceiverPortNumber:
+		return fmt.Sprintf("%d", d.ReceiverIdentity.PortNumber)
+	}
+	return ""
+}
+
+// Explain compares two data sets using provided comparison algorithm and returns detailed result
+func Explain(compare CompareFunc, a, b *Dataset) *Comparison {
+	r, reason := compare(a, b)
+	return &Comparison{
+		Result: r,
+		Reason: reason,
+		AValue: attrValue(a, reason),
+		BValue: attrValue(b, reason),
+	}
+}
+
+// CompareAnnounce compares two Announce messages received on the receiver port as per data set comparison algorithm
+func CompareAnnounce(a, b *ptp.Announce, receiver ptp.PortIdentity) *Comparison {
+	return Explain(Compare, FromAnnounce(a, receiver), FromAnnounce(b, receiver))
+}
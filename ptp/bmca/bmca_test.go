@@ -0,0 +1,301 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bmca
+
+import (
+	"testing"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func dataset(gm ptp.ClockIdentity, clockClass uint8) *Dataset {
+	return &Dataset{
+		GrandmasterPriority1: 128,
+		GrandmasterClockQuality: ptp.ClockQuality{
+			ClockClass:              clockClass,
+			ClockAccuracy:           0x21,
+			OffsetScaledLogVariance: 0x4e5d,
+		},
+		GrandmasterPriority2: 128,
+		GrandmasterIdentity:  gm,
+		SenderIdentity:       ptp.PortIdentity{ClockIdentity: gm, PortNumber: 1},
+		ReceiverIdentity:     ptp.PortIdentity{ClockIdentity: 0xff, PortNumber: 1},
+	}
+}
+
+func TestFromAnnounce(t *testing.T) {
+	a := &ptp.Announce{
+		Header: ptp.Header{
+			SourcePortIdentity: ptp.PortIdentity{ClockIdentity: 42, PortNumber: 2},
+		},
+		AnnounceBody: ptp.AnnounceBody{
+			GrandmasterPriority1:    1,
+			GrandmasterClockQuality: ptp.ClockQuality{ClockClass: 6},
+			GrandmasterPriority2:    2,
+			GrandmasterIdentity:     43,
+			StepsRemoved:            3,
+		},
+	}
+	receiver := ptp.PortIdentity{ClockIdentity: 44, PortNumber: 1}
+	want := &Dataset{
+		GrandmasterPriority1:    1,
+		GrandmasterClockQuality: ptp.ClockQuality{ClockClass: 6},
+		GrandmasterPriority2:    2,
+		GrandmasterIdentity:     43,
+		StepsRemoved:            3,
+		SenderIdentity:          ptp.PortIdentity{ClockIdentity: 42, PortNumber: 2},
+		ReceiverIdentity:        receiver,
+	}
+	require.Equal(t, want, FromAnnounce(a, receiver))
+}
+
+func TestCompareGrandmasters(t *testing.T) {
+	a := dataset(1, 6)
+	b := dataset(2, 6)
+
+	r, reason := Compare(a, b)
+	require.Equal(t, ABetter, r)
+	require.Equal(t, ReasonGrandmasterIdentity, reason)
+
+	b.GrandmasterPriority2 = 127
+	r, reason = Compare(a, b)
+	require.Equal(t, BBetter, r)
+	require.Equal(t, ReasonPriority2, reason)
+
+	a.GrandmasterClockQuality.OffsetScaledLogVariance = 0x4000
+	r, reason = Compare(a, b)
+	require.Equal(t, ABetter, r)
+	require.Equal(t, ReasonOffsetScaledLogVariance, reason)
+
+	b.GrandmasterClockQuality.ClockAccuracy = 0x20
+	r, reason = Compare(a, b)
+	require.Equal(t, BBetter, r)
+	require.Equal(t, ReasonClockAccuracy, reason)
+
+	b.GrandmasterClockQuality.ClockClass = 7
+	r, reason = Compare(a, b)
+	require.Equal(t, ABetter, r)
+	require.Equal(t, ReasonClockClass, reason)
+
+	b.GrandmasterPriority1 = 1
+	r, reason = Compare(a, b)
+	require.Equal(t, BBetter, r)
+	require.Equal(t, ReasonPriority1, reason)
+}
+
+func TestCompareTopology(t *testing.T) {
+	a := dataset(1, 6)
+	b := dataset(1, 6)
+
+	r, reason := Compare(a, b)
+	require.Equal(t, Error2, r)
+	require.Equal(t, ReasonEqual, reason)
+
+	b.ReceiverIdentity.PortNumber = 2
+	r, reason = Compare(a, b)
+	require.Equal(t, ABetterByTopology, r)
+	require.Equal(t, ReasonReceiverPortNumber, reason)
+
+	a.SenderIdentity.PortNumber = 2
+	r, reason = Compare(a, b)
+	require.Equal(t, BBetterByTopology, r)
+	require.Equal(t, ReasonSenderIdentity, reason)
+
+	// receiver is bigger than sender
+	a.StepsRemoved = 1
+	r, reason = Compare(a, b)
+	require.Equal(t, BBetterByTopology, r)
+	require.Equal(t, ReasonStepsRemoved, reason)
+
+	r, reason = Compare(b, a)
+	require.Equal(t, ABetterByTopology, r)
+	require.Equal(t, ReasonStepsRemoved, reason)
+
+	// receiver is smaller than sender
+	a.SenderIdentity.ClockIdentity = 0xfff
+	r, reason = Compare(b, a)
+	require.Equal(t, ABetter, r)
+	require.Equal(t, ReasonStepsRemoved, reason)
+
+	// message sent by the receiver
+	a.SenderIdentity = a.ReceiverIdentity
+	r, reason = Compare(a, b)
+	require.Equal(t, Error1, r)
+	require.Equal(t, ReasonReceiverIdentity, reason)
+
+	a.StepsRemoved = 2
+	r, reason = Compare(a, b)
+	require.Equal(t, BBetter, r)
+	require.Equal(t, ReasonStepsRemoved, reason)
+	r, reason = Compare(b, a)
+	require.Equal(t, ABetter, r)
+	require.Equal(t, ReasonStepsRemoved, reason)
+}
+
+func TestBest(t *testing.T) {
+	best, reason := Best(nil, nil)
+	require.Nil(t, best)
+	require.Equal(t, ReasonEqual, reason)
+
+	local := FromDefaults(0xff, 128, ptp.ClockQuality{ClockClass: 248, ClockAccuracy: 0xfe, OffsetScaledLogVariance: 0xffff}, 128)
+	best, reason = Best(local, nil)
+	require.Equal(t, local, best)
+	require.Equal(t, ReasonEqual, reason)
+
+	a := dataset(1, 7)
+	b := dataset(2, 6)
+	c := dataset(3, 6)
+	best, reason = Best(local, []*Dataset{a, b, c})
+	require.Equal(t, b, best)
+	require.Equal(t, ReasonGrandmasterIdentity, reason)
+
+	c.GrandmasterPriority1 = 1
+	best, reason = Best(local, []*Dataset{a, b, c})
+	require.Equal(t, c, best)
+	require.Equal(t, ReasonPriority1, reason)
+
+	best, reason = Best(nil, []*Dataset{a})
+	require.Equal(t, a, best)
+	require.Equal(t, ReasonEqual, reason)
+}
+
+func TestCompareAlternate(t *testing.T) {
+	a := dataset(1, 6)
+	b := dataset(2, 6)
+	b.SenderIdentity.PortNumber = 2
+
+	// grandmaster-capable clocks are compared by topology, not identity
+	r, reason := CompareAlternate(a, b)
+	require.Equal(t, ABetterByTopology, r)
+	require.Equal(t, ReasonSenderIdentity, reason)
+
+	a.LocalPriority = 200
+	b.LocalPriority = 100
+	r, reason = CompareAlternate(a, b)
+	require.Equal(t, BBetter, r)
+	require.Equal(t, ReasonLocalPriority, reason)
+
+	// priority1 is ignored
+	b.GrandmasterPriority1 = 255
+	r, reason = CompareAlternate(a, b)
+	require.Equal(t, BBetter, r)
+	require.Equal(t, ReasonLocalPriority, reason)
+
+	a.GrandmasterPriority2 = 1
+	r, reason = CompareAlternate(a, b)
+	require.Equal(t, ABetter, r)
+	require.Equal(t, ReasonPriority2, reason)
+
+	b.GrandmasterClockQuality.ClockClass = 7
+	r, reason = CompareAlternate(a, b)
+	require.Equal(t, ABetter, r)
+	require.Equal(t, ReasonClockClass, reason)
+
+	// not grandmaster-capable clocks are compared by identity
+	a = dataset(2, 165)
+	b = dataset(1, 165)
+	r, reason = CompareAlternate(a, b)
+	require.Equal(t, BBetter, r)
+	require.Equal(t, ReasonGrandmasterIdentity, reason)
+}
+
+func TestBestBy(t *testing.T) {
+	a := dataset(1, 6)
+	b := dataset(2, 6)
+	b.GrandmasterPriority1 = 1
+	a.LocalPriority = 1
+	b.LocalPriority = 2
+
+	best, reason := BestBy(Compare, nil, []*Dataset{a, b})
+	require.Equal(t, b, best)
+	require.Equal(t, ReasonPriority1, reason)
+
+	best, reason = BestBy(CompareAlternate, nil, []*Dataset{a, b})
+	require.Equal(t, a, best)
+	require.Equal(t, ReasonLocalPriority, reason)
+}
+
+func TestResultString(t *testing.T) {
+	require.Equal(t, "A_BETTER_BY_TOPOLOGY", ABetterByTopology.String())
+	require.Equal(t, "UNKNOWN_RESULT=42", Result(42).String())
+	require.True(t, ABetterByTopology.ABetterOrEqual())
+	require.False(t, BBetterByTopology.ABetterOrEqual())
+}
+
+func TestReasonString(t *testing.T) {
+	require.Equal(t, "clockClass", ReasonClockClass.String())
+	require.Equal(t, "UNKNOWN_REASON=42", Reason(42).String())
+}
+
+func TestCompareAnnounce(t *testing.T) {
+	receiver := ptp.PortIdentity{ClockIdentity: 0xff, PortNumber: 1}
+	announce := func(gm ptp.ClockIdentity, clockClass uint8) *ptp.Announce {
+		return &ptp.Announce{
+			Header: ptp.Header{
+				SourcePortIdentity: ptp.PortIdentity{ClockIdentity: gm, PortNumber: 1},
+			},
+			AnnounceBody: ptp.AnnounceBody{
+				GrandmasterPriority1: 128,
+				GrandmasterClockQuality: ptp.ClockQuality{
+					ClockClass:              clockClass,
+					ClockAccuracy:           0x21,
+					OffsetScaledLogVariance: 0x4e5d,
+				},
+				GrandmasterPriority2: 128,
+				GrandmasterIdentity:  gm,
+			},
+		}
+	}
+	a, b := announce(1, 6), announce(2, 7)
+	c := CompareAnnounce(a, b, receiver)
+	require.Equal(t, ABetter, c.Result)
+	require.Equal(t, ReasonClockClass, c.Reason)
+	require.Equal(t, "A", c.Winner())
+	require.Equal(t, "A_BETTER by clockClass (6 vs 7)", c.String())
+
+	b.GrandmasterClockQuality.ClockClass = 6
+	b.GrandmasterPriority1 = 127
+	c = CompareAnnounce(a, b, receiver)
+	require.Equal(t, "B", c.Winner())
+	require.Equal(t, "B_BETTER by priority1 (128 vs 127)", c.String())
+
+	b.GrandmasterPriority1 = 128
+	c = CompareAnnounce(a, b, receiver)
+	require.Equal(t, ReasonGrandmasterIdentity, c.Reason)
+	require.Equal(t, "000000.0000.000001", c.AValue)
+	require.Equal(t, "000000.0000.000002", c.BValue)
+
+	// same grandmaster via different paths
+	b = announce(1, 6)
+	b.StepsRemoved = 2
+	c = CompareAnnounce(a, b, receiver)
+	require.Equal(t, ABetter, c.Result)
+	require.Equal(t, "A_BETTER by stepsRemoved (0 vs 2)", c.String())
+
+	c = CompareAnnounce(a, a, receiver)
+	require.Equal(t, "", c.Winner())
+	require.Equal(t, "ERROR_2: data sets are equal", c.String())
+}
+
+func TestExplain(t *testing.T) {
+	a, b := dataset(1, 6), dataset(2, 6)
+	a.LocalPriority = 10
+	b.LocalPriority = 20
+	c := Explain(CompareAlternate, a, b)
+	require.Equal(t, "A_BETTER by localPriority (10 vs 20)", c.String())
+}
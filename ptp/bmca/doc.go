@@ -0,0 +1,24 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package bmca implements data set comparison algorithm of Best Master Clock Algorithm (BMCA)
+as per IEEE 1588-2019 9.3.4.
+
+It's meant to be used both by clients choosing the grandmaster among the servers they receive Announce messages from,
+and by anything acting as a boundary clock.
+*/
+package bmca
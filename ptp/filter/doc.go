@@ -0,0 +1,29 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package filter implements filtering of offset samples measured by PTP clients before they are passed to the servo.
+
+Pipeline consists of stages applied in order, each of them may pass the sample further, replace it or drop it:
+  - median:N passes median offset of the last N samples, smoothing the noise
+  - mad:K[:N] drops samples further than K median absolute deviations from the median of the last N samples
+  - lucky:N passes the sample with the lowest path delay out of every N, the one which was least queued on the way
+
+Median and MAD stages are Stateful: their recent samples can be saved and restored, so a restarted client doesn't start over.
+
+Stages don't spawn goroutines and are not safe for concurrent use.
+*/
+package filter
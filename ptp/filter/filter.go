@@ -0,0 +1,349 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMADWindow is the number of samples MAD outlier rejection looks at if not specified
+const defaultMADWindow = 16
+
+// madScale makes median absolute deviation a consistent estimator of standard deviation for normal distribution
+const madScale = 1.4826
+
+// Sample is a single measurement against the grandmaster
+type Sample struct {
+	Offset time.Duration
+	Delay  time.Duration
+}
+
+// Filter is a stage of the pipeline
+type Filter interface {
+	// Sample takes the sample and returns the one to pass further, false if there is none
+	Sample(s Sample) (Sample, bool)
+	// Reset forgets all samples, for example after the clock was stepped
+	Reset()
+	// Decimation is how many samples are taken for one passed at most
+	Decimation() int
+}
+
+// Stateful is a filter which can save its recent samples and continue from them, for example across restarts
+type Stateful interface {
+	Filter
+	// Samples returns the samples the filter keeps
+	Samples() []Sample
+	// Restore makes the filter continue from saved samples
+	Restore(samples []Sample)
+}
+
+// Pipeline applies filters in order
+type Pipeline []Filter
+
+// Sample runs the sample through all filters of the pipeline
+func (p Pipeline) Sample(s Sample) (Sample, bool) {
+	for _, f := range p {
+		var ok bool
+		if s, ok = f.Sample(s); !ok {
+			return Sample{}, false
+		}
+	}
+	return s, true
+}
+
+// Reset resets all filters of the pipeline
+func (p Pipeline) Reset() {
+	for _, f := range p {
+		f.Reset()
+	}
+}
+
+// Samples returns samples kept by every filter of the pipeline, nil for ones which keep none
+func (p Pipeline) Samples() [][]Sample {
+	res := make([][]Sample, len(p))
+	for i, f := range p {
+		if s, ok := f.(Stateful); ok {
+			res[i] = s.Samples()
+		}
+	}
+	return res
+}
+
+// Restore makes filters of the pipeline continue from the samples saved by the same pipeline
+func (p Pipeline) Restore(saved [][]Sample) {
+	if len(saved) != len(p) {
+		return
+	}
+	for i, f := range p {
+		if s, ok := f.(Stateful); ok {
+			s.Restore(saved[i])
+		}
+	}
+}
+
+// Decimation is how many samples the pipeline takes for one passed
+func (p Pipeline) Decimation() int {
+	d := 1
+	for _, f := range p {
+		d *= f.Decimation()
+	}
+	return d
+}
+
+// window is the ring of the last samples
+type window struct {
+	size    int
+	samples []Sample
+}
+
+func (w *window) add(s Sample) {
+	w.samples = append(w.samples, s)
+	if len(w.samples) > w.size {
+		w.samples = w.samples[1:]
+	}
+}
+
+func (w *window) reset() {
+	w.samples = nil
+}
+
+func (w *window) restore(samples []Sample) {
+	if len(samples) > w.size {
+		samples = samples[len(samples)-w.size:]
+	}
+	w.samples = append([]Sample(nil), samples...)
+}
+
+// medianOf returns median of the durations
+func medianOf(d []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), d...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+// Median passes the sample with median offset out of the last ones, the lower one for even number of them
+type Median struct {
+	w window
+}
+
+// NewMedian returns median filter of n samples
+func NewMedian(n int) *Median {
+	return &Median{w: window{size: n}}
+}
+
+// Sample implements Filter
+func (m *Median) Sample(s Sample) (Sample, bool) {
+	m.w.add(s)
+	sorted := append([]Sample(nil), m.w.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+	return sorted[(len(sorted)-1)/2], true
+}
+
+// Reset implements Filter
+func (m *Median) Reset() {
+	m.w.reset()
+}
+
+// Samples implements Stateful
+func (m *Median) Samples() []Sample {
+	return append([]Sample(nil), m.w.samples...)
+}
+
+// Restore implements Stateful
+func (m *Median) Restore(samples []Sample) {
+	m.w.restore(samples)
+}
+
+// Decimation implements Filter
+func (m *Median) Decimation() int {
+	return 1
+}
+
+// MAD drops samples too far from the median of the last samples, in units of median absolute deviation
+type MAD struct {
+	k float64
+	w window
+}
+
+// NewMAD returns outlier rejection with threshold k over n samples
+func NewMAD(k float64, n int) *MAD {
+	return &MAD{k: k, w: window{size: n}}
+}
+
+// Sample implements Filter
+func (m *MAD) Sample(s Sample) (Sample, bool) {
+	// not enough samples to tell outliers yet
+	if len(m.w.samples) < 3 {
+		m.w.add(s)
+		return s, true
+	}
+	offsets := make([]time.Duration, len(m.w.samples))
+	for i, w := range m.w.samples {
+		offsets[i] = w.Offset
+	}
+	median := medianOf(offsets)
+	for i, o := range offsets {
+		offsets[i] = abs(o - median)
+	}
+	// samples with no spread at all can't tell outliers
+	mad := float64(medianOf(offsets)) * madScale
+	if mad > 0 && float64(abs(s.Offset-median)) > m.k*mad {
+		// outliers are not added so they don't shift the median, but the window shrinks
+		// so it moves on once the offset changes for real
+		m.w.samples = m.w.samples[1:]
+		return Sample{}, false
+	}
+	m.w.add(s)
+	return s, true
+}
+
+// Reset implements Filter
+func (m *MAD) Reset() {
+	m.w.reset()
+}
+
+// Samples implements Stateful
+func (m *MAD) Samples() []Sample {
+	return append([]Sample(nil), m.w.samples...)
+}
+
+// Restore implements Stateful
+func (m *MAD) Restore(samples []Sample) {
+	m.w.restore(samples)
+}
+
+// Decimation implements Filter
+func (m *MAD) Decimation() int {
+	return 1
+}
+
+// Lucky passes the sample with the lowest delay out of every n
+type Lucky struct {
+	n     int
+	count int
+	best  Sample
+}
+
+// NewLucky returns lucky packet filter over n samples
+func NewLucky(n int) *Lucky {
+	return &Lucky{n: n}
+}
+
+// Sample implements Filter
+func (l *Lucky) Sample(s Sample) (Sample, bool) {
+	if l.count == 0 || s.Delay < l.best.Delay {
+		l.best = s
+	}
+	l.count++
+	if l.count < l.n {
+		return Sample{}, false
+	}
+	l.count = 0
+	return l.best, true
+}
+
+// Reset implements Filter
+func (l *Lucky) Reset() {
+	l.count = 0
+}
+
+// Decimation implements Filter
+func (l *Lucky) Decimation() int {
+	return l.n
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// positive parses positive integer argument of the stage
+func positive(name, arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s needs positive number of samples, got %q", name, arg)
+	}
+	return n, nil
+}
+
+// parseStage parses single stage as name:arg[:arg]
+func parseStage(spec string) (Filter, error) {
+	args := strings.Split(spec, ":")
+	name := args[0]
+	switch name {
+	case "median":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("median needs number of samples, got %q", spec)
+		}
+		n, err := positive(name, args[1])
+		if err != nil {
+			return nil, err
+		}
+		return NewMedian(n), nil
+	case "mad":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("mad needs threshold and optional number of samples, got %q", spec)
+		}
+		k, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || k <= 0 {
+			return nil, fmt.Errorf("mad needs positive threshold, got %q", args[1])
+		}
+		n := defaultMADWindow
+		if len(args) == 3 {
+			if n, err = positive(name, args[2]); err != nil {
+				return nil, err
+			}
+		}
+		return NewMAD(k, n), nil
+	case "lucky":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("lucky needs number of samples, got %q", spec)
+		}
+		n, err := positive(name, args[1])
+		if err != nil {
+			return nil, err
+		}
+		return NewLucky(n), nil
+	}
+	return nil, fmt.Errorf("unknown filter %q, must be one of median, mad, lucky", name)
+}
+
+// Parse builds the pipeline from comma separated stages, for example "mad:3,lucky:4". Empty pipeline passes everything
+func Parse(spec string) (Pipeline, error) {
+	p := Pipeline{}
+	if spec == "" {
+		return p, nil
+	}
+	for _, stage := range strings.Split(spec, ",") {
+		f, err := parseStage(strings.TrimSpace(stage))
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, f)
+	}
+	return p, nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func offsets(f Filter, offsets ...time.Duration) []time.Duration {
+	passed := []time.Duration{}
+	for _, o := range offsets {
+		if s, ok := f.Sample(Sample{Offset: o}); ok {
+			passed = append(passed, s.Offset)
+		}
+	}
+	return passed
+}
+
+func TestMedian(t *testing.T) {
+	m := NewMedian(3)
+	require.Equal(t, []time.Duration{10, 10, 20, 30, 30}, offsets(m, 10, 20, 30, 1000, 25))
+	require.Equal(t, 1, m.Decimation())
+	m.Reset()
+	require.Equal(t, []time.Duration{-5}, offsets(m, -5))
+}
+
+func TestMAD(t *testing.T) {
+	m := NewMAD(3, 5)
+	require.Equal(t, []time.Duration{10, 12, 11, 9, 10}, offsets(m, 10, 12, 11, 1000, 9, -1000, 10))
+	require.Equal(t, 1, m.Decimation())
+
+	// offset changing for real is accepted after a while
+	m.Reset()
+	passed := offsets(m, 10, 12, 11, 10, 11, 500, 501, 502, 500, 501, 502)
+	require.Equal(t, []time.Duration{10, 12, 11, 10, 11}, passed[:5])
+	require.Equal(t, time.Duration(502), passed[len(passed)-1])
+
+	// nothing is an outlier without spread
+	m.Reset()
+	require.Equal(t, []time.Duration{10, 10, 10, 10, 11}, offsets(m, 10, 10, 10, 10, 11))
+}
+
+func TestLucky(t *testing.T) {
+	l := NewLucky(3)
+	require.Equal(t, 3, l.Decimation())
+	samples := []Sample{
+		{Offset: 1, Delay: 30},
+		{Offset: 2, Delay: 10},
+		{Offset: 3, Delay: 20},
+		{Offset: 4, Delay: 50},
+		{Offset: 5, Delay: 40},
+		{Offset: 6, Delay: 60},
+	}
+	passed := []Sample{}
+	for _, s := range samples {
+		if s, ok := l.Sample(s); ok {
+			passed = append(passed, s)
+		}
+	}
+	require.Equal(t, []Sample{samples[1], samples[4]}, passed)
+
+	_, ok := l.Sample(samples[0])
+	require.False(t, ok)
+	l.Reset()
+	_, ok = l.Sample(samples[0])
+	require.False(t, ok)
+	_, ok = l.Sample(samples[0])
+	require.False(t, ok)
+	s, ok := l.Sample(samples[3])
+	require.True(t, ok)
+	require.Equal(t, samples[0], s)
+}
+
+func TestPipeline(t *testing.T) {
+	p, err := Parse("")
+	require.NoError(t, err)
+	s, ok := p.Sample(Sample{Offset: 42})
+	require.True(t, ok)
+	require.Equal(t, time.Duration(42), s.Offset)
+	require.Equal(t, 1, p.Decimation())
+
+	p, err = Parse("mad:3:5, lucky:2")
+	require.NoError(t, err)
+	require.Len(t, p, 2)
+	require.Equal(t, 2, p.Decimation())
+	require.Equal(t, []time.Duration{10, 11}, offsets(p, 10, 12, 11, 1000, 9, 10))
+	p.Reset()
+	require.Equal(t, []time.Duration{100}, offsets(p, 100, 200))
+}
+
+func TestPipelineRestore(t *testing.T) {
+	p, err := Parse("median:3,lucky:2")
+	require.NoError(t, err)
+	offsets(p, 10, 10, 20, 20, 30, 30, 40, 40)
+	saved := p.Samples()
+	require.Len(t, saved, 2)
+	require.Len(t, saved[0], 3)
+	require.Nil(t, saved[1])
+
+	// restored median continues from the last samples
+	restored, err := Parse("median:3,lucky:2")
+	require.NoError(t, err)
+	restored.Restore(saved)
+	require.Equal(t, offsets(p, 0, 0), offsets(restored, 0, 0))
+
+	// window is cut to the size of the filter, samples of other pipelines are ignored
+	m := NewMedian(2)
+	m.Restore(saved[0])
+	require.Len(t, m.Samples(), 2)
+	other := Pipeline{NewMedian(3)}
+	other.Restore(saved)
+	require.Empty(t, other.Samples()[0])
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse("median:5,mad:2.5,lucky:4")
+	require.NoError(t, err)
+	require.Equal(t, Pipeline{NewMedian(5), NewMAD(2.5, defaultMADWindow), NewLucky(4)}, p)
+
+	for _, spec := range []string{"median", "median:0", "median:1:2", "mad", "mad:-1", "mad:3:x", "lucky:", "kalman:1", "median:3,"} {
+		_, err := Parse(spec)
+		require.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,26 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package negotiation implements unicast message transmission negotiation as per IEEE 1588-2019 16.1.
+
+Requester is meant to be embedded by clients, it tracks which message types are requested, granted or denied,
+and tells when requests have to be (re)sent, backing off exponentially while the granter doesn't answer.
+Granter is meant to be embedded by servers, it decides whether to grant the requests and tracks grants until they expire.
+
+Neither of them spawns goroutines or timers, current time is passed explicitly, and both are not safe for concurrent use.
+*/
+package negotiation
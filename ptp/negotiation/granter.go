@@ -0,0 +1,119 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package negotiation
+
+import (
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// GranterConfig specifies which requests Granter grants
+type GranterConfig struct {
+	// MinInterval is the minimum interval of the messages, requests for more frequent messages are denied
+	MinInterval time.Duration
+	// MaxDuration is the maximum grant duration, longer requests are denied
+	MaxDuration time.Duration
+}
+
+// Grant is a unicast transmission granted to the requester
+type Grant struct {
+	Requester   ptp.PortIdentity
+	MessageType ptp.MessageType
+	Interval    ptp.LogInterval
+	Duration    time.Duration
+	Expires     time.Time
+}
+
+type grantKey struct {
+	requester ptp.PortIdentity
+	msgType   ptp.MessageType
+}
+
+// Granter tracks unicast transmissions granted by a server
+type Granter struct {
+	cfg    GranterConfig
+	grants map[grantKey]*Grant
+}
+
+// NewGranter returns new Granter
+func NewGranter(cfg GranterConfig) *Granter {
+	return &Granter{
+		cfg:    cfg,
+		grants: map[grantKey]*Grant{},
+	}
+}
+
+// HandleRequest processes REQUEST_UNICAST_TRANSMISSION TLV received from the requester and returns the grant TLV to send back.
+// Denied requests are answered with zero duration, and existing grant for the same message type is removed.
+func (g *Granter) HandleRequest(requester ptp.PortIdentity, tlv *ptp.RequestUnicastTransmissionTLV, now time.Time) *ptp.GrantUnicastTransmissionTLV {
+	msgType := tlv.MsgTypeAndReserved.MsgType()
+	key := grantKey{requester: requester, msgType: msgType}
+	duration := time.Duration(tlv.DurationField) * time.Second
+	if tlv.LogInterMessagePeriod.Duration() < g.cfg.MinInterval || duration > g.cfg.MaxDuration || duration == 0 {
+		delete(g.grants, key)
+		return grantTLV(msgType, tlv.LogInterMessagePeriod, 0)
+	}
+	g.grants[key] = &Grant{
+		Requester:   requester,
+		MessageType: msgType,
+		Interval:    tlv.LogInterMessagePeriod,
+		Duration:    duration,
+		Expires:     now.Add(duration),
+	}
+	return grantTLV(msgType, tlv.LogInterMessagePeriod, duration)
+}
+
+// HandleCancel processes CANCEL_UNICAST_TRANSMISSION TLV received from the requester and returns acknowledgement to send back
+func (g *Granter) HandleCancel(requester ptp.PortIdentity, tlv *ptp.CancelUnicastTransmissionTLV) *ptp.AcknowledgeCancelUnicastTransmissionTLV {
+	msgType := tlv.MsgTypeAndFlags.MsgType()
+	delete(g.grants, grantKey{requester: requester, msgType: msgType})
+	return ackCancelTLV(msgType)
+}
+
+// Cancel removes the grant and returns CANCEL_UNICAST_TRANSMISSION TLV to send to the requester
+func (g *Granter) Cancel(requester ptp.PortIdentity, msgType ptp.MessageType) *ptp.CancelUnicastTransmissionTLV {
+	delete(g.grants, grantKey{requester: requester, msgType: msgType})
+	return cancelTLV(msgType)
+}
+
+// Grant returns active grant of message type for the requester
+func (g *Granter) Grant(requester ptp.PortIdentity, msgType ptp.MessageType, now time.Time) (Grant, bool) {
+	gr, ok := g.grants[grantKey{requester: requester, msgType: msgType}]
+	if !ok || !now.Before(gr.Expires) {
+		return Grant{}, false
+	}
+	return *gr, true
+}
+
+// Expire removes and returns grants which expired by now
+func (g *Granter) Expire(now time.Time) []Grant {
+	var res []Grant
+	for key, gr := range g.grants {
+		if now.Before(gr.Expires) {
+			continue
+		}
+		res = append(res, *gr)
+		delete(g.grants, key)
+	}
+	return res
+}
+
+// Len returns number of grants, including expired but not yet removed ones
+func (g *Granter) Len() int {
+	return len(g.grants)
+}
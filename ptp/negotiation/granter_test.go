@@ -0,0 +1,121 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package negotiation
+
+import (
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+var testGranterConfig = GranterConfig{
+	MinInterval: time.Second,
+	MaxDuration: time.Hour,
+}
+
+func TestGranterHandleRequest(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	client := ptp.PortIdentity{ClockIdentity: 42, PortNumber: 1}
+	g := NewGranter(testGranterConfig)
+
+	grant := g.HandleRequest(client, requestTLV(ptp.MessageSync, 0, time.Minute), now)
+	require.Equal(t, ptp.TLVGrantUnicastTransmission, grant.TLVType)
+	require.Equal(t, uint16(8), grant.LengthField)
+	require.Equal(t, ptp.MessageSync, grant.MsgTypeAndReserved.MsgType())
+	require.Equal(t, uint32(60), grant.DurationField)
+	require.Equal(t, uint8(1), grant.Renewal)
+
+	gr, ok := g.Grant(client, ptp.MessageSync, now)
+	require.True(t, ok)
+	require.Equal(t, Grant{Requester: client, MessageType: ptp.MessageSync, Duration: time.Minute, Expires: now.Add(time.Minute)}, gr)
+	_, ok = g.Grant(client, ptp.MessageSync, now.Add(time.Minute))
+	require.False(t, ok)
+	_, ok = g.Grant(client, ptp.MessageAnnounce, now)
+	require.False(t, ok)
+
+	// too frequent
+	grant = g.HandleRequest(client, requestTLV(ptp.MessageSync, -1, time.Minute), now)
+	require.Equal(t, uint32(0), grant.DurationField)
+	_, ok = g.Grant(client, ptp.MessageSync, now)
+	require.False(t, ok)
+
+	// too long
+	grant = g.HandleRequest(client, requestTLV(ptp.MessageAnnounce, 1, 2*time.Hour), now)
+	require.Equal(t, uint32(0), grant.DurationField)
+	require.Equal(t, 0, g.Len())
+}
+
+func TestGranterCancel(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	client := ptp.PortIdentity{ClockIdentity: 42, PortNumber: 1}
+	g := NewGranter(testGranterConfig)
+
+	g.HandleRequest(client, requestTLV(ptp.MessageSync, 0, time.Minute), now)
+	g.HandleRequest(client, requestTLV(ptp.MessageAnnounce, 0, time.Minute), now)
+	require.Equal(t, 2, g.Len())
+
+	ack := g.HandleCancel(client, cancelTLV(ptp.MessageSync))
+	require.Equal(t, ptp.TLVAcknowledgeCancelUnicastTransmission, ack.TLVType)
+	require.Equal(t, ptp.MessageSync, ack.MsgTypeAndFlags.MsgType())
+	require.Equal(t, 1, g.Len())
+
+	cancel := g.Cancel(client, ptp.MessageAnnounce)
+	require.Equal(t, ptp.TLVCancelUnicastTransmission, cancel.TLVType)
+	require.Equal(t, ptp.MessageAnnounce, cancel.MsgTypeAndFlags.MsgType())
+	require.Equal(t, 0, g.Len())
+}
+
+func TestGranterExpire(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	client := ptp.PortIdentity{ClockIdentity: 42, PortNumber: 1}
+	g := NewGranter(testGranterConfig)
+
+	g.HandleRequest(client, requestTLV(ptp.MessageSync, 0, time.Minute), now)
+	g.HandleRequest(client, requestTLV(ptp.MessageAnnounce, 0, 2*time.Minute), now)
+
+	require.Empty(t, g.Expire(now))
+	expired := g.Expire(now.Add(time.Minute))
+	require.Len(t, expired, 1)
+	require.Equal(t, ptp.MessageSync, expired[0].MessageType)
+	require.Equal(t, 1, g.Len())
+
+	// renewal extends the grant
+	g.HandleRequest(client, requestTLV(ptp.MessageAnnounce, 0, 2*time.Minute), now.Add(time.Minute))
+	require.Empty(t, g.Expire(now.Add(2*time.Minute)))
+	require.Len(t, g.Expire(now.Add(3*time.Minute)), 1)
+}
+
+func TestRequesterGranter(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	client := ptp.PortIdentity{ClockIdentity: 42, PortNumber: 1}
+	r := NewRequester(testRequesterConfig)
+	g := NewGranter(testGranterConfig)
+	r.Add(ptp.MessageSync, 0)
+	r.Add(ptp.MessageDelayResp, -1)
+
+	for _, req := range r.Due(now) {
+		err := r.HandleGrant(g.HandleRequest(client, req, now), now)
+		require.Nil(t, err)
+	}
+	s, _ := r.Subscription(ptp.MessageSync)
+	require.Equal(t, StateGranted, s.State)
+	s, _ = r.Subscription(ptp.MessageDelayResp)
+	require.Equal(t, StateDenied, s.State)
+	require.Equal(t, 1, g.Len())
+}
@@ -0,0 +1,241 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package negotiation
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// State is the negotiation state of a single message type
+type State uint8
+
+// Negotiation states
+const (
+	// StateIdle means the message type was never requested
+	StateIdle State = iota
+	// StateRequested means request was sent and grant is awaited
+	StateRequested
+	// StateGranted means the grant was received and hasn't expired yet
+	StateGranted
+	// StateDenied means the request was denied, it will be retried after DenialBackoff
+	StateDenied
+	// StateCancelled means the grant was cancelled by the granter, it will be requested again after DenialBackoff
+	StateCancelled
+)
+
+// StateToString is a map from State to string
+var StateToString = map[State]string{
+	StateIdle:      "IDLE",
+	StateRequested: "REQUESTED",
+	StateGranted:   "GRANTED",
+	StateDenied:    "DENIED",
+	StateCancelled: "CANCELLED",
+}
+
+func (s State) String() string {
+	return StateToString[s]
+}
+
+// RequesterConfig specifies how Requester requests and renews grants
+type RequesterConfig struct {
+	// Duration is the requested grant duration
+	Duration time.Duration
+	// GrantTimeout is how long to wait for the grant before sending the request again
+	GrantTimeout time.Duration
+	// MaxGrantTimeout caps exponential backoff of requests the granter doesn't answer,
+	// GrantTimeout doubles with every unanswered request until it. Backoff is disabled unless it's above GrantTimeout
+	MaxGrantTimeout time.Duration
+	// BackoffJitter is the part of the backed off timeout added to it at random
+	BackoffJitter float64
+	// DenialBackoff is how long to wait before requesting again after denial or cancellation
+	DenialBackoff time.Duration
+	// RenewalMargin is how long before the grant expiry it's renewed.
+	// It's capped at half of the granted duration.
+	RenewalMargin time.Duration
+}
+
+// Subscription is the negotiation state of a single message type
+type Subscription struct {
+	MessageType ptp.MessageType
+	// Interval is the requested interval, or the granted one once granted
+	Interval ptp.LogInterval
+	State    State
+	// Duration is the granted duration
+	Duration time.Duration
+	// Expires is when the grant expires
+	Expires time.Time
+	// next is when the request has to be sent
+	next time.Time
+	// retries is how many requests in a row were not answered
+	retries int
+}
+
+// Requester tracks unicast transmission requests of a client
+type Requester struct {
+	cfg  RequesterConfig
+	subs map[ptp.MessageType]*Subscription
+}
+
+// NewRequester returns new Requester
+func NewRequester(cfg RequesterConfig) *Requester {
+	return &Requester{
+		cfg:  cfg,
+		subs: map[ptp.MessageType]*Subscription{},
+	}
+}
+
+// Add adds message type to be requested with given interval. Request is due immediately.
+func (r *Requester) Add(msgType ptp.MessageType, interval ptp.LogInterval) {
+	r.subs[msgType] = &Subscription{
+		MessageType: msgType,
+		Interval:    interval,
+		State:       StateIdle,
+	}
+}
+
+// Subscription returns negotiation state of the message type
+func (r *Requester) Subscription(msgType ptp.MessageType) (Subscription, bool) {
+	s, ok := r.subs[msgType]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *s, true
+}
+
+// Backoff returns how long to wait before the next retry after that many unanswered ones: initial interval doubled
+// with every retry up to max, plus up to jitter part of it at random, so clients don't retry in lockstep
+func Backoff(initial, max time.Duration, retries int, jitter float64) time.Duration {
+	t := initial
+	for i := 0; i < retries && t < max; i++ {
+		t *= 2
+	}
+	if t > max {
+		t = max
+	}
+	if retries > 0 && jitter > 0 {
+		t += time.Duration(rand.Float64() * jitter * float64(t))
+	}
+	return t
+}
+
+// grantTimeout returns how long to wait for the grant of the subscription
+func (r *Requester) grantTimeout(s *Subscription) time.Duration {
+	if r.cfg.MaxGrantTimeout <= r.cfg.GrantTimeout {
+		return r.cfg.GrantTimeout
+	}
+	return Backoff(r.cfg.GrantTimeout, r.cfg.MaxGrantTimeout, s.retries, r.cfg.BackoffJitter)
+}
+
+// Due returns request TLVs which have to be sent now, be it initial requests, renewals or retries.
+// Returned requests are considered sent.
+func (r *Requester) Due(now time.Time) []*ptp.RequestUnicastTransmissionTLV {
+	var res []*ptp.RequestUnicastTransmissionTLV
+	for _, s := range r.sorted() {
+		if now.Before(s.next) {
+			continue
+		}
+		if s.State == StateGranted && !now.Before(s.Expires) {
+			s.State = StateIdle
+		}
+		res = append(res, requestTLV(s.MessageType, s.Interval, r.cfg.Duration))
+		if s.State == StateRequested {
+			s.retries++
+		}
+		if s.State != StateGranted {
+			s.State = StateRequested
+		}
+		s.next = now.Add(r.grantTimeout(s))
+		if s.State == StateGranted && s.next.After(s.Expires) {
+			s.next = s.Expires
+		}
+	}
+	return res
+}
+
+// NextDue returns when Due has to be called next, zero time if nothing is added
+func (r *Requester) NextDue() time.Time {
+	var next time.Time
+	for _, s := range r.subs {
+		if next.IsZero() || s.next.Before(next) {
+			next = s.next
+		}
+	}
+	return next
+}
+
+// HandleGrant processes GRANT_UNICAST_TRANSMISSION TLV received from the granter
+func (r *Requester) HandleGrant(tlv *ptp.GrantUnicastTransmissionTLV, now time.Time) error {
+	msgType := tlv.MsgTypeAndReserved.MsgType()
+	s, ok := r.subs[msgType]
+	if !ok {
+		return fmt.Errorf("received grant for %s which was not requested", msgType)
+	}
+	// granter is back, requests it didn't answer are sent again right away
+	for _, other := range r.subs {
+		if other.retries > 0 && other.State == StateRequested {
+			other.State = StateIdle
+			other.next = now
+		}
+		other.retries = 0
+	}
+	if tlv.DurationField == 0 {
+		s.State = StateDenied
+		s.next = now.Add(r.cfg.DenialBackoff)
+		return nil
+	}
+	s.State = StateGranted
+	s.Interval = tlv.LogInterMessagePeriod
+	s.Duration = time.Duration(tlv.DurationField) * time.Second
+	s.Expires = now.Add(s.Duration)
+	margin := r.cfg.RenewalMargin
+	if margin > s.Duration/2 {
+		margin = s.Duration / 2
+	}
+	s.next = s.Expires.Add(-margin)
+	return nil
+}
+
+// HandleCancel processes CANCEL_UNICAST_TRANSMISSION TLV received from the granter and returns acknowledgement to send back
+func (r *Requester) HandleCancel(tlv *ptp.CancelUnicastTransmissionTLV, now time.Time) *ptp.AcknowledgeCancelUnicastTransmissionTLV {
+	msgType := tlv.MsgTypeAndFlags.MsgType()
+	if s, ok := r.subs[msgType]; ok {
+		s.State = StateCancelled
+		s.next = now.Add(r.cfg.DenialBackoff)
+	}
+	return ackCancelTLV(msgType)
+}
+
+// Cancel stops requesting the message type and returns CANCEL_UNICAST_TRANSMISSION TLV to send to the granter
+func (r *Requester) Cancel(msgType ptp.MessageType) *ptp.CancelUnicastTransmissionTLV {
+	delete(r.subs, msgType)
+	return cancelTLV(msgType)
+}
+
+// sorted returns subscriptions sorted by message type, so requests are sent in stable order
+func (r *Requester) sorted() []*Subscription {
+	res := make([]*Subscription, 0, len(r.subs))
+	for _, s := range r.subs {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].MessageType < res[j].MessageType })
+	return res
+}
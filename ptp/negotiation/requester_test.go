@@ -0,0 +1,184 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package negotiation
+
+import (
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+var testRequesterConfig = RequesterConfig{
+	Duration:      time.Minute,
+	GrantTimeout:  time.Second,
+	DenialBackoff: 10 * time.Second,
+	RenewalMargin: 10 * time.Second,
+}
+
+func TestStateString(t *testing.T) {
+	require.Equal(t, "GRANTED", StateGranted.String())
+}
+
+func TestRequesterGrant(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	r := NewRequester(testRequesterConfig)
+	r.Add(ptp.MessageSync, 0)
+	r.Add(ptp.MessageAnnounce, 1)
+	require.Equal(t, time.Time{}, r.NextDue())
+
+	reqs := r.Due(now)
+	require.Len(t, reqs, 2)
+	require.Equal(t, ptp.MessageSync, reqs[0].MsgTypeAndReserved.MsgType())
+	require.Equal(t, ptp.MessageAnnounce, reqs[1].MsgTypeAndReserved.MsgType())
+	require.Equal(t, ptp.LogInterval(1), reqs[1].LogInterMessagePeriod)
+	require.Equal(t, uint32(60), reqs[1].DurationField)
+	require.Equal(t, uint16(6), reqs[1].LengthField)
+	require.Equal(t, now.Add(time.Second), r.NextDue())
+
+	// nothing is due until grant timeout
+	require.Empty(t, r.Due(now.Add(time.Millisecond)))
+
+	err := r.HandleGrant(grantTLV(ptp.MessageSync, 0, 30*time.Second), now)
+	require.Nil(t, err)
+	s, ok := r.Subscription(ptp.MessageSync)
+	require.True(t, ok)
+	require.Equal(t, StateGranted, s.State)
+	require.Equal(t, 30*time.Second, s.Duration)
+	require.Equal(t, now.Add(30*time.Second), s.Expires)
+
+	// announce request timed out and is retried, sync is not due
+	reqs = r.Due(now.Add(time.Second))
+	require.Len(t, reqs, 1)
+	require.Equal(t, ptp.MessageAnnounce, reqs[0].MsgTypeAndReserved.MsgType())
+	err = r.HandleGrant(grantTLV(ptp.MessageAnnounce, 1, time.Minute), now.Add(time.Second))
+	require.Nil(t, err)
+
+	// sync is renewed 10 seconds before expiry
+	require.Equal(t, now.Add(20*time.Second), r.NextDue())
+	require.Empty(t, r.Due(now.Add(19*time.Second)))
+	reqs = r.Due(now.Add(20 * time.Second))
+	require.Len(t, reqs, 1)
+	require.Equal(t, ptp.MessageSync, reqs[0].MsgTypeAndReserved.MsgType())
+	s, _ = r.Subscription(ptp.MessageSync)
+	require.Equal(t, StateGranted, s.State)
+
+	// renewal is not answered until the grant expires
+	require.Len(t, r.Due(now.Add(21*time.Second)), 1)
+	require.Len(t, r.Due(now.Add(30*time.Second)), 1)
+	s, _ = r.Subscription(ptp.MessageSync)
+	require.Equal(t, StateRequested, s.State)
+
+	err = r.HandleGrant(grantTLV(ptp.MessageDelayResp, 0, time.Minute), now)
+	require.Error(t, err)
+}
+
+func TestRequesterRenewalMargin(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	r := NewRequester(testRequesterConfig)
+	r.Add(ptp.MessageSync, 0)
+	r.Due(now)
+	err := r.HandleGrant(grantTLV(ptp.MessageSync, 0, 4*time.Second), now)
+	require.Nil(t, err)
+	// margin is capped at half of the duration
+	require.Equal(t, now.Add(2*time.Second), r.NextDue())
+}
+
+func TestRequesterDenial(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	r := NewRequester(testRequesterConfig)
+	r.Add(ptp.MessageSync, -7)
+	r.Due(now)
+
+	err := r.HandleGrant(grantTLV(ptp.MessageSync, -7, 0), now)
+	require.Nil(t, err)
+	s, _ := r.Subscription(ptp.MessageSync)
+	require.Equal(t, StateDenied, s.State)
+
+	require.Empty(t, r.Due(now.Add(9*time.Second)))
+	require.Len(t, r.Due(now.Add(10*time.Second)), 1)
+	s, _ = r.Subscription(ptp.MessageSync)
+	require.Equal(t, StateRequested, s.State)
+}
+
+func TestRequesterCancel(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	r := NewRequester(testRequesterConfig)
+	r.Add(ptp.MessageSync, 0)
+	r.Due(now)
+	err := r.HandleGrant(grantTLV(ptp.MessageSync, 0, time.Minute), now)
+	require.Nil(t, err)
+
+	ack := r.HandleCancel(cancelTLV(ptp.MessageSync), now)
+	require.Equal(t, ptp.TLVAcknowledgeCancelUnicastTransmission, ack.TLVType)
+	require.Equal(t, ptp.MessageSync, ack.MsgTypeAndFlags.MsgType())
+	s, _ := r.Subscription(ptp.MessageSync)
+	require.Equal(t, StateCancelled, s.State)
+	require.Equal(t, now.Add(10*time.Second), r.NextDue())
+
+	cancel := r.Cancel(ptp.MessageSync)
+	require.Equal(t, ptp.TLVCancelUnicastTransmission, cancel.TLVType)
+	require.Equal(t, ptp.MessageSync, cancel.MsgTypeAndFlags.MsgType())
+	_, ok := r.Subscription(ptp.MessageSync)
+	require.False(t, ok)
+	require.Empty(t, r.Due(now.Add(time.Hour)))
+}
+
+func TestBackoff(t *testing.T) {
+	require.Equal(t, time.Second, Backoff(time.Second, time.Minute, 0, 0))
+	require.Equal(t, 8*time.Second, Backoff(time.Second, time.Minute, 3, 0))
+	require.Equal(t, time.Minute, Backoff(time.Second, time.Minute, 100, 0))
+	// first try is not delayed by jitter
+	require.Equal(t, time.Second, Backoff(time.Second, time.Minute, 0, 0.5))
+	for i := 0; i < 100; i++ {
+		b := Backoff(time.Second, time.Minute, 2, 0.5)
+		require.GreaterOrEqual(t, b, 4*time.Second)
+		require.Less(t, b, 6*time.Second)
+	}
+}
+
+func TestRequesterBackoff(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	cfg := testRequesterConfig
+	cfg.MaxGrantTimeout = 4 * time.Second
+	r := NewRequester(cfg)
+	r.Add(ptp.MessageSync, 0)
+	r.Add(ptp.MessageAnnounce, 1)
+
+	// unanswered requests are retried less and less often
+	require.Len(t, r.Due(now), 2)
+	require.Equal(t, now.Add(time.Second), r.NextDue())
+	now = now.Add(time.Second)
+	require.Len(t, r.Due(now), 2)
+	require.Equal(t, now.Add(2*time.Second), r.NextDue())
+	now = now.Add(2 * time.Second)
+	require.Len(t, r.Due(now), 2)
+	require.Equal(t, now.Add(4*time.Second), r.NextDue())
+	now = now.Add(4 * time.Second)
+	require.Len(t, r.Due(now), 2)
+	require.Equal(t, now.Add(4*time.Second), r.NextDue())
+
+	// once the granter answers, the other request is sent right away
+	now = now.Add(time.Second)
+	require.NoError(t, r.HandleGrant(grantTLV(ptp.MessageSync, 0, time.Minute), now))
+	require.Equal(t, now, r.NextDue())
+	reqs := r.Due(now)
+	require.Len(t, reqs, 1)
+	require.Equal(t, ptp.MessageAnnounce, reqs[0].MsgTypeAndReserved.MsgType())
+	require.Equal(t, now.Add(time.Second), r.NextDue())
+}
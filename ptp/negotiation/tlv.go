@@ -0,0 +1,76 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package negotiation
+
+import (
+	"encoding/binary"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+var tlvHeadSize = binary.Size(ptp.TLVHead{})
+
+// durationField converts duration to DurationField of unicast TLVs, which is in seconds
+func durationField(d time.Duration) uint32 {
+	return uint32(d / time.Second)
+}
+
+func requestTLV(msgType ptp.MessageType, interval ptp.LogInterval, duration time.Duration) *ptp.RequestUnicastTransmissionTLV {
+	return &ptp.RequestUnicastTransmissionTLV{
+		TLVHead: ptp.TLVHead{
+			TLVType:     ptp.TLVRequestUnicastTransmission,
+			LengthField: uint16(binary.Size(ptp.RequestUnicastTransmissionTLV{}) - tlvHeadSize),
+		},
+		MsgTypeAndReserved:    ptp.NewUnicastMsgTypeAndFlags(msgType, 0),
+		LogInterMessagePeriod: interval,
+		DurationField:         durationField(duration),
+	}
+}
+
+func grantTLV(msgType ptp.MessageType, interval ptp.LogInterval, duration time.Duration) *ptp.GrantUnicastTransmissionTLV {
+	return &ptp.GrantUnicastTransmissionTLV{
+		TLVHead: ptp.TLVHead{
+			TLVType:     ptp.TLVGrantUnicastTransmission,
+			LengthField: uint16(binary.Size(ptp.GrantUnicastTransmissionTLV{}) - tlvHeadSize),
+		},
+		MsgTypeAndReserved:    ptp.NewUnicastMsgTypeAndFlags(msgType, 0),
+		LogInterMessagePeriod: interval,
+		DurationField:         durationField(duration),
+		Renewal:               1,
+	}
+}
+
+func cancelTLV(msgType ptp.MessageType) *ptp.CancelUnicastTransmissionTLV {
+	return &ptp.CancelUnicastTransmissionTLV{
+		TLVHead: ptp.TLVHead{
+			TLVType:     ptp.TLVCancelUnicastTransmission,
+			LengthField: uint16(binary.Size(ptp.CancelUnicastTransmissionTLV{}) - tlvHeadSize),
+		},
+		MsgTypeAndFlags: ptp.NewUnicastMsgTypeAndFlags(msgType, 0),
+	}
+}
+
+func ackCancelTLV(msgType ptp.MessageType) *ptp.AcknowledgeCancelUnicastTransmissionTLV {
+	return &ptp.AcknowledgeCancelUnicastTransmissionTLV{
+		TLVHead: ptp.TLVHead{
+			TLVType:     ptp.TLVAcknowledgeCancelUnicastTransmission,
+			LengthField: uint16(binary.Size(ptp.AcknowledgeCancelUnicastTransmissionTLV{}) - tlvHeadSize),
+		},
+		MsgTypeAndFlags: ptp.NewUnicastMsgTypeAndFlags(msgType, 0),
+	}
+}
@@ -0,0 +1,24 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package pcap decodes PTP messages from pcap and pcapng capture files, for offline troubleshooting.
+
+Both PTP over UDP (IPv4 and IPv6) and PTP over IEEE 802.3 (L2) are supported.
+Sync/Follow_Up and Delay_Req/Delay_Resp messages can be paired into exchanges to compute offsets and path delays.
+Capture timestamps are used as client side timestamps, so results only make sense for captures taken on the client.
+*/
+package pcap
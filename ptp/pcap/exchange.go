@@ -0,0 +1,170 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pcap
+
+import (
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// Result is the offset and path delay computed from Sync/Follow_Up and Delay_Req/Delay_Resp exchange
+type Result struct {
+	Server     ptp.PortIdentity
+	Client     ptp.PortIdentity
+	ServerAddr string
+	ClientAddr string
+
+	SyncSequenceID  uint16
+	DelaySequenceID uint16
+	// T1 is the Sync departure from the server
+	T1 time.Time
+	// T2 is the Sync arrival to the client, capture timestamp
+	T2 time.Time
+	// T3 is the Delay_Req departure from the client, capture timestamp
+	T3 time.Time
+	// T4 is the Delay_Req arrival to the server
+	T4 time.Time
+
+	ServerToClientDiff time.Duration
+	ClientToServerDiff time.Duration
+	Delay              time.Duration
+	Offset             time.Duration
+}
+
+// syncKey identifies single Sync message sent by the server to the destination
+type syncKey struct {
+	server ptp.PortIdentity
+	dst    string
+	seq    uint16
+}
+
+// pathKey identifies flow of Sync messages from the server to the destination
+type pathKey struct {
+	server ptp.PortIdentity
+	dst    string
+}
+
+// delayKey identifies single Delay_Req message sent by the client
+type delayKey struct {
+	client ptp.PortIdentity
+	seq    uint16
+}
+
+type syncData struct {
+	seq        uint16
+	t1         time.Time
+	t2         time.Time
+	correction ptp.Correction
+}
+
+type delayData struct {
+	clientAddr string
+	serverAddr string
+	t3         time.Time
+}
+
+// Exchanges pairs Sync/Follow_Up and Delay_Req/Delay_Resp messages and computes offset and delay
+// every time Delay_Resp completes an exchange, using the latest complete Sync received by the client.
+// Messages are expected to be in capture order.
+func Exchanges(msgs []*Message) []*Result {
+	pendingSyncs := map[syncKey]*syncData{}
+	latestSyncs := map[pathKey]*syncData{}
+	pendingDelays := map[delayKey]*delayData{}
+	results := []*Result{}
+
+	for _, msg := range msgs {
+		switch p := msg.Packet.(type) {
+		case *ptp.SyncDelayReq:
+			switch p.MessageType() {
+			case ptp.MessageSync:
+				s := &syncData{seq: p.SequenceID, t2: msg.Timestamp, correction: p.CorrectionField}
+				if p.FlagField&ptp.FlagTwoStep == 0 {
+					s.t1 = p.OriginTimestamp.Time()
+					latestSyncs[pathKey{server: p.SourcePortIdentity, dst: msg.Dst}] = s
+					continue
+				}
+				pendingSyncs[syncKey{server: p.SourcePortIdentity, dst: msg.Dst, seq: p.SequenceID}] = s
+			case ptp.MessageDelayReq:
+				pendingDelays[delayKey{client: p.SourcePortIdentity, seq: p.SequenceID}] = &delayData{
+					clientAddr: msg.Src,
+					serverAddr: msg.Dst,
+					t3:         msg.Timestamp,
+				}
+			}
+		case *ptp.FollowUp:
+			key := syncKey{server: p.SourcePortIdentity, dst: msg.Dst, seq: p.SequenceID}
+			s, found := pendingSyncs[key]
+			if !found {
+				continue
+			}
+			delete(pendingSyncs, key)
+			s.t1 = p.PreciseOriginTimestamp.Time()
+			s.correction = s.correction.Add(p.CorrectionField)
+			latestSyncs[pathKey{server: key.server, dst: key.dst}] = s
+		case *ptp.DelayResp:
+			key := delayKey{client: p.RequestingPortIdentity, seq: p.SequenceID}
+			d, found := pendingDelays[key]
+			if !found {
+				continue
+			}
+			delete(pendingDelays, key)
+			s := latestSync(latestSyncs, p.SourcePortIdentity, d.clientAddr)
+			if s == nil {
+				continue
+			}
+			t4 := p.ReceiveTimestamp.Time()
+			serverToClientDiff := s.t2.Sub(s.t1) - s.correction.Duration()
+			clientToServerDiff := t4.Sub(d.t3) - p.CorrectionField.Duration()
+			delay := (clientToServerDiff + serverToClientDiff) / 2
+			results = append(results, &Result{
+				Server:             p.SourcePortIdentity,
+				Client:             p.RequestingPortIdentity,
+				ServerAddr:         d.serverAddr,
+				ClientAddr:         d.clientAddr,
+				SyncSequenceID:     s.seq,
+				DelaySequenceID:    p.SequenceID,
+				T1:                 s.t1,
+				T2:                 s.t2,
+				T3:                 d.t3,
+				T4:                 t4,
+				ServerToClientDiff: serverToClientDiff,
+				ClientToServerDiff: clientToServerDiff,
+				Delay:              delay,
+				Offset:             serverToClientDiff - delay,
+			})
+		}
+	}
+	return results
+}
+
+// latestSync returns latest complete Sync sent by the server either to the client directly, or to multicast address
+func latestSync(syncs map[pathKey]*syncData, server ptp.PortIdentity, client string) *syncData {
+	if s, found := syncs[pathKey{server: server, dst: client}]; found {
+		return s
+	}
+	var latest *syncData
+	for k, s := range syncs {
+		if k.server != server || !isMulticast(k.dst) {
+			continue
+		}
+		if latest == nil || s.t2.After(latest.t2) {
+			latest = s
+		}
+	}
+	return latest
+}
@@ -0,0 +1,236 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pcap
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/require"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+var (
+	serverIdentity = ptp.PortIdentity{ClockIdentity: 0xc42a1fffe6d7ca6, PortNumber: 1}
+	clientIdentity = ptp.PortIdentity{ClockIdentity: 0x4857ddfffe0e91da, PortNumber: 1}
+	serverIP       = net.ParseIP("2401:db00::1")
+	clientIP       = net.ParseIP("2401:db00::2")
+	serverMAC      = net.HardwareAddr{0x0c, 0x42, 0xa1, 0x6d, 0x7c, 0xa6}
+	clientMAC      = net.HardwareAddr{0x48, 0x57, 0xdd, 0x0e, 0x91, 0xda}
+	t1             = time.Unix(1653574589, 100000000)
+)
+
+func header(msgType ptp.MessageType, from ptp.PortIdentity, seq uint16, flags uint16) ptp.Header {
+	return ptp.Header{
+		SdoIDAndMsgType:    ptp.NewSdoIDAndMsgType(msgType, 0),
+		Version:            ptp.Version,
+		FlagField:          flags,
+		SequenceID:         seq,
+		SourcePortIdentity: from,
+		LogMessageInterval: 0x7f,
+	}
+}
+
+// exchange returns Sync, optional Follow_Up, Delay_Req and Delay_Resp with 5µs path delay and 2µs offset of the client
+func exchange(twoStep bool, correction ptp.Correction) []ptp.Packet {
+	sync := &ptp.SyncDelayReq{Header: header(ptp.MessageSync, serverIdentity, 1, 0)}
+	sync.MessageLength = 44
+	sync.CorrectionField = correction
+	followup := &ptp.FollowUp{Header: header(ptp.MessageFollowUp, serverIdentity, 1, 0)}
+	followup.MessageLength = 44
+	followup.PreciseOriginTimestamp = ptp.NewTimestamp(t1)
+	if twoStep {
+		sync.FlagField = ptp.FlagTwoStep
+	} else {
+		sync.OriginTimestamp = ptp.NewTimestamp(t1)
+	}
+	delayReq := &ptp.SyncDelayReq{Header: header(ptp.MessageDelayReq, clientIdentity, 5, 0)}
+	delayReq.MessageLength = 44
+	delayResp := &ptp.DelayResp{Header: header(ptp.MessageDelayResp, serverIdentity, 5, 0)}
+	delayResp.MessageLength = 54
+	delayResp.RequestingPortIdentity = clientIdentity
+	delayResp.ReceiveTimestamp = ptp.NewTimestamp(t1.Add(110 * time.Microsecond))
+	if twoStep {
+		return []ptp.Packet{sync, followup, delayReq, delayResp}
+	}
+	return []ptp.Packet{sync, delayReq, delayResp}
+}
+
+// capture times of the exchange packets
+func captureTimes(twoStep bool) []time.Time {
+	t2 := t1.Add(7 * time.Microsecond)
+	t3 := t2.Add(100 * time.Microsecond)
+	if twoStep {
+		return []time.Time{t2, t2.Add(time.Microsecond), t3, t3.Add(10 * time.Microsecond)}
+	}
+	return []time.Time{t2, t3, t3.Add(10 * time.Microsecond)}
+}
+
+func udpFrame(t *testing.T, p ptp.Packet, fromServer bool) []byte {
+	b, err := ptp.Bytes(p)
+	require.NoError(t, err)
+	src, dst := serverIP, clientIP
+	srcMAC, dstMAC := serverMAC, clientMAC
+	if !fromServer {
+		src, dst = dst, src
+		srcMAC, dstMAC = dstMAC, srcMAC
+	}
+	port := layers.UDPPort(ptp.PortGeneral)
+	if p.MessageType() == ptp.MessageSync || p.MessageType() == ptp.MessageDelayReq {
+		port = ptp.PortEvent
+	}
+	eth := &layers.Ethernet{SrcMAC: srcMAC, DstMAC: dstMAC, EthernetType: layers.EthernetTypeIPv6}
+	ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: port, DstPort: port}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(b)))
+	return buf.Bytes()
+}
+
+func l2Frame(t *testing.T, p ptp.Packet, fromServer bool) []byte {
+	src := serverMAC
+	if !fromServer {
+		src = clientMAC
+	}
+	buf := make([]byte, 128)
+	n, err := ptp.EthernetBytesTo(p, src, buf)
+	require.NoError(t, err)
+	return buf[:n]
+}
+
+func writeCapture(t *testing.T, frames [][]byte, times []time.Time) *bytes.Reader {
+	var out bytes.Buffer
+	w := pcapgo.NewWriterNanos(&out)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for i, frame := range frames {
+		ci := gopacket.CaptureInfo{Timestamp: times[i], CaptureLength: len(frame), Length: len(frame)}
+		require.NoError(t, w.WritePacket(ci, frame))
+	}
+	return bytes.NewReader(out.Bytes())
+}
+
+func TestReadAllUDP(t *testing.T) {
+	packets := exchange(true, 0)
+	times := captureTimes(true)
+	frames := [][]byte{}
+	for _, p := range packets {
+		frames = append(frames, udpFrame(t, p, p.MessageType() != ptp.MessageDelayReq))
+	}
+	// not PTP
+	eth := &layers.Ethernet{SrcMAC: serverMAC, DstMAC: clientMAC, EthernetType: layers.EthernetTypeIPv6}
+	ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: serverIP, DstIP: clientIP}
+	udp := &layers.UDP{SrcPort: 123, DstPort: 123}
+	require.NoError(t, udp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, ip, udp, gopacket.Payload{1, 2, 3}))
+	frames = append(frames, buf.Bytes())
+	times = append(times, times[len(times)-1])
+
+	msgs, err := ReadAll(writeCapture(t, frames, times))
+	require.NoError(t, err)
+	require.Equal(t, len(packets), len(msgs))
+	for i, msg := range msgs {
+		require.Equal(t, packets[i], msg.Packet)
+		require.True(t, times[i].Equal(msg.Timestamp))
+		require.False(t, msg.L2)
+	}
+	require.Equal(t, serverIP.String(), msgs[0].Src)
+	require.Equal(t, clientIP.String(), msgs[0].Dst)
+
+	results := Exchanges(msgs)
+	require.Equal(t, 1, len(results))
+	r := results[0]
+	require.Equal(t, serverIdentity, r.Server)
+	require.Equal(t, clientIdentity, r.Client)
+	require.Equal(t, serverIP.String(), r.ServerAddr)
+	require.Equal(t, clientIP.String(), r.ClientAddr)
+	require.Equal(t, uint16(1), r.SyncSequenceID)
+	require.Equal(t, uint16(5), r.DelaySequenceID)
+	require.Equal(t, 5*time.Microsecond, r.Delay)
+	require.Equal(t, 2*time.Microsecond, r.Offset)
+}
+
+func TestReadAllL2(t *testing.T) {
+	packets := exchange(false, ptp.NewCorrectionFromDuration(time.Microsecond))
+	times := captureTimes(false)
+	frames := [][]byte{}
+	for _, p := range packets {
+		frames = append(frames, l2Frame(t, p, p.MessageType() != ptp.MessageDelayReq))
+	}
+	// broken PTP message
+	broken := l2Frame(t, packets[0], true)
+	frames = append(frames, broken[:ptp.EthernetHeaderSize+10])
+	times = append(times, times[len(times)-1])
+
+	reader, err := NewReader(writeCapture(t, frames, times))
+	require.NoError(t, err)
+	msgs := []*Message{}
+	for {
+		msg, err := reader.Next()
+		if err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	require.Equal(t, 1, reader.Skipped)
+	require.Equal(t, len(packets), len(msgs))
+	require.True(t, msgs[0].L2)
+	require.Equal(t, serverMAC.String(), msgs[0].Src)
+	require.Equal(t, ptp.MulticastMAC.String(), msgs[0].Dst)
+
+	results := Exchanges(msgs)
+	require.Equal(t, 1, len(results))
+	// Sync correction is taken into account
+	require.Equal(t, 4500*time.Nanosecond, results[0].Delay)
+	require.Equal(t, 1500*time.Nanosecond, results[0].Offset)
+}
+
+func TestExchangesIncomplete(t *testing.T) {
+	packets := exchange(true, 0)
+	times := captureTimes(true)
+	msgs := []*Message{}
+	for i, p := range packets {
+		// no Follow_Up
+		if p.MessageType() == ptp.MessageFollowUp {
+			continue
+		}
+		msgs = append(msgs, &Message{Timestamp: times[i], Src: "a", Dst: "b", Packet: p})
+	}
+	require.Empty(t, Exchanges(msgs))
+}
+
+func TestNewReaderGarbage(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("definitely not a pcap file")))
+	require.Error(t, err)
+}
+
+func TestIsMulticast(t *testing.T) {
+	require.True(t, isMulticast("ff0e::181"))
+	require.True(t, isMulticast("224.0.1.129"))
+	require.False(t, isMulticast("2401:db00::1"))
+	require.True(t, isMulticast(ptp.MulticastMAC.String()))
+	require.False(t, isMulticast(serverMAC.String()))
+	require.False(t, isMulticast("garbage"))
+}
@@ -0,0 +1,157 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// Message is a single PTP message read from the capture
+type Message struct {
+	// Timestamp is the capture timestamp
+	Timestamp time.Time
+	// Src and Dst are IP addresses for PTP over UDP, and MAC addresses for PTP over L2
+	Src string
+	Dst string
+	// L2 is true if message was sent via IEEE 802.3 transport
+	L2     bool
+	Packet ptp.Packet
+}
+
+// packetHandle abstracts packet handles provided by pcapgo.Reader and pcapgo.NGReader
+type packetHandle interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// Reader reads PTP messages from pcap or pcapng capture
+type Reader struct {
+	handle packetHandle
+	// Skipped is the number of PTP packets which failed to decode
+	Skipped int
+}
+
+// NewReader returns Reader of pcap or pcapng capture
+func NewReader(r io.ReadSeeker) (*Reader, error) {
+	// try NGReader, if it fails - fall back to Reader
+	handle, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	if err == nil {
+		return &Reader{handle: handle}, nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking capture: %w", err)
+	}
+	h, err := pcapgo.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("decoding capture: %w", err)
+	}
+	return &Reader{handle: h}, nil
+}
+
+// Next returns next PTP message from the capture, io.EOF when there are no more messages.
+// Packets which are not PTP are skipped, as well as PTP packets which failed to decode.
+func (r *Reader) Next() (*Message, error) {
+	for {
+		data, ci, err := r.handle.ReadPacketData()
+		if err != nil {
+			return nil, err
+		}
+		msg, isPTP, err := decodeFrame(data, r.handle.LinkType())
+		if !isPTP {
+			continue
+		}
+		if err != nil {
+			r.Skipped++
+			continue
+		}
+		msg.Timestamp = ci.Timestamp
+		return msg, nil
+	}
+}
+
+// ReadAll reads all PTP messages from the capture
+func ReadAll(r io.ReadSeeker) ([]*Message, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*Message{}
+	for {
+		msg, err := reader.Next()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// decodeFrame decodes PTP message from captured frame. Returned bool tells if frame contained PTP at all.
+func decodeFrame(data []byte, linkType layers.LinkType) (*Message, bool, error) {
+	packet := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
+		udp, _ := udpLayer.(*layers.UDP)
+		if !isPTPPort(udp.DstPort) && !isPTPPort(udp.SrcPort) {
+			return nil, false, nil
+		}
+		flow := packet.NetworkLayer().NetworkFlow()
+		p, err := ptp.DecodePacket(udp.Payload)
+		if err != nil {
+			return nil, true, err
+		}
+		return &Message{Src: flow.Src().String(), Dst: flow.Dst().String(), Packet: p}, true, nil
+	}
+	if linkType != layers.LinkTypeEthernet {
+		return nil, false, nil
+	}
+	h := &ptp.EthernetHeader{}
+	if err := h.UnmarshalBinary(data); err != nil || h.EtherType != ptp.EtherType {
+		return nil, false, nil
+	}
+	p, h, err := ptp.DecodeEthernetPacket(data)
+	if err != nil {
+		return nil, true, err
+	}
+	return &Message{Src: h.Source.String(), Dst: h.Destination.String(), L2: true, Packet: p}, true, nil
+}
+
+func isPTPPort(port layers.UDPPort) bool {
+	return port == ptp.PortEvent || port == ptp.PortGeneral
+}
+
+// isMulticast checks if address of the Message is multicast one
+func isMulticast(addr string) bool {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.IsMulticast()
+	}
+	mac, err := net.ParseMAC(addr)
+	if err != nil || len(mac) == 0 {
+		return false
+	}
+	return mac[0]&0x01 != 0
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+// ClockClass values defined by telecom profiles, ITU-T G.8275.1 Table 2 and G.8275.2 Table 2
+const (
+	// ClockClassLocked is T-GM connected to a PRTC in locked mode
+	ClockClassLocked uint8 = 6
+	// ClockClassHoldoverInSpec is T-GM in holdover, within holdover specification
+	ClockClassHoldoverInSpec uint8 = 7
+	// ClockClassBCHoldoverInSpec is T-BC in holdover, within holdover specification
+	ClockClassBCHoldoverInSpec uint8 = 135
+	// ClockClassHoldoverCategory1 is T-GM in holdover, out of holdover specification, traceable to Category 1 frequency source
+	ClockClassHoldoverCategory1 uint8 = 140
+	// ClockClassHoldoverCategory2 is T-GM in holdover, out of holdover specification, traceable to Category 2 frequency source
+	ClockClassHoldoverCategory2 uint8 = 150
+	// ClockClassHoldoverCategory3 is T-GM in holdover, out of holdover specification, traceable to Category 3 frequency source
+	ClockClassHoldoverCategory3 uint8 = 160
+	// ClockClassBCHoldoverOutOfSpec is T-BC in holdover, out of holdover specification
+	ClockClassBCHoldoverOutOfSpec uint8 = 165
+	// ClockClassFreeRun is T-GM or T-BC without time reference since start up
+	ClockClassFreeRun uint8 = 248
+	// ClockClassSlaveOnly is slave-only OC
+	ClockClassSlaveOnly uint8 = 255
+)
+
+// ClockClassToString is a map from telecom profile ClockClass to its description
+var ClockClassToString = map[uint8]string{
+	ClockClassLocked:              "LOCKED",
+	ClockClassHoldoverInSpec:      "HOLDOVER_IN_SPEC",
+	ClockClassBCHoldoverInSpec:    "BC_HOLDOVER_IN_SPEC",
+	ClockClassHoldoverCategory1:   "HOLDOVER_CATEGORY_1",
+	ClockClassHoldoverCategory2:   "HOLDOVER_CATEGORY_2",
+	ClockClassHoldoverCategory3:   "HOLDOVER_CATEGORY_3",
+	ClockClassBCHoldoverOutOfSpec: "BC_HOLDOVER_OUT_OF_SPEC",
+	ClockClassFreeRun:             "FREE_RUN",
+	ClockClassSlaveOnly:           "SLAVE_ONLY",
+}
+
+// HoldoverClockClass returns clockClass T-GM should announce when it lost its time reference.
+// inSpec tells if the clock is still within holdover specification,
+// category is the category (1-3) of frequency source it's traceable to when it's out of specification.
+func HoldoverClockClass(inSpec bool, category int) uint8 {
+	if inSpec {
+		return ClockClassHoldoverInSpec
+	}
+	switch category {
+	case 1:
+		return ClockClassHoldoverCategory1
+	case 2:
+		return ClockClassHoldoverCategory2
+	case 3:
+		return ClockClassHoldoverCategory3
+	}
+	return ClockClassFreeRun
+}
@@ -0,0 +1,24 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package profile contains definitions of PTP profiles: IEEE 1588-2019 default delay request-response profile,
+and ITU-T G.8275.1 and G.8275.2 telecom profiles.
+
+Profile describes allowed domains, message rates, transport and BMCA flavour,
+so servers and clients can be configured for a particular network by the profile name.
+*/
+package profile
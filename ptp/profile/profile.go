@@ -0,0 +1,250 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/facebook/time/ptp/bmca"
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// Transport is the transport mapping used by the profile
+type Transport uint8
+
+// Transports as per IEEE 1588-2019 Annex C and Annex E
+const (
+	TransportUDP Transport = iota
+	TransportL2
+)
+
+// TransportToString is a map from Transport to string
+var TransportToString = map[Transport]string{
+	TransportUDP: "UDP",
+	TransportL2:  "L2",
+}
+
+func (t Transport) String() string {
+	return TransportToString[t]
+}
+
+// IntervalRange is the range of logMessageInterval values allowed by the profile
+type IntervalRange struct {
+	Min     ptp.LogInterval
+	Max     ptp.LogInterval
+	Default ptp.LogInterval
+}
+
+// Contains checks if interval is within the range
+func (r IntervalRange) Contains(i ptp.LogInterval) bool {
+	return i >= r.Min && i <= r.Max
+}
+
+// Profile is the PTP profile definition
+type Profile struct {
+	Name string
+
+	DomainMin     uint8
+	DomainMax     uint8
+	DefaultDomain uint8
+	// SdoID is the sdoId all messages of the profile carry
+	SdoID ptp.SdoID
+
+	Transport Transport
+	// Unicast means messages are exchanged via unicast negotiation
+	Unicast bool
+	// DelayMechanism is how the path delay is measured
+	DelayMechanism ptp.DelayMechanism
+
+	AnnounceInterval IntervalRange
+	SyncInterval     IntervalRange
+	// DelayReqInterval is the range of Delay_Req or Pdelay_Req intervals, depending on DelayMechanism
+	DelayReqInterval IntervalRange
+	// AnnounceReceiptTimeout is the default number of announce intervals before the timeout
+	AnnounceReceiptTimeout uint8
+	// MaxGrantDuration is the longest unicast grant allowed, zero means there is no limit
+	MaxGrantDuration time.Duration
+
+	Priority1     uint8
+	Priority2     uint8
+	LocalPriority uint8
+	// AlternateBMCA means telecom BMCA with localPriority is used instead of the default one
+	AlternateBMCA bool
+}
+
+// Profile names
+const (
+	NameDefault = "default"
+	NameG8275_1 = "g8275.1"
+	NameG8275_2 = "g8275.2"
+	NamePower   = "power"
+)
+
+// Default is the IEEE 1588-2019 delay request-response default PTP profile, Annex I.3
+var Default = &Profile{
+	Name:           NameDefault,
+	DomainMin:      0,
+	DomainMax:      127,
+	DefaultDomain:  0,
+	Transport:      TransportUDP,
+	DelayMechanism: ptp.DelayMechanismE2E,
+
+	AnnounceInterval:       IntervalRange{Min: 0, Max: 4, Default: 1},
+	SyncInterval:           IntervalRange{Min: -1, Max: 1, Default: 0},
+	DelayReqInterval:       IntervalRange{Min: 0, Max: 5, Default: 0},
+	AnnounceReceiptTimeout: 3,
+
+	Priority1: 128,
+	Priority2: 128,
+}
+
+// G8275_1 is the ITU-T G.8275.1 telecom profile for phase/time synchronization with full timing support from the network.
+// Messages are sent via L2 multicast at fixed rates.
+var G8275_1 = &Profile{
+	Name:           NameG8275_1,
+	DomainMin:      24,
+	DomainMax:      43,
+	DefaultDomain:  24,
+	Transport:      TransportL2,
+	DelayMechanism: ptp.DelayMechanismE2E,
+
+	AnnounceInterval:       IntervalRange{Min: -3, Max: -3, Default: -3},
+	SyncInterval:           IntervalRange{Min: -4, Max: -4, Default: -4},
+	DelayReqInterval:       IntervalRange{Min: -4, Max: -4, Default: -4},
+	AnnounceReceiptTimeout: 3,
+
+	Priority1:     128,
+	Priority2:     128,
+	LocalPriority: 128,
+	AlternateBMCA: true,
+}
+
+// G8275_2 is the ITU-T G.8275.2 telecom profile for phase/time synchronization with partial timing support from the network.
+// Messages are sent via UDP unicast negotiation.
+var G8275_2 = &Profile{
+	Name:           NameG8275_2,
+	DomainMin:      44,
+	DomainMax:      63,
+	DefaultDomain:  44,
+	Transport:      TransportUDP,
+	Unicast:        true,
+	DelayMechanism: ptp.DelayMechanismE2E,
+
+	AnnounceInterval:       IntervalRange{Min: -3, Max: 0, Default: 0},
+	SyncInterval:           IntervalRange{Min: -7, Max: 0, Default: -4},
+	DelayReqInterval:       IntervalRange{Min: -7, Max: 0, Default: -4},
+	AnnounceReceiptTimeout: 3,
+	MaxGrantDuration:       1000 * time.Second,
+
+	Priority1:     128,
+	Priority2:     128,
+	LocalPriority: 128,
+	AlternateBMCA: true,
+}
+
+// Power is the IEC/IEEE 61850-9-3 power utility automation profile.
+// Messages are sent via L2 multicast once a second, path delay is measured peer-to-peer.
+var Power = &Profile{
+	Name:           NamePower,
+	DomainMin:      0,
+	DomainMax:      255,
+	DefaultDomain:  0,
+	SdoID:          ptp.SdoIDDefault,
+	Transport:      TransportL2,
+	DelayMechanism: ptp.DelayMechanismP2P,
+
+	AnnounceInterval:       IntervalRange{Min: 0, Max: 0, Default: 0},
+	SyncInterval:           IntervalRange{Min: 0, Max: 0, Default: 0},
+	DelayReqInterval:       IntervalRange{Min: 0, Max: 0, Default: 0},
+	AnnounceReceiptTimeout: 3,
+
+	Priority1: 128,
+	Priority2: 128,
+}
+
+var profiles = map[string]*Profile{
+	NameDefault: Default,
+	NameG8275_1: G8275_1,
+	NameG8275_2: G8275_2,
+	NamePower:   Power,
+}
+
+// ByName returns profile by its name
+func ByName(name string) (*Profile, error) {
+	p, found := profiles[name]
+	if !found {
+		return nil, fmt.Errorf("unknown profile %q, supported profiles: %v", name, Names())
+	}
+	return p, nil
+}
+
+// Names returns sorted names of all known profiles
+func Names() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidDomain checks if domainNumber is allowed by the profile
+func (p *Profile) ValidDomain(domain uint8) bool {
+	return domain >= p.DomainMin && domain <= p.DomainMax
+}
+
+// ValidSdoID checks if message with given sdoId belongs to the profile
+func (p *Profile) ValidSdoID(sdoID ptp.SdoID) bool {
+	return sdoID == p.SdoID
+}
+
+// Interval returns range of intervals allowed by the profile for messages of given type
+func (p *Profile) Interval(msgType ptp.MessageType) (IntervalRange, error) {
+	switch msgType {
+	case ptp.MessageAnnounce:
+		return p.AnnounceInterval, nil
+	case ptp.MessageSync, ptp.MessageFollowUp:
+		return p.SyncInterval, nil
+	case ptp.MessageDelayReq, ptp.MessageDelayResp, ptp.MessagePDelayReq, ptp.MessagePDelayResp, ptp.MessagePDelayRespFollowUp:
+		return p.DelayReqInterval, nil
+	}
+	return IntervalRange{}, fmt.Errorf("no interval defined for %s", msgType)
+}
+
+// ValidInterval checks if messages of given type can be sent with given interval under the profile
+func (p *Profile) ValidInterval(msgType ptp.MessageType, i ptp.LogInterval) bool {
+	r, err := p.Interval(msgType)
+	if err != nil {
+		return false
+	}
+	return r.Contains(i)
+}
+
+// ValidGrantDuration checks if unicast grant of given duration is allowed by the profile
+func (p *Profile) ValidGrantDuration(d time.Duration) bool {
+	return p.MaxGrantDuration == 0 || d <= p.MaxGrantDuration
+}
+
+// Compare returns data set comparison algorithm used by the profile
+func (p *Profile) Compare() bmca.CompareFunc {
+	if p.AlternateBMCA {
+		return bmca.CompareAlternate
+	}
+	return bmca.Compare
+}
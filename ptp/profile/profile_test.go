@@ -0,0 +1,109 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebook/time/ptp/bmca"
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByName(t *testing.T) {
+	p, err := ByName("g8275.2")
+	require.NoError(t, err)
+	require.Equal(t, G8275_2, p)
+
+	_, err = ByName("nope")
+	require.Error(t, err)
+
+	require.Equal(t, []string{"default", "g8275.1", "g8275.2", "power"}, Names())
+}
+
+func TestValidDomain(t *testing.T) {
+	require.True(t, Default.ValidDomain(0))
+	require.False(t, Default.ValidDomain(128))
+	require.True(t, G8275_1.ValidDomain(G8275_1.DefaultDomain))
+	require.False(t, G8275_1.ValidDomain(44))
+	require.True(t, G8275_2.ValidDomain(63))
+	require.False(t, G8275_2.ValidDomain(0))
+	require.True(t, Power.ValidDomain(255))
+}
+
+func TestValidSdoID(t *testing.T) {
+	require.True(t, G8275_1.ValidSdoID(ptp.SdoIDDefault))
+	require.False(t, G8275_1.ValidSdoID(ptp.SdoIDIEEE8021AS))
+	require.True(t, Power.ValidSdoID(ptp.SdoIDDefault))
+	require.False(t, Power.ValidSdoID(ptp.SdoID(0x001)))
+}
+
+func TestPower(t *testing.T) {
+	p, err := ByName("power")
+	require.NoError(t, err)
+	require.Equal(t, Power, p)
+	require.Equal(t, TransportL2, p.Transport)
+	require.Equal(t, ptp.DelayMechanismP2P, p.DelayMechanism)
+	require.True(t, p.ValidInterval(ptp.MessagePDelayReq, 0))
+	require.False(t, p.ValidInterval(ptp.MessagePDelayReq, -1))
+	require.False(t, p.ValidInterval(ptp.MessageSync, -4))
+	// no localPriority in the default BMCA
+	r, reason := p.Compare()(&bmca.Dataset{GrandmasterPriority1: 1, GrandmasterIdentity: 1, LocalPriority: 2}, &bmca.Dataset{GrandmasterPriority1: 2, GrandmasterIdentity: 2, LocalPriority: 1})
+	require.Equal(t, bmca.ABetter, r)
+	require.Equal(t, bmca.ReasonPriority1, reason)
+}
+
+func TestValidInterval(t *testing.T) {
+	require.True(t, G8275_2.ValidInterval(ptp.MessageSync, -7))
+	require.False(t, G8275_2.ValidInterval(ptp.MessageSync, -8))
+	require.True(t, G8275_2.ValidInterval(ptp.MessageDelayResp, 0))
+	require.False(t, G8275_2.ValidInterval(ptp.MessageAnnounce, -4))
+	require.True(t, G8275_1.ValidInterval(ptp.MessageAnnounce, -3))
+	require.False(t, G8275_1.ValidInterval(ptp.MessageAnnounce, 0))
+	require.False(t, Default.ValidInterval(ptp.MessageSignaling, 0))
+
+	_, err := Default.Interval(ptp.MessageManagement)
+	require.Error(t, err)
+}
+
+func TestValidGrantDuration(t *testing.T) {
+	require.True(t, G8275_2.ValidGrantDuration(300*time.Second))
+	require.False(t, G8275_2.ValidGrantDuration(time.Hour))
+	require.True(t, Default.ValidGrantDuration(time.Hour))
+}
+
+func TestCompare(t *testing.T) {
+	a := &bmca.Dataset{GrandmasterPriority1: 1, GrandmasterIdentity: 1, LocalPriority: 2}
+	b := &bmca.Dataset{GrandmasterPriority1: 2, GrandmasterIdentity: 2, LocalPriority: 1}
+
+	r, reason := Default.Compare()(a, b)
+	require.Equal(t, bmca.ABetter, r)
+	require.Equal(t, bmca.ReasonPriority1, reason)
+
+	r, reason = G8275_2.Compare()(a, b)
+	require.Equal(t, bmca.BBetter, r)
+	require.Equal(t, bmca.ReasonLocalPriority, reason)
+}
+
+func TestHoldoverClockClass(t *testing.T) {
+	require.Equal(t, ClockClassHoldoverInSpec, HoldoverClockClass(true, 1))
+	require.Equal(t, ClockClassHoldoverCategory2, HoldoverClockClass(false, 2))
+	require.Equal(t, ClockClassFreeRun, HoldoverClockClass(false, 0))
+	require.Equal(t, "HOLDOVER_CATEGORY_2", ClockClassToString[ClockClassHoldoverCategory2])
+	require.Equal(t, "L2", G8275_1.Transport.String())
+}
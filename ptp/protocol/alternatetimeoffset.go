@@ -0,0 +1,115 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// alternateTimeOffsetFixedSize is the size of ALTERNATE_TIME_OFFSET_INDICATOR TLV data without displayName
+const alternateTimeOffsetFixedSize = 15
+
+// AlternateTimeOffsetIndicatorTLV Table 117 ALTERNATE_TIME_OFFSET_INDICATOR TLV format
+type AlternateTimeOffsetIndicatorTLV struct {
+	TLVHead
+	KeyField uint8
+	// CurrentOffset is the offset of the alternate time from PTP time in seconds
+	CurrentOffset int32
+	// JumpSeconds is the size of the next discontinuity of the alternate time in seconds
+	JumpSeconds int32
+	// TimeOfNextJump is PTP seconds of the next discontinuity, UInteger48
+	TimeOfNextJump uint64
+	DisplayName    PTPText
+}
+
+// NewAlternateTimeOffsetIndicatorTLV returns ALTERNATE_TIME_OFFSET_INDICATOR TLV for alternate timescale identified by key
+func NewAlternateTimeOffsetIndicatorTLV(key uint8, offset, jump time.Duration, nextJump time.Time, name string) *AlternateTimeOffsetIndicatorTLV {
+	t := &AlternateTimeOffsetIndicatorTLV{
+		TLVHead: TLVHead{
+			TLVType: TLVAlternateTimeOffsetIndicator,
+		},
+		KeyField:      key,
+		CurrentOffset: int32(offset / time.Second),
+		JumpSeconds:   int32(jump / time.Second),
+		DisplayName:   PTPText(name),
+	}
+	if !nextJump.IsZero() {
+		t.TimeOfNextJump = uint64(nextJump.Unix())
+	}
+	// displayName is padded to make sure TLV length is even
+	t.LengthField = uint16(alternateTimeOffsetFixedSize + 1 + len(name) + len(name)%2)
+	return t
+}
+
+// Offset returns offset of the alternate time from PTP time
+func (t *AlternateTimeOffsetIndicatorTLV) Offset() time.Duration {
+	return time.Duration(t.CurrentOffset) * time.Second
+}
+
+// NextJump returns time of the next discontinuity of the alternate time, zero time if there is none scheduled
+func (t *AlternateTimeOffsetIndicatorTLV) NextJump() time.Time {
+	if t.TimeOfNextJump == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(t.TimeOfNextJump), 0)
+}
+
+// MarshalBinaryTo marshals TLV into provided []byte
+func (t *AlternateTimeOffsetIndicatorTLV) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < tlvHeadSize+alternateTimeOffsetFixedSize {
+		return 0, fmt.Errorf("not enough buffer to write AlternateTimeOffsetIndicatorTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	b[tlvHeadSize] = t.KeyField
+	binary.BigEndian.PutUint32(b[tlvHeadSize+1:], uint32(t.CurrentOffset))
+	binary.BigEndian.PutUint32(b[tlvHeadSize+5:], uint32(t.JumpSeconds))
+	putUint48(b[tlvHeadSize+9:], t.TimeOfNextJump)
+	nn, err := t.DisplayName.MarshalBinaryTo(b[tlvHeadSize+alternateTimeOffsetFixedSize:])
+	if err != nil {
+		return 0, err
+	}
+	return tlvHeadSize + alternateTimeOffsetFixedSize + nn, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *AlternateTimeOffsetIndicatorTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.LengthField < alternateTimeOffsetFixedSize+1 || len(b) < tlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("not enough data to decode AlternateTimeOffsetIndicatorTLV")
+	}
+	t.KeyField = b[tlvHeadSize]
+	t.CurrentOffset = int32(binary.BigEndian.Uint32(b[tlvHeadSize+1:]))
+	t.JumpSeconds = int32(binary.BigEndian.Uint32(b[tlvHeadSize+5:]))
+	t.TimeOfNextJump = uint48(b[tlvHeadSize+9:])
+	t.DisplayName = ""
+	return t.DisplayName.UnmarshalBinary(b[tlvHeadSize+alternateTimeOffsetFixedSize : tlvHeadSize+int(t.LengthField)])
+}
+
+// AlternateTimeOffsets returns all ALTERNATE_TIME_OFFSET_INDICATOR TLVs of Announce message
+func (p *Announce) AlternateTimeOffsets() []*AlternateTimeOffsetIndicatorTLV {
+	var res []*AlternateTimeOffsetIndicatorTLV
+	for _, tlv := range p.TLVs {
+		if t, ok := tlv.(*AlternateTimeOffsetIndicatorTLV); ok {
+			res = append(res, t)
+		}
+	}
+	return res
+}
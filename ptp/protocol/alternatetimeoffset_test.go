@@ -0,0 +1,78 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlternateTimeOffsetIndicatorTLV(t *testing.T) {
+	next := time.Unix(1667098800, 0)
+	tlv := NewAlternateTimeOffsetIndicatorTLV(1, -7*time.Hour, time.Hour, next, "PDT")
+	require.Equal(t, uint16(20), tlv.LengthField)
+	require.Equal(t, -7*time.Hour, tlv.Offset())
+	require.Equal(t, next, tlv.NextJump())
+
+	b := make([]byte, 24)
+	n, err := tlv.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, []byte{
+		0x00, 0x09, 0x00, 0x14,
+		0x01,
+		0xff, 0xff, 0x9d, 0x90,
+		0x00, 0x00, 0x0e, 0x10,
+		0x00, 0x00, 0x63, 0x5d, 0xe8, 0xb0,
+		0x03, 'P', 'D', 'T', 0x00,
+	}, b[:n])
+
+	got := &AlternateTimeOffsetIndicatorTLV{}
+	err = got.UnmarshalBinary(b)
+	require.Nil(t, err)
+	require.Equal(t, tlv, got)
+
+	_, err = tlv.MarshalBinaryTo(b[:20])
+	require.Error(t, err)
+	err = got.UnmarshalBinary(b[:20])
+	require.Error(t, err)
+
+	tlv = NewAlternateTimeOffsetIndicatorTLV(2, 0, 0, time.Time{}, "UT")
+	require.Equal(t, uint16(18), tlv.LengthField)
+	require.True(t, tlv.NextJump().IsZero())
+	n, err = tlv.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, 22, n)
+}
+
+func TestAnnounceAlternateTimeOffsets(t *testing.T) {
+	p := testAnnounce()
+	require.Empty(t, p.AlternateTimeOffsets())
+
+	tlv := NewAlternateTimeOffsetIndicatorTLV(1, -7*time.Hour, time.Hour, time.Unix(1667098800, 0), "PDT")
+	p.TLVs = append(p.TLVs, tlv)
+	p.MessageLength += tlvHeadSize + tlv.LengthField
+	p.AppendPathTrace(42)
+
+	b, err := Bytes(p)
+	require.Nil(t, err)
+	got, err := DecodePacket(b)
+	require.Nil(t, err)
+	require.Equal(t, p, got)
+	require.Equal(t, []*AlternateTimeOffsetIndicatorTLV{tlv}, got.(*Announce).AlternateTimeOffsets())
+}
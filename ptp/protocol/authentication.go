@@ -0,0 +1,252 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have IEEE 1588-2019 16.14 AUTHENTICATION TLV support.
+// Only immediate security processing is supported, meaning there is no disclosedKey, sequenceNo and RES fields in the TLV.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// ErrNoAuthenticationTLV is returned when verified packet doesn't have AUTHENTICATION TLV
+var ErrNoAuthenticationTLV = errors.New("no AUTHENTICATION TLV found")
+
+// ErrICVMismatch is returned when ICV of AUTHENTICATION TLV doesn't match the packet
+var ErrICVMismatch = errors.New("AUTHENTICATION TLV ICV mismatch")
+
+// authTLVFixedSize is the size of AUTHENTICATION TLV without ICV
+const authTLVFixedSize = tlvHeadSize + 6
+
+// AuthKey is a key from security association used to calculate ICV
+type AuthKey struct {
+	// Hash is a hash function used by HMAC, for example sha256.New
+	Hash func() hash.Hash
+	Key  []byte
+	// ICVLength is the length HMAC is truncated to
+	ICVLength int
+}
+
+// NewAuthKey returns AuthKey with HMAC of hash function h truncated to icvLength
+func NewAuthKey(h func() hash.Hash, key []byte, icvLength int) (*AuthKey, error) {
+	k := &AuthKey{
+		Hash:      h,
+		Key:       key,
+		ICVLength: icvLength,
+	}
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// NewHMACSHA256Key returns HMAC-SHA256-128 AuthKey, which is the default one as per IEEE 1588-2019 Annex P
+func NewHMACSHA256Key(key []byte) *AuthKey {
+	return &AuthKey{
+		Hash:      sha256.New,
+		Key:       key,
+		ICVLength: 16,
+	}
+}
+
+// validate checks ICV can be calculated with the key
+func (k *AuthKey) validate() error {
+	if k.Hash == nil {
+		return fmt.Errorf("no hash function")
+	}
+	// The length of all TLVs shall be an even number of octets
+	if size := k.Hash().Size(); k.ICVLength <= 0 || k.ICVLength > size || k.ICVLength%2 != 0 {
+		return fmt.Errorf("unsupported ICV length %d, HMAC size is %d", k.ICVLength, size)
+	}
+	return nil
+}
+
+// icv calculates ICV of b. Key must be valid.
+func (k *AuthKey) icv(b []byte) []byte {
+	mac := hmac.New(k.Hash, k.Key)
+	mac.Write(b)
+	return mac.Sum(nil)[:k.ICVLength]
+}
+
+// KeyProvider provides keys for signing and verifying packets by security parameter pointer (SPP) and key ID
+type KeyProvider interface {
+	Key(spp uint8, keyID uint32) (*AuthKey, error)
+}
+
+// StaticKeyProvider is a KeyProvider with fixed set of keys, ignoring SPP
+type StaticKeyProvider map[uint32]*AuthKey
+
+// Key implements KeyProvider interface
+func (s StaticKeyProvider) Key(spp uint8, keyID uint32) (*AuthKey, error) {
+	k, ok := s[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %d", keyID)
+	}
+	return k, nil
+}
+
+// AuthenticationTLV Table 148 AUTHENTICATION TLV format
+type AuthenticationTLV struct {
+	TLVHead
+	SPP               uint8
+	SecParamIndicator uint8
+	KeyID             uint32
+	ICV               []byte
+}
+
+// MarshalBinaryTo marshals TLV into provided []byte
+func (t *AuthenticationTLV) MarshalBinaryTo(b []byte) (int, error) {
+	size := authTLVFixedSize + len(t.ICV)
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write AuthenticationTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	b[tlvHeadSize] = t.SPP
+	b[tlvHeadSize+1] = t.SecParamIndicator
+	binary.BigEndian.PutUint32(b[tlvHeadSize+2:], t.KeyID)
+	copy(b[authTLVFixedSize:], t.ICV)
+	return size, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields.
+// ICV points into provided []byte.
+func (t *AuthenticationTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.TLVType != TLVAuthentication {
+		return fmt.Errorf("not an AUTHENTICATION TLV: %s", t.TLVType)
+	}
+	if int(t.LengthField) < authTLVFixedSize-tlvHeadSize || len(b) < tlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("not enough data to decode AuthenticationTLV")
+	}
+	t.SPP = b[tlvHeadSize]
+	t.SecParamIndicator = b[tlvHeadSize+1]
+	if t.SecParamIndicator != 0 {
+		return fmt.Errorf("unsupported secParamIndicator %#x, only immediate security processing is supported", t.SecParamIndicator)
+	}
+	t.KeyID = binary.BigEndian.Uint32(b[tlvHeadSize+2:])
+	t.ICV = b[authTLVFixedSize : tlvHeadSize+int(t.LengthField)]
+	return nil
+}
+
+// tlvsOffset returns where TLVs start in the message of given type
+func tlvsOffset(msgType MessageType) (int, error) {
+	switch msgType {
+	case MessageSync, MessageDelayReq, MessageFollowUp:
+		return headerSize + 10, nil
+	case MessageDelayResp, MessagePDelayReq, MessagePDelayResp, MessagePDelayRespFollowUp:
+		return headerSize + 20, nil
+	case MessageAnnounce:
+		return headerSize + 30, nil
+	case MessageSignaling:
+		return headerSize + 10, nil
+	case MessageManagement:
+		return headerSize + 14, nil
+	}
+	return 0, fmt.Errorf("unsupported type %s", msgType)
+}
+
+// SignPacket appends AUTHENTICATION TLV to the packet marshalled into b[:n], using the key identified by spp and keyID.
+// It updates messageLength of the packet and returns new length of the packet.
+// It must be called after all other fields and TLVs of the packet are final.
+func SignPacket(b []byte, n int, spp uint8, keyID uint32, keys KeyProvider) (int, error) {
+	if n < headerSize {
+		return 0, fmt.Errorf("not enough data to sign packet")
+	}
+	if !VersionSupports(b[1], CapabilityAuthentication) {
+		return 0, fmt.Errorf("%s is not supported by PTP version %s", CapabilityAuthentication, VersionString(b[1]))
+	}
+	key, err := keys.Key(spp, keyID)
+	if err != nil {
+		return 0, err
+	}
+	if err := key.validate(); err != nil {
+		return 0, err
+	}
+	tlv := AuthenticationTLV{
+		TLVHead: TLVHead{
+			TLVType:     TLVAuthentication,
+			LengthField: uint16(authTLVFixedSize - tlvHeadSize + key.ICVLength),
+		},
+		SPP:   spp,
+		KeyID: keyID,
+	}
+	size := n + authTLVFixedSize + key.ICVLength
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write AuthenticationTLV")
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(size))
+	if _, err := tlv.MarshalBinaryTo(b[n:]); err != nil {
+		return 0, err
+	}
+	// ICV covers the whole message except ICV itself
+	copy(b[n+authTLVFixedSize:], key.icv(b[:n+authTLVFixedSize]))
+	return size, nil
+}
+
+// VerifyPacket finds AUTHENTICATION TLV in the packet and verifies its ICV using the key identified by TLV SPP and key ID.
+// It returns ErrNoAuthenticationTLV if there is no such TLV, and ErrICVMismatch if ICV is wrong.
+func VerifyPacket(b []byte, keys KeyProvider) (*AuthenticationTLV, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("not enough data to verify packet")
+	}
+	if !VersionSupports(b[1], CapabilityAuthentication) {
+		return nil, fmt.Errorf("%s is not supported by PTP version %s", CapabilityAuthentication, VersionString(b[1]))
+	}
+	msgType := SdoIDAndMsgType(b[0]).MsgType()
+	pos, err := tlvsOffset(msgType)
+	if err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length > len(b) {
+		return nil, fmt.Errorf("messageLength %d is bigger than packet size %d", length, len(b))
+	}
+	for pos+tlvHeadSize <= length {
+		tlvType := TLVType(binary.BigEndian.Uint16(b[pos:]))
+		tlvLength := int(binary.BigEndian.Uint16(b[pos+2:]))
+		if tlvType != TLVAuthentication {
+			pos += tlvHeadSize + tlvLength
+			continue
+		}
+		tlv := &AuthenticationTLV{}
+		if err := tlv.UnmarshalBinary(b[pos:length]); err != nil {
+			return nil, err
+		}
+		key, err := keys.Key(tlv.SPP, tlv.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		if err := key.validate(); err != nil {
+			return nil, err
+		}
+		if len(tlv.ICV) != key.ICVLength {
+			return nil, fmt.Errorf("ICV length %d doesn't match key ICV length %d", len(tlv.ICV), key.ICVLength)
+		}
+		if !hmac.Equal(tlv.ICV, key.icv(b[:pos+authTLVFixedSize])) {
+			return nil, ErrICVMismatch
+		}
+		return tlv, nil
+	}
+	return nil, ErrNoAuthenticationTLV
+}
@@ -0,0 +1,213 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testKeys = StaticKeyProvider{
+	1: NewHMACSHA256Key([]byte("secret")),
+	2: NewHMACSHA256Key([]byte("another secret")),
+}
+
+func testSync() *SyncDelayReq {
+	return &SyncDelayReq{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSync, 0),
+			Version:         Version,
+			MessageLength:   44,
+			SequenceID:      116,
+			SourcePortIdentity: PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: 36138748164966842,
+			},
+		},
+		SyncDelayReqBody: SyncDelayReqBody{
+			OriginTimestamp: NewTimestamp(time.Unix(1653574589, 806492928)),
+		},
+	}
+}
+
+func TestAuthenticationTLV(t *testing.T) {
+	tlv := &AuthenticationTLV{
+		TLVHead: TLVHead{
+			TLVType:     TLVAuthentication,
+			LengthField: 8,
+		},
+		SPP:   3,
+		KeyID: 42,
+		ICV:   []byte{0xca, 0xfe},
+	}
+	b := make([]byte, 12)
+	n, err := tlv.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, []byte{0x80, 0x09, 0x00, 0x08, 0x03, 0x00, 0x00, 0x00, 0x00, 0x2a, 0xca, 0xfe}, b[:n])
+
+	got := &AuthenticationTLV{}
+	err = got.UnmarshalBinary(b)
+	require.Nil(t, err)
+	require.Equal(t, tlv, got)
+
+	_, err = tlv.MarshalBinaryTo(b[:11])
+	require.Error(t, err)
+	err = got.UnmarshalBinary(b[:11])
+	require.Error(t, err)
+
+	// disclosedKey, sequenceNo and RES are not supported
+	b[5] = 0x7
+	err = got.UnmarshalBinary(b)
+	require.Error(t, err)
+
+	b[0] = 0
+	err = got.UnmarshalBinary(b)
+	require.Error(t, err)
+}
+
+func TestSignVerifyPacket(t *testing.T) {
+	b := make([]byte, 128)
+	n, err := testSync().MarshalBinaryTo(b)
+	require.Nil(t, err)
+
+	n, err = SignPacket(b, n, 0, 1, testKeys)
+	require.Nil(t, err)
+	require.Equal(t, 44+authTLVFixedSize+16, n)
+
+	tlv, err := VerifyPacket(b[:n], testKeys)
+	require.Nil(t, err)
+	require.Equal(t, uint32(1), tlv.KeyID)
+	require.Equal(t, uint16(6+16), tlv.LengthField)
+
+	// trailing bytes are ignored
+	tlv, err = VerifyPacket(b[:n+2], testKeys)
+	require.Nil(t, err)
+	require.Equal(t, uint32(1), tlv.KeyID)
+
+	// signed packet can be decoded as usual
+	p, err := DecodePacket(b[:n])
+	require.Nil(t, err)
+	want := testSync()
+	want.MessageLength = uint16(n)
+	require.Equal(t, want, p)
+
+	// wrong key
+	_, err = VerifyPacket(b[:n], StaticKeyProvider{1: testKeys[2]})
+	require.ErrorIs(t, err, ErrICVMismatch)
+
+	// unknown key
+	_, err = VerifyPacket(b[:n], StaticKeyProvider{})
+	require.Error(t, err)
+
+	// tampered packet
+	b[30]++
+	_, err = VerifyPacket(b[:n], testKeys)
+	require.ErrorIs(t, err, ErrICVMismatch)
+}
+
+func TestSignVerifySignaling(t *testing.T) {
+	p := &Signaling{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSignaling, 0),
+			Version:         Version,
+			MessageLength:   54,
+			FlagField:       FlagUnicast,
+		},
+		TargetPortIdentity: PortIdentity{
+			PortNumber:    0xffff,
+			ClockIdentity: 0xffffffffffffffff,
+		},
+		TLVs: []TLV{
+			&RequestUnicastTransmissionTLV{
+				TLVHead: TLVHead{
+					TLVType:     TLVRequestUnicastTransmission,
+					LengthField: 6,
+				},
+				MsgTypeAndReserved:    NewUnicastMsgTypeAndFlags(MessageAnnounce, 0),
+				LogInterMessagePeriod: 1,
+				DurationField:         60,
+			},
+		},
+	}
+	b := make([]byte, 128)
+	n, err := p.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	n, err = SignPacket(b, n, 0, 2, testKeys)
+	require.Nil(t, err)
+
+	tlv, err := VerifyPacket(b[:n], testKeys)
+	require.Nil(t, err)
+	require.Equal(t, uint32(2), tlv.KeyID)
+
+	got := &Signaling{}
+	err = got.UnmarshalBinary(b[:n])
+	require.Nil(t, err)
+	require.Len(t, got.TLVs, 2)
+	require.Equal(t, tlv, got.TLVs[1])
+}
+
+func TestVerifyPacketNoTLV(t *testing.T) {
+	b := make([]byte, 128)
+	n, err := testSync().MarshalBinaryTo(b)
+	require.Nil(t, err)
+	_, err = VerifyPacket(b[:n], testKeys)
+	require.ErrorIs(t, err, ErrNoAuthenticationTLV)
+
+	_, err = VerifyPacket(b[:10], testKeys)
+	require.Error(t, err)
+
+	_, err = SignPacket(b, n, 0, 42, testKeys)
+	require.Error(t, err)
+
+	_, err = SignPacket(b[:n+10], n, 0, 1, testKeys)
+	require.Error(t, err)
+}
+
+func TestNewAuthKey(t *testing.T) {
+	k, err := NewAuthKey(sha256.New, []byte("secret"), 32)
+	require.Nil(t, err)
+	require.Equal(t, 32, k.ICVLength)
+
+	for _, l := range []int{-2, 0, 15, 34} {
+		_, err = NewAuthKey(sha256.New, []byte("secret"), l)
+		require.Error(t, err, l)
+	}
+	_, err = NewAuthKey(nil, []byte("secret"), 16)
+	require.Error(t, err)
+}
+
+func TestSignVerifyPacketInvalidKey(t *testing.T) {
+	b := make([]byte, 128)
+	n, err := testSync().MarshalBinaryTo(b)
+	require.Nil(t, err)
+	n, err = SignPacket(b, n, 0, 1, testKeys)
+	require.Nil(t, err)
+
+	for _, l := range []int{-16, 64} {
+		keys := StaticKeyProvider{1: &AuthKey{Hash: sha256.New, Key: []byte("secret"), ICVLength: l}}
+		u := make([]byte, 256)
+		m, err := testSync().MarshalBinaryTo(u)
+		require.Nil(t, err)
+		_, err = SignPacket(u, m, 0, 1, keys)
+		require.Contains(t, err.Error(), "unsupported ICV length", l)
+		_, err = VerifyPacket(b[:n], keys)
+		require.Contains(t, err.Error(), "unsupported ICV length", l)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// controlField values for PTPv1 hardware compatibility, IEEE 1588-2019 Table 42
+var controlField = map[MessageType]uint8{
+	MessageSync:      0,
+	MessageDelayReq:  1,
+	MessageFollowUp:  2,
+	MessageDelayResp: 3,
+}
+
+// controlFieldOther is controlField value for all other message types
+const controlFieldOther uint8 = 5
+
+// Builder constructs PTP messages with consistent header fields
+// and keeps separate auto-incrementing sequence IDs per message type.
+// Builder is not safe for concurrent use.
+type Builder struct {
+	SourcePortIdentity PortIdentity
+	DomainNumber       uint8
+	// Version is versionPTP to emit, zero value means Version
+	Version uint8
+	// Unicast sets FlagUnicast on all messages
+	Unicast bool
+	// TwoStep sets FlagTwoStep on Sync messages
+	TwoStep bool
+	// PTPTimescale sets FlagPTPTimescale on Announce messages
+	PTPTimescale bool
+
+	sequenceIDs [16]uint16
+}
+
+// NewBuilder returns new Builder of messages originating from the given port
+func NewBuilder(source PortIdentity, domain uint8) *Builder {
+	return &Builder{
+		SourcePortIdentity: source,
+		DomainNumber:       domain,
+	}
+}
+
+// NextSequenceID returns sequence ID to be used by the next message of the type, and advances the counter
+func (b *Builder) NextSequenceID(msgType MessageType) uint16 {
+	seq := b.sequenceIDs[msgType&0x0f]
+	b.sequenceIDs[msgType&0x0f]++
+	return seq
+}
+
+// SetSequenceID sets sequence ID to be used by the next message of the type
+func (b *Builder) SetSequenceID(msgType MessageType, seq uint16) {
+	b.sequenceIDs[msgType&0x0f] = seq
+}
+
+// Header returns header for the message of given type and total length, without consuming sequence ID
+func (b *Builder) Header(msgType MessageType, length int, interval LogInterval) Header {
+	version := b.Version
+	if version == 0 {
+		version = Version
+	}
+	var flags uint16
+	if b.Unicast {
+		flags |= FlagUnicast
+	}
+	switch msgType {
+	case MessageSync:
+		if b.TwoStep {
+			flags |= FlagTwoStep
+		}
+	case MessageAnnounce:
+		if b.PTPTimescale {
+			flags |= FlagPTPTimescale
+		}
+	}
+	control, ok := controlField[msgType]
+	if !ok {
+		control = controlFieldOther
+	}
+	return Header{
+		SdoIDAndMsgType:    NewSdoIDAndMsgType(msgType, 0),
+		Version:            version,
+		MessageLength:      uint16(length),
+		DomainNumber:       b.DomainNumber,
+		FlagField:          flags,
+		SourcePortIdentity: b.SourcePortIdentity,
+		ControlField:       control,
+		LogMessageInterval: interval,
+	}
+}
+
+// Sync returns new Sync message with next sequence ID and origin timestamp ts
+func (b *Builder) Sync(interval LogInterval, ts time.Time) *SyncDelayReq {
+	p := &SyncDelayReq{
+		Header: b.Header(MessageSync, binary.Size(SyncDelayReq{}), interval),
+	}
+	p.SequenceID = b.NextSequenceID(MessageSync)
+	if !ts.IsZero() {
+		p.OriginTimestamp = NewTimestamp(ts)
+	}
+	return p
+}
+
+// DelayReq returns new Delay_Req message with next sequence ID
+func (b *Builder) DelayReq() *SyncDelayReq {
+	p := &SyncDelayReq{
+		Header: b.Header(MessageDelayReq, binary.Size(SyncDelayReq{}), 0x7f),
+	}
+	p.SequenceID = b.NextSequenceID(MessageDelayReq)
+	return p
+}
+
+// FollowUp returns Follow_Up message for the Sync message with sequence ID seq, sent at ts
+func (b *Builder) FollowUp(seq uint16, interval LogInterval, ts time.Time) *FollowUp {
+	p := &FollowUp{
+		Header: b.Header(MessageFollowUp, binary.Size(FollowUp{}), interval),
+		FollowUpBody: FollowUpBody{
+			PreciseOriginTimestamp: NewTimestamp(ts),
+		},
+	}
+	p.SequenceID = seq
+	return p
+}
+
+// Announce returns new Announce message with next sequence ID
+func (b *Builder) Announce(interval LogInterval, body AnnounceBody) *Announce {
+	p := &Announce{
+		Header:       b.Header(MessageAnnounce, headerSize+binary.Size(AnnounceBody{}), interval),
+		AnnounceBody: body,
+	}
+	p.SequenceID = b.NextSequenceID(MessageAnnounce)
+	return p
+}
+
+// DelayResp returns Delay_Resp message answering the Delay_Req with header req, received at ts
+func (b *Builder) DelayResp(req *Header, ts time.Time) *DelayResp {
+	p := &DelayResp{
+		Header: b.Header(MessageDelayResp, binary.Size(DelayResp{}), 0x7f),
+		DelayRespBody: DelayRespBody{
+			ReceiveTimestamp:       NewTimestamp(ts),
+			RequestingPortIdentity: req.SourcePortIdentity,
+		},
+	}
+	p.SequenceID = req.SequenceID
+	p.CorrectionField = req.CorrectionField
+	return p
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderSequence(t *testing.T) {
+	b := NewBuilder(PortIdentity{PortNumber: 1, ClockIdentity: 1234}, 24)
+	require.Equal(t, uint16(0), b.Sync(0, time.Time{}).SequenceID)
+	require.Equal(t, uint16(1), b.Sync(0, time.Time{}).SequenceID)
+	// sequence IDs are per message type
+	require.Equal(t, uint16(0), b.Announce(1, AnnounceBody{}).SequenceID)
+	require.Equal(t, uint16(0), b.DelayReq().SequenceID)
+
+	b.SetSequenceID(MessageSync, 0xffff)
+	require.Equal(t, uint16(0xffff), b.Sync(0, time.Time{}).SequenceID)
+	require.Equal(t, uint16(0), b.NextSequenceID(MessageSync))
+}
+
+func TestBuilderMessages(t *testing.T) {
+	source := PortIdentity{PortNumber: 1, ClockIdentity: 1234}
+	b := NewBuilder(source, 24)
+	b.Unicast = true
+	b.TwoStep = true
+	b.PTPTimescale = true
+	now := time.Unix(1653574589, 806492928)
+
+	sync := b.Sync(-3, now)
+	require.Equal(t, MessageSync, sync.MessageType())
+	require.Equal(t, Version, sync.Version)
+	require.Equal(t, uint8(24), sync.DomainNumber)
+	require.Equal(t, FlagUnicast|FlagTwoStep, sync.FlagField)
+	require.Equal(t, source, sync.SourcePortIdentity)
+	require.Equal(t, uint8(0), sync.ControlField)
+	require.Equal(t, LogInterval(-3), sync.LogMessageInterval)
+	require.Equal(t, NewTimestamp(now), sync.OriginTimestamp)
+	bytes, err := Bytes(sync)
+	require.Nil(t, err)
+	require.Equal(t, int(sync.MessageLength)+2, len(bytes))
+
+	fup := b.FollowUp(sync.SequenceID, -3, now)
+	require.Equal(t, MessageFollowUp, fup.MessageType())
+	require.Equal(t, sync.SequenceID, fup.SequenceID)
+	require.Equal(t, FlagUnicast, fup.FlagField)
+	require.Equal(t, uint8(2), fup.ControlField)
+	require.Equal(t, NewTimestamp(now), fup.PreciseOriginTimestamp)
+
+	announce := b.Announce(1, AnnounceBody{GrandmasterIdentity: 1234})
+	require.Equal(t, MessageAnnounce, announce.MessageType())
+	require.Equal(t, FlagUnicast|FlagPTPTimescale, announce.FlagField)
+	require.Equal(t, uint8(5), announce.ControlField)
+	require.Equal(t, ClockIdentity(1234), announce.GrandmasterIdentity)
+	bytes, err = Bytes(announce)
+	require.Nil(t, err)
+	require.Equal(t, int(announce.MessageLength)+2, len(bytes))
+
+	req := &Header{
+		SequenceID:         42,
+		CorrectionField:    NewCorrection(100),
+		SourcePortIdentity: PortIdentity{PortNumber: 1, ClockIdentity: 5678},
+	}
+	resp := b.DelayResp(req, now)
+	require.Equal(t, MessageDelayResp, resp.MessageType())
+	require.Equal(t, uint16(42), resp.SequenceID)
+	require.Equal(t, req.CorrectionField, resp.CorrectionField)
+	require.Equal(t, req.SourcePortIdentity, resp.RequestingPortIdentity)
+	require.Equal(t, NewTimestamp(now), resp.ReceiveTimestamp)
+	require.Equal(t, uint8(3), resp.ControlField)
+
+	b.Version = MajorVersion
+	dreq := b.DelayReq()
+	require.Equal(t, MessageDelayReq, dreq.MessageType())
+	require.Equal(t, MajorVersion, dreq.Version)
+	require.Equal(t, uint8(1), dreq.ControlField)
+	require.Equal(t, LogInterval(0x7f), dreq.LogMessageInterval)
+}
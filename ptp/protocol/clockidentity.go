@@ -0,0 +1,125 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have helpers to construct, parse and explain ClockIdentity for diagnostics
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ouiVendors maps IEEE OUI to vendor name, used by ClockIdentity.Vendor.
+// It only has a few vendors commonly seen in PTP networks, use RegisterVendor to add more.
+var ouiVendors = struct {
+	sync.RWMutex
+	m map[[3]byte]string
+}{
+	m: map[[3]byte]string{
+		{0x00, 0x1b, 0x21}: "Intel",
+		{0xa0, 0x36, 0x9f}: "Intel",
+		{0x0c, 0x42, 0xa1}: "Mellanox",
+		{0x98, 0x03, 0x9b}: "Mellanox",
+		{0xb8, 0x59, 0x9f}: "Mellanox",
+		{0x00, 0x80, 0x63}: "Hirschmann",
+	},
+}
+
+// RegisterVendor adds vendor name for the OUI, overriding existing one
+func RegisterVendor(oui [3]byte, name string) {
+	ouiVendors.Lock()
+	defer ouiVendors.Unlock()
+	ouiVendors.m[oui] = name
+}
+
+// UnregisterVendor removes vendor name of the OUI
+func UnregisterVendor(oui [3]byte) {
+	ouiVendors.Lock()
+	defer ouiVendors.Unlock()
+	delete(ouiVendors.m, oui)
+}
+
+// ParseClockIdentity parses ClockIdentity from the format ptp4l pmc client uses, like "aabbcc.fffe.ddeeff"
+func ParseClockIdentity(s string) (ClockIdentity, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 || len(parts[0]) != 6 || len(parts[1]) != 4 || len(parts[2]) != 6 {
+		return 0, fmt.Errorf("malformed ClockIdentity %q, must be like aabbcc.fffe.ddeeff", s)
+	}
+	v, err := strconv.ParseUint(strings.Join(parts, ""), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing ClockIdentity %q: %w", s, err)
+	}
+	return ClockIdentity(v), nil
+}
+
+// ParsePortIdentity parses PortIdentity from the format ptp4l pmc client uses, like "aabbcc.fffe.ddeeff-1"
+func ParsePortIdentity(s string) (PortIdentity, error) {
+	i := strings.LastIndex(s, "-")
+	if i < 0 {
+		return PortIdentity{}, fmt.Errorf("malformed PortIdentity %q, must be like aabbcc.fffe.ddeeff-1", s)
+	}
+	c, err := ParseClockIdentity(s[:i])
+	if err != nil {
+		return PortIdentity{}, err
+	}
+	port, err := strconv.ParseUint(s[i+1:], 10, 16)
+	if err != nil {
+		return PortIdentity{}, fmt.Errorf("parsing port number of PortIdentity %q: %w", s, err)
+	}
+	return PortIdentity{ClockIdentity: c, PortNumber: uint16(port)}, nil
+}
+
+// MAC returns EUI-48 MAC address ClockIdentity was built from by NewClockIdentity.
+// If ClockIdentity doesn't look like one built from EUI-48, it's returned as EUI-64.
+func (c ClockIdentity) MAC() net.HardwareAddr {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(c))
+	if b[3] == 0xFF && b[4] == 0xFE {
+		return net.HardwareAddr{b[0], b[1], b[2], b[5], b[6], b[7]}
+	}
+	return net.HardwareAddr(b)
+}
+
+// OUI returns the Organizationally Unique Identifier part of ClockIdentity
+func (c ClockIdentity) OUI() [3]byte {
+	return [3]byte{byte(c >> 56), byte(c >> 48), byte(c >> 40)}
+}
+
+// Vendor returns name of the vendor ClockIdentity belongs to, if known
+func (c ClockIdentity) Vendor() string {
+	ouiVendors.RLock()
+	defer ouiVendors.RUnlock()
+	return ouiVendors.m[c.OUI()]
+}
+
+// Interface returns local network interface ClockIdentity was built from, if there is one
+func (c ClockIdentity) Interface() (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		if cid, err := NewClockIdentity(ifaces[i].HardwareAddr); err == nil && cid == c {
+			return &ifaces[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no local interface with ClockIdentity %s", c)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClockIdentity(t *testing.T) {
+	got, err := ParseClockIdentity("0c42a1.fffe.6d7ca6")
+	require.Nil(t, err)
+	require.Equal(t, ClockIdentity(0xc42a1fffe6d7ca6), got)
+
+	for _, s := range []string{"", "0c42a1fffe6d7ca6", "0c42a1.fffe.6d7ca", "0c42a1.fffe.6d7cz6", "0c42a1.ff.fe6d7ca6"} {
+		_, err := ParseClockIdentity(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestParsePortIdentity(t *testing.T) {
+	want := PortIdentity{ClockIdentity: 0xc42a1fffe6d7ca6, PortNumber: 42}
+	got, err := ParsePortIdentity(want.String())
+	require.Nil(t, err)
+	require.Equal(t, want, got)
+
+	for _, s := range []string{"0c42a1.fffe.6d7ca6", "0c42a1.fffe.6d7ca6-", "0c42a1.fffe.6d7ca6-70000", "nope-1"} {
+		_, err := ParsePortIdentity(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestClockIdentityMAC(t *testing.T) {
+	mac, err := net.ParseMAC("0c:42:a1:6d:7c:a6")
+	require.Nil(t, err)
+	c, err := NewClockIdentity(mac)
+	require.Nil(t, err)
+	require.Equal(t, mac, c.MAC())
+	require.Equal(t, [3]byte{0x0c, 0x42, 0xa1}, c.OUI())
+	require.Equal(t, "Mellanox", c.Vendor())
+
+	eui64, err := net.ParseMAC("00:80:63:ff:ff:00:09:ba")
+	require.Nil(t, err)
+	c, err = NewClockIdentity(eui64)
+	require.Nil(t, err)
+	require.Equal(t, eui64, c.MAC())
+	require.Equal(t, "Hirschmann", c.Vendor())
+
+	require.Equal(t, "", ClockIdentity(0x1122334455667788).Vendor())
+	RegisterVendor([3]byte{0x11, 0x22, 0x33}, "Test")
+	require.Equal(t, "Test", ClockIdentity(0x1122334455667788).Vendor())
+	UnregisterVendor([3]byte{0x11, 0x22, 0x33})
+	require.Equal(t, "", ClockIdentity(0x1122334455667788).Vendor())
+}
+
+func TestClockIdentityInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	require.Nil(t, err)
+	for _, iface := range ifaces {
+		c, err := NewClockIdentity(iface.HardwareAddr)
+		if err != nil {
+			continue
+		}
+		got, err := c.Interface()
+		require.Nil(t, err)
+		require.Equal(t, iface.HardwareAddr, got.HardwareAddr)
+	}
+	_, err = ClockIdentity(0x1122334455667788).Interface()
+	require.Error(t, err)
+}
+
+func TestRegisterVendorConcurrent(t *testing.T) {
+	oui := [3]byte{0x11, 0x22, 0x34}
+	defer UnregisterVendor(oui)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterVendor(oui, "Test")
+		}()
+		go func() {
+			defer wg.Done()
+			ClockIdentity(0x1122344455667788).Vendor()
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, "Test", ClockIdentity(0x1122344455667788).Vendor())
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have correctionField arithmetic needed by transparent and boundary clocks.
+// All values are kept in 2**-16 ns units, so sub-nanosecond precision is never lost in between.
+
+import (
+	"math"
+	"time"
+)
+
+// CorrectionTooBig is the special Correction value indicating the correction is too big to be represented
+const CorrectionTooBig Correction = math.MaxInt64
+
+// NewCorrectionFromDuration returns Correction built from time.Duration.
+// Durations which can't be represented result in CorrectionTooBig.
+func NewCorrectionFromDuration(d time.Duration) Correction {
+	if d > math.MaxInt64/twoPow16 || d < math.MinInt64/twoPow16 {
+		return CorrectionTooBig
+	}
+	return Correction(d * twoPow16)
+}
+
+// Duration returns Correction as time.Duration, sub-nanosecond part is truncated
+func (t Correction) Duration() time.Duration {
+	return time.Duration(t / twoPow16)
+}
+
+// Add returns t + c.
+// If either of operands is too big or the sum overflows, result is CorrectionTooBig.
+func (t Correction) Add(c Correction) Correction {
+	if t.TooBig() || c.TooBig() {
+		return CorrectionTooBig
+	}
+	s := t + c
+	// overflow happens only if both operands have the same sign and the sum has a different one
+	if (t < 0) == (c < 0) && (s < 0) != (t < 0) {
+		return CorrectionTooBig
+	}
+	return s
+}
+
+// Sub returns t - c.
+// If either of operands is too big or the difference overflows, result is CorrectionTooBig.
+func (t Correction) Sub(c Correction) Correction {
+	if t.TooBig() || c.TooBig() {
+		return CorrectionTooBig
+	}
+	s := t - c
+	// overflow happens only if operands have different signs and the difference has the sign of c
+	if (t < 0) != (c < 0) && (s < 0) == (c < 0) {
+		return CorrectionTooBig
+	}
+	return s
+}
+
+// AddDuration returns t with time.Duration added
+func (t Correction) AddDuration(d time.Duration) Correction {
+	return t.Add(NewCorrectionFromDuration(d))
+}
+
+// AddTimeInterval returns t with TimeInterval added, keeping sub-nanosecond part
+func (t Correction) AddTimeInterval(i TimeInterval) Correction {
+	return t.Add(Correction(i))
+}
+
+// AddResidenceTime returns t with residence time of the message in the PTP Instance,
+// measured between its ingress and egress timestamps, added as per 10.2.2.
+func (t Correction) AddResidenceTime(ingress, egress time.Time) Correction {
+	return t.AddDuration(egress.Sub(ingress))
+}
+
+// AddAsymmetry returns t corrected for delayAsymmetry of the link as per 16.8.
+// Asymmetry is added to Sync and Pdelay_Resp on ingress and subtracted from Delay_Req and Pdelay_Req on egress,
+// other messages are not affected.
+func (t Correction) AddAsymmetry(msgType MessageType, asymmetry TimeInterval) Correction {
+	switch msgType {
+	case MessageSync, MessagePDelayResp:
+		return t.Add(Correction(asymmetry))
+	case MessageDelayReq, MessagePDelayReq:
+		return t.Sub(Correction(asymmetry))
+	}
+	return t
+}
+
+// AddCorrection adds c to CorrectionField of the header
+func (p *Header) AddCorrection(c Correction) {
+	p.CorrectionField = p.CorrectionField.Add(c)
+}
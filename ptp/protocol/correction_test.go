@@ -0,0 +1,78 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCorrectionFromDuration(t *testing.T) {
+	require.Equal(t, NewCorrection(1500), NewCorrectionFromDuration(1500*time.Nanosecond))
+	require.Equal(t, NewCorrection(-20), NewCorrectionFromDuration(-20*time.Nanosecond))
+	require.Equal(t, 1500*time.Nanosecond, NewCorrectionFromDuration(1500*time.Nanosecond).Duration())
+	require.True(t, NewCorrectionFromDuration(48*time.Hour).TooBig())
+	require.True(t, NewCorrectionFromDuration(-48*time.Hour).TooBig())
+}
+
+func TestCorrectionAdd(t *testing.T) {
+	require.Equal(t, NewCorrection(3.5), NewCorrection(1.25).Add(NewCorrection(2.25)))
+	require.Equal(t, NewCorrection(-1), NewCorrection(1.25).Add(NewCorrection(-2.25)))
+	require.True(t, CorrectionTooBig.Add(NewCorrection(-1)).TooBig())
+	require.True(t, NewCorrection(1).Add(CorrectionTooBig).TooBig())
+	require.True(t, Correction(math.MaxInt64-10).Add(Correction(20)).TooBig())
+	require.True(t, Correction(math.MinInt64+10).Add(Correction(-20)).TooBig())
+}
+
+func TestCorrectionSub(t *testing.T) {
+	require.Equal(t, NewCorrection(-1), NewCorrection(1.25).Sub(NewCorrection(2.25)))
+	require.Equal(t, NewCorrection(3.5), NewCorrection(1.25).Sub(NewCorrection(-2.25)))
+	require.True(t, CorrectionTooBig.Sub(NewCorrection(1)).TooBig())
+	require.True(t, NewCorrection(1).Sub(Correction(math.MinInt64)).TooBig())
+	require.True(t, Correction(math.MinInt64+10).Sub(Correction(20)).TooBig())
+	require.Equal(t, Correction(math.MinInt64+11), Correction(-10).Sub(Correction(math.MaxInt64-20)))
+}
+
+func TestCorrectionAddResidenceTime(t *testing.T) {
+	ingress := time.Unix(1653574589, 806492928)
+	egress := ingress.Add(2345 * time.Nanosecond)
+	c := NewCorrection(0.5).AddResidenceTime(ingress, egress)
+	require.Equal(t, NewCorrection(2345.5), c)
+	require.InDelta(t, 2345.5, c.Nanoseconds(), 0.00001)
+
+	// sub-nanosecond part of TimeInterval is kept
+	c = c.AddTimeInterval(NewTimeInterval(0.25))
+	require.Equal(t, NewCorrection(2345.75), c)
+
+	h := Header{}
+	h.AddCorrection(c)
+	h.AddCorrection(NewCorrectionFromDuration(time.Microsecond))
+	require.Equal(t, NewCorrection(3345.75), h.CorrectionField)
+}
+
+func TestCorrectionAddAsymmetry(t *testing.T) {
+	asymmetry := NewTimeInterval(10.5)
+	c := NewCorrection(100)
+	require.Equal(t, NewCorrection(110.5), c.AddAsymmetry(MessageSync, asymmetry))
+	require.Equal(t, NewCorrection(110.5), c.AddAsymmetry(MessagePDelayResp, asymmetry))
+	require.Equal(t, NewCorrection(89.5), c.AddAsymmetry(MessageDelayReq, asymmetry))
+	require.Equal(t, NewCorrection(89.5), c.AddAsymmetry(MessagePDelayReq, asymmetry))
+	require.Equal(t, c, c.AddAsymmetry(MessageAnnounce, asymmetry))
+}
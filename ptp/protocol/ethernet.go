@@ -0,0 +1,153 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have helpers for PTP over IEEE 802.3 (layer 2) transport as per IEEE 1588-2019 Annex E
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// EtherType is the EtherType of PTP over IEEE 802.3
+const EtherType uint16 = 0x88F7
+
+// etherTypeVLAN is the EtherType of IEEE 802.1Q tag
+const etherTypeVLAN uint16 = 0x8100
+
+// EthernetHeaderSize is the size of untagged ethernet frame header
+const EthernetHeaderSize = 14
+
+// EthernetHeader is an ethernet frame header preceding PTP message
+type EthernetHeader struct {
+	Destination net.HardwareAddr
+	Source      net.HardwareAddr
+	// VLAN is the IEEE 802.1Q tag control information, only used if HasVLAN is set
+	VLAN      uint16
+	HasVLAN   bool
+	EtherType uint16
+}
+
+// MarshalBinaryTo marshals ethernet header into provided []byte
+func (h *EthernetHeader) MarshalBinaryTo(b []byte) (int, error) {
+	size := EthernetHeaderSize
+	if h.HasVLAN {
+		size += 4
+	}
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write EthernetHeader")
+	}
+	if len(h.Destination) != 6 || len(h.Source) != 6 {
+		return 0, fmt.Errorf("unsupported MAC address length")
+	}
+	copy(b, h.Destination)
+	copy(b[6:], h.Source)
+	n := 12
+	if h.HasVLAN {
+		binary.BigEndian.PutUint16(b[n:], etherTypeVLAN)
+		binary.BigEndian.PutUint16(b[n+2:], h.VLAN)
+		n += 4
+	}
+	binary.BigEndian.PutUint16(b[n:], h.EtherType)
+	return n + 2, nil
+}
+
+// UnmarshalBinary parses ethernet header from []byte.
+// Destination and Source point into provided []byte.
+func (h *EthernetHeader) UnmarshalBinary(b []byte) error {
+	_, err := h.unmarshal(b)
+	return err
+}
+
+// unmarshal parses ethernet header and returns its size
+func (h *EthernetHeader) unmarshal(b []byte) (int, error) {
+	if len(b) < EthernetHeaderSize {
+		return 0, fmt.Errorf("not enough data to decode EthernetHeader")
+	}
+	h.Destination = net.HardwareAddr(b[0:6:6])
+	h.Source = net.HardwareAddr(b[6:12:12])
+	h.EtherType = binary.BigEndian.Uint16(b[12:])
+	h.HasVLAN = false
+	h.VLAN = 0
+	if h.EtherType != etherTypeVLAN {
+		return EthernetHeaderSize, nil
+	}
+	if len(b) < EthernetHeaderSize+4 {
+		return 0, fmt.Errorf("not enough data to decode VLAN tagged EthernetHeader")
+	}
+	h.HasVLAN = true
+	h.VLAN = binary.BigEndian.Uint16(b[14:])
+	h.EtherType = binary.BigEndian.Uint16(b[16:])
+	return EthernetHeaderSize + 4, nil
+}
+
+// EthernetBytesTo marshals packet into provided []byte as ethernet frame sent from src MAC address.
+// Destination is the multicast MAC address matching the message type.
+func EthernetBytesTo(p Packet, src net.HardwareAddr, buf []byte) (int, error) {
+	h := EthernetHeader{
+		Destination: MulticastDestination(p.MessageType()),
+		Source:      src,
+		EtherType:   EtherType,
+	}
+	n, err := h.MarshalBinaryTo(buf)
+	if err != nil {
+		return 0, err
+	}
+	// interface smuggling
+	if pp, ok := p.(BinaryMarshalerTo); ok {
+		nn, err := pp.MarshalBinaryTo(buf[n:])
+		if err != nil {
+			return 0, err
+		}
+		return n + nn, nil
+	}
+	b, err := Bytes(p)
+	if err != nil {
+		return 0, err
+	}
+	// no need for extra two bytes on L2
+	b = b[:len(b)-2]
+	if len(buf[n:]) < len(b) {
+		return 0, fmt.Errorf("not enough buffer to write %s", p.MessageType())
+	}
+	return n + copy(buf[n:], b), nil
+}
+
+// DecodeEthernetPacket decodes ethernet frame carrying PTPv2 packet.
+// Ethernet padding after the PTP message is ignored.
+func DecodeEthernetPacket(b []byte) (Packet, *EthernetHeader, error) {
+	h := &EthernetHeader{}
+	n, err := h.unmarshal(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.EtherType != EtherType {
+		return nil, nil, fmt.Errorf("unexpected EtherType %#04x", h.EtherType)
+	}
+	payload := b[n:]
+	if len(payload) >= 4 {
+		if l := int(binary.BigEndian.Uint16(payload[2:])); l >= headerSize && l < len(payload) {
+			payload = payload[:l]
+		}
+	}
+	p, err := DecodePacket(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, h, nil
+}
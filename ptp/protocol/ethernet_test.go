@@ -0,0 +1,122 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthernetHeader(t *testing.T) {
+	src := net.HardwareAddr{0x0c, 0x42, 0xa1, 0x01, 0x02, 0x03}
+	h := EthernetHeader{
+		Destination: MulticastMAC,
+		Source:      src,
+		EtherType:   EtherType,
+	}
+	b := make([]byte, 18)
+	n, err := h.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, EthernetHeaderSize, n)
+	require.Equal(t, []byte{0x01, 0x1B, 0x19, 0x00, 0x00, 0x00, 0x0c, 0x42, 0xa1, 0x01, 0x02, 0x03, 0x88, 0xf7}, b[:n])
+
+	got := EthernetHeader{}
+	err = got.UnmarshalBinary(b[:n])
+	require.Nil(t, err)
+	require.Equal(t, h, got)
+
+	h.HasVLAN = true
+	h.VLAN = 42
+	n, err = h.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, 18, n)
+	require.Equal(t, []byte{0x81, 0x00, 0x00, 0x2a, 0x88, 0xf7}, b[12:n])
+	err = got.UnmarshalBinary(b[:n])
+	require.Nil(t, err)
+	require.Equal(t, h, got)
+
+	_, err = h.MarshalBinaryTo(b[:EthernetHeaderSize])
+	require.Error(t, err)
+	err = got.UnmarshalBinary(b[:EthernetHeaderSize])
+	require.Error(t, err)
+	err = got.UnmarshalBinary(b[:10])
+	require.Error(t, err)
+}
+
+func TestEthernetBytesTo(t *testing.T) {
+	src := net.HardwareAddr{0x0c, 0x42, 0xa1, 0x01, 0x02, 0x03}
+	sync := &SyncDelayReq{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSync, 0),
+			Version:         Version,
+			MessageLength:   44,
+			SequenceID:      116,
+			SourcePortIdentity: PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: 36138748164966842,
+			},
+		},
+		SyncDelayReqBody: SyncDelayReqBody{
+			OriginTimestamp: NewTimestamp(time.Unix(1653574589, 806492928)),
+		},
+	}
+	buf := make([]byte, 128)
+	n, err := EthernetBytesTo(sync, src, buf)
+	require.Nil(t, err)
+	require.Equal(t, EthernetHeaderSize+44, n)
+
+	// pad the frame to minimal ethernet frame size
+	frame := make([]byte, 60)
+	copy(frame, buf[:n])
+	p, h, err := DecodeEthernetPacket(frame)
+	require.Nil(t, err)
+	require.Equal(t, MulticastMAC, h.Destination)
+	require.Equal(t, src, h.Source)
+	require.Equal(t, sync, p)
+
+	pdelay := &PDelayReq{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessagePDelayReq, 0),
+			Version:         Version,
+			MessageLength:   54,
+			SequenceID:      1,
+		},
+	}
+	n, err = EthernetBytesTo(pdelay, src, buf)
+	require.Nil(t, err)
+	require.Equal(t, EthernetHeaderSize+54, n)
+	p, h, err = DecodeEthernetPacket(buf[:n])
+	require.Nil(t, err)
+	require.Equal(t, PeerDelayMulticastMAC, h.Destination)
+	require.Equal(t, pdelay, p)
+
+	_, err = EthernetBytesTo(pdelay, src, buf[:EthernetHeaderSize+10])
+	require.Error(t, err)
+}
+
+func TestDecodeEthernetPacketWrongEtherType(t *testing.T) {
+	frame := make([]byte, 60)
+	frame[12] = 0x08
+	_, _, err := DecodeEthernetPacket(frame)
+	require.Error(t, err)
+
+	_, _, err = DecodeEthernetPacket(frame[:10])
+	require.Error(t, err)
+}
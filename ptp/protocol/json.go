@@ -0,0 +1,503 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have JSON marshalling of PTP messages and TLVs meant for capture and analysis tools.
+// Enums are represented by their names (or numbers if the value is unknown), identities are formatted same way ptp4l pmc client does,
+// timestamps are represented as RFC3339 time and time intervals as nanoseconds.
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// enumMarshalJSON marshals enum as its name, or as a number if the name is unknown
+func enumMarshalJSON(name string, v uint64) ([]byte, error) {
+	if name == "" {
+		return json.Marshal(v)
+	}
+	return json.Marshal(name)
+}
+
+// enumUnmarshalJSON parses enum from its name or number
+func enumUnmarshalJSON(b []byte, bits int, lookup func(string) (uint64, bool)) (uint64, error) {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return strconv.ParseUint(string(b), 10, bits)
+	}
+	v, ok := lookup(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown value %q", name)
+	}
+	return v, nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v MessageType) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(MessageTypeToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *MessageType) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 8, func(s string) (uint64, bool) {
+		for k, name := range MessageTypeToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing MessageType: %w", err)
+	}
+	*v = MessageType(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v TLVType) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(TLVTypeToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *TLVType) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 16, func(s string) (uint64, bool) {
+		for k, name := range TLVTypeToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing TLVType: %w", err)
+	}
+	*v = TLVType(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v TimeSource) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(TimeSourceToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *TimeSource) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 8, func(s string) (uint64, bool) {
+		for k, name := range TimeSourceToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing TimeSource: %w", err)
+	}
+	*v = TimeSource(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v PortState) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(PortStateToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *PortState) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 8, func(s string) (uint64, bool) {
+		for k, name := range PortStateToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing PortState: %w", err)
+	}
+	*v = PortState(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v DelayMechanism) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(DelayMechanismToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *DelayMechanism) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 8, func(s string) (uint64, bool) {
+		for k, name := range DelayMechanismToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing DelayMechanism: %w", err)
+	}
+	*v = DelayMechanism(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v ManagementID) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(ManagementIDToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *ManagementID) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 16, func(s string) (uint64, bool) {
+		for k, name := range ManagementIDToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing ManagementID: %w", err)
+	}
+	*v = ManagementID(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v ManagementErrorID) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(ManagementErrorIDToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *ManagementErrorID) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 16, func(s string) (uint64, bool) {
+		for k, name := range ManagementErrorIDToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing ManagementErrorID: %w", err)
+	}
+	*v = ManagementErrorID(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (v Action) MarshalJSON() ([]byte, error) {
+	return enumMarshalJSON(ActionToString[v], uint64(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (v *Action) UnmarshalJSON(b []byte) error {
+	n, err := enumUnmarshalJSON(b, 8, func(s string) (uint64, bool) {
+		for k, name := range ActionToString {
+			if name == s {
+				return uint64(k), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("parsing Action: %w", err)
+	}
+	*v = Action(n)
+	return nil
+}
+
+// sdoIDAndMsgTypeJSON only carries majorSdoId, the 12 bit sdoId is completed by MinorSdoID field of the header
+type sdoIDAndMsgTypeJSON struct {
+	MessageType MessageType
+	MajorSdoID  uint8
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (m SdoIDAndMsgType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sdoIDAndMsgTypeJSON{MessageType: m.MsgType(), MajorSdoID: m.MajorSdoID()})
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (m *SdoIDAndMsgType) UnmarshalJSON(b []byte) error {
+	var v sdoIDAndMsgTypeJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.MajorSdoID > 0xf {
+		return fmt.Errorf("majorSdoId %d doesn't fit into 4 bits", v.MajorSdoID)
+	}
+	*m = NewSdoIDAndMsgType(v.MessageType, v.MajorSdoID)
+	return nil
+}
+
+type unicastMsgTypeAndFlagsJSON struct {
+	MessageType MessageType
+	Flags       uint8
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (m UnicastMsgTypeAndFlags) MarshalJSON() ([]byte, error) {
+	return json.Marshal(unicastMsgTypeAndFlagsJSON{MessageType: m.MsgType(), Flags: uint8(m) & 0x0f})
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (m *UnicastMsgTypeAndFlags) UnmarshalJSON(b []byte) error {
+	var v unicastMsgTypeAndFlagsJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*m = NewUnicastMsgTypeAndFlags(v.MessageType, v.Flags)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (c ClockIdentity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (c *ClockIdentity) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := ParseClockIdentity(s)
+	if err != nil {
+		return err
+	}
+	*c = v
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Time().UTC().Format(time.RFC3339Nano))
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return fmt.Errorf("parsing Timestamp: %w", err)
+	}
+	*t = NewTimestamp(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (t TimeInterval) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Nanoseconds())
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (t *TimeInterval) UnmarshalJSON(b []byte) error {
+	var ns float64
+	if err := json.Unmarshal(b, &ns); err != nil {
+		return err
+	}
+	*t = NewTimeInterval(ns)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (t Correction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Nanoseconds())
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (t *Correction) UnmarshalJSON(b []byte) error {
+	var ns float64
+	if err := json.Unmarshal(b, &ns); err != nil {
+		return err
+	}
+	*t = NewCorrection(ns)
+	return nil
+}
+
+// tlvTypeJSON is used to peek at the type of TLV before unmarshalling it
+type tlvTypeJSON struct {
+	TLVType      TLVType
+	LengthField  uint16
+	ManagementID ManagementID
+	// ORGANIZATION_EXTENSION TLVs
+	OrganizationID      OrganizationID
+	OrganizationSubType OrganizationSubType
+}
+
+// newTLV returns empty TLV of given type
+func newTLV(head tlvTypeJSON) (TLV, error) {
+	t := head.TLVType
+	switch t {
+	case TLVRequestUnicastTransmission:
+		return &RequestUnicastTransmissionTLV{}, nil
+	case TLVGrantUnicastTransmission:
+		return &GrantUnicastTransmissionTLV{}, nil
+	case TLVCancelUnicastTransmission:
+		return &CancelUnicastTransmissionTLV{}, nil
+	case TLVAcknowledgeCancelUnicastTransmission:
+		return &AcknowledgeCancelUnicastTransmissionTLV{}, nil
+	case TLVPathTrace:
+		return &PathTraceTLV{}, nil
+	case TLVAlternateTimeOffsetIndicator:
+		return &AlternateTimeOffsetIndicatorTLV{}, nil
+	case TLVAuthentication:
+		return &AuthenticationTLV{}, nil
+	case TLVPTPMonRequest:
+		return &PTPMonRequestTLV{}, nil
+	case TLVPTPMonResponse:
+		return &PTPMonResponseTLV{}, nil
+	case TLVOrganizationExtension:
+		if head.OrganizationID == SMPTEOrganizationID && head.OrganizationSubType == SMPTEOrganizationSubType {
+			return &SMPTETLV{}, nil
+		}
+	}
+	tlvRegistry.RLock()
+	defer tlvRegistry.RUnlock()
+	if t == TLVOrganizationExtension {
+		if f, ok := tlvRegistry.byOrganization[organizationKey{id: head.OrganizationID, subType: head.OrganizationSubType}]; ok {
+			return f(), nil
+		}
+		return nil, fmt.Errorf("unmarshalling organization TLV %x/%x from JSON is not supported", head.OrganizationID, head.OrganizationSubType)
+	}
+	if f, ok := tlvRegistry.byType[t]; ok {
+		return f(), nil
+	}
+	return nil, fmt.Errorf("unmarshalling TLV %s (%d) from JSON is not supported", t, uint16(t))
+}
+
+// unmarshalTLVsJSON unmarshals list of TLVs using TLVType of each to pick the right struct
+func unmarshalTLVsJSON(raw []json.RawMessage) ([]TLV, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	tlvs := make([]TLV, 0, len(raw))
+	for _, r := range raw {
+		var head tlvTypeJSON
+		if err := json.Unmarshal(r, &head); err != nil {
+			return nil, err
+		}
+		tlv, err := newTLV(head)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(r, tlv); err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, tlv)
+	}
+	return tlvs, nil
+}
+
+// newManagementTLV returns empty management TLV. Requests with no data are represented by ManagementTLVHead.
+func newManagementTLV(head tlvTypeJSON) ManagementTLV {
+	if head.LengthField == 2 {
+		return &ManagementTLVHead{}
+	}
+	switch head.ManagementID {
+	case IDDefaultDataSet:
+		return &DefaultDataSetTLV{}
+	case IDCurrentDataSet:
+		return &CurrentDataSetTLV{}
+	case IDParentDataSet:
+		return &ParentDataSetTLV{}
+	case IDTimePropertiesDataSet:
+		return &TimePropertiesDataSetTLV{}
+	case IDPortDataSet:
+		return &PortDataSetTLV{}
+	case IDPriority1:
+		return &Priority1TLV{}
+	case IDPriority2:
+		return &Priority2TLV{}
+	case IDPortStatsNP:
+		return &PortStatsNPTLV{}
+	case IDPortServiceStatsNP:
+		return &PortServiceStatsNPTLV{}
+	case IDTimeStatusNP:
+		return &TimeStatusNPTLV{}
+	}
+	return &ManagementTLVHead{}
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (p *Announce) UnmarshalJSON(b []byte) error {
+	type alias Announce
+	v := struct {
+		*alias
+		TLVs []json.RawMessage
+	}{alias: (*alias)(p)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	tlvs, err := unmarshalTLVsJSON(v.TLVs)
+	if err != nil {
+		return err
+	}
+	p.TLVs = tlvs
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (p *Signaling) UnmarshalJSON(b []byte) error {
+	type alias Signaling
+	v := struct {
+		*alias
+		TLVs []json.RawMessage
+	}{alias: (*alias)(p)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	tlvs, err := unmarshalTLVsJSON(v.TLVs)
+	if err != nil {
+		return err
+	}
+	p.TLVs = tlvs
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface
+func (p *Management) UnmarshalJSON(b []byte) error {
+	type alias Management
+	v := struct {
+		*alias
+		TLV json.RawMessage
+	}{alias: (*alias)(p)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if len(v.TLV) == 0 || string(v.TLV) == "null" {
+		p.TLV = nil
+		return nil
+	}
+	var head tlvTypeJSON
+	if err := json.Unmarshal(v.TLV, &head); err != nil {
+		return err
+	}
+	tlv := newManagementTLV(head)
+	if err := json.Unmarshal(v.TLV, tlv); err != nil {
+		return err
+	}
+	p.TLV = tlv
+	return nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncJSON(t *testing.T) {
+	p := testSync()
+	p.CorrectionField = NewCorrection(2.5)
+	b, err := json.Marshal(p)
+	require.Nil(t, err)
+	require.JSONEq(t, `{
+		"SdoIDAndMsgType": {"MessageType": "SYNC", "MajorSdoID": 0},
+		"Version": 18,
+		"MessageLength": 44,
+		"DomainNumber": 0,
+		"MinorSdoID": 0,
+		"FlagField": 0,
+		"CorrectionField": 2.5,
+		"MessageTypeSpecific": 0,
+		"SourcePortIdentity": {"ClockIdentity": "008063.ffff.0009ba", "PortNumber": 1},
+		"SequenceID": 116,
+		"ControlField": 0,
+		"LogMessageInterval": 0,
+		"OriginTimestamp": "2022-05-26T14:16:29.806492928Z"
+	}`, string(b))
+
+	got := &SyncDelayReq{}
+	err = json.Unmarshal(b, got)
+	require.Nil(t, err)
+	require.Equal(t, p, got)
+}
+
+func TestSdoIDJSON(t *testing.T) {
+	p := testSync()
+	p.Version = NewVersion(MajorVersion, 1)
+	require.Nil(t, p.SetSdoID(0x142))
+	b, err := json.Marshal(p)
+	require.Nil(t, err)
+
+	got := &SyncDelayReq{}
+	require.Nil(t, json.Unmarshal(b, got))
+	// the whole 12 bits survive, not just majorSdoId
+	require.Equal(t, SdoID(0x142), got.SdoID())
+	require.Equal(t, p, got)
+
+	var m SdoIDAndMsgType
+	require.Error(t, json.Unmarshal([]byte(`{"MessageType": "SYNC", "MajorSdoID": 16}`), &m))
+}
+
+func TestEnumJSON(t *testing.T) {
+	b, err := json.Marshal(TLVType(0x1234))
+	require.Nil(t, err)
+	require.Equal(t, "4660", string(b))
+	var tlvType TLVType
+	err = json.Unmarshal(b, &tlvType)
+	require.Nil(t, err)
+	require.Equal(t, TLVType(0x1234), tlvType)
+
+	var ts TimeSource
+	err = json.Unmarshal([]byte(`"GNSS"`), &ts)
+	require.Nil(t, err)
+	require.Equal(t, TimeSourceGNSS, ts)
+	err = json.Unmarshal([]byte(`"NOT_A_TIME_SOURCE"`), &ts)
+	require.Error(t, err)
+	err = json.Unmarshal([]byte(`300`), &ts)
+	require.Error(t, err)
+
+	var c ClockIdentity
+	err = json.Unmarshal([]byte(`"not an identity"`), &c)
+	require.Error(t, err)
+}
+
+func TestMessagesJSON(t *testing.T) {
+	announce := testAnnounce()
+	announce.TLVs = []TLV{NewAlternateTimeOffsetIndicatorTLV(1, -7*time.Hour, time.Hour, time.Unix(1667098800, 0), "PDT")}
+	announce.AppendPathTrace(42)
+
+	b := make([]byte, 128)
+	n, err := testSync().MarshalBinaryTo(b)
+	require.Nil(t, err)
+	n, err = SignPacket(b, n, 0, 1, testKeys)
+	require.Nil(t, err)
+	signed, err := DecodePacket(b[:n])
+	require.Nil(t, err)
+	signaling := &Signaling{}
+	n, err = (&Signaling{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSignaling, 0),
+			Version:         Version,
+			MessageLength:   headerSize + 10 + tlvHeadSize + 6,
+		},
+		TLVs: []TLV{&RequestUnicastTransmissionTLV{
+			TLVHead:               TLVHead{TLVType: TLVRequestUnicastTransmission, LengthField: 6},
+			MsgTypeAndReserved:    NewUnicastMsgTypeAndFlags(MessageSync, 0),
+			LogInterMessagePeriod: -4,
+			DurationField:         60,
+		}},
+	}).MarshalBinaryTo(b)
+	require.Nil(t, err)
+	n, err = SignPacket(b, n, 0, 1, testKeys)
+	require.Nil(t, err)
+	err = signaling.UnmarshalBinary(b[:n])
+	require.Nil(t, err)
+
+	packets := []Packet{
+		signed,
+		announce,
+		signaling,
+		&Signaling{
+			Header: Header{SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSignaling, 0)},
+			TLVs: []TLV{
+				&GrantUnicastTransmissionTLV{TLVHead: TLVHead{TLVType: TLVGrantUnicastTransmission, LengthField: 8}, Renewal: 1},
+				&CancelUnicastTransmissionTLV{TLVHead: TLVHead{TLVType: TLVCancelUnicastTransmission, LengthField: 2}},
+				&AcknowledgeCancelUnicastTransmissionTLV{TLVHead: TLVHead{TLVType: TLVAcknowledgeCancelUnicastTransmission, LengthField: 2}},
+			},
+		},
+		&FollowUp{Header: Header{SdoIDAndMsgType: NewSdoIDAndMsgType(MessageFollowUp, 0)}},
+		&DelayResp{Header: Header{SdoIDAndMsgType: NewSdoIDAndMsgType(MessageDelayResp, 0)}},
+		&PDelayReq{Header: Header{SdoIDAndMsgType: NewSdoIDAndMsgType(MessagePDelayReq, 0)}},
+		&PDelayResp{Header: Header{SdoIDAndMsgType: NewSdoIDAndMsgType(MessagePDelayResp, 0)}},
+		&PDelayRespFollowUp{Header: Header{SdoIDAndMsgType: NewSdoIDAndMsgType(MessagePDelayRespFollowUp, 0)}},
+		DefaultDataSetRequest(),
+		CurrentDataSetRequest(),
+		ParentDataSetRequest(),
+		TimePropertiesDataSetRequest(),
+		PortDataSetRequest(),
+		Priority1Request(),
+		Priority2Request(),
+		PortStatsNPRequest(),
+		PortServiceStatsNPRequest(),
+		TimeStatusNPRequest(),
+		&Management{
+			ManagementMsgHead: ManagementMsgHead{ActionField: RESPONSE},
+			TLV: &PortServiceStatsNPTLV{
+				ManagementTLVHead: mgmtTLVHead(IDPortServiceStatsNP, 96),
+				PortServiceStats:  PortServiceStats{SyncTimeout: 1},
+			},
+		},
+		&ManagementMsgErrorStatus{
+			ManagementErrorStatusTLV: ManagementErrorStatusTLV{
+				ManagementErrorID: ErrorNotSupported,
+				ManagementID:      IDPortStatsNP,
+				DisplayData:       "nope",
+			},
+		},
+	}
+	for _, p := range packets {
+		b, err := json.Marshal(p)
+		require.Nil(t, err)
+		got := newPacketOfType(p)
+		err = json.Unmarshal(b, got)
+		require.Nil(t, err, string(b))
+		require.Equal(t, p, got, string(b))
+	}
+}
+
+// newPacketOfType returns new empty packet of the same type as p
+func newPacketOfType(p Packet) Packet {
+	switch p.(type) {
+	case *SyncDelayReq:
+		return &SyncDelayReq{}
+	case *Announce:
+		return &Announce{}
+	case *Signaling:
+		return &Signaling{}
+	case *FollowUp:
+		return &FollowUp{}
+	case *DelayResp:
+		return &DelayResp{}
+	case *PDelayReq:
+		return &PDelayReq{}
+	case *PDelayResp:
+		return &PDelayResp{}
+	case *PDelayRespFollowUp:
+		return &PDelayRespFollowUp{}
+	case *Management:
+		return &Management{}
+	case *ManagementMsgErrorStatus:
+		return &ManagementMsgErrorStatus{}
+	}
+	return nil
+}
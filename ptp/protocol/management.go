@@ -48,6 +48,19 @@ const (
 	ACKNOWLEDGE
 )
 
+// ActionToString is a map from Action to string
+var ActionToString = map[Action]string{
+	GET:         "GET",
+	SET:         "SET",
+	RESPONSE:    "RESPONSE",
+	COMMAND:     "COMMAND",
+	ACKNOWLEDGE: "ACKNOWLEDGE",
+}
+
+func (a Action) String() string {
+	return ActionToString[a]
+}
+
 // ManagementTLVHead Spec Table 58 - Management TLV fields
 type ManagementTLVHead struct {
 	TLVHead
@@ -66,6 +79,22 @@ type ManagementMsgHead struct {
 	Reserved             uint8
 }
 
+const mgmtMsgHeadSize = headerSize + 14
+
+func mgmtMsgHeadMarshalBinaryTo(p *ManagementMsgHead, b []byte) (int, error) {
+	if len(b) < mgmtMsgHeadSize {
+		return 0, fmt.Errorf("not enough buffer to write ManagementMsgHead")
+	}
+	n := headerMarshalBinaryTo(&p.Header, b)
+	binary.BigEndian.PutUint64(b[n:], uint64(p.TargetPortIdentity.ClockIdentity))
+	binary.BigEndian.PutUint16(b[n+8:], p.TargetPortIdentity.PortNumber)
+	b[n+10] = p.StartingBoundaryHops
+	b[n+11] = p.BoundaryHops
+	b[n+12] = byte(p.ActionField)
+	b[n+13] = p.Reserved
+	return mgmtMsgHeadSize, nil
+}
+
 // Action returns ActionField
 func (p *ManagementMsgHead) Action() Action {
 	return p.ActionField
@@ -126,22 +155,34 @@ func (p *Management) UnmarshalBinary(rawBytes []byte) error {
 	return nil
 }
 
-// MarshalBinaryTo converts packet to bytes and writes those into provided buffer
-func (p *Management) MarshalBinaryTo(bytes io.Writer) error {
-	if err := binary.Write(bytes, binary.BigEndian, p.ManagementMsgHead); err != nil {
-		return err
+// MarshalBinaryTo marshals packet into provided []byte
+func (p *Management) MarshalBinaryTo(b []byte) (int, error) {
+	if p.TLV == nil {
+		return 0, fmt.Errorf("no TLV in Management message")
 	}
-	if err := binary.Write(bytes, binary.BigEndian, p.TLV); err != nil {
-		return err
+	n, err := mgmtMsgHeadMarshalBinaryTo(&p.ManagementMsgHead, b)
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	nn, err := writeTLV(p.TLV, b[n:])
+	if err != nil {
+		return 0, err
+	}
+	return n + nn, nil
 }
 
 // MarshalBinary converts packet to []bytes
 func (p *Management) MarshalBinary() ([]byte, error) {
-	var bytes bytes.Buffer
-	err := p.MarshalBinaryTo(&bytes)
-	return bytes.Bytes(), err
+	size := int(p.MessageLength)
+	if tlvSize := binary.Size(p.TLV); tlvSize > 0 {
+		size = mgmtMsgHeadSize + tlvSize
+	}
+	buf := make([]byte, size)
+	n, err := p.MarshalBinaryTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
 }
 
 // ManagementErrorStatusTLV spec Table 108 MANAGEMENT_ERROR_STATUS TLV format
@@ -201,41 +242,69 @@ func (p *ManagementMsgErrorStatus) UnmarshalBinary(rawBytes []byte) error {
 	return nil
 }
 
-// MarshalBinaryTo converts packet to bytes and writes those into provided buffer
-func (p *ManagementMsgErrorStatus) MarshalBinaryTo(bytes io.Writer) error {
-	be := binary.BigEndian
-	if err := binary.Write(bytes, be, &p.ManagementMsgHead); err != nil {
-		return fmt.Errorf("writing ManagementMsgErrorStatus ManagementMsgHead: %w", err)
+// NewManagementErrorStatus builds MANAGEMENT_ERROR_STATUS response from the source port to the management request
+func NewManagementErrorStatus(req *Management, source PortIdentity, errID ManagementErrorID, text PTPText) *ManagementMsgErrorStatus {
+	p := &ManagementMsgErrorStatus{
+		ManagementMsgHead: req.ManagementMsgHead,
+		ManagementErrorStatusTLV: ManagementErrorStatusTLV{
+			TLVHead: TLVHead{
+				TLVType: TLVManagementErrorStatus,
+			},
+			ManagementErrorID: errID,
+			DisplayData:       text,
+		},
+	}
+	if req.TLV != nil {
+		p.ManagementID = req.TLV.MgmtID()
 	}
-	if err := binary.Write(bytes, be, &p.ManagementErrorStatusTLV.TLVHead); err != nil {
-		return fmt.Errorf("writing ManagementMsgErrorStatus TLVHead: %w", err)
+	p.ActionField = RESPONSE
+	if req.ActionField == COMMAND {
+		p.ActionField = ACKNOWLEDGE
 	}
-	if err := binary.Write(bytes, be, &p.ManagementErrorStatusTLV.ManagementErrorID); err != nil {
-		return fmt.Errorf("writing ManagementMsgErrorStatus ManagementErrorID: %w", err)
+	p.SourcePortIdentity = source
+	p.TargetPortIdentity = req.SourcePortIdentity
+	p.BoundaryHops = p.StartingBoundaryHops - p.BoundaryHops
+	length := 8
+	if text != "" {
+		length += 1 + len(text) + len(text)%2
 	}
-	if err := binary.Write(bytes, be, &p.ManagementErrorStatusTLV.ManagementID); err != nil {
-		return fmt.Errorf("writing ManagementMsgErrorStatus ManagementID: %w", err)
+	p.LengthField = uint16(length)
+	p.MessageLength = uint16(mgmtMsgHeadSize + tlvHeadSize + length)
+	return p
+}
+
+// MarshalBinaryTo marshals packet into provided []byte
+func (p *ManagementMsgErrorStatus) MarshalBinaryTo(b []byte) (int, error) {
+	n, err := mgmtMsgHeadMarshalBinaryTo(&p.ManagementMsgHead, b)
+	if err != nil {
+		return 0, err
 	}
-	if err := binary.Write(bytes, be, &p.ManagementErrorStatusTLV.Reserved); err != nil {
-		return fmt.Errorf("writing ManagementMsgErrorStatus Reserved: %w", err)
+	if len(b) < n+tlvHeadSize+8 {
+		return 0, fmt.Errorf("not enough buffer to write ManagementErrorStatusTLV")
 	}
+	tlvHeadMarshalBinaryTo(&p.ManagementErrorStatusTLV.TLVHead, b[n:])
+	binary.BigEndian.PutUint16(b[n+tlvHeadSize:], uint16(p.ManagementErrorID))
+	binary.BigEndian.PutUint16(b[n+tlvHeadSize+2:], uint16(p.ManagementErrorStatusTLV.ManagementID))
+	binary.BigEndian.PutUint32(b[n+tlvHeadSize+4:], uint32(p.ManagementErrorStatusTLV.Reserved))
+	n += tlvHeadSize + 8
 	if p.DisplayData != "" {
-		dd, err := p.DisplayData.MarshalBinary()
+		nn, err := p.DisplayData.MarshalBinaryTo(b[n:])
 		if err != nil {
-			return fmt.Errorf("writing ManagementMsgErrorStatus DisplayData: %w", err)
-		}
-		if _, err := bytes.Write(dd); err != nil {
-			return err
+			return 0, fmt.Errorf("writing ManagementMsgErrorStatus DisplayData: %w", err)
 		}
+		n += nn
 	}
-	return nil
+	return n, nil
 }
 
 // MarshalBinary converts packet to []bytes
 func (p *ManagementMsgErrorStatus) MarshalBinary() ([]byte, error) {
-	var bytes bytes.Buffer
-	err := p.MarshalBinaryTo(&bytes)
-	return bytes.Bytes(), err
+	buf := make([]byte, mgmtMsgHeadSize+tlvHeadSize+8+1+len(p.DisplayData)+len(p.DisplayData)%2)
+	n, err := p.MarshalBinaryTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
 }
 
 // ManagementErrorID is an enum for possible management errors
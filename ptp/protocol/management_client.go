@@ -24,6 +24,35 @@ import (
 	"io"
 )
 
+// ManagementError is returned by MgmtClient when PTP server responds with MANAGEMENT_ERROR_STATUS TLV.
+// It wraps ManagementErrorID, so specific errors can be checked with errors.Is(err, ErrorNotSupported).
+type ManagementError struct {
+	ManagementErrorID ManagementErrorID
+	ManagementID      ManagementID
+	DisplayData       PTPText
+}
+
+func (e *ManagementError) Error() string {
+	if e.DisplayData != "" {
+		return fmt.Sprintf("got Management Error in response: %v for %v: %s", e.ManagementErrorID, e.ManagementID, e.DisplayData)
+	}
+	return fmt.Sprintf("got Management Error in response: %v for %v", e.ManagementErrorID, e.ManagementID)
+}
+
+// Unwrap allows to use errors.Is and errors.As on ManagementError
+func (e *ManagementError) Unwrap() error {
+	return e.ManagementErrorID
+}
+
+// Err returns ManagementError corresponding to the MANAGEMENT_ERROR_STATUS TLV
+func (p *ManagementMsgErrorStatus) Err() *ManagementError {
+	return &ManagementError{
+		ManagementErrorID: p.ManagementErrorID,
+		ManagementID:      p.ManagementID,
+		DisplayData:       p.DisplayData,
+	}
+}
+
 // MgmtClient talks to ptp server over unix socket
 type MgmtClient struct {
 	Connection io.ReadWriter
@@ -59,7 +88,7 @@ func (c *MgmtClient) Communicate(packet *Management) (*Management, error) {
 	}
 	errorPacket, ok := res.(*ManagementMsgErrorStatus)
 	if ok {
-		return nil, fmt.Errorf("got Management Error in response: %v", errorPacket.ManagementErrorStatusTLV.ManagementErrorID)
+		return nil, errorPacket.Err()
 	}
 	p, ok := res.(*Management)
 	if !ok {
@@ -109,3 +138,59 @@ func (c *MgmtClient) CurrentDataSet() (*CurrentDataSetTLV, error) {
 	}
 	return tlv, nil
 }
+
+// TimePropertiesDataSet sends TIME_PROPERTIES_DATA_SET request and returns response
+func (c *MgmtClient) TimePropertiesDataSet() (*TimePropertiesDataSetTLV, error) {
+	req := TimePropertiesDataSetRequest()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*TimePropertiesDataSetTLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
+
+// PortDataSet sends PORT_DATA_SET request and returns response
+func (c *MgmtClient) PortDataSet() (*PortDataSetTLV, error) {
+	req := PortDataSetRequest()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*PortDataSetTLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
+
+// Priority1 sends PRIORITY1 request and returns response
+func (c *MgmtClient) Priority1() (*Priority1TLV, error) {
+	req := Priority1Request()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*Priority1TLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
+
+// Priority2 sends PRIORITY2 request and returns response
+func (c *MgmtClient) Priority2() (*Priority2TLV, error) {
+	req := Priority2Request()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*Priority2TLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
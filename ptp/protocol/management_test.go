@@ -17,6 +17,9 @@ limitations under the License.
 package protocol
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -79,3 +82,196 @@ func Test_parseMsgErrorStatus(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, &want, pp)
 }
+
+// fakeConn is io.ReadWriter which records written request and returns prepared response
+type fakeConn struct {
+	written  bytes.Buffer
+	response []byte
+}
+
+func (c *fakeConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+func (c *fakeConn) Read(b []byte) (int, error) {
+	return copy(b, c.response), nil
+}
+
+// mgmtResponse turns request into response with given TLV
+func mgmtResponse(t *testing.T, req *Management, tlv ManagementTLV) []byte {
+	resp := *req
+	resp.ActionField = RESPONSE
+	resp.TLV = tlv
+	b, err := resp.MarshalBinary()
+	require.Nil(t, err)
+	return b
+}
+
+func TestTimePropertiesDataSet(t *testing.T) {
+	req := TimePropertiesDataSetRequest()
+	require.Equal(t, uint16(48+10), req.MessageLength)
+	require.Equal(t, IDTimePropertiesDataSet, req.TLV.MgmtID())
+
+	tlv := &TimePropertiesDataSetTLV{
+		ManagementTLVHead: mgmtTLVHead(IDTimePropertiesDataSet, 10),
+		CurrentUTCOffset:  37,
+		Flags:             uint8(FlagCurrentUtcOffsetValid | FlagPTPTimescale | FlagTimeTraceable),
+		TimeSource:        TimeSourceGNSS,
+	}
+	conn := &fakeConn{response: mgmtResponse(t, req, tlv)}
+	c := &MgmtClient{Connection: conn}
+	got, err := c.TimePropertiesDataSet()
+	require.Nil(t, err)
+	require.Equal(t, tlv, got)
+	require.Equal(t, int(req.MessageLength), conn.written.Len())
+}
+
+func TestPortDataSet(t *testing.T) {
+	req := PortDataSetRequest()
+	require.Equal(t, uint16(48+32), req.MessageLength)
+
+	tlv := &PortDataSetTLV{
+		ManagementTLVHead: mgmtTLVHead(IDPortDataSet, 32),
+		PortIdentity: PortIdentity{
+			PortNumber:    1,
+			ClockIdentity: 5212879185253000328,
+		},
+		PortState:              PortStateSlave,
+		LogMinDelayReqInterval: -4,
+		PeerMeanPathDelay:      NewTimeInterval(100),
+		LogAnnounceInterval:    1,
+		AnnounceReceiptTimeout: 3,
+		LogSyncInterval:        -4,
+		DelayMechanism:         DelayMechanismE2E,
+		VersionNumber:          2,
+	}
+	c := &MgmtClient{Connection: &fakeConn{response: mgmtResponse(t, req, tlv)}}
+	got, err := c.PortDataSet()
+	require.Nil(t, err)
+	require.Equal(t, tlv, got)
+	require.Equal(t, "SLAVE", got.PortState.String())
+	require.Equal(t, "E2E", got.DelayMechanism.String())
+}
+
+func TestPriority(t *testing.T) {
+	req := Priority1Request()
+	require.Equal(t, uint16(48+8), req.MessageLength)
+	tlv1 := &Priority1TLV{
+		ManagementTLVHead: mgmtTLVHead(IDPriority1, 8),
+		Priority1:         128,
+	}
+	c := &MgmtClient{Connection: &fakeConn{response: mgmtResponse(t, req, tlv1)}}
+	got1, err := c.Priority1()
+	require.Nil(t, err)
+	require.Equal(t, tlv1, got1)
+
+	req = Priority2Request()
+	require.Equal(t, uint16(48+8), req.MessageLength)
+	tlv2 := &Priority2TLV{
+		ManagementTLVHead: mgmtTLVHead(IDPriority2, 8),
+		Priority2:         255,
+	}
+	c = &MgmtClient{Connection: &fakeConn{response: mgmtResponse(t, req, tlv2)}}
+	got2, err := c.Priority2()
+	require.Nil(t, err)
+	require.Equal(t, tlv2, got2)
+
+	// response with unexpected TLV
+	c = &MgmtClient{Connection: &fakeConn{response: mgmtResponse(t, req, tlv1)}}
+	_, err = c.Priority2()
+	require.Error(t, err)
+}
+
+func TestManagementMarshalBinaryTo(t *testing.T) {
+	req := PortDataSetRequest()
+	buf := make([]byte, 508)
+	n, err := req.MarshalBinaryTo(buf)
+	require.Nil(t, err)
+	require.Equal(t, int(req.MessageLength), n)
+
+	// must be the same as generic binary encoding of the struct
+	var want bytes.Buffer
+	require.Nil(t, binary.Write(&want, binary.BigEndian, req.ManagementMsgHead))
+	require.Nil(t, binary.Write(&want, binary.BigEndian, req.TLV))
+	require.Equal(t, want.Bytes(), buf[:n])
+
+	_, err = req.MarshalBinaryTo(buf[:n-1])
+	require.Error(t, err)
+	_, err = req.MarshalBinaryTo(buf[:mgmtMsgHeadSize-1])
+	require.Error(t, err)
+	_, err = (&Management{}).MarshalBinaryTo(buf)
+	require.Error(t, err)
+}
+
+func TestManagementMsgErrorStatusMarshalBinaryTo(t *testing.T) {
+	p := &ManagementMsgErrorStatus{
+		ManagementMsgHead: ManagementMsgHead{
+			Header: Header{
+				SdoIDAndMsgType: NewSdoIDAndMsgType(MessageManagement, 0),
+				Version:         MajorVersion,
+				MessageLength:   mgmtMsgHeadSize + tlvHeadSize + 8 + 5,
+			},
+			ActionField: RESPONSE,
+		},
+		ManagementErrorStatusTLV: ManagementErrorStatusTLV{
+			TLVHead: TLVHead{
+				TLVType:     TLVManagementErrorStatus,
+				LengthField: 8 + 5,
+			},
+			ManagementErrorID: ErrorNotSupported,
+			ManagementID:      IDPortStatsNP,
+			DisplayData:       "nope",
+		},
+	}
+	buf := make([]byte, 508)
+	n, err := p.MarshalBinaryTo(buf)
+	require.Nil(t, err)
+	require.Equal(t, int(p.MessageLength), n)
+
+	got := &ManagementMsgErrorStatus{}
+	require.Nil(t, got.UnmarshalBinary(buf[:n]))
+	require.Equal(t, p, got)
+
+	_, err = p.MarshalBinaryTo(buf[:n-1])
+	require.Error(t, err)
+}
+
+func TestNewManagementErrorStatus(t *testing.T) {
+	req := CurrentDataSetRequest()
+	req.SourcePortIdentity = PortIdentity{PortNumber: 1, ClockIdentity: 1234}
+	source := PortIdentity{PortNumber: 1, ClockIdentity: 5678}
+	p := NewManagementErrorStatus(req, source, ErrorWrongLength, "bad")
+	require.Equal(t, RESPONSE, p.ActionField)
+	require.Equal(t, source, p.SourcePortIdentity)
+	require.Equal(t, req.SourcePortIdentity, p.TargetPortIdentity)
+	require.Equal(t, IDCurrentDataSet, p.ManagementID)
+
+	b, err := p.MarshalBinary()
+	require.Nil(t, err)
+	require.Equal(t, int(p.MessageLength), len(b))
+	pp, err := decodeMgmtPacket(b)
+	require.Nil(t, err)
+	require.Equal(t, p, pp)
+
+	req.ActionField = COMMAND
+	p = NewManagementErrorStatus(req, source, ErrorNotSupported, "")
+	require.Equal(t, ACKNOWLEDGE, p.ActionField)
+	require.Equal(t, uint16(mgmtMsgHeadSize+tlvHeadSize+8), p.MessageLength)
+}
+
+func TestMgmtClientManagementError(t *testing.T) {
+	req := CurrentDataSetRequest()
+	resp := NewManagementErrorStatus(req, PortIdentity{}, ErrorNotSupported, "nope")
+	b, err := resp.MarshalBinary()
+	require.Nil(t, err)
+	c := &MgmtClient{Connection: &fakeConn{response: b}}
+	_, err = c.CurrentDataSet()
+	require.Error(t, err)
+	require.True(t, errors.Is(err, ErrorNotSupported))
+	require.False(t, errors.Is(err, ErrorWrongLength))
+	var mErr *ManagementError
+	require.True(t, errors.As(err, &mErr))
+	require.Equal(t, IDCurrentDataSet, mErr.ManagementID)
+	require.Equal(t, PTPText("nope"), mErr.DisplayData)
+	require.Equal(t, "got Management Error in response: NOT_SUPPORTED for CURRENT_DATA_SET: nope", err.Error())
+}
@@ -40,9 +40,37 @@ const (
 	IDParentDataSet         ManagementID = 0x2002
 	IDTimePropertiesDataSet ManagementID = 0x2003
 	IDPortDataSet           ManagementID = 0x2004
+	IDPriority1             ManagementID = 0x2005
+	IDPriority2             ManagementID = 0x2006
 	// rest of Management IDs that we don't implement yet
 )
 
+// ManagementIDToString is a map from ManagementID to string
+var ManagementIDToString = map[ManagementID]string{
+	IDNullPTPManagement:        "NULL_PTP_MANAGEMENT",
+	IDClockDescription:         "CLOCK_DESCRIPTION",
+	IDUserDescription:          "USER_DESCRIPTION",
+	IDSaveInNonVolatileStorage: "SAVE_IN_NON_VOLATILE_STORAGE",
+	IDResetNonVolatileStorage:  "RESET_NON_VOLATILE_STORAGE",
+	IDInitialize:               "INITIALIZE",
+	IDFaultLog:                 "FAULT_LOG",
+	IDFaultLogReset:            "FAULT_LOG_RESET",
+	IDDefaultDataSet:           "DEFAULT_DATA_SET",
+	IDCurrentDataSet:           "CURRENT_DATA_SET",
+	IDParentDataSet:            "PARENT_DATA_SET",
+	IDTimePropertiesDataSet:    "TIME_PROPERTIES_DATA_SET",
+	IDPortDataSet:              "PORT_DATA_SET",
+	IDPriority1:                "PRIORITY1",
+	IDPriority2:                "PRIORITY2",
+	IDTimeStatusNP:             "TIME_STATUS_NP",
+	IDPortStatsNP:              "PORT_STATS_NP",
+	IDPortServiceStatsNP:       "PORT_SERVICE_STATS_NP",
+}
+
+func (m ManagementID) String() string {
+	return ManagementIDToString[m]
+}
+
 // ManagementTLV abstracts away any ManagementTLV
 type ManagementTLV interface {
 	TLV
@@ -93,6 +121,53 @@ var mgmtTLVDecoder = map[ManagementID]MgmtTLVDecoderFunc{
 		}
 		return tlv, nil
 	},
+	IDTimePropertiesDataSet: func(data []byte) (ManagementTLV, error) {
+		r := bytes.NewReader(data)
+		tlv := &TimePropertiesDataSetTLV{}
+		if err := binary.Read(r, binary.BigEndian, tlv); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
+	IDPortDataSet: func(data []byte) (ManagementTLV, error) {
+		r := bytes.NewReader(data)
+		tlv := &PortDataSetTLV{}
+		if err := binary.Read(r, binary.BigEndian, tlv); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
+	IDPriority1: func(data []byte) (ManagementTLV, error) {
+		r := bytes.NewReader(data)
+		tlv := &Priority1TLV{}
+		if err := binary.Read(r, binary.BigEndian, tlv); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
+	IDPriority2: func(data []byte) (ManagementTLV, error) {
+		r := bytes.NewReader(data)
+		tlv := &Priority2TLV{}
+		if err := binary.Read(r, binary.BigEndian, tlv); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
+	IDPortServiceStatsNP: func(data []byte) (ManagementTLV, error) {
+		r := bytes.NewReader(data)
+		tlv := &PortServiceStatsNPTLV{}
+		if err := binary.Read(r, binary.BigEndian, &tlv.ManagementTLVHead); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &tlv.PortIdentity); err != nil {
+			return nil, err
+		}
+		// same as PortStats, sent over wire as LittleEndian
+		if err := binary.Read(r, binary.LittleEndian, &tlv.PortServiceStats); err != nil {
+			return nil, err
+		}
+		return tlv, nil
+	},
 	IDTimeStatusNP: func(data []byte) (ManagementTLV, error) {
 		r := bytes.NewReader(data)
 		tlv := &TimeStatusNPTLV{}
@@ -241,3 +316,160 @@ func ParentDataSetRequest() *Management {
 		},
 	}
 }
+
+// TimePropertiesDataSetTLV Spec Table 87 - TIME_PROPERTIES_DATA_SET management TLV data field
+type TimePropertiesDataSetTLV struct {
+	ManagementTLVHead
+
+	CurrentUTCOffset int16
+	// Flags are the same as second octet of FlagField, e.g. FlagLeap61
+	Flags      uint8
+	TimeSource TimeSource
+}
+
+// PortState is the state of PTP port as per Table 20 portState enumeration
+type PortState uint8
+
+// Table 20 portState enumeration
+const (
+	PortStateInitializing PortState = 1
+	PortStateFaulty       PortState = 2
+	PortStateDisabled     PortState = 3
+	PortStateListening    PortState = 4
+	PortStatePreMaster    PortState = 5
+	PortStateMaster       PortState = 6
+	PortStatePassive      PortState = 7
+	PortStateUncalibrated PortState = 8
+	PortStateSlave        PortState = 9
+)
+
+// PortStateToString is a map from PortState to string
+var PortStateToString = map[PortState]string{
+	PortStateInitializing: "INITIALIZING",
+	PortStateFaulty:       "FAULTY",
+	PortStateDisabled:     "DISABLED",
+	PortStateListening:    "LISTENING",
+	PortStatePreMaster:    "PRE_MASTER",
+	PortStateMaster:       "MASTER",
+	PortStatePassive:      "PASSIVE",
+	PortStateUncalibrated: "UNCALIBRATED",
+	PortStateSlave:        "SLAVE",
+}
+
+func (s PortState) String() string {
+	return PortStateToString[s]
+}
+
+// DelayMechanism is the propagation delay measuring option as per Table 21 delayMechanism values
+type DelayMechanism uint8
+
+// Table 21 delayMechanism values
+const (
+	DelayMechanismE2E         DelayMechanism = 0x01
+	DelayMechanismP2P         DelayMechanism = 0x02
+	DelayMechanismCommonP2P   DelayMechanism = 0x03
+	DelayMechanismSpecial     DelayMechanism = 0x04
+	DelayMechanismNoMechanism DelayMechanism = 0xFE
+)
+
+// DelayMechanismToString is a map from DelayMechanism to string
+var DelayMechanismToString = map[DelayMechanism]string{
+	DelayMechanismE2E:         "E2E",
+	DelayMechanismP2P:         "P2P",
+	DelayMechanismCommonP2P:   "COMMON_P2P",
+	DelayMechanismSpecial:     "SPECIAL",
+	DelayMechanismNoMechanism: "NO_MECHANISM",
+}
+
+func (m DelayMechanism) String() string {
+	return DelayMechanismToString[m]
+}
+
+// PortDataSetTLV Spec Table 89 - PORT_DATA_SET management TLV data field
+type PortDataSetTLV struct {
+	ManagementTLVHead
+
+	PortIdentity            PortIdentity
+	PortState               PortState
+	LogMinDelayReqInterval  LogInterval
+	PeerMeanPathDelay       TimeInterval
+	LogAnnounceInterval     LogInterval
+	AnnounceReceiptTimeout  uint8
+	LogSyncInterval         LogInterval
+	DelayMechanism          DelayMechanism
+	LogMinPdelayReqInterval LogInterval
+	VersionNumber           uint8
+}
+
+// Priority1TLV Spec Table 74 - PRIORITY1 management TLV data field
+type Priority1TLV struct {
+	ManagementTLVHead
+
+	Priority1 uint8
+	Reserved  uint8
+}
+
+// Priority2TLV Spec Table 75 - PRIORITY2 management TLV data field
+type Priority2TLV struct {
+	ManagementTLVHead
+
+	Priority2 uint8
+	Reserved  uint8
+}
+
+// mgmtGetRequest prepares GET request packet for management TLV.
+// Data of the TLV is sent zeroed, size is the size of the whole TLV.
+func mgmtGetRequest(tlv ManagementTLV, size uint16) *Management {
+	headerSize := uint16(binary.Size(ManagementMsgHead{}))
+	return &Management{
+		ManagementMsgHead: ManagementMsgHead{
+			Header: Header{
+				SdoIDAndMsgType:    NewSdoIDAndMsgType(MessageManagement, 0),
+				Version:            Version,
+				MessageLength:      headerSize + size,
+				SourcePortIdentity: identity,
+				LogMessageInterval: MgmtLogMessageInterval,
+			},
+			TargetPortIdentity:   DefaultTargetPortIdentity,
+			StartingBoundaryHops: 0,
+			BoundaryHops:         0,
+			ActionField:          GET,
+		},
+		TLV: tlv,
+	}
+}
+
+// mgmtTLVHead returns ManagementTLVHead for TLV of given size
+func mgmtTLVHead(id ManagementID, size uint16) ManagementTLVHead {
+	return ManagementTLVHead{
+		TLVHead: TLVHead{
+			TLVType:     TLVManagement,
+			LengthField: size - tlvHeadSize,
+		},
+		ManagementID: id,
+	}
+}
+
+// TimePropertiesDataSetRequest prepares request packet for TIME_PROPERTIES_DATA_SET request
+func TimePropertiesDataSetRequest() *Management {
+	size := uint16(binary.Size(TimePropertiesDataSetTLV{}))
+	return mgmtGetRequest(&TimePropertiesDataSetTLV{ManagementTLVHead: mgmtTLVHead(IDTimePropertiesDataSet, size)}, size)
+}
+
+// PortDataSetRequest prepares request packet for PORT_DATA_SET request
+func PortDataSetRequest() *Management {
+	size := uint16(binary.Size(PortDataSetTLV{}))
+	return mgmtGetRequest(&PortDataSetTLV{ManagementTLVHead: mgmtTLVHead(IDPortDataSet, size)}, size)
+}
+
+// Priority1Request prepares request packet for PRIORITY1 request
+func Priority1Request() *Management {
+	size := uint16(binary.Size(Priority1TLV{}))
+	return mgmtGetRequest(&Priority1TLV{ManagementTLVHead: mgmtTLVHead(IDPriority1, size)}, size)
+}
+
+// Priority2Request prepares request packet for PRIORITY2 request
+func Priority2Request() *Management {
+	size := uint16(binary.Size(Priority2TLV{}))
+	return mgmtGetRequest(&Priority2TLV{ManagementTLVHead: mgmtTLVHead(IDPriority2, size)}, size)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have multicast destinations of PTP messages for all supported transports,
+// as per IEEE 1588-2019 Annex C (UDP over IPv4), Annex D (UDP over IPv6) and Annex E (IEEE 802.3)
+
+import (
+	"fmt"
+	"net"
+)
+
+// Multicast MAC addresses as per IEEE 1588-2019 Annex E
+var (
+	// MulticastMAC is used for all messages except peer delay ones
+	MulticastMAC = net.HardwareAddr{0x01, 0x1B, 0x19, 0x00, 0x00, 0x00}
+	// PeerDelayMulticastMAC is used for peer delay messages
+	PeerDelayMulticastMAC = net.HardwareAddr{0x01, 0x80, 0xC2, 0x00, 0x00, 0x0E}
+)
+
+// IPv4 multicast addresses as per IEEE 1588-2019 Annex C
+var (
+	// MulticastIPv4 is used for all messages except peer delay ones
+	MulticastIPv4 = net.IPv4(224, 0, 1, 129)
+	// PeerDelayMulticastIPv4 is used for peer delay messages
+	PeerDelayMulticastIPv4 = net.IPv4(224, 0, 0, 107)
+)
+
+// PeerDelayMulticastIPv6 is used for peer delay messages, which never leave the link
+var PeerDelayMulticastIPv6 = net.ParseIP("ff02::6b")
+
+// IPv6Scope is the scope of IPv6 multicast address, the X in FF0X::181
+type IPv6Scope uint8
+
+// IPv6 multicast scopes as per RFC 4291 and RFC 7346
+const (
+	IPv6ScopeInterfaceLocal    IPv6Scope = 0x1
+	IPv6ScopeLinkLocal         IPv6Scope = 0x2
+	IPv6ScopeRealmLocal        IPv6Scope = 0x3
+	IPv6ScopeAdminLocal        IPv6Scope = 0x4
+	IPv6ScopeSiteLocal         IPv6Scope = 0x5
+	IPv6ScopeOrganizationLocal IPv6Scope = 0x8
+	IPv6ScopeGlobal            IPv6Scope = 0xE
+)
+
+// IsEvent checks if messages of this type are event messages, which are timestamped and sent to PortEvent
+func (m MessageType) IsEvent() bool {
+	return m <= MessagePDelayResp
+}
+
+// isPeerDelay checks if messages of this type are part of peer delay mechanism
+func (m MessageType) isPeerDelay() bool {
+	return m == MessagePDelayReq || m == MessagePDelayResp || m == MessagePDelayRespFollowUp
+}
+
+// MulticastDestination returns multicast MAC address messages of given type are sent to over IEEE 802.3
+func MulticastDestination(msgType MessageType) net.HardwareAddr {
+	if msgType.isPeerDelay() {
+		return PeerDelayMulticastMAC
+	}
+	return MulticastMAC
+}
+
+// MulticastIPv6 returns FF0X::181 address of given scope used for all messages except peer delay ones
+func MulticastIPv6(scope IPv6Scope) (net.IP, error) {
+	if scope == 0 || scope > 0xE {
+		return nil, fmt.Errorf("invalid IPv6 multicast scope %#x", uint8(scope))
+	}
+	ip := net.ParseIP("ff00::181")
+	ip[1] = byte(scope)
+	return ip, nil
+}
+
+// MulticastIP returns multicast IP address messages of given type are sent to over UDP.
+// Scope is only used for IPv6, and is ignored for peer delay messages which are always link-local.
+func MulticastIP(msgType MessageType, proto NetworkProtocol, scope IPv6Scope) (net.IP, error) {
+	switch proto {
+	case NetworkProtocolUDPIPv4:
+		if msgType.isPeerDelay() {
+			return PeerDelayMulticastIPv4, nil
+		}
+		return MulticastIPv4, nil
+	case NetworkProtocolUDPIPv6:
+		if msgType.isPeerDelay() {
+			return PeerDelayMulticastIPv6, nil
+		}
+		return MulticastIPv6(scope)
+	}
+	return nil, fmt.Errorf("unsupported network protocol %s (%d) for multicast IP", proto, uint16(proto))
+}
+
+// MulticastUDPAddr returns multicast UDP address messages of given type are sent to, including the right port
+func MulticastUDPAddr(msgType MessageType, proto NetworkProtocol, scope IPv6Scope) (*net.UDPAddr, error) {
+	ip, err := MulticastIP(msgType, proto, scope)
+	if err != nil {
+		return nil, err
+	}
+	port := PortGeneral
+	if msgType.IsEvent() {
+		port = PortEvent
+	}
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+// DelayRequestType returns type of the message used to request delay measurement by given delay mechanism
+func DelayRequestType(mechanism DelayMechanism) (MessageType, error) {
+	switch mechanism {
+	case DelayMechanismE2E:
+		return MessageDelayReq, nil
+	case DelayMechanismP2P, DelayMechanismCommonP2P:
+		return MessagePDelayReq, nil
+	}
+	return 0, fmt.Errorf("delay mechanism %s doesn't send delay requests", mechanism)
+}
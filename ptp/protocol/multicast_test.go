@@ -0,0 +1,102 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMulticastIPv6(t *testing.T) {
+	ip, err := MulticastIPv6(IPv6ScopeGlobal)
+	require.Nil(t, err)
+	require.Equal(t, "ff0e::181", ip.String())
+	ip, err = MulticastIPv6(IPv6ScopeLinkLocal)
+	require.Nil(t, err)
+	require.Equal(t, "ff02::181", ip.String())
+	_, err = MulticastIPv6(0)
+	require.Error(t, err)
+	_, err = MulticastIPv6(0xf)
+	require.Error(t, err)
+}
+
+func TestMulticastIP(t *testing.T) {
+	tests := []struct {
+		msgType MessageType
+		proto   NetworkProtocol
+		want    string
+	}{
+		{MessageSync, NetworkProtocolUDPIPv4, "224.0.1.129"},
+		{MessageAnnounce, NetworkProtocolUDPIPv4, "224.0.1.129"},
+		{MessagePDelayReq, NetworkProtocolUDPIPv4, "224.0.0.107"},
+		{MessagePDelayRespFollowUp, NetworkProtocolUDPIPv4, "224.0.0.107"},
+		{MessageDelayReq, NetworkProtocolUDPIPv6, "ff05::181"},
+		{MessagePDelayResp, NetworkProtocolUDPIPv6, "ff02::6b"},
+	}
+	for _, tt := range tests {
+		ip, err := MulticastIP(tt.msgType, tt.proto, IPv6ScopeSiteLocal)
+		require.Nil(t, err)
+		require.Equal(t, tt.want, ip.String(), tt.msgType)
+	}
+	_, err := MulticastIP(MessageSync, NetworkProtocolIEEE8023, IPv6ScopeSiteLocal)
+	require.Error(t, err)
+	_, err = MulticastIP(MessageSync, NetworkProtocolUDPIPv6, 0)
+	require.Error(t, err)
+}
+
+func TestMulticastUDPAddr(t *testing.T) {
+	addr, err := MulticastUDPAddr(MessageSync, NetworkProtocolUDPIPv4, 0)
+	require.Nil(t, err)
+	require.Equal(t, &net.UDPAddr{IP: MulticastIPv4, Port: PortEvent}, addr)
+
+	addr, err = MulticastUDPAddr(MessageFollowUp, NetworkProtocolUDPIPv6, IPv6ScopeGlobal)
+	require.Nil(t, err)
+	require.Equal(t, "[ff0e::181]:320", addr.String())
+
+	_, err = MulticastUDPAddr(MessageFollowUp, NetworkProtocolIEEE8023, IPv6ScopeGlobal)
+	require.Error(t, err)
+}
+
+func TestMessageTypeIsEvent(t *testing.T) {
+	for _, m := range []MessageType{MessageSync, MessageDelayReq, MessagePDelayReq, MessagePDelayResp} {
+		require.True(t, m.IsEvent(), m)
+	}
+	for _, m := range []MessageType{MessageFollowUp, MessageDelayResp, MessagePDelayRespFollowUp, MessageAnnounce, MessageSignaling, MessageManagement} {
+		require.False(t, m.IsEvent(), m)
+	}
+}
+
+func TestMulticastDestination(t *testing.T) {
+	require.Equal(t, MulticastMAC, MulticastDestination(MessageSync))
+	require.Equal(t, MulticastMAC, MulticastDestination(MessageAnnounce))
+	require.Equal(t, PeerDelayMulticastMAC, MulticastDestination(MessagePDelayReq))
+	require.Equal(t, PeerDelayMulticastMAC, MulticastDestination(MessagePDelayRespFollowUp))
+}
+
+func TestDelayRequestType(t *testing.T) {
+	m, err := DelayRequestType(DelayMechanismE2E)
+	require.Nil(t, err)
+	require.Equal(t, MessageDelayReq, m)
+	m, err = DelayRequestType(DelayMechanismP2P)
+	require.Nil(t, err)
+	require.Equal(t, MessagePDelayReq, m)
+	require.Equal(t, PeerDelayMulticastMAC, MulticastDestination(m))
+	_, err = DelayRequestType(DelayMechanismNoMechanism)
+	require.Error(t, err)
+}
@@ -0,0 +1,379 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have support for NetSync Monitor (NSM) extension, as implemented by linuxptp.
+// NSM client sends unicast Delay_Req carrying PTPMON_REQ TLV,
+// and NSM capable port replies with unicast Sync, Follow_Up and Delay_Resp carrying PTPMON_RESP TLV.
+// This allows to measure the offset of the remote port without subscribing to it.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// NetworkProtocol is the network protocol of PortAddress as per Table 3 networkProtocol enumerations
+type NetworkProtocol uint16
+
+// Table 3 networkProtocol enumerations
+const (
+	NetworkProtocolUDPIPv4    NetworkProtocol = 1
+	NetworkProtocolUDPIPv6    NetworkProtocol = 2
+	NetworkProtocolIEEE8023   NetworkProtocol = 3
+	NetworkProtocolDeviceNet  NetworkProtocol = 4
+	NetworkProtocolControlNet NetworkProtocol = 5
+	NetworkProtocolPROFINET   NetworkProtocol = 6
+)
+
+// NetworkProtocolToString is a map from NetworkProtocol to string
+var NetworkProtocolToString = map[NetworkProtocol]string{
+	NetworkProtocolUDPIPv4:    "UDP_IPV4",
+	NetworkProtocolUDPIPv6:    "UDP_IPV6",
+	NetworkProtocolIEEE8023:   "IEEE_802_3",
+	NetworkProtocolDeviceNet:  "DEVICENET",
+	NetworkProtocolControlNet: "CONTROLNET",
+	NetworkProtocolPROFINET:   "PROFINET",
+}
+
+func (p NetworkProtocol) String() string {
+	return NetworkProtocolToString[p]
+}
+
+// PortAddress is a protocol address of the port as per 5.3.6 PortAddress
+type PortAddress struct {
+	NetworkProtocol NetworkProtocol
+	AddressLength   uint16
+	AddressField    []byte
+}
+
+const portAddressHeadSize = 4
+
+// NewPortAddress builds PortAddress from IP address
+func NewPortAddress(ip net.IP) PortAddress {
+	if ip4 := ip.To4(); ip4 != nil {
+		return PortAddress{NetworkProtocol: NetworkProtocolUDPIPv4, AddressLength: net.IPv4len, AddressField: ip4}
+	}
+	return PortAddress{NetworkProtocol: NetworkProtocolUDPIPv6, AddressLength: net.IPv6len, AddressField: ip.To16()}
+}
+
+// IP returns IP address stored in PortAddress, nil if it's not UDP address
+func (p *PortAddress) IP() net.IP {
+	switch p.NetworkProtocol {
+	case NetworkProtocolUDPIPv4, NetworkProtocolUDPIPv6:
+		return net.IP(p.AddressField)
+	}
+	return nil
+}
+
+// MarshalBinaryTo marshals PortAddress into provided []byte
+func (p *PortAddress) MarshalBinaryTo(b []byte) (int, error) {
+	if int(p.AddressLength) != len(p.AddressField) {
+		return 0, fmt.Errorf("PortAddress length %d doesn't match address size %d", p.AddressLength, len(p.AddressField))
+	}
+	if len(b) < portAddressHeadSize+len(p.AddressField) {
+		return 0, fmt.Errorf("not enough buffer to write PortAddress")
+	}
+	binary.BigEndian.PutUint16(b, uint16(p.NetworkProtocol))
+	binary.BigEndian.PutUint16(b[2:], p.AddressLength)
+	copy(b[portAddressHeadSize:], p.AddressField)
+	return portAddressHeadSize + len(p.AddressField), nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *PortAddress) UnmarshalBinary(b []byte) error {
+	if len(b) < portAddressHeadSize {
+		return fmt.Errorf("not enough data to decode PortAddress")
+	}
+	p.NetworkProtocol = NetworkProtocol(binary.BigEndian.Uint16(b))
+	p.AddressLength = binary.BigEndian.Uint16(b[2:])
+	if len(b) < portAddressHeadSize+int(p.AddressLength) {
+		return fmt.Errorf("not enough data to decode PortAddress")
+	}
+	p.AddressField = make([]byte, p.AddressLength)
+	copy(p.AddressField, b[portAddressHeadSize:])
+	return nil
+}
+
+// PTPMonRequestTLV is PTPMON_REQ TLV, which turns Delay_Req into NetSync Monitor request. It has no data.
+type PTPMonRequestTLV struct {
+	TLVHead
+}
+
+// MarshalBinaryTo marshals TLV into provided []byte
+func (t *PTPMonRequestTLV) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < tlvHeadSize {
+		return 0, fmt.Errorf("not enough buffer to write PTPMonRequestTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	return tlvHeadSize, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *PTPMonRequestTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.TLVType != TLVPTPMonRequest {
+		return fmt.Errorf("not a PTPMON_REQ TLV: %s", t.TLVType)
+	}
+	return nil
+}
+
+// ptpMonResponseFixedSize is the size of PTPMON_RESP data after the parent address:
+// parent dataset (32), current dataset (18), time properties dataset (4) and last sync timestamp (10)
+const ptpMonResponseFixedSize = 64
+
+// PTPMonResponseTLV is PTPMON_RESP TLV carrying state of the port replying to NetSync Monitor request.
+// Datasets are encoded the same way as in corresponding management TLVs.
+type PTPMonResponseTLV struct {
+	TLVHead
+	PortState     PortState
+	Reserved      uint8
+	ParentAddress PortAddress
+
+	// PARENT_DATA_SET
+	ParentPortIdentity                    PortIdentity
+	PS                                    uint8
+	ParentReserved                        uint8
+	ObservedParentOffsetScaledLogVariance uint16
+	ObservedParentClockPhaseChangeRate    uint32
+	GrandmasterPriority1                  uint8
+	GrandmasterClockQuality               ClockQuality
+	GrandmasterPriority2                  uint8
+	GrandmasterIdentity                   ClockIdentity
+
+	// CURRENT_DATA_SET
+	StepsRemoved     uint16
+	OffsetFromMaster TimeInterval
+	MeanPathDelay    TimeInterval
+
+	// TIME_PROPERTIES_DATA_SET
+	CurrentUTCOffset int16
+	// Flags are the same as second octet of FlagField, e.g. FlagLeap61
+	Flags      uint8
+	TimeSource TimeSource
+
+	// LastSync is the time the port last received Sync
+	LastSync Timestamp
+}
+
+// MarshalBinaryTo marshals TLV into provided []byte
+func (t *PTPMonResponseTLV) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < tlvHeadSize+2 {
+		return 0, fmt.Errorf("not enough buffer to write PTPMonResponseTLV")
+	}
+	b[tlvHeadSize] = byte(t.PortState)
+	b[tlvHeadSize+1] = t.Reserved
+	pos := tlvHeadSize + 2
+	n, err := t.ParentAddress.MarshalBinaryTo(b[pos:])
+	if err != nil {
+		return 0, err
+	}
+	pos += n
+	if len(b) < pos+ptpMonResponseFixedSize {
+		return 0, fmt.Errorf("not enough buffer to write PTPMonResponseTLV")
+	}
+	binary.BigEndian.PutUint64(b[pos:], uint64(t.ParentPortIdentity.ClockIdentity))
+	binary.BigEndian.PutUint16(b[pos+8:], t.ParentPortIdentity.PortNumber)
+	b[pos+10] = t.PS
+	b[pos+11] = t.ParentReserved
+	binary.BigEndian.PutUint16(b[pos+12:], t.ObservedParentOffsetScaledLogVariance)
+	binary.BigEndian.PutUint32(b[pos+14:], t.ObservedParentClockPhaseChangeRate)
+	b[pos+18] = t.GrandmasterPriority1
+	b[pos+19] = t.GrandmasterClockQuality.ClockClass
+	b[pos+20] = t.GrandmasterClockQuality.ClockAccuracy
+	binary.BigEndian.PutUint16(b[pos+21:], t.GrandmasterClockQuality.OffsetScaledLogVariance)
+	b[pos+23] = t.GrandmasterPriority2
+	binary.BigEndian.PutUint64(b[pos+24:], uint64(t.GrandmasterIdentity))
+	pos += 32
+	binary.BigEndian.PutUint16(b[pos:], t.StepsRemoved)
+	binary.BigEndian.PutUint64(b[pos+2:], uint64(t.OffsetFromMaster))
+	binary.BigEndian.PutUint64(b[pos+10:], uint64(t.MeanPathDelay))
+	pos += 18
+	binary.BigEndian.PutUint16(b[pos:], uint16(t.CurrentUTCOffset))
+	b[pos+2] = t.Flags
+	b[pos+3] = byte(t.TimeSource)
+	pos += 4
+	copy(b[pos:], t.LastSync.Seconds[:]) //uint48
+	binary.BigEndian.PutUint32(b[pos+6:], t.LastSync.Nanoseconds)
+	pos += 10
+
+	t.TLVType = TLVPTPMonResponse
+	t.LengthField = uint16(pos - tlvHeadSize)
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	return pos, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *PTPMonResponseTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.TLVType != TLVPTPMonResponse {
+		return fmt.Errorf("not a PTPMON_RESP TLV: %s", t.TLVType)
+	}
+	if len(b) < tlvHeadSize+int(t.LengthField) || t.LengthField < 2+portAddressHeadSize+ptpMonResponseFixedSize {
+		return fmt.Errorf("not enough data to decode PTPMonResponseTLV")
+	}
+	b = b[:tlvHeadSize+int(t.LengthField)]
+	t.PortState = PortState(b[tlvHeadSize])
+	t.Reserved = b[tlvHeadSize+1]
+	pos := tlvHeadSize + 2
+	if err := t.ParentAddress.UnmarshalBinary(b[pos:]); err != nil {
+		return err
+	}
+	pos += portAddressHeadSize + int(t.ParentAddress.AddressLength)
+	if len(b) < pos+ptpMonResponseFixedSize {
+		return fmt.Errorf("not enough data to decode PTPMonResponseTLV")
+	}
+	t.ParentPortIdentity.ClockIdentity = ClockIdentity(binary.BigEndian.Uint64(b[pos:]))
+	t.ParentPortIdentity.PortNumber = binary.BigEndian.Uint16(b[pos+8:])
+	t.PS = b[pos+10]
+	t.ParentReserved = b[pos+11]
+	t.ObservedParentOffsetScaledLogVariance = binary.BigEndian.Uint16(b[pos+12:])
+	t.ObservedParentClockPhaseChangeRate = binary.BigEndian.Uint32(b[pos+14:])
+	t.GrandmasterPriority1 = b[pos+18]
+	t.GrandmasterClockQuality.ClockClass = b[pos+19]
+	t.GrandmasterClockQuality.ClockAccuracy = b[pos+20]
+	t.GrandmasterClockQuality.OffsetScaledLogVariance = binary.BigEndian.Uint16(b[pos+21:])
+	t.GrandmasterPriority2 = b[pos+23]
+	t.GrandmasterIdentity = ClockIdentity(binary.BigEndian.Uint64(b[pos+24:]))
+	pos += 32
+	t.StepsRemoved = binary.BigEndian.Uint16(b[pos:])
+	t.OffsetFromMaster = TimeInterval(binary.BigEndian.Uint64(b[pos+2:]))
+	t.MeanPathDelay = TimeInterval(binary.BigEndian.Uint64(b[pos+10:]))
+	pos += 18
+	t.CurrentUTCOffset = int16(binary.BigEndian.Uint16(b[pos:]))
+	t.Flags = b[pos+2]
+	t.TimeSource = TimeSource(b[pos+3])
+	pos += 4
+	copy(t.LastSync.Seconds[:], b[pos:]) //uint48
+	t.LastSync.Nanoseconds = binary.BigEndian.Uint32(b[pos+6:])
+	return nil
+}
+
+// NetSyncMonitorRequest is a Delay_Req carrying PTPMON_REQ TLV
+type NetSyncMonitorRequest struct {
+	SyncDelayReq
+	PTPMonRequestTLV
+}
+
+// NewNetSyncMonitorRequest builds NetSync Monitor request out of Delay_Req
+func NewNetSyncMonitorRequest(req *SyncDelayReq) *NetSyncMonitorRequest {
+	p := &NetSyncMonitorRequest{
+		SyncDelayReq: *req,
+		PTPMonRequestTLV: PTPMonRequestTLV{
+			TLVHead: TLVHead{TLVType: TLVPTPMonRequest},
+		},
+	}
+	p.FlagField |= FlagUnicast
+	p.MessageLength = headerSize + 10 + tlvHeadSize
+	return p
+}
+
+// MarshalBinaryTo marshals packet into provided []byte
+func (p *NetSyncMonitorRequest) MarshalBinaryTo(b []byte) (int, error) {
+	n, err := p.SyncDelayReq.MarshalBinaryTo(b)
+	if err != nil {
+		return 0, err
+	}
+	nn, err := p.PTPMonRequestTLV.MarshalBinaryTo(b[n:])
+	if err != nil {
+		return 0, err
+	}
+	return n + nn, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *NetSyncMonitorRequest) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, headerSize+10+tlvHeadSize)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *NetSyncMonitorRequest) UnmarshalBinary(b []byte) error {
+	if err := p.SyncDelayReq.UnmarshalBinary(b); err != nil {
+		return err
+	}
+	if p.MessageType() != MessageDelayReq {
+		return fmt.Errorf("not a delay request message %v", p.MessageType())
+	}
+	if len(b) < headerSize+10+tlvHeadSize || int(p.MessageLength) < headerSize+10+tlvHeadSize {
+		return fmt.Errorf("not enough data to decode NetSyncMonitorRequest")
+	}
+	return p.PTPMonRequestTLV.UnmarshalBinary(b[headerSize+10:])
+}
+
+// NetSyncMonitorResponse is a Delay_Resp carrying PTPMON_RESP TLV
+type NetSyncMonitorResponse struct {
+	DelayResp
+	PTPMonResponseTLV
+}
+
+// MarshalBinaryTo marshals packet into provided []byte, updating MessageLength
+func (p *NetSyncMonitorResponse) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < headerSize+20 {
+		return 0, fmt.Errorf("not enough buffer to write DelayResp")
+	}
+	nn, err := p.PTPMonResponseTLV.MarshalBinaryTo(b[headerSize+20:])
+	if err != nil {
+		return 0, err
+	}
+	p.MessageLength = uint16(headerSize + 20 + nn)
+	n, err := p.DelayResp.MarshalBinaryTo(b)
+	if err != nil {
+		return 0, err
+	}
+	return n + nn, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *NetSyncMonitorResponse) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 508)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *NetSyncMonitorResponse) UnmarshalBinary(b []byte) error {
+	if err := p.DelayResp.UnmarshalBinary(b); err != nil {
+		return err
+	}
+	if p.MessageType() != MessageDelayResp {
+		return fmt.Errorf("not a delay response message %v", p.MessageType())
+	}
+	if len(b) < int(p.MessageLength) || p.MessageLength < headerSize+20 {
+		return fmt.Errorf("not enough data to decode NetSyncMonitorResponse")
+	}
+	return p.PTPMonResponseTLV.UnmarshalBinary(b[headerSize+20 : p.MessageLength])
+}
+
+// IsNetSyncMonitorRequest checks if raw packet is a Delay_Req carrying PTPMON_REQ TLV
+func IsNetSyncMonitorRequest(b []byte) bool {
+	if len(b) < headerSize+10+tlvHeadSize {
+		return false
+	}
+	if SdoIDAndMsgType(b[0]).MsgType() != MessageDelayReq {
+		return false
+	}
+	if int(binary.BigEndian.Uint16(b[2:])) < headerSize+10+tlvHeadSize {
+		return false
+	}
+	return TLVType(binary.BigEndian.Uint16(b[headerSize+10:])) == TLVPTPMonRequest
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPortAddress(t *testing.T) {
+	a := NewPortAddress(net.ParseIP("192.168.0.1"))
+	require.Equal(t, NetworkProtocolUDPIPv4, a.NetworkProtocol)
+	require.Equal(t, "UDP_IPV4", a.NetworkProtocol.String())
+	require.Equal(t, uint16(4), a.AddressLength)
+	require.True(t, net.ParseIP("192.168.0.1").Equal(a.IP()))
+
+	a = NewPortAddress(net.ParseIP("2401:db00::1"))
+	require.Equal(t, NetworkProtocolUDPIPv6, a.NetworkProtocol)
+	require.Equal(t, uint16(16), a.AddressLength)
+
+	b := make([]byte, 20)
+	n, err := a.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, 20, n)
+	got := PortAddress{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, a, got)
+
+	_, err = a.MarshalBinaryTo(b[:19])
+	require.Error(t, err)
+	require.Error(t, got.UnmarshalBinary(b[:19]))
+
+	l2 := PortAddress{NetworkProtocol: NetworkProtocolIEEE8023, AddressLength: 6, AddressField: []byte{1, 2, 3, 4, 5, 6}}
+	require.Nil(t, l2.IP())
+}
+
+func TestNetSyncMonitorRequest(t *testing.T) {
+	builder := NewBuilder(PortIdentity{PortNumber: 1, ClockIdentity: 1234}, 0)
+	p := NewNetSyncMonitorRequest(builder.DelayReq())
+	require.Equal(t, FlagUnicast, p.FlagField)
+	b, err := p.MarshalBinary()
+	require.Nil(t, err)
+	require.Equal(t, int(p.MessageLength), len(b))
+	require.True(t, IsNetSyncMonitorRequest(b))
+
+	got := &NetSyncMonitorRequest{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, p, got)
+
+	// plain Delay_Req is not NSM request
+	dreq, err := builder.DelayReq().MarshalBinary()
+	require.Nil(t, err)
+	require.False(t, IsNetSyncMonitorRequest(dreq))
+	require.False(t, IsNetSyncMonitorRequest(append(dreq, 0x21, 0xfe, 0, 0)))
+	require.Error(t, got.UnmarshalBinary(dreq))
+
+	// and can be decoded as usual
+	pp, err := DecodePacket(b)
+	require.Nil(t, err)
+	require.Equal(t, &p.SyncDelayReq, pp)
+}
+
+func TestNetSyncMonitorResponse(t *testing.T) {
+	builder := NewBuilder(PortIdentity{PortNumber: 1, ClockIdentity: 1234}, 0)
+	req := builder.DelayReq()
+	now := time.Unix(1653574589, 806492928)
+	p := &NetSyncMonitorResponse{
+		DelayResp: *builder.DelayResp(&req.Header, now),
+		PTPMonResponseTLV: PTPMonResponseTLV{
+			PortState:     PortStateSlave,
+			ParentAddress: NewPortAddress(net.ParseIP("10.0.0.1")),
+			ParentPortIdentity: PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: 5678,
+			},
+			GrandmasterPriority1: 128,
+			GrandmasterClockQuality: ClockQuality{
+				ClockClass:              6,
+				ClockAccuracy:           0x21,
+				OffsetScaledLogVariance: 23008,
+			},
+			GrandmasterPriority2: 128,
+			GrandmasterIdentity:  5678,
+			StepsRemoved:         1,
+			OffsetFromMaster:     NewTimeInterval(-42),
+			MeanPathDelay:        NewTimeInterval(1234),
+			CurrentUTCOffset:     37,
+			Flags:                uint8(FlagPTPTimescale),
+			TimeSource:           TimeSourceGNSS,
+			LastSync:             NewTimestamp(now),
+		},
+	}
+	b, err := p.MarshalBinary()
+	require.Nil(t, err)
+	require.Equal(t, headerSize+20+tlvHeadSize+2+8+ptpMonResponseFixedSize, len(b))
+	require.Equal(t, int(p.MessageLength), len(b))
+	require.Equal(t, TLVPTPMonResponse, p.TLVType)
+	require.Equal(t, uint16(2+8+ptpMonResponseFixedSize), p.LengthField)
+
+	got := &NetSyncMonitorResponse{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, p, got)
+
+	require.Error(t, got.UnmarshalBinary(b[:len(b)-1]))
+	_, err = p.MarshalBinaryTo(make([]byte, len(b)-1))
+	require.Error(t, err)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PathTraceTLV Table 115 PATH_TRACE TLV format
+type PathTraceTLV struct {
+	TLVHead
+	PathSequence []ClockIdentity
+}
+
+// NewPathTraceTLV returns PATH_TRACE TLV with given path sequence
+func NewPathTraceTLV(path []ClockIdentity) *PathTraceTLV {
+	return &PathTraceTLV{
+		TLVHead: TLVHead{
+			TLVType:     TLVPathTrace,
+			LengthField: uint16(8 * len(path)),
+		},
+		PathSequence: path,
+	}
+}
+
+// MarshalBinaryTo marshals TLV into provided []byte
+func (t *PathTraceTLV) MarshalBinaryTo(b []byte) (int, error) {
+	size := tlvHeadSize + 8*len(t.PathSequence)
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write PathTraceTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	for i, c := range t.PathSequence {
+		binary.BigEndian.PutUint64(b[tlvHeadSize+8*i:], uint64(c))
+	}
+	return size, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *PathTraceTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.LengthField%8 != 0 {
+		return fmt.Errorf("PathTraceTLV length %d is not a multiple of ClockIdentity size", t.LengthField)
+	}
+	if len(b) < tlvHeadSize+int(t.LengthField) {
+		return fmt.Errorf("not enough data to decode PathTraceTLV")
+	}
+	t.PathSequence = make([]ClockIdentity, t.LengthField/8)
+	for i := range t.PathSequence {
+		t.PathSequence[i] = ClockIdentity(binary.BigEndian.Uint64(b[tlvHeadSize+8*i:]))
+	}
+	return nil
+}
+
+// Contains returns true if clock identity is in the path sequence
+func (t *PathTraceTLV) Contains(c ClockIdentity) bool {
+	for _, p := range t.PathSequence {
+		if p == c {
+			return true
+		}
+	}
+	return false
+}
+
+// PathTrace returns PATH_TRACE TLV of Announce message, nil if there is none
+func (p *Announce) PathTrace() *PathTraceTLV {
+	for _, tlv := range p.TLVs {
+		if t, ok := tlv.(*PathTraceTLV); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// HasLoop returns true if Announce message went through the local clock already, as per 16.2.5.
+// Such messages shall be discarded.
+func (p *Announce) HasLoop(local ClockIdentity) bool {
+	t := p.PathTrace()
+	return t != nil && t.Contains(local)
+}
+
+// AppendPathTrace appends clock identity to PATH_TRACE TLV of Announce message, adding the TLV if there is none.
+// MessageLength is updated accordingly.
+func (p *Announce) AppendPathTrace(c ClockIdentity) {
+	t := p.PathTrace()
+	if t == nil {
+		t = NewPathTraceTLV(nil)
+		p.TLVs = append(p.TLVs, t)
+		p.MessageLength += tlvHeadSize
+	}
+	t.PathSequence = append(t.PathSequence, c)
+	t.LengthField += 8
+	p.MessageLength += 8
+}
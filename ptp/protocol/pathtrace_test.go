@@ -0,0 +1,117 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAnnounce() *Announce {
+	return &Announce{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageAnnounce, 0),
+			Version:         Version,
+			MessageLength:   headerSize + 30,
+			SourcePortIdentity: PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: 36138748164966842,
+			},
+		},
+		AnnounceBody: AnnounceBody{
+			GrandmasterPriority1: 128,
+			GrandmasterClockQuality: ClockQuality{
+				ClockClass:              6,
+				ClockAccuracy:           33,
+				OffsetScaledLogVariance: 23008,
+			},
+			GrandmasterPriority2: 128,
+			GrandmasterIdentity:  36138748164966842,
+			TimeSource:           TimeSourceGNSS,
+		},
+	}
+}
+
+func TestPathTraceTLV(t *testing.T) {
+	tlv := NewPathTraceTLV([]ClockIdentity{0x0102030405060708, 42})
+	b := make([]byte, 20)
+	n, err := tlv.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, []byte{
+		0x00, 0x08, 0x00, 0x10,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2a,
+	}, b[:n])
+
+	got := &PathTraceTLV{}
+	err = got.UnmarshalBinary(b)
+	require.Nil(t, err)
+	require.Equal(t, tlv, got)
+	require.True(t, got.Contains(42))
+	require.False(t, got.Contains(43))
+
+	_, err = tlv.MarshalBinaryTo(b[:19])
+	require.Error(t, err)
+	err = got.UnmarshalBinary(b[:19])
+	require.Error(t, err)
+	b[3] = 0x0f
+	err = got.UnmarshalBinary(b)
+	require.Error(t, err)
+}
+
+func TestAnnouncePathTrace(t *testing.T) {
+	p := testAnnounce()
+	require.Nil(t, p.PathTrace())
+	require.False(t, p.HasLoop(42))
+
+	p.AppendPathTrace(36138748164966842)
+	p.AppendPathTrace(42)
+	require.Equal(t, uint16(headerSize+30+tlvHeadSize+16), p.MessageLength)
+	require.Equal(t, []ClockIdentity{36138748164966842, 42}, p.PathTrace().PathSequence)
+	require.True(t, p.HasLoop(42))
+	require.False(t, p.HasLoop(43))
+
+	b, err := Bytes(p)
+	require.Nil(t, err)
+	require.Equal(t, int(p.MessageLength)+2, len(b))
+
+	got, err := DecodePacket(b)
+	require.Nil(t, err)
+	require.Equal(t, p, got)
+}
+
+func TestAnnounceUnknownTLV(t *testing.T) {
+	p := testAnnounce()
+	b, err := Bytes(p)
+	require.Nil(t, err)
+	// ORGANIZATION_EXTENSION of unknown organization is skipped
+	b = append(b[:headerSize+30], 0x00, 0x03, 0x00, 0x02, 0xff, 0xff)
+	b[3] += 6
+	p.MessageLength += 6
+	p.AppendPathTrace(42)
+	pt := make([]byte, tlvHeadSize+8)
+	_, err = p.PathTrace().MarshalBinaryTo(pt)
+	require.Nil(t, err)
+	b = append(b, pt...)
+	b[3] += tlvHeadSize + 8
+
+	got := &Announce{}
+	err = got.UnmarshalBinary(b)
+	require.Nil(t, err)
+	require.Equal(t, p, got)
+}
@@ -164,6 +164,7 @@ type AnnounceBody struct {
 type Announce struct {
 	Header
 	AnnounceBody
+	TLVs []TLV
 }
 
 func (p *Announce) MarshalBinaryTo(b []byte) (int, error) {
@@ -183,16 +184,105 @@ func (p *Announce) MarshalBinaryTo(b []byte) (int, error) {
 	binary.BigEndian.PutUint64(b[n+19:], uint64(p.GrandmasterIdentity))
 	binary.BigEndian.PutUint16(b[n+27:], p.StepsRemoved)
 	b[n+29] = byte(p.TimeSource)
-	return n + 30, nil
+	tlvLen, err := writeTLVs(p.TLVs, b[n+30:])
+	if err != nil {
+		return 0, err
+	}
+	return n + 30 + tlvLen, nil
 }
 
 // MarshalBinary converts packet to []bytes
 func (p *Announce) MarshalBinary() ([]byte, error) {
-	buf := make([]byte, 64)
+	size := headerSize + 30
+	for _, tlv := range p.TLVs {
+		if t, ok := tlv.(interface{ length() int }); ok {
+			size += tlvHeadSize + t.length()
+		}
+	}
+	buf := make([]byte, size)
 	n, err := p.MarshalBinaryTo(buf)
 	return buf[:n], err
 }
 
+// UnmarshalBinary parses []byte and populates struct fields
+func (p *Announce) UnmarshalBinary(b []byte) error {
+	if len(b) < headerSize+30 {
+		return fmt.Errorf("not enough data to decode Announce")
+	}
+	unmarshalHeader(&p.Header, b)
+	n := headerSize
+	copy(p.OriginTimestamp.Seconds[:], b[n:]) //uint48
+	p.OriginTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[n+6:])
+	p.CurrentUTCOffset = int16(binary.BigEndian.Uint16(b[n+10:]))
+	p.Reserved = b[n+12]
+	p.GrandmasterPriority1 = b[n+13]
+	p.GrandmasterClockQuality.ClockClass = b[n+14]
+	p.GrandmasterClockQuality.ClockAccuracy = b[n+15]
+	p.GrandmasterClockQuality.OffsetScaledLogVariance = binary.BigEndian.Uint16(b[n+16:])
+	p.GrandmasterPriority2 = b[n+18]
+	p.GrandmasterIdentity = ClockIdentity(binary.BigEndian.Uint64(b[n+19:]))
+	p.StepsRemoved = binary.BigEndian.Uint16(b[n+27:])
+	p.TimeSource = TimeSource(b[n+29])
+
+	p.TLVs = nil
+	pos := n + 30
+	for {
+		// packet can have trailing bytes, let's make sure we don't try to read past given length
+		if pos+tlvHeadSize > int(p.MessageLength) || pos+tlvHeadSize > len(b) {
+			break
+		}
+		tlvType := TLVType(binary.BigEndian.Uint16(b[pos:]))
+		length := int(binary.BigEndian.Uint16(b[pos+2:]))
+		switch tlvType {
+		case TLVPathTrace:
+			tlv := &PathTraceTLV{}
+			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
+				return err
+			}
+			p.TLVs = append(p.TLVs, tlv)
+		case TLVAlternateTimeOffsetIndicator:
+			tlv := &AlternateTimeOffsetIndicatorTLV{}
+			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
+				return err
+			}
+			p.TLVs = append(p.TLVs, tlv)
+		case TLVAuthentication:
+			tlv := &AuthenticationTLV{}
+			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
+				return err
+			}
+			p.TLVs = append(p.TLVs, tlv)
+		case TLVOrganizationExtension:
+			if !isSMPTETLV(b[pos:]) {
+				tlv, err := decodeRegisteredTLV(b[pos:])
+				if err != nil {
+					return err
+				}
+				if tlv != nil {
+					p.TLVs = append(p.TLVs, tlv)
+				}
+				break
+			}
+			tlv := &SMPTETLV{}
+			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
+				return err
+			}
+			p.TLVs = append(p.TLVs, tlv)
+		default:
+			tlv, err := decodeRegisteredTLV(b[pos:])
+			if err != nil {
+				return err
+			}
+			if tlv != nil {
+				p.TLVs = append(p.TLVs, tlv)
+			}
+		}
+		// TLVs we don't know about are skipped as per 14.2.2
+		pos += tlvHeadSize + length
+	}
+	return nil
+}
+
 // SyncDelayReqBody Table 44 Sync and Delay_Req message fields
 type SyncDelayReqBody struct {
 	OriginTimestamp Timestamp
@@ -324,6 +414,35 @@ type PDelayReq struct {
 	PDelayReqBody
 }
 
+func (p *PDelayReq) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < headerSize+20 {
+		return 0, fmt.Errorf("not enough buffer to write PDelayReq")
+	}
+	n := headerMarshalBinaryTo(&p.Header, b)
+	copy(b[n:], p.OriginTimestamp.Seconds[:]) //uint48
+	binary.BigEndian.PutUint32(b[n+6:], p.OriginTimestamp.Nanoseconds)
+	copy(b[n+10:], p.Reserved[:])
+	return n + 20, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *PDelayReq) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 54)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+func (p *PDelayReq) UnmarshalBinary(b []byte) error {
+	if len(b) < headerSize+20 {
+		return fmt.Errorf("not enough data to decode PDelayReq")
+	}
+	unmarshalHeader(&p.Header, b)
+	copy(p.OriginTimestamp.Seconds[:], b[headerSize:]) //uint48
+	p.OriginTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[headerSize+6:])
+	copy(p.Reserved[:], b[headerSize+10:])
+	return nil
+}
+
 // PDelayRespBody Table 48 Pdelay_Resp message fields
 type PDelayRespBody struct {
 	RequestReceiptTimestamp Timestamp
@@ -336,6 +455,37 @@ type PDelayResp struct {
 	PDelayRespBody
 }
 
+func (p *PDelayResp) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < headerSize+20 {
+		return 0, fmt.Errorf("not enough buffer to write PDelayResp")
+	}
+	n := headerMarshalBinaryTo(&p.Header, b)
+	copy(b[n:], p.RequestReceiptTimestamp.Seconds[:]) //uint48
+	binary.BigEndian.PutUint32(b[n+6:], p.RequestReceiptTimestamp.Nanoseconds)
+	binary.BigEndian.PutUint64(b[n+10:], uint64(p.RequestingPortIdentity.ClockIdentity))
+	binary.BigEndian.PutUint16(b[n+18:], p.RequestingPortIdentity.PortNumber)
+	return n + 20, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *PDelayResp) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 54)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+func (p *PDelayResp) UnmarshalBinary(b []byte) error {
+	if len(b) < headerSize+20 {
+		return fmt.Errorf("not enough data to decode PDelayResp")
+	}
+	unmarshalHeader(&p.Header, b)
+	copy(p.RequestReceiptTimestamp.Seconds[:], b[headerSize:]) //uint48
+	p.RequestReceiptTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[headerSize+6:])
+	p.RequestingPortIdentity.ClockIdentity = ClockIdentity(binary.BigEndian.Uint64(b[headerSize+10:]))
+	p.RequestingPortIdentity.PortNumber = binary.BigEndian.Uint16(b[headerSize+18:])
+	return nil
+}
+
 // PDelayRespFollowUpBody Table 49 Pdelay_Resp_Follow_Up message fields
 type PDelayRespFollowUpBody struct {
 	ResponseOriginTimestamp Timestamp
@@ -348,6 +498,37 @@ type PDelayRespFollowUp struct {
 	PDelayRespFollowUpBody
 }
 
+func (p *PDelayRespFollowUp) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < headerSize+20 {
+		return 0, fmt.Errorf("not enough buffer to write PDelayRespFollowUp")
+	}
+	n := headerMarshalBinaryTo(&p.Header, b)
+	copy(b[n:], p.ResponseOriginTimestamp.Seconds[:]) //uint48
+	binary.BigEndian.PutUint32(b[n+6:], p.ResponseOriginTimestamp.Nanoseconds)
+	binary.BigEndian.PutUint64(b[n+10:], uint64(p.RequestingPortIdentity.ClockIdentity))
+	binary.BigEndian.PutUint16(b[n+18:], p.RequestingPortIdentity.PortNumber)
+	return n + 20, nil
+}
+
+// MarshalBinary converts packet to []bytes
+func (p *PDelayRespFollowUp) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 54)
+	n, err := p.MarshalBinaryTo(buf)
+	return buf[:n], err
+}
+
+func (p *PDelayRespFollowUp) UnmarshalBinary(b []byte) error {
+	if len(b) < headerSize+20 {
+		return fmt.Errorf("not enough data to decode PDelayRespFollowUp")
+	}
+	unmarshalHeader(&p.Header, b)
+	copy(p.ResponseOriginTimestamp.Seconds[:], b[headerSize:]) //uint48
+	p.ResponseOriginTimestamp.Nanoseconds = binary.BigEndian.Uint32(b[headerSize+6:])
+	p.RequestingPortIdentity.ClockIdentity = ClockIdentity(binary.BigEndian.Uint64(b[headerSize+10:]))
+	p.RequestingPortIdentity.PortNumber = binary.BigEndian.Uint16(b[headerSize+18:])
+	return nil
+}
+
 // Packet is an iterface to abstract all different packets
 type Packet interface {
 	MessageType() MessageType
@@ -412,6 +593,9 @@ func DecodePacket(b []byte) (Packet, error) {
 	if err := binary.Read(r, binary.BigEndian, head); err != nil {
 		return nil, err
 	}
+	if err := CheckVersion(head.Version); err != nil {
+		return nil, err
+	}
 	msgType := head.MessageType()
 	var p Packet
 	switch msgType {
@@ -165,6 +165,122 @@ func Test_parsePDelayReq(t *testing.T) {
 	assert.Equal(t, &want, pp)
 }
 
+func Test_parsePDelayResp(t *testing.T) {
+	raw := []uint8{
+		0x03, 0x02, 0x00, 0x36, 0x00, 0x00, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x63, 0xff,
+		0xff, 0x00, 0x09, 0xba, 0x00, 0x01, 0x9e, 0x57,
+		0x05, 0x7f, 0x00, 0x00, 0x45, 0xb1, 0x11, 0x5e,
+		0x04, 0x5d, 0xd2, 0x6e, 0x0c, 0x42, 0xa1, 0xff,
+		0xfe, 0x01, 0x02, 0x03, 0x00, 0x02, 0x00, 0x00,
+	}
+	packet := new(PDelayResp)
+	err := FromBytes(raw, packet)
+	require.Nil(t, err)
+	want := PDelayResp{
+		Header: Header{
+			SdoIDAndMsgType:     NewSdoIDAndMsgType(MessagePDelayResp, 0),
+			Version:             MajorVersion,
+			MessageLength:       54,
+			DomainNumber:        0,
+			MinorSdoID:          0,
+			FlagField:           FlagTwoStep,
+			CorrectionField:     0,
+			MessageTypeSpecific: 0,
+			SourcePortIdentity: PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: 36138748164966842,
+			},
+			SequenceID:         40535,
+			ControlField:       5,
+			LogMessageInterval: 0x7f,
+		},
+		PDelayRespBody: PDelayRespBody{
+			RequestReceiptTimestamp: Timestamp{
+				Seconds:     [6]byte{0x0, 0x00, 0x45, 0xb1, 0x11, 0x5e},
+				Nanoseconds: 73257582,
+			},
+			RequestingPortIdentity: PortIdentity{
+				PortNumber:    2,
+				ClockIdentity: 0x0c42a1fffe010203,
+			},
+		},
+	}
+	require.Equal(t, want, *packet)
+	b, err := Bytes(packet)
+	require.Nil(t, err)
+	assert.Equal(t, raw, b)
+
+	// test generic DecodePacket as well
+	pp, err := DecodePacket(raw)
+	require.Nil(t, err)
+	assert.Equal(t, &want, pp)
+
+	err = FromBytes(raw[:headerSize+10], packet)
+	require.Error(t, err)
+	_, err = packet.MarshalBinaryTo(make([]byte, headerSize+10))
+	require.Error(t, err)
+}
+
+func Test_parsePDelayRespFollowUp(t *testing.T) {
+	raw := []uint8{
+		0x0a, 0x02, 0x00, 0x36, 0x00, 0x00, 0x02, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x63, 0xff,
+		0xff, 0x00, 0x09, 0xba, 0x00, 0x01, 0x9e, 0x57,
+		0x05, 0x7f, 0x00, 0x00, 0x45, 0xb1, 0x11, 0x5e,
+		0x04, 0x5d, 0xd2, 0x6e, 0x0c, 0x42, 0xa1, 0xff,
+		0xfe, 0x01, 0x02, 0x03, 0x00, 0x02, 0x00, 0x00,
+	}
+	packet := new(PDelayRespFollowUp)
+	err := FromBytes(raw, packet)
+	require.Nil(t, err)
+	want := PDelayRespFollowUp{
+		Header: Header{
+			SdoIDAndMsgType:     NewSdoIDAndMsgType(MessagePDelayRespFollowUp, 0),
+			Version:             MajorVersion,
+			MessageLength:       54,
+			DomainNumber:        0,
+			MinorSdoID:          0,
+			FlagField:           FlagTwoStep,
+			CorrectionField:     0,
+			MessageTypeSpecific: 0,
+			SourcePortIdentity: PortIdentity{
+				PortNumber:    1,
+				ClockIdentity: 36138748164966842,
+			},
+			SequenceID:         40535,
+			ControlField:       5,
+			LogMessageInterval: 0x7f,
+		},
+		PDelayRespFollowUpBody: PDelayRespFollowUpBody{
+			ResponseOriginTimestamp: Timestamp{
+				Seconds:     [6]byte{0x0, 0x00, 0x45, 0xb1, 0x11, 0x5e},
+				Nanoseconds: 73257582,
+			},
+			RequestingPortIdentity: PortIdentity{
+				PortNumber:    2,
+				ClockIdentity: 0x0c42a1fffe010203,
+			},
+		},
+	}
+	require.Equal(t, want, *packet)
+	b, err := Bytes(packet)
+	require.Nil(t, err)
+	assert.Equal(t, raw, b)
+
+	// test generic DecodePacket as well
+	pp, err := DecodePacket(raw)
+	require.Nil(t, err)
+	assert.Equal(t, &want, pp)
+
+	err = FromBytes(raw[:headerSize+10], packet)
+	require.Error(t, err)
+	_, err = packet.MarshalBinaryTo(make([]byte, headerSize+10))
+	require.Error(t, err)
+}
+
 func Test_parseAnnounce(t *testing.T) {
 	raw := []uint8{
 		0xb, 0x2, 0x0, 0x40, 0x0, 0x0, 0x4, 0x8, 0x0,
@@ -183,7 +299,7 @@ func Test_parseAnnounce(t *testing.T) {
 		Header: Header{
 			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageAnnounce, 0),
 			Version:         MajorVersion,
-			MessageLength:   uint16(binary.Size(Announce{})),
+			MessageLength:   uint16(binary.Size(Header{}) + binary.Size(AnnounceBody{})),
 			DomainNumber:    0,
 			FlagField:       FlagUnicast | FlagPTPTimescale,
 			SequenceID:      0,
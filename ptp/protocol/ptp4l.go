@@ -16,7 +16,7 @@ limitations under the License.
 
 package protocol
 
-// Support has been included for some non-standard extensions provided by the ptp4l implementation; the TLVs IDPortStatsNP and IDTimeStatusNP
+// Support has been included for some non-standard extensions provided by the ptp4l implementation; the TLVs IDPortStatsNP, IDPortServiceStatsNP and IDTimeStatusNP
 // Implemented as present in linuxptp master d95f4cd6e4a7c6c51a220c58903110a2326885e7
 
 import (
@@ -26,8 +26,9 @@ import (
 
 // ptp4l-specific management TLV ids
 const (
-	IDPortStatsNP  ManagementID = 0xC005
-	IDTimeStatusNP ManagementID = 0xC000
+	IDPortStatsNP        ManagementID = 0xC005
+	IDTimeStatusNP       ManagementID = 0xC000
+	IDPortServiceStatsNP ManagementID = 0xC007
 )
 
 // PortStats is a ptp4l struct containing port statistics
@@ -44,6 +45,28 @@ type PortStatsNPTLV struct {
 	PortStats    PortStats
 }
 
+// PortServiceStats is a ptp4l struct containing counters of port timeouts and mismatches
+type PortServiceStats struct {
+	AnnounceTimeout       uint64
+	SyncTimeout           uint64
+	DelayTimeout          uint64
+	UnicastServiceTimeout uint64
+	UnicastRequestTimeout uint64
+	MasterAnnounceTimeout uint64
+	MasterSyncTimeout     uint64
+	QualificationTimeout  uint64
+	SyncMismatch          uint64
+	FollowupMismatch      uint64
+}
+
+// PortServiceStatsNPTLV is a ptp4l struct containing port identity and service statistics
+type PortServiceStatsNPTLV struct {
+	ManagementTLVHead
+
+	PortIdentity     PortIdentity
+	PortServiceStats PortServiceStats
+}
+
 // ScaledNS is some struct used by ptp4l to report phase change
 type ScaledNS struct {
 	NanosecondsMSB        uint16
@@ -108,6 +131,32 @@ func (c *MgmtClient) PortStatsNP() (*PortStatsNPTLV, error) {
 	return tlv, nil
 }
 
+// PortServiceStatsNPRequest prepares request packet for PORT_SERVICE_STATS_NP request
+func PortServiceStatsNPRequest() *Management {
+	// we send request with no portServiceStats data just like pmc does
+	return mgmtGetRequest(&ManagementTLVHead{
+		TLVHead: TLVHead{
+			TLVType:     TLVManagement,
+			LengthField: 2,
+		},
+		ManagementID: IDPortServiceStatsNP,
+	}, tlvHeadSize+2)
+}
+
+// PortServiceStatsNP sends PORT_SERVICE_STATS_NP request and returns response
+func (c *MgmtClient) PortServiceStatsNP() (*PortServiceStatsNPTLV, error) {
+	req := PortServiceStatsNPRequest()
+	p, err := c.Communicate(req)
+	if err != nil {
+		return nil, err
+	}
+	tlv, ok := p.TLV.(*PortServiceStatsNPTLV)
+	if !ok {
+		return nil, fmt.Errorf("got unexpected management TLV %T, wanted %T", p.TLV, tlv)
+	}
+	return tlv, nil
+}
+
 // TimeStatusNPRequest prepares request packet for TIME_STATUS_NP request
 func TimeStatusNPRequest() *Management {
 	headerSize := uint16(binary.Size(ManagementMsgHead{}))
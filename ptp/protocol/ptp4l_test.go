@@ -86,3 +86,57 @@ func Test_parseTimeStatusNP(t *testing.T) {
 	require.Nil(t, err)
 	assert.Equal(t, raw, b)
 }
+
+func Test_parsePortServiceStatsNP(t *testing.T) {
+	req := PortServiceStatsNPRequest()
+	require.Equal(t, uint16(48+6), req.MessageLength)
+
+	raw, err := req.MarshalBinary()
+	require.Nil(t, err)
+	raw[3] = 48 + 6 + 10 + 80
+	raw[46] = byte(RESPONSE)
+	raw[51] = 2 + 10 + 80
+	raw = append(raw,
+		0x48, 0x57, 0xdd, 0xff, 0xfe, 0x08, 0x64, 0x88, 0x00, 0x01, // port identity
+		1, 0, 0, 0, 0, 0, 0, 0, // announce timeout
+		2, 0, 0, 0, 0, 0, 0, 0, // sync timeout
+		3, 0, 0, 0, 0, 0, 0, 0,
+		4, 0, 0, 0, 0, 0, 0, 0,
+		5, 0, 0, 0, 0, 0, 0, 0,
+		6, 0, 0, 0, 0, 0, 0, 0,
+		7, 0, 0, 0, 0, 0, 0, 0,
+		8, 0, 0, 0, 0, 0, 0, 0,
+		9, 0, 0, 0, 0, 0, 0, 0,
+		0, 1, 0, 0, 0, 0, 0, 0, // followup mismatch
+	)
+	conn := &fakeConn{response: raw}
+	c := &MgmtClient{Connection: conn}
+	tlv, err := c.PortServiceStatsNP()
+	require.Nil(t, err)
+	want := &PortServiceStatsNPTLV{
+		ManagementTLVHead: ManagementTLVHead{
+			TLVHead: TLVHead{
+				TLVType:     TLVManagement,
+				LengthField: 92,
+			},
+			ManagementID: IDPortServiceStatsNP,
+		},
+		PortIdentity: PortIdentity{
+			PortNumber:    1,
+			ClockIdentity: 5212879185253000328,
+		},
+		PortServiceStats: PortServiceStats{
+			AnnounceTimeout:       1,
+			SyncTimeout:           2,
+			DelayTimeout:          3,
+			UnicastServiceTimeout: 4,
+			UnicastRequestTimeout: 5,
+			MasterAnnounceTimeout: 6,
+			MasterSyncTimeout:     7,
+			QualificationTimeout:  8,
+			SyncMismatch:          9,
+			FollowupMismatch:      256,
+		},
+	}
+	require.Equal(t, want, tlv)
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"fmt"
+)
+
+// SdoID is 12 bit sdoId identifying the standard organization profile, as per IEEE 1588-2019 Section 7.1.4.
+// It's made of 4 bit majorSdoId (former transportSpecific, upper nibble of the first header octet)
+// and 8 bit minorSdoId (formerly reserved header octet, only meaningful since PTP v2.1).
+type SdoID uint16
+
+// MaxSdoID is the largest valid SdoID
+const MaxSdoID SdoID = 0xfff
+
+// Well-known SdoID values
+const (
+	// SdoIDDefault is used by IEEE 1588 profiles, including IEC/IEEE 61850-9-3 power utility profile
+	SdoIDDefault SdoID = 0x000
+	// SdoIDIEEE8021AS is used by IEEE 802.1AS (gPTP)
+	SdoIDIEEE8021AS SdoID = 0x100
+)
+
+// NewSdoID builds SdoID from majorSdoId and minorSdoId
+func NewSdoID(major, minor uint8) (SdoID, error) {
+	if major > 0xf {
+		return 0, fmt.Errorf("majorSdoId %d doesn't fit into 4 bits", major)
+	}
+	return SdoID(major)<<8 | SdoID(minor), nil
+}
+
+// Major returns majorSdoId
+func (s SdoID) Major() uint8 {
+	return uint8(s >> 8 & 0xf)
+}
+
+// Minor returns minorSdoId
+func (s SdoID) Minor() uint8 {
+	return uint8(s)
+}
+
+// Valid checks if SdoID fits into 12 bits
+func (s SdoID) Valid() bool {
+	return s <= MaxSdoID
+}
+
+func (s SdoID) String() string {
+	return fmt.Sprintf("0x%03x", uint16(s))
+}
+
+// MajorSdoID extracts majorSdoId from SdoIDAndMsgType
+func (m SdoIDAndMsgType) MajorSdoID() uint8 {
+	return uint8(m >> 4)
+}
+
+// SdoID returns full 12 bit SdoID of the packet
+func (p *Header) SdoID() SdoID {
+	return SdoID(p.SdoIDAndMsgType.MajorSdoID())<<8 | SdoID(p.MinorSdoID)
+}
+
+// SetSdoID sets both majorSdoId and minorSdoId of the packet, keeping the message type.
+// Non-zero minorSdoId requires PTP v2.1, so version of the packet must be set before.
+func (p *Header) SetSdoID(s SdoID) error {
+	if !s.Valid() {
+		return fmt.Errorf("sdoId %s doesn't fit into 12 bits", s)
+	}
+	if s.Minor() != 0 && !p.Supports(CapabilityMinorSdoID) {
+		return fmt.Errorf("%s is not supported by PTP version %s", CapabilityMinorSdoID, VersionString(p.Version))
+	}
+	p.SdoIDAndMsgType = NewSdoIDAndMsgType(p.MessageType(), s.Major())
+	p.MinorSdoID = s.Minor()
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSdoID(t *testing.T) {
+	s, err := NewSdoID(1, 0x23)
+	require.Nil(t, err)
+	require.Equal(t, SdoID(0x123), s)
+	require.Equal(t, uint8(1), s.Major())
+	require.Equal(t, uint8(0x23), s.Minor())
+	require.Equal(t, "0x123", s.String())
+	require.True(t, s.Valid())
+	require.False(t, SdoID(0x1000).Valid())
+
+	_, err = NewSdoID(0x10, 0)
+	require.Error(t, err)
+
+	require.Equal(t, uint8(1), NewSdoIDAndMsgType(MessageSync, 1).MajorSdoID())
+}
+
+func TestHeaderSdoID(t *testing.T) {
+	h := &Header{
+		SdoIDAndMsgType: NewSdoIDAndMsgType(MessageAnnounce, 0),
+		Version:         Version,
+	}
+	require.Equal(t, SdoIDDefault, h.SdoID())
+	require.Nil(t, h.SetSdoID(0x142))
+	require.Equal(t, SdoID(0x142), h.SdoID())
+	require.Equal(t, MessageAnnounce, h.MessageType())
+	require.Equal(t, uint8(0x42), h.MinorSdoID)
+	require.Error(t, h.SetSdoID(0x1000))
+
+	// minorSdoId is reserved in PTP v2.0
+	h.Version = MajorVersion
+	require.Error(t, h.SetSdoID(0x142))
+	require.Nil(t, h.SetSdoID(SdoIDIEEE8021AS))
+	require.Equal(t, SdoIDIEEE8021AS, h.SdoID())
+}
+
+func TestValidatePacketMinorSdoID(t *testing.T) {
+	p := testSync()
+	require.Nil(t, p.SetSdoID(0x042))
+	b, err := p.MarshalBinary()
+	require.Nil(t, err)
+	require.Nil(t, ValidatePacket(b))
+
+	b[1] = MajorVersion
+	err = ValidatePacket(b)
+	require.True(t, errors.Is(err, ErrReservedBitsSet))
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have SMPTE ST 2059-2 synchronization metadata, carried on Announce messages
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// SMPTE organization extension identifiers as per SMPTE ST 2059-2 Table 3
+var (
+	// SMPTEOrganizationID is the OUI of SMPTE
+	SMPTEOrganizationID = [3]byte{0x68, 0x97, 0xE8}
+	// SMPTEOrganizationSubType is the sub type of synchronization metadata TLV
+	SMPTEOrganizationSubType = [3]byte{0x00, 0x00, 0x01}
+)
+
+// smpteDataSize is the size of SMPTE TLV data, including organizationId and organizationSubType
+const smpteDataSize = 48
+
+// SMPTELockingStatus is the locking status of the grandmaster to its reference
+type SMPTELockingStatus uint8
+
+// Locking statuses as per SMPTE ST 2059-2 Table 4
+const (
+	SMPTENotInUse SMPTELockingStatus = iota
+	SMPTEFreeRun
+	SMPTEColdLocking
+	SMPTEWarmLocking
+	SMPTELocked
+)
+
+// SMPTELockingStatusToString is a map from SMPTELockingStatus to string
+var SMPTELockingStatusToString = map[SMPTELockingStatus]string{
+	SMPTENotInUse:    "NOT_IN_USE",
+	SMPTEFreeRun:     "FREE_RUN",
+	SMPTEColdLocking: "COLD_LOCKING",
+	SMPTEWarmLocking: "WARM_LOCKING",
+	SMPTELocked:      "LOCKED",
+}
+
+func (s SMPTELockingStatus) String() string {
+	return SMPTELockingStatusToString[s]
+}
+
+// flags used in SMPTE TLV
+const (
+	// TimeAddressFlags
+	SMPTEFlagDropFrame  uint8 = 1 << 0
+	SMPTEFlagColorFrame uint8 = 1 << 1
+	// DaylightSaving
+	SMPTEFlagDaylightSavingCurrent     uint8 = 1 << 0
+	SMPTEFlagDaylightSavingNextJump    uint8 = 1 << 1
+	SMPTEFlagDaylightSavingPreviousJam uint8 = 1 << 2
+	// LeapSecondJump
+	SMPTEFlagLeapSecondJump uint8 = 1 << 0
+)
+
+// SMPTETLV is the SMPTE synchronization metadata ORGANIZATION_EXTENSION TLV, SMPTE ST 2059-2 Table 3
+type SMPTETLV struct {
+	TLVHead
+	OrganizationID      [3]byte
+	OrganizationSubType [3]byte
+
+	DefaultSystemFrameRateNumerator   uint32
+	DefaultSystemFrameRateDenominator uint32
+	MasterLockingStatus               SMPTELockingStatus
+	TimeAddressFlags                  uint8
+	// CurrentLocalOffset is the offset of local time from PTP time in seconds
+	CurrentLocalOffset int32
+	// JumpSeconds is the size of the next discontinuity of local time in seconds
+	JumpSeconds int32
+	// TimeOfNextJump is PTP seconds of the next discontinuity, UInteger48
+	TimeOfNextJump uint64
+	// TimeOfNextJam is PTP seconds of the next daily jam, UInteger48
+	TimeOfNextJam uint64
+	// TimeOfPreviousJam is PTP seconds of the previous daily jam, UInteger48
+	TimeOfPreviousJam uint64
+	// PreviousJamLocalOffset is CurrentLocalOffset at the time of the previous daily jam
+	PreviousJamLocalOffset int32
+	DaylightSaving         uint8
+	LeapSecondJump         uint8
+}
+
+// NewSMPTETLV returns SMPTE TLV with identifiers and length populated
+func NewSMPTETLV() *SMPTETLV {
+	return &SMPTETLV{
+		TLVHead: TLVHead{
+			TLVType:     TLVOrganizationExtension,
+			LengthField: smpteDataSize,
+		},
+		OrganizationID:      SMPTEOrganizationID,
+		OrganizationSubType: SMPTEOrganizationSubType,
+	}
+}
+
+// FrameRate returns default system frame rate in frames per second
+func (t *SMPTETLV) FrameRate() float64 {
+	if t.DefaultSystemFrameRateDenominator == 0 {
+		return 0
+	}
+	return float64(t.DefaultSystemFrameRateNumerator) / float64(t.DefaultSystemFrameRateDenominator)
+}
+
+// NextJam returns time of the next daily jam, zero time if there is none scheduled
+func (t *SMPTETLV) NextJam() time.Time {
+	if t.TimeOfNextJam == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(t.TimeOfNextJam), 0)
+}
+
+// MarshalBinaryTo marshals TLV into provided []byte
+func (t *SMPTETLV) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < tlvHeadSize+smpteDataSize {
+		return 0, fmt.Errorf("not enough buffer to write SMPTETLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	n := tlvHeadSize
+	copy(b[n:], t.OrganizationID[:])
+	copy(b[n+3:], t.OrganizationSubType[:])
+	binary.BigEndian.PutUint32(b[n+6:], t.DefaultSystemFrameRateNumerator)
+	binary.BigEndian.PutUint32(b[n+10:], t.DefaultSystemFrameRateDenominator)
+	b[n+14] = byte(t.MasterLockingStatus)
+	b[n+15] = t.TimeAddressFlags
+	binary.BigEndian.PutUint32(b[n+16:], uint32(t.CurrentLocalOffset))
+	binary.BigEndian.PutUint32(b[n+20:], uint32(t.JumpSeconds))
+	putUint48(b[n+24:], t.TimeOfNextJump)
+	putUint48(b[n+30:], t.TimeOfNextJam)
+	putUint48(b[n+36:], t.TimeOfPreviousJam)
+	binary.BigEndian.PutUint32(b[n+42:], uint32(t.PreviousJamLocalOffset))
+	b[n+46] = t.DaylightSaving
+	b[n+47] = t.LeapSecondJump
+	return tlvHeadSize + smpteDataSize, nil
+}
+
+// UnmarshalBinary parses []byte and populates struct fields
+func (t *SMPTETLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if t.LengthField < smpteDataSize || len(b) < tlvHeadSize+smpteDataSize {
+		return fmt.Errorf("not enough data to decode SMPTETLV")
+	}
+	n := tlvHeadSize
+	copy(t.OrganizationID[:], b[n:])
+	copy(t.OrganizationSubType[:], b[n+3:])
+	t.DefaultSystemFrameRateNumerator = binary.BigEndian.Uint32(b[n+6:])
+	t.DefaultSystemFrameRateDenominator = binary.BigEndian.Uint32(b[n+10:])
+	t.MasterLockingStatus = SMPTELockingStatus(b[n+14])
+	t.TimeAddressFlags = b[n+15]
+	t.CurrentLocalOffset = int32(binary.BigEndian.Uint32(b[n+16:]))
+	t.JumpSeconds = int32(binary.BigEndian.Uint32(b[n+20:]))
+	t.TimeOfNextJump = uint48(b[n+24:])
+	t.TimeOfNextJam = uint48(b[n+30:])
+	t.TimeOfPreviousJam = uint48(b[n+36:])
+	t.PreviousJamLocalOffset = int32(binary.BigEndian.Uint32(b[n+42:]))
+	t.DaylightSaving = b[n+46]
+	t.LeapSecondJump = b[n+47]
+	return nil
+}
+
+// isSMPTETLV checks if ORGANIZATION_EXTENSION TLV in b is SMPTE synchronization metadata
+func isSMPTETLV(b []byte) bool {
+	if len(b) < tlvHeadSize+6 {
+		return false
+	}
+	var id, subType [3]byte
+	copy(id[:], b[tlvHeadSize:])
+	copy(subType[:], b[tlvHeadSize+3:])
+	return id == SMPTEOrganizationID && subType == SMPTEOrganizationSubType
+}
+
+// SMPTE returns SMPTE synchronization metadata TLV of Announce message, nil if there is none
+func (p *Announce) SMPTE() *SMPTETLV {
+	for _, tlv := range p.TLVs {
+		if t, ok := tlv.(*SMPTETLV); ok {
+			return t
+		}
+	}
+	return nil
+}
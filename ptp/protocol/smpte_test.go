@@ -0,0 +1,103 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSMPTETLV() *SMPTETLV {
+	tlv := NewSMPTETLV()
+	tlv.DefaultSystemFrameRateNumerator = 30000
+	tlv.DefaultSystemFrameRateDenominator = 1001
+	tlv.MasterLockingStatus = SMPTELocked
+	tlv.TimeAddressFlags = SMPTEFlagDropFrame
+	tlv.CurrentLocalOffset = -18000
+	tlv.JumpSeconds = 3600
+	tlv.TimeOfNextJump = 1667098800
+	tlv.TimeOfNextJam = 1667037600
+	tlv.TimeOfPreviousJam = 1666951200
+	tlv.PreviousJamLocalOffset = -14400
+	tlv.DaylightSaving = SMPTEFlagDaylightSavingPreviousJam
+	return tlv
+}
+
+func TestSMPTETLV(t *testing.T) {
+	tlv := testSMPTETLV()
+	b := make([]byte, tlvHeadSize+smpteDataSize)
+	n, err := tlv.MarshalBinaryTo(b)
+	require.Nil(t, err)
+	require.Equal(t, len(b), n)
+	want := []byte{
+		0x00, 0x03, 0x00, 0x30, // TLV head
+		0x68, 0x97, 0xe8, 0x00, 0x00, 0x01, // organizationId and organizationSubType
+		0x00, 0x00, 0x75, 0x30, 0x00, 0x00, 0x03, 0xe9, // frame rate
+		0x04, 0x01, // locking status, time address flags
+		0xff, 0xff, 0xb9, 0xb0, // current local offset
+		0x00, 0x00, 0x0e, 0x10, // jump seconds
+		0x00, 0x00, 0x63, 0x5d, 0xe8, 0xb0, // time of next jump
+		0x00, 0x00, 0x63, 0x5c, 0xf9, 0xa0, // time of next jam
+		0x00, 0x00, 0x63, 0x5b, 0xa8, 0x20, // time of previous jam
+		0xff, 0xff, 0xc7, 0xc0, // previous jam local offset
+		0x04, 0x00, // daylight saving, leap second jump
+	}
+	require.Equal(t, want, b)
+
+	got := &SMPTETLV{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, tlv, got)
+	require.InDelta(t, 29.97, got.FrameRate(), 0.001)
+	require.Equal(t, time.Unix(1667037600, 0), got.NextJam())
+	require.Equal(t, "LOCKED", got.MasterLockingStatus.String())
+
+	_, err = tlv.MarshalBinaryTo(b[:n-1])
+	require.Error(t, err)
+	require.Error(t, got.UnmarshalBinary(b[:n-1]))
+}
+
+func TestSMPTETLVZero(t *testing.T) {
+	tlv := NewSMPTETLV()
+	require.Equal(t, float64(0), tlv.FrameRate())
+	require.True(t, tlv.NextJam().IsZero())
+}
+
+func TestAnnounceSMPTE(t *testing.T) {
+	p := testAnnounce()
+	require.Nil(t, p.SMPTE())
+	tlv := testSMPTETLV()
+	p.TLVs = append(p.TLVs, tlv)
+	p.MessageLength += tlvHeadSize + smpteDataSize
+
+	b, err := Bytes(p)
+	require.Nil(t, err)
+	require.Equal(t, int(p.MessageLength)+2, len(b))
+
+	got := &Announce{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, p, got)
+	require.Equal(t, tlv, got.SMPTE())
+
+	j, err := json.Marshal(p)
+	require.Nil(t, err)
+	gotJSON := &Announce{}
+	require.Nil(t, json.Unmarshal(j, gotJSON))
+	require.Equal(t, p, gotJSON)
+}
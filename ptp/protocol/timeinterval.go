@@ -0,0 +1,156 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"math"
+	"time"
+)
+
+// Here we have conversions of 2**-16 ns scaled values (TimeInterval and Correction)
+// which allow to keep sub-nanosecond precision, and to control how it's dropped when it has to be.
+
+// RoundingMode defines how sub-nanosecond part is handled when converting to coarser units
+type RoundingMode uint8
+
+// Supported rounding modes
+const (
+	// RoundTruncate rounds towards zero
+	RoundTruncate RoundingMode = iota
+	// RoundNearest rounds to the nearest value, half away from zero
+	RoundNearest
+	// RoundFloor rounds towards negative infinity
+	RoundFloor
+	// RoundCeil rounds towards positive infinity
+	RoundCeil
+)
+
+// unscale converts 2**-16 scaled value to integer number of units using rounding mode
+func unscale(v int64, mode RoundingMode) int64 {
+	q := v >> 16 // floor
+	r := v & (twoPow16 - 1)
+	if r == 0 {
+		return q
+	}
+	switch mode {
+	case RoundFloor:
+		return q
+	case RoundCeil:
+		return q + 1
+	case RoundNearest:
+		if r > twoPow16/2 || (r == twoPow16/2 && v >= 0) {
+			return q + 1
+		}
+		return q
+	}
+	// RoundTruncate
+	if v < 0 {
+		return q + 1
+	}
+	return q
+}
+
+// scale converts floating point number of units to 2**-16 scaled value using rounding mode.
+// It returns false if the value can't be represented.
+func scale(f float64, mode RoundingMode) (int64, bool) {
+	f *= twoPow16
+	switch mode {
+	case RoundNearest:
+		f = math.Round(f)
+	case RoundFloor:
+		f = math.Floor(f)
+	case RoundCeil:
+		f = math.Ceil(f)
+	default:
+		f = math.Trunc(f)
+	}
+	// float64(math.MaxInt64) is exactly 2**63, which doesn't fit
+	if math.IsNaN(f) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// NewTimeIntervalRounded returns TimeInterval built from nanoseconds, with sub-2**-16 ns part rounded using mode.
+// Values outside of TimeInterval range are clamped to the largest positive or negative value as per 5.3.2.
+func NewTimeIntervalRounded(ns float64, mode RoundingMode) TimeInterval {
+	v, ok := scale(ns, mode)
+	if !ok {
+		if ns < 0 {
+			return math.MinInt64
+		}
+		return math.MaxInt64
+	}
+	return TimeInterval(v)
+}
+
+// NewTimeIntervalFromDuration returns TimeInterval built from time.Duration, clamping values outside of the range
+func NewTimeIntervalFromDuration(d time.Duration) TimeInterval {
+	if d > math.MaxInt64/twoPow16 {
+		return math.MaxInt64
+	}
+	if d < math.MinInt64/twoPow16 {
+		return math.MinInt64
+	}
+	return TimeInterval(d * twoPow16)
+}
+
+// Duration returns TimeInterval as time.Duration, sub-nanosecond part is truncated
+func (t TimeInterval) Duration() time.Duration {
+	return t.DurationRounded(RoundTruncate)
+}
+
+// DurationRounded returns TimeInterval as time.Duration, sub-nanosecond part is rounded using mode
+func (t TimeInterval) DurationRounded(mode RoundingMode) time.Duration {
+	return time.Duration(unscale(int64(t), mode))
+}
+
+// SubNanoseconds returns fractional nanoseconds part of TimeInterval in 2**-16 ns units.
+// It's always non-negative, so t equals floor nanoseconds plus fractional part.
+func (t TimeInterval) SubNanoseconds() uint16 {
+	return uint16(t & (twoPow16 - 1))
+}
+
+// Correction converts TimeInterval to Correction, preserving sub-nanosecond precision
+func (t TimeInterval) Correction() Correction {
+	return Correction(t)
+}
+
+// NewCorrectionRounded returns Correction built from nanoseconds, with sub-2**-16 ns part rounded using mode.
+// Values which can't be represented result in CorrectionTooBig.
+func NewCorrectionRounded(ns float64, mode RoundingMode) Correction {
+	v, ok := scale(ns, mode)
+	if !ok || Correction(v) == CorrectionTooBig {
+		return CorrectionTooBig
+	}
+	return Correction(v)
+}
+
+// DurationRounded returns Correction as time.Duration, sub-nanosecond part is rounded using mode
+func (t Correction) DurationRounded(mode RoundingMode) time.Duration {
+	return time.Duration(unscale(int64(t), mode))
+}
+
+// SubNanoseconds returns fractional nanoseconds part of Correction in 2**-16 ns units, see TimeInterval.SubNanoseconds
+func (t Correction) SubNanoseconds() uint16 {
+	return uint16(t & (twoPow16 - 1))
+}
+
+// TimeInterval converts Correction to TimeInterval, preserving sub-nanosecond precision
+func (t Correction) TimeInterval() TimeInterval {
+	return TimeInterval(t)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnscale(t *testing.T) {
+	half := int64(twoPow16 / 2)
+	cases := []struct {
+		in                          int64
+		trunc, nearest, floor, ceil int64
+	}{
+		{0, 0, 0, 0, 0},
+		{3 * twoPow16, 3, 3, 3, 3},
+		{3*twoPow16 + 1, 3, 3, 3, 4},
+		{3*twoPow16 + half, 3, 4, 3, 4},
+		{3*twoPow16 + half + 1, 3, 4, 3, 4},
+		{-3 * twoPow16, -3, -3, -3, -3},
+		{-3*twoPow16 - 1, -3, -3, -4, -3},
+		{-3*twoPow16 - half, -3, -4, -4, -3},
+		{-3*twoPow16 - half + 1, -3, -3, -4, -3},
+		{math.MinInt64, math.MinInt64 / twoPow16, math.MinInt64 / twoPow16, math.MinInt64 / twoPow16, math.MinInt64 / twoPow16},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.trunc, unscale(c.in, RoundTruncate), c.in)
+		require.Equal(t, c.nearest, unscale(c.in, RoundNearest), c.in)
+		require.Equal(t, c.floor, unscale(c.in, RoundFloor), c.in)
+		require.Equal(t, c.ceil, unscale(c.in, RoundCeil), c.in)
+	}
+}
+
+func TestNewTimeIntervalRounded(t *testing.T) {
+	// 1/3 of 2**-16 ns
+	tiny := 1.0 / 3 / twoPow16
+	require.Equal(t, TimeInterval(twoPow16), NewTimeIntervalRounded(1+tiny, RoundTruncate))
+	require.Equal(t, TimeInterval(twoPow16), NewTimeIntervalRounded(1+tiny, RoundNearest))
+	require.Equal(t, TimeInterval(twoPow16+1), NewTimeIntervalRounded(1+tiny, RoundCeil))
+	require.Equal(t, TimeInterval(-twoPow16), NewTimeIntervalRounded(-1-tiny, RoundTruncate))
+	require.Equal(t, TimeInterval(-twoPow16-1), NewTimeIntervalRounded(-1-tiny, RoundFloor))
+	require.Equal(t, TimeInterval(1), NewTimeIntervalRounded(2*tiny, RoundNearest))
+
+	require.Equal(t, TimeInterval(math.MaxInt64), NewTimeIntervalRounded(1e300, RoundTruncate))
+	require.Equal(t, TimeInterval(math.MinInt64), NewTimeIntervalRounded(-1e300, RoundTruncate))
+	require.Equal(t, TimeInterval(math.MaxInt64), NewTimeIntervalRounded(math.NaN(), RoundTruncate))
+}
+
+func TestTimeIntervalDuration(t *testing.T) {
+	d := 1500 * time.Microsecond
+	ti := NewTimeIntervalFromDuration(d)
+	require.Equal(t, d, ti.Duration())
+	require.Equal(t, float64(d.Nanoseconds()), ti.Nanoseconds())
+	require.Equal(t, uint16(0), ti.SubNanoseconds())
+
+	ti = NewTimeInterval(-2.75)
+	require.Equal(t, -2*time.Nanosecond, ti.Duration())
+	require.Equal(t, -3*time.Nanosecond, ti.DurationRounded(RoundNearest))
+	require.Equal(t, -3*time.Nanosecond, ti.DurationRounded(RoundFloor))
+	require.Equal(t, -2*time.Nanosecond, ti.DurationRounded(RoundCeil))
+	// -2.75 = -3 + 0.25
+	require.Equal(t, uint16(twoPow16/4), ti.SubNanoseconds())
+
+	require.Equal(t, TimeInterval(math.MaxInt64), NewTimeIntervalFromDuration(200*24*time.Hour))
+	require.Equal(t, TimeInterval(math.MinInt64), NewTimeIntervalFromDuration(-200*24*time.Hour))
+}
+
+func TestCorrectionRounded(t *testing.T) {
+	c := NewCorrectionRounded(10.5, RoundNearest)
+	require.Equal(t, Correction(10.5*twoPow16), c)
+	require.Equal(t, 11*time.Nanosecond, c.DurationRounded(RoundNearest))
+	require.Equal(t, 10*time.Nanosecond, c.DurationRounded(RoundTruncate))
+	require.Equal(t, c.Duration(), c.DurationRounded(RoundTruncate))
+	require.Equal(t, uint16(twoPow16/2), c.SubNanoseconds())
+
+	require.Equal(t, CorrectionTooBig, NewCorrectionRounded(1e300, RoundNearest))
+	require.Equal(t, CorrectionTooBig, NewCorrectionRounded(math.NaN(), RoundNearest))
+
+	// lossless round trip between TimeInterval and Correction
+	ti := NewTimeInterval(-123.456)
+	require.Equal(t, ti, ti.Correction().TimeInterval())
+	require.Equal(t, ti.Nanoseconds(), ti.Correction().Nanoseconds())
+}
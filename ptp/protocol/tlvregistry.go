@@ -0,0 +1,154 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Here we have a registry of custom TLVs, which allows users to plug in support
+// for TLVs this package doesn't know about, like organization specific extensions.
+// Registered TLVs are decoded by Announce and Signaling UnmarshalBinary as well as JSON unmarshalling.
+// To be serialized, custom TLV must implement BinaryMarshalerTo, otherwise it's written with binary.Write.
+
+// DecodableTLV is a TLV that can be populated from bytes, starting from TLV header
+type DecodableTLV interface {
+	TLV
+	encoding.BinaryUnmarshaler
+}
+
+// NewTLVFunc returns new empty instance of custom TLV to decode into
+type NewTLVFunc func() DecodableTLV
+
+// OrganizationID is organizationId of ORGANIZATION_EXTENSION TLV
+type OrganizationID [3]byte
+
+// OrganizationSubType is organizationSubType of ORGANIZATION_EXTENSION TLV
+type OrganizationSubType [3]byte
+
+type organizationKey struct {
+	id      OrganizationID
+	subType OrganizationSubType
+}
+
+var tlvRegistry = struct {
+	sync.RWMutex
+	byType         map[TLVType]NewTLVFunc
+	byOrganization map[organizationKey]NewTLVFunc
+}{
+	byType:         map[TLVType]NewTLVFunc{},
+	byOrganization: map[organizationKey]NewTLVFunc{},
+}
+
+// builtinTLV checks if TLV of this type is natively supported by this package
+func builtinTLV(t TLVType) bool {
+	switch t {
+	case TLVManagement, TLVManagementErrorStatus, TLVOrganizationExtension,
+		TLVRequestUnicastTransmission, TLVGrantUnicastTransmission,
+		TLVCancelUnicastTransmission, TLVAcknowledgeCancelUnicastTransmission,
+		TLVPathTrace, TLVAlternateTimeOffsetIndicator, TLVAuthentication,
+		TLVPTPMonRequest, TLVPTPMonResponse:
+		return true
+	}
+	return false
+}
+
+// RegisterTLV registers constructor of custom TLV of given type.
+// Types natively supported by this package can't be overridden,
+// ORGANIZATION_EXTENSION TLVs must be registered with RegisterOrganizationTLV.
+func RegisterTLV(t TLVType, f NewTLVFunc) error {
+	if builtinTLV(t) {
+		return fmt.Errorf("TLV %s (%d) is natively supported and can't be registered", t, uint16(t))
+	}
+	tlvRegistry.Lock()
+	defer tlvRegistry.Unlock()
+	if _, ok := tlvRegistry.byType[t]; ok {
+		return fmt.Errorf("TLV %d is already registered", uint16(t))
+	}
+	tlvRegistry.byType[t] = f
+	return nil
+}
+
+// UnregisterTLV removes custom TLV of given type from the registry
+func UnregisterTLV(t TLVType) {
+	tlvRegistry.Lock()
+	defer tlvRegistry.Unlock()
+	delete(tlvRegistry.byType, t)
+}
+
+// RegisterOrganizationTLV registers constructor of ORGANIZATION_EXTENSION TLV with given organizationId and organizationSubType
+func RegisterOrganizationTLV(id OrganizationID, subType OrganizationSubType, f NewTLVFunc) error {
+	if id == SMPTEOrganizationID && subType == SMPTEOrganizationSubType {
+		return fmt.Errorf("SMPTE TLV is natively supported and can't be registered")
+	}
+	key := organizationKey{id: id, subType: subType}
+	tlvRegistry.Lock()
+	defer tlvRegistry.Unlock()
+	if _, ok := tlvRegistry.byOrganization[key]; ok {
+		return fmt.Errorf("organization TLV %x/%x is already registered", id, subType)
+	}
+	tlvRegistry.byOrganization[key] = f
+	return nil
+}
+
+// UnregisterOrganizationTLV removes ORGANIZATION_EXTENSION TLV from the registry
+func UnregisterOrganizationTLV(id OrganizationID, subType OrganizationSubType) {
+	tlvRegistry.Lock()
+	defer tlvRegistry.Unlock()
+	delete(tlvRegistry.byOrganization, organizationKey{id: id, subType: subType})
+}
+
+// registeredTLV returns new instance of custom TLV matching TLV in b, nil if nothing is registered for it
+func registeredTLV(b []byte) DecodableTLV {
+	if len(b) < tlvHeadSize {
+		return nil
+	}
+	t := TLVType(binary.BigEndian.Uint16(b))
+	tlvRegistry.RLock()
+	defer tlvRegistry.RUnlock()
+	if t != TLVOrganizationExtension {
+		if f, ok := tlvRegistry.byType[t]; ok {
+			return f()
+		}
+		return nil
+	}
+	if len(b) < tlvHeadSize+6 {
+		return nil
+	}
+	key := organizationKey{}
+	copy(key.id[:], b[tlvHeadSize:])
+	copy(key.subType[:], b[tlvHeadSize+3:])
+	if f, ok := tlvRegistry.byOrganization[key]; ok {
+		return f()
+	}
+	return nil
+}
+
+// decodeRegisteredTLV decodes TLV in b using the registry. It returns nil TLV if nothing is registered for it.
+func decodeRegisteredTLV(b []byte) (TLV, error) {
+	tlv := registeredTLV(b)
+	if tlv == nil {
+		return nil, nil
+	}
+	if err := tlv.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return tlv, nil
+}
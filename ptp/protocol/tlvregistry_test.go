@@ -0,0 +1,209 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testCustomTLVType TLVType = 0x2000
+
+// testCustomTLV is a custom TLV carrying a single uint32
+type testCustomTLV struct {
+	TLVHead
+	Value uint32
+}
+
+func (t *testCustomTLV) MarshalBinaryTo(b []byte) (int, error) {
+	if len(b) < tlvHeadSize+4 {
+		return 0, fmt.Errorf("not enough buffer to write testCustomTLV")
+	}
+	tlvHeadMarshalBinaryTo(&t.TLVHead, b)
+	binary.BigEndian.PutUint32(b[tlvHeadSize:], t.Value)
+	return tlvHeadSize + 4, nil
+}
+
+func (t *testCustomTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if len(b) < tlvHeadSize+4 {
+		return fmt.Errorf("not enough data to decode testCustomTLV")
+	}
+	t.Value = binary.BigEndian.Uint32(b[tlvHeadSize:])
+	return nil
+}
+
+// testOrgTLV is a custom ORGANIZATION_EXTENSION TLV carrying a single uint16
+type testOrgTLV struct {
+	TLVHead
+	OrganizationID      OrganizationID
+	OrganizationSubType OrganizationSubType
+	Value               uint16
+}
+
+func (t *testOrgTLV) UnmarshalBinary(b []byte) error {
+	if err := unmarshalTLVHeader(&t.TLVHead, b); err != nil {
+		return err
+	}
+	if len(b) < tlvHeadSize+8 {
+		return fmt.Errorf("not enough data to decode testOrgTLV")
+	}
+	copy(t.OrganizationID[:], b[tlvHeadSize:])
+	copy(t.OrganizationSubType[:], b[tlvHeadSize+3:])
+	t.Value = binary.BigEndian.Uint16(b[tlvHeadSize+6:])
+	return nil
+}
+
+var (
+	testOrgID      = OrganizationID{0x00, 0x1b, 0x19}
+	testOrgSubType = OrganizationSubType{0x00, 0x00, 0x42}
+)
+
+func TestRegisterTLV(t *testing.T) {
+	newCustom := func() DecodableTLV { return &testCustomTLV{} }
+	require.Error(t, RegisterTLV(TLVPathTrace, newCustom))
+	require.Error(t, RegisterTLV(TLVOrganizationExtension, newCustom))
+	require.Nil(t, RegisterTLV(testCustomTLVType, newCustom))
+	defer UnregisterTLV(testCustomTLVType)
+	require.Error(t, RegisterTLV(testCustomTLVType, newCustom))
+
+	newOrg := func() DecodableTLV { return &testOrgTLV{} }
+	require.Error(t, RegisterOrganizationTLV(SMPTEOrganizationID, SMPTEOrganizationSubType, newOrg))
+	require.Nil(t, RegisterOrganizationTLV(testOrgID, testOrgSubType, newOrg))
+	defer UnregisterOrganizationTLV(testOrgID, testOrgSubType)
+	require.Error(t, RegisterOrganizationTLV(testOrgID, testOrgSubType, newOrg))
+
+	tlv, err := newTLV(tlvTypeJSON{TLVType: testCustomTLVType})
+	require.Nil(t, err)
+	require.Equal(t, &testCustomTLV{}, tlv)
+}
+
+func TestRegisteredTLVAnnounce(t *testing.T) {
+	require.Nil(t, RegisterTLV(testCustomTLVType, func() DecodableTLV { return &testCustomTLV{} }))
+	require.Nil(t, RegisterOrganizationTLV(testOrgID, testOrgSubType, func() DecodableTLV { return &testOrgTLV{} }))
+
+	custom := &testCustomTLV{
+		TLVHead: TLVHead{TLVType: testCustomTLVType, LengthField: 4},
+		Value:   0xdeadbeef,
+	}
+	org := &testOrgTLV{
+		TLVHead:             TLVHead{TLVType: TLVOrganizationExtension, LengthField: 8},
+		OrganizationID:      testOrgID,
+		OrganizationSubType: testOrgSubType,
+		Value:               42,
+	}
+	p := testAnnounce()
+	p.TLVs = []TLV{custom, org}
+	p.MessageLength += tlvHeadSize + 4 + tlvHeadSize + 8
+	b, err := p.MarshalBinary()
+	require.Nil(t, err)
+	require.Equal(t, int(p.MessageLength), len(b))
+
+	got := &Announce{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, p, got)
+
+	// once unregistered, TLVs are skipped again
+	UnregisterTLV(testCustomTLVType)
+	UnregisterOrganizationTLV(testOrgID, testOrgSubType)
+	got = &Announce{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Empty(t, got.TLVs)
+}
+
+func TestRegisteredTLVSignaling(t *testing.T) {
+	require.Nil(t, RegisterTLV(testCustomTLVType, func() DecodableTLV { return &testCustomTLV{} }))
+	defer UnregisterTLV(testCustomTLVType)
+
+	custom := &testCustomTLV{
+		TLVHead: TLVHead{TLVType: testCustomTLVType, LengthField: 4},
+		Value:   0xdeadbeef,
+	}
+	p := &Signaling{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSignaling, 0),
+			Version:         Version,
+			MessageLength:   headerSize + 10 + tlvHeadSize + 4,
+		},
+		TLVs: []TLV{custom},
+	}
+	b, err := p.MarshalBinary()
+	require.Nil(t, err)
+
+	got := &Signaling{}
+	require.Nil(t, got.UnmarshalBinary(b))
+	require.Equal(t, p, got)
+
+	UnregisterTLV(testCustomTLVType)
+	got = &Signaling{}
+	require.Error(t, got.UnmarshalBinary(b))
+}
+
+func TestTLVsJSON(t *testing.T) {
+	require.Error(t, RegisterTLV(TLVPTPMonRequest, func() DecodableTLV { return &testCustomTLV{} }))
+	require.Nil(t, RegisterOrganizationTLV(testOrgID, testOrgSubType, func() DecodableTLV { return &testOrgTLV{} }))
+	defer UnregisterOrganizationTLV(testOrgID, testOrgSubType)
+
+	org := &testOrgTLV{
+		TLVHead:             TLVHead{TLVType: TLVOrganizationExtension, LengthField: 8},
+		OrganizationID:      testOrgID,
+		OrganizationSubType: testOrgSubType,
+		Value:               42,
+	}
+	resp := &PTPMonResponseTLV{
+		TLVHead:       TLVHead{TLVType: TLVPTPMonResponse, LengthField: 2 + 8 + ptpMonResponseFixedSize},
+		PortState:     PortStateSlave,
+		ParentAddress: NewPortAddress(net.ParseIP("10.0.0.1")),
+		ParentPortIdentity: PortIdentity{
+			PortNumber:    1,
+			ClockIdentity: 5678,
+		},
+		GrandmasterIdentity: 5678,
+		OffsetFromMaster:    NewTimeInterval(-42),
+		TimeSource:          TimeSourceGNSS,
+		LastSync:            NewTimestamp(time.Unix(1653574589, 806492928)),
+	}
+	p := &Signaling{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSignaling, 0),
+			Version:         Version,
+		},
+		TLVs: []TLV{
+			testSMPTETLV(),
+			org,
+			&PTPMonRequestTLV{TLVHead: TLVHead{TLVType: TLVPTPMonRequest}},
+			resp,
+		},
+	}
+	j, err := json.Marshal(p)
+	require.Nil(t, err)
+	got := &Signaling{}
+	require.Nil(t, json.Unmarshal(j, got))
+	require.Equal(t, p, got)
+
+	// organization TLVs nobody registered can't be decoded
+	UnregisterOrganizationTLV(testOrgID, testOrgSubType)
+	require.Error(t, json.Unmarshal(j, &Signaling{}))
+}
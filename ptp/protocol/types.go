@@ -105,11 +105,56 @@ func (t TLVHead) Type() TLVType {
 	return t.TLVType
 }
 
+// length returns LengthField, it's used to size buffers for TLVs
+func (t TLVHead) length() int {
+	return int(t.LengthField)
+}
+
 func tlvHeadMarshalBinaryTo(t *TLVHead, b []byte) {
 	binary.BigEndian.PutUint16(b, uint16(t.TLVType))
 	binary.BigEndian.PutUint16(b[2:], t.LengthField)
 }
 
+// writeTLVs marshals TLVs into provided []byte one after another
+func writeTLVs(tlvs []TLV, b []byte) (int, error) {
+	pos := 0
+	for _, tlv := range tlvs {
+		nn, err := writeTLV(tlv, b[pos:])
+		if err != nil {
+			return 0, err
+		}
+		pos += nn
+	}
+	return pos, nil
+}
+
+// writeTLV marshals single TLV into provided []byte
+func writeTLV(tlv TLV, b []byte) (int, error) {
+	if ttlv, ok := tlv.(BinaryMarshalerTo); ok {
+		return ttlv.MarshalBinaryTo(b)
+	}
+	// very inefficient path for TLVs that don't support MarshalBinaryTo
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, tlv); err != nil {
+		return 0, err
+	}
+	if buf.Len() > len(b) {
+		return 0, fmt.Errorf("not enough buffer to write TLV")
+	}
+	return copy(b, buf.Bytes()), nil
+}
+
+// putUint48 writes lower 48 bits of v into provided []byte as UInteger48 in network byte order
+func putUint48(b []byte, v uint64) {
+	binary.BigEndian.PutUint16(b, uint16(v>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(v))
+}
+
+// uint48 reads UInteger48 in network byte order from provided []byte
+func uint48(b []byte) uint64 {
+	return uint64(binary.BigEndian.Uint16(b))<<32 | uint64(binary.BigEndian.Uint32(b[2:]))
+}
+
 // As per Table 52 tlvType values
 const (
 	TLVManagement                           TLVType = 0x0001
@@ -121,6 +166,10 @@ const (
 	TLVAcknowledgeCancelUnicastTransmission TLVType = 0x0007
 	TLVPathTrace                            TLVType = 0x0008
 	TLVAlternateTimeOffsetIndicator         TLVType = 0x0009
+	TLVAuthentication                       TLVType = 0x8009
+	// NetSync Monitor extension TLVs, as implemented by linuxptp
+	TLVPTPMonRequest  TLVType = 0x21FE
+	TLVPTPMonResponse TLVType = 0x21FF
 	// Remaining 52tlvType TLVs not implemented
 )
 
@@ -135,6 +184,9 @@ var TLVTypeToString = map[TLVType]string{
 	TLVAcknowledgeCancelUnicastTransmission: "ACKNOWLEDGE_CANCEL_UNICAST_TRANSMISSION",
 	TLVPathTrace:                            "PATH_TRACE",
 	TLVAlternateTimeOffsetIndicator:         "ALTERNATE_TIME_OFFSET_INDICATOR",
+	TLVAuthentication:                       "AUTHENTICATION",
+	TLVPTPMonRequest:                        "PTPMON_REQ",
+	TLVPTPMonResponse:                       "PTPMON_RESP",
 }
 
 func (t TLVType) String() string {
@@ -382,25 +434,34 @@ func (p *PTPText) UnmarshalBinary(rawBytes []byte) error {
 	return nil
 }
 
-// MarshalBinary converts ptptext to []bytes
-func (p *PTPText) MarshalBinary() ([]byte, error) {
-	rawText := []byte(*p)
-	if len(rawText) > 255 {
-		return nil, fmt.Errorf("text is too long")
+// MarshalBinaryTo marshals ptptext into provided []byte
+func (p *PTPText) MarshalBinaryTo(b []byte) (int, error) {
+	length := len(*p)
+	if length > 255 {
+		return 0, fmt.Errorf("text is too long")
 	}
-	length := uint8(len(rawText))
-	var bytes bytes.Buffer
-	if err := binary.Write(&bytes, binary.BigEndian, length); err != nil {
-		return nil, err
+	size := 1 + length
+	// padding to make sure packet length is even
+	if length%2 != 0 {
+		size++
 	}
-	if err := binary.Write(&bytes, binary.BigEndian, rawText); err != nil {
-		return nil, err
+	if len(b) < size {
+		return 0, fmt.Errorf("not enough buffer to write PTPText")
 	}
-	// padding to make sure packet length is even
+	b[0] = byte(length)
+	copy(b[1:], *p)
 	if length%2 != 0 {
-		if err := bytes.WriteByte(0); err != nil {
-			return nil, err
-		}
+		b[size-1] = 0
+	}
+	return size, nil
+}
+
+// MarshalBinary converts ptptext to []bytes
+func (p *PTPText) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 1+len(*p)+len(*p)%2)
+	n, err := p.MarshalBinaryTo(buf)
+	if err != nil {
+		return nil, err
 	}
-	return bytes.Bytes(), nil
+	return buf[:n], nil
 }
@@ -177,7 +177,22 @@ func TestPTPText(t *testing.T) {
 				gotBytes, err := text.MarshalBinary()
 				require.Nil(t, err)
 				assert.Equal(t, tt.in, gotBytes)
+
+				_, err = text.MarshalBinaryTo(make([]byte, len(tt.in)-1))
+				assert.Error(t, err)
 			}
 		})
 	}
 }
+
+func TestUint48(t *testing.T) {
+	b := make([]byte, 6)
+	putUint48(b, 0x123456789abc)
+	require.Equal(t, []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc}, b)
+	require.Equal(t, uint64(0x123456789abc), uint48(b))
+
+	// only lower 48 bits are written
+	putUint48(b, 0xffff000000000001)
+	require.Equal(t, []byte{0, 0, 0, 0, 0, 1}, b)
+	require.Equal(t, uint64(1), uint48(b))
+}
@@ -17,7 +17,6 @@ limitations under the License.
 package protocol
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 )
@@ -49,26 +48,11 @@ func (p *Signaling) MarshalBinaryTo(b []byte) (int, error) {
 	n := headerMarshalBinaryTo(&p.Header, b)
 	binary.BigEndian.PutUint64(b[n:], uint64(p.TargetPortIdentity.ClockIdentity))
 	binary.BigEndian.PutUint16(b[n+8:], p.TargetPortIdentity.PortNumber)
-	pos := n + 10
-	for _, tlv := range p.TLVs {
-		if ttlv, ok := tlv.(BinaryMarshalerTo); ok {
-			nn, err := ttlv.MarshalBinaryTo(b[pos:])
-			if err != nil {
-				return 0, err
-			}
-			pos += nn
-			continue
-		}
-		// very inefficient path for TLVs that don't support MarshalBinaryTo
-		buf := new(bytes.Buffer)
-		if err := binary.Write(buf, binary.BigEndian, tlv); err != nil {
-			return 0, err
-		}
-		bbytes := buf.Bytes()
-		copy(b[pos:], bbytes)
-		pos += len(bbytes)
+	tlvLen, err := writeTLVs(p.TLVs, b[n+10:])
+	if err != nil {
+		return 0, err
 	}
-	return pos, nil
+	return n + 10 + tlvLen, nil
 }
 
 // MarshalBinary converts packet to []bytes
@@ -89,6 +73,15 @@ func unmarshalTLVHeader(p *TLVHead, b []byte) error {
 
 // UnmarshalBinary parses []byte and populates struct fields
 func (p *Signaling) UnmarshalBinary(b []byte) error {
+	return p.unmarshalBinary(b, false)
+}
+
+// UnmarshalBinarySkipUnknown is UnmarshalBinary which skips TLVs it can't decode instead of failing
+func (p *Signaling) UnmarshalBinarySkipUnknown(b []byte) error {
+	return p.unmarshalBinary(b, true)
+}
+
+func (p *Signaling) unmarshalBinary(b []byte, skipUnknown bool) error {
 	if len(b) < headerSize+10+tlvHeadSize {
 		return fmt.Errorf("not enough data to decode Signaling")
 	}
@@ -140,8 +133,25 @@ func (p *Signaling) UnmarshalBinary(b []byte) error {
 			}
 			p.TLVs = append(p.TLVs, tlv)
 			pos += tlvHeadSize + int(tlv.LengthField)
+		case TLVAuthentication:
+			tlv := &AuthenticationTLV{}
+			if err := tlv.UnmarshalBinary(b[pos:]); err != nil {
+				return err
+			}
+			p.TLVs = append(p.TLVs, tlv)
+			pos += tlvHeadSize + int(tlv.LengthField)
 		default:
-			return fmt.Errorf("reading TLV %s (%d) is not yet implemented", head.TLVType, head.TLVType)
+			tlv, err := decodeRegisteredTLV(b[pos:])
+			if err != nil {
+				return err
+			}
+			if tlv == nil && !skipUnknown {
+				return fmt.Errorf("reading TLV %s (%d) is not yet implemented", head.TLVType, head.TLVType)
+			}
+			if tlv != nil {
+				p.TLVs = append(p.TLVs, tlv)
+			}
+			pos += tlvHeadSize + int(binary.BigEndian.Uint16(b[pos+2:]))
 		}
 	}
 	if len(p.TLVs) == 0 {
@@ -279,3 +279,30 @@ func BenchmarkReadSignaling(b *testing.B) {
 		_ = p.UnmarshalBinary(raw)
 	}
 }
+
+func TestSignalingSkipUnknownTLV(t *testing.T) {
+	raw := []uint8{0x0c, 0x02, 0x00, 0x3e, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xb8, 0x59, 0x9f, 0xff, 0xfe, 0x55, 0xaf, 0x4e, 0x00, 0x01, 0x00, 0x00, 0x05, 0x7f, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x20, 0x40, 0x00, 0x04, 0xde, 0xad, 0xbe, 0xef, // TLV type nobody knows
+		0x00, 0x04, 0x00, 0x06, 0x90, 0x01, 0x00, 0x00, 0x00, 0x3c,
+		0x00, 0x00, // extra 2 bytes for udp6 checksum
+	}
+	packet := new(Signaling)
+	require.Error(t, FromBytes(raw, packet))
+
+	packet = new(Signaling)
+	require.NoError(t, packet.UnmarshalBinarySkipUnknown(raw))
+	require.Equal(t, []TLV{
+		&RequestUnicastTransmissionTLV{
+			TLVHead:               TLVHead{TLVType: TLVRequestUnicastTransmission, LengthField: 6},
+			MsgTypeAndReserved:    NewUnicastMsgTypeAndFlags(MessageDelayResp, 0),
+			LogInterMessagePeriod: 1,
+			DurationField:         60,
+		},
+	}, packet.TLVs)
+
+	// there has to be at least one TLV we know
+	raw = append(raw[:52], 0x00, 0x00)
+	raw[3] = 0x34
+	require.Error(t, new(Signaling).UnmarshalBinarySkipUnknown(raw))
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+// Here we have strict validation of PTP packets, meant for servers which want to drop malformed traffic early
+// instead of relying on best-effort parsing.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Errors returned by ValidatePacket, wrapped into ValidationError
+var (
+	ErrInvalidLength      = errors.New("invalid length")
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrReservedBitsSet    = errors.New("reserved bits set")
+	ErrInvalidLogInterval = errors.New("invalid logMessageInterval")
+	ErrTruncatedTLV       = errors.New("truncated TLV")
+)
+
+// Limits of logMessageInterval ValidatePacket accepts, besides MgmtLogMessageInterval (0x7f).
+// Anything outside means sub-16μs or multiple hour periods, which no sane PTP Instance uses.
+const (
+	MinLogMessageInterval LogInterval = -16
+	MaxLogMessageInterval LogInterval = 16
+)
+
+// reserved bits of flagField as per Table 37 Values of flagField
+const flagReservedMask uint16 = 1<<(8+3) | 1<<(8+4) | 1<<(8+7) | 1<<7
+
+// ValidationError is returned when packet fails strict validation
+type ValidationError struct {
+	// Field is the name of the field which failed validation
+	Field string
+	// Value is the offending value
+	Value int
+	// Err is one of ErrInvalidLength, ErrUnsupportedVersion, ErrReservedBitsSet, ErrInvalidLogInterval or ErrTruncatedTLV
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s=%d", e.Err, e.Field, e.Value)
+}
+
+// Unwrap allows to use errors.Is on ValidationError
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func validationErr(err error, field string, value int) *ValidationError {
+	return &ValidationError{Field: field, Value: value, Err: err}
+}
+
+// ValidatePacket strictly validates raw PTP packet without decoding it.
+// It checks that messageLength is consistent with the message type and the packet size,
+// that no reserved bits are set, that logMessageInterval makes sense, and that TLVs are not truncated.
+// Returned error is *ValidationError.
+func ValidatePacket(b []byte) error {
+	if len(b) < headerSize {
+		return validationErr(ErrInvalidLength, "packetLength", len(b))
+	}
+	msgType := SdoIDAndMsgType(b[0]).MsgType()
+	if _, ok := MessageTypeToString[msgType]; !ok {
+		return validationErr(ErrReservedBitsSet, "messageType", int(msgType))
+	}
+	if err := CheckVersion(b[1]); err != nil {
+		return validationErr(ErrUnsupportedVersion, "versionPTP", int(MajorVersionPTP(b[1])))
+	}
+	// minorSdoId was a reserved field before PTP v2.1
+	if minorSdoID := b[5]; minorSdoID != 0 && !VersionSupports(b[1], CapabilityMinorSdoID) {
+		return validationErr(ErrReservedBitsSet, "minorSdoId", int(minorSdoID))
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length > len(b) {
+		return validationErr(ErrInvalidLength, "messageLength", length)
+	}
+	offset, err := tlvsOffset(msgType)
+	if err != nil {
+		return validationErr(ErrReservedBitsSet, "messageType", int(msgType))
+	}
+	if length < offset {
+		return validationErr(ErrInvalidLength, "messageLength", length)
+	}
+	if flags := binary.BigEndian.Uint16(b[6:]); flags&flagReservedMask != 0 {
+		return validationErr(ErrReservedBitsSet, "flagField", int(flags))
+	}
+	if interval := LogInterval(b[33]); interval != MgmtLogMessageInterval && (interval < MinLogMessageInterval || interval > MaxLogMessageInterval) {
+		return validationErr(ErrInvalidLogInterval, "logMessageInterval", int(interval))
+	}
+	if msgType == MessageAnnounce && b[headerSize+12] != 0 {
+		return validationErr(ErrReservedBitsSet, "announceReserved", int(b[headerSize+12]))
+	}
+	tlvs, err := validateTLVs(b[offset:length])
+	if err != nil {
+		return err
+	}
+	if tlvs == 0 && (msgType == MessageSignaling || msgType == MessageManagement) {
+		return validationErr(ErrTruncatedTLV, "tlvCount", 0)
+	}
+	return nil
+}
+
+// validateTLVs checks that TLVs fill b exactly and returns their number
+func validateTLVs(b []byte) (int, error) {
+	pos := 0
+	n := 0
+	for pos < len(b) {
+		if pos+tlvHeadSize > len(b) {
+			return 0, validationErr(ErrTruncatedTLV, "tlvOffset", pos)
+		}
+		tlvLength := int(binary.BigEndian.Uint16(b[pos+2:]))
+		if tlvLength%2 != 0 {
+			return 0, validationErr(ErrTruncatedTLV, "lengthField", tlvLength)
+		}
+		if pos+tlvHeadSize+tlvLength > len(b) {
+			return 0, validationErr(ErrTruncatedTLV, "lengthField", tlvLength)
+		}
+		pos += tlvHeadSize + tlvLength
+		n++
+	}
+	return n, nil
+}
+
+// DecodePacketStrict is DecodePacket which first validates the packet with ValidatePacket
+func DecodePacketStrict(b []byte) (Packet, error) {
+	if err := ValidatePacket(b); err != nil {
+		return nil, err
+	}
+	return DecodePacket(b)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePacket(t *testing.T) {
+	sync, err := testSync().MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, ValidatePacket(sync))
+
+	announce, err := testAnnounce().MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, ValidatePacket(announce))
+
+	// trailing padding beyond messageLength is fine
+	require.NoError(t, ValidatePacket(append(sync, 0, 0)))
+
+	// any 2.x version is accepted, same as by CheckVersion
+	sync[1] = NewVersion(MajorVersion, 3)
+	require.NoError(t, ValidatePacket(sync))
+}
+
+func TestValidatePacketErrors(t *testing.T) {
+	sync, err := testSync().MarshalBinary()
+	require.NoError(t, err)
+
+	signaling := &Signaling{
+		Header: Header{
+			SdoIDAndMsgType: NewSdoIDAndMsgType(MessageSignaling, 0),
+			Version:         Version,
+			MessageLength:   headerSize + 10 + tlvHeadSize + 6,
+		},
+		TLVs: []TLV{
+			&RequestUnicastTransmissionTLV{
+				TLVHead:               TLVHead{TLVType: TLVRequestUnicastTransmission, LengthField: 6},
+				MsgTypeAndReserved:    NewUnicastMsgTypeAndFlags(MessageSync, 0),
+				DurationField:         60,
+				LogInterMessagePeriod: 0,
+			},
+		},
+	}
+	sig, err := signaling.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, ValidatePacket(sig))
+
+	tests := []struct {
+		name   string
+		b      []byte
+		modify func(b []byte) []byte
+		err    error
+	}{
+		{
+			name:   "short",
+			b:      sync,
+			modify: func(b []byte) []byte { return b[:20] },
+			err:    ErrInvalidLength,
+		},
+		{
+			name:   "messageLength beyond packet",
+			b:      sync,
+			modify: func(b []byte) []byte { return b[:40] },
+			err:    ErrInvalidLength,
+		},
+		{
+			name:   "messageLength too small for type",
+			b:      sync,
+			modify: func(b []byte) []byte { b[3] = headerSize; return b },
+			err:    ErrInvalidLength,
+		},
+		{
+			name:   "version",
+			b:      sync,
+			modify: func(b []byte) []byte { b[1] = 1; return b },
+			err:    ErrUnsupportedVersion,
+		},
+		{
+			name:   "reserved message type",
+			b:      sync,
+			modify: func(b []byte) []byte { b[0] = 0x05; return b },
+			err:    ErrReservedBitsSet,
+		},
+		{
+			name:   "reserved flag",
+			b:      sync,
+			modify: func(b []byte) []byte { b[6] = 0x80; return b },
+			err:    ErrReservedBitsSet,
+		},
+		{
+			name:   "log interval",
+			b:      sync,
+			modify: func(b []byte) []byte { b[33] = 0x40; return b },
+			err:    ErrInvalidLogInterval,
+		},
+		{
+			name:   "truncated TLV",
+			b:      sig,
+			modify: func(b []byte) []byte { b[headerSize+10+3] = 8; return b },
+			err:    ErrTruncatedTLV,
+		},
+		{
+			name:   "odd TLV length",
+			b:      sig,
+			modify: func(b []byte) []byte { b[headerSize+10+3] = 5; return b },
+			err:    ErrTruncatedTLV,
+		},
+		{
+			name:   "no TLV",
+			b:      sig,
+			modify: func(b []byte) []byte { b[3] = headerSize + 10; return b },
+			err:    ErrTruncatedTLV,
+		},
+		{
+			name:   "garbage after TLV",
+			b:      sig,
+			modify: func(b []byte) []byte { b[3] += 2; return append(b, 0, 0) },
+			err:    ErrTruncatedTLV,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, len(tt.b))
+			copy(b, tt.b)
+			err := ValidatePacket(tt.modify(b))
+			require.Error(t, err)
+			require.True(t, errors.Is(err, tt.err), err)
+			var verr *ValidationError
+			require.True(t, errors.As(err, &verr))
+		})
+	}
+}
+
+func TestDecodePacketStrict(t *testing.T) {
+	sync, err := testSync().MarshalBinary()
+	require.NoError(t, err)
+	p, err := DecodePacketStrict(sync)
+	require.NoError(t, err)
+	require.Equal(t, testSync(), p)
+
+	sync[33] = 0x40
+	_, err = DecodePacketStrict(sync)
+	require.ErrorIs(t, err, ErrInvalidLogInterval)
+	// regular decoding doesn't care
+	_, err = DecodePacket(sync)
+	require.NoError(t, err)
+}
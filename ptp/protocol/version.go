@@ -0,0 +1,135 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Capability is a protocol feature that is only available starting from some minorVersionPTP
+type Capability uint8
+
+// Capabilities which depend on minorVersionPTP
+const (
+	// CapabilityAuthentication is AUTHENTICATION TLV, IEEE 1588-2019 Section 16.14
+	CapabilityAuthentication Capability = iota
+	// CapabilityMinorSdoID is minorSdoId header field, IEEE 1588-2019 Section 13.3.2.5
+	CapabilityMinorSdoID
+)
+
+// CapabilityToString is a map from Capability to string
+var CapabilityToString = map[Capability]string{
+	CapabilityAuthentication: "AUTHENTICATION",
+	CapabilityMinorSdoID:     "MINOR_SDO_ID",
+}
+
+func (c Capability) String() string {
+	return CapabilityToString[c]
+}
+
+// capabilityMinMinor is the minimal minorVersionPTP which supports the Capability
+var capabilityMinMinor = map[Capability]uint8{
+	CapabilityAuthentication: 1,
+	CapabilityMinorSdoID:     1,
+}
+
+// NewVersion builds versionPTP header field from majorVersionPTP and minorVersionPTP
+func NewVersion(major, minor uint8) uint8 {
+	return minor<<4 | major&MajorVersionMask
+}
+
+// MajorVersionPTP returns majorVersionPTP from versionPTP header field
+func MajorVersionPTP(v uint8) uint8 {
+	return v & MajorVersionMask
+}
+
+// MinorVersionPTP returns minorVersionPTP from versionPTP header field
+func MinorVersionPTP(v uint8) uint8 {
+	return v >> 4
+}
+
+// VersionString returns versionPTP in major.minor form, like 2.1
+func VersionString(v uint8) string {
+	return fmt.Sprintf("%d.%d", MajorVersionPTP(v), MinorVersionPTP(v))
+}
+
+// ParseVersion parses version in major.minor (or just major) form into versionPTP header field
+func ParseVersion(s string) (uint8, error) {
+	parts := strings.SplitN(s, ".", 2)
+	major, err := strconv.ParseUint(parts[0], 10, 4)
+	if err != nil {
+		return 0, fmt.Errorf("invalid major version in %q: %w", s, err)
+	}
+	var minor uint64
+	if len(parts) == 2 {
+		minor, err = strconv.ParseUint(parts[1], 10, 4)
+		if err != nil {
+			return 0, fmt.Errorf("invalid minor version in %q: %w", s, err)
+		}
+	}
+	v := NewVersion(uint8(major), uint8(minor))
+	if err := CheckVersion(v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// CheckVersion checks that versionPTP header field can be handled by us.
+// Any minorVersionPTP of PTPv2 is accepted, as 2.x versions are backwards compatible.
+func CheckVersion(v uint8) error {
+	if major := MajorVersionPTP(v); major != MajorVersion {
+		return fmt.Errorf("%w: majorVersionPTP %d", ErrUnsupportedVersion, major)
+	}
+	return nil
+}
+
+// NegotiateVersion returns versionPTP to be used when talking to the remote side, which is the lowest of the two
+func NegotiateVersion(local, remote uint8) uint8 {
+	if MajorVersionPTP(local) != MajorVersionPTP(remote) {
+		return local
+	}
+	if MinorVersionPTP(remote) < MinorVersionPTP(local) {
+		return remote
+	}
+	return local
+}
+
+// VersionSupports checks if versionPTP header field value allows usage of Capability
+func VersionSupports(v uint8, c Capability) bool {
+	minMinor, ok := capabilityMinMinor[c]
+	if !ok {
+		return false
+	}
+	return MajorVersionPTP(v) == MajorVersion && MinorVersionPTP(v) >= minMinor
+}
+
+// MajorVersionPTP returns majorVersionPTP of the packet
+func (p *Header) MajorVersionPTP() uint8 {
+	return MajorVersionPTP(p.Version)
+}
+
+// MinorVersionPTP returns minorVersionPTP of the packet
+func (p *Header) MinorVersionPTP() uint8 {
+	return MinorVersionPTP(p.Version)
+}
+
+// Supports checks if the packet version allows usage of Capability
+func (p *Header) Supports(c Capability) bool {
+	return VersionSupports(p.Version, c)
+}
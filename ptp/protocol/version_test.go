@@ -0,0 +1,108 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	require.Equal(t, Version, NewVersion(MajorVersion, MinorVersion))
+	require.Equal(t, uint8(2), MajorVersionPTP(Version))
+	require.Equal(t, uint8(1), MinorVersionPTP(Version))
+	require.Equal(t, "2.1", VersionString(Version))
+	require.Equal(t, "2.0", VersionString(MajorVersion))
+
+	h := &Header{Version: NewVersion(2, 3)}
+	require.Equal(t, uint8(2), h.MajorVersionPTP())
+	require.Equal(t, uint8(3), h.MinorVersionPTP())
+}
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("2.1")
+	require.Nil(t, err)
+	require.Equal(t, Version, v)
+
+	v, err = ParseVersion("2")
+	require.Nil(t, err)
+	require.Equal(t, MajorVersion, v)
+
+	v, err = ParseVersion("2.0")
+	require.Nil(t, err)
+	require.Equal(t, MajorVersion, v)
+
+	for _, s := range []string{"", "1", "3.1", "2.x", "2.16", "two"} {
+		_, err = ParseVersion(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	require.Nil(t, CheckVersion(MajorVersion))
+	require.Nil(t, CheckVersion(Version))
+	require.Nil(t, CheckVersion(NewVersion(2, 5)))
+	err := CheckVersion(NewVersion(1, 0))
+	require.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	require.Equal(t, MajorVersion, NegotiateVersion(Version, MajorVersion))
+	require.Equal(t, MajorVersion, NegotiateVersion(MajorVersion, Version))
+	require.Equal(t, Version, NegotiateVersion(Version, NewVersion(2, 2)))
+	// different major version is not something we can negotiate
+	require.Equal(t, Version, NegotiateVersion(Version, NewVersion(1, 0)))
+}
+
+func TestVersionSupports(t *testing.T) {
+	require.True(t, VersionSupports(Version, CapabilityAuthentication))
+	require.True(t, VersionSupports(NewVersion(2, 2), CapabilityMinorSdoID))
+	require.False(t, VersionSupports(MajorVersion, CapabilityAuthentication))
+	require.False(t, VersionSupports(NewVersion(3, 1), CapabilityAuthentication))
+	require.False(t, VersionSupports(Version, Capability(255)))
+	require.True(t, (&Header{Version: Version}).Supports(CapabilityAuthentication))
+	require.Equal(t, "AUTHENTICATION", CapabilityAuthentication.String())
+}
+
+func TestDecodePacketVersion(t *testing.T) {
+	p := testSync()
+	p.Version = NewVersion(2, 2)
+	b, err := Bytes(p)
+	require.Nil(t, err)
+	_, err = DecodePacket(b)
+	require.Nil(t, err)
+
+	p.Version = NewVersion(1, 0)
+	b, err = Bytes(p)
+	require.Nil(t, err)
+	_, err = DecodePacket(b)
+	require.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
+
+func TestSignPacketVersion(t *testing.T) {
+	p := testSync()
+	p.Version = MajorVersion
+	buf := make([]byte, 508)
+	n, err := BytesTo(p, buf)
+	require.Nil(t, err)
+	_, err = SignPacket(buf, n, 0, 1, testKeys)
+	require.Error(t, err)
+	_, err = VerifyPacket(buf[:n], testKeys)
+	require.Error(t, err)
+}
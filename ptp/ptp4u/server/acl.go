@@ -0,0 +1,167 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ACL restricts which clients may obtain unicast grants.
+// Deny rules take precedence over allow rules, empty allow list allows everyone not denied.
+type ACL struct {
+	sync.RWMutex
+	path  string
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parsePrefix parses CIDR prefix or a single IP address
+func parsePrefix(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func parsePrefixes(prefixes []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(prefixes))
+	for _, p := range prefixes {
+		n, err := parsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+// NewACL creates ACL from allow and deny lists of CIDR prefixes
+func NewACL(allow, deny []string) (*ACL, error) {
+	a := &ACL{}
+	var err error
+	if a.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ParseACL reads ACL rules, one per line, in the form of 'allow <prefix>' or 'deny <prefix>'.
+// Empty lines and lines starting with # are ignored.
+func ParseACL(r io.Reader) (*ACL, error) {
+	var allow, deny []string
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected '<allow|deny> <prefix>', got %q", line, text)
+		}
+		switch fields[0] {
+		case "allow":
+			allow = append(allow, fields[1])
+		case "deny":
+			deny = append(deny, fields[1])
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewACL(allow, deny)
+}
+
+// ReadACL reads ACL from the file. See ParseACL for the format.
+func ReadACL(path string) (*ACL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	a, err := ParseACL(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ACL %s: %w", path, err)
+	}
+	a.path = path
+	return a, nil
+}
+
+// Reload re-reads ACL from the file it was read from. Rules stay intact if the file is invalid.
+func (a *ACL) Reload() error {
+	if a.path == "" {
+		return fmt.Errorf("ACL was not read from a file")
+	}
+	n, err := ReadACL(a.path)
+	if err != nil {
+		return err
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.allow = n.allow
+	a.deny = n.deny
+	return nil
+}
+
+// Allowed checks if the client with given IP may obtain grants. Nil ACL allows everyone.
+func (a *ACL) Allowed(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	a.RLock()
+	defer a.RUnlock()
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestACLAllowed(t *testing.T) {
+	var a *ACL
+	require.True(t, a.Allowed(net.ParseIP("192.168.0.1")))
+
+	a, err := NewACL([]string{"192.168.0.0/16", "2401:db00::/32"}, []string{"192.168.1.0/24", "2401:db00::1"})
+	require.Nil(t, err)
+	require.True(t, a.Allowed(net.ParseIP("192.168.0.1")))
+	require.False(t, a.Allowed(net.ParseIP("192.168.1.1")))
+	require.False(t, a.Allowed(net.ParseIP("10.0.0.1")))
+	require.True(t, a.Allowed(net.ParseIP("2401:db00::2")))
+	require.False(t, a.Allowed(net.ParseIP("2401:db00::1")))
+
+	a, err = NewACL(nil, []string{"10.0.0.1"})
+	require.Nil(t, err)
+	require.True(t, a.Allowed(net.ParseIP("10.0.0.2")))
+	require.False(t, a.Allowed(net.ParseIP("10.0.0.1")))
+
+	_, err = NewACL([]string{"10.0.0.0/33"}, nil)
+	require.Error(t, err)
+	_, err = NewACL(nil, []string{"nope"})
+	require.Error(t, err)
+}
+
+func TestParseACL(t *testing.T) {
+	a, err := ParseACL(strings.NewReader("# comment\n\nallow 10.0.0.0/8\ndeny 10.1.0.0/16\n"))
+	require.Nil(t, err)
+	require.True(t, a.Allowed(net.ParseIP("10.0.0.1")))
+	require.False(t, a.Allowed(net.ParseIP("10.1.0.1")))
+
+	_, err = ParseACL(strings.NewReader("permit 10.0.0.0/8\n"))
+	require.EqualError(t, err, "line 1: unknown action \"permit\"")
+	_, err = ParseACL(strings.NewReader("allow\n"))
+	require.Error(t, err)
+}
+
+func TestACLReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl")
+	require.Nil(t, os.WriteFile(path, []byte("deny 10.0.0.1\n"), 0644))
+	a, err := ReadACL(path)
+	require.Nil(t, err)
+	require.False(t, a.Allowed(net.ParseIP("10.0.0.1")))
+
+	require.Nil(t, os.WriteFile(path, []byte("deny 10.0.0.2\n"), 0644))
+	require.Nil(t, a.Reload())
+	require.True(t, a.Allowed(net.ParseIP("10.0.0.1")))
+	require.False(t, a.Allowed(net.ParseIP("10.0.0.2")))
+
+	// broken file keeps old rules
+	require.Nil(t, os.WriteFile(path, []byte("deny\n"), 0644))
+	require.Error(t, a.Reload())
+	require.False(t, a.Allowed(net.ParseIP("10.0.0.2")))
+
+	_, err = ReadACL(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+	require.Error(t, (&ACL{}).Reload())
+}
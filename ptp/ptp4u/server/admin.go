@@ -0,0 +1,209 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+)
+
+// SubscriberInfo describes a subscription as listed by the admin API
+type SubscriberInfo struct {
+	ClientID string        `json:"client_id"`
+	IP       string        `json:"ip"`
+	Type     string        `json:"type"`
+	Domain   uint8         `json:"domain"`
+	Interval time.Duration `json:"interval"`
+	Expire   time.Time     `json:"expire"`
+	Worker   int           `json:"worker"`
+}
+
+// info returns the subscription details for the admin API
+func (sc *SubscriptionClient) info(clientID ptp.PortIdentity, worker int) SubscriberInfo {
+	sc.Lock()
+	defer sc.Unlock()
+	return SubscriberInfo{
+		ClientID: clientID.String(),
+		IP:       timestamp.SockaddrToIP(sc.gclisa).String(),
+		Type:     sc.subscriptionType.String(),
+		Domain:   sc.announceP.DomainNumber,
+		Interval: sc.interval,
+		Expire:   sc.expire,
+		Worker:   worker,
+	}
+}
+
+// subscribers returns details of the running unicast subscriptions of the worker
+func (s *sendWorker) subscribers() []SubscriberInfo {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	res := []SubscriberInfo{}
+	for _, subs := range s.clients {
+		for clientID, sc := range subs {
+			if !sc.granted || !sc.Running() {
+				continue
+			}
+			res = append(res, sc.info(clientID, s.id))
+		}
+	}
+	return res
+}
+
+// clientSubscriptions returns all subscriptions of the client in the worker
+func (s *sendWorker) clientSubscriptions(clientID ptp.PortIdentity) []*SubscriptionClient {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	res := []*SubscriptionClient{}
+	for _, subs := range s.clients {
+		if sc, ok := subs[clientID]; ok && sc.granted {
+			res = append(res, sc)
+		}
+	}
+	return res
+}
+
+// Drain stops granting subscriptions, existing ones run until they expire
+func (s *Server) Drain() {
+	atomic.StoreInt32(&s.drained, 1)
+}
+
+// Undrain resumes granting subscriptions
+func (s *Server) Undrain() {
+	atomic.StoreInt32(&s.drained, 0)
+}
+
+// Drained reports if the server is drained
+func (s *Server) Drained() bool {
+	return atomic.LoadInt32(&s.drained) == 1
+}
+
+// Subscribers returns details of all running unicast subscriptions sorted by client
+func (s *Server) Subscribers() []SubscriberInfo {
+	res := []SubscriberInfo{}
+	for _, w := range s.workers() {
+		res = append(res, w.subscribers()...)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].ClientID != res[j].ClientID {
+			return res[i].ClientID < res[j].ClientID
+		}
+		return res[i].Type < res[j].Type
+	})
+	return res
+}
+
+// CancelClient sends Cancel to the client for each of its subscriptions and stops them.
+// It returns how many subscriptions were cancelled.
+func (s *Server) CancelClient(clientID ptp.PortIdentity) int {
+	n := 0
+	for _, w := range s.workers() {
+		for _, sc := range w.clientSubscriptions(clientID) {
+			if !sc.Running() {
+				continue
+			}
+			s.sendCancel(sc, clientID)
+			sc.Stop()
+			n++
+		}
+	}
+	return n
+}
+
+// adminHandler returns http handler of the admin API
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/drain", s.handleDrain)
+	mux.HandleFunc("/undrain", s.handleDrain)
+	mux.HandleFunc("/subscribers", s.handleSubscribers)
+	mux.HandleFunc("/cancel", s.handleCancel)
+	return mux
+}
+
+// handleDrain drains or undrains the server
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/undrain") {
+		s.Undrain()
+		log.Warningf("Server undrained via admin API")
+	} else {
+		s.Drain()
+		log.Warningf("Server drained via admin API")
+	}
+	fmt.Fprintf(w, "drained: %v\n", s.Drained())
+}
+
+// handleSubscribers lists running subscriptions
+func (s *Server) handleSubscribers(w http.ResponseWriter, r *http.Request) {
+	js, err := json.Marshal(s.Subscribers())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(js); err != nil {
+		log.Errorf("Failed to reply: %v", err)
+	}
+}
+
+// handleCancel cancels all subscriptions of the client given as ?client=aabbcc.fffe.ddeeff-1
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	clientID, err := ptp.ParsePortIdentity(r.URL.Query().Get("client"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := s.CancelClient(clientID)
+	if n == 0 {
+		http.Error(w, fmt.Sprintf("no subscriptions of %s", clientID), http.StatusNotFound)
+		return
+	}
+	log.Warningf("Cancelled %d subscriptions of %s via admin API", n, clientID)
+	fmt.Fprintf(w, "cancelled: %d\n", n)
+}
+
+// startAdmin serves the admin API on the unix socket
+func (s *Server) startAdmin() error {
+	// remove the socket left from the previous run
+	if err := os.Remove(s.Config.AdminSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	l, err := net.Listen("unix", s.Config.AdminSocket)
+	if err != nil {
+		return err
+	}
+	log.Infof("Starting admin API on %s", s.Config.AdminSocket)
+	return http.Serve(l, s.adminHandler())
+}
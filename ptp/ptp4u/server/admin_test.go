@@ -0,0 +1,118 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+
+	"github.com/stretchr/testify/require"
+)
+
+func adminTestServer() (*Server, *sendWorker) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	w := &sendWorker{
+		id:      1,
+		queue:   make(chan *SubscriptionClient),
+		clients: make(map[ptp.MessageType]map[ptp.PortIdentity]*SubscriptionClient),
+	}
+	return &Server{Config: c, sw: []*sendWorker{w}}, w
+}
+
+func adminTestSubscription(w *sendWorker, c *Config, clientID ptp.PortIdentity, st ptp.MessageType, expire time.Time) *SubscriptionClient {
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, st, c, time.Second, expire)
+	sc.granted = true
+	sc.setRunning(true)
+	w.RegisterSubscription(clientID, st, sc)
+	return sc
+}
+
+func TestAdminDrain(t *testing.T) {
+	s, _ := adminTestServer()
+	h := s.adminHandler()
+	require.False(t, s.Drained())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/drain", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.False(t, s.Drained())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/drain", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "drained: true\n", rec.Body.String())
+	require.True(t, s.Drained())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/undrain", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.False(t, s.Drained())
+}
+
+func TestAdminSubscribers(t *testing.T) {
+	s, w := adminTestServer()
+	expire := time.Date(2021, time.December, 1, 0, 0, 0, 0, time.UTC)
+	clientID := ptp.PortIdentity{ClockIdentity: 0xaabbccfffeddeeff, PortNumber: 1}
+	adminTestSubscription(w, s.Config, clientID, ptp.MessageSync, expire)
+	adminTestSubscription(w, s.Config, clientID, ptp.MessageAnnounce, expire)
+	// not granted
+	sc := adminTestSubscription(w, s.Config, ptp.PortIdentity{}, ptp.MessageSync, expire)
+	sc.granted = false
+
+	rec := httptest.NewRecorder()
+	s.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/subscribers", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var got []SubscriberInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	want := []SubscriberInfo{
+		{ClientID: "aabbcc.fffe.ddeeff-1", IP: "127.0.0.1", Type: "ANNOUNCE", Interval: time.Second, Expire: expire, Worker: 1},
+		{ClientID: "aabbcc.fffe.ddeeff-1", IP: "127.0.0.1", Type: "SYNC", Interval: time.Second, Expire: expire, Worker: 1},
+	}
+	require.Equal(t, want, got)
+}
+
+func TestAdminCancel(t *testing.T) {
+	s, w := adminTestServer()
+	h := s.adminHandler()
+	clientID := ptp.PortIdentity{ClockIdentity: 0xaabbccfffeddeeff, PortNumber: 1}
+	sync := adminTestSubscription(w, s.Config, clientID, ptp.MessageSync, time.Now().Add(time.Minute))
+	announce := adminTestSubscription(w, s.Config, clientID, ptp.MessageAnnounce, time.Now().Add(time.Minute))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cancel?client=nope", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cancel?client=aabbcc.fffe.ddeeff-2", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/cancel?client=aabbcc.fffe.ddeeff-1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "cancelled: 2\n", rec.Body.String())
+	require.True(t, sync.Expired())
+	require.True(t, announce.Expired())
+}
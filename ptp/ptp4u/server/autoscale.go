@@ -0,0 +1,149 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we size the pool of send workers to the load. Each worker keeps the worst
+// queue depth and TX timestamp latency it has seen, and every AutoscaleInterval
+// the pool is grown when any worker falls behind or shrunk when all of them are
+// well within the limits. Resizing is a config reload, so subscriptions are kept.
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// workerLoad is the worst load of send workers over the autoscale interval
+type workerLoad struct {
+	queue       int64
+	txtsLatency time.Duration
+}
+
+// Autoscale checks if the number of send workers follows the load
+func (c *Config) Autoscale() bool {
+	return c.MaxSendWorkers > 0
+}
+
+// observeQueue records the depth of the worker queue
+func (s *sendWorker) observeQueue(n int) {
+	storeMax(&s.maxQueue, int64(n))
+}
+
+// observeTXTS records how long reading the TX timestamp took
+func (s *sendWorker) observeTXTS(d time.Duration) {
+	storeMax(&s.maxTXTSLatency, int64(d))
+}
+
+// takeLoad returns the worst load since the last call and starts over
+func (s *sendWorker) takeLoad() workerLoad {
+	return workerLoad{
+		queue:       atomic.SwapInt64(&s.maxQueue, 0),
+		txtsLatency: time.Duration(atomic.SwapInt64(&s.maxTXTSLatency, 0)),
+	}
+}
+
+// storeMax atomically raises the value at addr to v
+func storeMax(addr *int64, v int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if v <= old || atomic.CompareAndSwapInt64(addr, old, v) {
+			return
+		}
+	}
+}
+
+// overloaded checks if the load exceeds any of the limits
+func (c *Config) overloaded(l workerLoad) bool {
+	return (c.AutoscaleQueue > 0 && l.queue > int64(c.AutoscaleQueue)) ||
+		(c.AutoscaleTXTSLatency > 0 && l.txtsLatency > c.AutoscaleTXTSLatency)
+}
+
+// underloaded checks if the load is below a quarter of every limit, so shrinking doesn't immediately overload the pool
+func (c *Config) underloaded(l workerLoad) bool {
+	return (c.AutoscaleQueue <= 0 || l.queue <= int64(c.AutoscaleQueue/4)) &&
+		(c.AutoscaleTXTSLatency <= 0 || l.txtsLatency <= c.AutoscaleTXTSLatency/4)
+}
+
+// autoscaleWorkers returns how many send workers should serve the load.
+// Workers are doubled when overloaded and a quarter of them are removed when underloaded.
+func (c *Config) autoscaleWorkers(current int, l workerLoad) int {
+	n := current
+	switch {
+	case c.overloaded(l):
+		n = current * 2
+	case c.underloaded(l):
+		n = current - current/4
+		if n == current {
+			n--
+		}
+	}
+	if n > c.MaxSendWorkers {
+		n = c.MaxSendWorkers
+	}
+	if n < c.MinSendWorkers {
+		n = c.MinSendWorkers
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// collectLoad returns the worst load of all send workers since the last call
+func (s *Server) collectLoad() workerLoad {
+	var load workerLoad
+	for _, w := range s.workers() {
+		l := w.takeLoad()
+		if l.queue > load.queue {
+			load.queue = l.queue
+		}
+		if l.txtsLatency > load.txtsLatency {
+			load.txtsLatency = l.txtsLatency
+		}
+	}
+	return load
+}
+
+// resizeSendWorkers replaces send workers with n of them per domain
+func (s *Server) resizeSendWorkers(n int) error {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	dc := s.Config.dynamicConfig()
+	dc.SendWorkers = n
+	return s.reloadLocked(dc)
+}
+
+// autoscale resizes the send worker pool every AutoscaleInterval
+func (s *Server) autoscale() {
+	for {
+		<-time.After(s.Config.AutoscaleInterval)
+		// reload may have changed the number of workers, so read it with the load
+		s.reloadMux.Lock()
+		current := s.Config.SendWorkers
+		s.reloadMux.Unlock()
+		load := s.collectLoad()
+		n := s.Config.autoscaleWorkers(current, load)
+		if n == current {
+			continue
+		}
+		log.Infof("Resizing send workers %d -> %d, queue depth %d, TX timestamp latency %v", current, n, load.queue, load.txtsLatency)
+		if err := s.resizeSendWorkers(n); err != nil {
+			log.Errorf("Failed to resize send workers: %v", err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/ptp/ptp4u/stats"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoscale(t *testing.T) {
+	c := &Config{}
+	require.False(t, c.Autoscale())
+	c.MaxSendWorkers = 10
+	require.True(t, c.Autoscale())
+}
+
+func TestWorkerLoad(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	w := NewSendWorker(0, c, nil)
+
+	w.observeQueue(3)
+	w.observeQueue(5)
+	w.observeQueue(1)
+	w.observeTXTS(time.Millisecond)
+	w.observeTXTS(time.Microsecond)
+	require.Equal(t, workerLoad{queue: 5, txtsLatency: time.Millisecond}, w.takeLoad())
+	require.Equal(t, workerLoad{}, w.takeLoad())
+}
+
+func TestAutoscaleWorkers(t *testing.T) {
+	c := &Config{
+		MinSendWorkers:       2,
+		MaxSendWorkers:       10,
+		AutoscaleQueue:       100,
+		AutoscaleTXTSLatency: time.Millisecond,
+	}
+
+	// queue or latency over the limit doubles workers up to the max
+	require.Equal(t, 8, c.autoscaleWorkers(4, workerLoad{queue: 101}))
+	require.Equal(t, 8, c.autoscaleWorkers(4, workerLoad{txtsLatency: 2 * time.Millisecond}))
+	require.Equal(t, 10, c.autoscaleWorkers(8, workerLoad{queue: 200}))
+	// moderate load keeps workers
+	require.Equal(t, 4, c.autoscaleWorkers(4, workerLoad{queue: 50}))
+	require.Equal(t, 4, c.autoscaleWorkers(4, workerLoad{txtsLatency: 500 * time.Microsecond}))
+	// low load removes a quarter of workers down to the min
+	require.Equal(t, 6, c.autoscaleWorkers(8, workerLoad{queue: 10, txtsLatency: 10 * time.Microsecond}))
+	require.Equal(t, 2, c.autoscaleWorkers(3, workerLoad{}))
+	require.Equal(t, 2, c.autoscaleWorkers(2, workerLoad{}))
+	// reload may have set workers out of bounds
+	require.Equal(t, 10, c.autoscaleWorkers(50, workerLoad{queue: 50}))
+
+	// ignored limits
+	c.AutoscaleQueue = 0
+	require.Equal(t, 4, c.autoscaleWorkers(4, workerLoad{queue: 1000, txtsLatency: 500 * time.Microsecond}))
+	require.Equal(t, 3, c.autoscaleWorkers(4, workerLoad{queue: 1000, txtsLatency: 100 * time.Microsecond}))
+}
+
+func TestResizeSendWorkers(t *testing.T) {
+	c := &Config{
+		clockIdentity: ptp.ClockIdentity(1234),
+		Interface:     "lo",
+		IP:            net.ParseIP("127.0.0.1"),
+		TimestampType: timestamp.SW,
+		GeneralDSCP:   -1,
+		SendWorkers:   1,
+	}
+	st := stats.NewJSONStats()
+	old := NewSendWorker(0, c, st)
+	s := &Server{Config: c, Stats: st, sw: []*sendWorker{old}}
+
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	clientID := ptp.PortIdentity{ClockIdentity: ptp.ClockIdentity(1), PortNumber: 1}
+	sc := NewSubscriptionClient(old.queue, sa, sa, ptp.MessageSync, c, time.Second, time.Now().Add(time.Minute))
+	old.RegisterSubscription(clientID, ptp.MessageSync, sc)
+	old.observeQueue(10)
+
+	require.Equal(t, workerLoad{queue: 10}, s.collectLoad())
+	require.NoError(t, s.resizeSendWorkers(3))
+	require.Equal(t, 3, c.SendWorkers)
+	require.Equal(t, 3, len(s.workers()))
+
+	// subscriptions stay
+	w := s.findWorker(clientID, rand.New(rand.NewSource(time.Now().UnixNano())))
+	require.Equal(t, sc, w.FindSubscription(clientID, ptp.MessageSync))
+}
+
+func TestReloadConfigAutoscaled(t *testing.T) {
+	c := &Config{
+		clockIdentity:  ptp.ClockIdentity(1234),
+		Interface:      "lo",
+		IP:             net.ParseIP("127.0.0.1"),
+		TimestampType:  timestamp.SW,
+		GeneralDSCP:    -1,
+		SendWorkers:    3,
+		MinSendWorkers: 1,
+		MaxSendWorkers: 10,
+	}
+	st := stats.NewJSONStats()
+	s := &Server{Config: c, Stats: st, sw: []*sendWorker{NewSendWorker(0, c, st), NewSendWorker(1, c, st), NewSendWorker(2, c, st)}}
+
+	path := filepath.Join(t.TempDir(), "ptp4u.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"workers": 1, "dscp": 10}`), 0644))
+	require.NoError(t, s.ReloadConfig(path))
+	require.Equal(t, 10, c.DSCP)
+	require.Equal(t, 3, c.SendWorkers)
+	require.Equal(t, 3, len(s.workers()))
+
+	// without autoscaling the file sets the number of workers
+	c.MaxSendWorkers = 0
+	require.NoError(t, s.ReloadConfig(path))
+	require.Equal(t, 1, c.SendWorkers)
+	require.Equal(t, 1, len(s.workers()))
+}
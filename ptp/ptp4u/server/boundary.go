@@ -0,0 +1,805 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have boundary clock mode. Besides serving clients the server runs a client port
+// negotiating unicast Announce, Sync and Delay_Resp with the upstream grandmaster. Offsets
+// measured against it discipline PHC of the interface, and once synced the server announces
+// the grandmaster's data set with stepsRemoved increased by one, as a boundary clock would.
+// Until then, or after Announce stop coming, the server announces itself as a free running clock,
+// or as a clock in holdover for Holdover after losing the grandmaster.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/facebook/time/phc"
+	"github.com/facebook/time/ptp/bmca"
+	"github.com/facebook/time/ptp/filter"
+	"github.com/facebook/time/ptp/negotiation"
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// upstreamDuration is the grant duration requested from the upstream grandmaster
+const upstreamDuration = 60 * time.Second
+
+// upstreamCheckInterval is how often grants are renewed and Announce timeout is checked
+const upstreamCheckInterval = 100 * time.Millisecond
+
+// upstreamAnnounceTimeout is how many Announce intervals may pass without Announce before we consider the grandmaster lost
+const upstreamAnnounceTimeout = 3
+
+// freeRunningQuality is announced by the boundary clock not synced to the upstream grandmaster
+var freeRunningQuality = ptp.ClockQuality{
+	ClockClass:              248,
+	ClockAccuracy:           0xfe, // unknown
+	OffsetScaledLogVariance: 0xffff,
+}
+
+// parentFlags are Announce flags propagated from the upstream grandmaster
+const parentFlags = ptp.FlagLeap61 | ptp.FlagLeap59 | ptp.FlagCurrentUtcOffsetValid | ptp.FlagPTPTimescale | ptp.FlagTimeTraceable | ptp.FlagFrequencyTraceable
+
+// upstreamParent is what the boundary clock propagates from the grandmaster it's synced to
+type upstreamParent struct {
+	dataset    bmca.Dataset
+	utcOffset  int16
+	timeSource ptp.TimeSource
+	flags      uint16
+}
+
+// newUpstreamParent returns data of the grandmaster from its Announce
+func newUpstreamParent(a *ptp.Announce, receiver ptp.PortIdentity) *upstreamParent {
+	return &upstreamParent{
+		dataset:    *bmca.FromAnnounce(a, receiver),
+		utcOffset:  a.CurrentUTCOffset,
+		timeSource: a.TimeSource,
+		flags:      a.FlagField & parentFlags,
+	}
+}
+
+// Boundary checks if the server syncs from the upstream grandmaster
+func (c *Config) Boundary() bool {
+	return c.Upstream != nil && !c.UpstreamMonitor
+}
+
+// HasUpstream checks if the server talks to the upstream grandmaster, either syncing from it or monitoring it
+func (c *Config) HasUpstream() bool {
+	return c.Upstream != nil
+}
+
+// setUpstreamMeasurement records the last offset and path delay measured against the upstream grandmaster
+func (c *Config) setUpstreamMeasurement(offset, delay time.Duration) {
+	atomic.StoreInt64(&c.upstreamOffset, int64(offset))
+	atomic.StoreInt64(&c.upstreamDelay, int64(delay))
+	atomic.StoreInt32(&c.upstreamMeasured, 1)
+}
+
+// upstreamMeasurement returns the last offset and path delay measured against the upstream grandmaster, false if there is none
+func (c *Config) upstreamMeasurement() (time.Duration, time.Duration, bool) {
+	if atomic.LoadInt32(&c.upstreamMeasured) == 0 {
+		return 0, 0, false
+	}
+	return time.Duration(atomic.LoadInt64(&c.upstreamOffset)), time.Duration(atomic.LoadInt64(&c.upstreamDelay)), true
+}
+
+// setParent sets the grandmaster the boundary clock is synced to, nil if it's not synced
+func (c *Config) setParent(p *upstreamParent) {
+	c.parentMux.Lock()
+	defer c.parentMux.Unlock()
+	c.parent = p
+}
+
+// syncedParent returns the grandmaster the boundary clock is synced to, nil if it's not synced
+func (c *Config) syncedParent() *upstreamParent {
+	c.parentMux.RLock()
+	defer c.parentMux.RUnlock()
+	return c.parent
+}
+
+// propagateParent fills Announce with the data set of the grandmaster the boundary clock is synced to
+func (c *Config) propagateParent(a *ptp.Announce) {
+	if !c.Boundary() {
+		return
+	}
+	p := c.syncedParent()
+	if p == nil {
+		a.GrandmasterPriority1 = 128
+		a.GrandmasterPriority2 = 128
+		a.GrandmasterClockQuality = freeRunningQuality
+		if q, ok := c.holdoverQuality(time.Now()); ok {
+			a.GrandmasterClockQuality = q
+		}
+		a.GrandmasterIdentity = c.clockIdentity
+		a.StepsRemoved = 0
+		a.TimeSource = ptp.TimeSourceInternalOscillator
+		a.FlagField &^= ptp.FlagTimeTraceable | ptp.FlagFrequencyTraceable
+		return
+	}
+	a.GrandmasterPriority1 = p.dataset.GrandmasterPriority1
+	a.GrandmasterPriority2 = p.dataset.GrandmasterPriority2
+	a.GrandmasterClockQuality = c.timestampQuality(p.dataset.GrandmasterClockQuality)
+	a.GrandmasterIdentity = p.dataset.GrandmasterIdentity
+	a.StepsRemoved = p.dataset.StepsRemoved + 1
+	a.TimeSource = p.timeSource
+	a.CurrentUTCOffset = p.utcOffset
+	a.FlagField = a.FlagField&^parentFlags | p.flags
+	c.smearParent(a, time.Now())
+}
+
+// upstreamClock is the clock disciplined by the boundary clock
+type upstreamClock interface {
+	AdjFreqPPB(ppb float64) error
+	Step(step time.Duration) error
+}
+
+// phcClock is PHC of the interface
+type phcClock string
+
+func (d phcClock) AdjFreqPPB(ppb float64) error {
+	return phc.AdjFreqPPBFromDevice(string(d), ppb)
+}
+
+func (d phcClock) Step(step time.Duration) error {
+	return phc.StepFromDevice(string(d), step)
+}
+
+// realtimeClock is CLOCK_REALTIME, disciplined when the NIC has no hardware timestamps
+type realtimeClock struct{}
+
+func (realtimeClock) AdjFreqPPB(ppb float64) error {
+	return phc.AdjFreqPPBRealtime(ppb)
+}
+
+func (realtimeClock) Step(step time.Duration) error {
+	return phc.StepRealtime(step)
+}
+
+// UpstreamSWTS checks if the boundary clock uses software timestamps and disciplines CLOCK_REALTIME
+func (c *Config) UpstreamSWTS() bool {
+	return atomic.LoadInt32(&c.upstreamSWTS) == 1
+}
+
+// setUpstreamSWTS marks if the boundary clock uses software timestamps
+func (c *Config) setUpstreamSWTS(sw bool) {
+	var v int32
+	if sw {
+		v = 1
+	}
+	atomic.StoreInt32(&c.upstreamSWTS, v)
+}
+
+// disciplinedClock returns the clock synced to the upstream grandmaster along with its name and frequency in ppb:
+// PHC of the interface with hardware timestamps, CLOCK_REALTIME with software ones
+func (c *Config) disciplinedClock(ts timestamp.Timestamp) (upstreamClock, string, float64, error) {
+	if ts == timestamp.SW {
+		freq, err := phc.FrequencyPPBRealtime()
+		return realtimeClock{}, "CLOCK_REALTIME", freq, err
+	}
+	device, err := phc.DeviceFromIface(c.Interface)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	freq, err := phc.FrequencyPPBFromDevice(device)
+	return phcClock(device), device, freq, err
+}
+
+// upstreamPort is the client port of the boundary clock
+type upstreamPort struct {
+	mux       sync.Mutex
+	config    *Config
+	identity  ptp.PortIdentity
+	builder   *ptp.Builder
+	requester *negotiation.Requester
+	filter    filter.Pipeline
+	servo     servo
+	clock     upstreamClock
+
+	// sendEvent sends the event message to the grandmaster and returns its TX timestamp
+	sendEvent func(b []byte) (time.Time, error)
+	// sendGeneral sends the general message to the grandmaster
+	sendGeneral func(b []byte) error
+	// connections of the port, kept so they are not collected
+	eConn *net.UDPConn
+	gConn *net.UDPConn
+	// addresses of the grandmaster
+	eSA unix.Sockaddr
+	gSA unix.Sockaddr
+	// SW timestamps are taken from CLOCK_REALTIME in UTC, unlike timestamps of the grandmaster
+	swTS bool
+
+	// Sync awaiting Follow_Up
+	syncSeq        uint16
+	syncRX         time.Time
+	syncCorrection time.Duration
+	// last complete Sync, t1 and t2
+	syncSent     time.Time
+	syncReceived time.Time
+	// Delay_Req awaiting Delay_Resp, t3
+	delayReqSeq  uint16
+	delayReqSent time.Time
+	delay        time.Duration
+	haveDelay    bool
+
+	parent       *upstreamParent
+	lastAnnounce time.Time
+
+	// holdover
+	model            driftModel
+	lastSync         time.Time
+	holdoverSince    time.Time
+	holdoverAdjusted time.Time
+
+	// leap second of the grandmaster being followed
+	leapEvent time.Time
+	leapDelta time.Duration
+	// kernelLeap arms leap second in CLOCK_REALTIME
+	kernelLeap func(leap int) error
+
+	// last frequency of the clock, saved to the state file once set while synced
+	freq    float64
+	freqSet time.Time
+	// grandmaster of the state restored at startup, until its first Announce
+	restoredGM string
+	// cross-check against NTP servers, nil if disabled, and the last offset of the clock from NTP time in UTC
+	ntp         *ntpCheck
+	ntpOffset   time.Duration
+	ntpMeasured time.Time
+	// PHCs following the disciplined clock
+	secondaries []*secondaryPHC
+}
+
+// newUpstreamPort returns client port disciplining the clock
+func newUpstreamPort(c *Config, clock upstreamClock, freq float64) (*upstreamPort, error) {
+	f, err := filter.Parse(c.UpstreamFilter)
+	if err != nil {
+		return nil, err
+	}
+	// servo only gets samples passed by the filter
+	sv, err := newServo(c.UpstreamServo, c.UpstreamInterval*time.Duration(f.Decimation()), freq, c.upstreamSteps())
+	if err != nil {
+		return nil, err
+	}
+	identity := ptp.PortIdentity{PortNumber: 2, ClockIdentity: c.clockIdentity}
+	b := ptp.NewBuilder(identity, c.DomainNumber)
+	b.Version = c.ptpVersion()
+	b.Unicast = true
+	u := &upstreamPort{
+		config:   c,
+		identity: identity,
+		builder:  b,
+		requester: negotiation.NewRequester(negotiation.RequesterConfig{
+			Duration:        upstreamDuration,
+			GrantTimeout:    5 * time.Second,
+			MaxGrantTimeout: 2 * time.Minute,
+			BackoffJitter:   0.1,
+			DenialBackoff:   30 * time.Second,
+			RenewalMargin:   10 * time.Second,
+		}),
+		filter:     f,
+		servo:      sv,
+		freq:       freq,
+		clock:      clock,
+		kernelLeap: phc.SetLeapRealtime,
+	}
+	interval, _ := ptp.NewLogInterval(c.UpstreamInterval)
+	for _, t := range []ptp.MessageType{ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp} {
+		u.requester.Add(t, interval)
+	}
+	return u, nil
+}
+
+// signaling wraps TLVs into Signaling message to the grandmaster
+func (u *upstreamPort) signaling(tlvs []ptp.TLV) *ptp.Signaling {
+	length := binary.Size(ptp.Header{}) + binary.Size(ptp.PortIdentity{})
+	for _, tlv := range tlvs {
+		length += binary.Size(ptp.TLVHead{}) + int(tlvLength(tlv))
+	}
+	h := u.builder.Header(ptp.MessageSignaling, length, 0x7f)
+	h.SequenceID = u.builder.NextSequenceID(ptp.MessageSignaling)
+	return &ptp.Signaling{
+		Header:             h,
+		TargetPortIdentity: ptp.DefaultTargetPortIdentity,
+		TLVs:               tlvs,
+	}
+}
+
+// tlvLength returns lengthField of the TLV
+func tlvLength(tlv ptp.TLV) uint16 {
+	switch v := tlv.(type) {
+	case *ptp.RequestUnicastTransmissionTLV:
+		return v.LengthField
+	case *ptp.CancelUnicastTransmissionTLV:
+		return v.LengthField
+	case *ptp.AcknowledgeCancelUnicastTransmissionTLV:
+		return v.LengthField
+	}
+	return 0
+}
+
+// sendSignaling sends TLVs to the grandmaster
+func (u *upstreamPort) sendSignaling(tlvs []ptp.TLV) error {
+	b, err := ptp.Bytes(u.signaling(tlvs))
+	if err != nil {
+		return err
+	}
+	return u.sendGeneral(b)
+}
+
+// check renews grants, drops the grandmaster we didn't hear from for too long and runs holdover
+func (u *upstreamPort) check(now time.Time) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.parent != nil && now.Sub(u.lastAnnounce) > upstreamAnnounceTimeout*u.announceInterval() {
+		log.Warningf("No Announce from upstream grandmaster %s since %v, announcing as free running clock", u.config.Upstream, u.lastAnnounce)
+		u.parent = nil
+		u.config.setParent(nil)
+	}
+	u.holdover(now)
+	u.followLeap(now)
+	due := u.requester.Due(now)
+	if len(due) == 0 {
+		return nil
+	}
+	tlvs := make([]ptp.TLV, 0, len(due))
+	for _, tlv := range due {
+		tlvs = append(tlvs, tlv)
+	}
+	return u.sendSignaling(tlvs)
+}
+
+// announceInterval returns the granted Announce interval
+func (u *upstreamPort) announceInterval() time.Duration {
+	sub, ok := u.requester.Subscription(ptp.MessageAnnounce)
+	if !ok || sub.State != negotiation.StateGranted {
+		return u.config.UpstreamInterval
+	}
+	return sub.Interval.Duration()
+}
+
+// handle processes message from the grandmaster, rxTS is only set for event messages
+func (u *upstreamPort) handle(b []byte, rxTS time.Time) error {
+	msgType, err := ptp.ProbeMsgType(b)
+	if err != nil {
+		return err
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	now := time.Now()
+	rxTS = u.ptpTime(rxTS)
+	switch msgType {
+	case ptp.MessageSignaling:
+		sg := &ptp.Signaling{}
+		if err := ptp.FromBytes(b, sg); err != nil {
+			return err
+		}
+		for _, tlv := range sg.TLVs {
+			switch v := tlv.(type) {
+			case *ptp.GrantUnicastTransmissionTLV:
+				if err := u.requester.HandleGrant(v, now); err != nil {
+					return err
+				}
+				if v.DurationField == 0 {
+					log.Warningf("Upstream grandmaster %s denied %s", u.config.Upstream, v.MsgTypeAndReserved.MsgType())
+				}
+			case *ptp.CancelUnicastTransmissionTLV:
+				log.Warningf("Upstream grandmaster %s cancelled %s", u.config.Upstream, v.MsgTypeAndFlags.MsgType())
+				if err := u.sendSignaling([]ptp.TLV{u.requester.HandleCancel(v, now)}); err != nil {
+					return err
+				}
+			}
+		}
+	case ptp.MessageAnnounce:
+		a := &ptp.Announce{}
+		if err := ptp.FromBytes(b, a); err != nil {
+			return err
+		}
+		u.parent = newUpstreamParent(a, u.identity)
+		u.lastAnnounce = now
+		u.checkRestoredGM()
+		if u.haveDelay && u.servo.isLocked() {
+			u.config.setParent(u.parent)
+		}
+	case ptp.MessageSync:
+		s := &ptp.SyncDelayReq{}
+		if err := ptp.FromBytes(b, s); err != nil {
+			return err
+		}
+		if s.FlagField&ptp.FlagTwoStep == 0 {
+			return u.measure(s.OriginTimestamp.Time().Add(s.CorrectionField.Duration()), rxTS, now)
+		}
+		u.syncSeq = s.SequenceID
+		u.syncRX = rxTS
+		u.syncCorrection = s.CorrectionField.Duration()
+	case ptp.MessageFollowUp:
+		f := &ptp.FollowUp{}
+		if err := ptp.FromBytes(b, f); err != nil {
+			return err
+		}
+		if f.SequenceID != u.syncSeq || u.syncRX.IsZero() {
+			return nil
+		}
+		sent := f.PreciseOriginTimestamp.Time().Add(u.syncCorrection + f.CorrectionField.Duration())
+		received := u.syncRX
+		u.syncRX = time.Time{}
+		return u.measure(sent, received, now)
+	case ptp.MessageDelayResp:
+		d := &ptp.DelayResp{}
+		if err := ptp.FromBytes(b, d); err != nil {
+			return err
+		}
+		if d.SequenceID != u.delayReqSeq || d.RequestingPortIdentity != u.identity || u.delayReqSent.IsZero() {
+			return nil
+		}
+		received := d.ReceiveTimestamp.Time().Add(-d.CorrectionField.Duration())
+		u.delay = (u.syncReceived.Sub(u.syncSent) + received.Sub(u.delayReqSent)) / 2
+		u.haveDelay = true
+		u.delayReqSent = time.Time{}
+	}
+	return nil
+}
+
+// measure runs the servo with the offset measured from the Sync, and sends Delay_Req
+func (u *upstreamPort) measure(sent, received, now time.Time) error {
+	u.syncSent = sent
+	u.syncReceived = received
+	if u.haveDelay {
+		offset := received.Sub(sent) - u.delay - u.config.UpstreamAsymmetry
+		s, ok := u.filter.Sample(filter.Sample{Offset: offset, Delay: u.delay})
+		if ok {
+			u.config.setUpstreamMeasurement(s.Offset, s.Delay)
+			if u.config.UpstreamMonitor {
+				log.Debugf("Offset from upstream grandmaster %s is %v, delay %v", u.config.Upstream, s.Offset, s.Delay)
+			} else if err := u.discipline(s.Offset, now); err != nil {
+				return err
+			}
+		}
+	}
+	return u.sendDelayReq()
+}
+
+// discipline adjusts the clock by the offset from the grandmaster
+func (u *upstreamPort) discipline(offset time.Duration, now time.Time) error {
+	if u.panics(offset, now) || u.ntpRefuses(offset, now) {
+		return nil
+	}
+	ppb, step := u.servo.sample(offset, now)
+	log.Debugf("Offset from upstream grandmaster %s is %v, delay %v, frequency %.3f ppb", u.config.Upstream, offset, u.delay, ppb)
+	if step != 0 {
+		log.Infof("Stepping clock by %v to upstream grandmaster %s", step, u.config.Upstream)
+		if err := u.clock.Step(step); err != nil {
+			return fmt.Errorf("stepping clock: %w", err)
+		}
+		// measurements taken before the step are no good
+		u.haveDelay = false
+		u.filter.Reset()
+		u.config.setParent(nil)
+		u.ntpOffset += step
+		for _, p := range u.secondaries {
+			p.follow(step)
+		}
+		if u.config.OnUpstreamStep != nil {
+			u.config.OnUpstreamStep(step)
+		}
+	}
+	if err := u.clock.AdjFreqPPB(ppb); err != nil {
+		return fmt.Errorf("adjusting clock frequency: %w", err)
+	}
+	if step != 0 {
+		return nil
+	}
+	u.lastSync = now
+	if !u.relock(offset, now) {
+		return nil
+	}
+	u.model.add(now, ppb)
+	u.freq = ppb
+	u.freqSet = now
+	if u.parent != nil {
+		u.config.setParent(u.parent)
+	}
+	return nil
+}
+
+// panics checks if the offset is above the panic threshold and shouldn't be applied.
+// The threshold only guards the clock while it's synced: the first offset is always applied, and so is any offset
+// once the clock got no samples for the sync timeout, as after the grandmaster jumped
+func (u *upstreamPort) panics(offset time.Duration, now time.Time) bool {
+	p := u.config.UpstreamPanic
+	if p == 0 || offset <= p && offset >= -p {
+		return false
+	}
+	if !u.servo.isLocked() || u.lastSync.IsZero() || now.Sub(u.lastSync) > u.syncTimeout() {
+		log.Warningf("Offset %v from upstream grandmaster %s is above panic threshold %v while not synced, applied", offset, u.config.Upstream, p)
+		return false
+	}
+	if u.config.OnUpstreamPanic != nil && u.config.OnUpstreamPanic(offset) {
+		log.Warningf("Offset %v from upstream grandmaster %s is above panic threshold %v, accepted", offset, u.config.Upstream, p)
+		return false
+	}
+	log.Errorf("Offset %v from upstream grandmaster %s is above panic threshold %v, ignored", offset, u.config.Upstream, p)
+	return true
+}
+
+// sendDelayReq sends Delay_Req to the grandmaster and records when it was sent
+func (u *upstreamPort) sendDelayReq() error {
+	seq := u.builder.NextSequenceID(ptp.MessageDelayReq)
+	req := &ptp.SyncDelayReq{Header: u.builder.Header(ptp.MessageDelayReq, binary.Size(ptp.SyncDelayReq{}), 0x7f)}
+	req.SequenceID = seq
+	b, err := ptp.Bytes(req)
+	if err != nil {
+		return err
+	}
+	sent, err := u.sendEvent(b)
+	if err != nil {
+		return fmt.Errorf("sending delay request: %w", err)
+	}
+	u.delayReqSeq = seq
+	u.delayReqSent = u.ptpTime(sent)
+	return nil
+}
+
+// utcOffset returns UTC offset of the grandmaster, or the configured one until it announces itself
+func (u *upstreamPort) utcOffset() time.Duration {
+	if u.parent != nil {
+		return time.Duration(u.parent.utcOffset) * time.Second
+	}
+	return u.config.UTCOffset
+}
+
+// ptpTime converts timestamp taken by the port to PTP timescale of the grandmaster
+func (u *upstreamPort) ptpTime(ts time.Time) time.Time {
+	if !u.swTS || ts.IsZero() {
+		return ts
+	}
+	return ts.Add(u.utcOffset())
+}
+
+// listen binds the client port to UpstreamIP
+func (u *upstreamPort) listen(s *Server, ts timestamp.Timestamp) (eFd int, gFd int, err error) {
+	c := u.config
+	eConn, err := s.listenUDP(c.UpstreamIP, ptp.PortEvent, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	gConn, err := s.listenUDP(c.UpstreamIP, ptp.PortGeneral, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	u.eConn, u.gConn = eConn, gConn
+	eFd, err = timestamp.ConnFd(eConn)
+	if err != nil {
+		return 0, 0, err
+	}
+	gFd, err = timestamp.ConnFd(gConn)
+	if err != nil {
+		return 0, 0, err
+	}
+	if ts == timestamp.SW {
+		err = timestamp.EnableSWTimestampsSocket(eFd)
+	} else {
+		err = timestamp.EnableHWTimestampsSocket(eFd, c.Interface)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("enabling %s timestamps of the upstream port: %w", ts, err)
+	}
+	for _, fd := range []int{eFd, gFd} {
+		if err := unix.SetNonblock(fd, false); err != nil {
+			return 0, 0, err
+		}
+	}
+	u.swTS = ts == timestamp.SW
+	u.eSA = timestamp.IPToSockaddr(c.Upstream, ptp.PortEvent)
+	u.gSA = timestamp.IPToSockaddr(c.Upstream, ptp.PortGeneral)
+	oob := make([]byte, timestamp.ControlSizeBytes)
+	toob := make([]byte, timestamp.ControlSizeBytes)
+	// messages are only sent under the lock, so the grandmaster doesn't change meanwhile
+	u.sendEvent = func(b []byte) (time.Time, error) {
+		if err := timestamp.SendtoWithDrain(eFd, b, u.eSA); err != nil {
+			return time.Time{}, err
+		}
+		ts, _, err := timestamp.ReadTXtimestampBuf(eFd, oob, toob)
+		return ts, err
+	}
+	u.sendGeneral = func(b []byte) error {
+		return unix.Sendto(gFd, b, 0, u.gSA)
+	}
+	return eFd, gFd, nil
+}
+
+// read passes messages of the grandmaster received on the socket to the port
+func (u *upstreamPort) read(fd int, event bool) error {
+	buf := make([]byte, timestamp.PayloadSizeBytes)
+	oob := make([]byte, timestamp.ControlSizeBytes)
+	for {
+		var n int
+		var sa unix.Sockaddr
+		var rxTS time.Time
+		var err error
+		if event {
+			n, sa, rxTS, err = timestamp.ReadPacketWithRXTimestampBuf(fd, buf, oob)
+		} else {
+			n, sa, err = readPacketBuf(fd, buf)
+		}
+		if err != nil {
+			return err
+		}
+		ip := timestamp.SockaddrToIP(sa)
+		if !u.fromUpstream(ip) {
+			log.Debugf("Ignoring message from %s on the upstream port", ip)
+			continue
+		}
+		if err := u.handle(buf[:n], rxTS); err != nil {
+			log.Errorf("Failed to handle message of upstream grandmaster %s: %v", ip, err)
+		}
+	}
+}
+
+// startUpstream runs the client port of the boundary clock. It only returns on error
+func (s *Server) startUpstream() error {
+	// settings may be reloaded once the port is running
+	s.reloadMux.Lock()
+	// fallback of TX timestamps may change TimestampType later, the upstream port keeps what it started with
+	ts := s.Config.TimestampType
+	var eFd, gFd int
+	var u *upstreamPort
+	clock, device, freq, err := s.Config.disciplinedClock(ts)
+	if err == nil {
+		u, err = newUpstreamPort(s.Config, clock, freq)
+	}
+	if err == nil {
+		eFd, gFd, err = s.startUpstreamPort(u, device, ts)
+	}
+	s.reloadMux.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.runUpstream(u, eFd, gFd)
+}
+
+// startUpstreamPort binds the port and starts syncing secondary PHCs to the disciplined clock
+func (s *Server) startUpstreamPort(u *upstreamPort, device string, ts timestamp.Timestamp) (int, int, error) {
+	eFd, gFd, err := u.listen(s, ts)
+	if err != nil {
+		return -1, -1, err
+	}
+	if ts == timestamp.SW {
+		log.Warningf("Using %s timestamps with upstream grandmaster %s, accuracy is limited", ts, s.Config.Upstream)
+		s.Config.setUpstreamSWTS(true)
+	}
+	if s.Config.UpstreamMonitor {
+		log.Infof("Monitoring %s against upstream grandmaster %s from %s", device, s.Config.Upstream, s.Config.UpstreamIP)
+	} else {
+		log.Infof("Syncing %s to upstream grandmaster %s from %s", device, s.Config.Upstream, s.Config.UpstreamIP)
+		if s.Config.UpstreamStateFile != "" {
+			if err := u.restoreState(time.Now()); err != nil {
+				log.Warningf("Not restoring boundary clock state from %s: %v", s.Config.UpstreamStateFile, err)
+			}
+		}
+		if len(s.Config.UpstreamSecondaries) > 0 {
+			if u.secondaries, err = s.Config.newSecondaries(device, ts); err != nil {
+				return -1, -1, err
+			}
+			for _, p := range u.secondaries {
+				log.Infof("Syncing %s of %s to %s", p.device, p.iface, device)
+			}
+			go runSecondaries(u.secondaries)
+		}
+		if len(s.Config.UpstreamNTP) > 0 {
+			u.ntp = s.Config.newNTPCheck(device, ts)
+			log.Infof("Cross-checking %s against NTP servers %v", device, s.Config.UpstreamNTP)
+			go u.runNTPCheck()
+		}
+	}
+
+	s.upstream = u
+	return eFd, gFd, nil
+}
+
+// runUpstream reads messages of the grandmaster, renews grants and saves state of the port. It only returns on error
+func (s *Server) runUpstream(u *upstreamPort, eFd, gFd int) error {
+	errs := make(chan error, 2)
+	go func() { errs <- u.read(eFd, true) }()
+	go func() { errs <- u.read(gFd, false) }()
+	var saved time.Time
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(upstreamCheckInterval):
+			now := time.Now()
+			if err := u.check(now); err != nil {
+				log.Errorf("Failed to request grants from upstream grandmaster: %v", err)
+			}
+			if s.Config.UpstreamStateFile == "" || now.Sub(saved) < s.Config.StateInterval {
+				continue
+			}
+			saved = now
+			if err := u.saveState(now); err != nil {
+				log.Errorf("Failed to save boundary clock state to %s: %v", s.Config.UpstreamStateFile, err)
+			}
+		}
+	}
+}
+
+// upstreamSteps returns step thresholds of the servo syncing to the upstream grandmaster
+func (c *Config) upstreamSteps() stepThresholds {
+	t := defaultStepThresholds
+	if c.UpstreamFirstStep > 0 {
+		t.first = c.UpstreamFirstStep
+	}
+	if c.UpstreamStep > 0 {
+		t.locked = c.UpstreamStep
+	}
+	return t
+}
+
+// ValidateUpstream checks the boundary clock can sync from the upstream grandmaster
+func (c *Config) ValidateUpstream() error {
+	if c.UpstreamIP == nil {
+		return fmt.Errorf("upstream port needs own IP")
+	}
+	for _, ip := range c.ips() {
+		if ip.Equal(c.UpstreamIP) {
+			return fmt.Errorf("upstream port IP %s is served to clients", ip)
+		}
+	}
+	if c.TimestampType != timestamp.HW && c.TimestampType != timestamp.SW {
+		return fmt.Errorf("boundary clock requires %s or %s timestamps", timestamp.HW, timestamp.SW)
+	}
+	if c.UpstreamInterval <= 0 {
+		return fmt.Errorf("unsupported upstream interval %v", c.UpstreamInterval)
+	}
+	if _, err := filter.Parse(c.UpstreamFilter); err != nil {
+		return fmt.Errorf("unsupported upstream filter: %w", err)
+	}
+	if _, err := newServo(c.UpstreamServo, c.UpstreamInterval, 0, c.upstreamSteps()); err != nil {
+		return err
+	}
+	if c.UpstreamFirstStep < 0 || c.UpstreamStep < 0 {
+		return fmt.Errorf("unsupported upstream step thresholds %v, %v", c.UpstreamFirstStep, c.UpstreamStep)
+	}
+	if c.UpstreamPanic < 0 {
+		return fmt.Errorf("unsupported upstream panic threshold %v", c.UpstreamPanic)
+	}
+	if c.Holdover < 0 {
+		return fmt.Errorf("unsupported holdover %v", c.Holdover)
+	}
+	if len(c.UpstreamNTP) > 0 && (c.UpstreamNTPInterval <= 0 || c.UpstreamNTPThreshold <= 0) {
+		return fmt.Errorf("NTP cross-check requires positive interval and threshold, got %v, %v", c.UpstreamNTPInterval, c.UpstreamNTPThreshold)
+	}
+	for _, iface := range c.UpstreamSecondaries {
+		if iface == c.Interface {
+			return fmt.Errorf("secondary PHC of %s is the one synced to the upstream grandmaster", iface)
+		}
+	}
+	if c.UpstreamLeap == UpstreamLeapSmear {
+		if c.LeapSmear == SmearNone || c.LeapSmearWindow <= 0 || c.LeapSmearWindow > 2*leapArmWindow {
+			return fmt.Errorf("smearing leap seconds of the upstream grandmaster requires smear mode and window up to %v", 2*leapArmWindow)
+		}
+		if c.LeapSecondsFile != "" {
+			return fmt.Errorf("leap seconds are either smeared from the upstream grandmaster or from %s", c.LeapSecondsFile)
+		}
+	}
+	return nil
+}
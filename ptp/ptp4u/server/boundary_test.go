@@ -0,0 +1,519 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock records adjustments of the clock
+type fakeClock struct {
+	ppb   []float64
+	steps []time.Duration
+}
+
+func (c *fakeClock) AdjFreqPPB(ppb float64) error {
+	c.ppb = append(c.ppb, ppb)
+	return nil
+}
+
+func (c *fakeClock) Step(step time.Duration) error {
+	c.steps = append(c.steps, step)
+	return nil
+}
+
+var upstreamGM = ptp.PortIdentity{ClockIdentity: ptp.ClockIdentity(4321), PortNumber: 1}
+
+// newTestUpstreamPort returns port with fake clock, recording sent general messages and sending Delay_Req at delayReqSent
+func newTestUpstreamPort(t *testing.T, delayReqSent *time.Time) (*upstreamPort, *fakeClock, *[][]byte) {
+	c := &Config{
+		clockIdentity:    ptp.ClockIdentity(1234),
+		Upstream:         net.ParseIP("192.168.0.1"),
+		UpstreamInterval: time.Second,
+	}
+	clock := &fakeClock{}
+	u, err := newUpstreamPort(c, clock, 0)
+	require.NoError(t, err)
+	general := [][]byte{}
+	u.sendGeneral = func(b []byte) error {
+		general = append(general, b)
+		return nil
+	}
+	u.sendEvent = func(b []byte) (time.Time, error) {
+		return *delayReqSent, nil
+	}
+	return u, clock, &general
+}
+
+func gmMessage(t *testing.T, p ptp.Packet) []byte {
+	b, err := ptp.Bytes(p)
+	require.NoError(t, err)
+	return b
+}
+
+func gmHeader(msgType ptp.MessageType, length int, seq uint16) ptp.Header {
+	h := ptp.NewBuilder(upstreamGM, 0).Header(msgType, length, 0)
+	h.SequenceID = seq
+	return h
+}
+
+func TestUpstreamPortMeasure(t *testing.T) {
+	delay := 10 * time.Microsecond
+	offset := 5 * time.Microsecond
+	gmTime := time.Unix(1000, 0)
+	delayReqSent := gmTime.Add(time.Millisecond)
+	u, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+
+	announce := &ptp.Announce{
+		Header: gmHeader(ptp.MessageAnnounce, binary.Size(ptp.Header{})+binary.Size(ptp.AnnounceBody{}), 0),
+		AnnounceBody: ptp.AnnounceBody{
+			CurrentUTCOffset:        37,
+			GrandmasterPriority1:    1,
+			GrandmasterClockQuality: DefaultClockQuality,
+			GrandmasterPriority2:    2,
+			GrandmasterIdentity:     upstreamGM.ClockIdentity,
+			StepsRemoved:            1,
+			TimeSource:              ptp.TimeSourceGNSS,
+		},
+	}
+	announce.FlagField |= ptp.FlagCurrentUtcOffsetValid | ptp.FlagTimeTraceable
+	require.NoError(t, u.handle(gmMessage(t, announce), time.Time{}))
+	// not synced yet
+	require.Nil(t, u.config.syncedParent())
+
+	// two-step Sync, Delay_Req is sent after Follow_Up
+	sync := &ptp.SyncDelayReq{Header: gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), 1)}
+	sync.FlagField |= ptp.FlagTwoStep
+	require.NoError(t, u.handle(gmMessage(t, sync), gmTime.Add(delay+offset)))
+	followup := &ptp.FollowUp{
+		Header:       gmHeader(ptp.MessageFollowUp, binary.Size(ptp.FollowUp{}), 1),
+		FollowUpBody: ptp.FollowUpBody{PreciseOriginTimestamp: ptp.NewTimestamp(gmTime)},
+	}
+	require.NoError(t, u.handle(gmMessage(t, followup), time.Time{}))
+	require.Equal(t, delayReqSent, u.delayReqSent)
+	require.Empty(t, clock.ppb)
+
+	// Delay_Resp to someone else is ignored
+	resp := &ptp.DelayResp{
+		Header: gmHeader(ptp.MessageDelayResp, binary.Size(ptp.DelayResp{}), u.delayReqSeq),
+		DelayRespBody: ptp.DelayRespBody{
+			ReceiveTimestamp:       ptp.NewTimestamp(delayReqSent.Add(delay - offset)),
+			RequestingPortIdentity: ptp.PortIdentity{ClockIdentity: 42},
+		},
+	}
+	require.NoError(t, u.handle(gmMessage(t, resp), time.Time{}))
+	require.False(t, u.haveDelay)
+	resp.RequestingPortIdentity = u.identity
+	require.NoError(t, u.handle(gmMessage(t, resp), time.Time{}))
+	require.True(t, u.haveDelay)
+	require.Equal(t, delay, u.delay)
+
+	// one-step Sync gets measured right away
+	gmTime = gmTime.Add(time.Second)
+	sync = &ptp.SyncDelayReq{
+		Header:           gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), 2),
+		SyncDelayReqBody: ptp.SyncDelayReqBody{OriginTimestamp: ptp.NewTimestamp(gmTime)},
+	}
+	require.NoError(t, u.handle(gmMessage(t, sync), gmTime.Add(delay+offset)))
+	require.Empty(t, clock.steps)
+	require.Len(t, clock.ppb, 1)
+	require.InDelta(t, -5000.0, clock.ppb[0], 0.001)
+
+	// synced, so the data set of the grandmaster is propagated
+	a := &ptp.Announce{}
+	a.FlagField = ptp.FlagLeap61
+	u.config.propagateParent(a)
+	require.Equal(t, upstreamGM.ClockIdentity, a.GrandmasterIdentity)
+	require.Equal(t, uint8(1), a.GrandmasterPriority1)
+	require.Equal(t, uint8(2), a.GrandmasterPriority2)
+	require.Equal(t, DefaultClockQuality, a.GrandmasterClockQuality)
+	require.Equal(t, uint16(2), a.StepsRemoved)
+	require.Equal(t, ptp.TimeSourceGNSS, a.TimeSource)
+	require.Equal(t, int16(37), a.CurrentUTCOffset)
+	require.Equal(t, ptp.FlagCurrentUtcOffsetValid|ptp.FlagTimeTraceable, a.FlagField)
+
+	// grandmaster is lost once Announce stop
+	require.NoError(t, u.check(u.lastAnnounce.Add(2*time.Second)))
+	require.NotNil(t, u.config.syncedParent())
+	require.NoError(t, u.check(u.lastAnnounce.Add(4*time.Second)))
+	require.Nil(t, u.config.syncedParent())
+}
+
+func TestUpstreamPortStep(t *testing.T) {
+	gmTime := time.Unix(1000, 0)
+	delayReqSent := gmTime
+	u, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.haveDelay = true
+
+	sync := &ptp.SyncDelayReq{
+		Header:           gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), 1),
+		SyncDelayReqBody: ptp.SyncDelayReqBody{OriginTimestamp: ptp.NewTimestamp(gmTime)},
+	}
+	require.NoError(t, u.handle(gmMessage(t, sync), gmTime.Add(-time.Millisecond)))
+	require.Equal(t, []time.Duration{time.Millisecond}, clock.steps)
+	// delay has to be measured again after the step
+	require.False(t, u.haveDelay)
+}
+
+func TestUpstreamPortThresholds(t *testing.T) {
+	c := &Config{
+		clockIdentity:     ptp.ClockIdentity(1234),
+		Upstream:          net.ParseIP("192.168.0.1"),
+		UpstreamInterval:  time.Second,
+		UpstreamFirstStep: 2 * time.Millisecond,
+		UpstreamPanic:     time.Minute,
+	}
+	steps := []time.Duration{}
+	c.OnUpstreamStep = func(step time.Duration) {
+		steps = append(steps, step)
+	}
+	accept := false
+	panics := []time.Duration{}
+	c.OnUpstreamPanic = func(offset time.Duration) bool {
+		panics = append(panics, offset)
+		return accept
+	}
+	clock := &fakeClock{}
+	u, err := newUpstreamPort(c, clock, 0)
+	require.NoError(t, err)
+	now := time.Now()
+
+	// clock which isn't synced yet is stepped by any offset
+	require.NoError(t, u.discipline(time.Hour, now))
+	require.Empty(t, panics)
+	require.Equal(t, []time.Duration{-time.Hour}, clock.steps)
+	clock.steps = clock.steps[:0]
+	clock.ppb = clock.ppb[:0]
+	steps = steps[:0]
+
+	// below the first step threshold
+	require.NoError(t, u.discipline(time.Millisecond, now))
+	require.Empty(t, clock.steps)
+	require.Empty(t, steps)
+	require.Len(t, clock.ppb, 1)
+
+	// above the step threshold
+	require.NoError(t, u.discipline(2*time.Second, now))
+	require.Equal(t, []time.Duration{-2 * time.Second}, clock.steps)
+	require.Equal(t, clock.steps, steps)
+
+	// above the panic threshold the clock is left alone
+	require.NoError(t, u.discipline(-time.Hour, now))
+	require.Equal(t, []time.Duration{-time.Hour}, panics)
+	require.Len(t, clock.steps, 1)
+	require.Len(t, clock.ppb, 2)
+
+	// unless accepted
+	accept = true
+	require.NoError(t, u.discipline(-time.Hour, now))
+	require.Len(t, panics, 2)
+	require.Equal(t, time.Hour, clock.steps[1])
+	require.Equal(t, clock.steps, steps)
+
+	// or the clock got no samples for the sync timeout, as the grandmaster jumped
+	accept = false
+	require.NoError(t, u.discipline(time.Millisecond, now))
+	require.NoError(t, u.discipline(-time.Hour, now.Add(u.syncTimeout())))
+	require.Len(t, panics, 3)
+	require.Len(t, clock.steps, 2)
+	require.NoError(t, u.discipline(-time.Hour, now.Add(u.syncTimeout()+time.Second)))
+	require.Len(t, panics, 3)
+	require.Equal(t, time.Hour, clock.steps[2])
+}
+
+func TestUpstreamPortFilter(t *testing.T) {
+	c := &Config{
+		clockIdentity:    ptp.ClockIdentity(1234),
+		Upstream:         net.ParseIP("192.168.0.1"),
+		UpstreamInterval: time.Second,
+		UpstreamFilter:   "lucky:2",
+	}
+	clock := &fakeClock{}
+	u, err := newUpstreamPort(c, clock, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, u.syncTimeout()/upstreamAnnounceTimeout)
+	u.sendEvent = func(b []byte) (time.Time, error) {
+		return time.Now(), nil
+	}
+	u.haveDelay = true
+
+	// servo only gets every other sample
+	gmTime := time.Unix(1000, 0)
+	for i := 1; i <= 4; i++ {
+		sync := &ptp.SyncDelayReq{
+			Header:           gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), uint16(i)),
+			SyncDelayReqBody: ptp.SyncDelayReqBody{OriginTimestamp: ptp.NewTimestamp(gmTime)},
+		}
+		require.NoError(t, u.handle(gmMessage(t, sync), gmTime.Add(time.Microsecond)))
+		require.Len(t, clock.ppb, i/2)
+	}
+
+	c.UpstreamFilter = "kalman:1"
+	_, err = newUpstreamPort(c, clock, 0)
+	require.Error(t, err)
+}
+
+func TestUpstreamPortAsymmetry(t *testing.T) {
+	var delayReqSent time.Time
+	u, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.config.UpstreamAsymmetry = 5 * time.Microsecond
+	u.haveDelay = true
+
+	// offset seen is all asymmetry
+	gmTime := time.Unix(1000, 0)
+	sync := &ptp.SyncDelayReq{
+		Header:           gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), 1),
+		SyncDelayReqBody: ptp.SyncDelayReqBody{OriginTimestamp: ptp.NewTimestamp(gmTime)},
+	}
+	require.NoError(t, u.handle(gmMessage(t, sync), gmTime.Add(5*time.Microsecond)))
+	require.Equal(t, []float64{0}, clock.ppb)
+}
+
+func TestUpstreamPortSWTimestamps(t *testing.T) {
+	delay := 10 * time.Microsecond
+	utc := time.Unix(1000, 0)
+	tai := utc.Add(37 * time.Second)
+	// SW timestamps are in UTC
+	delayReqSent := utc.Add(time.Millisecond)
+	u, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.swTS = true
+	u.config.UTCOffset = 37 * time.Second
+
+	// configured UTC offset is used until the grandmaster announces its own
+	require.Equal(t, tai, u.ptpTime(utc))
+	require.True(t, u.ptpTime(time.Time{}).IsZero())
+	announce := &ptp.Announce{
+		Header:       gmHeader(ptp.MessageAnnounce, binary.Size(ptp.Header{})+binary.Size(ptp.AnnounceBody{}), 0),
+		AnnounceBody: ptp.AnnounceBody{CurrentUTCOffset: 37, GrandmasterIdentity: upstreamGM.ClockIdentity},
+	}
+	u.config.UTCOffset = 36 * time.Second
+	require.NoError(t, u.handle(gmMessage(t, announce), time.Time{}))
+	require.Equal(t, tai, u.ptpTime(utc))
+
+	sync := &ptp.SyncDelayReq{
+		Header:           gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), 1),
+		SyncDelayReqBody: ptp.SyncDelayReqBody{OriginTimestamp: ptp.NewTimestamp(tai)},
+	}
+	require.NoError(t, u.handle(gmMessage(t, sync), utc.Add(delay)))
+	require.Equal(t, tai.Add(time.Millisecond), u.delayReqSent)
+	resp := &ptp.DelayResp{
+		Header: gmHeader(ptp.MessageDelayResp, binary.Size(ptp.DelayResp{}), u.delayReqSeq),
+		DelayRespBody: ptp.DelayRespBody{
+			ReceiveTimestamp:       ptp.NewTimestamp(tai.Add(time.Millisecond + delay)),
+			RequestingPortIdentity: u.identity,
+		},
+	}
+	require.NoError(t, u.handle(gmMessage(t, resp), time.Time{}))
+	require.Equal(t, delay, u.delay)
+
+	// clock in sync with the grandmaster isn't stepped by the UTC offset
+	sync.SequenceID = 2
+	sync.OriginTimestamp = ptp.NewTimestamp(tai.Add(time.Second))
+	require.NoError(t, u.handle(gmMessage(t, sync), utc.Add(time.Second+delay)))
+	require.Empty(t, clock.steps)
+	require.Equal(t, []float64{0}, clock.ppb)
+	offset, _, ok := u.config.upstreamMeasurement()
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), offset)
+}
+
+func TestUpstreamPortMonitor(t *testing.T) {
+	var delayReqSent time.Time
+	u, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.config.UpstreamMonitor = true
+	require.False(t, u.config.Boundary())
+	require.True(t, u.config.HasUpstream())
+	u.haveDelay = true
+	u.delay = 10 * time.Microsecond
+	_, _, ok := u.config.upstreamMeasurement()
+	require.False(t, ok)
+
+	gmTime := time.Unix(1000, 0)
+	for i := 1; i <= 2; i++ {
+		sync := &ptp.SyncDelayReq{
+			Header:           gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), uint16(i)),
+			SyncDelayReqBody: ptp.SyncDelayReqBody{OriginTimestamp: ptp.NewTimestamp(gmTime)},
+		}
+		require.NoError(t, u.handle(gmMessage(t, sync), gmTime.Add(time.Millisecond)))
+	}
+	// measured, but clock is left alone
+	offset, delay, ok := u.config.upstreamMeasurement()
+	require.True(t, ok)
+	require.Equal(t, 990*time.Microsecond, offset)
+	require.Equal(t, 10*time.Microsecond, delay)
+	require.Empty(t, clock.ppb)
+	require.Empty(t, clock.steps)
+	require.Nil(t, u.config.syncedParent())
+
+	// and so it's not held over
+	require.NoError(t, u.check(time.Now().Add(time.Minute)))
+	require.Empty(t, clock.ppb)
+}
+
+func TestUpstreamPortNegotiation(t *testing.T) {
+	var delayReqSent time.Time
+	u, _, general := newTestUpstreamPort(t, &delayReqSent)
+	now := time.Now()
+
+	// everything is requested in one message
+	require.NoError(t, u.check(now))
+	require.Len(t, *general, 1)
+	sg := &ptp.Signaling{}
+	require.NoError(t, ptp.FromBytes((*general)[0], sg))
+	require.Equal(t, u.identity, sg.SourcePortIdentity)
+	require.Equal(t, ptp.DefaultTargetPortIdentity, sg.TargetPortIdentity)
+	require.Equal(t, int(sg.MessageLength)+2, len((*general)[0]))
+	require.Len(t, sg.TLVs, 3)
+
+	// granted subscriptions are not requested again until renewal
+	for _, tlv := range sg.TLVs {
+		req := tlv.(*ptp.RequestUnicastTransmissionTLV)
+		grant := &ptp.Signaling{
+			Header:             gmHeader(ptp.MessageSignaling, binary.Size(ptp.Header{})+binary.Size(ptp.PortIdentity{})+binary.Size(ptp.GrantUnicastTransmissionTLV{}), 0),
+			TargetPortIdentity: u.identity,
+			TLVs: []ptp.TLV{&ptp.GrantUnicastTransmissionTLV{
+				TLVHead:               ptp.TLVHead{TLVType: ptp.TLVGrantUnicastTransmission, LengthField: uint16(binary.Size(ptp.GrantUnicastTransmissionTLV{}) - binary.Size(ptp.TLVHead{}))},
+				MsgTypeAndReserved:    req.MsgTypeAndReserved,
+				LogInterMessagePeriod: req.LogInterMessagePeriod,
+				DurationField:         req.DurationField,
+				Renewal:               1,
+			}},
+		}
+		require.NoError(t, u.handle(gmMessage(t, grant), time.Time{}))
+	}
+	require.NoError(t, u.check(now.Add(time.Second)))
+	require.Len(t, *general, 1)
+
+	// cancellation is acknowledged
+	cancel := &ptp.Signaling{
+		Header:             gmHeader(ptp.MessageSignaling, binary.Size(ptp.Header{})+binary.Size(ptp.PortIdentity{})+binary.Size(ptp.CancelUnicastTransmissionTLV{}), 0),
+		TargetPortIdentity: u.identity,
+		TLVs: []ptp.TLV{&ptp.CancelUnicastTransmissionTLV{
+			TLVHead:         ptp.TLVHead{TLVType: ptp.TLVCancelUnicastTransmission, LengthField: uint16(binary.Size(ptp.CancelUnicastTransmissionTLV{}) - binary.Size(ptp.TLVHead{}))},
+			MsgTypeAndFlags: ptp.NewUnicastMsgTypeAndFlags(ptp.MessageSync, 0),
+		}},
+	}
+	require.NoError(t, u.handle(gmMessage(t, cancel), time.Time{}))
+	require.Len(t, *general, 2)
+	ack := &ptp.Signaling{}
+	require.NoError(t, ptp.FromBytes((*general)[1], ack))
+	_, ok := ack.TLVs[0].(*ptp.AcknowledgeCancelUnicastTransmissionTLV)
+	require.True(t, ok)
+}
+
+func TestPropagateParentFreeRunning(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	a := &ptp.Announce{}
+	a.GrandmasterClockQuality = DefaultClockQuality
+	// nothing changes unless in boundary clock mode
+	c.propagateParent(a)
+	require.Equal(t, DefaultClockQuality, a.GrandmasterClockQuality)
+	c.Upstream = net.ParseIP("192.168.0.1")
+	c.UpstreamMonitor = true
+	c.propagateParent(a)
+	require.Equal(t, DefaultClockQuality, a.GrandmasterClockQuality)
+	c.UpstreamMonitor = false
+
+	c.Upstream = net.ParseIP("192.168.0.1")
+	a.FlagField = ptp.FlagTimeTraceable | ptp.FlagPTPTimescale
+	c.propagateParent(a)
+	require.Equal(t, freeRunningQuality, a.GrandmasterClockQuality)
+	require.Equal(t, c.clockIdentity, a.GrandmasterIdentity)
+	require.Equal(t, uint16(0), a.StepsRemoved)
+	require.Equal(t, ptp.TimeSourceInternalOscillator, a.TimeSource)
+	require.Equal(t, ptp.FlagPTPTimescale, a.FlagField)
+}
+
+func TestPropagateParentSWTS(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), Upstream: net.ParseIP("192.168.0.1")}
+	gm := &ptp.Announce{}
+	gm.GrandmasterClockQuality = ptp.ClockQuality{ClockClass: 6, ClockAccuracy: 0x21}
+	c.setParent(newUpstreamParent(gm, upstreamGM))
+
+	a := &ptp.Announce{}
+	c.propagateParent(a)
+	require.Equal(t, gm.GrandmasterClockQuality, a.GrandmasterClockQuality)
+
+	// accuracy of the grandmaster is lost over software timestamps
+	c.setUpstreamSWTS(true)
+	require.True(t, c.UpstreamSWTS())
+	c.propagateParent(a)
+	require.Equal(t, uint8(6), a.GrandmasterClockQuality.ClockClass)
+	require.Equal(t, swClockAccuracy, a.GrandmasterClockQuality.ClockAccuracy)
+}
+
+func TestValidateUpstream(t *testing.T) {
+	c := &Config{
+		IP:               net.ParseIP("192.168.0.10"),
+		Upstream:         net.ParseIP("192.168.0.1"),
+		TimestampType:    timestamp.HW,
+		UpstreamInterval: time.Second,
+	}
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamIP = net.ParseIP("192.168.0.10")
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamIP = net.ParseIP("192.168.0.11")
+	require.NoError(t, c.ValidateUpstream())
+	c.TimestampType = timestamp.SW
+	require.NoError(t, c.ValidateUpstream())
+	c.TimestampType = timestamp.HWRX
+	require.Error(t, c.ValidateUpstream())
+	c.TimestampType = timestamp.HW
+	c.UpstreamInterval = 0
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamInterval = time.Second
+	c.Holdover = -time.Second
+	require.Error(t, c.ValidateUpstream())
+	c.Holdover = 0
+	c.UpstreamFilter = "median"
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamFilter = "median:5"
+	require.NoError(t, c.ValidateUpstream())
+	c.UpstreamStep = -time.Second
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamStep = 0
+	c.UpstreamPanic = -time.Second
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamPanic = time.Minute
+	require.NoError(t, c.ValidateUpstream())
+	c.UpstreamLeap = UpstreamLeapSmear
+	require.Error(t, c.ValidateUpstream())
+	c.LeapSmear = SmearCosine
+	c.LeapSmearWindow = 24 * time.Hour
+	require.NoError(t, c.ValidateUpstream())
+	c.LeapSecondsFile = "/usr/share/zoneinfo/right/UTC"
+	require.Error(t, c.ValidateUpstream())
+	c.LeapSecondsFile = ""
+	c.Interface = "eth0"
+	c.UpstreamSecondaries = []string{"eth1"}
+	require.NoError(t, c.ValidateUpstream())
+	c.UpstreamSecondaries = []string{"eth1", "eth0"}
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamSecondaries = nil
+	c.UpstreamNTP = []string{"ntp.example.com"}
+	require.Error(t, c.ValidateUpstream())
+	c.UpstreamNTPInterval = time.Minute
+	c.UpstreamNTPThreshold = 100 * time.Millisecond
+	require.NoError(t, c.ValidateUpstream())
+}
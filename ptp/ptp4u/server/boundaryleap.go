@@ -0,0 +1,161 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpstreamLeapMode is how the boundary clock handles leap seconds announced by the upstream grandmaster
+type UpstreamLeapMode int
+
+// Upstream leap second modes
+const (
+	// UpstreamLeapPropagate only passes leap flags of the grandmaster on to clients
+	UpstreamLeapPropagate UpstreamLeapMode = iota
+	// UpstreamLeapKernel also arms the kernel to insert or delete the leap second in CLOCK_REALTIME
+	UpstreamLeapKernel
+	// UpstreamLeapSmear smears the leap second over LeapSmearWindow instead of announcing it
+	UpstreamLeapSmear
+)
+
+// UpstreamLeapModeToString is a map from UpstreamLeapMode to the string
+var UpstreamLeapModeToString = map[UpstreamLeapMode]string{
+	UpstreamLeapPropagate: "propagate",
+	UpstreamLeapKernel:    "kernel",
+	UpstreamLeapSmear:     "smear",
+}
+
+// String returns UpstreamLeapMode as a string
+func (m UpstreamLeapMode) String() string {
+	s, found := UpstreamLeapModeToString[m]
+	if !found {
+		return "unsupported"
+	}
+	return s
+}
+
+// Set implements flag.Value
+func (m *UpstreamLeapMode) Set(value string) error {
+	for k, v := range UpstreamLeapModeToString {
+		if v == value {
+			*m = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown upstream leap mode %q", value)
+}
+
+// Type implements pflag.Value
+func (m *UpstreamLeapMode) Type() string {
+	return "upstreamleapmode"
+}
+
+// leapArmWindow is how long before the end of the UTC day leap flags of the grandmaster are acted upon,
+// so flags kept for a while after the event are not taken for a leap second of the next day
+const leapArmWindow = 12 * time.Hour
+
+// upstreamLeap returns UTC time and size of the leap second announced by the flags at the midnight following now, zero if none
+func upstreamLeap(flags uint16, now time.Time) (time.Time, time.Duration) {
+	var delta time.Duration
+	switch {
+	case flags&ptp.FlagLeap61 != 0:
+		delta = time.Second
+	case flags&ptp.FlagLeap59 != 0:
+		delta = -time.Second
+	default:
+		return time.Time{}, 0
+	}
+	utc := now.UTC()
+	midnight := time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+	if midnight.Sub(utc) > leapArmWindow {
+		return time.Time{}, 0
+	}
+	return midnight, delta
+}
+
+// setUpstreamSmear sets the leap second of the grandmaster to smear at the moment in PTP timescale,
+// along with UTC offset in seconds before it
+func (c *Config) setUpstreamSmear(event time.Time, delta time.Duration, utcOffset int16) {
+	c.leapMux.Lock()
+	defer c.leapMux.Unlock()
+	c.smearEvent = event
+	c.smearDelta = delta
+	c.smearUTCOffset = utcOffset
+}
+
+// smearParent hides the leap second of the grandmaster being smeared from Announce:
+// leap flags are dropped and UTC offset changes at the end of the window
+func (c *Config) smearParent(a *ptp.Announce, now time.Time) {
+	if c.UpstreamLeap != UpstreamLeapSmear {
+		return
+	}
+	a.FlagField &^= ptp.FlagLeap61 | ptp.FlagLeap59
+	c.leapMux.RLock()
+	event, utcOffset := c.smearEvent, c.smearUTCOffset
+	c.leapMux.RUnlock()
+	if event.IsZero() {
+		return
+	}
+	ptpNow := now.Add(time.Duration(a.CurrentUTCOffset) * time.Second)
+	if !ptpNow.Before(event.Add(-c.LeapSmearWindow/2)) && ptpNow.Before(event.Add(c.LeapSmearWindow/2)) {
+		a.CurrentUTCOffset = utcOffset
+	}
+}
+
+// followLeap acts on the leap second announced by the grandmaster as UpstreamLeap says
+func (u *upstreamPort) followLeap(now time.Time) {
+	mode := u.config.UpstreamLeap
+	// whatever was armed stays while the grandmaster is lost
+	if mode == UpstreamLeapPropagate || u.parent == nil {
+		return
+	}
+	event, delta := upstreamLeap(u.parent.flags, now)
+	if event.Equal(u.leapEvent) && delta == u.leapDelta {
+		return
+	}
+	if event.IsZero() && !u.leapEvent.IsZero() && !now.Before(u.leapEvent) {
+		// the leap second is over, the kernel disarms itself and smearing ends with the window
+		u.leapEvent, u.leapDelta = time.Time{}, 0
+		return
+	}
+	if event.IsZero() {
+		log.Warningf("Upstream grandmaster %s cancelled leap second at %v", u.config.Upstream, u.leapEvent)
+	} else {
+		log.Warningf("Upstream grandmaster %s announced leap second of %v at %v", u.config.Upstream, delta, event)
+	}
+	switch mode {
+	case UpstreamLeapKernel:
+		if err := u.kernelLeap(int(delta / time.Second)); err != nil {
+			log.Errorf("Failed to arm kernel leap second: %v", err)
+			return
+		}
+	case UpstreamLeapSmear:
+		if event.IsZero() {
+			u.config.setUpstreamSmear(time.Time{}, 0, 0)
+		} else {
+			utcOffset := u.parent.utcOffset
+			// midnight in PTP timescale, with UTC offset after the leap second
+			u.config.setUpstreamSmear(event.Add(time.Duration(utcOffset)*time.Second+delta), delta, utcOffset)
+		}
+	}
+	u.leapEvent, u.leapDelta = event, delta
+}
@@ -0,0 +1,123 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamLeapMode(t *testing.T) {
+	var m UpstreamLeapMode
+	require.NoError(t, m.Set("kernel"))
+	require.Equal(t, UpstreamLeapKernel, m)
+	require.Equal(t, "kernel", m.String())
+	require.Error(t, m.Set("ignore"))
+	require.Equal(t, "unsupported", UpstreamLeapMode(42).String())
+}
+
+func TestUpstreamLeap(t *testing.T) {
+	midnight := time.Date(2016, time.December, 31, 24, 0, 0, 0, time.UTC)
+
+	event, delta := upstreamLeap(ptp.FlagLeap61, midnight.Add(-time.Hour))
+	require.Equal(t, midnight, event)
+	require.Equal(t, time.Second, delta)
+
+	event, delta = upstreamLeap(ptp.FlagLeap59, midnight.Add(-time.Minute))
+	require.Equal(t, midnight, event)
+	require.Equal(t, -time.Second, delta)
+
+	// too early in the day, or flag left over after the event
+	event, _ = upstreamLeap(ptp.FlagLeap61, midnight.Add(-13*time.Hour))
+	require.True(t, event.IsZero())
+	event, _ = upstreamLeap(ptp.FlagLeap61, midnight.Add(time.Second))
+	require.True(t, event.IsZero())
+
+	event, _ = upstreamLeap(ptp.FlagPTPTimescale, midnight.Add(-time.Hour))
+	require.True(t, event.IsZero())
+}
+
+func TestFollowLeapKernel(t *testing.T) {
+	var delayReqSent time.Time
+	u, _, _ := newTestUpstreamPort(t, &delayReqSent)
+	leaps := []int{}
+	u.kernelLeap = func(leap int) error {
+		leaps = append(leaps, leap)
+		return nil
+	}
+	midnight := time.Date(2016, time.December, 31, 24, 0, 0, 0, time.UTC)
+	u.parent = &upstreamParent{flags: ptp.FlagLeap61}
+
+	// propagated only by default
+	u.followLeap(midnight.Add(-time.Hour))
+	require.Empty(t, leaps)
+
+	u.config.UpstreamLeap = UpstreamLeapKernel
+	u.followLeap(midnight.Add(-time.Hour))
+	u.followLeap(midnight.Add(-time.Minute))
+	require.Equal(t, []int{1}, leaps)
+
+	// cancelled before the event
+	u.parent.flags = 0
+	u.followLeap(midnight.Add(-time.Second))
+	require.Equal(t, []int{1, 0}, leaps)
+
+	// flag kept after the event isn't armed again
+	u.parent.flags = ptp.FlagLeap61
+	u.followLeap(midnight.Add(-time.Second))
+	u.followLeap(midnight.Add(time.Second))
+	require.Equal(t, []int{1, 0, 1}, leaps)
+	require.True(t, u.leapEvent.IsZero())
+}
+
+func TestFollowLeapSmear(t *testing.T) {
+	var delayReqSent time.Time
+	u, _, _ := newTestUpstreamPort(t, &delayReqSent)
+	c := u.config
+	c.UpstreamLeap = UpstreamLeapSmear
+	c.LeapSmear = SmearLinear
+	c.LeapSmearWindow = 2 * time.Hour
+	midnight := time.Date(2016, time.December, 31, 24, 0, 0, 0, time.UTC)
+	u.parent = &upstreamParent{flags: ptp.FlagLeap61 | ptp.FlagCurrentUtcOffsetValid, utcOffset: 36}
+
+	u.followLeap(midnight.Add(-2 * time.Hour))
+	event := midnight.Add(37 * time.Second)
+	require.Equal(t, event, c.smearEvent)
+	require.Equal(t, 500*time.Millisecond, c.smearOffset(event))
+
+	// leap second is not announced, and UTC offset changes at the end of the window
+	for _, tc := range []struct {
+		at        time.Time
+		gmOffset  int16
+		utcOffset int16
+	}{
+		{midnight.Add(-2 * time.Hour), 36, 36},
+		{midnight.Add(-time.Minute), 36, 36},
+		{midnight.Add(time.Minute), 37, 36},
+		{midnight.Add(2 * time.Hour), 37, 37},
+	} {
+		a := &ptp.Announce{}
+		a.CurrentUTCOffset = tc.gmOffset
+		a.FlagField = ptp.FlagLeap61 | ptp.FlagCurrentUtcOffsetValid
+		c.smearParent(a, tc.at)
+		require.Equal(t, tc.utcOffset, a.CurrentUTCOffset, tc.at)
+		require.Equal(t, ptp.FlagCurrentUtcOffsetValid, a.FlagField)
+	}
+}
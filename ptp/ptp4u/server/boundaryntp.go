@@ -0,0 +1,218 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have NTP cross-check of the boundary clock. Offset of the disciplined clock from NTP servers is
+// measured in the background, and every correction is checked against it: grandmaster which runs away from
+// NTP time by more than the threshold is alerted on, or not followed at all.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	ntp "github.com/facebook/time/ntp/protocol"
+	"github.com/facebook/time/phc"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+)
+
+// ntpTimeout is how long to wait for reply of NTP server
+const ntpTimeout = time.Second
+
+// ntpMaxAge is how many UpstreamNTPInterval the last cross-check is used for when NTP servers stop answering
+const ntpMaxAge = 3
+
+// states of the cross-check reported to stats
+const (
+	ntpStateNone int32 = iota
+	ntpStateOK
+	ntpStateAlarm
+)
+
+// ntpCheck measures the disciplined clock against NTP servers
+type ntpCheck struct {
+	servers []string
+	// query returns offset of NTP server from CLOCK_REALTIME
+	query func(server string) (time.Duration, error)
+	// clockOffset returns offset of the disciplined clock from CLOCK_REALTIME
+	clockOffset func() (time.Duration, error)
+	// ptpTimescale is set if the disciplined clock is PHC in PTP timescale, CLOCK_REALTIME is in UTC like NTP
+	ptpTimescale bool
+}
+
+// newNTPCheck returns cross-check of the disciplined device against UpstreamNTP
+func (c *Config) newNTPCheck(device string, ts timestamp.Timestamp) *ntpCheck {
+	n := &ntpCheck{
+		servers: c.UpstreamNTP,
+		query:   queryNTP,
+		clockOffset: func() (time.Duration, error) {
+			return 0, nil
+		},
+	}
+	if ts != timestamp.SW {
+		n.ptpTimescale = true
+		n.clockOffset = func() (time.Duration, error) {
+			r, err := phc.TimeAndOffsetFromDevice(device, phc.MethodIoctlSysOffsetExtended)
+			return -r.Offset, err
+		}
+	}
+	return n
+}
+
+// queryNTP sends client request to the NTP server and returns its offset from CLOCK_REALTIME
+func queryNTP(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, strconv.Itoa(123))
+	}
+	conn, err := net.DialTimeout("udp", server, ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(ntpTimeout)); err != nil {
+		return 0, err
+	}
+	sent := time.Now()
+	sec, frac := ntp.Time(sent)
+	request := &ntp.Packet{Settings: 0x1B, TxTimeSec: sec, TxTimeFrac: frac}
+	if err := binary.Write(conn, binary.BigEndian, request); err != nil {
+		return 0, fmt.Errorf("sending request: %w", err)
+	}
+	buf := make([]byte, ntp.PacketSizeBytes)
+	if _, err := conn.Read(buf); err != nil {
+		return 0, fmt.Errorf("reading reply: %w", err)
+	}
+	received := time.Now()
+	response, err := ntp.BytesToPacket(buf)
+	if err != nil {
+		return 0, err
+	}
+	if response.OrigTimeSec != sec || response.OrigTimeFrac != frac {
+		return 0, fmt.Errorf("reply to another request")
+	}
+	if response.Stratum == 0 || response.Settings>>6 == 3 {
+		return 0, fmt.Errorf("server is not synchronized")
+	}
+	serverReceived := ntp.Unix(response.RxTimeSec, response.RxTimeFrac)
+	serverSent := ntp.Unix(response.TxTimeSec, response.TxTimeFrac)
+	delay := ntp.AvgNetworkDelay(sent, serverReceived, serverSent, received)
+	return time.Duration(ntp.CalculateOffset(ntp.CurrentRealTime(serverSent, delay), received)), nil
+}
+
+// measure returns offset of the disciplined clock from the median of NTP servers which answered
+func (n *ntpCheck) measure() (time.Duration, error) {
+	offsets := []time.Duration{}
+	for _, server := range n.servers {
+		offset, err := n.query(server)
+		if err != nil {
+			log.Warningf("Failed to query NTP server %s: %v", server, err)
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(offsets) == 0 {
+		return 0, fmt.Errorf("no NTP server answered")
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	ntpOffset := offsets[len(offsets)/2]
+	if len(offsets)%2 == 0 {
+		ntpOffset = (offsets[len(offsets)/2-1] + ntpOffset) / 2
+	}
+	clockOffset, err := n.clockOffset()
+	if err != nil {
+		return 0, fmt.Errorf("measuring disciplined clock: %w", err)
+	}
+	return clockOffset - ntpOffset, nil
+}
+
+// crossCheck measures offset of the disciplined clock from NTP time in UTC
+func (u *upstreamPort) crossCheck(now time.Time) error {
+	offset, err := u.ntp.measure()
+	if err != nil {
+		return err
+	}
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.ntpOffset = offset
+	if u.ntp.ptpTimescale {
+		u.ntpOffset -= u.utcOffset()
+	}
+	u.ntpMeasured = now
+	state := ntpStateOK
+	if absDuration(u.ntpOffset) > u.config.UpstreamNTPThreshold {
+		state = ntpStateAlarm
+		log.Errorf("Clock synced to upstream grandmaster %s is %v away from NTP time, above threshold %v", u.config.Upstream, u.ntpOffset, u.config.UpstreamNTPThreshold)
+	}
+	u.config.setUpstreamNTP(u.ntpOffset, state)
+	return nil
+}
+
+// ntpRefuses checks if correction by the offset from the grandmaster leaves the clock too far from NTP time and
+// shouldn't be applied. Without recent cross-check every correction is applied
+func (u *upstreamPort) ntpRefuses(offset time.Duration, now time.Time) bool {
+	if u.ntp == nil || u.ntpMeasured.IsZero() || now.Sub(u.ntpMeasured) > ntpMaxAge*u.config.UpstreamNTPInterval {
+		return false
+	}
+	// clock is corrected to the time of the grandmaster
+	gmOffset := u.ntpOffset - offset
+	t := u.config.UpstreamNTPThreshold
+	if absDuration(gmOffset) <= t {
+		return false
+	}
+	u.config.setUpstreamNTP(gmOffset, ntpStateAlarm)
+	if !u.config.UpstreamNTPRefuse {
+		log.Errorf("Upstream grandmaster %s is %v away from NTP time, above threshold %v, followed", u.config.Upstream, gmOffset, t)
+		return false
+	}
+	log.Errorf("Upstream grandmaster %s is %v away from NTP time, above threshold %v, refused", u.config.Upstream, gmOffset, t)
+	return true
+}
+
+// absDuration returns the absolute value of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// runNTPCheck cross-checks the disciplined clock against NTP servers every UpstreamNTPInterval. It never returns
+func (u *upstreamPort) runNTPCheck() {
+	for {
+		if err := u.crossCheck(time.Now()); err != nil {
+			log.Errorf("Failed to cross-check clock against NTP: %v", err)
+		}
+		<-time.After(u.config.UpstreamNTPInterval)
+	}
+}
+
+// setUpstreamNTP records the last offset of the boundary clock from NTP time and if it's above the threshold
+func (c *Config) setUpstreamNTP(offset time.Duration, state int32) {
+	atomic.StoreInt64(&c.upstreamNTP, int64(offset))
+	atomic.StoreInt32(&c.upstreamNTPState, state)
+}
+
+// upstreamNTPOffset returns the last offset of the boundary clock from NTP time, if it's above the threshold, and false if there is none
+func (c *Config) upstreamNTPOffset() (time.Duration, bool, bool) {
+	state := atomic.LoadInt32(&c.upstreamNTPState)
+	return time.Duration(atomic.LoadInt64(&c.upstreamNTP)), state == ntpStateAlarm, state != ntpStateNone
+}
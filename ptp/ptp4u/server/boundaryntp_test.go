@@ -0,0 +1,202 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ntp "github.com/facebook/time/ntp/protocol"
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNTPCheck returns cross-check against servers answering with the offsets, or failing if there is none
+func fakeNTPCheck(servers map[string]time.Duration, clockOffset time.Duration) *ntpCheck {
+	n := &ntpCheck{
+		query: func(server string) (time.Duration, error) {
+			offset, ok := servers[server]
+			if !ok {
+				return 0, fmt.Errorf("timeout")
+			}
+			return offset, nil
+		},
+		clockOffset:  func() (time.Duration, error) { return clockOffset, nil },
+		ptpTimescale: true,
+	}
+	for server := range servers {
+		n.servers = append(n.servers, server)
+	}
+	return n
+}
+
+func TestNTPCheckMeasure(t *testing.T) {
+	n := fakeNTPCheck(map[string]time.Duration{"a": time.Millisecond, "b": 2 * time.Millisecond, "c": time.Second}, 37*time.Second)
+	n.servers = append(n.servers, "down")
+	// outlier doesn't move the median
+	offset, err := n.measure()
+	require.NoError(t, err)
+	require.Equal(t, 37*time.Second-2*time.Millisecond, offset)
+
+	n = fakeNTPCheck(map[string]time.Duration{"a": time.Millisecond, "b": 2 * time.Millisecond}, 0)
+	offset, err = n.measure()
+	require.NoError(t, err)
+	require.Equal(t, -1500*time.Microsecond, offset)
+
+	n.servers = []string{"down"}
+	_, err = n.measure()
+	require.Error(t, err)
+}
+
+func TestUpstreamPortCrossCheck(t *testing.T) {
+	var delayReqSent time.Time
+	u, _, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.config.UTCOffset = 37 * time.Second
+	u.config.UpstreamNTPThreshold = 100 * time.Millisecond
+	u.ntp = fakeNTPCheck(map[string]time.Duration{"a": -time.Millisecond}, 37*time.Second)
+	_, _, ok := u.config.upstreamNTPOffset()
+	require.False(t, ok)
+
+	now := time.Now()
+	require.NoError(t, u.crossCheck(now))
+	require.Equal(t, time.Millisecond, u.ntpOffset)
+	require.Equal(t, now, u.ntpMeasured)
+	offset, alarm, ok := u.config.upstreamNTPOffset()
+	require.True(t, ok)
+	require.False(t, alarm)
+	require.Equal(t, time.Millisecond, offset)
+
+	// UTC offset of the grandmaster is used once it's known
+	u.parent = &upstreamParent{utcOffset: 36}
+	require.NoError(t, u.crossCheck(now))
+	require.Equal(t, time.Second+time.Millisecond, u.ntpOffset)
+	_, alarm, _ = u.config.upstreamNTPOffset()
+	require.True(t, alarm)
+
+	u.ntp.servers = []string{"down"}
+	require.Error(t, u.crossCheck(now))
+
+	// CLOCK_REALTIME disciplined with SW timestamps is in UTC already
+	u.ntp = fakeNTPCheck(map[string]time.Duration{"a": -time.Millisecond}, 0)
+	u.ntp.ptpTimescale = false
+	require.NoError(t, u.crossCheck(now))
+	require.Equal(t, time.Millisecond, u.ntpOffset)
+	_, alarm, _ = u.config.upstreamNTPOffset()
+	require.False(t, alarm)
+}
+
+func TestNewNTPCheck(t *testing.T) {
+	c := &Config{UpstreamNTP: []string{"a"}}
+	n := c.newNTPCheck("", timestamp.SW)
+	require.False(t, n.ptpTimescale)
+	offset, err := n.clockOffset()
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), offset)
+	require.True(t, c.newNTPCheck("/dev/ptp0", timestamp.HW).ptpTimescale)
+}
+
+func TestUpstreamPortNTPRefuses(t *testing.T) {
+	gmTime := time.Unix(1000, 0)
+	delayReqSent := gmTime
+	u, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.config.UpstreamNTPInterval = time.Minute
+	u.config.UpstreamNTPThreshold = 100 * time.Millisecond
+	u.servo.resume(0)
+	now := time.Now()
+
+	// nothing to check against yet
+	require.False(t, u.ntpRefuses(time.Second, now))
+	u.ntp = fakeNTPCheck(nil, 0)
+	require.False(t, u.ntpRefuses(time.Second, now))
+
+	// clock is 50ms ahead of NTP time, grandmaster which keeps it there or brings it closer is fine
+	u.ntpOffset = 50 * time.Millisecond
+	u.ntpMeasured = now
+	require.False(t, u.ntpRefuses(50*time.Millisecond, now))
+	require.False(t, u.ntpRefuses(-40*time.Millisecond, now))
+	// one taking it 200ms away is only alerted on
+	require.False(t, u.ntpRefuses(-150*time.Millisecond, now))
+	offset, alarm, _ := u.config.upstreamNTPOffset()
+	require.True(t, alarm)
+	require.Equal(t, 200*time.Millisecond, offset)
+
+	// or refused
+	u.config.UpstreamNTPRefuse = true
+	require.True(t, u.ntpRefuses(-150*time.Millisecond, now))
+	require.NoError(t, u.discipline(-150*time.Millisecond, now))
+	require.Empty(t, clock.ppb)
+	require.Empty(t, clock.steps)
+	require.NoError(t, u.discipline(10*time.Microsecond, now))
+	require.Len(t, clock.ppb, 1)
+
+	// until the cross-check gets too old to rely on
+	require.False(t, u.ntpRefuses(-150*time.Millisecond, now.Add(ntpMaxAge*time.Minute+time.Second)))
+
+	// step applied anyway moves the clock away from NTP time
+	u.config.UpstreamNTPRefuse = false
+	u.haveDelay = true
+	sync := &ptp.SyncDelayReq{
+		Header:           gmHeader(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), 1),
+		SyncDelayReqBody: ptp.SyncDelayReqBody{OriginTimestamp: ptp.NewTimestamp(gmTime)},
+	}
+	require.NoError(t, u.handle(gmMessage(t, sync), gmTime.Add(-2*time.Second)))
+	require.Equal(t, []time.Duration{2 * time.Second}, clock.steps)
+	require.Equal(t, 50*time.Millisecond+2*time.Second, u.ntpOffset)
+}
+
+func TestQueryNTP(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer conn.Close()
+	serverOffset := 3 * time.Second
+	stratum := int32(1)
+	go func() {
+		for {
+			request, addr, err := ntp.ReadNTPPacket(conn)
+			if err != nil {
+				return
+			}
+			now := time.Now().Add(serverOffset)
+			sec, frac := ntp.Time(now)
+			b, _ := (&ntp.Packet{
+				Settings:     0x1C,
+				Stratum:      uint8(atomic.LoadInt32(&stratum)),
+				OrigTimeSec:  request.TxTimeSec,
+				OrigTimeFrac: request.TxTimeFrac,
+				RxTimeSec:    sec,
+				RxTimeFrac:   frac,
+				TxTimeSec:    sec,
+				TxTimeFrac:   frac,
+			}).Bytes()
+			_, _ = conn.WriteTo(b, addr)
+		}
+	}()
+
+	offset, err := queryNTP(conn.LocalAddr().String())
+	require.NoError(t, err)
+	require.InDelta(t, serverOffset, offset, float64(100*time.Millisecond))
+
+	// unsynchronized server is no good
+	atomic.StoreInt32(&stratum, 0)
+	_, err = queryNTP(conn.LocalAddr().String())
+	require.Error(t, err)
+}
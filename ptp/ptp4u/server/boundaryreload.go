@@ -0,0 +1,221 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have config reload of the boundary clock. The upstream grandmaster, the interval, the filter
+// and the servo can be changed without restart. The clock is never stepped by the reload: new servo
+// continues locked from the current frequency, and measurements start over with the new settings.
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/facebook/time/ptp/filter"
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpstreamDynamicConfig is the part of the dynamic config of the boundary clock, durations are as time.ParseDuration reads them
+type UpstreamDynamicConfig struct {
+	Upstream          string `json:"upstream,omitempty"`
+	UpstreamInterval  string `json:"upstreaminterval,omitempty"`
+	UpstreamFilter    string `json:"upstreamfilter,omitempty"`
+	UpstreamServo     string `json:"upstreamservo,omitempty"`
+	UpstreamFirstStep string `json:"upstreamfirststep,omitempty"`
+	UpstreamStep      string `json:"upstreamstep,omitempty"`
+}
+
+// upstreamSettings are parsed UpstreamDynamicConfig
+type upstreamSettings struct {
+	upstream  net.IP
+	interval  time.Duration
+	filter    string
+	servo     string
+	firstStep time.Duration
+	step      time.Duration
+}
+
+// upstreamDynamicConfig returns the current values of the dynamic config of the boundary clock, empty without one
+func (c *Config) upstreamDynamicConfig() UpstreamDynamicConfig {
+	if !c.HasUpstream() {
+		return UpstreamDynamicConfig{}
+	}
+	return UpstreamDynamicConfig{
+		Upstream:          c.Upstream.String(),
+		UpstreamInterval:  c.UpstreamInterval.String(),
+		UpstreamFilter:    c.UpstreamFilter,
+		UpstreamServo:     c.UpstreamServo,
+		UpstreamFirstStep: c.UpstreamFirstStep.String(),
+		UpstreamStep:      c.UpstreamStep.String(),
+	}
+}
+
+// Apply sets the dynamic config of the boundary clock read at startup in the config, empty upstream disables the boundary clock
+func (uc *UpstreamDynamicConfig) Apply(c *Config) error {
+	if uc.Upstream == "" {
+		c.Upstream = nil
+		return nil
+	}
+	st, err := uc.parse()
+	if err != nil {
+		return err
+	}
+	st.apply(c)
+	return nil
+}
+
+// settings validates the dynamic config of the boundary clock, which can't be enabled or disabled by reload
+func (uc *UpstreamDynamicConfig) settings(c *Config) (upstreamSettings, error) {
+	if (uc.Upstream == "") != !c.HasUpstream() {
+		return upstreamSettings{}, fmt.Errorf("boundary clock can't be enabled or disabled without restart")
+	}
+	if uc.Upstream == "" {
+		return upstreamSettings{}, nil
+	}
+	st, err := uc.parse()
+	if err != nil {
+		return st, err
+	}
+	if st.upstream.Equal(c.UpstreamIP) {
+		return st, fmt.Errorf("upstream grandmaster %s is the IP of the upstream port", st.upstream)
+	}
+	return st, nil
+}
+
+// parse validates values of the dynamic config of the boundary clock
+func (uc *UpstreamDynamicConfig) parse() (upstreamSettings, error) {
+	st := upstreamSettings{
+		upstream: net.ParseIP(uc.Upstream),
+		filter:   uc.UpstreamFilter,
+		servo:    uc.UpstreamServo,
+	}
+	if st.upstream == nil {
+		return st, fmt.Errorf("unsupported upstream grandmaster IP %q", uc.Upstream)
+	}
+	for _, d := range []struct {
+		v   string
+		res *time.Duration
+	}{
+		{uc.UpstreamInterval, &st.interval},
+		{uc.UpstreamFirstStep, &st.firstStep},
+		{uc.UpstreamStep, &st.step},
+	} {
+		var err error
+		if *d.res, err = time.ParseDuration(d.v); err != nil {
+			return st, err
+		}
+	}
+	if st.interval <= 0 {
+		return st, fmt.Errorf("unsupported upstream interval %v", st.interval)
+	}
+	if st.firstStep < 0 || st.step < 0 {
+		return st, fmt.Errorf("unsupported upstream step thresholds %v, %v", st.firstStep, st.step)
+	}
+	if _, err := filter.Parse(st.filter); err != nil {
+		return st, fmt.Errorf("unsupported upstream filter: %w", err)
+	}
+	if _, err := newServo(st.servo, st.interval, 0, defaultStepThresholds); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// reloadUpstream applies settings of the boundary clock, to the running upstream port if there is one
+func (s *Server) reloadUpstream(st upstreamSettings) error {
+	if s.upstream == nil {
+		st.apply(s.Config)
+		return nil
+	}
+	return s.upstream.reconfigure(st)
+}
+
+// apply sets the settings in the config
+func (st upstreamSettings) apply(c *Config) {
+	c.Upstream = st.upstream
+	c.UpstreamInterval = st.interval
+	c.UpstreamFilter = st.filter
+	c.UpstreamServo = st.servo
+	c.UpstreamFirstStep = st.firstStep
+	c.UpstreamStep = st.step
+}
+
+// reconfigure switches the port to the settings. Filter samples are dropped if the filter or the grandmaster changes,
+// but the servo keeps the frequency and its lock, so the clock isn't stepped by the first step threshold again
+func (u *upstreamPort) reconfigure(st upstreamSettings) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	c := u.config
+	f, err := filter.Parse(st.filter)
+	if err != nil {
+		return err
+	}
+	steps := (&Config{UpstreamFirstStep: st.firstStep, UpstreamStep: st.step}).upstreamSteps()
+	sv, err := newServo(st.servo, st.interval*time.Duration(f.Decimation()), u.freq, steps)
+	if err != nil {
+		return err
+	}
+	if u.servo.isLocked() {
+		sv.resume(u.freq)
+	}
+	if st.filter == c.UpstreamFilter {
+		f.Restore(u.filter.Samples())
+	}
+	gmChanged := !st.upstream.Equal(c.Upstream)
+	if gmChanged {
+		tlvs := []ptp.TLV{}
+		for _, t := range []ptp.MessageType{ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp} {
+			tlvs = append(tlvs, u.requester.Cancel(t))
+		}
+		if err := u.sendSignaling(tlvs); err != nil {
+			log.Warningf("Failed to cancel transmission of upstream grandmaster %s: %v", c.Upstream, err)
+		}
+		log.Infof("Switching upstream grandmaster from %s to %s", c.Upstream, st.upstream)
+		f.Reset()
+	}
+	renew := gmChanged || st.interval != c.UpstreamInterval
+	st.apply(c)
+	u.servo = sv
+	u.filter = f
+	u.eSA = timestamp.IPToSockaddr(c.Upstream, ptp.PortEvent)
+	u.gSA = timestamp.IPToSockaddr(c.Upstream, ptp.PortGeneral)
+	if renew {
+		// transmission is requested again at the new interval or from the new grandmaster
+		interval, _ := ptp.NewLogInterval(c.UpstreamInterval)
+		for _, t := range []ptp.MessageType{ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp} {
+			u.requester.Add(t, interval)
+		}
+	}
+	if gmChanged {
+		// exchanges in flight are with the old grandmaster
+		u.syncRX = time.Time{}
+		u.delayReqSent = time.Time{}
+		u.haveDelay = false
+		u.parent = nil
+		c.setParent(nil)
+	}
+	log.Infof("Reconfigured boundary clock: upstream %s, interval %v, filter %q, servo %q", c.Upstream, c.UpstreamInterval, c.UpstreamFilter, c.UpstreamServo)
+	return nil
+}
+
+// fromUpstream checks if the message from the ip is from the upstream grandmaster
+func (u *upstreamPort) fromUpstream(ip net.IP) bool {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return ip.Equal(u.config.Upstream)
+}
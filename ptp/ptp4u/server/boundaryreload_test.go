@@ -0,0 +1,164 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamDynamicConfigSettings(t *testing.T) {
+	c := &Config{Upstream: net.ParseIP("192.168.0.1"), UpstreamIP: net.ParseIP("192.168.0.2"), UpstreamInterval: time.Second}
+	valid := UpstreamDynamicConfig{
+		Upstream:          "192.168.0.3",
+		UpstreamInterval:  "500ms",
+		UpstreamFilter:    "median:5",
+		UpstreamServo:     ServoLinreg,
+		UpstreamFirstStep: "20µs",
+		UpstreamStep:      "1s",
+	}
+	st, err := valid.settings(c)
+	require.NoError(t, err)
+	require.Equal(t, upstreamSettings{
+		upstream:  net.ParseIP("192.168.0.3"),
+		interval:  500 * time.Millisecond,
+		filter:    "median:5",
+		servo:     ServoLinreg,
+		firstStep: 20 * time.Microsecond,
+		step:      time.Second,
+	}, st)
+
+	invalid := []func(uc *UpstreamDynamicConfig){
+		func(uc *UpstreamDynamicConfig) { uc.Upstream = "" },
+		func(uc *UpstreamDynamicConfig) { uc.Upstream = "gm" },
+		func(uc *UpstreamDynamicConfig) { uc.Upstream = "192.168.0.2" },
+		func(uc *UpstreamDynamicConfig) { uc.UpstreamInterval = "0s" },
+		func(uc *UpstreamDynamicConfig) { uc.UpstreamInterval = "1" },
+		func(uc *UpstreamDynamicConfig) { uc.UpstreamFilter = "mean:5" },
+		func(uc *UpstreamDynamicConfig) { uc.UpstreamServo = "pid" },
+		func(uc *UpstreamDynamicConfig) { uc.UpstreamStep = "-1s" },
+	}
+	for i, f := range invalid {
+		uc := valid
+		f(&uc)
+		_, err := uc.settings(c)
+		require.Error(t, err, "case %d", i)
+	}
+
+	// boundary clock can't be enabled by reload
+	_, err = valid.settings(&Config{})
+	require.Error(t, err)
+
+	// but may be at startup, and config round trips
+	started := &Config{}
+	require.NoError(t, valid.Apply(started))
+	require.Equal(t, valid, started.upstreamDynamicConfig())
+	require.NoError(t, (&UpstreamDynamicConfig{}).Apply(started))
+	require.False(t, started.HasUpstream())
+}
+
+func TestUpstreamPortReconfigure(t *testing.T) {
+	var delayReqSent time.Time
+	u, clock, general := newTestUpstreamPort(t, &delayReqSent)
+	u.servo.resume(-5000)
+	u.freq = -5000
+	u.haveDelay = true
+	u.parent = &upstreamParent{}
+	u.config.setParent(u.parent)
+
+	// servo is replaced without losing its frequency and lock, grants are renewed at the new interval
+	st := upstreamSettings{upstream: u.config.Upstream, interval: 250 * time.Millisecond, servo: ServoLinreg}
+	require.NoError(t, u.reconfigure(st))
+	_, ok := u.servo.(*linregServo)
+	require.True(t, ok)
+	require.True(t, u.servo.isLocked())
+	require.Equal(t, 250*time.Millisecond, u.config.UpstreamInterval)
+	sub, ok := u.requester.Subscription(ptp.MessageSync)
+	require.True(t, ok)
+	require.Equal(t, ptp.LogInterval(-2), sub.Interval)
+	require.True(t, u.haveDelay)
+	require.NotNil(t, u.config.syncedParent())
+	require.Empty(t, *general)
+	require.Empty(t, clock.ppb)
+	require.Empty(t, clock.steps)
+
+	// new grandmaster is measured from scratch, the old one is asked to stop
+	st.upstream = net.ParseIP("192.168.0.3")
+	require.NoError(t, u.reconfigure(st))
+	require.Len(t, *general, 1)
+	sg := &ptp.Signaling{}
+	require.NoError(t, ptp.FromBytes((*general)[0], sg))
+	require.Len(t, sg.TLVs, 3)
+	_, ok = sg.TLVs[0].(*ptp.CancelUnicastTransmissionTLV)
+	require.True(t, ok)
+	require.False(t, u.haveDelay)
+	require.Nil(t, u.config.syncedParent())
+	require.True(t, u.servo.isLocked())
+	require.False(t, u.fromUpstream(net.ParseIP("192.168.0.1")))
+	require.True(t, u.fromUpstream(net.ParseIP("192.168.0.3")))
+	_, ok = u.requester.Subscription(ptp.MessageAnnounce)
+	require.True(t, ok)
+	require.Empty(t, clock.steps)
+}
+
+func TestReloadUpstream(t *testing.T) {
+	var delayReqSent time.Time
+	u, _, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.config.UpstreamIP = net.ParseIP("192.168.0.2")
+	u.config.Interface = "lo"
+	u.config.IP = net.ParseIP("127.0.0.1")
+	u.config.TimestampType = timestamp.SW
+	u.config.SendWorkers = 1
+	s := &Server{Config: u.config, upstream: u}
+
+	path := filepath.Join(t.TempDir(), "ptp4u.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"upstreamfilter": "lucky:3", "upstreamstep": "10ms"}`), 0644))
+	require.NoError(t, s.ReloadConfig(path))
+	require.Equal(t, "lucky:3", u.config.UpstreamFilter)
+	require.Equal(t, 10*time.Millisecond, u.config.UpstreamStep)
+	require.Equal(t, 3, u.filter.Decimation())
+
+	// invalid config changes nothing
+	require.NoError(t, os.WriteFile(path, []byte(`{"upstreamfilter": "median:5", "upstreaminterval": "-1s"}`), 0644))
+	require.Error(t, s.ReloadConfig(path))
+	require.Equal(t, "lucky:3", u.config.UpstreamFilter)
+	require.NoError(t, os.WriteFile(path, []byte(`{"upstream": ""}`), 0644))
+	require.Error(t, s.ReloadConfig(path))
+	require.True(t, u.config.HasUpstream())
+}
+
+func TestConfigWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ptp4u.json")
+	w := &configWatcher{path: path}
+	require.False(t, w.changed())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{}`), 0644))
+	require.True(t, w.changed())
+	require.False(t, w.changed())
+
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	require.True(t, w.changed())
+	require.NoError(t, os.Remove(path))
+	require.False(t, w.changed())
+}
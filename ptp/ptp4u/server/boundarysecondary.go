@@ -0,0 +1,137 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have secondary PHCs of the boundary clock. PHCs of other interfaces are kept in sync with
+// the clock disciplined to the upstream grandmaster, each by its own servo, so hardware clocks of
+// a multi-NIC host are consistent. Steps of the disciplined clock are applied to them right away.
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/facebook/time/phc"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+)
+
+// secondaryInterval is how often secondary PHCs are compared to the disciplined clock
+const secondaryInterval = time.Second
+
+// secondaryPHC is PHC of another interface following the disciplined clock
+type secondaryPHC struct {
+	mux    sync.Mutex
+	iface  string
+	device string
+	clock  upstreamClock
+	servo  servo
+	// offset measures offset of the PHC from the disciplined clock
+	offset func() (time.Duration, error)
+}
+
+// secondaryOffset returns measurement of offset of PHC device from the disciplined clock,
+// which is primary PHC with hardware timestamps and CLOCK_REALTIME with software ones
+func secondaryOffset(device, primary string, ts timestamp.Timestamp) func() (time.Duration, error) {
+	if ts == timestamp.SW {
+		return func() (time.Duration, error) {
+			r, err := phc.TimeAndOffsetFromDevice(device, phc.MethodIoctlSysOffsetExtended)
+			return -r.Offset, err
+		}
+	}
+	return func() (time.Duration, error) {
+		return phc.OffsetFromDevices(device, primary)
+	}
+}
+
+// newSecondaries returns PHCs of UpstreamSecondaries following the disciplined clock.
+// PHCs shared by interfaces, or with the disciplined clock, are only synced once
+func (c *Config) newSecondaries(primary string, ts timestamp.Timestamp) ([]*secondaryPHC, error) {
+	seen := map[string]bool{primary: true}
+	res := []*secondaryPHC{}
+	for _, iface := range c.UpstreamSecondaries {
+		device, err := phc.DeviceFromIface(iface)
+		if err != nil {
+			return nil, fmt.Errorf("secondary PHC of %s: %w", iface, err)
+		}
+		if seen[device] {
+			log.Warningf("%s of %s is already synced, skipping", device, iface)
+			continue
+		}
+		seen[device] = true
+		freq, err := phc.FrequencyPPBFromDevice(device)
+		if err != nil {
+			return nil, fmt.Errorf("secondary PHC of %s: %w", iface, err)
+		}
+		sv, err := newServo(c.UpstreamServo, secondaryInterval, freq, c.upstreamSteps())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &secondaryPHC{
+			iface:  iface,
+			device: device,
+			clock:  phcClock(device),
+			servo:  sv,
+			offset: secondaryOffset(device, primary, ts),
+		})
+	}
+	return res, nil
+}
+
+// sync disciplines the PHC by its offset from the disciplined clock
+func (p *secondaryPHC) sync(now time.Time) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	offset, err := p.offset()
+	if err != nil {
+		return fmt.Errorf("measuring offset: %w", err)
+	}
+	ppb, step := p.servo.sample(offset, now)
+	log.Debugf("Offset of %s of %s is %v, frequency %.3f ppb", p.device, p.iface, offset, ppb)
+	if step != 0 {
+		log.Infof("Stepping %s of %s by %v", p.device, p.iface, step)
+		if err := p.clock.Step(step); err != nil {
+			return fmt.Errorf("stepping clock: %w", err)
+		}
+	}
+	if err := p.clock.AdjFreqPPB(ppb); err != nil {
+		return fmt.Errorf("adjusting clock frequency: %w", err)
+	}
+	return nil
+}
+
+// follow steps the PHC by the step of the disciplined clock
+func (p *secondaryPHC) follow(step time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if err := p.clock.Step(step); err != nil {
+		log.Errorf("Failed to step %s of %s after the disciplined clock: %v", p.device, p.iface, err)
+	}
+}
+
+// runSecondaries keeps secondary PHCs in sync with the disciplined clock. It never returns
+func runSecondaries(secondaries []*secondaryPHC) {
+	for {
+		<-time.After(secondaryInterval)
+		now := time.Now()
+		for _, p := range secondaries {
+			if err := p.sync(now); err != nil {
+				log.Errorf("Failed to sync %s of %s: %v", p.device, p.iface, err)
+			}
+		}
+	}
+}
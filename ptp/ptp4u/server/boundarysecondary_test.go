@@ -0,0 +1,68 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondaryPHCSync(t *testing.T) {
+	clock := &fakeClock{}
+	// secondary PHC is 1ms ahead and runs 1000ppb fast
+	offset := time.Millisecond
+	p := &secondaryPHC{
+		iface:  "eth1",
+		device: "/dev/ptp1",
+		clock:  clock,
+		servo:  newPIServo(secondaryInterval, 0),
+		offset: func() (time.Duration, error) { return offset, nil },
+	}
+	now := time.Unix(1653574589, 0)
+	require.NoError(t, p.sync(now))
+	require.Equal(t, []time.Duration{-time.Millisecond}, clock.steps)
+	offset = 0
+	for i := 0; i < 30; i++ {
+		now = now.Add(secondaryInterval)
+		require.NoError(t, p.sync(now))
+		offset += time.Duration(1000 + clock.ppb[len(clock.ppb)-1])
+	}
+	require.InDelta(t, -1000, clock.ppb[len(clock.ppb)-1], 10)
+	require.InDelta(t, 0, float64(offset), 10)
+
+	// steps of the disciplined clock are followed right away
+	p.follow(2 * time.Second)
+	require.Equal(t, []time.Duration{-time.Millisecond, 2 * time.Second}, clock.steps)
+}
+
+func TestUpstreamPortStepsSecondaries(t *testing.T) {
+	var delayReqSent time.Time
+	u, _, _ := newTestUpstreamPort(t, &delayReqSent)
+	clock := &fakeClock{}
+	u.secondaries = []*secondaryPHC{{clock: clock, servo: newPIServo(secondaryInterval, 0)}}
+	require.NoError(t, u.discipline(time.Millisecond, time.Unix(1653574589, 0)))
+	require.Equal(t, []time.Duration{-time.Millisecond}, clock.steps)
+}
+
+func TestNewSecondaries(t *testing.T) {
+	c := &Config{UpstreamSecondaries: []string{"nonexistent0"}}
+	_, err := c.newSecondaries("/dev/ptp0", timestamp.HW)
+	require.Error(t, err)
+}
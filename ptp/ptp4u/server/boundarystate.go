@@ -0,0 +1,132 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have persistence of the boundary clock. Frequency last set while synced, the grandmaster and
+// samples of the filter pipeline are periodically saved to the upstream state file. After restart the servo
+// continues locked from them, instead of stepping the clock and searching for its frequency again.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/facebook/time/ptp/filter"
+	log "github.com/sirupsen/logrus"
+)
+
+// upstreamStateMaxAge is how old the saved state may be to be restored
+const upstreamStateMaxAge = time.Hour
+
+// filterStateMaxAge is how old saved filter samples may be, offsets move on faster than frequency
+const filterStateMaxAge = time.Minute
+
+// savedUpstream is the state of the boundary clock as stored in the upstream state file
+type savedUpstream struct {
+	Upstream    string            `json:"upstream"`
+	Grandmaster string            `json:"grandmaster"`
+	Frequency   float64           `json:"frequency"`
+	Filter      string            `json:"filter"`
+	Samples     [][]filter.Sample `json:"samples"`
+	Saved       time.Time         `json:"saved"`
+}
+
+// state returns the state of the port to save, false if the clock is not synced
+func (u *upstreamPort) state(now time.Time) (savedUpstream, bool) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if u.freqSet.IsZero() || !u.holdoverSince.IsZero() {
+		return savedUpstream{}, false
+	}
+	s := savedUpstream{
+		Upstream:  u.config.Upstream.String(),
+		Frequency: u.freq,
+		Filter:    u.config.UpstreamFilter,
+		Samples:   u.filter.Samples(),
+		Saved:     now,
+	}
+	if u.parent != nil {
+		s.Grandmaster = u.parent.dataset.GrandmasterIdentity.String()
+	}
+	return s, true
+}
+
+// saveState atomically writes the state of the port to the upstream state file, unless the clock is not synced
+func (u *upstreamPort) saveState(now time.Time) error {
+	s, ok := u.state(now)
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(u.config.UpstreamStateFile, b)
+}
+
+// restoreState makes the port continue from the state saved in the upstream state file, if there is one
+func (u *upstreamPort) restoreState(now time.Time) error {
+	b, err := os.ReadFile(u.config.UpstreamStateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s := savedUpstream{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", u.config.UpstreamStateFile, err)
+	}
+	return u.restore(s, now)
+}
+
+// restore sets the saved frequency and makes the servo and the filter continue from the saved state
+func (u *upstreamPort) restore(s savedUpstream, now time.Time) error {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if s.Upstream != u.config.Upstream.String() {
+		return fmt.Errorf("saved for upstream grandmaster %s", s.Upstream)
+	}
+	age := now.Sub(s.Saved)
+	if age < 0 || age > upstreamStateMaxAge {
+		return fmt.Errorf("saved at %v", s.Saved)
+	}
+	if err := u.clock.AdjFreqPPB(s.Frequency); err != nil {
+		return fmt.Errorf("adjusting clock frequency: %w", err)
+	}
+	u.servo.resume(s.Frequency)
+	u.freq = s.Frequency
+	if s.Filter == u.config.UpstreamFilter && age <= filterStateMaxAge {
+		u.filter.Restore(s.Samples)
+	}
+	u.restoredGM = s.Grandmaster
+	log.Infof("Restored boundary clock state saved %v ago, frequency %.3f ppb", age, s.Frequency)
+	return nil
+}
+
+// checkRestoredGM drops filter samples of the restored state if the grandmaster behind the upstream address changed
+func (u *upstreamPort) checkRestoredGM() {
+	if u.restoredGM == "" {
+		return
+	}
+	if gm := u.parent.dataset.GrandmasterIdentity.String(); gm != u.restoredGM {
+		log.Warningf("Upstream grandmaster %s changed from %s to %s since the state was saved", u.config.Upstream, u.restoredGM, gm)
+		u.filter.Reset()
+	}
+	u.restoredGM = ""
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebook/time/ptp/bmca"
+	"github.com/facebook/time/ptp/filter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamPortState(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	var delayReqSent time.Time
+	u, _, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.config.UpstreamStateFile = filepath.Join(t.TempDir(), "upstream.json")
+
+	// nothing is saved until synced
+	require.NoError(t, u.saveState(now))
+	require.NoError(t, u.restoreState(now))
+
+	u.freq = -1234.5
+	u.freqSet = now
+	u.parent = &upstreamParent{dataset: bmca.Dataset{GrandmasterIdentity: upstreamGM.ClockIdentity}}
+	require.NoError(t, u.saveState(now))
+
+	restored, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	restored.config.UpstreamStateFile = u.config.UpstreamStateFile
+	require.NoError(t, restored.restoreState(now.Add(time.Minute)))
+	require.Equal(t, []float64{-1234.5}, clock.ppb)
+	require.True(t, restored.servo.isLocked())
+	require.Equal(t, upstreamGM.ClockIdentity.String(), restored.restoredGM)
+
+	// state of other grandmaster or too old one is not restored
+	other, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	other.config.UpstreamStateFile = u.config.UpstreamStateFile
+	other.config.Upstream = net.ParseIP("192.168.0.2")
+	require.Error(t, other.restoreState(now))
+	other.config.Upstream = u.config.Upstream
+	require.Error(t, other.restoreState(now.Add(2*upstreamStateMaxAge)))
+	require.Empty(t, clock.ppb)
+	require.False(t, other.servo.isLocked())
+}
+
+func TestUpstreamPortRestoreFilter(t *testing.T) {
+	now := time.Unix(1653574589, 0)
+	var delayReqSent time.Time
+	u, _, _ := newTestUpstreamPort(t, &delayReqSent)
+	s := savedUpstream{
+		Upstream:    u.config.Upstream.String(),
+		Grandmaster: upstreamGM.ClockIdentity.String(),
+		Filter:      "median:3",
+		Samples:     [][]filter.Sample{{{Offset: 10}, {Offset: 20}}},
+		Saved:       now,
+	}
+
+	// samples of other pipeline are not restored
+	require.NoError(t, u.restore(s, now))
+	require.Empty(t, u.filter.Samples())
+
+	var err error
+	u.config.UpstreamFilter = "median:3"
+	u.filter, err = filter.Parse(u.config.UpstreamFilter)
+	require.NoError(t, err)
+	require.NoError(t, u.restore(s, now.Add(2*filterStateMaxAge)))
+	require.Empty(t, u.filter.Samples()[0])
+	require.NoError(t, u.restore(s, now))
+	require.Len(t, u.filter.Samples()[0], 2)
+
+	// samples are dropped if there is another grandmaster behind the address
+	u.parent = &upstreamParent{dataset: bmca.Dataset{GrandmasterIdentity: 42}}
+	u.checkRestoredGM()
+	require.Empty(t, u.filter.Samples()[0])
+	require.Empty(t, u.restoredGM)
+}
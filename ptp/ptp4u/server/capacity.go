@@ -0,0 +1,162 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have subscriber capacity limits. Once the server has MaxSubscribers granted subscriptions
+// new subscribers are either rejected or make room by evicting the subscription closest to its expiration.
+// Announce subscriptions are cheap, so monitoring clients requesting only them are not limited.
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// EvictionPolicy is what happens to new subscribers when the server is at capacity
+type EvictionPolicy int
+
+// Eviction policies
+const (
+	// EvictNone rejects new subscribers
+	EvictNone EvictionPolicy = iota
+	// EvictShortest cancels the subscription with the shortest remaining grant
+	EvictShortest
+)
+
+// EvictionPolicyToString is a map from EvictionPolicy to the string
+var EvictionPolicyToString = map[EvictionPolicy]string{
+	EvictNone:     "reject",
+	EvictShortest: "shortest",
+}
+
+// String returns EvictionPolicy as a string
+func (p EvictionPolicy) String() string {
+	s, found := EvictionPolicyToString[p]
+	if !found {
+		return "unsupported"
+	}
+	return s
+}
+
+// Set implements flag.Value
+func (p *EvictionPolicy) Set(value string) error {
+	for k, v := range EvictionPolicyToString {
+		if v == value {
+			*p = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown eviction policy %q", value)
+}
+
+// Type implements pflag.Value
+func (p *EvictionPolicy) Type() string {
+	return "evictionpolicy"
+}
+
+// consumesCapacity checks if the subscription counts towards MaxSubscribers
+func (sc *SubscriptionClient) consumesCapacity() bool {
+	return sc.granted && sc.subscriptionType != ptp.MessageAnnounce
+}
+
+// subscriberCount returns number of granted Sync and Delay_Resp subscriptions of all workers
+func (s *Server) subscriberCount() int {
+	n := int64(0)
+	for _, w := range s.workers() {
+		n += atomic.LoadInt64(&w.eventGrants)
+	}
+	return int(n)
+}
+
+// hasCapacity reports if a new subscriber fits into MaxSubscribers without evicting anyone
+func (s *Server) hasCapacity(st ptp.MessageType) bool {
+	return st == ptp.MessageAnnounce || s.Config.MaxSubscribers <= 0 || s.subscriberCount() < s.Config.MaxSubscribers
+}
+
+// admitSubscriber reports if a new subscriber fits into MaxSubscribers, evicting another one if the policy allows
+func (s *Server) admitSubscriber(st ptp.MessageType) bool {
+	if s.hasCapacity(st) {
+		return true
+	}
+	if s.Config.Eviction == EvictShortest && s.evictShortest() {
+		return true
+	}
+	s.serverStats().IncCapacityRejected(st)
+	return false
+}
+
+// shortestGrant returns running granted Sync or Delay_Resp subscription of the worker expiring first
+func (s *sendWorker) shortestGrant() (ptp.PortIdentity, *SubscriptionClient) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var clientID ptp.PortIdentity
+	var shortest *SubscriptionClient
+	var expire time.Time
+	for _, subs := range s.clients {
+		for k, sc := range subs {
+			if !sc.consumesCapacity() || !sc.Running() {
+				continue
+			}
+			if e := sc.Expire(); shortest == nil || e.Before(expire) {
+				clientID, shortest, expire = k, sc, e
+			}
+		}
+	}
+	return clientID, shortest
+}
+
+// unregister removes the subscription from the worker. It reports false if it was already gone.
+func (s *sendWorker) unregister(clientID ptp.PortIdentity, sc *SubscriptionClient) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	subs := s.clients[sc.subscriptionType]
+	if subs[clientID] != sc {
+		return false
+	}
+	delete(subs, clientID)
+	if sc.consumesCapacity() {
+		atomic.AddInt64(&s.eventGrants, -1)
+	}
+	if sc.granted {
+		s.stats.IncGrantEnded(sc.subscriptionType)
+	}
+	return true
+}
+
+// evictShortest cancels the subscription with the shortest remaining grant across all workers
+func (s *Server) evictShortest() bool {
+	var worker *sendWorker
+	var clientID ptp.PortIdentity
+	var shortest *SubscriptionClient
+	for _, w := range s.workers() {
+		id, sc := w.shortestGrant()
+		if sc != nil && (shortest == nil || sc.Expire().Before(shortest.Expire())) {
+			worker, clientID, shortest = w, id, sc
+		}
+	}
+	if shortest == nil || !worker.unregister(clientID, shortest) {
+		return false
+	}
+	shortest.log(log.InfoLevel, "Evicting subscription to make room for a new subscriber")
+	s.sendCancel(shortest, clientID)
+	shortest.Stop()
+	s.serverStats().IncCapacityEvicted(shortest.subscriptionType)
+	return true
+}
@@ -0,0 +1,150 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/ptp/ptp4u/stats"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvictionPolicy(t *testing.T) {
+	var p EvictionPolicy
+	require.Equal(t, EvictNone, p)
+	require.Equal(t, "reject", p.String())
+	require.NoError(t, p.Set("shortest"))
+	require.Equal(t, EvictShortest, p)
+	require.Equal(t, "shortest", p.String())
+	require.Error(t, p.Set("lol"))
+	require.Equal(t, "unsupported", EvictionPolicy(42).String())
+}
+
+func capacityTestServer(max int, policy EvictionPolicy) (*Server, *sendWorker) {
+	c := &Config{
+		clockIdentity:  ptp.ClockIdentity(1234),
+		SendWorkers:    1,
+		MaxSubscribers: max,
+		Eviction:       policy,
+	}
+	st := stats.NewJSONStats()
+	w := NewSendWorker(0, c, st)
+	return &Server{Config: c, Stats: st, sw: []*sendWorker{w}}, w
+}
+
+func grantedSubscription(w *sendWorker, c *Config, clientID ptp.PortIdentity, expire time.Time) *SubscriptionClient {
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageSync, c, time.Second, expire)
+	sc.granted = true
+	sc.setRunning(true)
+	w.RegisterSubscription(clientID, ptp.MessageSync, sc)
+	return sc
+}
+
+func TestWorkerGrantsCount(t *testing.T) {
+	s, w := capacityTestServer(0, EvictNone)
+	clientID := ptp.PortIdentity{ClockIdentity: 1, PortNumber: 1}
+	sc := grantedSubscription(w, s.Config, clientID, time.Now().Add(time.Minute))
+	require.Equal(t, 1, s.subscriberCount())
+	// replacing the subscription doesn't count twice
+	grantedSubscription(w, s.Config, clientID, time.Now().Add(time.Minute))
+	require.Equal(t, 1, s.subscriberCount())
+
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	w.RegisterSubscription(clientID, ptp.MessageAnnounce, NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, s.Config, time.Second, time.Now()))
+	require.Equal(t, 1, s.subscriberCount())
+
+	require.NotEqual(t, sc, w.FindSubscription(clientID, ptp.MessageSync))
+	w.FindSubscription(clientID, ptp.MessageSync).setRunning(false)
+	w.inventoryClients()
+	require.Equal(t, 0, s.subscriberCount())
+}
+
+func TestAdmitSubscriberReject(t *testing.T) {
+	s, w := capacityTestServer(1, EvictNone)
+	require.True(t, s.admitSubscriber(ptp.MessageSync))
+	grantedSubscription(w, s.Config, ptp.PortIdentity{ClockIdentity: 1}, time.Now().Add(time.Minute))
+	require.False(t, s.admitSubscriber(ptp.MessageSync))
+	require.Equal(t, 1, s.subscriberCount())
+
+	// unlimited
+	s.Config.MaxSubscribers = 0
+	require.True(t, s.admitSubscriber(ptp.MessageSync))
+}
+
+func TestAdmitSubscriberEvictShortest(t *testing.T) {
+	s, w := capacityTestServer(2, EvictShortest)
+	now := time.Now()
+	long := grantedSubscription(w, s.Config, ptp.PortIdentity{ClockIdentity: 1}, now.Add(time.Hour))
+	short := grantedSubscription(w, s.Config, ptp.PortIdentity{ClockIdentity: 2}, now.Add(time.Minute))
+
+	require.True(t, s.admitSubscriber(ptp.MessageSync))
+	require.Equal(t, 1, s.subscriberCount())
+	require.Nil(t, w.FindSubscription(ptp.PortIdentity{ClockIdentity: 2}, ptp.MessageSync))
+	require.Equal(t, long, w.FindSubscription(ptp.PortIdentity{ClockIdentity: 1}, ptp.MessageSync))
+	require.True(t, short.Expired())
+	require.False(t, long.Expired())
+
+	require.True(t, s.evictShortest())
+	require.True(t, long.Expired())
+	// nothing left to evict
+	require.False(t, s.evictShortest())
+	require.Equal(t, 0, s.subscriberCount())
+}
+
+// monitoringStats counts monitoring subscriptions reported by the worker
+type monitoringStats struct {
+	*stats.JSONStats
+	monitoring int
+}
+
+func (s *monitoringStats) IncMonitoringSubs() {
+	s.monitoring++
+}
+
+func TestAnnounceOnlySubscribers(t *testing.T) {
+	s, w := capacityTestServer(1, EvictNone)
+	st := &monitoringStats{JSONStats: stats.NewJSONStats()}
+	w.stats = st
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	announce := func(clientID ptp.PortIdentity) {
+		sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, s.Config, time.Second, time.Now().Add(time.Minute))
+		sc.granted = true
+		sc.setRunning(true)
+		w.RegisterSubscription(clientID, ptp.MessageAnnounce, sc)
+	}
+
+	// Announce doesn't consume capacity
+	for i := 1; i <= 3; i++ {
+		require.True(t, s.admitSubscriber(ptp.MessageAnnounce))
+		announce(ptp.PortIdentity{ClockIdentity: ptp.ClockIdentity(i)})
+	}
+	require.Equal(t, 0, s.subscriberCount())
+	require.True(t, s.admitSubscriber(ptp.MessageSync))
+	grantedSubscription(w, s.Config, ptp.PortIdentity{ClockIdentity: 1}, time.Now().Add(time.Minute))
+	require.Equal(t, 1, s.subscriberCount())
+	require.False(t, s.admitSubscriber(ptp.MessageSync))
+	require.True(t, s.admitSubscriber(ptp.MessageAnnounce))
+
+	// client 1 gets time from us, 2 and 3 only monitor
+	w.inventoryClients()
+	require.Equal(t, 2, st.monitoring)
+}
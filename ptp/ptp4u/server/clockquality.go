@@ -0,0 +1,123 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/facebook/time/oscillatord"
+	ptp "github.com/facebook/time/ptp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// Clock qualities announced depending on the state of the time reference, clock classes as per IEEE 1588-2019 Table 4
+var (
+	// HoldoverClockQuality is announced after the reference was lost, while the clock is within holdover specification
+	HoldoverClockQuality = ptp.ClockQuality{
+		ClockClass:              7,
+		ClockAccuracy:           35, // 0x23 - Time Accurate within 1us
+		OffsetScaledLogVariance: 23008,
+	}
+	// DegradedClockQuality is announced when the clock is out of holdover specification (degradation alternative A)
+	DegradedClockQuality = ptp.ClockQuality{
+		ClockClass:              52,
+		ClockAccuracy:           254, // 0xFE - Unknown
+		OffsetScaledLogVariance: 65535,
+	}
+)
+
+// ClockQualitySource provides quality of the clock the server distributes time from
+type ClockQualitySource interface {
+	ClockQuality() (*ptp.ClockQuality, error)
+}
+
+// OscillatordSource derives clock quality from the status reported by oscillatord
+type OscillatordSource struct {
+	Address string
+	Timeout time.Duration
+	// HoldoverSpec is how long the clock stays within holdover specification after it lost the lock
+	HoldoverSpec time.Duration
+
+	lastLocked time.Time
+}
+
+// NewOscillatordSource creates OscillatordSource talking to oscillatord monitoring port at the address
+func NewOscillatordSource(address string, holdoverSpec time.Duration) *OscillatordSource {
+	return &OscillatordSource{
+		Address:      address,
+		Timeout:      time.Second,
+		HoldoverSpec: holdoverSpec,
+	}
+}
+
+// ClockQuality reads oscillatord status and returns clock quality matching it
+func (o *OscillatordSource) ClockQuality() (*ptp.ClockQuality, error) {
+	conn, err := net.DialTimeout("tcp", o.Address, o.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to oscillatord: %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(o.Timeout)); err != nil {
+		return nil, fmt.Errorf("setting connection deadline: %w", err)
+	}
+	status, err := oscillatord.ReadStatus(conn)
+	if err != nil {
+		return nil, err
+	}
+	return o.clockQuality(status, time.Now()), nil
+}
+
+// clockQuality maps oscillatord status to the clock quality, tracking holdover
+func (o *OscillatordSource) clockQuality(status *oscillatord.Status, now time.Time) *ptp.ClockQuality {
+	q := DegradedClockQuality
+	switch {
+	case status.Oscillator.Lock && status.GNSS.FixOK:
+		o.lastLocked = now
+		q = DefaultClockQuality
+	case !o.lastLocked.IsZero() && now.Sub(o.lastLocked) < o.HoldoverSpec:
+		q = HoldoverClockQuality
+	}
+	return &q
+}
+
+// setClockQuality atomically sets clock quality of the main domain
+func (c *Config) setClockQuality(q ptp.ClockQuality) {
+	c.clockQualityMux.Lock()
+	defer c.clockQualityMux.Unlock()
+	c.ClockQuality = q
+}
+
+// updateClockQuality periodically refreshes announced clock quality from ClockQualitySource.
+// Last known quality is kept if the source fails.
+func (s *Server) updateClockQuality() {
+	interval := s.Config.ClockQualityInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for {
+		q, err := s.Config.ClockQualitySource.ClockQuality()
+		if err != nil {
+			log.Errorf("Failed to get clock quality: %v. Keeping the last known: %+v", err, s.Config.clockQuality())
+		} else if *q != s.Config.clockQuality() {
+			log.Warningf("Clock quality changed from %+v to %+v", s.Config.clockQuality(), *q)
+			s.Config.setClockQuality(*q)
+		}
+		<-time.After(interval)
+	}
+}
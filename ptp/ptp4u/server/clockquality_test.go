@@ -0,0 +1,92 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebook/time/oscillatord"
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOscillatordSourceHoldover(t *testing.T) {
+	o := NewOscillatordSource("", time.Hour)
+	locked := &oscillatord.Status{
+		Oscillator: oscillatord.Oscillator{Lock: true},
+		GNSS:       oscillatord.GNSS{FixOK: true},
+	}
+	unlocked := &oscillatord.Status{
+		Oscillator: oscillatord.Oscillator{Lock: true},
+		GNSS:       oscillatord.GNSS{FixOK: false},
+	}
+	now := time.Now()
+
+	// never locked
+	require.Equal(t, DegradedClockQuality, *o.clockQuality(unlocked, now))
+	require.Equal(t, DefaultClockQuality, *o.clockQuality(locked, now))
+	require.Equal(t, HoldoverClockQuality, *o.clockQuality(unlocked, now.Add(time.Minute)))
+	require.Equal(t, DegradedClockQuality, *o.clockQuality(unlocked, now.Add(2*time.Hour)))
+	// lock is back
+	require.Equal(t, DefaultClockQuality, *o.clockQuality(locked, now.Add(3*time.Hour)))
+}
+
+func TestOscillatordSourceClockQuality(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 1)
+		if _, err := conn.Read(b); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(`{"oscillator": {"lock": true}, "gnss": {"fixOk": true}}`))
+	}()
+
+	o := NewOscillatordSource(ln.Addr().String(), time.Hour)
+	q, err := o.ClockQuality()
+	require.NoError(t, err)
+	require.Equal(t, DefaultClockQuality, *q)
+
+	ln.Close()
+	_, err = o.ClockQuality()
+	require.Error(t, err)
+}
+
+func TestAnnounceFollowsClockQuality(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), Domains: []DomainConfig{{DomainNumber: 24, ClockQuality: DefaultClockQuality}}}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Time{})
+	extra := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Time{})
+	extra.setDomain(c.Domains[0])
+
+	c.setClockQuality(HoldoverClockQuality)
+	sc.UpdateAnnounce()
+	extra.UpdateAnnounce()
+	require.Equal(t, HoldoverClockQuality, sc.Announce().GrandmasterClockQuality)
+	require.Equal(t, DefaultClockQuality, extra.Announce().GrandmasterClockQuality)
+}
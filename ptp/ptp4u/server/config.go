@@ -19,16 +19,22 @@ package server
 import (
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/facebook/time/ntp/shm"
 	"github.com/facebook/time/phc"
+	"github.com/facebook/time/ptp/profile"
 	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
 )
 
 // Config is a server config structure
 type Config struct {
+	BusyPoll       time.Duration
+	BusyPollBudget int
 	DSCP           int
+	DomainNumber   uint8
 	Interface      string
 	IP             net.IP
 	LogLevel       string
@@ -36,12 +42,156 @@ type Config struct {
 	MetricInterval time.Duration
 	MinSubInterval time.Duration
 	MonitoringPort int
+	Profile        *profile.Profile
 	SHM            bool
-	TimestampType  string
+	TimestampType  timestamp.Timestamp
 	UTCOffset      time.Duration
 	SendWorkers    int
 	RecvWorkers    int
+	ReusePort      bool
 	QueueSize      int
+	Version        uint8
+
+	// IOURing reads event messages with io_uring if the kernel supports it
+	IOURing bool
+
+	// GeneralDSCP is DSCP of general messages (Announce, Follow_Up, Signaling), negative uses DSCP of event messages
+	GeneralDSCP int
+
+	// SecondaryIP of the other address family is served alongside IP if set
+	SecondaryIP net.IP
+
+	// MulticastAnnounceInterval and MulticastSyncInterval enable multicast mode, zero disables it
+	MulticastAnnounceInterval time.Duration
+	MulticastSyncInterval     time.Duration
+	// MulticastHybrid makes Delay_Resp to multicast clients go via unicast
+	MulticastHybrid bool
+
+	// ACL restricts which clients may obtain unicast grants, nil allows everyone
+	ACL *ACL
+	// Policies clamp grants of groups of clients, nil grants what's requested within the limits above
+	Policies *Policies
+
+	// InteropDomains are served as a plain IEEE 1588 unicast master, see interop.go
+	InteropDomains []uint8
+
+	// ClockQuality announced in DomainNumber, zero value means DefaultClockQuality
+	ClockQuality ptp.ClockQuality
+	// Domains are served in addition to DomainNumber
+	Domains []DomainConfig
+
+	// OneStep makes NIC insert TX timestamps into Sync packets so no Follow Up are sent, if the NIC supports it
+	OneStep bool
+	// PeerDelay makes the server answer Pdelay_Req
+	PeerDelay bool
+
+	// ClockQualitySource updates ClockQuality every ClockQualityInterval if set
+	ClockQualitySource   ClockQualitySource
+	ClockQualityInterval time.Duration
+
+	clockQualityMux sync.RWMutex
+
+	// LeapSecondsFile is leap-seconds.list or time zone file UTCOffset and leap flags follow, SHM is ignored if set
+	LeapSecondsFile string
+
+	// LeapSmear spreads leap seconds from LeapSecondsFile over LeapSmearWindow centered at the event.
+	// Leap seconds are not announced, UTC offset changes at the end of the window
+	LeapSmear       SmearMode
+	LeapSmearWindow time.Duration
+
+	leapMux    sync.RWMutex
+	leapFlags  uint16
+	smearEvent time.Time
+	smearDelta time.Duration
+	// smearUTCOffset is UTC offset in seconds before the leap second of the upstream grandmaster being smeared
+	smearUTCOffset int16
+
+	// AdminSocket is a unix socket path to serve the admin API on, empty disables it
+	AdminSocket string
+
+	// StateFile keeps granted subscriptions across restarts, saved every StateInterval. Empty disables it
+	StateFile     string
+	StateInterval time.Duration
+
+	// MaxSubscribers limits number of granted subscriptions, 0 is unlimited.
+	// Eviction defines what happens to new subscribers once the limit is reached
+	MaxSubscribers int
+	Eviction       EvictionPolicy
+
+	// TXTSFallbackFailures is how many hardware TX timestamps missing in a row make the server fall back to software ones, 0 disables it.
+	// Hardware timestamps are retried every TXTSRetryInterval
+	TXTSFallbackFailures int
+	TXTSRetryInterval    time.Duration
+
+	txtsFailures int64
+	tsDegraded   int32
+
+	// Upstream is the grandmaster PHC of Interface is synced from in boundary clock mode, nil disables it.
+	// The server talks to it from UpstreamIP requesting messages every UpstreamInterval
+	Upstream         net.IP
+	UpstreamIP       net.IP
+	UpstreamInterval time.Duration
+	// UpstreamFilter is the filter pipeline applied to offsets from the upstream grandmaster before the servo, see filter.Parse
+	UpstreamFilter string
+	// UpstreamServo is the servo backend disciplining PHC, ServoPI or ServoLinreg
+	UpstreamServo string
+	// UpstreamFirstStep and UpstreamStep are offsets from the upstream grandmaster above which PHC is stepped,
+	// until the servo is locked and once it is. Zero uses the defaults of linuxptp, 20us and 1s
+	UpstreamFirstStep time.Duration
+	UpstreamStep      time.Duration
+	// UpstreamPanic is the offset from the upstream grandmaster above which PHC is left alone unless OnUpstreamPanic
+	// accepts it, zero disables the check
+	UpstreamPanic time.Duration
+	// OnUpstreamStep is called after PHC was stepped to the upstream grandmaster
+	OnUpstreamStep func(step time.Duration)
+	// OnUpstreamPanic is called with the offset above UpstreamPanic, PHC is disciplined by it if it returns true.
+	// It may as well exit
+	OnUpstreamPanic func(offset time.Duration) bool
+	// UpstreamAsymmetry is the static delay asymmetry of the path to the upstream grandmaster subtracted from offsets,
+	// positive when the path from the grandmaster is longer than the path to it
+	UpstreamAsymmetry time.Duration
+	// UpstreamMonitor only measures offset from the upstream grandmaster, PHC is not disciplined and its data set is not announced
+	UpstreamMonitor bool
+	// UpstreamLeap is how leap seconds announced by the upstream grandmaster are handled
+	UpstreamLeap UpstreamLeapMode
+	// UpstreamSecondaries are interfaces whose PHCs are kept in sync with the clock disciplined to the upstream grandmaster
+	UpstreamSecondaries []string
+	// UpstreamStateFile keeps frequency, grandmaster and filter samples of the boundary clock across restarts,
+	// saved every StateInterval. Empty disables it
+	UpstreamStateFile string
+	// UpstreamNTP are NTP servers time of the boundary clock is cross-checked against every UpstreamNTPInterval, empty disables it.
+	// Corrections which leave the clock further than UpstreamNTPThreshold from NTP time are alerted on, and refused with UpstreamNTPRefuse
+	UpstreamNTP          []string
+	UpstreamNTPInterval  time.Duration
+	UpstreamNTPThreshold time.Duration
+	UpstreamNTPRefuse    bool
+
+	upstreamOffset   int64
+	upstreamDelay    int64
+	upstreamMeasured int32
+	upstreamSWTS     int32
+	upstreamNTP      int64
+	upstreamNTPState int32
+
+	// Holdover is for how long the boundary clock announces holdover after losing the upstream grandmaster,
+	// before it's announced as free running. Clock is disciplined by the drift model either way
+	Holdover time.Duration
+
+	parentMux sync.RWMutex
+	parent    *upstreamParent
+	holdover  holdoverState
+
+	// LogRateLimit is how many lines of each level about subscribers are logged per second, 0 is unlimited
+	LogRateLimit int
+	logLimit     logLimiter
+
+	// MaxSendWorkers enables resizing SendWorkers between MinSendWorkers and MaxSendWorkers every AutoscaleInterval, 0 disables it.
+	// Workers are added once queue depth exceeds AutoscaleQueue or reading TX timestamp takes longer than AutoscaleTXTSLatency
+	MinSendWorkers       int
+	MaxSendWorkers       int
+	AutoscaleInterval    time.Duration
+	AutoscaleQueue       int
+	AutoscaleTXTSLatency time.Duration
 
 	clockIdentity ptp.ClockIdentity
 }
@@ -71,20 +221,54 @@ func (c *Config) SetUTCOffsetFromSHM() error {
 	return nil
 }
 
-// IfaceHasIP checks if selected IP is on interface
+// generalDSCP returns DSCP of general messages
+func (c *Config) generalDSCP() int {
+	if c.GeneralDSCP < 0 {
+		return c.DSCP
+	}
+	return c.GeneralDSCP
+}
+
+// ptpVersion returns versionPTP header value the server emits, defaulting to ptp.Version
+func (c *Config) ptpVersion() uint8 {
+	if c.Version == 0 {
+		return ptp.Version
+	}
+	return c.Version
+}
+
+// subscriptionAllowed checks if subscription for messages of the type with given interval and duration is within limits
+func (c *Config) subscriptionAllowed(msgType ptp.MessageType, interval ptp.LogInterval, duration time.Duration) bool {
+	if interval.Duration() < c.MinSubInterval || duration > c.MaxSubDuration {
+		return false
+	}
+	if c.Profile == nil {
+		return true
+	}
+	return c.Profile.ValidInterval(msgType, interval) && c.Profile.ValidGrantDuration(duration)
+}
+
+// IfaceHasIP checks if selected IPs are on interface
 func (c *Config) IfaceHasIP() (bool, error) {
 	ips, err := ifaceIPs(c.Interface)
 	if err != nil {
 		return false, err
 	}
 
-	for _, ip := range ips {
-		if c.IP.Equal(ip) {
-			return true, nil
+	for _, served := range c.ips() {
+		found := false
+		for _, ip := range ips {
+			if served.Equal(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
 		}
 	}
 
-	return false, nil
+	return true, nil
 }
 
 // ifaceIPs gets all IPs on the specified interface
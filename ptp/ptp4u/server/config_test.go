@@ -21,6 +21,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/facebook/time/ptp/profile"
+	ptp "github.com/facebook/time/ptp/protocol"
 	"github.com/stretchr/testify/require"
 )
 
@@ -69,3 +71,35 @@ func TestConfigSetUTCOffsetFromSHM(t *testing.T) {
 	require.NotNil(t, err)
 	require.Equal(t, utcoffset, c.UTCOffset)
 }
+
+func TestConfigSubscriptionAllowed(t *testing.T) {
+	c := Config{MinSubInterval: 125 * time.Millisecond, MaxSubDuration: time.Hour}
+	require.True(t, c.subscriptionAllowed(ptp.MessageSync, -3, time.Hour))
+	require.False(t, c.subscriptionAllowed(ptp.MessageSync, -4, time.Hour))
+	require.False(t, c.subscriptionAllowed(ptp.MessageSync, 0, 2*time.Hour))
+
+	c.Profile = profile.G8275_2
+	require.True(t, c.subscriptionAllowed(ptp.MessageSync, -3, 300*time.Second))
+	require.False(t, c.subscriptionAllowed(ptp.MessageAnnounce, 1, 300*time.Second))
+	require.False(t, c.subscriptionAllowed(ptp.MessageSync, -3, time.Hour))
+}
+
+func TestConfigPTPVersion(t *testing.T) {
+	c := Config{}
+	require.Equal(t, ptp.Version, c.ptpVersion())
+	c.Version = ptp.MajorVersion
+	require.Equal(t, ptp.MajorVersion, c.ptpVersion())
+}
+
+func TestConfigGeneralDSCP(t *testing.T) {
+	c := &Config{DSCP: 46, GeneralDSCP: -1}
+	require.Equal(t, 46, c.generalDSCP())
+	c.GeneralDSCP = 0
+	require.Equal(t, 0, c.generalDSCP())
+	c.GeneralDSCP = 8
+	require.Equal(t, 8, c.generalDSCP())
+
+	w := NewSendWorker(0, c, nil)
+	require.Equal(t, 46, w.eventDSCP)
+	require.Equal(t, 8, w.generalDSCP)
+}
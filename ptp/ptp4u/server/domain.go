@@ -0,0 +1,154 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// DefaultClockQuality is announced unless configured otherwise
+var DefaultClockQuality = ptp.ClockQuality{
+	ClockClass:              6,
+	ClockAccuracy:           33, // 0x21 - Time Accurate within 100ns
+	OffsetScaledLogVariance: 23008,
+}
+
+// DomainConfig is a PTP domain served in addition to the main one,
+// with its own clock quality and subscriptions
+type DomainConfig struct {
+	DomainNumber uint8
+	ClockQuality ptp.ClockQuality
+}
+
+// ParseDomains parses comma separated list of domains in the form of
+// domain[:clockClass[:clockAccuracy[:offsetScaledLogVariance]]].
+// Omitted clock quality values are taken from DefaultClockQuality.
+func ParseDomains(s string) ([]DomainConfig, error) {
+	res := []DomainConfig{}
+	if s == "" {
+		return res, nil
+	}
+	seen := map[uint8]bool{}
+	for _, d := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(d), ":")
+		if len(parts) > 4 {
+			return nil, fmt.Errorf("too many fields in domain %q", d)
+		}
+		values := []uint64{0, uint64(DefaultClockQuality.ClockClass), uint64(DefaultClockQuality.ClockAccuracy), uint64(DefaultClockQuality.OffsetScaledLogVariance)}
+		bits := []int{8, 8, 8, 16}
+		for i, p := range parts {
+			v, err := strconv.ParseUint(p, 10, bits[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid domain %q: %w", d, err)
+			}
+			values[i] = v
+		}
+		dc := DomainConfig{
+			DomainNumber: uint8(values[0]),
+			ClockQuality: ptp.ClockQuality{
+				ClockClass:              uint8(values[1]),
+				ClockAccuracy:           uint8(values[2]),
+				OffsetScaledLogVariance: uint16(values[3]),
+			},
+		}
+		if seen[dc.DomainNumber] {
+			return nil, fmt.Errorf("domain %d is listed more than once", dc.DomainNumber)
+		}
+		seen[dc.DomainNumber] = true
+		res = append(res, dc)
+	}
+	return res, nil
+}
+
+// clockQuality returns clock quality of the main domain
+func (c *Config) clockQuality() ptp.ClockQuality {
+	c.clockQualityMux.RLock()
+	defer c.clockQualityMux.RUnlock()
+	if c.ClockQuality == (ptp.ClockQuality{}) {
+		return DefaultClockQuality
+	}
+	return c.ClockQuality
+}
+
+// extraDomain returns config of additional domain with given number
+func (c *Config) extraDomain(domain uint8) (DomainConfig, bool) {
+	for _, d := range c.Domains {
+		if d.DomainNumber == domain {
+			return d, true
+		}
+	}
+	return DomainConfig{}, false
+}
+
+// startDomainWorkers launches separate send workers for each additional domain, so they have own subscriptions
+func (s *Server) startDomainWorkers() {
+	s.domains = make(map[uint8][]*sendWorker, len(s.Config.Domains))
+	for _, d := range s.Config.Domains {
+		workers := make([]*sendWorker, s.Config.SendWorkers)
+		for i := range workers {
+			w := NewSendWorker(len(s.sw), s.Config, s.Stats)
+			workers[i] = w
+			s.sw = append(s.sw, w)
+			go w.Start()
+		}
+		s.domains[d.DomainNumber] = workers
+	}
+}
+
+// findDomainWorker finds worker of the domain the client is in.
+// Clients of domains we don't serve separately are handled by the main domain workers.
+func (s *Server) findDomainWorker(domain uint8, clientID ptp.PortIdentity, r *rand.Rand) *sendWorker {
+	s.workersMux.RLock()
+	defer s.workersMux.RUnlock()
+	return s.findDomainWorkerLocked(domain, clientID, r)
+}
+
+// findDomainWorkerLocked is findDomainWorker for callers holding workersMux
+func (s *Server) findDomainWorkerLocked(domain uint8, clientID ptp.PortIdentity, r *rand.Rand) *sendWorker {
+	workers, ok := s.domains[domain]
+	if !ok {
+		return s.findWorkerLocked(clientID, r)
+	}
+	r.Seed(int64(clientID.ClockIdentity) + int64(clientID.PortNumber))
+	return workers[r.Intn(len(workers))]
+}
+
+// domain returns the domain packets of the subscription belong to
+func (sc *SubscriptionClient) domain() uint8 {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.announceP.DomainNumber
+}
+
+// setDomain makes all packets sent within the subscription belong to the domain
+func (sc *SubscriptionClient) setDomain(d DomainConfig) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.syncP.DomainNumber = d.DomainNumber
+	sc.followupP.DomainNumber = d.DomainNumber
+	sc.announceP.DomainNumber = d.DomainNumber
+	sc.delayRespP.DomainNumber = d.DomainNumber
+	sc.grant.DomainNumber = d.DomainNumber
+	sc.pdelayRespP.DomainNumber = d.DomainNumber
+	sc.pdelayRespFollowUpP.DomainNumber = d.DomainNumber
+	sc.announceP.GrandmasterClockQuality = d.ClockQuality
+}
@@ -0,0 +1,111 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/ptp/ptp4u/stats"
+	"github.com/facebook/time/timestamp"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDomains(t *testing.T) {
+	domains, err := ParseDomains("")
+	require.NoError(t, err)
+	require.Equal(t, []DomainConfig{}, domains)
+
+	domains, err = ParseDomains("24, 25:248,26:7:254:65535")
+	require.NoError(t, err)
+	require.Equal(t, []DomainConfig{
+		{DomainNumber: 24, ClockQuality: DefaultClockQuality},
+		{DomainNumber: 25, ClockQuality: ptp.ClockQuality{ClockClass: 248, ClockAccuracy: 33, OffsetScaledLogVariance: 23008}},
+		{DomainNumber: 26, ClockQuality: ptp.ClockQuality{ClockClass: 7, ClockAccuracy: 254, OffsetScaledLogVariance: 65535}},
+	}, domains)
+
+	_, err = ParseDomains("256")
+	require.Error(t, err)
+	_, err = ParseDomains("1:2:3:4:5")
+	require.Error(t, err)
+	_, err = ParseDomains("1,2,1")
+	require.EqualError(t, err, "domain 1 is listed more than once")
+	_, err = ParseDomains("1:nope")
+	require.Error(t, err)
+}
+
+func TestConfigClockQuality(t *testing.T) {
+	c := &Config{}
+	require.Equal(t, DefaultClockQuality, c.clockQuality())
+	c.ClockQuality = ptp.ClockQuality{ClockClass: 7}
+	require.Equal(t, ptp.ClockQuality{ClockClass: 7}, c.clockQuality())
+
+	c.Domains = []DomainConfig{{DomainNumber: 24}}
+	_, ok := c.extraDomain(0)
+	require.False(t, ok)
+	d, ok := c.extraDomain(24)
+	require.True(t, ok)
+	require.Equal(t, uint8(24), d.DomainNumber)
+}
+
+func TestFindDomainWorker(t *testing.T) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	c := &Config{
+		clockIdentity: ptp.ClockIdentity(1234),
+		TimestampType: timestamp.SW,
+		SendWorkers:   10,
+		Domains:       []DomainConfig{{DomainNumber: 24}},
+	}
+	s := Server{
+		Config: c,
+		Stats:  stats.NewJSONStats(),
+		sw:     make([]*sendWorker, c.SendWorkers),
+		domains: map[uint8][]*sendWorker{
+			24: make([]*sendWorker, c.SendWorkers),
+		},
+	}
+	for i := 0; i < s.Config.SendWorkers; i++ {
+		s.sw[i] = NewSendWorker(i, c, s.Stats)
+		s.domains[24][i] = NewSendWorker(i+c.SendWorkers, c, s.Stats)
+	}
+	clipi := ptp.PortIdentity{PortNumber: 2, ClockIdentity: ptp.ClockIdentity(1234)}
+
+	require.Equal(t, 3, s.findDomainWorker(0, clipi, r).id)
+	require.Equal(t, 3, s.findDomainWorker(42, clipi, r).id)
+	require.Equal(t, 13, s.findDomainWorker(24, clipi, r).id)
+}
+
+func TestSubscriptionSetDomain(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Time{})
+	require.Equal(t, DefaultClockQuality, sc.Announce().GrandmasterClockQuality)
+
+	d := DomainConfig{DomainNumber: 24, ClockQuality: ptp.ClockQuality{ClockClass: 248}}
+	sc.setDomain(d)
+	require.Equal(t, uint8(24), sc.Sync().DomainNumber)
+	require.Equal(t, uint8(24), sc.Followup().DomainNumber)
+	require.Equal(t, uint8(24), sc.Announce().DomainNumber)
+	require.Equal(t, uint8(24), sc.DelayResp().DomainNumber)
+	require.Equal(t, uint8(24), sc.Grant().DomainNumber)
+	require.Equal(t, d.ClockQuality, sc.Announce().GrandmasterClockQuality)
+}
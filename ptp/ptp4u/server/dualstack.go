@@ -0,0 +1,152 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ips returns addresses the server listens on, the primary one goes first
+func (c *Config) ips() []net.IP {
+	if c.SecondaryIP == nil {
+		return []net.IP{c.IP}
+	}
+	return []net.IP{c.IP, c.SecondaryIP}
+}
+
+// servesIPv4 reports if there is an IPv4 socket to reply to IPv4 clients from.
+// Otherwise they are reached via IPv4-mapped IPv6 addresses.
+func (c *Config) servesIPv4() bool {
+	for _, ip := range c.ips() {
+		if ip.To4() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// replySockaddr returns socket address of the client of the family the server has sockets for
+func (c *Config) replySockaddr(ip net.IP, port int) unix.Sockaddr {
+	if ip.To4() != nil && c.servesIPv4() {
+		sa := &unix.SockaddrInet4{Port: port}
+		copy(sa.Addr[:], ip.To4())
+		return sa
+	}
+	sa := &unix.SockaddrInet6{Port: port}
+	copy(sa.Addr[:], ip.To16())
+	return sa
+}
+
+// sockaddrFamily returns address family of the socket address
+func sockaddrFamily(sa unix.Sockaddr) int {
+	if _, ok := sa.(*unix.SockaddrInet4); ok {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// sockaddrPort returns port of the socket address
+func sockaddrPort(sa unix.Sockaddr) int {
+	switch v := sa.(type) {
+	case *unix.SockaddrInet4:
+		return v.Port
+	case *unix.SockaddrInet6:
+		return v.Port
+	}
+	return 0
+}
+
+// ipFamily returns address family of the IP
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// workerSockets are event and general sockets of a worker, a pair per address family served
+type workerSockets struct {
+	event   map[int]int
+	general map[int]int
+}
+
+func newWorkerSockets() *workerSockets {
+	return &workerSockets{event: map[int]int{}, general: map[int]int{}}
+}
+
+// fds returns event and general sockets to send to the client from, -1 if the family is not served
+func (ws *workerSockets) fds(eclisa, gclisa unix.Sockaddr) (int, int) {
+	eFd, ok := ws.event[sockaddrFamily(eclisa)]
+	if !ok {
+		eFd = -1
+	}
+	gFd, ok := ws.general[sockaddrFamily(gclisa)]
+	if !ok {
+		gFd = -1
+	}
+	return eFd, gFd
+}
+
+// close closes all sockets
+func (ws *workerSockets) close() {
+	for _, fd := range ws.event {
+		unix.Close(fd)
+	}
+	for _, fd := range ws.general {
+		unix.Close(fd)
+	}
+}
+
+// generalFd returns general socket to send to the client from, -1 if the family is not served
+func (s *Server) generalFd(sa unix.Sockaddr) int {
+	s.fdsMux.Lock()
+	defer s.fdsMux.Unlock()
+	gFd, ok := s.gFds[sockaddrFamily(sa)]
+	if !ok {
+		return -1
+	}
+	return gFd
+}
+
+// SetIPs sets addresses to bind on from comma separated list.
+// Second address, if any, must be of the other family and is served alongside the first one.
+func (c *Config) SetIPs(addrs string) error {
+	parts := strings.Split(addrs, ",")
+	if len(parts) > 2 {
+		return fmt.Errorf("at most one IPv4 and one IPv6 address can be served, got %q", addrs)
+	}
+	ips := make([]net.IP, 0, len(parts))
+	for _, p := range parts {
+		ip := net.ParseIP(strings.TrimSpace(p))
+		if ip == nil {
+			return fmt.Errorf("invalid IP %q", p)
+		}
+		ips = append(ips, ip)
+	}
+	c.IP, c.SecondaryIP = ips[0], nil
+	if len(ips) == 2 {
+		if ipFamily(ips[0]) == ipFamily(ips[1]) {
+			return fmt.Errorf("IPs %s and %s are of the same address family", ips[0], ips[1])
+		}
+		c.SecondaryIP = ips[1]
+	}
+	return nil
+}
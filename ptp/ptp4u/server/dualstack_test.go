@@ -0,0 +1,92 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestConfigSetIPs(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.SetIPs("::1"))
+	require.Equal(t, net.ParseIP("::1"), c.IP)
+	require.Nil(t, c.SecondaryIP)
+	require.Equal(t, []net.IP{net.ParseIP("::1")}, c.ips())
+
+	require.NoError(t, c.SetIPs("192.168.0.1, 2001:db8::1"))
+	require.Equal(t, net.ParseIP("192.168.0.1"), c.IP)
+	require.Equal(t, net.ParseIP("2001:db8::1"), c.SecondaryIP)
+	require.Equal(t, []net.IP{c.IP, c.SecondaryIP}, c.ips())
+
+	require.Error(t, c.SetIPs("192.168.0.1,192.168.0.2"))
+	require.Error(t, c.SetIPs("::1,::2"))
+	require.Error(t, c.SetIPs("192.168.0.1,::1,::2"))
+	require.Error(t, c.SetIPs("lol"))
+	require.Error(t, c.SetIPs(""))
+}
+
+func TestConfigReplySockaddr(t *testing.T) {
+	c := &Config{IP: net.ParseIP("::")}
+	require.False(t, c.servesIPv4())
+	// IPv4 clients of IPv6 only server are reached via mapped addresses
+	sa := c.replySockaddr(net.ParseIP("192.168.0.1"), 319)
+	require.Equal(t, &unix.SockaddrInet6{Port: 319, Addr: [16]byte{10: 0xff, 11: 0xff, 12: 192, 13: 168, 15: 1}}, sa)
+	require.Equal(t, unix.AF_INET6, sockaddrFamily(sa))
+
+	c.SecondaryIP = net.ParseIP("0.0.0.0")
+	require.True(t, c.servesIPv4())
+	sa = c.replySockaddr(net.ParseIP("192.168.0.1"), 319)
+	require.Equal(t, &unix.SockaddrInet4{Port: 319, Addr: [4]byte{192, 168, 0, 1}}, sa)
+	require.Equal(t, unix.AF_INET, sockaddrFamily(sa))
+
+	sa = c.replySockaddr(net.ParseIP("2001:db8::1"), 320)
+	require.Equal(t, unix.AF_INET6, sockaddrFamily(sa))
+	require.Equal(t, 320, sa.(*unix.SockaddrInet6).Port)
+}
+
+func TestWorkerSocketsFds(t *testing.T) {
+	ws := newWorkerSockets()
+	ws.event[unix.AF_INET6] = 3
+	ws.general[unix.AF_INET6] = 4
+
+	sa4 := &unix.SockaddrInet4{Port: 319}
+	sa6 := &unix.SockaddrInet6{Port: 319}
+	eFd, gFd := ws.fds(sa6, sa6)
+	require.Equal(t, 3, eFd)
+	require.Equal(t, 4, gFd)
+	eFd, gFd = ws.fds(sa4, sa4)
+	require.Equal(t, -1, eFd)
+	require.Equal(t, -1, gFd)
+
+	ws.event[unix.AF_INET] = 5
+	ws.general[unix.AF_INET] = 6
+	eFd, gFd = ws.fds(sa4, sa6)
+	require.Equal(t, 5, eFd)
+	require.Equal(t, 4, gFd)
+}
+
+func TestServerGeneralFd(t *testing.T) {
+	s := &Server{}
+	require.Equal(t, -1, s.generalFd(&unix.SockaddrInet4{}))
+	s.gFds = map[int]int{unix.AF_INET: 7, unix.AF_INET6: 8}
+	require.Equal(t, 7, s.generalFd(&unix.SockaddrInet4{}))
+	require.Equal(t, 8, s.generalFd(&unix.SockaddrInet6{}))
+}
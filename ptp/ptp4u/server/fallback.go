@@ -0,0 +1,130 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we fall back to software timestamps when the NIC stops producing hardware
+// TX timestamps, for example after driver reset or because of a firmware bug.
+// Send workers count TX timestamps missing in a row, and once TXTSFallbackFailures
+// are missing the server is reloaded with software timestamps, announcing worse
+// clock accuracy. Hardware timestamps are retried every TXTSRetryInterval.
+
+import (
+	"sync/atomic"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+)
+
+// swClockAccuracy is announced while software timestamps are used, 0x27 - Time Accurate within 100us
+const swClockAccuracy uint8 = 0x27
+
+// txtsCheckInterval is how often the fallback state is checked
+const txtsCheckInterval = time.Second
+
+// txtsFailed records TX timestamp which wasn't produced
+func (c *Config) txtsFailed() {
+	atomic.AddInt64(&c.txtsFailures, 1)
+}
+
+// txtsOK records TX timestamp which was produced, avoiding writes of the shared counter while all is well
+func (c *Config) txtsOK() {
+	if atomic.LoadInt64(&c.txtsFailures) != 0 {
+		atomic.StoreInt64(&c.txtsFailures, 0)
+	}
+}
+
+// TSDegraded checks if software timestamps are used as the NIC stopped producing hardware ones
+func (c *Config) TSDegraded() bool {
+	return atomic.LoadInt32(&c.tsDegraded) == 1
+}
+
+// setTSDegraded marks if software timestamps are used instead of hardware ones
+func (c *Config) setTSDegraded(degraded bool) {
+	var v int32
+	if degraded {
+		v = 1
+	}
+	atomic.StoreInt32(&c.tsDegraded, v)
+}
+
+// timestampQuality returns the clock quality with accuracy matching the timestamps we send
+func (c *Config) timestampQuality(q ptp.ClockQuality) ptp.ClockQuality {
+	if (c.TSDegraded() || c.UpstreamSWTS()) && q.ClockAccuracy < swClockAccuracy {
+		q.ClockAccuracy = swClockAccuracy
+	}
+	return q
+}
+
+// switchTimestamps reloads the server with timestamps of another type if it uses timestamps of the from type.
+// It reports if the server was switched, and the interface it uses
+func (s *Server) switchTimestamps(from, to timestamp.Timestamp) (bool, string, error) {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	if s.Config.TimestampType != from {
+		return false, s.Config.Interface, nil
+	}
+	dc := s.Config.dynamicConfig()
+	dc.TimestampType = to
+	if err := s.reloadLocked(dc); err != nil {
+		return false, s.Config.Interface, err
+	}
+	atomic.StoreInt64(&s.Config.txtsFailures, 0)
+	return true, s.Config.Interface, nil
+}
+
+// followTXTimestamps falls back to software timestamps when hardware TX timestamps are missing and periodically retries hardware ones
+func (s *Server) followTXTimestamps() {
+	var fellBack time.Time
+	for {
+		<-time.After(txtsCheckInterval)
+		if !s.Config.TSDegraded() {
+			failures := atomic.LoadInt64(&s.Config.txtsFailures)
+			if failures < int64(s.Config.TXTSFallbackFailures) {
+				continue
+			}
+			switched, iface, err := s.switchTimestamps(timestamp.HW, timestamp.SW)
+			if err != nil {
+				log.Errorf("Failed to fall back to %s timestamps: %v", timestamp.SW, err)
+				continue
+			}
+			if !switched {
+				continue
+			}
+			log.Errorf("%d %s TX timestamps missing in a row on %s, fell back to %s timestamps", failures, timestamp.HW, iface, timestamp.SW)
+			s.Config.setTSDegraded(true)
+			s.serverStats().IncSWFallback()
+			fellBack = time.Now()
+			continue
+		}
+		if time.Since(fellBack) < s.Config.TXTSRetryInterval {
+			continue
+		}
+		switched, iface, err := s.switchTimestamps(timestamp.SW, timestamp.HW)
+		if err != nil {
+			log.Warningf("Failed to switch back to %s timestamps: %v", timestamp.HW, err)
+			fellBack = time.Now()
+			continue
+		}
+		if switched {
+			log.Infof("Switched back to %s timestamps on %s", timestamp.HW, iface)
+		}
+		// config reload may have switched timestamps already
+		s.Config.setTSDegraded(false)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/ptp/ptp4u/stats"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTXTSFailures(t *testing.T) {
+	c := &Config{}
+	c.txtsFailed()
+	c.txtsFailed()
+	require.Equal(t, int64(2), c.txtsFailures)
+	c.txtsOK()
+	require.Equal(t, int64(0), c.txtsFailures)
+}
+
+func TestTimestampQuality(t *testing.T) {
+	c := &Config{}
+	require.False(t, c.TSDegraded())
+	require.Equal(t, DefaultClockQuality, c.timestampQuality(DefaultClockQuality))
+
+	c.setTSDegraded(true)
+	require.True(t, c.TSDegraded())
+	q := c.timestampQuality(DefaultClockQuality)
+	require.Equal(t, swClockAccuracy, q.ClockAccuracy)
+	require.Equal(t, DefaultClockQuality.ClockClass, q.ClockClass)
+	// already worse accuracy stays
+	unknown := ptp.ClockQuality{ClockClass: 248, ClockAccuracy: 0xfe}
+	require.Equal(t, unknown, c.timestampQuality(unknown))
+
+	c.setTSDegraded(false)
+	require.False(t, c.TSDegraded())
+}
+
+func TestAnnounceTSDegraded(t *testing.T) {
+	c := &Config{
+		clockIdentity: ptp.ClockIdentity(1234),
+		Domains:       []DomainConfig{{DomainNumber: 24, ClockQuality: ptp.ClockQuality{ClockClass: 7, ClockAccuracy: 0x20}}},
+	}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(nil, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Time{})
+	extra := NewSubscriptionClient(nil, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Time{})
+	extra.setDomain(c.Domains[0])
+
+	c.setTSDegraded(true)
+	sc.UpdateAnnounce()
+	extra.UpdateAnnounce()
+	require.Equal(t, swClockAccuracy, sc.Announce().GrandmasterClockQuality.ClockAccuracy)
+	require.Equal(t, swClockAccuracy, extra.Announce().GrandmasterClockQuality.ClockAccuracy)
+	require.Equal(t, uint8(7), extra.Announce().GrandmasterClockQuality.ClockClass)
+
+	c.setTSDegraded(false)
+	sc.UpdateAnnounce()
+	extra.UpdateAnnounce()
+	require.Equal(t, DefaultClockQuality, sc.Announce().GrandmasterClockQuality)
+	require.Equal(t, c.Domains[0].ClockQuality, extra.Announce().GrandmasterClockQuality)
+}
+
+func TestSwitchTimestamps(t *testing.T) {
+	c := &Config{
+		clockIdentity: ptp.ClockIdentity(1234),
+		Interface:     "lo",
+		IP:            net.ParseIP("127.0.0.1"),
+		TimestampType: timestamp.HW,
+		GeneralDSCP:   -1,
+		SendWorkers:   1,
+	}
+	st := stats.NewJSONStats()
+	s := &Server{Config: c, Stats: st, sw: []*sendWorker{NewSendWorker(0, c, st)}}
+	c.txtsFailed()
+
+	// nothing to do if the server uses other timestamps
+	switched, _, err := s.switchTimestamps(timestamp.SW, timestamp.HW)
+	require.NoError(t, err)
+	require.False(t, switched)
+	require.Equal(t, int64(1), c.txtsFailures)
+
+	switched, iface, err := s.switchTimestamps(timestamp.HW, timestamp.SW)
+	require.NoError(t, err)
+	require.Equal(t, "lo", iface)
+	require.True(t, switched)
+	require.Equal(t, timestamp.SW, c.TimestampType)
+	require.Equal(t, timestamp.SW, s.workers()[0].timestampType)
+	require.Equal(t, int64(0), c.txtsFailures)
+
+	// loopback has no hardware timestamps, so we stay with software ones
+	switched, _, err = s.switchTimestamps(timestamp.SW, timestamp.HW)
+	require.Error(t, err)
+	require.False(t, switched)
+	require.Equal(t, timestamp.SW, c.TimestampType)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have holdover of the boundary clock. While synced, frequency set by the servo is recorded,
+// and once the upstream grandmaster stops sending Sync, frequency predicted by the linear fit of
+// recent corrections keeps being applied to the clock until the grandmaster comes back.
+
+import (
+	"math"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// driftWindow is for how long frequency corrections are kept to model the drift of the clock
+const driftWindow = 10 * time.Minute
+
+// holdoverClockClass is announced in holdover, as per IEEE 1588-2019 Table 4
+const holdoverClockClass uint8 = 7
+
+// clockAccuracies are upper bounds of clock accuracy values, as per IEEE 1588-2019 Table 5
+var clockAccuracies = []struct {
+	within   time.Duration
+	accuracy uint8
+}{
+	{25 * time.Nanosecond, 0x20},
+	{100 * time.Nanosecond, 0x21},
+	{250 * time.Nanosecond, 0x22},
+	{time.Microsecond, 0x23},
+	{2500 * time.Nanosecond, 0x24},
+	{10 * time.Microsecond, 0x25},
+	{25 * time.Microsecond, 0x26},
+	{100 * time.Microsecond, 0x27},
+	{250 * time.Microsecond, 0x28},
+	{time.Millisecond, 0x29},
+	{2500 * time.Microsecond, 0x2a},
+	{10 * time.Millisecond, 0x2b},
+	{25 * time.Millisecond, 0x2c},
+	{100 * time.Millisecond, 0x2d},
+	{250 * time.Millisecond, 0x2e},
+	{time.Second, 0x2f},
+	{10 * time.Second, 0x30},
+}
+
+// clockAccuracy returns clock accuracy value of the time error
+func clockAccuracy(d time.Duration) uint8 {
+	for _, a := range clockAccuracies {
+		if d <= a.within {
+			return a.accuracy
+		}
+	}
+	return 0x31
+}
+
+// driftSample is the frequency set by the locked servo
+type driftSample struct {
+	t   time.Time
+	ppb float64
+}
+
+// driftModel predicts frequency of the clock from recent corrections
+type driftModel struct {
+	samples []driftSample
+}
+
+// add records the frequency set at t, forgetting ones older than driftWindow
+func (m *driftModel) add(t time.Time, ppb float64) {
+	m.samples = append(m.samples, driftSample{t: t, ppb: ppb})
+	i := 0
+	for i < len(m.samples) && t.Sub(m.samples[i].t) > driftWindow {
+		i++
+	}
+	m.samples = m.samples[i:]
+}
+
+// predict returns frequency of the clock at t from the linear fit of recorded ones,
+// and the rms of recorded frequencies around it. It's false if nothing is recorded.
+func (m *driftModel) predict(t time.Time) (float64, float64, bool) {
+	n := float64(len(m.samples))
+	if n == 0 {
+		return 0, 0, false
+	}
+	t0 := m.samples[0].t
+	var meanX, meanY float64
+	for _, s := range m.samples {
+		meanX += s.t.Sub(t0).Seconds()
+		meanY += s.ppb
+	}
+	meanX /= n
+	meanY /= n
+	var sxy, sxx float64
+	for _, s := range m.samples {
+		dx := s.t.Sub(t0).Seconds() - meanX
+		sxy += dx * (s.ppb - meanY)
+		sxx += dx * dx
+	}
+	slope := 0.0
+	if sxx > 0 {
+		slope = sxy / sxx
+	}
+	fit := func(t time.Time) float64 {
+		return meanY + slope*(t.Sub(t0).Seconds()-meanX)
+	}
+	var sum float64
+	for _, s := range m.samples {
+		r := s.ppb - fit(s.t)
+		sum += r * r
+	}
+	return clampPPB(fit(t)), math.Sqrt(sum / n), true
+}
+
+// holdoverState is the holdover of the boundary clock
+type holdoverState struct {
+	since time.Time
+	// rms of the drift model in ppb
+	rms float64
+}
+
+// setHoldover marks the boundary clock in holdover since the time, with rms of the drift model.
+// Zero time ends the holdover.
+func (c *Config) setHoldover(since time.Time, rms float64) {
+	c.parentMux.Lock()
+	defer c.parentMux.Unlock()
+	c.holdover = holdoverState{since: since, rms: rms}
+}
+
+// inHoldover returns for how long the boundary clock is in holdover and the time error it's estimated to have accumulated.
+// Error grows with the spread of frequency corrections around the drift model.
+func (c *Config) inHoldover(now time.Time) (time.Duration, time.Duration, bool) {
+	c.parentMux.RLock()
+	defer c.parentMux.RUnlock()
+	if c.holdover.since.IsZero() {
+		return 0, 0, false
+	}
+	d := now.Sub(c.holdover.since)
+	return d, time.Duration(c.holdover.rms * d.Seconds()), true
+}
+
+// holdoverQuality returns the clock quality announced in holdover, false once Holdover is over
+func (c *Config) holdoverQuality(now time.Time) (ptp.ClockQuality, bool) {
+	d, e, ok := c.inHoldover(now)
+	if !ok || d > c.Holdover {
+		return ptp.ClockQuality{}, false
+	}
+	return ptp.ClockQuality{
+		ClockClass:              holdoverClockClass,
+		ClockAccuracy:           clockAccuracy(e),
+		OffsetScaledLogVariance: 0xffff,
+	}, true
+}
+
+// syncTimeout returns for how long the servo may get no samples before holdover starts
+func (u *upstreamPort) syncTimeout() time.Duration {
+	return upstreamAnnounceTimeout * u.config.UpstreamInterval * time.Duration(u.filter.Decimation())
+}
+
+// holdover keeps disciplining the clock by the drift model while there are no Sync from the grandmaster
+func (u *upstreamPort) holdover(now time.Time) {
+	if u.lastSync.IsZero() || now.Sub(u.lastSync) <= u.syncTimeout() {
+		return
+	}
+	if u.holdoverSince.IsZero() {
+		if _, _, ok := u.model.predict(now); !ok {
+			return
+		}
+		log.Warningf("No Sync from upstream grandmaster %s since %v, entering holdover", u.config.Upstream, u.lastSync)
+		u.holdoverSince = u.lastSync
+		u.config.setParent(nil)
+	}
+	if now.Sub(u.holdoverAdjusted) < u.config.UpstreamInterval {
+		return
+	}
+	ppb, rms, _ := u.model.predict(now)
+	if err := u.clock.AdjFreqPPB(ppb); err != nil {
+		log.Errorf("Failed to adjust clock frequency in holdover: %v", err)
+		return
+	}
+	u.holdoverAdjusted = now
+	// re-lock starts from the modeled frequency
+	u.servo.setFrequency(ppb)
+	u.config.setHoldover(u.holdoverSince, rms)
+	log.Debugf("Holdover for %v, frequency %.3f ppb", now.Sub(u.holdoverSince), ppb)
+}
+
+// relock ends holdover once the clock is back close to the grandmaster. It's false while it's not
+func (u *upstreamPort) relock(offset time.Duration, now time.Time) bool {
+	if u.holdoverSince.IsZero() {
+		return true
+	}
+	if first := u.config.upstreamSteps().first; offset > first || offset < -first {
+		return false
+	}
+	log.Infof("Re-locked to upstream grandmaster %s after %v of holdover", u.config.Upstream, now.Sub(u.holdoverSince))
+	u.holdoverSince = time.Time{}
+	u.holdoverAdjusted = time.Time{}
+	u.config.setHoldover(time.Time{}, 0)
+	return true
+}
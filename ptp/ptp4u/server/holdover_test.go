@@ -0,0 +1,168 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockAccuracy(t *testing.T) {
+	require.Equal(t, uint8(0x20), clockAccuracy(0))
+	require.Equal(t, uint8(0x21), clockAccuracy(100*time.Nanosecond))
+	require.Equal(t, uint8(0x23), clockAccuracy(999*time.Nanosecond))
+	require.Equal(t, uint8(0x29), clockAccuracy(time.Millisecond))
+	require.Equal(t, uint8(0x31), clockAccuracy(time.Minute))
+}
+
+func TestDriftModelPredict(t *testing.T) {
+	m := driftModel{}
+	now := time.Unix(1000, 0)
+	_, _, ok := m.predict(now)
+	require.False(t, ok)
+
+	m.add(now, 100)
+	ppb, rms, ok := m.predict(now.Add(time.Hour))
+	require.True(t, ok)
+	require.Equal(t, 100.0, ppb)
+	require.Equal(t, 0.0, rms)
+
+	// frequency drifting by 2 ppb per second
+	for i := 1; i < 10; i++ {
+		m.add(now.Add(time.Duration(i)*time.Second), 100+2*float64(i))
+	}
+	ppb, rms, ok = m.predict(now.Add(20 * time.Second))
+	require.True(t, ok)
+	require.InDelta(t, 140.0, ppb, 0.001)
+	require.InDelta(t, 0.0, rms, 0.001)
+
+	// noise around the drift
+	m = driftModel{}
+	for i := 0; i < 10; i++ {
+		noise := 1.0
+		if i%2 == 1 {
+			noise = -1.0
+		}
+		m.add(now.Add(time.Duration(i)*time.Second), 100+noise)
+	}
+	ppb, rms, _ = m.predict(now.Add(10 * time.Second))
+	require.InDelta(t, 100.0, ppb, 2)
+	require.InDelta(t, 1.0, rms, 0.05)
+}
+
+func TestDriftModelWindow(t *testing.T) {
+	m := driftModel{}
+	now := time.Unix(1000, 0)
+	m.add(now, 100)
+	m.add(now.Add(driftWindow), 200)
+	require.Len(t, m.samples, 2)
+	m.add(now.Add(driftWindow+time.Second), 200)
+	require.Len(t, m.samples, 2)
+	ppb, _, _ := m.predict(now.Add(driftWindow + time.Minute))
+	require.InDelta(t, 200.0, ppb, 0.001)
+}
+
+func TestHoldoverQuality(t *testing.T) {
+	c := &Config{Holdover: time.Minute}
+	now := time.Now()
+	_, ok := c.holdoverQuality(now)
+	require.False(t, ok)
+
+	c.setHoldover(now.Add(-10*time.Second), 10)
+	d, e, ok := c.inHoldover(now)
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, d)
+	require.Equal(t, 100*time.Nanosecond, e)
+	q, ok := c.holdoverQuality(now)
+	require.True(t, ok)
+	require.Equal(t, ptp.ClockQuality{ClockClass: 7, ClockAccuracy: 0x21, OffsetScaledLogVariance: 0xffff}, q)
+
+	// free running once holdover is over
+	_, ok = c.holdoverQuality(now.Add(time.Minute))
+	require.False(t, ok)
+
+	c.setHoldover(time.Time{}, 0)
+	_, _, ok = c.inHoldover(now)
+	require.False(t, ok)
+}
+
+func TestUpstreamPortHoldover(t *testing.T) {
+	var delayReqSent time.Time
+	u, clock, _ := newTestUpstreamPort(t, &delayReqSent)
+	u.config.Holdover = time.Hour
+	// Announce is built with the current time
+	now := time.Now().Add(-time.Minute)
+
+	// nothing to hold over before the servo is locked
+	require.NoError(t, u.check(now.Add(time.Minute)))
+	_, _, ok := u.config.inHoldover(now.Add(time.Minute))
+	require.False(t, ok)
+
+	u.parent = &upstreamParent{}
+	u.lastAnnounce = now
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, u.discipline(time.Microsecond, now.Add(time.Duration(i)*time.Second)))
+	}
+	require.NotNil(t, u.config.syncedParent())
+	applied := len(clock.ppb)
+	now = now.Add(4 * time.Second)
+
+	// Sync is late, but not lost yet
+	require.NoError(t, u.check(now.Add(u.syncTimeout())))
+	require.Len(t, clock.ppb, applied)
+
+	// modeled frequency is applied once per interval
+	lost := now.Add(u.syncTimeout() + time.Millisecond)
+	require.NoError(t, u.check(lost))
+	require.Len(t, clock.ppb, applied+1)
+	ppb, _, _ := u.model.predict(lost)
+	require.Equal(t, ppb, clock.ppb[applied])
+	require.Equal(t, -ppb, u.servo.(*piServo).drift)
+	require.Nil(t, u.config.syncedParent())
+	d, _, ok := u.config.inHoldover(lost)
+	require.True(t, ok)
+	require.Equal(t, lost.Sub(now), d)
+	require.NoError(t, u.check(lost.Add(time.Millisecond)))
+	require.Len(t, clock.ppb, applied+1)
+	require.NoError(t, u.check(lost.Add(time.Second)))
+	require.Len(t, clock.ppb, applied+2)
+
+	// holdover is announced
+	a := &ptp.Announce{}
+	u.config.propagateParent(a)
+	require.Equal(t, holdoverClockClass, a.GrandmasterClockQuality.ClockClass)
+
+	// back to the grandmaster, but still too far
+	back := lost.Add(time.Minute)
+	u.parent = &upstreamParent{}
+	u.lastAnnounce = back
+	require.NoError(t, u.discipline(100*time.Microsecond, back))
+	_, _, ok = u.config.inHoldover(back)
+	require.True(t, ok)
+	require.Nil(t, u.config.syncedParent())
+
+	// close enough
+	require.NoError(t, u.discipline(time.Microsecond, back.Add(time.Second)))
+	_, _, ok = u.config.inHoldover(back)
+	require.False(t, ok)
+	require.NotNil(t, u.config.syncedParent())
+	require.Empty(t, clock.steps)
+}
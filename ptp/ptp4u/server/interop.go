@@ -0,0 +1,132 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have interop mode, where the server behaves as a plain IEEE 1588 unicast master
+// towards standard two-step unicast clients. In the domains it's enabled for
+// the server ignores messages of domains it doesn't serve and messages targeted at other
+// clocks, skips unknown TLVs, fills Signaling headers as the standard says rather than
+// echoing the request, and acknowledges cancellation of unicast transmission.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// ParseInteropDomains parses comma separated list of domains served in interop mode
+func ParseInteropDomains(s string) ([]uint8, error) {
+	res := []uint8{}
+	if s == "" {
+		return res, nil
+	}
+	for _, d := range strings.Split(s, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(d), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interop domain %q: %w", d, err)
+		}
+		res = append(res, uint8(v))
+	}
+	return res, nil
+}
+
+// servesDomain checks if the domain is the main one or one of additional domains
+func (c *Config) servesDomain(domain uint8) bool {
+	if domain == c.DomainNumber {
+		return true
+	}
+	_, ok := c.extraDomain(domain)
+	return ok
+}
+
+// interop checks if messages of the domain are handled in interop mode.
+// Domains we don't serve fall back to the main domain like everywhere else.
+func (c *Config) interop(domain uint8) bool {
+	if !c.servesDomain(domain) {
+		domain = c.DomainNumber
+	}
+	for _, d := range c.InteropDomains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// targetsUs checks if the message targeted at the port is meant for the server
+func (c *Config) targetsUs(target ptp.PortIdentity) bool {
+	return (target.ClockIdentity == ptp.DefaultTargetPortIdentity.ClockIdentity || target.ClockIdentity == c.clockIdentity) &&
+		(target.PortNumber == ptp.DefaultTargetPortIdentity.PortNumber || target.PortNumber == 1)
+}
+
+// interopIgnores checks if a standard master would ignore the message of the domain targeted at the port
+func (c *Config) interopIgnores(domain uint8, target ptp.PortIdentity) bool {
+	return c.interop(domain) && (!c.servesDomain(domain) || !c.targetsUs(target))
+}
+
+// domainOffset is where domainNumber is in the PTP header
+const domainOffset = 4
+
+// decodeSignaling reads the Signaling message. In interop domains TLVs it doesn't know are skipped,
+// as standard clients may add them to their requests
+func (c *Config) decodeSignaling(b []byte, sg *ptp.Signaling) error {
+	if len(b) > domainOffset && c.interop(b[domainOffset]) {
+		return sg.UnmarshalBinarySkipUnknown(b)
+	}
+	return ptp.FromBytes(b, sg)
+}
+
+// AcknowledgeCancel returns ptp Signaling packet acknowledging the cancel request of the client
+func (c *Config) AcknowledgeCancel(sg *ptp.Signaling, mt ptp.UnicastMsgTypeAndFlags) *ptp.Signaling {
+	b := ptp.NewBuilder(ptp.PortIdentity{PortNumber: 1, ClockIdentity: c.clockIdentity}, sg.DomainNumber)
+	b.Version = ptp.NegotiateVersion(c.ptpVersion(), sg.Version)
+	b.Unicast = true
+	h := b.Header(ptp.MessageSignaling, binary.Size(ptp.Header{})+binary.Size(ptp.PortIdentity{})+binary.Size(ptp.AcknowledgeCancelUnicastTransmissionTLV{}), 0x7f)
+	h.SequenceID = sg.SequenceID
+	return &ptp.Signaling{
+		Header:             h,
+		TargetPortIdentity: sg.SourcePortIdentity,
+		TLVs: []ptp.TLV{
+			&ptp.AcknowledgeCancelUnicastTransmissionTLV{
+				TLVHead:         ptp.TLVHead{TLVType: ptp.TLVAcknowledgeCancelUnicastTransmission, LengthField: uint16(binary.Size(ptp.AcknowledgeCancelUnicastTransmissionTLV{}) - binary.Size(ptp.TLVHead{}))},
+				MsgTypeAndFlags: ptp.NewUnicastMsgTypeAndFlags(mt.MsgType(), 0),
+			},
+		},
+	}
+}
+
+// sendCancelAck sends a Unicast Cancel acknowledgement to the client
+func (s *Server) sendCancelAck(sg *ptp.Signaling, mt ptp.UnicastMsgTypeAndFlags, sa unix.Sockaddr, src net.IP) {
+	fields := subscriberFields(timestamp.SockaddrToIP(sa), sg.SourcePortIdentity, sg.DomainNumber, mt.MsgType())
+	ackb, err := ptp.Bytes(s.Config.AcknowledgeCancel(sg, mt))
+	if err != nil {
+		s.Config.logSubscriber(log.ErrorLevel, fields, "Failed to prepare the unicast cancel acknowledgement: %v", err)
+		return
+	}
+	if err := sendGeneral(s.generalFd(sa), ackb, sourceOOB(sa, src), sa); err != nil {
+		s.Config.logSubscriber(log.ErrorLevel, fields, "Failed to send the unicast cancel acknowledgement: %v", err)
+		return
+	}
+	s.Stats.IncTXSignaling(mt.MsgType())
+}
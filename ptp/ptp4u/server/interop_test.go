@@ -0,0 +1,158 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInteropDomains(t *testing.T) {
+	domains, err := ParseInteropDomains("")
+	require.NoError(t, err)
+	require.Equal(t, []uint8{}, domains)
+
+	domains, err = ParseInteropDomains("0, 24")
+	require.NoError(t, err)
+	require.Equal(t, []uint8{0, 24}, domains)
+
+	_, err = ParseInteropDomains("256")
+	require.Error(t, err)
+	_, err = ParseInteropDomains("0,")
+	require.Error(t, err)
+}
+
+func TestConfigInterop(t *testing.T) {
+	c := &Config{
+		clockIdentity:  ptp.ClockIdentity(1234),
+		Domains:        []DomainConfig{{DomainNumber: 24}, {DomainNumber: 25}},
+		InteropDomains: []uint8{0, 24},
+	}
+	require.True(t, c.interop(0))
+	require.True(t, c.interop(24))
+	require.False(t, c.interop(25))
+	// unknown domains fall back to the main one
+	require.True(t, c.interop(42))
+
+	us := ptp.PortIdentity{ClockIdentity: 1234, PortNumber: 1}
+	require.False(t, c.interopIgnores(0, ptp.DefaultTargetPortIdentity))
+	require.False(t, c.interopIgnores(24, us))
+	require.True(t, c.interopIgnores(42, ptp.DefaultTargetPortIdentity))
+	require.True(t, c.interopIgnores(0, ptp.PortIdentity{ClockIdentity: 4321, PortNumber: 1}))
+	require.True(t, c.interopIgnores(0, ptp.PortIdentity{ClockIdentity: 1234, PortNumber: 2}))
+	// everything is served as before without interop
+	require.False(t, c.interopIgnores(25, ptp.PortIdentity{ClockIdentity: 4321, PortNumber: 1}))
+
+	c.InteropDomains = nil
+	require.False(t, c.interopIgnores(42, ptp.PortIdentity{}))
+}
+
+func TestInteropGrantPacket(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), InteropDomains: []uint8{0}}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(nil, sa, sa, ptp.MessageSync, c, time.Second, time.Time{})
+	sg := &ptp.Signaling{
+		Header: ptp.Header{
+			SequenceID:          42,
+			CorrectionField:     ptp.NewCorrection(100),
+			MessageTypeSpecific: 1,
+			LogMessageInterval:  3,
+		},
+	}
+
+	sc.UpdateGrant(sg, ptp.NewUnicastMsgTypeAndFlags(ptp.MessageSync, 0), 0, 60)
+	require.Equal(t, uint16(42), sc.Grant().SequenceID)
+	require.Equal(t, ptp.Correction(0), sc.Grant().CorrectionField)
+	require.Equal(t, uint32(0), sc.Grant().MessageTypeSpecific)
+	require.Equal(t, uint8(5), sc.Grant().ControlField)
+	require.Equal(t, ptp.LogInterval(0x7f), sc.Grant().LogMessageInterval)
+
+	// the request is echoed otherwise
+	c.InteropDomains = nil
+	sc.UpdateGrant(sg, ptp.NewUnicastMsgTypeAndFlags(ptp.MessageSync, 0), 0, 60)
+	require.Equal(t, sg.CorrectionField, sc.Grant().CorrectionField)
+	require.Equal(t, ptp.LogInterval(3), sc.Grant().LogMessageInterval)
+}
+
+func TestAcknowledgeCancel(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	client := ptp.PortIdentity{ClockIdentity: 4321, PortNumber: 1}
+	sg := &ptp.Signaling{
+		Header: ptp.Header{
+			Version:            ptp.Version,
+			DomainNumber:       24,
+			SequenceID:         42,
+			SourcePortIdentity: client,
+		},
+	}
+
+	ack := c.AcknowledgeCancel(sg, ptp.NewUnicastMsgTypeAndFlags(ptp.MessageAnnounce, 0))
+	b, err := ptp.Bytes(ack)
+	require.NoError(t, err)
+	// two extra zero bytes are always added
+	require.Equal(t, int(ack.MessageLength)+2, len(b))
+
+	got := &ptp.Signaling{}
+	require.NoError(t, ptp.FromBytes(b, got))
+	require.Equal(t, uint8(24), got.DomainNumber)
+	require.Equal(t, uint16(42), got.SequenceID)
+	require.Equal(t, client, got.TargetPortIdentity)
+	require.Equal(t, ptp.PortIdentity{ClockIdentity: 1234, PortNumber: 1}, got.SourcePortIdentity)
+	require.Len(t, got.TLVs, 1)
+	tlv, ok := got.TLVs[0].(*ptp.AcknowledgeCancelUnicastTransmissionTLV)
+	require.True(t, ok)
+	require.Equal(t, ptp.MessageAnnounce, tlv.MsgTypeAndFlags.MsgType())
+}
+
+func TestDecodeSignalingUnknownTLV(t *testing.T) {
+	c := &Config{
+		Domains:        []DomainConfig{{DomainNumber: 24}},
+		InteropDomains: []uint8{24},
+	}
+	request := &ptp.RequestUnicastTransmissionTLV{
+		TLVHead:               ptp.TLVHead{TLVType: ptp.TLVRequestUnicastTransmission, LengthField: 6},
+		MsgTypeAndReserved:    ptp.NewUnicastMsgTypeAndFlags(ptp.MessageSync, 0),
+		LogInterMessagePeriod: 1,
+		DurationField:         60,
+	}
+	sg := &ptp.Signaling{
+		Header:             ptp.NewBuilder(ptp.PortIdentity{ClockIdentity: 42, PortNumber: 1}, 24).Header(ptp.MessageSignaling, 0, 0x7f),
+		TargetPortIdentity: ptp.DefaultTargetPortIdentity,
+		TLVs:               []ptp.TLV{request},
+	}
+	b, err := ptp.Bytes(sg)
+	require.NoError(t, err)
+	// standard client adds a TLV we don't know before the request
+	unknown := []byte{0x20, 0x40, 0x00, 0x04, 0xde, 0xad, 0xbe, 0xef}
+	pos := binary.Size(ptp.Header{}) + binary.Size(ptp.PortIdentity{})
+	b = append(b[:pos], append(unknown, b[pos:]...)...)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-2))
+
+	got := &ptp.Signaling{}
+	require.NoError(t, c.decodeSignaling(b, got))
+	require.Equal(t, []ptp.TLV{request}, got.TLVs)
+
+	// without interop such message is dropped
+	b[domainOffset] = 25
+	require.Error(t, c.decodeSignaling(b, &ptp.Signaling{}))
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/facebook/time/leapsectz"
+	ptp "github.com/facebook/time/ptp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// leapWindow is how long before the leap second event Announce carry leap61/leap59 flag,
+// as the flags mean the last minute of the current UTC day has 61/59 seconds
+const leapWindow = 24 * time.Hour
+
+// initialUTCOffset is TAI-UTC offset before the first leap second
+const initialUTCOffset = 10 * time.Second
+
+// readLeapSeconds reads leap seconds from either time zone file (like /usr/share/zoneinfo/right/UTC) or leap-seconds.list
+func readLeapSeconds(path string) ([]leapsectz.LeapSecond, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err == nil && string(magic) == "TZif" {
+		return leapsectz.Parse(path)
+	}
+	return leapsectz.ParseList(path)
+}
+
+// leapState returns UTC offset and leap flags valid at the moment, and when they change next (zero if never)
+func leapState(leaps []leapsectz.LeapSecond, now time.Time) (time.Duration, uint16, time.Time) {
+	var nleap int32
+	var next time.Time
+	flags := ptp.FlagCurrentUtcOffsetValid
+	for _, l := range leaps {
+		t := l.Time()
+		if !now.Before(t) {
+			nleap = l.Nleap
+			continue
+		}
+		// the first leap second in future
+		start := t.Add(-leapWindow)
+		if now.Before(start) {
+			next = start
+			break
+		}
+		next = t
+		if l.Nleap > nleap {
+			flags |= ptp.FlagLeap61
+		} else {
+			flags |= ptp.FlagLeap59
+		}
+		break
+	}
+	return initialUTCOffset + time.Duration(nleap)*time.Second, flags, next
+}
+
+// setLeap atomically sets UTC offset and leap flags
+func (c *Config) setLeap(utcOffset time.Duration, flags uint16) {
+	c.leapMux.Lock()
+	defer c.leapMux.Unlock()
+	c.UTCOffset = utcOffset
+	c.leapFlags = flags
+}
+
+// leapIndicator returns leap related flags of Announce, zero unless leap seconds are followed
+func (c *Config) leapIndicator() uint16 {
+	c.leapMux.RLock()
+	defer c.leapMux.RUnlock()
+	return c.leapFlags
+}
+
+// followLeapSeconds keeps UTC offset and leap flags in line with LeapSecondsFile.
+// The file is re-read every time, so it can be updated without restart.
+func (s *Server) followLeapSeconds() {
+	for {
+		wait := time.Minute
+		leaps, err := readLeapSeconds(s.Config.LeapSecondsFile)
+		if err != nil {
+			log.Errorf("Failed to read leap seconds from %s: %v. Keeping the last known UTC offset: %s", s.Config.LeapSecondsFile, err, s.Config.UTCOffset)
+		} else {
+			now := time.Now()
+			at := now
+			if s.Config.LeapSmear != SmearNone {
+				// UTC offset changes once the leap second is fully smeared
+				at = now.Add(-s.Config.LeapSmearWindow / 2)
+				s.Config.setSmear(smearEvent(leaps, at))
+			}
+			utcOffset, flags, next := leapState(leaps, at)
+			if s.Config.LeapSmear != SmearNone {
+				flags &^= ptp.FlagLeap61 | ptp.FlagLeap59
+				if !next.IsZero() {
+					next = next.Add(s.Config.LeapSmearWindow / 2)
+				}
+			}
+			if utcOffset != s.Config.UTCOffset || flags != s.Config.leapIndicator() {
+				log.Warningf("UTC offset is %s, leap flags are %#x", utcOffset, flags)
+			}
+			s.Config.setLeap(utcOffset, flags)
+			// wake up right in time for the change
+			if !next.IsZero() && next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		}
+		<-time.After(wait)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebook/time/leapsectz"
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testLeapSecondsList = `#@	3928521600
+2272060800	10	# 1 Jan 1972
+2287785600	11	# 1 Jul 1972
+3644697600	36	# 1 Jul 2015
+3692217600	37	# 1 Jan 2017
+3928780800	36	# 1 Jul 2024, made up negative leap second
+`
+
+func TestLeapState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leap-seconds.list")
+	require.NoError(t, os.WriteFile(path, []byte(testLeapSecondsList), 0644))
+	leaps, err := readLeapSeconds(path)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(leaps))
+
+	event2017 := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	event2024 := time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	offset, flags, next := leapState(leaps, time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, 10*time.Second, offset)
+	require.Equal(t, ptp.FlagCurrentUtcOffsetValid, flags)
+	require.Equal(t, time.Date(1972, time.June, 30, 0, 0, 0, 0, time.UTC), next.UTC())
+
+	// a day before the leap second
+	offset, flags, next = leapState(leaps, event2017.Add(-25*time.Hour))
+	require.Equal(t, 36*time.Second, offset)
+	require.Equal(t, ptp.FlagCurrentUtcOffsetValid, flags)
+	require.Equal(t, event2017.Add(-leapWindow), next.UTC())
+
+	// last UTC day before the leap second
+	offset, flags, next = leapState(leaps, event2017.Add(-time.Hour))
+	require.Equal(t, 36*time.Second, offset)
+	require.Equal(t, ptp.FlagCurrentUtcOffsetValid|ptp.FlagLeap61, flags)
+	require.Equal(t, event2017, next.UTC())
+
+	// after the leap second flags are cleared
+	offset, flags, _ = leapState(leaps, event2017)
+	require.Equal(t, 37*time.Second, offset)
+	require.Equal(t, ptp.FlagCurrentUtcOffsetValid, flags)
+
+	// negative leap second
+	_, flags, _ = leapState(leaps, event2024.Add(-time.Minute))
+	require.Equal(t, ptp.FlagCurrentUtcOffsetValid|ptp.FlagLeap59, flags)
+	offset, flags, next = leapState(leaps, event2024)
+	require.Equal(t, 36*time.Second, offset)
+	require.Equal(t, ptp.FlagCurrentUtcOffsetValid, flags)
+	require.True(t, next.IsZero())
+}
+
+func TestReadLeapSecondsTZif(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, leapsectz.Write(&buf, '2', []leapsectz.LeapSecond{{Tleap: 78796800, Nleap: 1}}, ""))
+	path := filepath.Join(t.TempDir(), "UTC")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	leaps, err := readLeapSeconds(path)
+	require.NoError(t, err)
+	require.Equal(t, []leapsectz.LeapSecond{{Tleap: 78796800, Nleap: 1}}, leaps)
+
+	_, err = readLeapSeconds(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestAnnounceLeapFlags(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), UTCOffset: 37 * time.Second}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Time{})
+
+	sc.UpdateAnnounce()
+	require.Equal(t, ptp.FlagUnicast|ptp.FlagPTPTimescale, sc.Announce().FlagField)
+
+	c.setLeap(37*time.Second, ptp.FlagCurrentUtcOffsetValid|ptp.FlagLeap61)
+	sc.UpdateAnnounce()
+	require.Equal(t, ptp.FlagUnicast|ptp.FlagPTPTimescale|ptp.FlagCurrentUtcOffsetValid|ptp.FlagLeap61, sc.Announce().FlagField)
+
+	c.setLeap(38*time.Second, ptp.FlagCurrentUtcOffsetValid)
+	sc.UpdateAnnounce()
+	require.Equal(t, ptp.FlagUnicast|ptp.FlagPTPTimescale|ptp.FlagCurrentUtcOffsetValid, sc.Announce().FlagField)
+	require.Equal(t, int16(38), sc.Announce().CurrentUTCOffset)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have structured logging of the subscribers. Every line about a subscriber carries
+// its IP, clock identity, domain and message type as fields, and lines of each level are
+// rate limited to keep the server (and its logs) alive during event storms.
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+)
+
+// logLimiter counts lines of each level logged within the current second
+type logLimiter struct {
+	sync.Mutex
+	start   time.Time
+	counts  map[log.Level]int
+	dropped map[log.Level]int
+}
+
+// allow reports if one more line of the level fits into limit per second.
+// Number of lines dropped is reported once the second is over.
+func (l *logLimiter) allow(level log.Level, limit int, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if now.Sub(l.start) >= time.Second {
+		for lvl, n := range l.dropped {
+			log.Warningf("Dropped %d %s lines about subscribers over the limit of %d per second", n, lvl, limit)
+		}
+		l.start = now
+		l.counts = map[log.Level]int{}
+		l.dropped = map[log.Level]int{}
+	}
+	if l.counts[level] >= limit {
+		l.dropped[level]++
+		return false
+	}
+	l.counts[level]++
+	return true
+}
+
+// subscriberFields returns log fields identifying the subscriber
+func subscriberFields(ip net.IP, clientID ptp.PortIdentity, domain uint8, st ptp.MessageType) log.Fields {
+	return log.Fields{
+		"client_ip": ip.String(),
+		"clock_id":  clientID.String(),
+		"domain":    domain,
+		"msg_type":  st.String(),
+	}
+}
+
+// logSubscriber logs the line with subscriber fields unless lines of the level are over the rate limit
+func (c *Config) logSubscriber(level log.Level, fields log.Fields, format string, args ...interface{}) {
+	if !log.IsLevelEnabled(level) || !c.logLimit.allow(level, c.LogRateLimit, time.Now()) {
+		return
+	}
+	log.WithFields(fields).Logf(level, format, args...)
+}
+
+// logFields returns log fields identifying the client of the subscription
+func (sc *SubscriptionClient) logFields() log.Fields {
+	sc.Lock()
+	defer sc.Unlock()
+	return subscriberFields(timestamp.SockaddrToIP(sc.gclisa), sc.clientID, sc.announceP.DomainNumber, sc.subscriptionType)
+}
+
+// log logs the line about the client of the subscription
+func (sc *SubscriptionClient) log(level log.Level, format string, args ...interface{}) {
+	if !log.IsLevelEnabled(level) {
+		return
+	}
+	sc.serverConfig.logSubscriber(level, sc.logFields(), format, args...)
+}
+
+// setClientID sets identity of the client the subscription belongs to
+func (sc *SubscriptionClient) setClientID(clientID ptp.PortIdentity) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.clientID = clientID
+}
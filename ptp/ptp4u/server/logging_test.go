@@ -0,0 +1,92 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLimiter(t *testing.T) {
+	l := &logLimiter{}
+	now := time.Now()
+	require.True(t, l.allow(log.ErrorLevel, 0, now))
+
+	require.True(t, l.allow(log.ErrorLevel, 2, now))
+	require.True(t, l.allow(log.ErrorLevel, 2, now))
+	require.False(t, l.allow(log.ErrorLevel, 2, now))
+	// levels are limited separately
+	require.True(t, l.allow(log.WarnLevel, 2, now))
+	require.Equal(t, 1, l.dropped[log.ErrorLevel])
+
+	now = now.Add(time.Second)
+	require.True(t, l.allow(log.ErrorLevel, 2, now))
+	require.Equal(t, 0, l.dropped[log.ErrorLevel])
+}
+
+func TestSubscriptionLogFields(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	w := &sendWorker{clients: map[ptp.MessageType]map[ptp.PortIdentity]*SubscriptionClient{}}
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Now())
+	sc.setDomain(DomainConfig{DomainNumber: 24})
+	clientID := ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}
+	w.RegisterSubscription(clientID, ptp.MessageAnnounce, sc)
+
+	require.Equal(t, log.Fields{
+		"client_ip": "127.0.0.1",
+		"clock_id":  clientID.String(),
+		"domain":    uint8(24),
+		"msg_type":  "ANNOUNCE",
+	}, sc.logFields())
+}
+
+func TestLogSubscriber(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFormatter(&log.JSONFormatter{})
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFormatter(&log.TextFormatter{})
+
+	c := &Config{LogRateLimit: 1}
+	fields := subscriberFields(net.ParseIP("::1"), ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}, 0, ptp.MessageSync)
+	c.logSubscriber(log.ErrorLevel, fields, "Failed: %v", "oops")
+	c.logSubscriber(log.ErrorLevel, fields, "Failed: %v", "dropped")
+	// below the log level
+	c.logSubscriber(log.TraceLevel, fields, "Traced")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	got := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	require.Equal(t, "Failed: oops", got["msg"])
+	require.Equal(t, "error", got["level"])
+	require.Equal(t, "::1", got["client_ip"])
+	require.Equal(t, fields["clock_id"], got["clock_id"])
+	require.Equal(t, float64(0), got["domain"])
+	require.Equal(t, "SYNC", got["msg_type"])
+}
@@ -0,0 +1,185 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have multicast mode of the server. Multicast Sync and Announce are sent by
+// the regular send workers as never expiring subscriptions to the multicast group,
+// and Delay_Req from multicast clients are answered without prior negotiation.
+// In hybrid mode Delay_Resp are sent via unicast directly to the requesting client.
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// multicastDelayRespExpire is how long we keep the state of multicast client after its last Delay_Req
+const multicastDelayRespExpire = time.Minute
+
+// multicastNever is expiration time of multicast subscriptions
+var multicastNever = time.Unix(1<<62, 0)
+
+// Multicast checks if the server sends multicast messages
+func (c *Config) Multicast() bool {
+	return c.MulticastSyncInterval > 0 || c.MulticastAnnounceInterval > 0
+}
+
+// multicastGroup returns multicast group messages of given type are sent to, matching IP family of the server
+func (c *Config) multicastGroup(msgType ptp.MessageType) (net.IP, error) {
+	proto := ptp.NetworkProtocolUDPIPv6
+	if c.IP.To4() != nil {
+		proto = ptp.NetworkProtocolUDPIPv4
+	}
+	return ptp.MulticastIP(msgType, proto, ptp.IPv6ScopeGlobal)
+}
+
+// NewMulticastSubscriptionClient creates a never expiring subscription sending messages of given type to the multicast group
+func NewMulticastSubscriptionClient(q chan *SubscriptionClient, st ptp.MessageType, sc *Config, i time.Duration) (*SubscriptionClient, error) {
+	group, err := sc.multicastGroup(st)
+	if err != nil {
+		return nil, err
+	}
+	s := NewSubscriptionClient(q, timestamp.IPToSockaddr(group, ptp.PortEvent), timestamp.IPToSockaddr(group, ptp.PortGeneral), st, sc, i, multicastNever)
+	s.syncP.FlagField &^= ptp.FlagUnicast
+	s.followupP.FlagField &^= ptp.FlagUnicast
+	s.announceP.FlagField &^= ptp.FlagUnicast
+	// multicast clients learn the rate of Sync from the messages themselves
+	if interval, err := ptp.NewLogInterval(i); err == nil {
+		s.syncP.LogMessageInterval = interval
+	}
+	return s, nil
+}
+
+// startMulticast launches multicast Sync and Announce subscriptions
+func (s *Server) startMulticast() error {
+	subs := []struct {
+		msgType  ptp.MessageType
+		interval time.Duration
+	}{
+		{ptp.MessageSync, s.Config.MulticastSyncInterval},
+		{ptp.MessageAnnounce, s.Config.MulticastAnnounceInterval},
+	}
+	for i, sub := range subs {
+		if sub.interval <= 0 {
+			continue
+		}
+		worker := s.sw[i%len(s.sw)]
+		sc, err := NewMulticastSubscriptionClient(worker.queue, sub.msgType, s.Config, sub.interval)
+		if err != nil {
+			return err
+		}
+		// multicast subscriptions don't belong to any client
+		worker.RegisterSubscription(ptp.PortIdentity{}, sub.msgType, sc)
+		log.Infof("Sending multicast %s every %v", sub.msgType, sub.interval)
+		go sc.Start()
+	}
+	return nil
+}
+
+// multicastDelayResp returns where Delay_Resp to the multicast client are sent.
+// In hybrid mode they go via unicast to the client, otherwise to the multicast group.
+func (c *Config) multicastDelayResp(clisa unix.Sockaddr) (unix.Sockaddr, bool, error) {
+	if c.MulticastHybrid {
+		return c.replySockaddr(timestamp.SockaddrToIP(clisa), ptp.PortGeneral), true, nil
+	}
+	group, err := c.multicastGroup(ptp.MessageDelayResp)
+	if err != nil {
+		return nil, false, err
+	}
+	return timestamp.IPToSockaddr(group, ptp.PortGeneral), false, nil
+}
+
+// multicastDelayRespClient returns delay response subscription for multicast client, creating it if needed.
+// It also reports if the Delay_Req just received from the client is within the allowed rate.
+func (s *Server) multicastDelayRespClient(worker *sendWorker, clientID ptp.PortIdentity, clisa unix.Sockaddr) (*SubscriptionClient, bool) {
+	now := time.Now()
+	sc := worker.FindSubscription(clientID, ptp.MessageDelayResp)
+	if sc == nil {
+		gclisa, unicast, err := s.Config.multicastDelayResp(clisa)
+		if err != nil {
+			s.Config.logSubscriber(log.ErrorLevel, subscriberFields(timestamp.SockaddrToIP(clisa), clientID, s.Config.DomainNumber, ptp.MessageDelayResp), "Failed to find Delay_Resp destination: %v", err)
+			return nil, false
+		}
+		sc = NewSubscriptionClient(worker.queue, clisa, gclisa, ptp.MessageDelayResp, s.Config, time.Second, now)
+		if !unicast {
+			sc.delayRespP.FlagField &^= ptp.FlagUnicast
+		}
+		// tell the client how often it may send Delay_Req
+		if interval, err := ptp.NewLogInterval(s.Config.MinSubInterval); err == nil && s.Config.MinSubInterval > 0 {
+			sc.delayRespP.LogMessageInterval = interval
+		}
+		sc.multicast = true
+		worker.RegisterSubscription(clientID, ptp.MessageDelayResp, sc)
+	}
+	sc.setExpire(now.Add(multicastDelayRespExpire))
+	if !sc.Running() {
+		go sc.Start()
+	}
+	return sc, sc.delayReqAllowed(now, s.Config.MinSubInterval)
+}
+
+// joinMulticast subscribes the connection to the multicast group receiving messages of given type from multicast clients
+func joinMulticast(conn *net.UDPConn, c *Config, msgType ptp.MessageType) error {
+	iface, err := net.InterfaceByName(c.Interface)
+	if err != nil {
+		return err
+	}
+	group, err := c.multicastGroup(msgType)
+	if err != nil {
+		return err
+	}
+	sc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = sc.Control(func(fd uintptr) {
+		if ip4 := group.To4(); ip4 != nil {
+			mreq := &unix.IPMreqn{Ifindex: int32(iface.Index)}
+			copy(mreq.Multiaddr[:], ip4)
+			serr = unix.SetsockoptIPMreqn(int(fd), unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, mreq)
+			return
+		}
+		mreq := &unix.IPv6Mreq{Interface: uint32(iface.Index)}
+		copy(mreq.Multiaddr[:], group.To16())
+		serr = unix.SetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, mreq)
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to join multicast group %s on %s: %w", group, c.Interface, err)
+	}
+	return nil
+}
+
+// setMulticastInterface makes sure multicast messages sent via fd go out of the configured interface
+func setMulticastInterface(fd int, c *Config) error {
+	iface, err := net.InterfaceByName(c.Interface)
+	if err != nil {
+		return err
+	}
+	if c.IP.To4() != nil {
+		return unix.SetsockoptIPMreqn(fd, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, &unix.IPMreqn{Ifindex: int32(iface.Index)})
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, iface.Index)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigMulticast(t *testing.T) {
+	c := &Config{IP: net.ParseIP("::")}
+	require.False(t, c.Multicast())
+	c.MulticastSyncInterval = time.Second
+	require.True(t, c.Multicast())
+
+	group, err := c.multicastGroup(ptp.MessageSync)
+	require.Nil(t, err)
+	require.Equal(t, "ff0e::181", group.String())
+
+	c.IP = net.ParseIP("192.168.0.1")
+	group, err = c.multicastGroup(ptp.MessageAnnounce)
+	require.Nil(t, err)
+	require.Equal(t, "224.0.1.129", group.String())
+}
+
+func TestNewMulticastSubscriptionClient(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), IP: net.ParseIP("192.168.0.1")}
+	sc, err := NewMulticastSubscriptionClient(w.queue, ptp.MessageSync, c, 250*time.Millisecond)
+	require.Nil(t, err)
+	require.Equal(t, timestamp.IPToSockaddr(ptp.MulticastIPv4, ptp.PortEvent), sc.eclisa)
+	require.Equal(t, timestamp.IPToSockaddr(ptp.MulticastIPv4, ptp.PortGeneral), sc.gclisa)
+	require.Equal(t, ptp.FlagTwoStep, sc.Sync().FlagField)
+	require.Equal(t, ptp.LogInterval(-2), sc.Sync().LogMessageInterval)
+	require.Equal(t, uint16(0), sc.Followup().FlagField)
+	require.Equal(t, ptp.FlagPTPTimescale, sc.Announce().FlagField)
+	require.False(t, sc.Expired())
+}
+
+func TestMulticastDelayRespClient(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), IP: net.ParseIP("192.168.0.1")}
+	w := &sendWorker{
+		queue:   make(chan *SubscriptionClient),
+		clients: make(map[ptp.MessageType]map[ptp.PortIdentity]*SubscriptionClient),
+	}
+	s := &Server{Config: c, sw: []*sendWorker{w}}
+	clientID := ptp.PortIdentity{PortNumber: 1, ClockIdentity: 5678}
+	clisa := timestamp.IPToSockaddr(net.ParseIP("192.168.0.2"), 12345)
+
+	sc, allowed := s.multicastDelayRespClient(w, clientID, clisa)
+	require.True(t, allowed)
+	require.True(t, sc.multicast)
+	require.Equal(t, sc, w.FindSubscription(clientID, ptp.MessageDelayResp))
+	require.Equal(t, timestamp.IPToSockaddr(ptp.MulticastIPv4, ptp.PortGeneral), sc.gclisa)
+	require.Equal(t, uint16(0), sc.DelayResp().FlagField&ptp.FlagUnicast)
+	require.False(t, sc.Expired())
+
+	// subscription is reused
+	sc2, allowed := s.multicastDelayRespClient(w, clientID, clisa)
+	require.True(t, allowed)
+	require.Equal(t, sc, sc2)
+	sc.Stop()
+}
+
+func TestMulticastDelayRespClientHybrid(t *testing.T) {
+	c := &Config{
+		clockIdentity:   ptp.ClockIdentity(1234),
+		IP:              net.ParseIP("192.168.0.1"),
+		MinSubInterval:  time.Second,
+		MulticastHybrid: true,
+	}
+	w := &sendWorker{
+		queue:   make(chan *SubscriptionClient),
+		clients: make(map[ptp.MessageType]map[ptp.PortIdentity]*SubscriptionClient),
+	}
+	s := &Server{Config: c, sw: []*sendWorker{w}}
+	clientID := ptp.PortIdentity{PortNumber: 1, ClockIdentity: 5678}
+	clisa := timestamp.IPToSockaddr(net.ParseIP("192.168.0.2"), 12345)
+
+	sc, allowed := s.multicastDelayRespClient(w, clientID, clisa)
+	require.True(t, allowed)
+	require.Equal(t, timestamp.IPToSockaddr(net.ParseIP("192.168.0.2"), ptp.PortGeneral), sc.gclisa)
+	require.Equal(t, ptp.FlagUnicast, sc.DelayResp().FlagField&ptp.FlagUnicast)
+	require.Equal(t, ptp.LogInterval(0), sc.DelayResp().LogMessageInterval)
+
+	// burst is tolerated, flood is not
+	_, allowed = s.multicastDelayRespClient(w, clientID, clisa)
+	require.True(t, allowed)
+	_, allowed = s.multicastDelayRespClient(w, clientID, clisa)
+	require.False(t, allowed)
+	sc.Stop()
+}
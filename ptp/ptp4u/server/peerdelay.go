@@ -0,0 +1,99 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have peer delay responder. Pdelay_Req are answered with two-step
+// Pdelay_Resp and Pdelay_Resp_Follow_Up, so the server can be a neighbor of
+// clients using peer delay mechanism.
+
+import (
+	"encoding/binary"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	"golang.org/x/sys/unix"
+)
+
+// peerDelayRespExpire is how long we keep the state of peer delay client after its last Pdelay_Req
+const peerDelayRespExpire = time.Minute
+
+// peerDelayRespClient returns peer delay response subscription for the client, creating it if needed.
+// Requests sent via multicast are answered via multicast as well.
+func (s *Server) peerDelayRespClient(worker *sendWorker, h *ptp.Header, clisa unix.Sockaddr) *SubscriptionClient {
+	expire := time.Now().Add(peerDelayRespExpire)
+	sc := worker.FindSubscription(h.SourcePortIdentity, ptp.MessagePDelayResp)
+	if sc == nil {
+		ip := timestamp.SockaddrToIP(clisa)
+		eclisa, gclisa := clisa, s.Config.replySockaddr(ip, ptp.PortGeneral)
+		if h.FlagField&ptp.FlagUnicast == 0 {
+			group, err := s.Config.multicastGroup(ptp.MessagePDelayResp)
+			if err == nil {
+				eclisa, gclisa = timestamp.IPToSockaddr(group, ptp.PortEvent), timestamp.IPToSockaddr(group, ptp.PortGeneral)
+			}
+		}
+		sc = NewSubscriptionClient(worker.queue, eclisa, gclisa, ptp.MessagePDelayResp, s.Config, time.Second, expire)
+		worker.RegisterSubscription(h.SourcePortIdentity, ptp.MessagePDelayResp, sc)
+	}
+	sc.setExpire(expire)
+	if !sc.Running() {
+		go sc.Start()
+	}
+	return sc
+}
+
+func (sc *SubscriptionClient) initPDelayResp() {
+	sc.pdelayRespP = &ptp.PDelayResp{
+		Header: sc.builder().Header(ptp.MessagePDelayResp, binary.Size(ptp.PDelayResp{}), 0x7f),
+	}
+	sc.pdelayRespFollowUpP = &ptp.PDelayRespFollowUp{
+		Header: sc.builder().Header(ptp.MessagePDelayRespFollowUp, binary.Size(ptp.PDelayRespFollowUp{}), 0x7f),
+	}
+}
+
+// UpdatePDelayResp updates ptp Peer Delay Response packet answering the request received at the given time
+func (sc *SubscriptionClient) UpdatePDelayResp(h *ptp.Header, received time.Time) {
+	// we always answer in two steps, unicast only if asked via unicast
+	flags := ptp.FlagTwoStep | h.FlagField&ptp.FlagUnicast
+	sc.pdelayRespP.SequenceID = h.SequenceID
+	sc.pdelayRespP.DomainNumber = h.DomainNumber
+	sc.pdelayRespP.FlagField = flags
+	sc.pdelayRespP.PDelayRespBody = ptp.PDelayRespBody{
+		RequestReceiptTimestamp: ptp.NewTimestamp(received),
+		RequestingPortIdentity:  h.SourcePortIdentity,
+	}
+	sc.pdelayRespFollowUpP.SequenceID = h.SequenceID
+	sc.pdelayRespFollowUpP.DomainNumber = h.DomainNumber
+	sc.pdelayRespFollowUpP.FlagField = h.FlagField & ptp.FlagUnicast
+	sc.pdelayRespFollowUpP.CorrectionField = h.CorrectionField
+	sc.pdelayRespFollowUpP.RequestingPortIdentity = h.SourcePortIdentity
+}
+
+// PDelayResp returns ptp Peer Delay Response packet
+func (sc *SubscriptionClient) PDelayResp() *ptp.PDelayResp {
+	return sc.pdelayRespP
+}
+
+// UpdatePDelayRespFollowUp updates ptp Peer Delay Response Follow Up packet with TX timestamp of the response
+func (sc *SubscriptionClient) UpdatePDelayRespFollowUp(hwts time.Time) {
+	sc.pdelayRespFollowUpP.ResponseOriginTimestamp = ptp.NewTimestamp(hwts)
+}
+
+// PDelayRespFollowUp returns ptp Peer Delay Response Follow Up packet
+func (sc *SubscriptionClient) PDelayRespFollowUp() *ptp.PDelayRespFollowUp {
+	return sc.pdelayRespFollowUpP
+}
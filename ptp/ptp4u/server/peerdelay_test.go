@@ -0,0 +1,98 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPDelayRespPackets(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessagePDelayResp, c, time.Second, time.Time{})
+
+	h := &ptp.Header{
+		SequenceID:         42,
+		DomainNumber:       24,
+		FlagField:          ptp.FlagUnicast,
+		CorrectionField:    ptp.NewCorrection(100),
+		SourcePortIdentity: ptp.PortIdentity{PortNumber: 1, ClockIdentity: 5678},
+	}
+	rx := time.Unix(1653574589, 806097046)
+	tx := rx.Add(time.Microsecond)
+	sc.UpdatePDelayResp(h, rx)
+	sc.UpdatePDelayRespFollowUp(tx)
+
+	resp := sc.PDelayResp()
+	require.Equal(t, ptp.MessagePDelayResp, resp.SdoIDAndMsgType.MsgType())
+	require.Equal(t, uint16(42), resp.SequenceID)
+	require.Equal(t, uint8(24), resp.DomainNumber)
+	require.Equal(t, ptp.FlagTwoStep|ptp.FlagUnicast, resp.FlagField)
+	require.Equal(t, ptp.NewTimestamp(rx), resp.RequestReceiptTimestamp)
+	require.Equal(t, h.SourcePortIdentity, resp.RequestingPortIdentity)
+	require.Equal(t, ptp.PortIdentity{PortNumber: 1, ClockIdentity: 1234}, resp.SourcePortIdentity)
+
+	fup := sc.PDelayRespFollowUp()
+	require.Equal(t, ptp.MessagePDelayRespFollowUp, fup.SdoIDAndMsgType.MsgType())
+	require.Equal(t, uint16(42), fup.SequenceID)
+	require.Equal(t, ptp.FlagUnicast, fup.FlagField)
+	require.Equal(t, h.CorrectionField, fup.CorrectionField)
+	require.Equal(t, ptp.NewTimestamp(tx), fup.ResponseOriginTimestamp)
+	require.Equal(t, h.SourcePortIdentity, fup.RequestingPortIdentity)
+
+	b, err := ptp.Bytes(resp)
+	require.NoError(t, err)
+	require.Equal(t, int(resp.MessageLength)+2, len(b))
+	b, err = ptp.Bytes(fup)
+	require.NoError(t, err)
+	require.Equal(t, int(fup.MessageLength)+2, len(b))
+}
+
+func TestPeerDelayRespClient(t *testing.T) {
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), IP: net.ParseIP("192.168.0.1")}
+	w := &sendWorker{
+		queue:   make(chan *SubscriptionClient),
+		clients: make(map[ptp.MessageType]map[ptp.PortIdentity]*SubscriptionClient),
+	}
+	s := &Server{Config: c, sw: []*sendWorker{w}}
+	clisa := timestamp.IPToSockaddr(net.ParseIP("192.168.0.2"), ptp.PortEvent)
+
+	// unicast request is answered via unicast
+	h := &ptp.Header{FlagField: ptp.FlagUnicast, SourcePortIdentity: ptp.PortIdentity{PortNumber: 1, ClockIdentity: 5678}}
+	sc := s.peerDelayRespClient(w, h, clisa)
+	require.Equal(t, sc, w.FindSubscription(h.SourcePortIdentity, ptp.MessagePDelayResp))
+	require.Equal(t, clisa, sc.eclisa)
+	require.Equal(t, timestamp.IPToSockaddr(net.ParseIP("192.168.0.2"), ptp.PortGeneral), sc.gclisa)
+	require.False(t, sc.Expired())
+	require.Equal(t, sc, s.peerDelayRespClient(w, h, clisa))
+	sc.Stop()
+
+	// multicast request is answered via multicast
+	h = &ptp.Header{SourcePortIdentity: ptp.PortIdentity{PortNumber: 1, ClockIdentity: 9012}}
+	sc = s.peerDelayRespClient(w, h, clisa)
+	require.Equal(t, timestamp.IPToSockaddr(ptp.PeerDelayMulticastIPv4, ptp.PortEvent), sc.eclisa)
+	require.Equal(t, timestamp.IPToSockaddr(ptp.PeerDelayMulticastIPv4, ptp.PortGeneral), sc.gclisa)
+	sc.Stop()
+}
@@ -0,0 +1,213 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+// Here we have persistence of the grant table. It's periodically saved to the state file
+// and restored on startup, so restart of the server doesn't make all clients renegotiate at once.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+)
+
+// savedSubscription is a granted subscription as stored in the state file
+type savedSubscription struct {
+	ClientID string          `json:"client_id"`
+	IP       string          `json:"ip"`
+	Port     int             `json:"port"`
+	Type     ptp.MessageType `json:"type"`
+	Domain   uint8           `json:"domain"`
+	Version  uint8           `json:"version"`
+	Interval time.Duration   `json:"interval"`
+	Expire   time.Time       `json:"expire"`
+	Src      string          `json:"src,omitempty"`
+}
+
+// saved returns the subscription as stored in the state file
+func (sc *SubscriptionClient) saved() savedSubscription {
+	sc.Lock()
+	defer sc.Unlock()
+	src := ""
+	if len(sc.src) > 0 {
+		src = sc.src.String()
+	}
+	return savedSubscription{
+		ClientID: sc.clientID.String(),
+		IP:       timestamp.SockaddrToIP(sc.gclisa).String(),
+		Port:     sockaddrPort(sc.gclisa),
+		Type:     sc.subscriptionType,
+		Domain:   sc.announceP.DomainNumber,
+		Version:  sc.announceP.Version,
+		Interval: sc.interval,
+		Expire:   sc.expire,
+		Src:      src,
+	}
+}
+
+// grants returns all running granted subscriptions of the worker
+func (s *sendWorker) grants() []savedSubscription {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	res := []savedSubscription{}
+	for _, subs := range s.clients {
+		for _, sc := range subs {
+			if sc.granted && sc.Running() {
+				res = append(res, sc.saved())
+			}
+		}
+	}
+	return res
+}
+
+// saveSubscriptions atomically writes all granted subscriptions to the state file
+func (s *Server) saveSubscriptions() error {
+	subs := []savedSubscription{}
+	for _, w := range s.workers() {
+		subs = append(subs, w.grants()...)
+	}
+	b, err := json.Marshal(subs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Config.StateFile, b)
+}
+
+// writeFileAtomic writes the file via temporary one, so it's never seen half written
+func writeFileAtomic(name string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// restoreSubscriptions starts subscriptions saved in the state file which are still allowed and not expired
+func (s *Server) restoreSubscriptions() (int, error) {
+	b, err := os.ReadFile(s.Config.StateFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	subs := []savedSubscription{}
+	if err := json.Unmarshal(b, &subs); err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", s.Config.StateFile, err)
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now()
+	restored := 0
+	for _, saved := range subs {
+		if err := s.restoreSubscription(saved, now, r); err != nil {
+			log.Warningf("Not restoring %s subscription of %s: %v", saved.Type, saved.ClientID, err)
+			continue
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// restoreSubscription starts the saved subscription as if it was just granted for the rest of its duration
+func (s *Server) restoreSubscription(saved savedSubscription, now time.Time, r *rand.Rand) error {
+	clientID, err := ptp.ParsePortIdentity(saved.ClientID)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(saved.IP)
+	if ip == nil {
+		return fmt.Errorf("invalid IP %q", saved.IP)
+	}
+	remaining := saved.Expire.Sub(now)
+	if remaining <= 0 {
+		return fmt.Errorf("expired")
+	}
+	switch saved.Type {
+	case ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp:
+	default:
+		return fmt.Errorf("unsupported grant type")
+	}
+	if !s.Config.ACL.Allowed(ip) {
+		return fmt.Errorf("denied by ACL")
+	}
+	interval, err := ptp.NewLogInterval(saved.Interval)
+	if err != nil {
+		return err
+	}
+	// the policy of the client's group may have changed since the grant
+	duration := uint32(remaining / time.Second)
+	interval, clamped := s.Config.Policies.Clamp(ip, clientID.ClockIdentity, saved.Type, interval, duration)
+	expire := saved.Expire
+	if clamped != duration {
+		remaining = time.Duration(clamped) * time.Second
+		expire = now.Add(remaining)
+	}
+	if !s.Config.subscriptionAllowed(saved.Type, interval, remaining) {
+		return fmt.Errorf("interval %v is out of limits", interval.Duration())
+	}
+
+	worker := s.findDomainWorker(saved.Domain, clientID, r)
+	if worker.FindSubscription(clientID, saved.Type) != nil {
+		return fmt.Errorf("already subscribed")
+	}
+	// never evict on restore: the general sockets are not bound yet to send the cancel
+	if !s.hasCapacity(saved.Type) {
+		s.serverStats().IncCapacityRejected(saved.Type)
+		return fmt.Errorf("server is at capacity")
+	}
+	gclisa := s.Config.replySockaddr(ip, saved.Port)
+	sc := NewSubscriptionClient(worker.queue, s.Config.replySockaddr(ip, ptp.PortEvent), gclisa, saved.Type, s.Config, interval.Duration(), expire)
+	// the source is only chosen by the server when it listens on any address, it may not anymore
+	if src := net.ParseIP(saved.Src); src != nil && s.Config.boundToAny(gclisa) {
+		sc.setSource(src)
+	}
+	if d, ok := s.Config.extraDomain(saved.Domain); ok {
+		sc.setDomain(d)
+	}
+	sc.setVersion(ptp.NegotiateVersion(s.Config.ptpVersion(), saved.Version))
+	sc.granted = true
+	worker.RegisterSubscription(clientID, saved.Type, sc)
+	s.serverStats().IncGrantNew(saved.Type)
+	go sc.Start()
+	return nil
+}
+
+// persistSubscriptions saves granted subscriptions to the state file periodically
+func (s *Server) persistSubscriptions() {
+	for {
+		<-time.After(s.Config.StateInterval)
+		if err := s.saveSubscriptions(); err != nil {
+			log.Errorf("Failed to save subscriptions to %s: %v", s.Config.StateFile, err)
+		}
+	}
+}
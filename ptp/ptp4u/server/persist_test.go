@@ -0,0 +1,211 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/ptp/ptp4u/stats"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func persistTestServer(t *testing.T) (*Server, *sendWorker) {
+	c := &Config{
+		clockIdentity:  ptp.ClockIdentity(1234),
+		IP:             net.ParseIP("127.0.0.1"),
+		SendWorkers:    1,
+		MinSubInterval: time.Millisecond,
+		MaxSubDuration: time.Hour,
+		StateFile:      filepath.Join(t.TempDir(), "ptp4u.state"),
+	}
+	st := stats.NewJSONStats()
+	w := NewSendWorker(0, c, st)
+	return &Server{Config: c, Stats: st, sw: []*sendWorker{w}}, w
+}
+
+func TestSaveRestoreSubscriptions(t *testing.T) {
+	s, w := persistTestServer(t)
+	expire := time.Now().Add(time.Minute).Round(time.Second)
+	clientID := ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}
+	gclisa := timestamp.IPToSockaddr(net.ParseIP("192.168.0.1"), 32000)
+	sc := NewSubscriptionClient(w.queue, timestamp.IPToSockaddr(net.ParseIP("192.168.0.1"), ptp.PortEvent), gclisa, ptp.MessageSync, s.Config, time.Second, expire)
+	sc.granted = true
+	sc.setRunning(true)
+	w.RegisterSubscription(clientID, ptp.MessageSync, sc)
+	// not granted subscriptions are not saved
+	other := NewSubscriptionClient(w.queue, gclisa, gclisa, ptp.MessageDelayResp, s.Config, time.Second, expire)
+	other.setRunning(true)
+	w.RegisterSubscription(clientID, ptp.MessageDelayResp, other)
+
+	require.NoError(t, s.saveSubscriptions())
+	require.Equal(t, []savedSubscription{
+		{
+			ClientID: clientID.String(),
+			IP:       "192.168.0.1",
+			Port:     32000,
+			Type:     ptp.MessageSync,
+			Version:  ptp.Version,
+			Interval: time.Second,
+			Expire:   expire,
+		},
+	}, w.grants())
+
+	restarted, rw := persistTestServer(t)
+	restarted.Config.StateFile = s.Config.StateFile
+	n, err := restarted.restoreSubscriptions()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	got := rw.FindSubscription(clientID, ptp.MessageSync)
+	require.NotNil(t, got)
+	require.True(t, got.granted)
+	require.Equal(t, gclisa, got.gclisa)
+	require.Equal(t, time.Second, got.interval)
+	require.True(t, expire.Equal(got.expire))
+	require.Nil(t, rw.FindSubscription(clientID, ptp.MessageDelayResp))
+	got.Stop()
+}
+
+func TestRestoreSubscriptionsMissingFile(t *testing.T) {
+	s, _ := persistTestServer(t)
+	n, err := s.restoreSubscriptions()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	require.NoError(t, os.WriteFile(s.Config.StateFile, []byte("lol"), 0644))
+	_, err = s.restoreSubscriptions()
+	require.Error(t, err)
+}
+
+func TestRestoreSubscriptionSkipped(t *testing.T) {
+	s, w := persistTestServer(t)
+	now := time.Now()
+	saved := savedSubscription{
+		ClientID: ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}.String(),
+		IP:       "192.168.0.1",
+		Port:     ptp.PortGeneral,
+		Type:     ptp.MessageAnnounce,
+		Version:  ptp.Version,
+		Interval: time.Second,
+		Expire:   now.Add(time.Minute),
+	}
+
+	expired := saved
+	expired.Expire = now.Add(-time.Second)
+	require.Error(t, s.restoreSubscription(expired, now, nil))
+
+	tooLong := saved
+	tooLong.Expire = now.Add(2 * time.Hour)
+	require.Error(t, s.restoreSubscription(tooLong, now, nil))
+
+	badType := saved
+	badType.Type = ptp.MessageFollowUp
+	require.Error(t, s.restoreSubscription(badType, now, nil))
+
+	badID := saved
+	badID.ClientID = "lol"
+	require.Error(t, s.restoreSubscription(badID, now, nil))
+
+	require.Empty(t, w.grants())
+}
+
+func TestRestoreSubscriptionClamped(t *testing.T) {
+	s, w := persistTestServer(t)
+	p, err := ParsePolicies(strings.NewReader("192.168.0.0/16 mininterval=1s maxduration=60s\n"))
+	require.NoError(t, err)
+	s.Config.Policies = p
+	now := time.Now()
+	saved := savedSubscription{
+		ClientID: ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}.String(),
+		IP:       "192.168.0.1",
+		Port:     ptp.PortGeneral,
+		Type:     ptp.MessageSync,
+		Version:  ptp.Version,
+		Interval: 250 * time.Millisecond,
+		Expire:   now.Add(10 * time.Minute),
+	}
+	require.NoError(t, s.restoreSubscription(saved, now, rand.New(rand.NewSource(0))))
+
+	got := w.FindSubscription(ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}, ptp.MessageSync)
+	require.NotNil(t, got)
+	require.Equal(t, time.Second, got.Interval())
+	require.True(t, now.Add(time.Minute).Equal(got.Expire()))
+	got.Stop()
+}
+
+func TestRestoreSubscriptionNoEviction(t *testing.T) {
+	s, w := persistTestServer(t)
+	s.Config.MaxSubscribers = 1
+	s.Config.Eviction = EvictShortest
+	now := time.Now()
+	restored := grantedSubscription(w, s.Config, ptp.PortIdentity{ClockIdentity: 1}, now.Add(time.Minute))
+	saved := savedSubscription{
+		ClientID: ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}.String(),
+		IP:       "192.168.0.1",
+		Port:     ptp.PortGeneral,
+		Type:     ptp.MessageSync,
+		Version:  ptp.Version,
+		Interval: time.Second,
+		Expire:   now.Add(time.Hour),
+	}
+	require.Error(t, s.restoreSubscription(saved, now, rand.New(rand.NewSource(0))))
+	require.Equal(t, restored, w.FindSubscription(ptp.PortIdentity{ClockIdentity: 1}, ptp.MessageSync))
+	require.False(t, restored.Expired())
+	require.Nil(t, w.FindSubscription(ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}, ptp.MessageSync))
+}
+
+func TestRestoreSubscriptionSource(t *testing.T) {
+	s, w := persistTestServer(t)
+	now := time.Now()
+	saved := savedSubscription{
+		ClientID: ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}.String(),
+		IP:       "192.168.0.1",
+		Port:     ptp.PortGeneral,
+		Type:     ptp.MessageSync,
+		Version:  ptp.Version,
+		Interval: time.Second,
+		Expire:   now.Add(time.Minute),
+		Src:      "192.168.0.2",
+	}
+	r := rand.New(rand.NewSource(0))
+
+	// server listening on a specific address doesn't pick the source
+	require.NoError(t, s.restoreSubscription(saved, now, r))
+	got := w.FindSubscription(ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 1}, ptp.MessageSync)
+	require.NotNil(t, got)
+	require.Empty(t, got.src)
+	require.Empty(t, got.saved().Src)
+	got.Stop()
+
+	s.Config.IP = net.IPv4zero
+	saved.ClientID = ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 2}.String()
+	require.NoError(t, s.restoreSubscription(saved, now, r))
+	got = w.FindSubscription(ptp.PortIdentity{ClockIdentity: 5678, PortNumber: 2}, ptp.MessageSync)
+	require.NotNil(t, got)
+	require.Equal(t, "192.168.0.2", got.src.String())
+	require.NotEmpty(t, got.srcOOB)
+	require.Equal(t, "192.168.0.2", got.saved().Src)
+	got.Stop()
+}
@@ -0,0 +1,220 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// PolicyRule limits grants requested by clients within the prefix or with the clock identity
+type PolicyRule struct {
+	Prefix        *net.IPNet
+	ClockIdentity ptp.ClockIdentity
+	// MsgTypes the rule applies to, empty means all
+	MsgTypes []ptp.MessageType
+	// MinInterval is the shortest interval granted, 0 doesn't limit it
+	MinInterval time.Duration
+	// MaxDuration is the longest duration granted, 0 doesn't limit it
+	MaxDuration time.Duration
+}
+
+// matches checks if the rule applies to the grant request
+func (r *PolicyRule) matches(ip net.IP, clockID ptp.ClockIdentity, t ptp.MessageType) bool {
+	if r.Prefix != nil && !r.Prefix.Contains(ip) {
+		return false
+	}
+	if r.Prefix == nil && r.ClockIdentity != clockID {
+		return false
+	}
+	if len(r.MsgTypes) == 0 {
+		return true
+	}
+	for _, mt := range r.MsgTypes {
+		if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Policies clamp intervals and durations of grants requested by groups of clients.
+// The first rule matching the client applies.
+type Policies struct {
+	sync.RWMutex
+	path  string
+	rules []*PolicyRule
+}
+
+// NewPolicies creates Policies from the rules
+func NewPolicies(rules []*PolicyRule) *Policies {
+	return &Policies{rules: rules}
+}
+
+// parseGrantType parses type of the message clients subscribe to
+func parseGrantType(s string) (ptp.MessageType, error) {
+	for _, mt := range []ptp.MessageType{ptp.MessageSync, ptp.MessageAnnounce, ptp.MessageDelayResp} {
+		if strings.EqualFold(mt.String(), s) {
+			return mt, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported message type %q", s)
+}
+
+// parsePolicyRule parses rule in the form of '<prefix|clock identity> [type=<types>] [mininterval=<duration>] [maxduration=<duration>]'
+func parsePolicyRule(text string) (*PolicyRule, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected '<prefix|clock identity> <key>=<value>...', got %q", text)
+	}
+	r := &PolicyRule{}
+	if clockID, err := ptp.ParseClockIdentity(fields[0]); err == nil {
+		r.ClockIdentity = clockID
+	} else if r.Prefix, err = parsePrefix(fields[0]); err != nil {
+		return nil, err
+	}
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected <key>=<value>, got %q", f)
+		}
+		switch kv[0] {
+		case "type":
+			for _, s := range strings.Split(kv[1], ",") {
+				mt, err := parseGrantType(s)
+				if err != nil {
+					return nil, err
+				}
+				r.MsgTypes = append(r.MsgTypes, mt)
+			}
+		case "mininterval":
+			d, err := time.ParseDuration(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			// granted interval is sent as log2 of seconds
+			li, err := ptp.NewLogInterval(d)
+			if err != nil {
+				return nil, err
+			}
+			if li.Duration() != d {
+				return nil, fmt.Errorf("mininterval %v is not a power of 2 seconds", d)
+			}
+			r.MinInterval = d
+		case "maxduration":
+			d, err := time.ParseDuration(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			if d < time.Second {
+				return nil, fmt.Errorf("maxduration %v is shorter than a second", d)
+			}
+			r.MaxDuration = d.Truncate(time.Second)
+		default:
+			return nil, fmt.Errorf("unknown key %q", kv[0])
+		}
+	}
+	return r, nil
+}
+
+// ParsePolicies reads policy rules, one per line, in the form of
+// '<prefix|clock identity> [type=<types>] [mininterval=<duration>] [maxduration=<duration>]',
+// where types is a comma separated list of sync, announce and delay_resp.
+// Empty lines and lines starting with # are ignored.
+func ParsePolicies(r io.Reader) (*Policies, error) {
+	var rules []*PolicyRule
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule, err := parsePolicyRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewPolicies(rules), nil
+}
+
+// ReadPolicies reads policies from the file. See ParsePolicies for the format.
+func ReadPolicies(path string) (*Policies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := ParsePolicies(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing policies %s: %w", path, err)
+	}
+	p.path = path
+	return p, nil
+}
+
+// Reload re-reads policies from the file they were read from. Rules stay intact if the file is invalid.
+func (p *Policies) Reload() error {
+	if p.path == "" {
+		return fmt.Errorf("policies were not read from a file")
+	}
+	n, err := ReadPolicies(p.path)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.rules = n.rules
+	return nil
+}
+
+// Clamp returns interval and duration (in seconds) to grant the client requesting them, limited by the first matching rule.
+// Nil Policies grant what's requested.
+func (p *Policies) Clamp(ip net.IP, clockID ptp.ClockIdentity, t ptp.MessageType, interval ptp.LogInterval, duration uint32) (ptp.LogInterval, uint32) {
+	if p == nil {
+		return interval, duration
+	}
+	p.RLock()
+	defer p.RUnlock()
+	for _, r := range p.rules {
+		if !r.matches(ip, clockID, t) {
+			continue
+		}
+		if r.MinInterval > 0 && interval.Duration() < r.MinInterval {
+			// validated when the rule was parsed
+			interval, _ = ptp.NewLogInterval(r.MinInterval)
+		}
+		if r.MaxDuration > 0 && time.Duration(duration)*time.Second > r.MaxDuration {
+			duration = uint32(r.MaxDuration / time.Second)
+		}
+		break
+	}
+	return interval, duration
+}
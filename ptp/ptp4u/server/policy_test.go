@@ -0,0 +1,118 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoliciesClamp(t *testing.T) {
+	var p *Policies
+	interval, duration := p.Clamp(net.ParseIP("10.0.0.1"), 0, ptp.MessageSync, -4, 300)
+	require.Equal(t, ptp.LogInterval(-4), interval)
+	require.Equal(t, uint32(300), duration)
+
+	p, err := ParsePolicies(strings.NewReader(`# lab clients get 1s Sync max
+10.1.0.0/16 type=sync mininterval=1s maxduration=60s
+aabbcc.fffe.ddeeff mininterval=250ms
+# production racks
+10.0.0.0/8 mininterval=62.5ms
+`))
+	require.NoError(t, err)
+
+	lab := net.ParseIP("10.1.2.3")
+	interval, duration = p.Clamp(lab, 0, ptp.MessageSync, -4, 300)
+	require.Equal(t, ptp.LogInterval(0), interval)
+	require.Equal(t, uint32(60), duration)
+	// slower than the limit is granted as is
+	interval, duration = p.Clamp(lab, 0, ptp.MessageSync, 1, 30)
+	require.Equal(t, ptp.LogInterval(1), interval)
+	require.Equal(t, uint32(30), duration)
+	// lab rule is for Sync only, production rule applies to Announce
+	interval, _ = p.Clamp(lab, 0, ptp.MessageAnnounce, -7, 300)
+	require.Equal(t, ptp.LogInterval(-4), interval)
+
+	// clock identity rule goes before the prefix one
+	clockID, err := ptp.ParseClockIdentity("aabbcc.fffe.ddeeff")
+	require.NoError(t, err)
+	interval, _ = p.Clamp(net.ParseIP("10.2.0.1"), clockID, ptp.MessageDelayResp, -7, 300)
+	require.Equal(t, ptp.LogInterval(-2), interval)
+
+	interval, duration = p.Clamp(net.ParseIP("192.168.0.1"), 0, ptp.MessageSync, -7, 300)
+	require.Equal(t, ptp.LogInterval(-7), interval)
+	require.Equal(t, uint32(300), duration)
+}
+
+func TestParsePolicies(t *testing.T) {
+	p, err := ParsePolicies(strings.NewReader("2401:db00::/32 type=sync,delay_resp mininterval=500ms maxduration=1h\n"))
+	require.NoError(t, err)
+	_, prefix, _ := net.ParseCIDR("2401:db00::/32")
+	require.Equal(t, []*PolicyRule{
+		{
+			Prefix:      prefix,
+			MsgTypes:    []ptp.MessageType{ptp.MessageSync, ptp.MessageDelayResp},
+			MinInterval: 500 * time.Millisecond,
+			MaxDuration: time.Hour,
+		},
+	}, p.rules)
+
+	for _, bad := range []string{
+		"10.0.0.0/8",
+		"10.0.0.0/8 mininterval",
+		"10.0.0.0/8 mininterval=100ms",
+		"10.0.0.0/8 mininterval=lol",
+		"10.0.0.0/8 maxduration=100ms",
+		"10.0.0.0/8 type=followup",
+		"10.0.0.0/8 rate=1",
+		"nope mininterval=1s",
+	} {
+		_, err := ParsePolicies(strings.NewReader(bad))
+		require.Error(t, err, bad)
+	}
+	_, err = ParsePolicies(strings.NewReader("\n10.0.0.0/8 rate=1\n"))
+	require.EqualError(t, err, "line 2: unknown key \"rate\"")
+}
+
+func TestPoliciesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies")
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.0/8 maxduration=1m\n"), 0644))
+	p, err := ReadPolicies(path)
+	require.NoError(t, err)
+	_, duration := p.Clamp(net.ParseIP("10.0.0.1"), 0, ptp.MessageSync, 0, 300)
+	require.Equal(t, uint32(60), duration)
+
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.0/8 maxduration=2m\n"), 0644))
+	require.NoError(t, p.Reload())
+	_, duration = p.Clamp(net.ParseIP("10.0.0.1"), 0, ptp.MessageSync, 0, 300)
+	require.Equal(t, uint32(120), duration)
+
+	// broken file keeps old rules
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.0/8\n"), 0644))
+	require.Error(t, p.Reload())
+	_, duration = p.Clamp(net.ParseIP("10.0.0.1"), 0, ptp.MessageSync, 0, 300)
+	require.Equal(t, uint32(120), duration)
+
+	require.Error(t, NewPolicies(nil).Reload())
+}
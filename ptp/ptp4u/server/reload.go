@@ -0,0 +1,395 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/facebook/time/phc"
+	"github.com/facebook/time/timestamp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// workerStopGrace is how long replaced workers keep serving subscriptions which queued themselves before the switch
+const workerStopGrace = 5 * time.Second
+
+// DynamicConfig is the part of the config which can be changed without restart
+type DynamicConfig struct {
+	Interface     string              `json:"interface"`
+	TimestampType timestamp.Timestamp `json:"timestamptype"`
+	DSCP          int                 `json:"dscp"`
+	GeneralDSCP   int                 `json:"generaldscp"`
+	SendWorkers   int                 `json:"workers"`
+	ExtraDomains  string              `json:"extradomains"`
+	UpstreamDynamicConfig
+}
+
+// ReadDynamicConfig reads JSON config file on top of dc, values missing in the file stay intact
+func ReadDynamicConfig(path string, dc *DynamicConfig) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(dc); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// String formats DomainConfig the way ParseDomains reads it
+func (d DomainConfig) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", d.DomainNumber, d.ClockQuality.ClockClass, d.ClockQuality.ClockAccuracy, d.ClockQuality.OffsetScaledLogVariance)
+}
+
+// dynamicConfig returns the current values of the dynamic config
+func (c *Config) dynamicConfig() *DynamicConfig {
+	domains := make([]string, 0, len(c.Domains))
+	for _, d := range c.Domains {
+		domains = append(domains, d.String())
+	}
+	return &DynamicConfig{
+		Interface:     c.Interface,
+		TimestampType: c.TimestampType,
+		DSCP:          c.DSCP,
+		GeneralDSCP:   c.GeneralDSCP,
+		SendWorkers:   c.SendWorkers,
+		ExtraDomains:  strings.Join(domains, ","),
+
+		UpstreamDynamicConfig: c.upstreamDynamicConfig(),
+	}
+}
+
+// workersPart returns the dynamic config without the boundary clock part, changes of which need new workers
+func (dc DynamicConfig) workersPart() DynamicConfig {
+	dc.UpstreamDynamicConfig = UpstreamDynamicConfig{}
+	return dc
+}
+
+// generalDSCP returns DSCP of general messages
+func (dc *DynamicConfig) generalDSCP() int {
+	if dc.GeneralDSCP < 0 {
+		return dc.DSCP
+	}
+	return dc.GeneralDSCP
+}
+
+// validate checks the dynamic config can be applied on top of c and returns parsed domains
+func (dc *DynamicConfig) validate(c *Config) ([]DomainConfig, error) {
+	if dc.DSCP < 0 || dc.DSCP > 63 {
+		return nil, fmt.Errorf("unsupported DSCP value %d", dc.DSCP)
+	}
+	if dc.GeneralDSCP > 63 {
+		return nil, fmt.Errorf("unsupported general DSCP value %d", dc.GeneralDSCP)
+	}
+	if dc.SendWorkers < 1 {
+		return nil, fmt.Errorf("unsupported number of workers %d", dc.SendWorkers)
+	}
+	switch dc.TimestampType {
+	case timestamp.HW, timestamp.SW:
+	default:
+		return nil, fmt.Errorf("unsupported timestamp type %s", dc.TimestampType)
+	}
+	if dc.Interface != c.Interface && (c.Multicast() || c.PeerDelay) {
+		return nil, fmt.Errorf("interface can't be changed while multicast groups are joined")
+	}
+	ips, err := ifaceIPs(dc.Interface)
+	if err != nil {
+		return nil, err
+	}
+	for _, served := range c.ips() {
+		found := false
+		for _, ip := range ips {
+			found = found || served.Equal(ip)
+		}
+		if !found {
+			return nil, fmt.Errorf("IP %s is not found on interface %s", served, dc.Interface)
+		}
+	}
+	if dc.TimestampType == timestamp.HW {
+		info, err := phc.IfaceInfo(dc.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("getting timestamping capabilities of interface %s: %w", dc.Interface, err)
+		}
+		if err := info.CheckHWTimestamping(); err != nil {
+			return nil, fmt.Errorf("interface %s doesn't support hardware timestamps: %w", dc.Interface, err)
+		}
+	}
+	domains, err := ParseDomains(dc.ExtraDomains)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range domains {
+		if d.DomainNumber == c.DomainNumber || (c.Profile != nil && !c.Profile.ValidDomain(d.DomainNumber)) {
+			return nil, fmt.Errorf("unsupported extra domain %d", d.DomainNumber)
+		}
+	}
+	return domains, nil
+}
+
+// ReloadConfig re-reads the dynamic config file and applies it
+func (s *Server) ReloadConfig(path string) error {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	dc := s.Config.dynamicConfig()
+	workers := dc.SendWorkers
+	if err := ReadDynamicConfig(path, dc); err != nil {
+		return err
+	}
+	// the number of workers follows the load, don't undo it
+	if s.Config.Autoscale() && dc.SendWorkers != workers {
+		log.Infof("Ignoring %d send workers of %s, they are autoscaled", dc.SendWorkers, path)
+		dc.SendWorkers = workers
+	}
+	return s.reloadLocked(dc)
+}
+
+// Reload applies the dynamic config without dropping subscriptions.
+// The whole config is validated and new workers are set up before anything is switched,
+// so invalid config leaves the server intact.
+func (s *Server) Reload(dc *DynamicConfig) error {
+	s.reloadMux.Lock()
+	defer s.reloadMux.Unlock()
+	return s.reloadLocked(dc)
+}
+
+// reloadLocked is Reload with reloadMux held
+func (s *Server) reloadLocked(dc *DynamicConfig) error {
+	cur := s.Config.dynamicConfig()
+	if *dc == *cur {
+		return nil
+	}
+	domains, err := dc.validate(s.Config)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	upstream, err := dc.UpstreamDynamicConfig.settings(s.Config)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if dc.workersPart() != cur.workersPart() {
+		if err := s.reloadWorkers(dc, domains); err != nil {
+			return err
+		}
+	}
+	if s.Config.HasUpstream() && dc.UpstreamDynamicConfig != cur.UpstreamDynamicConfig {
+		return s.reloadUpstream(upstream)
+	}
+	return nil
+}
+
+// reloadWorkers switches to new workers set up as per the dynamic config, moving subscriptions over
+func (s *Server) reloadWorkers(dc *DynamicConfig, domains []DomainConfig) error {
+	var err error
+
+	// new workers with their sockets
+	var workers []*sendWorker
+	var sockets []*workerSockets
+	newWorker := func() error {
+		w := NewSendWorker(len(workers), s.Config, s.Stats)
+		w.iface, w.timestampType = dc.Interface, dc.TimestampType
+		w.eventDSCP, w.generalDSCP = dc.DSCP, dc.generalDSCP()
+		ws, err := w.listen()
+		if err != nil {
+			return err
+		}
+		workers = append(workers, w)
+		sockets = append(sockets, ws)
+		return nil
+	}
+	domainWorkers := make(map[uint8][]*sendWorker, len(domains))
+	for i := 0; i < dc.SendWorkers && err == nil; i++ {
+		err = newWorker()
+	}
+	for _, d := range domains {
+		for i := 0; i < dc.SendWorkers && err == nil; i++ {
+			err = newWorker()
+			if err == nil {
+				domainWorkers[d.DomainNumber] = append(domainWorkers[d.DomainNumber], workers[len(workers)-1])
+			}
+		}
+	}
+	rxSwitched := false
+	if err == nil && (dc.Interface != s.Config.Interface || dc.TimestampType != s.Config.TimestampType) {
+		err = s.enableRXTimestamps(dc.Interface, dc.TimestampType)
+		rxSwitched = err == nil
+	}
+	if err == nil {
+		err = s.setGeneralDSCP(dc.generalDSCP())
+	}
+	if err != nil {
+		if rxSwitched {
+			s.restoreRXTimestamps(len(s.eFds))
+		}
+		for _, ws := range sockets {
+			ws.close()
+		}
+		return fmt.Errorf("setting up workers: %w", err)
+	}
+	for i, w := range workers {
+		go w.run(sockets[i])
+	}
+
+	// switch to the new workers and move subscriptions over
+	s.workersMux.Lock()
+	old := s.sw
+	s.Config.Interface = dc.Interface
+	s.Config.TimestampType = dc.TimestampType
+	s.Config.DSCP = dc.DSCP
+	s.Config.GeneralDSCP = dc.GeneralDSCP
+	s.Config.SendWorkers = dc.SendWorkers
+	s.Config.Domains = domains
+	s.sw = workers
+	s.domains = domainWorkers
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	moved := 0
+	for _, w := range old {
+		w.mux.Lock()
+		for st, subs := range w.clients {
+			for clientID, sc := range subs {
+				nw := s.findDomainWorkerLocked(sc.domain(), clientID, r)
+				nw.RegisterSubscription(clientID, st, sc)
+				sc.setQueue(nw.queue)
+				moved++
+			}
+		}
+		w.mux.Unlock()
+	}
+	s.workersMux.Unlock()
+	log.Infof("Reloaded config %+v, moved %d subscriptions to %d workers", *dc, moved, len(workers))
+
+	go func() {
+		time.Sleep(workerStopGrace)
+		for _, w := range old {
+			close(w.stop)
+		}
+	}()
+	return nil
+}
+
+// enableRXTimestamps switches RX timestamps of the event sockets to the interface and timestamp type.
+// If any socket fails, the ones already switched are switched back to the current config
+func (s *Server) enableRXTimestamps(iface string, ts timestamp.Timestamp) error {
+	s.fdsMux.Lock()
+	for i, sock := range s.eFds {
+		if err := s.enableSocketRXTimestamps(sock, iface, ts); err != nil {
+			s.fdsMux.Unlock()
+			s.restoreRXTimestamps(i + 1)
+			return err
+		}
+	}
+	s.fdsMux.Unlock()
+	return nil
+}
+
+// restoreRXTimestamps switches RX timestamps of the first n event sockets back to the current config
+func (s *Server) restoreRXTimestamps(n int) {
+	s.fdsMux.Lock()
+	defer s.fdsMux.Unlock()
+	for _, sock := range s.eFds[:n] {
+		if err := s.enableSocketRXTimestamps(sock, s.Config.Interface, s.Config.TimestampType); err != nil {
+			log.Errorf("Failed to restore %s RX timestamps on %s: %v", s.Config.TimestampType, s.Config.Interface, err)
+		}
+	}
+}
+
+// enableSocketRXTimestamps enables RX timestamps of the type on the event socket
+func (s *Server) enableSocketRXTimestamps(sock *rxSocket, iface string, ts timestamp.Timestamp) error {
+	enabled, err := timestamp.EnableTimestamps(sock.fd, iface, ts)
+	if err != nil {
+		return fmt.Errorf("enabling %s RX timestamps: %w", ts, err)
+	}
+	sock.setTimestampType(enabled)
+	if enabled != ts {
+		return fmt.Errorf("enabling %s RX timestamps, only %s are available", ts, enabled)
+	}
+	if s.Config.OneStep && ts == timestamp.HW {
+		if _, err := enableOneStep(sock.fd, iface); err != nil {
+			return fmt.Errorf("enabling one-step sync: %w", err)
+		}
+	}
+	return nil
+}
+
+// setGeneralDSCP updates DSCP of the general sockets grants and cancellations are sent from.
+// If any socket fails, the ones already updated get the current DSCP back
+func (s *Server) setGeneralDSCP(dscp int) error {
+	s.fdsMux.Lock()
+	defer s.fdsMux.Unlock()
+	done := make([]int, 0, len(s.gFds))
+	for family := range s.gFds {
+		if err := enableDSCP(s.gFds[family], familyZero(family), dscp); err != nil {
+			for _, f := range done {
+				if rerr := enableDSCP(s.gFds[f], familyZero(f), s.Config.generalDSCP()); rerr != nil {
+					log.Errorf("Failed to restore DSCP on general socket: %v", rerr)
+				}
+			}
+			return fmt.Errorf("setting DSCP on general socket: %w", err)
+		}
+		done = append(done, family)
+	}
+	return nil
+}
+
+// familyZero returns unspecified address of the address family
+func familyZero(family int) net.IP {
+	if family == unix.AF_INET {
+		return net.IPv4zero
+	}
+	return net.IPv6zero
+}
+
+// WatchConfig calls reload every time modification time of the file at path changes, checking every interval. It never returns
+func WatchConfig(path string, interval time.Duration, reload func()) {
+	w := &configWatcher{path: path}
+	w.changed()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if w.changed() {
+			reload()
+		}
+	}
+}
+
+// configWatcher tracks modification time of the config file
+type configWatcher struct {
+	path  string
+	mtime time.Time
+}
+
+// changed checks if the file was modified since the last check. Missing file is not a change, so it's not reloaded while being replaced
+func (w *configWatcher) changed() bool {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	if fi.ModTime().Equal(w.mtime) {
+		return false
+	}
+	w.mtime = fi.ModTime()
+	return true
+}
@@ -0,0 +1,191 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/ptp/ptp4u/stats"
+	"github.com/facebook/time/timestamp"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReadDynamicConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ptp4u.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"dscp": 42, "timestamptype": "software", "extradomains": "24"}`), 0644))
+
+	dc := &DynamicConfig{Interface: "eth0", TimestampType: timestamp.HW, SendWorkers: 10}
+	require.NoError(t, ReadDynamicConfig(path, dc))
+	want := &DynamicConfig{Interface: "eth0", TimestampType: timestamp.SW, DSCP: 42, SendWorkers: 10, ExtraDomains: "24"}
+	require.Equal(t, want, dc)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"dscp": 42, "utcoffset": 37}`), 0644))
+	require.Error(t, ReadDynamicConfig(path, dc))
+
+	require.Error(t, ReadDynamicConfig(filepath.Join(t.TempDir(), "missing"), dc))
+}
+
+func TestConfigDynamicConfig(t *testing.T) {
+	domains, err := ParseDomains("24,25:248")
+	require.NoError(t, err)
+	c := &Config{Interface: "eth0", TimestampType: timestamp.HW, DSCP: 35, SendWorkers: 10, Domains: domains}
+	dc := c.dynamicConfig()
+	require.Equal(t, &DynamicConfig{Interface: "eth0", TimestampType: timestamp.HW, DSCP: 35, SendWorkers: 10, ExtraDomains: "24:6:33:23008,25:248:33:23008"}, dc)
+
+	parsed, err := ParseDomains(dc.ExtraDomains)
+	require.NoError(t, err)
+	require.Equal(t, domains, parsed)
+}
+
+func TestDynamicConfigValidate(t *testing.T) {
+	c := &Config{Interface: "lo", IP: net.ParseIP("127.0.0.1"), DomainNumber: 0}
+	valid := DynamicConfig{Interface: "lo", TimestampType: timestamp.SW, DSCP: 35, SendWorkers: 2, ExtraDomains: "24"}
+	domains, err := valid.validate(c)
+	require.NoError(t, err)
+	require.Equal(t, []DomainConfig{{DomainNumber: 24, ClockQuality: DefaultClockQuality}}, domains)
+
+	invalid := []func(dc *DynamicConfig){
+		func(dc *DynamicConfig) { dc.DSCP = 64 },
+		func(dc *DynamicConfig) { dc.SendWorkers = 0 },
+		func(dc *DynamicConfig) { dc.TimestampType = timestamp.HWRX },
+		func(dc *DynamicConfig) { dc.Interface = "nosuchiface0" },
+		func(dc *DynamicConfig) { dc.ExtraDomains = "0" },
+		func(dc *DynamicConfig) { dc.ExtraDomains = "24,24" },
+	}
+	for i, f := range invalid {
+		dc := valid
+		f(&dc)
+		_, err := dc.validate(c)
+		require.Error(t, err, "case %d", i)
+	}
+
+	c.MulticastSyncInterval = time.Second
+	dc := valid
+	dc.Interface = "eth0"
+	_, err = dc.validate(c)
+	require.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	c := &Config{
+		clockIdentity: ptp.ClockIdentity(1234),
+		Interface:     "lo",
+		IP:            net.ParseIP("127.0.0.1"),
+		TimestampType: timestamp.SW,
+		SendWorkers:   1,
+	}
+	st := stats.NewJSONStats()
+	old := NewSendWorker(0, c, st)
+	s := &Server{Config: c, Stats: st, sw: []*sendWorker{old}}
+
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	clients := []ptp.PortIdentity{}
+	for i := 1; i <= 10; i++ {
+		clientID := ptp.PortIdentity{ClockIdentity: ptp.ClockIdentity(i), PortNumber: 1}
+		sc := NewSubscriptionClient(old.queue, sa, sa, ptp.MessageSync, c, time.Second, time.Now().Add(time.Minute))
+		old.RegisterSubscription(clientID, ptp.MessageSync, sc)
+		clients = append(clients, clientID)
+	}
+
+	// invalid config changes nothing
+	err := s.Reload(&DynamicConfig{Interface: "lo", TimestampType: timestamp.SW, DSCP: 80, SendWorkers: 4})
+	require.Error(t, err)
+	require.Equal(t, []*sendWorker{old}, s.workers())
+	require.Equal(t, 0, c.DSCP)
+
+	err = s.Reload(&DynamicConfig{Interface: "lo", TimestampType: timestamp.SW, DSCP: 35, GeneralDSCP: -1, SendWorkers: 4, ExtraDomains: "24"})
+	require.NoError(t, err)
+	require.Equal(t, 35, c.DSCP)
+	require.Equal(t, 4, c.SendWorkers)
+	require.Equal(t, 8, len(s.workers()))
+	require.Equal(t, 4, len(s.domains[24]))
+
+	// every subscription is served by the worker clients are looked up in
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, clientID := range clients {
+		w := s.findWorker(clientID, r)
+		sc := w.FindSubscription(clientID, ptp.MessageSync)
+		require.NotNil(t, sc)
+		require.Equal(t, w.queue, sc.queue)
+		require.Equal(t, 35, w.eventDSCP)
+		require.Equal(t, 35, w.generalDSCP)
+	}
+
+	// same config is a no-op
+	workers := s.workers()
+	require.NoError(t, s.Reload(c.dynamicConfig()))
+	require.Equal(t, workers, s.workers())
+
+	// general messages may have own DSCP
+	require.NoError(t, s.Reload(&DynamicConfig{Interface: "lo", TimestampType: timestamp.SW, DSCP: 46, GeneralDSCP: 0, SendWorkers: 1}))
+	require.Equal(t, 0, c.GeneralDSCP)
+	for _, w := range s.workers() {
+		require.Equal(t, 46, w.eventDSCP)
+		require.Equal(t, 0, w.generalDSCP)
+	}
+	require.Error(t, s.Reload(&DynamicConfig{Interface: "lo", TimestampType: timestamp.SW, DSCP: 46, GeneralDSCP: 64, SendWorkers: 1}))
+}
+
+func TestEnableRXTimestampsRollback(t *testing.T) {
+	c := &Config{Interface: "lo", TimestampType: timestamp.SW}
+	s := &Server{Config: c}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer conn.Close()
+	fd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	good := &rxSocket{fd: fd}
+	require.NoError(t, s.enableSocketRXTimestamps(good, c.Interface, c.TimestampType))
+	require.Equal(t, timestamp.SW, good.timestampType())
+
+	// socket which fails makes the switched ones go back
+	s.eFds = []*rxSocket{good, {fd: -1}}
+	require.Error(t, s.enableRXTimestamps(c.Interface, timestamp.SWRX))
+	require.Equal(t, timestamp.SW, good.timestampType())
+
+	s.eFds = s.eFds[:1]
+	require.NoError(t, s.enableRXTimestamps(c.Interface, timestamp.SWRX))
+	require.Equal(t, timestamp.SWRX, good.timestampType())
+}
+
+func TestReloadGeneralDSCPFailure(t *testing.T) {
+	c := &Config{
+		clockIdentity: ptp.ClockIdentity(1234),
+		Interface:     "lo",
+		IP:            net.ParseIP("127.0.0.1"),
+		TimestampType: timestamp.SW,
+		GeneralDSCP:   -1,
+		SendWorkers:   1,
+	}
+	st := stats.NewJSONStats()
+	old := NewSendWorker(0, c, st)
+	s := &Server{Config: c, Stats: st, sw: []*sendWorker{old}, gFds: map[int]int{unix.AF_INET: -1}}
+
+	// config isn't applied if general sockets can't take it
+	require.Error(t, s.Reload(&DynamicConfig{Interface: "lo", TimestampType: timestamp.SW, DSCP: 35, GeneralDSCP: -1, SendWorkers: 2}))
+	require.Equal(t, []*sendWorker{old}, s.workers())
+	require.Equal(t, 0, c.DSCP)
+	require.Equal(t, 1, c.SendWorkers)
+}
@@ -22,10 +22,14 @@ In addition, it run checker, announce and stats implementations
 package server
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	ptp "github.com/facebook/time/ptp/protocol"
@@ -40,10 +44,44 @@ type Server struct {
 	Config *Config
 	Stats  stats.Stats
 	sw     []*sendWorker
+	// workers of additional domains by domain number
+	domains map[uint8][]*sendWorker
+	// workersMux guards workers, which are replaced on config reload
+	workersMux sync.RWMutex
+	// reloadMux makes sure only one config reload runs at a time
+	reloadMux sync.Mutex
+
+	// all event sockets, more than one per address with ReusePort
+	eFds []*rxSocket
+	// general sockets grants are sent from, by address family
+	gFds   map[int]int
+	fdsMux sync.Mutex
+
+	// drained is set to 1 when no grants are given
+	drained int32
+
+	// upstream is the client port of the boundary clock once started, guarded by reloadMux
+	upstream *upstreamPort
+}
+
+// rxSocket is an event socket with the type of RX timestamps enabled on it
+type rxSocket struct {
+	fd int
+	// ts is timestamp.Timestamp, switched by config reload while the socket is read
+	ts int32
+}
+
+func (r *rxSocket) timestampType() timestamp.Timestamp {
+	return timestamp.Timestamp(atomic.LoadInt32(&r.ts))
+}
+
+func (r *rxSocket) setTimestampType(ts timestamp.Timestamp) {
+	atomic.StoreInt32(&r.ts, int32(ts))
+}
 
-	// server source fds
-	eFd int
-	gFd int
+// serverStats returns Stats with the metrics it doesn't implement discarded
+func (s *Server) serverStats() stats.ServerStats {
+	return stats.Extend(s.Stats)
 }
 
 // Start the workers send bind to event and general UDP ports
@@ -64,15 +102,58 @@ func (s *Server) Start() error {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	// start X workers
+	// start X workers. They only exit when replaced on config reload
 	s.sw = make([]*sendWorker, s.Config.SendWorkers)
 	for i := 0; i < s.Config.SendWorkers; i++ {
 		// Each worker to monitor own queue
 		s.sw[i] = NewSendWorker(i, s.Config, s.Stats)
-		go func(i int) {
+		go s.sw[i].Start()
+	}
+	s.startDomainWorkers()
+
+	// Resume subscriptions granted before the restart
+	if s.Config.StateFile != "" {
+		n, err := s.restoreSubscriptions()
+		if err != nil {
+			log.Errorf("Failed to restore subscriptions from %s: %v", s.Config.StateFile, err)
+		}
+		log.Infof("Restored %d subscriptions from %s", n, s.Config.StateFile)
+		go func() {
 			defer wg.Done()
-			s.sw[i].Start()
-		}(i)
+			s.persistSubscriptions()
+		}()
+	}
+
+	// Sync from the upstream grandmaster as a boundary clock, or monitor it
+	if s.Config.HasUpstream() {
+		go func() {
+			defer wg.Done()
+			if err := s.startUpstream(); err != nil {
+				log.Errorf("Upstream port failed: %v", err)
+			}
+		}()
+	}
+
+	// Fall back to software timestamps if the NIC stops producing hardware ones
+	if s.Config.TXTSFallbackFailures > 0 {
+		go func() {
+			defer wg.Done()
+			s.followTXTimestamps()
+		}()
+	}
+
+	// Follow the load with the number of send workers
+	if s.Config.Autoscale() {
+		go func() {
+			defer wg.Done()
+			s.autoscale()
+		}()
+	}
+
+	if s.Config.Multicast() {
+		if err := s.startMulticast(); err != nil {
+			return fmt.Errorf("unable to start multicast: %w", err)
+		}
 	}
 
 	go func() {
@@ -89,23 +170,73 @@ func (s *Server) Start() error {
 		defer wg.Done()
 		for {
 			<-time.After(s.Config.MetricInterval)
-			for _, w := range s.sw {
+			for _, w := range s.workers() {
 				w.inventoryClients()
 			}
 			s.Stats.SetUTCOffset(int64(s.Config.UTCOffset.Seconds()))
+			s.serverStats().SetLeapSmear(int64(s.Config.smearOffset(time.Now().Add(s.Config.UTCOffset))))
+			if s.Config.TSDegraded() {
+				s.serverStats().SetTSDegraded(1)
+			}
+			if d, e, ok := s.Config.inHoldover(time.Now()); ok {
+				s.serverStats().SetHoldover(int64(d.Seconds()), e.Nanoseconds())
+			}
+			if offset, delay, ok := s.Config.upstreamMeasurement(); ok {
+				s.serverStats().SetUpstream(offset.Nanoseconds(), delay.Nanoseconds())
+			}
+			if s.Config.UpstreamSWTS() {
+				s.serverStats().SetUpstreamSWTS(1)
+			}
+			if offset, alarm, ok := s.Config.upstreamNTPOffset(); ok {
+				var v int64
+				if alarm {
+					v = 1
+				}
+				s.serverStats().SetUpstreamNTP(offset.Nanoseconds(), v)
+			}
 
 			s.Stats.Snapshot()
 			s.Stats.Reset()
 		}
 	}()
 
+	// Follow the quality of the time reference
+	if s.Config.ClockQualitySource != nil {
+		go func() {
+			defer wg.Done()
+			s.updateClockQuality()
+		}()
+	}
+
+	// Serve the admin API
+	if s.Config.AdminSocket != "" {
+		go func() {
+			defer wg.Done()
+			if err := s.startAdmin(); err != nil {
+				log.Errorf("Failed to serve admin API: %v", err)
+			}
+		}()
+	}
+
+	// Follow leap seconds
+	if s.Config.LeapSecondsFile != "" {
+		go func() {
+			defer wg.Done()
+			s.followLeapSeconds()
+		}()
+	}
+
 	// Update UTC offset periodically
 	go func() {
 		defer wg.Done()
 		for {
 			<-time.After(1 * time.Minute)
-			if s.Config.SHM {
-				if err := s.Config.SetUTCOffsetFromSHM(); err != nil {
+			if s.Config.SHM && s.Config.LeapSecondsFile == "" {
+				// reload may change the interface PHC is read from
+				s.reloadMux.Lock()
+				err := s.Config.SetUTCOffsetFromSHM()
+				s.reloadMux.Unlock()
+				if err != nil {
 					log.Errorf("Failed to update UTC offset: %v. Keeping the last known: %s", err, s.Config.UTCOffset)
 				}
 			}
@@ -118,90 +249,231 @@ func (s *Server) Start() error {
 	return fmt.Errorf("one of server routines finished")
 }
 
+// rxSockets returns how many sockets each listener binds and how many goroutines read each of them.
+// With ReusePort every receive worker gets own socket, so the kernel spreads flows across them.
+func (c *Config) rxSockets() (sockets, readers int) {
+	if c.ReusePort && c.RecvWorkers > 1 {
+		return c.RecvWorkers, 1
+	}
+	return 1, c.RecvWorkers
+}
+
+// listenUDP binds UDP socket to the ip and port, allowing other sockets to share it if reuse is set
+func (s *Server) listenUDP(ip net.IP, port int, reuse bool) (*net.UDPConn, error) {
+	lc := net.ListenConfig{}
+	if reuse {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var opErr error
+			err := c.Control(func(fd uintptr) {
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return opErr
+		}
+	}
+	// IPv6 socket would take IPv4 traffic too unless it's restricted to its family
+	network := "udp"
+	if s.Config.SecondaryIP != nil {
+		network = "udp6"
+		if ip.To4() != nil {
+			network = "udp4"
+		}
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// disableMulticastAll makes the socket receive only multicast groups it joined itself,
+// so multicast requests are not handled by every socket sharing the port
+func disableMulticastAll(conn *net.UDPConn, ip net.IP) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	err = rc.Control(func(fd uintptr) {
+		if ip.To4() != nil {
+			opErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MULTICAST_ALL, 0)
+		} else {
+			opErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_ALL, 0)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return opErr
+}
+
 // startEventListener launches the listener which listens to subscription requests
 func (s *Server) startEventListener() {
-	var err error
-	log.Infof("Binding on %s %d", s.Config.IP, ptp.PortEvent)
-	eventConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: s.Config.IP, Port: ptp.PortEvent})
+	sockets, readers := s.Config.rxSockets()
+
+	// Call wg.Add(1) ONLY once
+	// If ANY goroutine finishes no matter how many of them we run
+	// wg.Done will unblock
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	for _, ip := range s.Config.ips() {
+		for i := 0; i < sockets; i++ {
+			eventConn, sock := s.eventSocket(ip, i, sockets > 1)
+			defer eventConn.Close()
+			for j := 0; j < readers; j++ {
+				go func() {
+					defer wg.Done()
+					s.handleEventMessages(eventConn, sock)
+				}()
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// eventSocket sets up i-th socket listening to event messages on the ip
+func (s *Server) eventSocket(ip net.IP, i int, reuse bool) (*net.UDPConn, *rxSocket) {
+	log.Infof("Binding event socket #%d on %s %d", i, ip, ptp.PortEvent)
+	eventConn, err := s.listenUDP(ip, ptp.PortEvent, reuse)
 	if err != nil {
 		log.Fatalf("Listening error: %s", err)
 	}
-	defer eventConn.Close()
+
+	// Multicast requests are received by the first socket of the primary address only
+	primary := i == 0 && ip.Equal(s.Config.IP)
+	if !primary && reuse && (s.Config.Multicast() || s.Config.PeerDelay) {
+		if err := disableMulticastAll(eventConn, ip); err != nil {
+			log.Fatalf("Disabling multicast on event socket #%d: %v", i, err)
+		}
+	}
+	if primary && s.Config.Multicast() {
+		if err := joinMulticast(eventConn, s.Config, ptp.MessageDelayReq); err != nil {
+			log.Fatalf("Joining multicast group: %v", err)
+		}
+	}
+	if primary && s.Config.PeerDelay {
+		if err := joinMulticast(eventConn, s.Config, ptp.MessagePDelayReq); err != nil {
+			log.Fatalf("Joining peer delay multicast group: %v", err)
+		}
+	}
 
 	// get connection file descriptor
-	s.eFd, err = timestamp.ConnFd(eventConn)
+	eFd, err := timestamp.ConnFd(eventConn)
 	if err != nil {
 		log.Fatalf("Getting event connection FD: %s", err)
 	}
 
 	// Enable RX timestamps. Delay requests need to be timestamped by ptp4u on receipt
-	switch s.Config.TimestampType {
-	case timestamp.HWTIMESTAMP:
-		if err := timestamp.EnableHWTimestampsSocket(s.eFd, s.Config.Interface); err != nil {
-			log.Fatalf("Cannot enable hardware RX timestamps: %v", err)
+	ts, err := timestamp.EnableTimestamps(eFd, s.Config.Interface, s.Config.TimestampType)
+	if err != nil {
+		log.Fatalf("Cannot enable %s RX timestamps: %v", s.Config.TimestampType, err)
+	}
+	if ts != s.Config.TimestampType {
+		log.Fatalf("Cannot enable %s RX timestamps, only %s are available", s.Config.TimestampType, ts)
+	}
+
+	// Sending workers switch the NIC to one-step, make sure we don't switch it back
+	if s.Config.OneStep && s.Config.TimestampType == timestamp.HW {
+		oneStep, err := enableOneStep(eFd, s.Config.Interface)
+		if err != nil {
+			log.Fatalf("Cannot enable one-step sync: %v", err)
 		}
-	case timestamp.SWTIMESTAMP:
-		if err := timestamp.EnableSWTimestampsSocket(s.eFd); err != nil {
-			log.Fatalf("Cannot enable software RX timestamps: %v", err)
+		if primary && oneStep {
+			log.Infof("Sending one-step sync via %s", s.Config.Interface)
+		} else if primary {
+			log.Warningf("Interface %s doesn't support one-step sync, falling back to two-step", s.Config.Interface)
+		}
+	}
+
+	// Busy polling reduces RX timestamp jitter
+	if s.Config.BusyPoll > 0 {
+		if err := timestamp.EnableBusyPoll(eFd, s.Config.BusyPoll, s.Config.BusyPollBudget); err != nil {
+			log.Fatalf("Cannot enable busy polling: %v", err)
 		}
-	default:
-		log.Fatalf("Unrecognized timestamp type: %s", s.Config.TimestampType)
 	}
 
-	err = unix.SetNonblock(s.eFd, false)
+	err = unix.SetNonblock(eFd, false)
 	if err != nil {
 		log.Fatalf("Failed to set socket to blocking: %s", err)
 	}
 
+	sock := &rxSocket{fd: eFd}
+	sock.setTimestampType(ts)
+	s.fdsMux.Lock()
+	defer s.fdsMux.Unlock()
+	s.eFds = append(s.eFds, sock)
+	return eventConn, sock
+}
+
+// startGeneralListener launches the listener which listens to announces
+func (s *Server) startGeneralListener() {
+	sockets, readers := s.Config.rxSockets()
+
 	// Call wg.Add(1) ONLY once
 	// If ANY goroutine finishes no matter how many of them we run
 	// wg.Done will unblock
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	for i := 0; i < s.Config.RecvWorkers; i++ {
-		go func() {
-			defer wg.Done()
-			s.handleEventMessages(eventConn)
-		}()
+	for _, ip := range s.Config.ips() {
+		for i := 0; i < sockets; i++ {
+			generalConn, gFd := s.generalSocket(ip, i, sockets > 1)
+			defer generalConn.Close()
+			for j := 0; j < readers; j++ {
+				go func() {
+					defer wg.Done()
+					s.handleGeneralMessages(generalConn, gFd)
+				}()
+			}
+		}
 	}
 	wg.Wait()
 }
 
-// startGeneralListener launches the listener which listens to announces
-func (s *Server) startGeneralListener() {
-	var err error
-	log.Infof("Binding on %s %d", s.Config.IP, ptp.PortGeneral)
-	generalConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: s.Config.IP, Port: ptp.PortGeneral})
+// generalSocket sets up i-th socket listening to general messages on the ip.
+// Grants and cancellations to clients of the address family are sent from the first one.
+func (s *Server) generalSocket(ip net.IP, i int, reuse bool) (*net.UDPConn, int) {
+	log.Infof("Binding general socket #%d on %s %d", i, ip, ptp.PortGeneral)
+	generalConn, err := s.listenUDP(ip, ptp.PortGeneral, reuse)
 	if err != nil {
 		log.Fatalf("Listening error: %s", err)
 	}
-	defer generalConn.Close()
 
 	// get connection file descriptor
-	s.gFd, err = timestamp.ConnFd(generalConn)
+	gFd, err := timestamp.ConnFd(generalConn)
 	if err != nil {
 		log.Fatalf("Getting general connection FD: %s", err)
 	}
 
-	err = unix.SetNonblock(s.gFd, false)
+	err = unix.SetNonblock(gFd, false)
 	if err != nil {
 		log.Fatalf("Failed to set socket to blocking: %s", err)
 	}
 
-	// Call wg.Add(1) ONLY once
-	// If ANY goroutine finishes no matter how many of them we run
-	// wg.Done will unblock
-	var wg sync.WaitGroup
-	wg.Add(1)
+	// Grants and cancellations are general messages
+	if err := enableDSCP(gFd, ip, s.Config.generalDSCP()); err != nil {
+		log.Fatalf("Setting DSCP on general socket: %s", err)
+	}
 
-	for i := 0; i < s.Config.RecvWorkers; i++ {
-		go func() {
-			defer wg.Done()
-			s.handleGeneralMessages(generalConn)
-		}()
+	// Reply to unicast clients from the address they sent requests to
+	if ip.IsUnspecified() {
+		if err := timestamp.EnablePacketInfo(gFd); err != nil {
+			log.Fatalf("Enabling packet info on general socket: %s", err)
+		}
 	}
-	wg.Wait()
+
+	if i == 0 {
+		s.fdsMux.Lock()
+		if s.gFds == nil {
+			s.gFds = map[int]int{}
+		}
+		s.gFds[ipFamily(ip)] = gFd
+		s.fdsMux.Unlock()
+	}
+	return generalConn, gFd
 }
 
 func readPacketBuf(connFd int, buf []byte) (int, unix.Sockaddr, error) {
@@ -213,24 +485,44 @@ func readPacketBuf(connFd int, buf []byte) (int, unix.Sockaddr, error) {
 	return n, saddr, err
 }
 
+// packetReader returns reader of event messages from the socket
+func (c *Config) packetReader(fd int) timestamp.PacketReader {
+	if !c.IOURing {
+		return timestamp.NewRecvmsgReader(fd)
+	}
+	r := timestamp.NewPacketReader(fd)
+	if _, ok := r.(*timestamp.URingReader); !ok {
+		log.Warningf("io_uring is not supported, reading event messages with recvmsg")
+	}
+	return r
+}
+
 // handleEventMessage is a handler which gets called every time Event Message arrives
-func (s *Server) handleEventMessages(eventConn *net.UDPConn) {
+func (s *Server) handleEventMessages(eventConn *net.UDPConn, sock *rxSocket) {
+	reader := s.Config.packetReader(sock.fd)
+	defer reader.Close()
 	buf := make([]byte, timestamp.PayloadSizeBytes)
 	oob := make([]byte, timestamp.ControlSizeBytes)
 	dReq := &ptp.SyncDelayReq{}
+	pdReq := &ptp.PDelayReq{}
 	// Initialize the new random. We will re-seed it every time in findWorker
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	var msgType ptp.MessageType
 	var worker *sendWorker
 	var sc *SubscriptionClient
+	// client address is only converted when needed, so the hot path doesn't allocate
+	var rsa unix.RawSockaddrAny
+	clisa := func() unix.Sockaddr {
+		return timestamp.RawSockaddrToSockaddr(&rsa)
+	}
 
 	for {
-		bbuf, clisa, rxTS, err := timestamp.ReadPacketWithRXTimestampBuf(s.eFd, buf, oob)
+		bbuf, rxTS, err := reader.ReadPacketWithRXTimestampBufAddr(buf, oob, &rsa)
 		if err != nil {
 			log.Errorf("Failed to read packet on %s: %v", eventConn.LocalAddr(), err)
 			continue
 		}
-		if s.Config.TimestampType != timestamp.HWTIMESTAMP {
+		if sock.timestampType() != timestamp.HW {
 			rxTS = rxTS.Add(s.Config.UTCOffset)
 		}
 
@@ -250,13 +542,43 @@ func (s *Server) handleEventMessages(eventConn *net.UDPConn) {
 			}
 
 			log.Debugf("Got delay request")
-			worker = s.findWorker(dReq.Header.SourcePortIdentity, r)
+			if s.Config.interopIgnores(dReq.DomainNumber, ptp.DefaultTargetPortIdentity) {
+				s.Config.logSubscriber(log.DebugLevel, subscriberFields(timestamp.SockaddrToIP(clisa()), dReq.SourcePortIdentity, dReq.DomainNumber, ptp.MessageDelayResp), "Ignoring delay request of the domain we don't serve")
+				continue
+			}
+			worker = s.findDomainWorker(dReq.DomainNumber, dReq.Header.SourcePortIdentity, r)
 			sc = worker.FindSubscription(dReq.Header.SourcePortIdentity, ptp.MessageDelayResp)
-			if sc == nil {
-				log.Warningf("Delay request from %s is not in the subscription list", timestamp.SockaddrToIP(clisa))
+			if s.Config.Multicast() && (sc == nil || sc.multicast) {
+				// multicast clients don't negotiate delay responses
+				var allowed bool
+				sc, allowed = s.multicastDelayRespClient(worker, dReq.Header.SourcePortIdentity, clisa())
+				if !allowed {
+					s.Config.logSubscriber(log.DebugLevel, subscriberFields(timestamp.SockaddrToIP(clisa()), dReq.SourcePortIdentity, dReq.DomainNumber, ptp.MessageDelayResp), "Delay request is over the rate limit")
+					s.serverStats().IncDelayReqThrottled()
+					continue
+				}
+			} else if sc == nil {
+				s.Config.logSubscriber(log.WarnLevel, subscriberFields(timestamp.SockaddrToIP(clisa()), dReq.SourcePortIdentity, dReq.DomainNumber, ptp.MessageDelayResp), "Delay request is not in the subscription list")
+				continue
+			} else if s.delayReqThrottled(worker, sc, dReq.Header.SourcePortIdentity, time.Now()) {
 				continue
 			}
-			sc.UpdateDelayResp(&dReq.Header, rxTS)
+			sc.UpdateDelayResp(&dReq.Header, s.Config.smear(rxTS))
+			sc.Once()
+		case ptp.MessagePDelayReq:
+			if !s.Config.PeerDelay {
+				log.Errorf("Got unsupported message type %s(%d)", msgType, msgType)
+				continue
+			}
+			if err := ptp.FromBytes(buf[:bbuf], pdReq); err != nil {
+				log.Errorf("Failed to read the ptp PDelayReq: %v", err)
+				continue
+			}
+
+			log.Debugf("Got peer delay request")
+			worker = s.findDomainWorker(pdReq.DomainNumber, pdReq.SourcePortIdentity, r)
+			sc = s.peerDelayRespClient(worker, &pdReq.Header, clisa())
+			sc.UpdatePDelayResp(&pdReq.Header, rxTS)
 			sc.Once()
 		default:
 			log.Errorf("Got unsupported message type %s(%d)", msgType, msgType)
@@ -265,7 +587,7 @@ func (s *Server) handleEventMessages(eventConn *net.UDPConn) {
 }
 
 // handleGeneralMessage is a handler which gets called every time General Message arrives
-func (s *Server) handleGeneralMessages(generalConn *net.UDPConn) {
+func (s *Server) handleGeneralMessages(generalConn *net.UDPConn, gFd int) {
 	buf := make([]byte, timestamp.PayloadSizeBytes)
 	signaling := &ptp.Signaling{}
 	zerotlv := []ptp.TLV{}
@@ -278,9 +600,13 @@ func (s *Server) handleGeneralMessages(generalConn *net.UDPConn) {
 	var expire time.Time
 	var worker *sendWorker
 	var sc *SubscriptionClient
+	var rsa unix.RawSockaddrAny
+	// destination of the request, only reported by sockets bound to any address
+	oob := make([]byte, timestamp.ControlSizeBytes)
+	info := timestamp.PacketInfo{Dst: make(net.IP, 0, net.IPv6len)}
 
 	for {
-		bbuf, gclisa, err := readPacketBuf(s.gFd, buf)
+		bbuf, err := timestamp.ReadPacketWithInfoBuf(gFd, buf, oob, &rsa, &info)
 		if err != nil {
 			log.Errorf("Failed to read packet on %s: %v", generalConn.LocalAddr(), err)
 			continue
@@ -294,61 +620,102 @@ func (s *Server) handleGeneralMessages(generalConn *net.UDPConn) {
 
 		switch msgType {
 		case ptp.MessageSignaling:
+			gclisa := timestamp.RawSockaddrToSockaddr(&rsa)
 			signaling.TLVs = zerotlv
-			if err := ptp.FromBytes(buf[:bbuf], signaling); err != nil {
+			if err := s.Config.decodeSignaling(buf[:bbuf], signaling); err != nil {
 				log.Error(err)
 				continue
 			}
+			if err := ptp.CheckVersion(signaling.Version); err != nil {
+				s.Config.logSubscriber(log.ErrorLevel, log.Fields{"client_ip": timestamp.SockaddrToIP(gclisa).String(), "clock_id": signaling.SourcePortIdentity.String(), "domain": signaling.DomainNumber}, "Dropping signaling message: %v", err)
+				continue
+			}
+			if s.Config.interopIgnores(signaling.DomainNumber, signaling.TargetPortIdentity) {
+				s.Config.logSubscriber(log.DebugLevel, log.Fields{"client_ip": timestamp.SockaddrToIP(gclisa).String(), "clock_id": signaling.SourcePortIdentity.String(), "domain": signaling.DomainNumber}, "Ignoring signaling message not meant for us")
+				continue
+			}
 
 			for _, tlv := range signaling.TLVs {
 				switch v := tlv.(type) {
 				case *ptp.RequestUnicastTransmissionTLV:
 					grantType = v.MsgTypeAndReserved.MsgType()
-					log.Debugf("Got %s grant request", grantType)
-					durationt = time.Duration(v.DurationField) * time.Second
+					fields := subscriberFields(timestamp.SockaddrToIP(gclisa), signaling.SourcePortIdentity, signaling.DomainNumber, grantType)
+					s.Config.logSubscriber(log.DebugLevel, fields, "Got grant request")
+
+					if !s.Config.ACL.Allowed(timestamp.SockaddrToIP(gclisa)) {
+						s.Config.logSubscriber(log.WarnLevel, fields, "Rejecting grant request denied by ACL")
+						worker = s.findDomainWorker(signaling.DomainNumber, signaling.SourcePortIdentity, r)
+						s.denyGrant(worker, nil, signaling, v.MsgTypeAndReserved, v.LogInterMessagePeriod, gclisa, info.Dst)
+						continue
+					}
+
+					// Grant what the policy of the client's group allows
+					interval, duration := s.Config.Policies.Clamp(timestamp.SockaddrToIP(gclisa), signaling.SourcePortIdentity.ClockIdentity, grantType, v.LogInterMessagePeriod, v.DurationField)
+					if interval != v.LogInterMessagePeriod || duration != v.DurationField {
+						s.Config.logSubscriber(log.DebugLevel, fields, "Clamped grant request of %v for %ds to %v for %ds by policy", v.LogInterMessagePeriod.Duration(), v.DurationField, interval.Duration(), duration)
+					}
+					durationt = time.Duration(duration) * time.Second
 					expire = time.Now().Add(durationt)
-					intervalt = v.LogInterMessagePeriod.Duration()
+					intervalt = interval.Duration()
 
 					switch grantType {
 					case ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp:
-						worker = s.findWorker(signaling.SourcePortIdentity, r)
+						worker = s.findDomainWorker(signaling.DomainNumber, signaling.SourcePortIdentity, r)
 						sc = worker.FindSubscription(signaling.SourcePortIdentity, grantType)
+						// Reject queries out of limit.
+						// Let existing grants run out, deny new ones and renewals.
+						// Deny new ones if we are at capacity and can't evict anyone.
+						if !s.Config.subscriptionAllowed(grantType, interval, durationt) || s.Drained() || (sc == nil && !s.admitSubscriber(grantType)) {
+							s.denyGrant(worker, sc, signaling, v.MsgTypeAndReserved, interval, gclisa, info.Dst)
+							continue
+						}
 						if sc == nil {
 							ip := timestamp.SockaddrToIP(gclisa)
-							eclisa := timestamp.IPToSockaddr(ip, ptp.PortEvent)
+							eclisa := s.Config.replySockaddr(ip, ptp.PortEvent)
 							sc = NewSubscriptionClient(worker.queue, eclisa, gclisa, grantType, s.Config, intervalt, expire)
+							sc.setSource(info.Dst)
+							if d, ok := s.Config.extraDomain(signaling.DomainNumber); ok {
+								sc.setDomain(d)
+							}
+							sc.granted = true
 							worker.RegisterSubscription(signaling.SourcePortIdentity, grantType, sc)
+							s.serverStats().IncGrantNew(grantType)
 						} else {
 							// Update existing subscription data
-							sc.expire = expire
-							sc.interval = intervalt
-						}
-
-						// Reject queries out of limit
-						if intervalt < s.Config.MinSubInterval || durationt > s.Config.MaxSubDuration {
-							s.sendGrant(sc, signaling, v.MsgTypeAndReserved, v.LogInterMessagePeriod, 0, gclisa)
-							continue
+							sc.setExpire(expire)
+							sc.setInterval(intervalt)
+							sc.resetDelayReqThrottled()
 						}
+						// Talk to the client using the highest version supported by both sides
+						sc.setVersion(ptp.NegotiateVersion(s.Config.ptpVersion(), signaling.Version))
 
 						if !sc.Running() {
 							go sc.Start()
 						}
 
 						// Send confirmation grant
-						s.sendGrant(sc, signaling, v.MsgTypeAndReserved, v.LogInterMessagePeriod, v.DurationField, gclisa)
+						s.sendGrant(sc, signaling, v.MsgTypeAndReserved, interval, duration, gclisa)
 					default:
-						log.Errorf("Got unsupported grant type %s", grantType)
+						s.Config.logSubscriber(log.ErrorLevel, fields, "Got unsupported grant type")
 					}
 					s.Stats.IncRXSignaling(grantType)
 				case *ptp.CancelUnicastTransmissionTLV:
 					grantType = v.MsgTypeAndFlags.MsgType()
-					log.Debugf("Got %s cancel request", grantType)
-					worker = s.findWorker(signaling.SourcePortIdentity, r)
+					s.Config.logSubscriber(log.DebugLevel, subscriberFields(timestamp.SockaddrToIP(gclisa), signaling.SourcePortIdentity, signaling.DomainNumber, grantType), "Got cancel request")
+					worker = s.findDomainWorker(signaling.DomainNumber, signaling.SourcePortIdentity, r)
 					sc = worker.FindSubscription(signaling.SourcePortIdentity, grantType)
 					if sc != nil {
 						sc.Stop()
 					}
+					if s.Config.interop(signaling.DomainNumber) {
+						s.sendCancelAck(signaling, v.MsgTypeAndFlags, gclisa, info.Dst)
+					}
 				default:
+					// standard clients may add TLVs we don't handle
+					if s.Config.interop(signaling.DomainNumber) {
+						log.Debugf("Ignoring unsupported TLV %s", tlv.Type())
+						continue
+					}
 					log.Errorf("Got unsupported message type %s(%d)", msgType, msgType)
 				}
 			}
@@ -356,25 +723,86 @@ func (s *Server) handleGeneralMessages(generalConn *net.UDPConn) {
 	}
 }
 
+// delayReqMaxThrottled is how many consecutive Delay_Req over the granted rate we drop before revoking the grant
+const delayReqMaxThrottled = 10
+
+// delayReqThrottled checks if the Delay_Req is over the granted rate and must be dropped.
+// The grant is revoked if the client keeps sending them too fast.
+func (s *Server) delayReqThrottled(worker *sendWorker, sc *SubscriptionClient, clientID ptp.PortIdentity, now time.Time) bool {
+	if sc.delayReqAllowed(now, sc.Interval()) {
+		return false
+	}
+	sc.log(log.DebugLevel, "Delay request is over the granted rate")
+	s.serverStats().IncDelayReqThrottled()
+	if sc.DelayReqThrottled() > delayReqMaxThrottled {
+		sc.log(log.WarnLevel, "Revoking grant of the client sending delay requests over the granted rate")
+		s.sendCancel(sc, clientID)
+		sc.Stop()
+		worker.unregister(clientID, sc)
+	}
+	return true
+}
+
 func (s *Server) findWorker(clientID ptp.PortIdentity, r *rand.Rand) *sendWorker {
+	s.workersMux.RLock()
+	defer s.workersMux.RUnlock()
+	return s.findWorkerLocked(clientID, r)
+}
+
+// findWorkerLocked is findWorker for callers holding workersMux
+func (s *Server) findWorkerLocked(clientID ptp.PortIdentity, r *rand.Rand) *sendWorker {
 	// Seeding random with the same value will produce the same number
 	r.Seed(int64(clientID.ClockIdentity) + int64(clientID.PortNumber))
 	return s.sw[r.Intn(s.Config.SendWorkers)]
 }
 
+// workers returns all send workers
+func (s *Server) workers() []*sendWorker {
+	s.workersMux.RLock()
+	defer s.workersMux.RUnlock()
+	return s.sw
+}
+
 // sendGrant sends a Unicast Grant message
 func (s *Server) sendGrant(sc *SubscriptionClient, sg *ptp.Signaling, mt ptp.UnicastMsgTypeAndFlags, interval ptp.LogInterval, duration uint32, sa unix.Sockaddr) {
 	sc.UpdateGrant(sg, mt, interval, duration)
 	grantb, err := ptp.Bytes(sc.Grant())
 	if err != nil {
-		log.Errorf("Failed to prepare the unicast grant: %v", err)
+		sc.log(log.ErrorLevel, "Failed to prepare the unicast grant: %v", err)
 		return
 	}
-	err = unix.Sendto(s.gFd, grantb, 0, sa)
+	err = sendGeneral(s.generalFd(sa), grantb, sc.srcOOB, sa)
 	if err != nil {
-		log.Errorf("Failed to send the unicast grant: %v", err)
+		sc.log(log.ErrorLevel, "Failed to send the unicast grant: %v", err)
+		return
+	}
+	sc.log(log.DebugLevel, "Sent unicast grant for %ds", duration)
+	s.Stats.IncTXSignaling(sc.subscriptionType)
+}
+
+// denyGrant refuses the request with a grant of 0 seconds.
+// It's sent for sc, or for a throwaway subscription from src if the client has none.
+func (s *Server) denyGrant(worker *sendWorker, sc *SubscriptionClient, sg *ptp.Signaling, mt ptp.UnicastMsgTypeAndFlags, interval ptp.LogInterval, sa unix.Sockaddr, src net.IP) {
+	if sc == nil {
+		eclisa := s.Config.replySockaddr(timestamp.SockaddrToIP(sa), ptp.PortEvent)
+		sc = NewSubscriptionClient(worker.queue, eclisa, sa, mt.MsgType(), s.Config, interval.Duration(), time.Time{})
+		sc.setSource(src)
+	}
+	sc.setVersion(ptp.NegotiateVersion(s.Config.ptpVersion(), sg.Version))
+	s.sendGrant(sc, sg, mt, interval, 0, sa)
+	s.serverStats().IncRXSignalingRejected(mt.MsgType())
+}
+
+// sendCancel sends a Unicast Cancel message to the client of the subscription
+func (s *Server) sendCancel(sc *SubscriptionClient, clientID ptp.PortIdentity) {
+	cancelb, err := ptp.Bytes(sc.Cancel(clientID))
+	if err != nil {
+		sc.log(log.ErrorLevel, "Failed to prepare the unicast cancel: %v", err)
+		return
+	}
+	if err := sendGeneral(s.generalFd(sc.gclisa), cancelb, sc.srcOOB, sc.gclisa); err != nil {
+		sc.log(log.ErrorLevel, "Failed to send the unicast cancel: %v", err)
 		return
 	}
-	log.Debugf("Sent unicast grant")
 	s.Stats.IncTXSignaling(sc.subscriptionType)
 }
@@ -18,6 +18,7 @@ package server
 
 import (
 	"math/rand"
+	"net"
 	"testing"
 	"time"
 
@@ -25,13 +26,14 @@ import (
 	"github.com/facebook/time/ptp/ptp4u/stats"
 	"github.com/facebook/time/timestamp"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func TestFindWorker(t *testing.T) {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	c := &Config{
 		clockIdentity: ptp.ClockIdentity(1234),
-		TimestampType: timestamp.SWTIMESTAMP,
+		TimestampType: timestamp.SW,
 		SendWorkers:   10,
 	}
 	s := Server{
@@ -67,3 +69,131 @@ func TestFindWorker(t *testing.T) {
 	require.Equal(t, 3, s.findWorker(clipi2, r).id)
 	require.Equal(t, 1, s.findWorker(clipi3, r).id)
 }
+
+func TestRXSockets(t *testing.T) {
+	c := &Config{RecvWorkers: 4}
+	sockets, readers := c.rxSockets()
+	require.Equal(t, 1, sockets)
+	require.Equal(t, 4, readers)
+
+	c.ReusePort = true
+	sockets, readers = c.rxSockets()
+	require.Equal(t, 4, sockets)
+	require.Equal(t, 1, readers)
+}
+
+func TestListenUDPReusePort(t *testing.T) {
+	s := Server{Config: &Config{IP: net.ParseIP("127.0.0.1")}}
+	first, err := s.listenUDP(s.Config.IP, 0, true)
+	require.NoError(t, err)
+	defer first.Close()
+	port := first.LocalAddr().(*net.UDPAddr).Port
+
+	second, err := s.listenUDP(s.Config.IP, port, true)
+	require.NoError(t, err)
+	defer second.Close()
+	require.NoError(t, disableMulticastAll(second, s.Config.IP))
+
+	// socket without SO_REUSEPORT can't share the port
+	_, err = s.listenUDP(s.Config.IP, port, false)
+	require.Error(t, err)
+}
+
+// rejectedStats counts rejected signaling messages
+type rejectedStats struct {
+	*stats.JSONStats
+	rejected int
+}
+
+func (s *rejectedStats) IncRXSignalingRejected(ptp.MessageType) {
+	s.rejected++
+}
+
+func TestDenyGrant(t *testing.T) {
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer client.Close()
+	gFd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	require.NoError(t, err)
+	defer unix.Close(gFd)
+
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), SendWorkers: 1}
+	st := &rejectedStats{JSONStats: stats.NewJSONStats()}
+	w := NewSendWorker(0, c, st)
+	s := &Server{Config: c, Stats: st, sw: []*sendWorker{w}, gFds: map[int]int{unix.AF_INET: gFd}}
+
+	sg := &ptp.Signaling{Header: ptp.Header{SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageSignaling, 0), Version: ptp.Version, SequenceID: 42}}
+	mt := ptp.NewUnicastMsgTypeAndFlags(ptp.MessageSync, 0)
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), client.LocalAddr().(*net.UDPAddr).Port)
+	// any loopback address will do as the address the request was sent to
+	s.denyGrant(w, nil, sg, mt, ptp.LogInterval(-3), sa, net.ParseIP("127.0.0.2"))
+	require.Equal(t, 1, st.rejected)
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 128)
+	n, from, err := client.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.2", from.IP.String())
+	grant := &ptp.Signaling{}
+	require.NoError(t, grant.UnmarshalBinary(buf[:n]))
+	require.Len(t, grant.TLVs, 1)
+	tlv, ok := grant.TLVs[0].(*ptp.GrantUnicastTransmissionTLV)
+	require.True(t, ok)
+	require.Equal(t, ptp.MessageSync, tlv.MsgTypeAndReserved.MsgType())
+	require.Equal(t, ptp.LogInterval(-3), tlv.LogInterMessagePeriod)
+	require.Equal(t, uint32(0), tlv.DurationField)
+}
+
+func TestDelayReqThrottledRevoke(t *testing.T) {
+	s, w := capacityTestServer(0, EvictNone)
+	clientID := ptp.PortIdentity{PortNumber: 1, ClockIdentity: ptp.ClockIdentity(5678)}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageDelayResp, s.Config, time.Second, time.Now().Add(time.Minute))
+	sc.granted = true
+	w.RegisterSubscription(clientID, ptp.MessageDelayResp, sc)
+	require.Equal(t, 1, s.subscriberCount())
+
+	now := time.Now()
+	require.False(t, s.delayReqThrottled(w, sc, clientID, now))
+	require.False(t, s.delayReqThrottled(w, sc, clientID, now))
+	for i := 0; i < delayReqMaxThrottled; i++ {
+		require.True(t, s.delayReqThrottled(w, sc, clientID, now))
+	}
+	// a request within the rate resets the count
+	now = now.Add(time.Second)
+	require.False(t, s.delayReqThrottled(w, sc, clientID, now))
+	require.Equal(t, sc, w.FindSubscription(clientID, ptp.MessageDelayResp))
+
+	for i := 0; i <= delayReqMaxThrottled; i++ {
+		require.True(t, s.delayReqThrottled(w, sc, clientID, now))
+	}
+	require.True(t, sc.Expired())
+	require.Nil(t, w.FindSubscription(clientID, ptp.MessageDelayResp))
+	require.Equal(t, 0, s.subscriberCount())
+}
+
+func TestPacketReader(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer conn.Close()
+	fd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	require.NoError(t, timestamp.EnableSWTimestampsRx(fd))
+	require.NoError(t, unix.SetNonblock(fd, false))
+
+	c := &Config{}
+	require.Equal(t, timestamp.NewRecvmsgReader(fd), c.packetReader(fd))
+
+	// io_uring one if supported, recvmsg one otherwise
+	c.IOURing = true
+	r := c.packetReader(fd)
+	defer r.Close()
+	buf := make([]byte, timestamp.PayloadSizeBytes)
+	oob := make([]byte, timestamp.ControlSizeBytes)
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.NoError(t, err)
+	n, _, rxTS, err := r.ReadPacketWithRXTimestampBuf(buf, oob)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.False(t, rxTS.IsZero())
+}
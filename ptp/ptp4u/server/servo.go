@@ -0,0 +1,266 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Servo backends
+const (
+	// ServoPI is the proportional-integral servo
+	ServoPI = "pi"
+	// ServoLinreg is the linear regression servo
+	ServoLinreg = "linreg"
+)
+
+// servo disciplines frequency of the clock from its offsets
+type servo interface {
+	// sample takes the offset of the clock from the master measured at now and returns the frequency to set in ppb,
+	// and the step to apply to the clock first, zero if none
+	sample(offset time.Duration, now time.Time) (float64, time.Duration)
+	// isLocked checks if the servo got any sample
+	isLocked() bool
+	// setFrequency makes the servo continue from the frequency set by someone else, for example in holdover
+	setFrequency(ppb float64)
+	// resume makes the servo continue locked from the frequency, for example saved before restart
+	resume(ppb float64)
+}
+
+// newServo returns servo backend by name, sampled every interval, starting with the frequency in ppb and stepping the clock by steps
+func newServo(name string, interval time.Duration, freq float64, steps stepThresholds) (servo, error) {
+	switch name {
+	case "", ServoPI:
+		s := newPIServo(interval, freq)
+		s.steps = steps
+		return s, nil
+	case ServoLinreg:
+		s := newLinregServo(interval, freq)
+		s.steps = steps
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown servo %q, must be one of %s, %s", name, ServoPI, ServoLinreg)
+}
+
+// stepThresholds are offsets above which servo steps the clock instead of correcting it by frequency
+type stepThresholds struct {
+	// first is used until the servo is locked
+	first time.Duration
+	// locked is used once it is
+	locked time.Duration
+}
+
+// defaultStepThresholds step the clock on the first sample like linuxptp first_step_threshold.
+// Unlike linuxptp, where step_threshold is 0 and the locked clock is never stepped, it is stepped above servoStep
+var defaultStepThresholds = stepThresholds{first: servoFirstStep, locked: servoStep}
+
+// needed checks if the offset is too big to be corrected by frequency
+func (t stepThresholds) needed(locked bool, offset time.Duration) bool {
+	if offset < 0 {
+		offset = -offset
+	}
+	return !locked && offset > t.first || locked && offset > t.locked
+}
+
+// PI servo constants, same as defaults of linuxptp
+const (
+	servoKP = 0.7
+	servoKI = 0.3
+	// servoFirstStep is the offset above which the clock is stepped on the first sample
+	servoFirstStep = 20 * time.Microsecond
+	// servoStep is the offset above which the clock is stepped once locked, linuxptp doesn't step by default
+	servoStep = time.Second
+	// servoMaxPPB is the max frequency adjustment
+	servoMaxPPB = 500000
+)
+
+// piServo is a proportional-integral servo disciplining frequency of the clock from its offsets
+type piServo struct {
+	kp float64
+	ki float64
+	// drift is the integral term, which is the negated frequency of the clock
+	drift  float64
+	locked bool
+	steps  stepThresholds
+}
+
+// newPIServo returns servo of the clock sampled every interval with given initial frequency in ppb
+func newPIServo(interval time.Duration, freq float64) *piServo {
+	s := interval.Seconds()
+	if s <= 0 {
+		s = 1
+	}
+	return &piServo{
+		kp:    math.Min(servoKP, servoKP/s),
+		ki:    math.Min(servoKI, servoKI/s),
+		drift: -freq,
+		steps: defaultStepThresholds,
+	}
+}
+
+// sample implements servo
+func (s *piServo) sample(offset time.Duration, _ time.Time) (float64, time.Duration) {
+	if s.steps.needed(s.locked, offset) {
+		s.locked = true
+		return -s.drift, -offset
+	}
+	s.locked = true
+	ns := float64(offset.Nanoseconds())
+	ki := s.ki * ns
+	ppb := s.kp*ns + s.drift + ki
+	s.drift = clampPPB(s.drift + ki)
+	return -clampPPB(ppb), 0
+}
+
+// reset makes the servo start over keeping the frequency
+func (s *piServo) reset() {
+	s.locked = false
+}
+
+// isLocked implements servo
+func (s *piServo) isLocked() bool {
+	return s.locked
+}
+
+// setFrequency implements servo
+func (s *piServo) setFrequency(ppb float64) {
+	s.drift = -ppb
+}
+
+// resume implements servo
+func (s *piServo) resume(ppb float64) {
+	s.setFrequency(ppb)
+	s.locked = true
+}
+
+// linregPoints is how many last samples linear regression servo fits
+const linregPoints = 16
+
+// linregPoint is the offset in ns the clock would have at x seconds since the start without any frequency adjustments
+type linregPoint struct {
+	x float64
+	y float64
+}
+
+// linregServo estimates frequency of the clock by least squares fit of its offsets, corrected for
+// the frequency it set itself. It converges in a few samples, which suits sparse exchanges better than PI.
+type linregServo struct {
+	interval float64
+	// freq is the last frequency set in ppb
+	freq float64
+	// drift is the frequency in ppb compensating the drift of the clock, estimated by the last fit
+	drift  float64
+	locked bool
+	steps  stepThresholds
+	points []linregPoint
+	// phase is how far in ns frequency adjustments moved the clock since the start
+	phase float64
+	start time.Time
+	last  time.Time
+}
+
+// newLinregServo returns linear regression servo of the clock sampled every interval with given initial frequency in ppb
+func newLinregServo(interval time.Duration, freq float64) *linregServo {
+	s := interval.Seconds()
+	if s <= 0 {
+		s = 1
+	}
+	return &linregServo{interval: s, freq: freq, drift: freq, steps: defaultStepThresholds}
+}
+
+// restart forgets samples, after the clock was stepped or its frequency changed
+func (s *linregServo) restart() {
+	s.points = nil
+	s.phase = 0
+	s.start = time.Time{}
+}
+
+// sample implements servo
+func (s *linregServo) sample(offset time.Duration, now time.Time) (float64, time.Duration) {
+	if s.steps.needed(s.locked, offset) {
+		s.locked = true
+		s.restart()
+		return s.freq, -offset
+	}
+	s.locked = true
+	if s.start.IsZero() {
+		s.start = now
+	} else {
+		s.phase += s.freq * now.Sub(s.last).Seconds()
+	}
+	s.last = now
+	ns := float64(offset.Nanoseconds())
+	s.points = append(s.points, linregPoint{x: now.Sub(s.start).Seconds(), y: ns - s.phase})
+	if len(s.points) > linregPoints {
+		s.points = s.points[1:]
+	}
+	predicted := ns
+	if len(s.points) > 1 {
+		slope, intercept := s.fit()
+		s.drift = clampPPB(-slope)
+		predicted = intercept + slope*s.points[len(s.points)-1].x + s.phase
+	}
+	// follow the drift, and correct the offset by the next sample
+	s.freq = clampPPB(s.drift - predicted/s.interval)
+	return s.freq, 0
+}
+
+// fit returns slope and intercept of the least squares fit of recorded points
+func (s *linregServo) fit() (float64, float64) {
+	n := float64(len(s.points))
+	var meanX, meanY float64
+	for _, p := range s.points {
+		meanX += p.x
+		meanY += p.y
+	}
+	meanX /= n
+	meanY /= n
+	var sxy, sxx float64
+	for _, p := range s.points {
+		sxy += (p.x - meanX) * (p.y - meanY)
+		sxx += (p.x - meanX) * (p.x - meanX)
+	}
+	if sxx == 0 {
+		return 0, meanY
+	}
+	slope := sxy / sxx
+	return slope, meanY - slope*meanX
+}
+
+// isLocked implements servo
+func (s *linregServo) isLocked() bool {
+	return s.locked
+}
+
+// setFrequency implements servo
+func (s *linregServo) setFrequency(ppb float64) {
+	s.freq = ppb
+	s.drift = ppb
+	s.restart()
+}
+
+// resume implements servo
+func (s *linregServo) resume(ppb float64) {
+	s.setFrequency(ppb)
+	s.locked = true
+}
+
+func clampPPB(ppb float64) float64 {
+	return math.Max(-servoMaxPPB, math.Min(servoMaxPPB, ppb))
+}
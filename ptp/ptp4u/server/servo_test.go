@@ -0,0 +1,144 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPIServoFirstStep(t *testing.T) {
+	s := newPIServo(time.Second, 100)
+
+	// clock too far ahead is stepped back, keeping the frequency
+	ppb, step := s.sample(time.Millisecond, time.Time{})
+	require.Equal(t, -time.Millisecond, step)
+	require.Equal(t, 100.0, ppb)
+	require.True(t, s.locked)
+
+	// small offsets are then followed by frequency
+	ppb, step = s.sample(time.Microsecond, time.Time{})
+	require.Equal(t, time.Duration(0), step)
+	require.InDelta(t, 100-1000.0, ppb, 0.001)
+
+	// huge offsets are stepped again
+	_, step = s.sample(-2*time.Second, time.Time{})
+	require.Equal(t, 2*time.Second, step)
+
+	s.reset()
+	require.False(t, s.locked)
+}
+
+func TestPIServoNoFirstStep(t *testing.T) {
+	s := newPIServo(time.Second, 0)
+
+	ppb, step := s.sample(10*time.Microsecond, time.Time{})
+	require.Equal(t, time.Duration(0), step)
+	require.InDelta(t, -10000.0, ppb, 0.001)
+	// integral keeps what was learnt
+	ppb, _ = s.sample(0, time.Time{})
+	require.InDelta(t, -3000.0, ppb, 0.001)
+}
+
+func TestPIServoInterval(t *testing.T) {
+	s := newPIServo(2*time.Second, 0)
+	require.Equal(t, 0.35, s.kp)
+	require.Equal(t, 0.15, s.ki)
+
+	s = newPIServo(time.Second/4, 0)
+	require.Equal(t, servoKP, s.kp)
+	require.Equal(t, servoKI, s.ki)
+}
+
+func TestPIServoClamped(t *testing.T) {
+	s := newPIServo(time.Second, 0)
+	s.locked = true
+	ppb, step := s.sample(900*time.Millisecond, time.Time{})
+	require.Equal(t, time.Duration(0), step)
+	require.Equal(t, -float64(servoMaxPPB), ppb)
+	require.Equal(t, float64(servoMaxPPB), s.drift)
+}
+
+func TestServoResume(t *testing.T) {
+	for _, name := range []string{ServoPI, ServoLinreg} {
+		s, err := newServo(name, time.Second, 0, defaultStepThresholds)
+		require.NoError(t, err)
+		s.resume(-300)
+		require.True(t, s.isLocked(), name)
+		// resumed servo doesn't step by the first step threshold
+		ppb, step := s.sample(100*time.Microsecond, time.Unix(0, 0))
+		require.Equal(t, time.Duration(0), step, name)
+		require.Less(t, ppb, -300.0, name)
+	}
+}
+
+func TestNewServo(t *testing.T) {
+	s, err := newServo("", time.Second, 0, defaultStepThresholds)
+	require.NoError(t, err)
+	require.IsType(t, &piServo{}, s)
+	s, err = newServo(ServoLinreg, time.Second, 0, defaultStepThresholds)
+	require.NoError(t, err)
+	require.IsType(t, &linregServo{}, s)
+	_, err = newServo("kalman", time.Second, 0, defaultStepThresholds)
+	require.Error(t, err)
+}
+
+func TestLinregServoStep(t *testing.T) {
+	s := newLinregServo(time.Second, 100)
+	require.False(t, s.isLocked())
+
+	ppb, step := s.sample(time.Millisecond, time.Unix(0, 0))
+	require.Equal(t, -time.Millisecond, step)
+	require.Equal(t, 100.0, ppb)
+	require.True(t, s.isLocked())
+
+	// single sample only corrects the offset
+	ppb, step = s.sample(time.Microsecond, time.Unix(1, 0))
+	require.Equal(t, time.Duration(0), step)
+	require.InDelta(t, 100-1000.0, ppb, 0.001)
+}
+
+func TestLinregServoConverges(t *testing.T) {
+	// clock running 5000ppb fast, 10us ahead
+	const drift = 5000.0
+	s := newLinregServo(time.Second, 0)
+	offset := 10000.0
+	now := time.Unix(0, 0)
+	ppb := 0.0
+	for i := 0; i < 20; i++ {
+		var step time.Duration
+		ppb, step = s.sample(time.Duration(offset), now)
+		require.Equal(t, time.Duration(0), step)
+		now = now.Add(time.Second)
+		offset += drift + ppb
+	}
+	require.InDelta(t, -drift, ppb, 1)
+	require.InDelta(t, 0, offset, 1)
+}
+
+func TestLinregServoSetFrequency(t *testing.T) {
+	s := newLinregServo(time.Second, 0)
+	s.sample(0, time.Unix(0, 0))
+	s.sample(time.Microsecond, time.Unix(1, 0))
+	s.setFrequency(-300)
+	require.Empty(t, s.points)
+	// without a fit the servo continues from the set frequency
+	ppb, _ := s.sample(0, time.Unix(2, 0))
+	require.InDelta(t, -300.0, ppb, 0.001)
+}
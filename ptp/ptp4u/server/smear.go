@@ -0,0 +1,127 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/facebook/time/leapsectz"
+)
+
+// SmearMode is a way leap seconds are smeared
+type SmearMode int
+
+// Leap second smearing modes
+const (
+	// SmearNone announces leap seconds instead of smearing them
+	SmearNone SmearMode = iota
+	// SmearLinear shifts timestamps at a constant rate over the window
+	SmearLinear
+	// SmearCosine shifts timestamps slower at the edges of the window and faster in the middle
+	SmearCosine
+)
+
+// SmearModeToString is a map from SmearMode to the string
+var SmearModeToString = map[SmearMode]string{
+	SmearNone:   "none",
+	SmearLinear: "linear",
+	SmearCosine: "cosine",
+}
+
+// String returns SmearMode as a string
+func (m SmearMode) String() string {
+	s, found := SmearModeToString[m]
+	if !found {
+		return "unsupported"
+	}
+	return s
+}
+
+// Set implements flag.Value
+func (m *SmearMode) Set(value string) error {
+	for k, v := range SmearModeToString {
+		if v == value {
+			*m = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown smear mode %q", value)
+}
+
+// Type implements pflag.Value
+func (m *SmearMode) Type() string {
+	return "smearmode"
+}
+
+// fraction returns which part of the leap second is smeared at p part of the window passed
+func (m SmearMode) fraction(p float64) float64 {
+	switch m {
+	case SmearLinear:
+		return p
+	case SmearCosine:
+		return (1 - math.Cos(math.Pi*p)) / 2
+	}
+	return 0
+}
+
+// smearEvent returns the leap second to smear next at the moment in PTP timescale and its size, zero if there is none
+func smearEvent(leaps []leapsectz.LeapSecond, at time.Time) (time.Time, time.Duration) {
+	var nleap int32
+	for _, l := range leaps {
+		if !at.Before(l.Time()) {
+			nleap = l.Nleap
+			continue
+		}
+		return l.Time().Add(initialUTCOffset + time.Duration(l.Nleap)*time.Second), time.Duration(l.Nleap-nleap) * time.Second
+	}
+	return time.Time{}, 0
+}
+
+// setSmear atomically sets the leap second to smear
+func (c *Config) setSmear(event time.Time, delta time.Duration) {
+	c.leapMux.Lock()
+	defer c.leapMux.Unlock()
+	c.smearEvent = event
+	c.smearDelta = delta
+}
+
+// smearOffset returns how much timestamp in PTP timescale is shifted back to smear the leap second.
+// The window is centered at the leap second, outside of it timestamps are not shifted.
+func (c *Config) smearOffset(ts time.Time) time.Duration {
+	if c.LeapSmear == SmearNone || c.LeapSmearWindow <= 0 {
+		return 0
+	}
+	c.leapMux.RLock()
+	event, delta := c.smearEvent, c.smearDelta
+	c.leapMux.RUnlock()
+	if event.IsZero() {
+		return 0
+	}
+	start := event.Add(-c.LeapSmearWindow / 2)
+	if ts.Before(start) || !ts.Before(start.Add(c.LeapSmearWindow)) {
+		return 0
+	}
+	p := float64(ts.Sub(start)) / float64(c.LeapSmearWindow)
+	return time.Duration(c.LeapSmear.fraction(p) * float64(delta))
+}
+
+// smear shifts timestamp in PTP timescale to smear the leap second
+func (c *Config) smear(ts time.Time) time.Time {
+	return ts.Add(-c.smearOffset(ts))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmearModeSet(t *testing.T) {
+	var m SmearMode
+	require.NoError(t, m.Set("cosine"))
+	require.Equal(t, SmearCosine, m)
+	require.Equal(t, "cosine", m.String())
+	require.Error(t, m.Set("sine"))
+	require.Equal(t, "unsupported", SmearMode(42).String())
+}
+
+func TestSmearModeFraction(t *testing.T) {
+	require.Equal(t, 0.0, SmearNone.fraction(0.5))
+	require.Equal(t, 0.25, SmearLinear.fraction(0.25))
+	require.InDelta(t, 0.0, SmearCosine.fraction(0), 1e-9)
+	require.InDelta(t, 0.5, SmearCosine.fraction(0.5), 1e-9)
+	require.InDelta(t, 1.0, SmearCosine.fraction(1), 1e-9)
+	require.Less(t, SmearCosine.fraction(0.25), SmearLinear.fraction(0.25))
+}
+
+func TestSmearEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leap-seconds.list")
+	require.NoError(t, os.WriteFile(path, []byte(testLeapSecondsList), 0644))
+	leaps, err := readLeapSeconds(path)
+	require.NoError(t, err)
+
+	event2017 := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	event2024 := time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	event, delta := smearEvent(leaps, event2017.Add(-time.Hour))
+	require.Equal(t, event2017.Add(37*time.Second), event.UTC())
+	require.Equal(t, time.Second, delta)
+
+	event, delta = smearEvent(leaps, event2017)
+	require.Equal(t, event2024.Add(36*time.Second), event.UTC())
+	require.Equal(t, -time.Second, delta)
+
+	event, delta = smearEvent(leaps, event2024)
+	require.True(t, event.IsZero())
+	require.Equal(t, time.Duration(0), delta)
+}
+
+func TestSmearOffset(t *testing.T) {
+	event := time.Date(2017, time.January, 1, 0, 0, 37, 0, time.UTC)
+	c := &Config{LeapSmearWindow: 24 * time.Hour}
+	c.setSmear(event, time.Second)
+
+	// disabled
+	require.Equal(t, time.Duration(0), c.smearOffset(event))
+
+	c.LeapSmear = SmearLinear
+	require.Equal(t, time.Duration(0), c.smearOffset(event.Add(-13*time.Hour)))
+	require.Equal(t, time.Duration(0), c.smearOffset(event.Add(-12*time.Hour)))
+	require.Equal(t, 250*time.Millisecond, c.smearOffset(event.Add(-6*time.Hour)))
+	require.Equal(t, 500*time.Millisecond, c.smearOffset(event))
+	require.Equal(t, event.Add(-500*time.Millisecond), c.smear(event))
+	require.Equal(t, 750*time.Millisecond, c.smearOffset(event.Add(6*time.Hour)))
+	require.Equal(t, time.Duration(0), c.smearOffset(event.Add(12*time.Hour)))
+
+	c.LeapSmear = SmearCosine
+	require.InDelta(t, float64(500*time.Millisecond), float64(c.smearOffset(event)), 1)
+	require.InDelta(t, float64(146446609*time.Nanosecond), float64(c.smearOffset(event.Add(-6*time.Hour))), 1)
+
+	// negative leap second is smeared forward
+	c.LeapSmear = SmearLinear
+	c.setSmear(event, -time.Second)
+	require.Equal(t, -500*time.Millisecond, c.smearOffset(event))
+	require.Equal(t, event.Add(500*time.Millisecond), c.smear(event))
+
+	// nothing to smear
+	c.setSmear(time.Time{}, 0)
+	require.Equal(t, time.Duration(0), c.smearOffset(event))
+}
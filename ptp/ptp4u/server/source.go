@@ -0,0 +1,92 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"unsafe"
+
+	"github.com/facebook/time/timestamp"
+	"golang.org/x/sys/unix"
+)
+
+// boundToAny reports if the server sockets of the family of sa are bound to the unspecified address.
+// The kernel picks source address of packets sent from such sockets by the route to the client,
+// which on multi-homed hosts may differ from the address the client sent its request to.
+func (c *Config) boundToAny(sa unix.Sockaddr) bool {
+	for _, ip := range c.ips() {
+		if ipFamily(ip) == sockaddrFamily(sa) {
+			return ip.IsUnspecified()
+		}
+	}
+	return false
+}
+
+// sourceOOB returns control message making a single packet sent to sa leave from src, nil if src is not set
+func sourceOOB(sa unix.Sockaddr, src net.IP) []byte {
+	if sockaddrFamily(sa) == unix.AF_INET {
+		ip := src.To4()
+		if ip == nil {
+			return nil
+		}
+		b := make([]byte, unix.CmsgSpace(unix.SizeofInet4Pktinfo))
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+		h.Level, h.Type = unix.IPPROTO_IP, unix.IP_PKTINFO
+		h.SetLen(unix.CmsgLen(unix.SizeofInet4Pktinfo))
+		pi := (*unix.Inet4Pktinfo)(unsafe.Pointer(&b[unix.CmsgLen(0)]))
+		copy(pi.Spec_dst[:], ip)
+		return b
+	}
+	// IPv4 clients of IPv6 sockets are served from IPv4-mapped addresses
+	ip := src.To16()
+	if ip == nil {
+		return nil
+	}
+	b := make([]byte, unix.CmsgSpace(unix.SizeofInet6Pktinfo))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level, h.Type = unix.IPPROTO_IPV6, unix.IPV6_PKTINFO
+	h.SetLen(unix.CmsgLen(unix.SizeofInet6Pktinfo))
+	pi := (*unix.Inet6Pktinfo)(unsafe.Pointer(&b[unix.CmsgLen(0)]))
+	copy(pi.Addr[:], ip)
+	return b
+}
+
+// setSource makes all packets of the subscription leave from src, which is the address the client sent its request to.
+// Empty src lets the kernel pick the address.
+func (sc *SubscriptionClient) setSource(src net.IP) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.src = append(net.IP(nil), src...)
+	sc.srcOOB = sourceOOB(sc.gclisa, src)
+}
+
+// sendGeneral sends b to sa from the general socket, passing control messages in oob if there are any
+func sendGeneral(gFd int, b, oob []byte, sa unix.Sockaddr) error {
+	if len(oob) == 0 {
+		return unix.Sendto(gFd, b, 0, sa)
+	}
+	_, err := unix.SendmsgN(gFd, b, oob, sa, 0)
+	return err
+}
+
+// sendEvent sends b to sa from the event socket like timestamp.SendtoWithDrain, passing control messages in oob if there are any
+func sendEvent(eFd int, b, oob []byte, sa unix.Sockaddr) error {
+	if len(oob) == 0 {
+		return timestamp.SendtoWithDrain(eFd, b, sa)
+	}
+	return timestamp.SendmsgWithDrain(eFd, b, oob, sa)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestBoundToAny(t *testing.T) {
+	sa4 := timestamp.IPToSockaddr(net.ParseIP("192.168.0.1"), ptp.PortGeneral)
+	sa6 := timestamp.IPToSockaddr(net.ParseIP("2001:db8::1"), ptp.PortGeneral)
+
+	c := &Config{IP: net.ParseIP("::")}
+	require.False(t, c.boundToAny(sa4))
+	require.True(t, c.boundToAny(sa6))
+
+	c = &Config{IP: net.ParseIP("2001:db8::2"), SecondaryIP: net.IPv4zero}
+	require.True(t, c.boundToAny(sa4))
+	require.False(t, c.boundToAny(sa6))
+}
+
+func TestSourceOOB(t *testing.T) {
+	sa4 := timestamp.IPToSockaddr(net.ParseIP("192.168.0.1"), ptp.PortGeneral)
+	sa6 := timestamp.IPToSockaddr(net.ParseIP("2001:db8::1"), ptp.PortGeneral)
+	require.Nil(t, sourceOOB(sa4, nil))
+	require.Nil(t, sourceOOB(sa6, nil))
+	require.Nil(t, sourceOOB(sa4, net.ParseIP("2001:db8::2")))
+
+	msgs, err := unix.ParseSocketControlMessage(sourceOOB(sa4, net.ParseIP("192.168.0.2")))
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, int32(unix.IPPROTO_IP), msgs[0].Header.Level)
+	require.Equal(t, int32(unix.IP_PKTINFO), msgs[0].Header.Type)
+	require.Len(t, msgs[0].Data, unix.SizeofInet4Pktinfo)
+	// ifindex and local address are left for the kernel to pick, only spec_dst is set
+	require.Equal(t, []byte{192, 168, 0, 2}, msgs[0].Data[4:8])
+
+	// IPv4 clients of IPv6 sockets get IPv4-mapped source
+	msgs, err = unix.ParseSocketControlMessage(sourceOOB(sa6, net.ParseIP("192.168.0.2")))
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, int32(unix.IPPROTO_IPV6), msgs[0].Header.Level)
+	require.Equal(t, int32(unix.IPV6_PKTINFO), msgs[0].Header.Type)
+	require.Len(t, msgs[0].Data, unix.SizeofInet6Pktinfo)
+	require.Equal(t, []byte(net.ParseIP("192.168.0.2").To16()), msgs[0].Data[:16])
+}
+
+func TestSendFromSource(t *testing.T) {
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer client.Close()
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	require.NoError(t, err)
+	defer unix.Close(fd)
+
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), client.LocalAddr().(*net.UDPAddr).Port)
+	sc := NewSubscriptionClient(nil, sa, sa, ptp.MessageSync, &Config{}, time.Second, time.Time{})
+	buf := make([]byte, 16)
+
+	// kernel picks the source address by default
+	require.NoError(t, sendGeneral(fd, []byte{1}, sc.srcOOB, sc.gclisa))
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	_, from, err := client.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", from.IP.String())
+
+	// any loopback address will do as the address the request was sent to
+	sc.setSource(net.ParseIP("127.0.0.3"))
+	require.NoError(t, sendGeneral(fd, []byte{2}, sc.srcOOB, sc.gclisa))
+	n, from, err := client.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, buf[:n])
+	require.Equal(t, "127.0.0.3", from.IP.String())
+
+	require.NoError(t, sendEvent(fd, []byte{3}, sc.srcOOB, sc.eclisa))
+	n, from, err = client.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{3}, buf[:n])
+	require.Equal(t, "127.0.0.3", from.IP.String())
+}
@@ -17,12 +17,11 @@ package server
 
 import (
 	"encoding/binary"
-	"fmt"
+	"net"
 	"sync"
 	"time"
 
 	ptp "github.com/facebook/time/ptp/protocol"
-	"github.com/facebook/time/timestamp"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
@@ -40,9 +39,25 @@ type SubscriptionClient struct {
 	sequenceID uint16
 	running    bool
 
+	// clients served via multicast don't have grants
+	multicast bool
+	// granted is set for subscriptions created by unicast grant requests
+	granted bool
+
+	// client the subscription is registered for
+	clientID ptp.PortIdentity
+
+	// Delay_Req rate limiting
+	delayReqTokens    float64
+	delayReqLast      time.Time
+	delayReqThrottled int
+
 	// socket addresses
 	eclisa unix.Sockaddr
 	gclisa unix.Sockaddr
+	// address packets are sent from and control message selecting it, empty if the kernel picks it
+	src    net.IP
+	srcOOB []byte
 
 	// packets
 	syncP      *ptp.SyncDelayReq
@@ -50,6 +65,10 @@ type SubscriptionClient struct {
 	announceP  *ptp.Announce
 	delayRespP *ptp.DelayResp
 	grant      *ptp.Signaling
+
+	// peer delay packets
+	pdelayRespP         *ptp.PDelayResp
+	pdelayRespFollowUpP *ptp.PDelayRespFollowUp
 }
 
 // NewSubscriptionClient gets minimal required arguments to create a subscription
@@ -69,18 +88,18 @@ func NewSubscriptionClient(q chan *SubscriptionClient, eclisa, gclisa unix.Socka
 	s.initAnnounce()
 	s.initDelayResp()
 	s.initGrant()
+	s.initPDelayResp()
 
 	return s
 }
 
 // Start launches the subscription timers and exit on expire
 func (sc *SubscriptionClient) Start() {
-	log.Infof("Starting a new %s subscription for %s", sc.subscriptionType, timestamp.SockaddrToIP(sc.eclisa))
+	sc.log(log.InfoLevel, "Starting a new subscription")
 	sc.setRunning(true)
 
-	over := fmt.Sprintf("Subscription %s is over for %s", sc.subscriptionType, timestamp.SockaddrToIP(sc.eclisa))
 	// Send first message right away
-	if sc.subscriptionType != ptp.MessageDelayResp {
+	if sc.subscriptionType != ptp.MessageDelayResp && sc.subscriptionType != ptp.MessagePDelayResp {
 		sc.Once()
 	}
 
@@ -92,7 +111,7 @@ func (sc *SubscriptionClient) Start() {
 
 	for range intervalTicker.C {
 		if sc.Expired() {
-			log.Infof(over)
+			sc.log(log.InfoLevel, "Subscription is over")
 			// TODO send cancellation
 			return
 		}
@@ -101,7 +120,7 @@ func (sc *SubscriptionClient) Start() {
 			intervalTicker.Reset(sc.interval)
 			oldInterval = sc.interval
 		}
-		if sc.subscriptionType != ptp.MessageDelayResp {
+		if sc.subscriptionType != ptp.MessageDelayResp && sc.subscriptionType != ptp.MessagePDelayResp {
 			// Add myself to the worker queue
 			sc.Once()
 		}
@@ -110,7 +129,17 @@ func (sc *SubscriptionClient) Start() {
 
 // Once adds itself to the worker queue once
 func (sc *SubscriptionClient) Once() {
-	sc.queue <- sc
+	sc.Lock()
+	q := sc.queue
+	sc.Unlock()
+	q <- sc
+}
+
+// setQueue atomically moves the subscription to another worker queue
+func (sc *SubscriptionClient) setQueue(q chan *SubscriptionClient) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.queue = q
 }
 
 // Expired checks if the subscription expired or not
@@ -135,6 +164,13 @@ func (sc *SubscriptionClient) setRunning(running bool) {
 	sc.running = running
 }
 
+// Expire atomically returns expire
+func (sc *SubscriptionClient) Expire() time.Time {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.expire
+}
+
 // setExpire atomically sets expire
 func (sc *SubscriptionClient) setExpire(expire time.Time) {
 	sc.Lock()
@@ -142,6 +178,13 @@ func (sc *SubscriptionClient) setExpire(expire time.Time) {
 	sc.expire = expire
 }
 
+// Interval atomically returns interval
+func (sc *SubscriptionClient) Interval() time.Duration {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.interval
+}
+
 // setInterval atomically sets interval
 func (sc *SubscriptionClient) setInterval(interval time.Duration) {
 	sc.Lock()
@@ -149,6 +192,61 @@ func (sc *SubscriptionClient) setInterval(interval time.Duration) {
 	sc.interval = interval
 }
 
+// setVersion sets versionPTP of all packets sent within the subscription
+func (sc *SubscriptionClient) setVersion(version uint8) {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.syncP.Version = version
+	sc.followupP.Version = version
+	sc.announceP.Version = version
+	sc.delayRespP.Version = version
+	sc.grant.Version = version
+	sc.pdelayRespP.Version = version
+	sc.pdelayRespFollowUpP.Version = version
+}
+
+// delayReqBurst is how many Delay_Req above the allowed rate we tolerate, as clients randomize the time they send them
+const delayReqBurst = 2
+
+// delayReqAllowed checks if the client sends Delay_Req no faster than once per interval on average
+func (sc *SubscriptionClient) delayReqAllowed(now time.Time, interval time.Duration) bool {
+	sc.Lock()
+	defer sc.Unlock()
+	if interval <= 0 {
+		return true
+	}
+	if sc.delayReqLast.IsZero() {
+		sc.delayReqTokens = delayReqBurst
+	} else {
+		sc.delayReqTokens += float64(now.Sub(sc.delayReqLast)) / float64(interval)
+		if sc.delayReqTokens > delayReqBurst {
+			sc.delayReqTokens = delayReqBurst
+		}
+	}
+	sc.delayReqLast = now
+	if sc.delayReqTokens < 1 {
+		sc.delayReqThrottled++
+		return false
+	}
+	sc.delayReqTokens--
+	sc.delayReqThrottled = 0
+	return true
+}
+
+// DelayReqThrottled returns number of consecutive Delay_Req not answered as they were sent too fast
+func (sc *SubscriptionClient) DelayReqThrottled() int {
+	sc.Lock()
+	defer sc.Unlock()
+	return sc.delayReqThrottled
+}
+
+// resetDelayReqThrottled resets the number of Delay_Req sent too fast
+func (sc *SubscriptionClient) resetDelayReqThrottled() {
+	sc.Lock()
+	defer sc.Unlock()
+	sc.delayReqThrottled = 0
+}
+
 // Running returns the running bool
 func (sc *SubscriptionClient) Running() bool {
 	sc.Lock()
@@ -161,22 +259,19 @@ func (sc *SubscriptionClient) IncSequenceID() {
 	sc.sequenceID++
 }
 
+// builder returns ptp.Builder producing headers of the packets we send
+func (sc *SubscriptionClient) builder() *ptp.Builder {
+	b := ptp.NewBuilder(ptp.PortIdentity{PortNumber: 1, ClockIdentity: sc.serverConfig.clockIdentity}, sc.serverConfig.DomainNumber)
+	b.Version = sc.serverConfig.ptpVersion()
+	b.Unicast = true
+	b.TwoStep = true
+	b.PTPTimescale = true
+	return b
+}
+
 func (sc *SubscriptionClient) initSync() {
 	sc.syncP = &ptp.SyncDelayReq{
-		Header: ptp.Header{
-			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageSync, 0),
-			Version:         ptp.Version,
-			MessageLength:   uint16(binary.Size(ptp.SyncDelayReq{})),
-			DomainNumber:    0,
-			FlagField:       ptp.FlagUnicast | ptp.FlagTwoStep,
-			SequenceID:      0,
-			SourcePortIdentity: ptp.PortIdentity{
-				PortNumber:    1,
-				ClockIdentity: sc.serverConfig.clockIdentity,
-			},
-			LogMessageInterval: 0x7f,
-			ControlField:       0,
-		},
+		Header: sc.builder().Header(ptp.MessageSync, binary.Size(ptp.SyncDelayReq{}), 0x7f),
 	}
 }
 
@@ -185,6 +280,12 @@ func (sc *SubscriptionClient) UpdateSync() {
 	sc.syncP.SequenceID = sc.sequenceID
 }
 
+// UpdateSyncOneStep updates ptp Sync packet sent in one-step mode where the NIC inserts the origin timestamp
+func (sc *SubscriptionClient) UpdateSyncOneStep() {
+	sc.syncP.SequenceID = sc.sequenceID
+	sc.syncP.FlagField &^= ptp.FlagTwoStep
+}
+
 // Sync returns ptp Sync packet
 func (sc *SubscriptionClient) Sync() *ptp.SyncDelayReq {
 	return sc.syncP
@@ -192,20 +293,7 @@ func (sc *SubscriptionClient) Sync() *ptp.SyncDelayReq {
 
 func (sc *SubscriptionClient) initFollowup() {
 	sc.followupP = &ptp.FollowUp{
-		Header: ptp.Header{
-			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageFollowUp, 0),
-			Version:         ptp.Version,
-			MessageLength:   uint16(binary.Size(ptp.FollowUp{})),
-			DomainNumber:    0,
-			FlagField:       ptp.FlagUnicast,
-			SequenceID:      0,
-			SourcePortIdentity: ptp.PortIdentity{
-				PortNumber:    1,
-				ClockIdentity: sc.serverConfig.clockIdentity,
-			},
-			LogMessageInterval: 0,
-			ControlField:       2,
-		},
+		Header: sc.builder().Header(ptp.MessageFollowUp, binary.Size(ptp.FollowUp{}), 0),
 		FollowUpBody: ptp.FollowUpBody{
 			PreciseOriginTimestamp: ptp.NewTimestamp(time.Now()),
 		},
@@ -227,33 +315,16 @@ func (sc *SubscriptionClient) Followup() *ptp.FollowUp {
 
 func (sc *SubscriptionClient) initAnnounce() {
 	sc.announceP = &ptp.Announce{
-		Header: ptp.Header{
-			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageAnnounce, 0),
-			Version:         ptp.Version,
-			MessageLength:   uint16(binary.Size(ptp.Announce{})),
-			DomainNumber:    0,
-			FlagField:       ptp.FlagUnicast | ptp.FlagPTPTimescale,
-			SequenceID:      0,
-			SourcePortIdentity: ptp.PortIdentity{
-				PortNumber:    1,
-				ClockIdentity: sc.serverConfig.clockIdentity,
-			},
-			LogMessageInterval: 0,
-			ControlField:       5,
-		},
+		Header: sc.builder().Header(ptp.MessageAnnounce, binary.Size(ptp.Header{})+binary.Size(ptp.AnnounceBody{}), 0),
 		AnnounceBody: ptp.AnnounceBody{
-			CurrentUTCOffset:     0,
-			Reserved:             0,
-			GrandmasterPriority1: 128,
-			GrandmasterClockQuality: ptp.ClockQuality{
-				ClockClass:              6,
-				ClockAccuracy:           33, // 0x21 - Time Accurate within 100ns
-				OffsetScaledLogVariance: 23008,
-			},
-			GrandmasterPriority2: 128,
-			GrandmasterIdentity:  sc.serverConfig.clockIdentity,
-			StepsRemoved:         0,
-			TimeSource:           ptp.TimeSourceGNSS,
+			CurrentUTCOffset:        0,
+			Reserved:                0,
+			GrandmasterPriority1:    128,
+			GrandmasterClockQuality: sc.serverConfig.clockQuality(),
+			GrandmasterPriority2:    128,
+			GrandmasterIdentity:     sc.serverConfig.clockIdentity,
+			StepsRemoved:            0,
+			TimeSource:              ptp.TimeSourceGNSS,
 		},
 	}
 }
@@ -264,6 +335,14 @@ func (sc *SubscriptionClient) UpdateAnnounce() {
 	sc.announceP.SequenceID = sc.sequenceID
 	sc.announceP.LogMessageInterval = i
 	sc.announceP.CurrentUTCOffset = int16(sc.serverConfig.UTCOffset.Seconds())
+	sc.announceP.FlagField = sc.announceP.FlagField&^(ptp.FlagLeap61|ptp.FlagLeap59|ptp.FlagCurrentUtcOffsetValid) | sc.serverConfig.leapIndicator()
+	// additional domains have static clock quality
+	if d, ok := sc.serverConfig.extraDomain(sc.announceP.DomainNumber); ok {
+		sc.announceP.GrandmasterClockQuality = sc.serverConfig.timestampQuality(d.ClockQuality)
+	} else {
+		sc.announceP.GrandmasterClockQuality = sc.serverConfig.timestampQuality(sc.serverConfig.clockQuality())
+	}
+	sc.serverConfig.propagateParent(sc.announceP)
 }
 
 // Announce returns ptp Announce packet
@@ -273,21 +352,7 @@ func (sc *SubscriptionClient) Announce() *ptp.Announce {
 
 func (sc *SubscriptionClient) initDelayResp() {
 	sc.delayRespP = &ptp.DelayResp{
-		Header: ptp.Header{
-			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageDelayResp, 0),
-			Version:         ptp.Version,
-			MessageLength:   uint16(binary.Size(ptp.DelayResp{})),
-			DomainNumber:    0,
-			FlagField:       ptp.FlagUnicast,
-			SequenceID:      0,
-			SourcePortIdentity: ptp.PortIdentity{
-				PortNumber:    1,
-				ClockIdentity: sc.serverConfig.clockIdentity,
-			},
-			LogMessageInterval: 0x7f,
-			ControlField:       3,
-			CorrectionField:    0,
-		},
+		Header:        sc.builder().Header(ptp.MessageDelayResp, binary.Size(ptp.DelayResp{}), 0x7f),
 		DelayRespBody: ptp.DelayRespBody{},
 	}
 }
@@ -310,7 +375,7 @@ func (sc *SubscriptionClient) DelayResp() *ptp.DelayResp {
 func (sc *SubscriptionClient) initGrant() {
 	sc.grant = &ptp.Signaling{
 		Header: ptp.Header{
-			Version:       ptp.Version,
+			Version:       sc.serverConfig.ptpVersion(),
 			MessageLength: uint16(binary.Size(ptp.Header{}) + binary.Size(ptp.PortIdentity{}) + binary.Size(ptp.GrantUnicastTransmissionTLV{})),
 			FlagField:     ptp.FlagUnicast,
 			SourcePortIdentity: ptp.PortIdentity{
@@ -339,6 +404,14 @@ func (sc *SubscriptionClient) UpdateGrant(sg *ptp.Signaling, mt ptp.UnicastMsgTy
 	sc.grant.Header.SequenceID = sg.Header.SequenceID
 	sc.grant.Header.ControlField = sg.Header.ControlField
 	sc.grant.Header.LogMessageInterval = sg.Header.LogMessageInterval
+	// standard clients expect our own header rather than the echo of their request
+	if sc.serverConfig.interop(sg.DomainNumber) {
+		h := sc.builder().Header(ptp.MessageSignaling, 0, 0x7f)
+		sc.grant.Header.CorrectionField = 0
+		sc.grant.Header.MessageTypeSpecific = 0
+		sc.grant.Header.ControlField = h.ControlField
+		sc.grant.Header.LogMessageInterval = h.LogMessageInterval
+	}
 
 	sc.grant.TargetPortIdentity = sg.SourcePortIdentity
 	tlv := sc.grant.TLVs[0].(*ptp.GrantUnicastTransmissionTLV)
@@ -354,3 +427,20 @@ func (sc *SubscriptionClient) UpdateGrant(sg *ptp.Signaling, mt ptp.UnicastMsgTy
 func (sc *SubscriptionClient) Grant() *ptp.Signaling {
 	return sc.grant
 }
+
+// Cancel returns ptp Signaling packet cancelling subscription of the client
+func (sc *SubscriptionClient) Cancel(clientID ptp.PortIdentity) *ptp.Signaling {
+	h := sc.builder().Header(ptp.MessageSignaling, binary.Size(ptp.Header{})+binary.Size(ptp.PortIdentity{})+binary.Size(ptp.CancelUnicastTransmissionTLV{}), 0x7f)
+	// use the version negotiated with the client
+	h.Version = sc.grant.Version
+	return &ptp.Signaling{
+		Header:             h,
+		TargetPortIdentity: clientID,
+		TLVs: []ptp.TLV{
+			&ptp.CancelUnicastTransmissionTLV{
+				TLVHead:         ptp.TLVHead{TLVType: ptp.TLVCancelUnicastTransmission, LengthField: uint16(binary.Size(ptp.CancelUnicastTransmissionTLV{}) - binary.Size(ptp.TLVHead{}))},
+				MsgTypeAndFlags: ptp.NewUnicastMsgTypeAndFlags(sc.subscriptionType, 0),
+			},
+		},
+	}
+}
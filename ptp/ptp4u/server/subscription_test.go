@@ -108,6 +108,22 @@ func TestSubscriptionflags(t *testing.T) {
 	require.Equal(t, ptp.FlagUnicast|ptp.FlagPTPTimescale, sc.Announce().Header.FlagField)
 }
 
+func TestSubscriptionVersion(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234), Version: ptp.MajorVersion}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageAnnounce, c, time.Second, time.Time{})
+	require.Equal(t, ptp.MajorVersion, sc.Sync().Version)
+	require.Equal(t, ptp.MajorVersion, sc.Grant().Version)
+
+	sc.setVersion(ptp.Version)
+	require.Equal(t, ptp.Version, sc.Sync().Version)
+	require.Equal(t, ptp.Version, sc.Followup().Version)
+	require.Equal(t, ptp.Version, sc.Announce().Version)
+	require.Equal(t, ptp.Version, sc.DelayResp().Version)
+	require.Equal(t, ptp.Version, sc.Grant().Version)
+}
+
 func TestSyncPacket(t *testing.T) {
 	sequenceID := uint16(42)
 
@@ -123,6 +139,20 @@ func TestSyncPacket(t *testing.T) {
 	require.Equal(t, uint16(sequenceID+1), sc.Sync().Header.SequenceID)
 }
 
+func TestSyncPacketOneStep(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageSync, c, time.Second, time.Time{})
+	require.Equal(t, ptp.FlagTwoStep, sc.Sync().FlagField&ptp.FlagTwoStep)
+
+	sc.IncSequenceID()
+	sc.UpdateSyncOneStep()
+	require.Equal(t, uint16(1), sc.Sync().SequenceID)
+	require.Equal(t, uint16(0), sc.Sync().FlagField&ptp.FlagTwoStep)
+	require.Equal(t, ptp.FlagUnicast, sc.Sync().FlagField&ptp.FlagUnicast)
+}
+
 func TestFollowupPacket(t *testing.T) {
 	sequenceID := uint16(42)
 	now := time.Now()
@@ -235,3 +265,44 @@ func TestGrantPacket(t *testing.T) {
 	require.Equal(t, tlv, sc.Grant().TLVs[0])
 
 }
+
+func TestDelayReqAllowed(t *testing.T) {
+	sc := &SubscriptionClient{}
+	now := time.Now()
+	require.True(t, sc.delayReqAllowed(now, 0))
+	require.True(t, sc.delayReqAllowed(now, 0))
+
+	require.True(t, sc.delayReqAllowed(now, time.Second))
+	require.True(t, sc.delayReqAllowed(now, time.Second))
+	require.False(t, sc.delayReqAllowed(now, time.Second))
+	require.False(t, sc.delayReqAllowed(now.Add(500*time.Millisecond), time.Second))
+	require.Equal(t, 2, sc.DelayReqThrottled())
+	require.True(t, sc.delayReqAllowed(now.Add(time.Second), time.Second))
+	require.Equal(t, 0, sc.DelayReqThrottled())
+	require.True(t, sc.delayReqAllowed(now.Add(10*time.Second), time.Second))
+	require.True(t, sc.delayReqAllowed(now.Add(10*time.Second), time.Second))
+	require.False(t, sc.delayReqAllowed(now.Add(10*time.Second), time.Second))
+	require.Equal(t, 1, sc.DelayReqThrottled())
+
+	sc.resetDelayReqThrottled()
+	require.Equal(t, 0, sc.DelayReqThrottled())
+}
+
+func TestCancelPacket(t *testing.T) {
+	w := &sendWorker{}
+	c := &Config{clockIdentity: ptp.ClockIdentity(1234)}
+	sa := timestamp.IPToSockaddr(net.ParseIP("127.0.0.1"), 123)
+	sc := NewSubscriptionClient(w.queue, sa, sa, ptp.MessageDelayResp, c, time.Second, time.Time{})
+	clientID := ptp.PortIdentity{PortNumber: 1, ClockIdentity: 5678}
+
+	p := sc.Cancel(clientID)
+	require.Equal(t, clientID, p.TargetPortIdentity)
+	b, err := ptp.Bytes(p)
+	require.NoError(t, err)
+
+	got := &ptp.Signaling{}
+	require.NoError(t, ptp.FromBytes(b, got))
+	require.Equal(t, p, got)
+	tlv := got.TLVs[0].(*ptp.CancelUnicastTransmissionTLV)
+	require.Equal(t, ptp.MessageDelayResp, tlv.MsgTypeAndFlags.MsgType())
+}
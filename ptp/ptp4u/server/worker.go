@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 
 	ptp "github.com/facebook/time/ptp/protocol"
 	"github.com/facebook/time/ptp/ptp4u/stats"
@@ -42,40 +44,99 @@ func enableDSCP(fd int, localAddr net.IP, dscp int) error {
 	return nil
 }
 
+// dscpOOB returns control message overriding DSCP of a single packet sent to sa
+func dscpOOB(sa unix.Sockaddr, dscp int) []byte {
+	b := make([]byte, unix.CmsgSpace(4))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level, h.Type = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+	if sockaddrFamily(sa) == unix.AF_INET {
+		h.Level, h.Type = unix.IPPROTO_IP, unix.IP_TOS
+	}
+	h.SetLen(unix.CmsgLen(4))
+	*(*int32)(unsafe.Pointer(&b[unix.CmsgLen(0)])) = int32(dscp << 2)
+	return b
+}
+
+// enableOneStep tries to switch the interface to one-step Sync and reports if it supports it.
+// Interfaces which don't support it stay in two-step mode.
+func enableOneStep(fd int, iface string) (bool, error) {
+	caps, err := timestamp.EnableOneStepSync(fd, iface)
+	if err != nil {
+		return false, err
+	}
+	return caps.OneStepSync, nil
+}
+
 // sendWorker monitors the queue of jobs
 type sendWorker struct {
 	mux    sync.Mutex
 	id     int
 	queue  chan *SubscriptionClient
 	config *Config
-	stats  stats.Stats
+	stats  stats.ServerStats
+
+	// oneStep is set when the NIC inserts TX timestamps into Sync packets
+	oneStep bool
+
+	// socket settings, fixed for the lifetime of the worker so config reload can replace workers
+	iface         string
+	timestampType timestamp.Timestamp
+	eventDSCP     int
+	generalDSCP   int
+	// stop is closed to make the worker exit
+	stop chan struct{}
 
 	clients map[ptp.MessageType]map[ptp.PortIdentity]*SubscriptionClient
+	// number of granted subscriptions to event messages in clients, read atomically
+	eventGrants int64
+
+	// worst queue depth and TX timestamp latency since the last autoscale check, read atomically
+	maxQueue       int64
+	maxTXTSLatency int64
 }
 
 func NewSendWorker(i int, c *Config, st stats.Stats) *sendWorker {
 	s := &sendWorker{
 		id:     i,
 		config: c,
-		stats:  st,
+		stats:  stats.Extend(st),
+
+		iface:         c.Interface,
+		timestampType: c.TimestampType,
+		eventDSCP:     c.DSCP,
+		generalDSCP:   c.generalDSCP(),
+		stop:          make(chan struct{}),
 	}
 	s.clients = make(map[ptp.MessageType]map[ptp.PortIdentity]*SubscriptionClient)
 	s.queue = make(chan *SubscriptionClient, c.QueueSize)
 	return s
 }
 
-func (s *sendWorker) listen() (eventFD, generalFD int, err error) {
-	// socket domain differs depending whether we are listening on ipv4 or ipv6
-	domain := unix.AF_INET6
-	if s.config.IP.To4() != nil {
-		domain = unix.AF_INET
+// listen sets up sockets for every address the server listens on
+func (s *sendWorker) listen() (*workerSockets, error) {
+	ws := newWorkerSockets()
+	for _, ip := range s.config.ips() {
+		eventFD, generalFD, err := s.listenIP(ip)
+		if err != nil {
+			ws.close()
+			return nil, err
+		}
+		ws.event[ipFamily(ip)] = eventFD
+		ws.general[ipFamily(ip)] = generalFD
 	}
+	return ws, nil
+}
+
+// listenIP sets up event and general sockets sending from the ip
+func (s *sendWorker) listenIP(ip net.IP) (eventFD, generalFD int, err error) {
+	// socket domain differs depending whether we are listening on ipv4 or ipv6
+	domain := ipFamily(ip)
 	// set up event connection
 	eventFD, err = unix.Socket(domain, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
 	if err != nil {
 		return -1, -1, fmt.Errorf("creating event socket error: %w", err)
 	}
-	sockAddrAnyPort := timestamp.IPToSockaddr(s.config.IP, 0)
+	sockAddrAnyPort := timestamp.IPToSockaddr(ip, 0)
 
 	// set SO_REUSEPORT so we can potentially trace network path from same source port.
 	// needs to be set before we bind to a port.
@@ -101,22 +162,31 @@ func (s *sendWorker) listen() (eventFD, generalFD int, err error) {
 		log.Errorf("Unexpected local addr type %T", v)
 	}
 
-	if err = enableDSCP(eventFD, s.config.IP, s.config.DSCP); err != nil {
+	if err = enableDSCP(eventFD, ip, s.eventDSCP); err != nil {
 		return -1, -1, fmt.Errorf("setting DSCP on event socket: %w", err)
 	}
 
 	// Syncs sent from event port, so need to turn on timestamping here
-	switch s.config.TimestampType {
-	case timestamp.HWTIMESTAMP:
-		if err := timestamp.EnableHWTimestampsSocket(eventFD, s.config.Interface); err != nil {
-			return -1, -1, fmt.Errorf("failed to enable RX hardware timestamps: %w", err)
+	ts, err := timestamp.EnableTimestamps(eventFD, s.iface, s.timestampType)
+	if err != nil {
+		return -1, -1, fmt.Errorf("failed to enable %s timestamps: %w", s.timestampType, err)
+	}
+	if ts != s.timestampType {
+		return -1, -1, fmt.Errorf("failed to enable %s timestamps, only %s are available", s.timestampType, ts)
+	}
+
+	if s.config.OneStep && s.timestampType == timestamp.HW {
+		if s.oneStep, err = enableOneStep(eventFD, s.iface); err != nil {
+			return -1, -1, fmt.Errorf("failed to enable one-step sync: %w", err)
 		}
-	case timestamp.SWTIMESTAMP:
-		if err := timestamp.EnableSWTimestampsSocket(eventFD); err != nil {
-			return -1, -1, fmt.Errorf("unable to enable RX software timestamps: %w", err)
+	}
+
+	// multicast is only served on the primary address
+	multicast := (s.config.Multicast() || s.config.PeerDelay) && ip.Equal(s.config.IP)
+	if multicast {
+		if err = setMulticastInterface(eventFD, s.config); err != nil {
+			return -1, -1, fmt.Errorf("setting multicast interface on event socket: %w", err)
 		}
-	default:
-		return -1, -1, fmt.Errorf("unrecognized timestamp type: %s", s.config.TimestampType)
 	}
 
 	// set up general connection
@@ -134,20 +204,29 @@ func (s *sendWorker) listen() (eventFD, generalFD int, err error) {
 		return -1, -1, fmt.Errorf("binding event socket connection: %w", err)
 	}
 	// enable DSCP
-	if err = enableDSCP(generalFD, s.config.IP, s.config.DSCP); err != nil {
+	if err = enableDSCP(generalFD, ip, s.generalDSCP); err != nil {
 		return -1, -1, fmt.Errorf("setting DSCP on general socket: %w", err)
 	}
+	if multicast {
+		if err = setMulticastInterface(generalFD, s.config); err != nil {
+			return -1, -1, fmt.Errorf("setting multicast interface on general socket: %w", err)
+		}
+	}
 	return
 }
 
 // Start a SendWorker which will pull data from the queue and send Sync and Followup packets
 func (s *sendWorker) Start() {
-	eFd, gFd, err := s.listen()
+	ws, err := s.listen()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer unix.Close(eFd)
-	defer unix.Close(gFd)
+	s.run(ws)
+}
+
+// run sends packets of the queued subscriptions via the sockets until the worker is stopped
+func (s *sendWorker) run(ws *workerSockets) {
+	defer ws.close()
 
 	// reusable buffers
 	buf := make([]byte, timestamp.PayloadSizeBytes)
@@ -161,49 +240,70 @@ func (s *sendWorker) Start() {
 		attempts int
 		txTS     time.Time
 		c        *SubscriptionClient
+		err      error
+		eFd      int
+		gFd      int
 	)
 
-	for c = range s.queue {
+	for {
+		select {
+		case c = <-s.queue:
+		case <-s.stop:
+			return
+		}
+		eFd, gFd = ws.fds(c.eclisa, c.gclisa)
 		switch c.subscriptionType {
 		case ptp.MessageSync:
 			// send sync
-			c.UpdateSync()
+			if s.oneStep {
+				c.UpdateSyncOneStep()
+			} else {
+				c.UpdateSync()
+			}
 			n, err = ptp.BytesTo(c.Sync(), buf)
 			if err != nil {
-				log.Errorf("Failed to generate the sync packet: %v", err)
+				c.log(log.ErrorLevel, "Failed to generate the sync packet: %v", err)
 				continue
 			}
 			log.Debugf("Sending sync")
 
-			err = unix.Sendto(eFd, buf[:n], 0, c.eclisa)
+			err = sendEvent(eFd, buf[:n], c.srcOOB, c.eclisa)
 			if err != nil {
-				log.Errorf("Failed to send the sync packet: %v", err)
+				c.log(log.ErrorLevel, "Failed to send the sync packet: %v", err)
 				continue
 			}
 			s.stats.IncTX(c.subscriptionType)
+			// NIC has put TX timestamp into the Sync itself, no need in Follow Up
+			if s.oneStep {
+				break
+			}
 
+			txtsStart := time.Now()
 			txTS, attempts, err = timestamp.ReadTXtimestampBuf(eFd, oob, toob)
+			s.observeTXTS(time.Since(txtsStart))
 			s.stats.SetMaxTXTSAttempts(s.id, int64(attempts))
 			if err != nil {
-				log.Warningf("Failed to read TX timestamp: %v", err)
+				c.log(log.WarnLevel, "Failed to read TX timestamp: %v", err)
+				s.config.txtsFailed()
 				continue
 			}
-			if s.config.TimestampType != timestamp.HWTIMESTAMP {
+			s.config.txtsOK()
+			if s.timestampType != timestamp.HW {
 				txTS = txTS.Add(s.config.UTCOffset)
 			}
 
 			// send followup
-			c.UpdateFollowup(txTS)
+			c.UpdateFollowup(s.config.smear(txTS))
 			n, err = ptp.BytesTo(c.Followup(), buf)
 			if err != nil {
-				log.Errorf("Failed to generate the followup packet: %v", err)
+				c.log(log.ErrorLevel, "Failed to generate the followup packet: %v", err)
 				continue
 			}
 			log.Debugf("Sending followup")
 
-			err = unix.Sendto(gFd, buf[:n], 0, c.gclisa)
+			err = sendGeneral(gFd, buf[:n], c.srcOOB, c.gclisa)
 			if err != nil {
-				log.Errorf("Failed to send the followup packet: %v", err)
+				c.log(log.ErrorLevel, "Failed to send the followup packet: %v", err)
 				continue
 			}
 			s.stats.IncTX(ptp.MessageFollowUp)
@@ -212,14 +312,14 @@ func (s *sendWorker) Start() {
 			c.UpdateAnnounce()
 			n, err = ptp.BytesTo(c.Announce(), buf)
 			if err != nil {
-				log.Errorf("Failed to prepare the announce packet: %v", err)
+				c.log(log.ErrorLevel, "Failed to prepare the announce packet: %v", err)
 				continue
 			}
 			log.Debugf("Sending announce")
 
-			err = unix.Sendto(gFd, buf[:n], 0, c.gclisa)
+			err = sendGeneral(gFd, buf[:n], c.srcOOB, c.gclisa)
 			if err != nil {
-				log.Errorf("Failed to send the announce packet: %v", err)
+				c.log(log.ErrorLevel, "Failed to send the announce packet: %v", err)
 				continue
 			}
 			s.stats.IncTX(c.subscriptionType)
@@ -228,25 +328,77 @@ func (s *sendWorker) Start() {
 			// send delay response
 			n, err = ptp.BytesTo(c.DelayResp(), buf)
 			if err != nil {
-				log.Errorf("Failed to prepare the delay response packet: %v", err)
+				c.log(log.ErrorLevel, "Failed to prepare the delay response packet: %v", err)
 				continue
 			}
 			log.Debugf("Sending delay response")
 
-			err = unix.Sendto(gFd, buf[:n], 0, c.gclisa)
+			// Delay_Resp is sent from the general socket, but belongs to the event class
+			if s.eventDSCP != s.generalDSCP {
+				err = sendGeneral(gFd, buf[:n], append(dscpOOB(c.gclisa, s.eventDSCP), c.srcOOB...), c.gclisa)
+			} else {
+				err = sendGeneral(gFd, buf[:n], c.srcOOB, c.gclisa)
+			}
+			if err != nil {
+				c.log(log.ErrorLevel, "Failed to send the delay response: %v", err)
+				continue
+			}
+			s.stats.IncTX(c.subscriptionType)
+
+		case ptp.MessagePDelayResp:
+			// send peer delay response
+			n, err = ptp.BytesTo(c.PDelayResp(), buf)
+			if err != nil {
+				c.log(log.ErrorLevel, "Failed to prepare the peer delay response packet: %v", err)
+				continue
+			}
+			log.Debugf("Sending peer delay response")
+
+			err = sendEvent(eFd, buf[:n], c.srcOOB, c.eclisa)
 			if err != nil {
-				log.Errorf("Failed to send the delay response: %v", err)
+				c.log(log.ErrorLevel, "Failed to send the peer delay response: %v", err)
 				continue
 			}
 			s.stats.IncTX(c.subscriptionType)
 
+			txtsStart := time.Now()
+			txTS, attempts, err = timestamp.ReadTXtimestampBuf(eFd, oob, toob)
+			s.observeTXTS(time.Since(txtsStart))
+			s.stats.SetMaxTXTSAttempts(s.id, int64(attempts))
+			if err != nil {
+				c.log(log.WarnLevel, "Failed to read TX timestamp: %v", err)
+				s.config.txtsFailed()
+				continue
+			}
+			s.config.txtsOK()
+			if s.timestampType != timestamp.HW {
+				txTS = txTS.Add(s.config.UTCOffset)
+			}
+
+			// send peer delay response followup
+			c.UpdatePDelayRespFollowUp(txTS)
+			n, err = ptp.BytesTo(c.PDelayRespFollowUp(), buf)
+			if err != nil {
+				c.log(log.ErrorLevel, "Failed to prepare the peer delay response followup packet: %v", err)
+				continue
+			}
+			log.Debugf("Sending peer delay response followup")
+
+			err = sendGeneral(gFd, buf[:n], c.srcOOB, c.gclisa)
+			if err != nil {
+				c.log(log.ErrorLevel, "Failed to send the peer delay response followup: %v", err)
+				continue
+			}
+			s.stats.IncTX(ptp.MessagePDelayRespFollowUp)
+
 		default:
-			log.Errorf("Unknown subscription type: %v", c.subscriptionType)
+			c.log(log.ErrorLevel, "Unknown subscription type: %v", c.subscriptionType)
 			continue
 		}
 
 		c.IncSequenceID()
 		s.stats.SetMaxWorkerQueue(s.id, int64(len(s.queue)))
+		s.observeQueue(len(s.queue))
 	}
 }
 
@@ -275,7 +427,14 @@ func (s *sendWorker) RegisterSubscription(clientID ptp.PortIdentity, st ptp.Mess
 		s.clients[st] = map[ptp.PortIdentity]*SubscriptionClient{}
 		m = s.clients[st]
 	}
+	if old, ok := m[clientID]; ok && old.consumesCapacity() {
+		atomic.AddInt64(&s.eventGrants, -1)
+	}
+	if sc.consumesCapacity() {
+		atomic.AddInt64(&s.eventGrants, 1)
+	}
 	m[clientID] = sc
+	sc.setClientID(clientID)
 }
 
 func (s *sendWorker) inventoryClients() {
@@ -285,10 +444,33 @@ func (s *sendWorker) inventoryClients() {
 		for k, sc := range subs {
 			if !sc.Running() {
 				delete(subs, k)
+				if sc.consumesCapacity() {
+					atomic.AddInt64(&s.eventGrants, -1)
+				}
+				if sc.granted {
+					s.stats.IncGrantEnded(st)
+				}
 				continue
 			}
 			s.stats.IncSubscription(st)
 			s.stats.IncWorkerSubs(s.id)
 		}
 	}
+	// clients watching Announce without getting time from us
+	for clientID, sc := range s.clients[ptp.MessageAnnounce] {
+		if sc.granted && s.monitoringOnly(clientID) {
+			s.stats.IncMonitoringSubs()
+		}
+	}
+}
+
+// monitoringOnly checks if the client has no Sync and Delay_Resp subscriptions.
+// Caller must hold the worker lock.
+func (s *sendWorker) monitoringOnly(clientID ptp.PortIdentity) bool {
+	for _, st := range []ptp.MessageType{ptp.MessageSync, ptp.MessageDelayResp} {
+		if _, ok := s.clients[st][clientID]; ok {
+			return false
+		}
+	}
+	return true
 }
@@ -25,12 +25,13 @@ import (
 	"github.com/facebook/time/ptp/ptp4u/stats"
 	"github.com/facebook/time/timestamp"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
 func TestWorkerQueue(t *testing.T) {
 	c := &Config{
 		clockIdentity: ptp.ClockIdentity(1234),
-		TimestampType: timestamp.SWTIMESTAMP,
+		TimestampType: timestamp.SW,
 	}
 
 	st := stats.NewJSONStats()
@@ -61,7 +62,7 @@ func TestWorkerQueue(t *testing.T) {
 func TestFindSubscription(t *testing.T) {
 	c := &Config{
 		clockIdentity: ptp.ClockIdentity(1234),
-		TimestampType: timestamp.SWTIMESTAMP,
+		TimestampType: timestamp.SW,
 	}
 
 	w := &sendWorker{
@@ -146,3 +147,30 @@ func TestInventoryClients(t *testing.T) {
 	w.inventoryClients()
 	require.Equal(t, 0, len(w.clients[ptp.MessageSync]))
 }
+
+func TestDSCPOOB(t *testing.T) {
+	rFd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	require.NoError(t, err)
+	defer unix.Close(rFd)
+	require.NoError(t, unix.SetsockoptInt(rFd, unix.IPPROTO_IP, unix.IP_RECVTOS, 1))
+	require.NoError(t, unix.Bind(rFd, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	sa, err := unix.Getsockname(rFd)
+	require.NoError(t, err)
+
+	sFd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	require.NoError(t, err)
+	defer unix.Close(sFd)
+	require.NoError(t, enableDSCP(sFd, net.ParseIP("127.0.0.1"), 8))
+	_, err = unix.SendmsgN(sFd, []byte("ptp"), dscpOOB(sa, 46), sa, 0)
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	oob := make([]byte, 64)
+	_, oobn, _, _, err := unix.Recvmsg(rFd, buf, oob, 0)
+	require.NoError(t, err)
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+	require.Equal(t, int32(unix.IP_TOS), msgs[0].Header.Type)
+	require.Equal(t, byte(46<<2), msgs[0].Data[0])
+}
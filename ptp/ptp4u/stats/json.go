@@ -29,6 +29,8 @@ import (
 // JSONStats is what we want to report as stats via http
 type JSONStats struct {
 	report counters
+	// total accumulates counters since the start for Prometheus
+	total counters
 
 	counters
 }
@@ -39,6 +41,7 @@ func NewJSONStats() *JSONStats {
 
 	s.init()
 	s.report.init()
+	s.total.init()
 
 	return s
 }
@@ -47,6 +50,7 @@ func NewJSONStats() *JSONStats {
 func (s *JSONStats) Start(monitoringport int) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleRequest)
+	mux.HandleFunc("/metrics", s.handlePrometheus)
 	addr := fmt.Sprintf(":%d", monitoringport)
 	log.Infof("Starting http json server on %s", addr)
 	err := http.ListenAndServe(addr, mux)
@@ -61,11 +65,32 @@ func (s *JSONStats) Snapshot() {
 	s.rx.copy(&s.report.rx)
 	s.tx.copy(&s.report.tx)
 	s.rxSignaling.copy(&s.report.rxSignaling)
+	s.rxSignalingRejected.copy(&s.report.rxSignalingRejected)
+	s.grantsNew.copy(&s.report.grantsNew)
+	s.grantsEnded.copy(&s.report.grantsEnded)
+	s.capacityRejected.copy(&s.report.capacityRejected)
+	s.capacityEvicted.copy(&s.report.capacityEvicted)
 	s.txSignaling.copy(&s.report.txSignaling)
 	s.workerQueue.copy(&s.report.workerQueue)
 	s.workerSubs.copy(&s.report.workerSubs)
 	s.txtsattempts.copy(&s.report.txtsattempts)
-	s.report.utcoffset = s.utcoffset
+	atomic.StoreInt64(&s.report.utcoffset, atomic.LoadInt64(&s.utcoffset))
+	atomic.StoreInt64(&s.report.txtsMissing, atomic.LoadInt64(&s.txtsMissing))
+	atomic.StoreInt64(&s.report.txtsDrained, atomic.LoadInt64(&s.txtsDrained))
+	atomic.StoreInt64(&s.report.tsZero, atomic.LoadInt64(&s.tsZero))
+	atomic.StoreInt64(&s.report.swFallback, atomic.LoadInt64(&s.swFallback))
+	atomic.StoreInt64(&s.report.delayReqThrottled, atomic.LoadInt64(&s.delayReqThrottled))
+	atomic.StoreInt64(&s.report.monitoringSubs, atomic.LoadInt64(&s.monitoringSubs))
+	atomic.StoreInt64(&s.report.leapSmear, atomic.LoadInt64(&s.leapSmear))
+	atomic.StoreInt64(&s.report.tsDegraded, atomic.LoadInt64(&s.tsDegraded))
+	atomic.StoreInt64(&s.report.holdoverDuration, atomic.LoadInt64(&s.holdoverDuration))
+	atomic.StoreInt64(&s.report.holdoverError, atomic.LoadInt64(&s.holdoverError))
+	atomic.StoreInt64(&s.report.upstreamOffset, atomic.LoadInt64(&s.upstreamOffset))
+	atomic.StoreInt64(&s.report.upstreamDelay, atomic.LoadInt64(&s.upstreamDelay))
+	atomic.StoreInt64(&s.report.upstreamSWTS, atomic.LoadInt64(&s.upstreamSWTS))
+	atomic.StoreInt64(&s.report.upstreamNTPOffset, atomic.LoadInt64(&s.upstreamNTPOffset))
+	atomic.StoreInt64(&s.report.upstreamNTPAlarm, atomic.LoadInt64(&s.upstreamNTPAlarm))
+	s.total.accumulate(&s.report)
 }
 
 // handleRequest is a handler used for all http monitoring requests
@@ -111,11 +136,46 @@ func (s *JSONStats) IncTXSignaling(t ptp.MessageType) {
 	s.txSignaling.inc(int(t))
 }
 
+// IncRXSignalingRejected atomically add 1 to the counter
+func (s *JSONStats) IncRXSignalingRejected(t ptp.MessageType) {
+	s.rxSignalingRejected.inc(int(t))
+}
+
 // IncWorkerSubs atomically add 1 to the counter
 func (s *JSONStats) IncWorkerSubs(workerid int) {
 	s.workerSubs.inc(workerid)
 }
 
+// IncGrantNew atomically add 1 to the counter
+func (s *JSONStats) IncGrantNew(t ptp.MessageType) {
+	s.grantsNew.inc(int(t))
+}
+
+// IncGrantEnded atomically add 1 to the counter
+func (s *JSONStats) IncGrantEnded(t ptp.MessageType) {
+	s.grantsEnded.inc(int(t))
+}
+
+// IncCapacityRejected atomically add 1 to the counter
+func (s *JSONStats) IncCapacityRejected(t ptp.MessageType) {
+	s.capacityRejected.inc(int(t))
+}
+
+// IncCapacityEvicted atomically add 1 to the counter
+func (s *JSONStats) IncCapacityEvicted(t ptp.MessageType) {
+	s.capacityEvicted.inc(int(t))
+}
+
+// IncDelayReqThrottled atomically add 1 to the counter
+func (s *JSONStats) IncDelayReqThrottled() {
+	atomic.AddInt64(&s.delayReqThrottled, 1)
+}
+
+// IncMonitoringSubs atomically add 1 to the counter
+func (s *JSONStats) IncMonitoringSubs() {
+	atomic.AddInt64(&s.monitoringSubs, 1)
+}
+
 // DecSubscription atomically removes 1 from the counter
 func (s *JSONStats) DecSubscription(t ptp.MessageType) {
 	s.subscriptions.dec(int(t))
@@ -164,3 +224,56 @@ func (s *JSONStats) SetMaxTXTSAttempts(workerid int, attempts int64) {
 func (s *JSONStats) SetUTCOffset(utcoffset int64) {
 	atomic.StoreInt64(&s.utcoffset, utcoffset)
 }
+
+// SetLeapSmear atomically sets the current leap smear offset in nanoseconds
+func (s *JSONStats) SetLeapSmear(offset int64) {
+	atomic.StoreInt64(&s.leapSmear, offset)
+}
+
+// SetTSDegraded atomically sets if software timestamps are used as the NIC stopped producing hardware ones
+func (s *JSONStats) SetTSDegraded(degraded int64) {
+	atomic.StoreInt64(&s.tsDegraded, degraded)
+}
+
+// SetHoldover atomically sets for how long in seconds the boundary clock is in holdover and its estimated time error in nanoseconds
+func (s *JSONStats) SetHoldover(duration, estimatedError int64) {
+	atomic.StoreInt64(&s.holdoverDuration, duration)
+	atomic.StoreInt64(&s.holdoverError, estimatedError)
+}
+
+// SetUpstream atomically sets the last offset and path delay in nanoseconds measured against the upstream grandmaster
+func (s *JSONStats) SetUpstream(offset, delay int64) {
+	atomic.StoreInt64(&s.upstreamOffset, offset)
+	atomic.StoreInt64(&s.upstreamDelay, delay)
+}
+
+// SetUpstreamSWTS atomically sets if the boundary clock measures the upstream grandmaster with software timestamps
+func (s *JSONStats) SetUpstreamSWTS(sw int64) {
+	atomic.StoreInt64(&s.upstreamSWTS, sw)
+}
+
+// SetUpstreamNTP atomically sets the last offset in nanoseconds of the boundary clock from NTP time and if it's above the threshold
+func (s *JSONStats) SetUpstreamNTP(offset, alarm int64) {
+	atomic.StoreInt64(&s.upstreamNTPOffset, offset)
+	atomic.StoreInt64(&s.upstreamNTPAlarm, alarm)
+}
+
+// IncTXTSMissing atomically add 1 to the counter
+func (s *JSONStats) IncTXTSMissing() {
+	atomic.AddInt64(&s.txtsMissing, 1)
+}
+
+// IncTSZero atomically add 1 to the counter
+func (s *JSONStats) IncTSZero() {
+	atomic.AddInt64(&s.tsZero, 1)
+}
+
+// IncTXTSDrained atomically add 1 to the counter
+func (s *JSONStats) IncTXTSDrained() {
+	atomic.AddInt64(&s.txtsDrained, 1)
+}
+
+// IncSWFallback atomically add 1 to the counter
+func (s *JSONStats) IncSWFallback() {
+	atomic.AddInt64(&s.swFallback, 1)
+}
@@ -93,6 +93,16 @@ func TestJSONStatsRXSignaling(t *testing.T) {
 	require.Equal(t, int64(0), stats.rxSignaling.load(int(ptp.MessageSync)))
 }
 
+func TestJSONStatsRXSignalingRejected(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.IncRXSignalingRejected(ptp.MessageSync)
+	require.Equal(t, int64(1), stats.rxSignalingRejected.load(int(ptp.MessageSync)))
+
+	stats.Snapshot()
+	require.Equal(t, int64(1), stats.report.toMap()["rx.signaling.rejected.sync"])
+}
+
 func TestJSONStatsTXSignaling(t *testing.T) {
 	stats := NewJSONStats()
 
@@ -103,6 +113,32 @@ func TestJSONStatsTXSignaling(t *testing.T) {
 	require.Equal(t, int64(0), stats.txSignaling.load(int(ptp.MessageSync)))
 }
 
+func TestJSONStatsGrants(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.IncGrantNew(ptp.MessageSync)
+	stats.IncGrantEnded(ptp.MessageAnnounce)
+	require.Equal(t, int64(1), stats.grantsNew.load(int(ptp.MessageSync)))
+	require.Equal(t, int64(1), stats.grantsEnded.load(int(ptp.MessageAnnounce)))
+
+	stats.Snapshot()
+	require.Equal(t, int64(1), stats.report.toMap()["grants.new.sync"])
+	require.Equal(t, int64(1), stats.report.toMap()["grants.ended.announce"])
+}
+
+func TestJSONStatsCapacity(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.IncCapacityRejected(ptp.MessageSync)
+	stats.IncCapacityEvicted(ptp.MessageAnnounce)
+	require.Equal(t, int64(1), stats.capacityRejected.load(int(ptp.MessageSync)))
+	require.Equal(t, int64(1), stats.capacityEvicted.load(int(ptp.MessageAnnounce)))
+
+	stats.Snapshot()
+	require.Equal(t, int64(1), stats.report.toMap()["capacity.rejected.sync"])
+	require.Equal(t, int64(1), stats.report.toMap()["capacity.evicted.announce"])
+}
+
 func TestJSONStatsSetMaxWorkerQueue(t *testing.T) {
 	stats := NewJSONStats()
 
@@ -134,6 +170,106 @@ func TestJSONStatsSetUTCOffset(t *testing.T) {
 	require.Equal(t, int64(42), stats.utcoffset)
 }
 
+func TestJSONStatsSetLeapSmear(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.SetLeapSmear(-42)
+	require.Equal(t, int64(-42), stats.leapSmear)
+}
+
+func TestJSONStatsSetTSDegraded(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.SetTSDegraded(1)
+	require.Equal(t, int64(1), stats.tsDegraded)
+
+	stats.Snapshot()
+	require.Equal(t, int64(1), stats.report.toMap()["ts.degraded"])
+}
+
+func TestJSONStatsSetHoldover(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.SetHoldover(60, 1200)
+	require.Equal(t, int64(60), stats.holdoverDuration)
+	require.Equal(t, int64(1200), stats.holdoverError)
+
+	stats.Snapshot()
+	require.Equal(t, int64(60), stats.report.toMap()["holdover.duration"])
+	require.Equal(t, int64(1200), stats.report.toMap()["holdover.error"])
+}
+
+func TestJSONStatsSetUpstream(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.SetUpstream(-42, 1000)
+	require.Equal(t, int64(-42), stats.upstreamOffset)
+	require.Equal(t, int64(1000), stats.upstreamDelay)
+
+	stats.Snapshot()
+	require.Equal(t, int64(-42), stats.report.toMap()["upstream.offset"])
+	require.Equal(t, int64(1000), stats.report.toMap()["upstream.delay"])
+}
+
+func TestJSONStatsSetUpstreamSWTS(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.SetUpstreamSWTS(1)
+	require.Equal(t, int64(1), stats.upstreamSWTS)
+
+	stats.Snapshot()
+	require.Equal(t, int64(1), stats.report.toMap()["upstream.swts"])
+}
+
+func TestJSONStatsSetUpstreamNTP(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.SetUpstreamNTP(-2000000, 1)
+	require.Equal(t, int64(-2000000), stats.upstreamNTPOffset)
+	require.Equal(t, int64(1), stats.upstreamNTPAlarm)
+
+	stats.Snapshot()
+	require.Equal(t, int64(-2000000), stats.report.toMap()["upstream.ntp.offset"])
+	require.Equal(t, int64(1), stats.report.toMap()["upstream.ntp.alarm"])
+}
+
+func TestJSONStatsTimestampFailures(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.IncTXTSMissing()
+	stats.IncTXTSDrained()
+	stats.IncTXTSDrained()
+	stats.IncTSZero()
+	stats.IncSWFallback()
+	require.Equal(t, int64(1), stats.txtsMissing)
+	require.Equal(t, int64(2), stats.txtsDrained)
+	require.Equal(t, int64(1), stats.tsZero)
+	require.Equal(t, int64(1), stats.swFallback)
+}
+
+func TestJSONStatsDelayReqThrottled(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.IncDelayReqThrottled()
+	require.Equal(t, int64(1), stats.delayReqThrottled)
+
+	stats.Reset()
+	require.Equal(t, int64(0), stats.delayReqThrottled)
+}
+
+func TestJSONStatsMonitoringSubs(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.IncMonitoringSubs()
+	require.Equal(t, int64(1), stats.monitoringSubs)
+
+	stats.Snapshot()
+	require.Equal(t, int64(1), stats.report.toMap()["subscriptions.monitoring"])
+
+	stats.Reset()
+	require.Equal(t, int64(0), stats.monitoringSubs)
+}
+
 func TestJSONStatsSnapshot(t *testing.T) {
 	stats := NewJSONStats()
 
@@ -176,6 +312,7 @@ func TestJSONExport(t *testing.T) {
 	stats.IncRXSignaling(ptp.MessageDelayResp)
 	stats.IncRXSignaling(ptp.MessageDelayResp)
 	stats.SetUTCOffset(1)
+	stats.IncTXTSMissing()
 
 	stats.Snapshot()
 
@@ -195,6 +332,21 @@ func TestJSONExport(t *testing.T) {
 	expectedMap["tx.sync"] = 2
 	expectedMap["rx.signaling.delay_resp"] = 3
 	expectedMap["utcoffset"] = 1
+	expectedMap["txts.missing"] = 1
+	expectedMap["txts.drained"] = 0
+	expectedMap["ts.zero"] = 0
+	expectedMap["ts.swfallback"] = 0
+	expectedMap["delayreq.throttled"] = 0
+	expectedMap["subscriptions.monitoring"] = 0
+	expectedMap["leapsmear.offset"] = 0
+	expectedMap["ts.degraded"] = 0
+	expectedMap["holdover.duration"] = 0
+	expectedMap["holdover.error"] = 0
+	expectedMap["upstream.offset"] = 0
+	expectedMap["upstream.delay"] = 0
+	expectedMap["upstream.swts"] = 0
+	expectedMap["upstream.ntp.offset"] = 0
+	expectedMap["upstream.ntp.alarm"] = 0
 
 	require.Equal(t, expectedMap, data)
 }
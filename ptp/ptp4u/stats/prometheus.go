@@ -0,0 +1,142 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// prometheusPrefix is a prefix of all exported Prometheus metrics
+const prometheusPrefix = "ptp4u_"
+
+// accumulate adds counters (not gauges) of src to c
+func (c *counters) accumulate(src *counters) {
+	for _, m := range []struct{ dst, src *syncMapInt64 }{
+		{&c.rx, &src.rx},
+		{&c.tx, &src.tx},
+		{&c.rxSignaling, &src.rxSignaling},
+		{&c.rxSignalingRejected, &src.rxSignalingRejected},
+		{&c.txSignaling, &src.txSignaling},
+		{&c.grantsNew, &src.grantsNew},
+		{&c.grantsEnded, &src.grantsEnded},
+		{&c.capacityRejected, &src.capacityRejected},
+		{&c.capacityEvicted, &src.capacityEvicted},
+	} {
+		for _, t := range m.src.keys() {
+			m.dst.add(t, m.src.load(t))
+		}
+	}
+	atomic.AddInt64(&c.txtsMissing, atomic.LoadInt64(&src.txtsMissing))
+	atomic.AddInt64(&c.txtsDrained, atomic.LoadInt64(&src.txtsDrained))
+	atomic.AddInt64(&c.tsZero, atomic.LoadInt64(&src.tsZero))
+	atomic.AddInt64(&c.swFallback, atomic.LoadInt64(&src.swFallback))
+	atomic.AddInt64(&c.delayReqThrottled, atomic.LoadInt64(&src.delayReqThrottled))
+}
+
+// promWriter writes metrics in Prometheus text exposition format
+type promWriter struct {
+	w *bufio.Writer
+}
+
+// header writes HELP and TYPE lines of the metric
+func (p *promWriter) header(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s%s %s\n# TYPE %s%s %s\n", prometheusPrefix, name, help, prometheusPrefix, name, kind)
+}
+
+// value writes a metric without labels
+func (p *promWriter) value(name, kind, help string, v int64) {
+	p.header(name, kind, help)
+	fmt.Fprintf(p.w, "%s%s %d\n", prometheusPrefix, name, v)
+}
+
+// labeled writes a metric with one value per key of the map
+func (p *promWriter) labeled(name, kind, help, label string, m *syncMapInt64, format func(int) string) {
+	keys := m.keys()
+	if len(keys) == 0 {
+		return
+	}
+	sort.Ints(keys)
+	p.header(name, kind, help)
+	for _, k := range keys {
+		fmt.Fprintf(p.w, "%s%s{%s=%q} %d\n", prometheusPrefix, name, label, format(k), m.load(k))
+	}
+}
+
+// messageType formats message type label
+func messageType(t int) string {
+	return strings.ToLower(ptp.MessageType(t).String())
+}
+
+// workerID formats worker label
+func workerID(id int) string {
+	return fmt.Sprintf("%d", id)
+}
+
+// writePrometheus writes gauges of the last report and counters accumulated since the start
+func writePrometheus(w io.Writer, report, total *counters) error {
+	p := &promWriter{w: bufio.NewWriter(w)}
+
+	p.labeled("subscriptions", "gauge", "Active subscriptions", "type", &report.subscriptions, messageType)
+	p.labeled("rx_total", "counter", "Received messages", "type", &total.rx, messageType)
+	p.labeled("tx_total", "counter", "Sent messages", "type", &total.tx, messageType)
+	p.labeled("rx_signaling_total", "counter", "Received unicast grant requests", "type", &total.rxSignaling, messageType)
+	p.labeled("rx_signaling_rejected_total", "counter", "Rejected unicast grant requests", "type", &total.rxSignalingRejected, messageType)
+	p.labeled("tx_signaling_total", "counter", "Sent signaling messages", "type", &total.txSignaling, messageType)
+	p.labeled("grants_new_total", "counter", "Unicast grants given to new subscribers", "type", &total.grantsNew, messageType)
+	p.labeled("grants_ended_total", "counter", "Unicast grants which expired or were cancelled", "type", &total.grantsEnded, messageType)
+	p.labeled("capacity_rejected_total", "counter", "Unicast grant requests rejected as the server is at capacity", "type", &total.capacityRejected, messageType)
+	p.labeled("capacity_evicted_total", "counter", "Subscriptions evicted to make room for new subscribers", "type", &total.capacityEvicted, messageType)
+	p.labeled("worker_queue", "gauge", "Maximum worker queue length over the last interval", "worker", &report.workerQueue, workerID)
+	p.labeled("worker_subscriptions", "gauge", "Active subscriptions of the worker", "worker", &report.workerSubs, workerID)
+	p.labeled("worker_txts_attempts", "gauge", "Maximum attempts to read TX timestamp over the last interval", "worker", &report.txtsattempts, workerID)
+
+	p.value("txts_missing_total", "counter", "TX timestamps which could not be read", atomic.LoadInt64(&total.txtsMissing))
+	p.value("txts_drained_total", "counter", "Stale TX timestamps drained from the error queue", atomic.LoadInt64(&total.txtsDrained))
+	p.value("ts_zero_total", "counter", "Zero timestamps returned by the kernel", atomic.LoadInt64(&total.tsZero))
+	p.value("ts_swfallback_total", "counter", "Times software timestamps were used as hardware ones could not be enabled", atomic.LoadInt64(&total.swFallback))
+	p.value("delayreq_throttled_total", "counter", "Delay requests dropped for being over the granted rate", atomic.LoadInt64(&total.delayReqThrottled))
+	p.value("monitoring_subscriptions", "gauge", "Clients subscribed to Announce only", atomic.LoadInt64(&report.monitoringSubs))
+	p.value("utc_offset_seconds", "gauge", "Announced UTC offset", atomic.LoadInt64(&report.utcoffset))
+	p.value("leap_smear_offset_nanoseconds", "gauge", "Current leap smear offset of sent timestamps", atomic.LoadInt64(&report.leapSmear))
+	p.value("ts_degraded", "gauge", "Software timestamps are used as the NIC stopped producing hardware ones", atomic.LoadInt64(&report.tsDegraded))
+	p.value("holdover_duration_seconds", "gauge", "For how long the boundary clock is in holdover", atomic.LoadInt64(&report.holdoverDuration))
+	p.value("holdover_error_nanoseconds", "gauge", "Estimated time error accumulated by the boundary clock in holdover", atomic.LoadInt64(&report.holdoverError))
+	p.value("upstream_offset_nanoseconds", "gauge", "Last offset measured against the upstream grandmaster", atomic.LoadInt64(&report.upstreamOffset))
+	p.value("upstream_delay_nanoseconds", "gauge", "Last path delay measured to the upstream grandmaster", atomic.LoadInt64(&report.upstreamDelay))
+	p.value("upstream_swts", "gauge", "Upstream grandmaster is measured with software timestamps", atomic.LoadInt64(&report.upstreamSWTS))
+	p.value("upstream_ntp_offset_nanoseconds", "gauge", "Last offset of the boundary clock from NTP time", atomic.LoadInt64(&report.upstreamNTPOffset))
+	p.value("upstream_ntp_alarm", "gauge", "Boundary clock or upstream grandmaster is further from NTP time than the threshold", atomic.LoadInt64(&report.upstreamNTPAlarm))
+
+	return p.w.Flush()
+}
+
+// handlePrometheus is a handler of Prometheus scrapes
+func (s *JSONStats) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := writePrometheus(w, &s.report, &s.total); err != nil {
+		log.Errorf("Failed to reply: %v", err)
+	}
+}
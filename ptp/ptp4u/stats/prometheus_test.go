@@ -0,0 +1,120 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountersAccumulate(t *testing.T) {
+	total := counters{}
+	total.init()
+	c := counters{}
+	c.init()
+
+	c.tx.store(int(ptp.MessageSync), 2)
+	c.subscriptions.store(int(ptp.MessageSync), 5)
+	c.txtsMissing = 1
+	c.utcoffset = 37
+	total.accumulate(&c)
+	total.accumulate(&c)
+
+	require.Equal(t, int64(4), total.tx.load(int(ptp.MessageSync)))
+	require.Equal(t, int64(2), total.txtsMissing)
+	// gauges are not accumulated
+	require.Equal(t, 0, len(total.subscriptions.keys()))
+	require.Equal(t, int64(0), total.utcoffset)
+}
+
+func TestWritePrometheus(t *testing.T) {
+	stats := NewJSONStats()
+
+	stats.IncSubscription(ptp.MessageSync)
+	stats.IncTX(ptp.MessageSync)
+	stats.IncGrantNew(ptp.MessageSync)
+	stats.SetUTCOffset(37)
+	stats.Snapshot()
+	stats.Reset()
+
+	stats.IncSubscription(ptp.MessageSync)
+	stats.IncTX(ptp.MessageSync)
+	stats.IncTXTSMissing()
+	stats.SetMaxWorkerQueue(0, 3)
+	stats.SetUTCOffset(37)
+	stats.Snapshot()
+
+	var buf bytes.Buffer
+	require.NoError(t, writePrometheus(&buf, &stats.report, &stats.total))
+	out := buf.String()
+
+	require.Contains(t, out, "# TYPE ptp4u_subscriptions gauge\nptp4u_subscriptions{type=\"sync\"} 1\n")
+	require.Contains(t, out, "# TYPE ptp4u_tx_total counter\nptp4u_tx_total{type=\"sync\"} 2\n")
+	require.Contains(t, out, "ptp4u_grants_new_total{type=\"sync\"} 1\n")
+	require.Contains(t, out, "ptp4u_worker_queue{worker=\"0\"} 3\n")
+	require.Contains(t, out, "ptp4u_txts_missing_total 1\n")
+	require.Contains(t, out, "ptp4u_utc_offset_seconds 37\n")
+	require.NotContains(t, out, "ptp4u_rx_total")
+}
+
+func TestWritePrometheusDuringSnapshot(t *testing.T) {
+	stats := NewJSONStats()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			stats.IncTXTSMissing()
+			stats.SetLeapSmear(int64(i))
+			stats.Snapshot()
+		}
+	}()
+	// scrapes come from the http goroutine while the server snapshots
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		require.NoError(t, writePrometheus(ioutil.Discard, &stats.report, &stats.total))
+		stats.report.toMap()
+	}
+}
+
+func TestPrometheusExport(t *testing.T) {
+	stats := NewJSONStats()
+
+	go stats.Start(8889)
+	time.Sleep(time.Second)
+
+	stats.IncTX(ptp.MessageAnnounce)
+	stats.Snapshot()
+
+	resp, err := http.Get("http://localhost:8889/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+	require.Contains(t, string(body), "ptp4u_tx_total{type=\"announce\"} 1\n")
+}
@@ -25,8 +25,10 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
 )
 
 // Stats is a metric collection interface
@@ -87,6 +89,86 @@ type Stats interface {
 	SetUTCOffset(utcoffset int64)
 }
 
+// ServerStats is a collection of metrics added to the server after Stats was published.
+// They are kept out of Stats so its existing implementations keep compiling,
+// server reports them only if its Stats also implements ServerStats.
+type ServerStats interface {
+	Stats
+
+	// IncRXSignalingRejected atomically add 1 to the counter
+	IncRXSignalingRejected(t ptp.MessageType)
+
+	// IncGrantNew atomically add 1 to the counter
+	IncGrantNew(t ptp.MessageType)
+
+	// IncGrantEnded atomically add 1 to the counter
+	IncGrantEnded(t ptp.MessageType)
+
+	// IncCapacityRejected atomically add 1 to the counter
+	IncCapacityRejected(t ptp.MessageType)
+
+	// IncCapacityEvicted atomically add 1 to the counter
+	IncCapacityEvicted(t ptp.MessageType)
+
+	// IncDelayReqThrottled atomically add 1 to the counter
+	IncDelayReqThrottled()
+
+	// IncMonitoringSubs atomically add 1 to the counter
+	IncMonitoringSubs()
+
+	// SetLeapSmear atomically sets the current leap smear offset in nanoseconds
+	SetLeapSmear(offset int64)
+
+	// SetTSDegraded atomically sets if software timestamps are used as the NIC stopped producing hardware ones
+	SetTSDegraded(degraded int64)
+
+	// SetHoldover atomically sets for how long in seconds the boundary clock is in holdover and its estimated time error in nanoseconds
+	SetHoldover(duration, estimatedError int64)
+
+	// SetUpstream atomically sets the last offset and path delay in nanoseconds measured against the upstream grandmaster
+	SetUpstream(offset, delay int64)
+
+	// SetUpstreamSWTS atomically sets if the boundary clock measures the upstream grandmaster with software timestamps
+	SetUpstreamSWTS(sw int64)
+
+	// SetUpstreamNTP atomically sets the last offset in nanoseconds of the boundary clock from NTP time and if it's above the threshold
+	SetUpstreamNTP(offset, alarm int64)
+
+	// Stats of timestamping failures
+	timestamp.Stats
+}
+
+// Extend returns st as ServerStats, discarding the metrics it doesn't implement
+func Extend(st Stats) ServerStats {
+	if es, ok := st.(ServerStats); ok {
+		return es
+	}
+	return noopServerStats{st}
+}
+
+// noopServerStats discards the metrics of ServerStats missing in Stats
+type noopServerStats struct {
+	Stats
+}
+
+func (noopServerStats) IncRXSignalingRejected(ptp.MessageType) {}
+func (noopServerStats) IncGrantNew(ptp.MessageType)            {}
+func (noopServerStats) IncGrantEnded(ptp.MessageType)          {}
+func (noopServerStats) IncCapacityRejected(ptp.MessageType)    {}
+func (noopServerStats) IncCapacityEvicted(ptp.MessageType)     {}
+func (noopServerStats) IncDelayReqThrottled()                  {}
+func (noopServerStats) IncMonitoringSubs()                     {}
+func (noopServerStats) SetLeapSmear(int64)                     {}
+func (noopServerStats) SetTSDegraded(int64)                    {}
+func (noopServerStats) SetHoldover(int64, int64)               {}
+func (noopServerStats) SetUpstream(int64, int64)               {}
+func (noopServerStats) SetUpstreamSWTS(int64)                  {}
+func (noopServerStats) SetUpstreamNTP(int64, int64)            {}
+func (noopServerStats) IncTXTSMissing()                        {}
+func (noopServerStats) IncTSZero()                             {}
+func (noopServerStats) IncTXTSDrained()                        {}
+func (noopServerStats) IncSWFallback()                         {}
+
 // syncMapInt64 sync map of PTP messages
 type syncMapInt64 struct {
 	sync.Mutex
@@ -130,6 +212,13 @@ func (s *syncMapInt64) dec(key int) {
 	s.Unlock()
 }
 
+// add adds the value to the counter for the given key
+func (s *syncMapInt64) add(key int, value int64) {
+	s.Lock()
+	s.m[key] += value
+	s.Unlock()
+}
+
 // store saves the value with the key
 func (s *syncMapInt64) store(key int, value int64) {
 	s.Lock()
@@ -155,15 +244,35 @@ func (s *syncMapInt64) reset() {
 }
 
 type counters struct {
-	rx            syncMapInt64
-	rxSignaling   syncMapInt64
-	subscriptions syncMapInt64
-	tx            syncMapInt64
-	txSignaling   syncMapInt64
-	txtsattempts  syncMapInt64
-	workerQueue   syncMapInt64
-	workerSubs    syncMapInt64
-	utcoffset     int64
+	rx                  syncMapInt64
+	rxSignaling         syncMapInt64
+	rxSignalingRejected syncMapInt64
+	grantsNew           syncMapInt64
+	grantsEnded         syncMapInt64
+	capacityRejected    syncMapInt64
+	capacityEvicted     syncMapInt64
+	subscriptions       syncMapInt64
+	tx                  syncMapInt64
+	txSignaling         syncMapInt64
+	txtsattempts        syncMapInt64
+	workerQueue         syncMapInt64
+	workerSubs          syncMapInt64
+	utcoffset           int64
+	txtsMissing         int64
+	txtsDrained         int64
+	tsZero              int64
+	swFallback          int64
+	delayReqThrottled   int64
+	monitoringSubs      int64
+	leapSmear           int64
+	tsDegraded          int64
+	holdoverDuration    int64
+	holdoverError       int64
+	upstreamOffset      int64
+	upstreamDelay       int64
+	upstreamSWTS        int64
+	upstreamNTPOffset   int64
+	upstreamNTPAlarm    int64
 }
 
 func (c *counters) init() {
@@ -171,6 +280,11 @@ func (c *counters) init() {
 	c.rx.init()
 	c.tx.init()
 	c.rxSignaling.init()
+	c.rxSignalingRejected.init()
+	c.grantsNew.init()
+	c.grantsEnded.init()
+	c.capacityRejected.init()
+	c.capacityEvicted.init()
 	c.txSignaling.init()
 	c.workerQueue.init()
 	c.workerSubs.init()
@@ -182,11 +296,31 @@ func (c *counters) reset() {
 	c.rx.reset()
 	c.tx.reset()
 	c.rxSignaling.reset()
+	c.rxSignalingRejected.reset()
+	c.grantsNew.reset()
+	c.grantsEnded.reset()
+	c.capacityRejected.reset()
+	c.capacityEvicted.reset()
 	c.txSignaling.reset()
 	c.workerQueue.reset()
 	c.workerSubs.reset()
 	c.txtsattempts.reset()
-	c.utcoffset = 0
+	atomic.StoreInt64(&c.utcoffset, 0)
+	atomic.StoreInt64(&c.txtsMissing, 0)
+	atomic.StoreInt64(&c.txtsDrained, 0)
+	atomic.StoreInt64(&c.tsZero, 0)
+	atomic.StoreInt64(&c.swFallback, 0)
+	atomic.StoreInt64(&c.delayReqThrottled, 0)
+	atomic.StoreInt64(&c.monitoringSubs, 0)
+	atomic.StoreInt64(&c.leapSmear, 0)
+	atomic.StoreInt64(&c.tsDegraded, 0)
+	atomic.StoreInt64(&c.holdoverDuration, 0)
+	atomic.StoreInt64(&c.holdoverError, 0)
+	atomic.StoreInt64(&c.upstreamOffset, 0)
+	atomic.StoreInt64(&c.upstreamDelay, 0)
+	atomic.StoreInt64(&c.upstreamSWTS, 0)
+	atomic.StoreInt64(&c.upstreamNTPOffset, 0)
+	atomic.StoreInt64(&c.upstreamNTPAlarm, 0)
 }
 
 // toMap converts counters to a map
@@ -217,12 +351,42 @@ func (c *counters) toMap() (export map[string]int64) {
 		res[fmt.Sprintf("rx.signaling.%s", mt)] = c
 	}
 
+	for _, t := range c.rxSignalingRejected.keys() {
+		c := c.rxSignalingRejected.load(t)
+		mt := strings.ToLower(ptp.MessageType(t).String())
+		res[fmt.Sprintf("rx.signaling.rejected.%s", mt)] = c
+	}
+
 	for _, t := range c.txSignaling.keys() {
 		c := c.txSignaling.load(t)
 		mt := strings.ToLower(ptp.MessageType(t).String())
 		res[fmt.Sprintf("tx.signaling.%s", mt)] = c
 	}
 
+	for _, t := range c.grantsNew.keys() {
+		c := c.grantsNew.load(t)
+		mt := strings.ToLower(ptp.MessageType(t).String())
+		res[fmt.Sprintf("grants.new.%s", mt)] = c
+	}
+
+	for _, t := range c.grantsEnded.keys() {
+		c := c.grantsEnded.load(t)
+		mt := strings.ToLower(ptp.MessageType(t).String())
+		res[fmt.Sprintf("grants.ended.%s", mt)] = c
+	}
+
+	for _, t := range c.capacityRejected.keys() {
+		c := c.capacityRejected.load(t)
+		mt := strings.ToLower(ptp.MessageType(t).String())
+		res[fmt.Sprintf("capacity.rejected.%s", mt)] = c
+	}
+
+	for _, t := range c.capacityEvicted.keys() {
+		c := c.capacityEvicted.load(t)
+		mt := strings.ToLower(ptp.MessageType(t).String())
+		res[fmt.Sprintf("capacity.evicted.%s", mt)] = c
+	}
+
 	for _, t := range c.workerQueue.keys() {
 		c := c.workerQueue.load(t)
 		res[fmt.Sprintf("worker.%d.queue", t)] = c
@@ -238,7 +402,22 @@ func (c *counters) toMap() (export map[string]int64) {
 		res[fmt.Sprintf("worker.%d.txtsattempts", t)] = c
 	}
 
-	res["utcoffset"] = c.utcoffset
+	res["utcoffset"] = atomic.LoadInt64(&c.utcoffset)
+	res["txts.missing"] = atomic.LoadInt64(&c.txtsMissing)
+	res["txts.drained"] = atomic.LoadInt64(&c.txtsDrained)
+	res["ts.zero"] = atomic.LoadInt64(&c.tsZero)
+	res["ts.swfallback"] = atomic.LoadInt64(&c.swFallback)
+	res["delayreq.throttled"] = atomic.LoadInt64(&c.delayReqThrottled)
+	res["subscriptions.monitoring"] = atomic.LoadInt64(&c.monitoringSubs)
+	res["leapsmear.offset"] = atomic.LoadInt64(&c.leapSmear)
+	res["ts.degraded"] = atomic.LoadInt64(&c.tsDegraded)
+	res["holdover.duration"] = atomic.LoadInt64(&c.holdoverDuration)
+	res["holdover.error"] = atomic.LoadInt64(&c.holdoverError)
+	res["upstream.offset"] = atomic.LoadInt64(&c.upstreamOffset)
+	res["upstream.delay"] = atomic.LoadInt64(&c.upstreamDelay)
+	res["upstream.swts"] = atomic.LoadInt64(&c.upstreamSWTS)
+	res["upstream.ntp.offset"] = atomic.LoadInt64(&c.upstreamNTPOffset)
+	res["upstream.ntp.alarm"] = atomic.LoadInt64(&c.upstreamNTPAlarm)
 
 	return res
 }
@@ -73,6 +73,10 @@ func TestSyncMapInt64Counters(t *testing.T) {
 	c.workerSubs.store(1, 1)
 	c.txtsattempts.store(1, 1)
 	c.utcoffset = 1
+	c.txtsMissing = 1
+	c.txtsDrained = 1
+	c.tsZero = 1
+	c.swFallback = 1
 
 	require.Equal(t, int64(1), c.subscriptions.load(1))
 	require.Equal(t, int64(1), c.rx.load(1))
@@ -95,6 +99,10 @@ func TestSyncMapInt64Counters(t *testing.T) {
 	require.Equal(t, int64(0), c.workerSubs.load(1))
 	require.Equal(t, int64(0), c.txtsattempts.load(1))
 	require.Equal(t, int64(0), c.utcoffset)
+	require.Equal(t, int64(0), c.txtsMissing)
+	require.Equal(t, int64(0), c.txtsDrained)
+	require.Equal(t, int64(0), c.tsZero)
+	require.Equal(t, int64(0), c.swFallback)
 }
 
 func TestCountersToMap(t *testing.T) {
@@ -113,6 +121,43 @@ func TestCountersToMap(t *testing.T) {
 	expectedMap["tx.sync"] = 2
 	expectedMap["rx.signaling.delay_resp"] = 3
 	expectedMap["utcoffset"] = 1
+	expectedMap["txts.missing"] = 0
+	expectedMap["txts.drained"] = 0
+	expectedMap["ts.zero"] = 0
+	expectedMap["ts.swfallback"] = 0
+	expectedMap["delayreq.throttled"] = 0
+	expectedMap["subscriptions.monitoring"] = 0
+	expectedMap["leapsmear.offset"] = 0
+	expectedMap["ts.degraded"] = 0
+	expectedMap["holdover.duration"] = 0
+	expectedMap["holdover.error"] = 0
+	expectedMap["upstream.offset"] = 0
+	expectedMap["upstream.delay"] = 0
+	expectedMap["upstream.swts"] = 0
+	expectedMap["upstream.ntp.offset"] = 0
+	expectedMap["upstream.ntp.alarm"] = 0
 
 	require.Equal(t, expectedMap, result)
 }
+
+// baseStats implements only Stats
+type baseStats struct {
+	Stats
+}
+
+func TestExtend(t *testing.T) {
+	js := NewJSONStats()
+	require.Equal(t, js, Extend(js))
+
+	bs := baseStats{js}
+	es := Extend(bs)
+	require.Equal(t, noopServerStats{bs}, es)
+
+	es.IncRX(ptp.MessageSync)
+	require.Equal(t, int64(1), js.rx.load(int(ptp.MessageSync)))
+
+	es.IncGrantNew(ptp.MessageSync)
+	es.IncSWFallback()
+	require.Equal(t, int64(0), js.grantsNew.load(int(ptp.MessageSync)))
+	require.Equal(t, int64(0), js.swFallback)
+}
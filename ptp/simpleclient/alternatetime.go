@@ -0,0 +1,60 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// AlternateTimeOffset is an alternate timescale, like local time, the server advertises with ALTERNATE_TIME_OFFSET_INDICATOR TLV
+type AlternateTimeOffset struct {
+	Key  uint8  `json:"key"`
+	Name string `json:"name"`
+	// Offset is the offset of the alternate time from PTP time
+	Offset time.Duration `json:"offset_ns"`
+	// Jump is the size of the next discontinuity of the alternate time, happening at NextJump
+	Jump     time.Duration `json:"jump_ns"`
+	NextJump time.Time     `json:"next_jump"`
+}
+
+// alternateTimeOffsets returns alternate timescales advertised in Announce
+func alternateTimeOffsets(a *ptp.Announce) []AlternateTimeOffset {
+	tlvs := a.AlternateTimeOffsets()
+	if len(tlvs) == 0 {
+		return nil
+	}
+	res := make([]AlternateTimeOffset, 0, len(tlvs))
+	for _, t := range tlvs {
+		res = append(res, AlternateTimeOffset{
+			Key:      t.KeyField,
+			Name:     string(t.DisplayName),
+			Offset:   t.Offset(),
+			Jump:     time.Duration(t.JumpSeconds) * time.Second,
+			NextJump: t.NextJump(),
+		})
+	}
+	return res
+}
+
+// AlternateTimeOffsets returns alternate timescales advertised in the last Announce from the server
+func (c *Client) AlternateTimeOffsets() []AlternateTimeOffset {
+	c.atoMux.Lock()
+	defer c.atoMux.Unlock()
+	return c.alternateTimeOffsets
+}
@@ -0,0 +1,44 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleAnnounceAlternateTimeOffsets(t *testing.T) {
+	c := New(&Config{Address: "a"}, func(*MeasurementResult) {})
+	require.Empty(t, c.AlternateTimeOffsets())
+
+	nextJump := time.Unix(1700000000, 0)
+	a := announcePkt(1)
+	a.TLVs = []ptp.TLV{
+		ptp.NewAlternateTimeOffsetIndicatorTLV(1, time.Hour, -time.Hour, nextJump, "CET"),
+	}
+	require.NoError(t, c.handleAnnounce(a))
+	require.Equal(t, []AlternateTimeOffset{
+		{Key: 1, Name: "CET", Offset: time.Hour, Jump: -time.Hour, NextJump: nextJump},
+	}, c.AlternateTimeOffsets())
+
+	// timescales no longer advertised are forgotten
+	require.NoError(t, c.handleAnnounce(announcePkt(2)))
+	require.Empty(t, c.AlternateTimeOffsets())
+}
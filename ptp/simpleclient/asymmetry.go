@@ -0,0 +1,92 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// asymmetryRule is the asymmetry of paths to grandmasters in the prefix
+type asymmetryRule struct {
+	prefix    *net.IPNet
+	asymmetry time.Duration
+}
+
+// Asymmetries are static delay asymmetries of paths to grandmasters.
+// Asymmetry is positive when the path from the grandmaster is longer than the path to it, as per IEEE 1588-2019 16.8,
+// and is subtracted from measured offsets.
+type Asymmetries []asymmetryRule
+
+// ParseAsymmetries parses comma separated list of prefix=asymmetry, where prefix is either IP or CIDR.
+// For example "10.0.0.0/8=-1.5us,2001:db8::1=300ns"
+func ParseAsymmetries(s string) (Asymmetries, error) {
+	res := Asymmetries{}
+	if s == "" {
+		return res, nil
+	}
+	for _, rule := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(rule), "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("asymmetry must be prefix=duration, got %q", rule)
+		}
+		prefix, err := parsePrefix(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		asymmetry, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("parsing asymmetry of %s: %w", parts[0], err)
+		}
+		res = append(res, asymmetryRule{prefix: prefix, asymmetry: asymmetry})
+	}
+	return res, nil
+}
+
+// parsePrefix parses IP or CIDR
+func parsePrefix(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, prefix, err := net.ParseCIDR(s)
+		return prefix, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", s)
+	}
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Lookup returns asymmetry of the path to the grandmaster from the longest matching prefix, zero if none matches
+func (a Asymmetries) Lookup(ip net.IP) time.Duration {
+	var res time.Duration
+	longest := -1
+	for _, rule := range a {
+		if !rule.prefix.Contains(ip) {
+			continue
+		}
+		if ones, _ := rule.prefix.Mask.Size(); ones > longest {
+			longest = ones
+			res = rule.asymmetry
+		}
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+func TestParseAsymmetries(t *testing.T) {
+	a, err := ParseAsymmetries("")
+	require.NoError(t, err)
+	require.Empty(t, a)
+
+	a, err = ParseAsymmetries("10.0.0.0/8=-1.5us, 10.1.0.0/16=2us,10.1.2.3=300ns,2001:db8::/32=1us")
+	require.NoError(t, err)
+	require.Len(t, a, 4)
+	require.Equal(t, -1500*time.Nanosecond, a.Lookup(net.ParseIP("10.2.0.1")))
+	require.Equal(t, 2*time.Microsecond, a.Lookup(net.ParseIP("10.1.0.1")))
+	require.Equal(t, 300*time.Nanosecond, a.Lookup(net.ParseIP("10.1.2.3")))
+	require.Equal(t, 300*time.Nanosecond, a.Lookup(net.ParseIP("::ffff:10.1.2.3")))
+	require.Equal(t, time.Microsecond, a.Lookup(net.ParseIP("2001:db8::1")))
+	require.Equal(t, time.Duration(0), a.Lookup(net.ParseIP("192.168.0.1")))
+
+	for _, s := range []string{"10.0.0.1", "10.0.0.1=", "10.0.0.1=1", "host=1us", "10.0.0.0/33=1us", "10.0.0.1=1us=2us"} {
+		_, err := ParseAsymmetries(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestClientAsymmetry(t *testing.T) {
+	asymmetries, err := ParseAsymmetries("192.168.0.1=1us")
+	require.NoError(t, err)
+	history := []*MeasurementResult{}
+	c := New(&Config{Asymmetries: asymmetries}, func(m *MeasurementResult) {
+		history = append(history, m)
+	})
+	c.genAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: ptp.PortGeneral}
+	c.setAsymmetry()
+
+	// 10us each way, no offset
+	t1 := time.Unix(1000, 0)
+	c.m.addSync(1, t1.Add(10*time.Microsecond), 0)
+	c.m.addFollowUp(1, t1, 0)
+	c.m.addDelayReq(1, t1.Add(time.Millisecond))
+	resp := delayRespPkt(1)
+	resp.ReceiveTimestamp = ptp.NewTimestamp(t1.Add(time.Millisecond + 10*time.Microsecond))
+	require.NoError(t, c.handleDelay(resp))
+	require.Len(t, history, 1)
+	require.Equal(t, 10*time.Microsecond, history[0].Delay)
+	require.Equal(t, -time.Microsecond, history[0].Offset)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+// anyGM is the address of keys used for grandmasters without own keys
+const anyGM = "*"
+
+// GMKeys are HMAC-SHA256-128 keys of AUTHENTICATION TLVs by address of the grandmaster signing with them
+type GMKeys map[string]ptp.StaticKeyProvider
+
+// ParseGMKeys reads lines of '<address|*> <key id> <hex key>', comments start with #.
+// For example "10.0.0.1 1 00112233445566778899aabbccddeeff"
+func ParseGMKeys(r io.Reader) (GMKeys, error) {
+	res := GMKeys{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("line %d: key must be '<address> <key id> <hex key>'", n)
+		}
+		keyID, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: parsing key id: %w", n, err)
+		}
+		key, err := hex.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: parsing key: %w", n, err)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("line %d: empty key", n)
+		}
+		if res[parts[0]] == nil {
+			res[parts[0]] = ptp.StaticKeyProvider{}
+		}
+		res[parts[0]][uint32(keyID)] = ptp.NewHMACSHA256Key(key)
+	}
+	return res, scanner.Err()
+}
+
+// For returns keys of the grandmaster, falling back to keys for any grandmaster. It's nil if there are none
+func (g GMKeys) For(address string) ptp.KeyProvider {
+	if keys, ok := g[address]; ok {
+		return keys
+	}
+	if keys, ok := g[anyGM]; ok {
+		return keys
+	}
+	return nil
+}
+
+// authenticated checks AUTHENTICATION TLV of messages samples and data set of the grandmaster come from.
+// Without keys nothing is checked
+func (c *Client) authenticated(msgType ptp.MessageType, b []byte) bool {
+	if c.cfg.AuthKeys == nil {
+		return true
+	}
+	switch msgType {
+	case ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageFollowUp, ptp.MessageDelayResp:
+	default:
+		return true
+	}
+	if _, err := ptp.VerifyPacket(b, c.cfg.AuthKeys); err != nil {
+		atomic.AddUint64(&c.unauthenticated, 1)
+		log.Warningf("dropping %s from %s: %v", msgType, c.cfg.Address, err)
+		return false
+	}
+	return true
+}
+
+// Unauthenticated returns how many messages were dropped for missing or invalid AUTHENTICATION TLV
+func (c *Client) Unauthenticated() uint64 {
+	return atomic.LoadUint64(&c.unauthenticated)
+}
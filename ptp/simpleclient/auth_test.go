@@ -0,0 +1,116 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+var testKey = []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+func signedSync(t *testing.T, seq int, keys ptp.KeyProvider, keyID uint32) []byte {
+	b := make([]byte, 128)
+	n, err := syncPkt(seq).MarshalBinaryTo(b)
+	require.NoError(t, err)
+	n, err = ptp.SignPacket(b, n, 0, keyID, keys)
+	require.NoError(t, err)
+	return b[:n]
+}
+
+func TestParseGMKeys(t *testing.T) {
+	keys, err := ParseGMKeys(strings.NewReader(`
+# lab grandmasters
+10.0.0.1 1 00112233445566778899aabbccddeeff
+10.0.0.1 2 ff  # rotated
+* 7 0011
+`))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Len(t, keys["10.0.0.1"], 2)
+	require.Equal(t, testKey, keys["10.0.0.1"][1].Key)
+	require.Equal(t, 16, keys["10.0.0.1"][1].ICVLength)
+	require.Equal(t, []byte{0xff}, keys["10.0.0.1"][2].Key)
+	require.Equal(t, []byte{0x00, 0x11}, keys["*"][7].Key)
+
+	for _, in := range []string{"10.0.0.1 1", "10.0.0.1 x 00", "10.0.0.1 1 zz", "10.0.0.1 1 00 11"} {
+		_, err := ParseGMKeys(strings.NewReader(in))
+		require.Error(t, err, in)
+	}
+}
+
+func TestGMKeysFor(t *testing.T) {
+	keys := GMKeys{"a": ptp.StaticKeyProvider{1: ptp.NewHMACSHA256Key(testKey)}}
+	require.Equal(t, keys["a"], keys.For("a"))
+	require.Nil(t, keys.For("b"))
+	keys[anyGM] = ptp.StaticKeyProvider{2: ptp.NewHMACSHA256Key(testKey)}
+	require.Equal(t, keys[anyGM], keys.For("b"))
+	require.Nil(t, GMKeys(nil).For("a"))
+}
+
+func TestClientAuthentication(t *testing.T) {
+	keys := ptp.StaticKeyProvider{1: ptp.NewHMACSHA256Key(testKey)}
+	c := New(&Config{Address: "a", AuthKeys: keys}, func(*MeasurementResult) {})
+	now := time.Now()
+
+	// signed Sync is accepted
+	require.NoError(t, c.handleMsg(&inPacket{data: signedSync(t, 1, keys, 1), ts: now}))
+	require.Contains(t, c.m.serverToClient, uint16(1))
+	require.Equal(t, uint64(0), c.Unauthenticated())
+
+	// unsigned one is dropped
+	b, err := ptp.Bytes(syncPkt(2))
+	require.NoError(t, err)
+	require.NoError(t, c.handleMsg(&inPacket{data: b, ts: now}))
+	require.NotContains(t, c.m.serverToClient, uint16(2))
+	require.Equal(t, uint64(1), c.Unauthenticated())
+
+	// so is one signed with another key
+	other := ptp.StaticKeyProvider{1: ptp.NewHMACSHA256Key([]byte{1, 2, 3, 4})}
+	require.NoError(t, c.handleMsg(&inPacket{data: signedSync(t, 3, other, 1), ts: now}))
+	require.NotContains(t, c.m.serverToClient, uint16(3))
+	require.Equal(t, uint64(2), c.Unauthenticated())
+
+	// and one signed with unknown key
+	require.NoError(t, c.handleMsg(&inPacket{data: signedSync(t, 4, ptp.StaticKeyProvider{2: keys[1]}, 2), ts: now}))
+	require.Equal(t, uint64(3), c.Unauthenticated())
+
+	// nothing is verified without keys
+	c = New(&Config{Address: "a"}, func(*MeasurementResult) {})
+	require.NoError(t, c.handleMsg(&inPacket{data: b, ts: now}))
+	require.Contains(t, c.m.serverToClient, uint16(2))
+}
+
+func TestMultiClientGMKeys(t *testing.T) {
+	keys := GMKeys{"a": ptp.StaticKeyProvider{1: ptp.NewHMACSHA256Key(testKey)}}
+	m := NewMulti(&MultiConfig{Addresses: []string{"a", "b"}, GMKeys: keys}, func(string, *MeasurementResult) {})
+	require.Equal(t, keys["a"], m.clients[0].cfg.AuthKeys)
+	require.Nil(t, m.clients[1].cfg.AuthKeys)
+
+	b, err := ptp.Bytes(syncPkt(1))
+	require.NoError(t, err)
+	require.NoError(t, m.clients[0].handleMsg(&inPacket{data: b}))
+	require.NoError(t, m.clients[1].handleMsg(&inPacket{data: b}))
+	stats := m.Stats(time.Now())
+	require.Equal(t, uint64(1), stats[0].Unauthenticated)
+	require.Equal(t, uint64(0), stats[1].Unauthenticated)
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -27,6 +28,7 @@ import (
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sys/unix"
 
+	"github.com/facebook/time/ptp/negotiation"
 	ptp "github.com/facebook/time/ptp/protocol"
 	"github.com/facebook/time/timestamp"
 )
@@ -109,8 +111,32 @@ type Config struct {
 	Duration time.Duration
 	// what type of typestamping to use
 	Timestamping string
+	// PTP domain to talk in, for example DefaultDomain of the profile server operates under
+	DomainNumber uint8
+	// static delay asymmetries of paths to servers, the one matching the server is applied to offsets
+	Asymmetries Asymmetries
+	// DSCP of sent packets, 0 leaves the default
+	DSCP int
+	// MaxRetryInterval caps exponential backoff of requests the server doesn't answer, DefaultMaxRetryInterval if 0
+	MaxRetryInterval time.Duration
+	// Compat makes the client negotiate like a standard unicast slave, to talk to ptp4l or telecom grandmasters:
+	// all messages are requested at once and renewed, denials are retried, unknown TLVs are skipped
+	// and one-step Sync is supported
+	Compat bool
+	// AuthKeys are keys the server signs Announce, Sync, Follow_Up and Delay_Resp with. Messages without valid
+	// AUTHENTICATION TLV are dropped and counted. Nil accepts messages without verification
+	AuthKeys ptp.KeyProvider
 }
 
+// retryInterval is how long to wait for the server to answer the first request
+const retryInterval = time.Second
+
+// DefaultMaxRetryInterval is the default cap of backoff of requests to unresponsive server
+const DefaultMaxRetryInterval = 32 * time.Second
+
+// retryJitter is the part of the retry interval added to it at random
+const retryJitter = 0.1
+
 // Client is a very simplified PTPv2 unicast client.
 // Whenever it has all the data to calculate offset/delay/etc
 // it will call provided callback function with `MeasurementResult`.
@@ -123,6 +149,9 @@ type Client struct {
 	eventSequence uint16
 	// state enum
 	state state
+	// unanswered requests and when to send the next one
+	retries   int
+	nextRetry time.Time
 
 	// chan for received packets regardless of port
 	inChan chan *inPacket
@@ -135,10 +164,23 @@ type Client struct {
 	eventAddr *net.UDPAddr
 	// our clockID derived from MAC address
 	clockID ptp.ClockIdentity
+	// delay asymmetry of the path to the server
+	asymmetry time.Duration
 	// where we store timestamps
 	m *measurements
 	// what to do when we receive latest measurement
 	callback func(*MeasurementResult)
+	// what to do when we receive Announce, optional
+	announceCallback func(*ptp.Announce)
+	// negotiates unicast transmission in compat mode
+	requester *negotiation.Requester
+	// messages dropped for failed authentication
+	unauthenticated uint64
+	// packets dropped by MultiClient while the client wasn't keeping up
+	dropped uint64
+	// alternate timescales of the last Announce
+	atoMux               sync.Mutex
+	alternateTimeOffsets []AlternateTimeOffset
 }
 
 // New initializes new PTPv2 unicast client
@@ -149,6 +191,9 @@ func New(cfg *Config, callback func(*MeasurementResult)) *Client {
 		cfg:      cfg,
 		callback: callback,
 	}
+	if cfg.Compat {
+		c.requester = newCompatRequester(cfg)
+	}
 	return c
 }
 
@@ -187,77 +232,175 @@ func (c *Client) sendEventMsg(p ptp.Packet) (uint16, time.Time, error) {
 	return seq, hwts, nil
 }
 
-func (c *Client) setup(ctx context.Context, eg *errgroup.Group) error {
-	iface, err := net.InterfaceByName(c.cfg.Iface)
+// clockIdentity derives ClockIdentity from MAC address of the interface
+func clockIdentity(ifaceName string) (ptp.ClockIdentity, error) {
+	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return ptp.NewClockIdentity(iface.HardwareAddr)
+}
 
-	cid, err := ptp.NewClockIdentity(iface.HardwareAddr)
+// serverAddrs resolves general and event port addresses of the server
+func serverAddrs(address string) (*net.UDPAddr, *net.UDPAddr, error) {
+	genAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(address, fmt.Sprintf("%d", ptp.PortGeneral)))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	log.Infof("using ClockIdentity %s, talking to %v using Two-Step Unicast PTPv2 protocol", cid, c.cfg.Address)
-	c.clockID = cid
-
-	// addresses
-	// where to send to
-	genAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(c.cfg.Address, fmt.Sprintf("%d", ptp.PortGeneral)))
+	eventAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(address, fmt.Sprintf("%d", ptp.PortEvent)))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	eventAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(c.cfg.Address, fmt.Sprintf("%d", ptp.PortEvent)))
-	if err != nil {
-		return err
+	return genAddr, eventAddr, nil
+}
+
+// localAddr returns network and address to bind to for talking to the servers.
+// IPv4 only servers are talked to from IPv4 sockets, which also works on hosts with IPv6 disabled
+func localAddr(servers []*net.UDPAddr) (string, net.IP) {
+	for _, s := range servers {
+		if s.IP.To4() == nil {
+			return "udp", net.IPv6unspecified
+		}
 	}
+	return "udp4", net.IPv4zero
+}
+
+// listen binds to general and event ports for talking to the servers and enables timestamps on the event one
+func listen(cfg *Config, servers []*net.UDPAddr) (*net.UDPConn, *net.UDPConn, int, error) {
+	network, ip := localAddr(servers)
 	// bind to general port
-	genConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("::"), Port: ptp.PortGeneral})
+	genConn, err := net.ListenUDP(network, &net.UDPAddr{IP: ip, Port: ptp.PortGeneral})
 	if err != nil {
-		return err
+		return nil, nil, -1, err
 	}
-	c.genConn = genConn
-	c.genAddr = genAddr
 	// bind to event port
-	eventConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("::"), Port: ptp.PortEvent})
+	eventConn, err := net.ListenUDP(network, &net.UDPAddr{IP: ip, Port: ptp.PortEvent})
 	if err != nil {
-		return err
+		genConn.Close()
+		return nil, nil, -1, err
+	}
+	connFd, err := enableTimestamps(cfg, eventConn)
+	if err == nil {
+		err = enableDSCP(cfg.DSCP, servers, genConn, eventConn)
 	}
+	if err != nil {
+		genConn.Close()
+		eventConn.Close()
+		return nil, nil, -1, err
+	}
+	return genConn, eventConn, connFd, nil
+}
 
+// enableDSCP sets DSCP of packets sent to the servers
+func enableDSCP(dscp int, servers []*net.UDPAddr, conns ...*net.UDPConn) error {
+	if dscp == 0 {
+		return nil
+	}
+	network, _ := localAddr(servers)
+	for _, conn := range conns {
+		fd, err := timestamp.ConnFd(conn)
+		if err != nil {
+			return err
+		}
+		if network == "udp" {
+			if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2); err != nil {
+				return fmt.Errorf("failed to set DSCP: %w", err)
+			}
+		}
+		// IPv4 servers may be talked to from dual stack socket as well
+		for _, s := range servers {
+			if s.IP.To4() != nil {
+				if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, dscp<<2); err != nil {
+					return fmt.Errorf("failed to set DSCP: %w", err)
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// enableTimestamps enables timestamps of configured type on event port and returns FD of the connection
+func enableTimestamps(cfg *Config, eventConn *net.UDPConn) (int, error) {
 	// get FD of the connection. Can be optimized by doing this when connection is created
 	connFd, err := timestamp.ConnFd(eventConn)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
 	// we need to enable HW or SW timestamps on event port
-	switch c.cfg.Timestamping {
+	switch cfg.Timestamping {
 	case "": // auto-detection
-		if err := timestamp.EnableHWTimestampsSocket(connFd, c.cfg.Iface); err != nil {
-			if err := timestamp.EnableSWTimestampsSocket(connFd); err != nil {
-				return fmt.Errorf("failed to enable timestamps on port %d: %v", ptp.PortEvent, err)
-			}
-			log.Warningf("Failed to enable hardware timestamps on port %d, falling back to software timestamps", ptp.PortEvent)
+		ts, err := timestamp.EnableTimestamps(connFd, cfg.Iface, timestamp.HW)
+		if err != nil {
+			return -1, fmt.Errorf("failed to enable timestamps on port %d: %v", ptp.PortEvent, err)
+		}
+		if ts != timestamp.HW {
+			log.Warningf("Failed to enable hardware timestamps on port %d, falling back to %s timestamps", ptp.PortEvent, ts)
 		} else {
 			log.Infof("Using hardware timestamps")
 		}
 	case HWTIMESTAMP:
-		if err := timestamp.EnableHWTimestampsSocket(connFd, c.cfg.Iface); err != nil {
-			return fmt.Errorf("failed to enable hardware timestamps on port %d: %v", ptp.PortEvent, err)
+		if err := timestamp.EnableHWTimestampsSocket(connFd, cfg.Iface); err != nil {
+			return -1, fmt.Errorf("failed to enable hardware timestamps on port %d: %v", ptp.PortEvent, err)
 		}
 	case SWTIMESTAMP:
 		if err := timestamp.EnableSWTimestampsSocket(connFd); err != nil {
-			return fmt.Errorf("failed to enable software timestamps on port %d: %v", ptp.PortEvent, err)
+			return -1, fmt.Errorf("failed to enable software timestamps on port %d: %v", ptp.PortEvent, err)
 		}
 	default:
-		return fmt.Errorf("unknown type of typestamping: %q", c.cfg.Timestamping)
+		return -1, fmt.Errorf("unknown type of typestamping: %q", cfg.Timestamping)
 	}
 	// set it to blocking mode, otherwise recvmsg will just return with nothing most of the time
 	if err := unix.SetNonblock(connFd, false); err != nil {
-		return fmt.Errorf("failed to set event socket to blocking: %w", err)
+		return -1, fmt.Errorf("failed to set event socket to blocking: %w", err)
+	}
+	return connFd, nil
+}
+
+func (c *Client) setup(ctx context.Context, eg *errgroup.Group) error {
+	cid, err := clockIdentity(c.cfg.Iface)
+	if err != nil {
+		return err
+	}
+	log.Infof("using ClockIdentity %s, talking to %v using Two-Step Unicast PTPv2 protocol", cid, c.cfg.Address)
+	c.clockID = cid
+
+	// addresses
+	// where to send to
+	genAddr, eventAddr, err := serverAddrs(c.cfg.Address)
+	if err != nil {
+		return err
 	}
+	genConn, eventConn, connFd, err := listen(c.cfg, []*net.UDPAddr{genAddr})
+	if err != nil {
+		return err
+	}
+	c.genConn = genConn
+	c.genAddr = genAddr
 	c.eventConn = &udpConnTS{eventConn}
 	c.eventAddr = eventAddr
+	c.setAsymmetry()
+
+	receive(ctx, eg, genConn, connFd, func(ip net.IP, p *inPacket) {
+		if !ip.Equal(genAddr.IP) {
+			log.Warningf("ignoring packets from server %v", ip)
+		}
+		c.inChan <- p
+	})
+	return nil
+}
 
+// setAsymmetry looks up asymmetry of the path to the server by its address
+func (c *Client) setAsymmetry() {
+	c.asymmetry = c.cfg.Asymmetries.Lookup(c.genAddr.IP)
+	if c.asymmetry != 0 {
+		log.Infof("correcting offsets from %v by asymmetry of %v", c.genAddr.IP, c.asymmetry)
+	}
+}
+
+// receive reads packets from both ports until ctx is cancelled, passing them to deliver along with address of the sender
+func receive(ctx context.Context, eg *errgroup.Group, genConn *net.UDPConn, connFd int, deliver func(net.IP, *inPacket)) {
 	// get packets from general port
 	eg.Go(func() error {
 		// it's done in non-blocking way, so if context is cancelled we exit correctly
@@ -271,17 +414,14 @@ func (c *Client) setup(ctx context.Context, eg *errgroup.Group) error {
 					return
 				}
 				log.Debugf("got packet on port 320, n = %v, addr = %v", n, addr)
-				if !addr.IP.Equal(genAddr.IP) {
-					log.Warningf("ignoring packets from server %v", addr)
-				}
-				c.inChan <- &inPacket{data: response[:n]}
+				deliver(addr.IP, &inPacket{data: response[:n]})
 			}
 		}()
 		select {
 		case <-ctx.Done():
 			log.Debugf("cancelled general port receiver")
 			return ctx.Err()
-		case err = <-doneChan:
+		case err := <-doneChan:
 			return err
 		}
 	})
@@ -297,28 +437,26 @@ func (c *Client) setup(ctx context.Context, eg *errgroup.Group) error {
 					return
 				}
 				log.Debugf("got packet on port 319, addr = %v", addr)
-				if !timestamp.SockaddrToIP(addr).Equal(eventAddr.IP) {
-					log.Warningf("ignoring packets from server %v", addr)
-				}
-				c.inChan <- &inPacket{data: response, ts: rxtx}
+				deliver(timestamp.SockaddrToIP(addr), &inPacket{data: response, ts: rxtx})
 			}
 		}()
 		select {
 		case <-ctx.Done():
 			log.Debugf("cancelled event port receiver")
 			return ctx.Err()
-		case err = <-doneChan:
+		case err := <-doneChan:
 			return err
 		}
 	})
-
-	return nil
 }
 
 // handleGrantUnicast handles SIGNALLING packet that grants parts of unicast transmission
 func (c *Client) handleGrantUnicast(tlv *ptp.GrantUnicastTransmissionTLV) error {
 	msgType := tlv.MsgTypeAndReserved.MsgType()
 	c.logReceive(ptp.MessageSignaling, "unicast grant for %s", msgType)
+	if c.requester != nil {
+		return c.handleGrantCompat(tlv)
+	}
 	switch msgType {
 	case ptp.MessageAnnounce:
 		// we received response, no need to request more grants for Announce
@@ -327,7 +465,7 @@ func (c *Client) handleGrantUnicast(tlv *ptp.GrantUnicastTransmissionTLV) error
 			return fmt.Errorf("server denied us grant for %s", msgType)
 		}
 		// ask for sync messages
-		seq, err := c.sendGeneralMsg(reqUnicast(c.clockID, c.cfg.Duration, ptp.MessageSync))
+		seq, err := c.sendGeneralMsg(reqUnicast(c.clockID, c.cfg.DomainNumber, c.cfg.Duration, ptp.MessageSync))
 		if err != nil {
 			return err
 		}
@@ -337,7 +475,7 @@ func (c *Client) handleGrantUnicast(tlv *ptp.GrantUnicastTransmissionTLV) error
 			return fmt.Errorf("server denied us grant for %s", msgType)
 		}
 		// ask for delay_resp messages
-		seq, err := c.sendGeneralMsg(reqUnicast(c.clockID, c.cfg.Duration, ptp.MessageDelayResp))
+		seq, err := c.sendGeneralMsg(reqUnicast(c.clockID, c.cfg.DomainNumber, c.cfg.Duration, ptp.MessageDelayResp))
 		if err != nil {
 			return err
 		}
@@ -355,8 +493,11 @@ func (c *Client) handleGrantUnicast(tlv *ptp.GrantUnicastTransmissionTLV) error
 
 // handleCancelUnicast handles SIGNALLING packet that marks end of unicast transmission
 func (c *Client) handleCancelUnicast(tlv *ptp.CancelUnicastTransmissionTLV) error {
+	if c.requester != nil {
+		return c.handleCancelCompat(tlv)
+	}
 	c.logReceive(ptp.MessageSignaling, "unicast transmission cancelled, dying")
-	seq, err := c.sendGeneralMsg(reqAckCancelUnicast(c.clockID, tlv.MsgTypeAndFlags.MsgType()))
+	seq, err := c.sendGeneralMsg(reqAckCancelUnicast(c.clockID, c.cfg.DomainNumber, tlv.MsgTypeAndFlags.MsgType()))
 	if err != nil {
 		return err
 	}
@@ -371,21 +512,37 @@ func (c *Client) handleAnnounce(b *ptp.Announce) error {
 	c.logReceive(ptp.MessageAnnounce, "seq=%d, gmIdentity=%s, gmTimeSource=%s, stepsRemoved=%d",
 		b.SequenceID, b.GrandmasterIdentity, b.TimeSource, b.StepsRemoved)
 	c.m.currentUTCoffset = time.Duration(b.CurrentUTCOffset) * time.Second
+	if c.announceCallback != nil {
+		c.announceCallback(b)
+	}
+	atos := alternateTimeOffsets(b)
+	for _, ato := range atos {
+		c.logReceive(ptp.MessageAnnounce, "alternate timescale key=%d, name=%q, offset=%v, next jump by %v at %v",
+			ato.Key, ato.Name, ato.Offset, ato.Jump, ato.NextJump)
+	}
+	c.atoMux.Lock()
+	c.alternateTimeOffsets = atos
+	c.atoMux.Unlock()
 	return nil
 }
 
-// handleSync handles SYNC packet and adds send timestamp to measurements
+// handleSync handles SYNC packet and adds receive timestamp to measurements.
+// One-step SYNC carries the send timestamp as well, so DELAY_REQ is sent right away
 func (c *Client) handleSync(b *ptp.SyncDelayReq, ts time.Time) error {
 	c.logReceive(ptp.MessageSync, "seq=%d, our ReceiveTimestamp=%v", b.SequenceID, ts)
-	c.m.addSync(b.SequenceID, ts)
-	return nil
+	c.m.addSync(b.SequenceID, ts, b.CorrectionField.Duration())
+	if c.requester == nil || b.FlagField&ptp.FlagTwoStep != 0 {
+		return nil
+	}
+	c.m.addFollowUp(b.SequenceID, b.OriginTimestamp.Time(), 0)
+	return c.sendDelayReq()
 }
 
 // handleDelay handles DELAY packet and adds ReceiveTimestamp to measurements
 func (c *Client) handleDelay(b *ptp.DelayResp) error {
 	c.logReceive(ptp.MessageDelayResp, "seq=%d, server ReceiveTimestamp=%v", b.SequenceID, b.ReceiveTimestamp.Time())
 	// store data in measurements
-	c.m.addDelayResp(b.SequenceID, b.ReceiveTimestamp.Time())
+	c.m.addDelayResp(b.SequenceID, b.ReceiveTimestamp.Time(), b.CorrectionField.Duration())
 
 	// do whatever needs to be done with current measurements
 	res, err := c.m.latest()
@@ -393,6 +550,7 @@ func (c *Client) handleDelay(b *ptp.DelayResp) error {
 		log.Warningf("failed to get measurements: %v", err)
 		return nil
 	}
+	res.Offset -= c.asymmetry
 	c.callback(res)
 	return nil
 }
@@ -400,9 +558,13 @@ func (c *Client) handleDelay(b *ptp.DelayResp) error {
 // handleFollowUp handles FOLLOW_UP packet and sends DELAY_REQ packet
 func (c *Client) handleFollowUp(b *ptp.FollowUp) error {
 	c.logReceive(ptp.MessageFollowUp, "seq=%d, server PreciseOriginTimestamp=%v", b.SequenceID, b.PreciseOriginTimestamp.Time())
-	c.m.addFollowUp(b.SequenceID, b.PreciseOriginTimestamp.Time())
-	// ask for delay
-	seq, hwts, err := c.sendEventMsg(reqDelay(c.clockID))
+	c.m.addFollowUp(b.SequenceID, b.PreciseOriginTimestamp.Time(), b.CorrectionField.Duration())
+	return c.sendDelayReq()
+}
+
+// sendDelayReq asks for delay by sending DELAY_REQ packet
+func (c *Client) sendDelayReq() error {
+	seq, hwts, err := c.sendEventMsg(reqDelay(c.clockID, c.cfg.DomainNumber))
 	if err != nil {
 		return err
 	}
@@ -417,6 +579,9 @@ func (c *Client) handleMsg(msg *inPacket) error {
 	if err != nil {
 		return err
 	}
+	if !c.authenticated(msgType, msg.data) {
+		return nil
+	}
 	switch msgType {
 	case ptp.MessageSignaling:
 		signaling := &ptp.Signaling{}
@@ -436,6 +601,10 @@ func (c *Client) handleMsg(msg *inPacket) error {
 					return err
 				}
 			default:
+				if c.requester != nil {
+					c.logReceive(ptp.MessageSignaling, "unsupported TLV type %s(%d), ignoring", tlv.Type(), tlv.Type())
+					continue
+				}
 				return fmt.Errorf("got unsupported TLV type %s(%d)", tlv.Type(), tlv.Type())
 			}
 		}
@@ -504,32 +673,59 @@ func (c *Client) runInternal(skipSetup bool) error {
 	}
 
 	eg.Go(func() error {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Debugf("cancelled main loop")
-				return ctx.Err()
-			case msg := <-c.inChan:
-				if err := c.handleMsg(msg); err != nil {
+		return c.loop(ctx, cancel)
+	})
+	return eg.Wait()
+}
+
+// loop talks to the server until the transmission is cancelled, calling cancel once it's done
+func (c *Client) loop(ctx context.Context, cancel context.CancelFunc) error {
+	for {
+		if c.state == stateDone {
+			cancel()
+			return nil
+		}
+		// request is retried until the server answers, less and less often
+		var retry <-chan time.Time
+		switch {
+		case c.requester != nil:
+			retry = time.After(time.Until(c.requester.NextDue()))
+		case c.state == stateInit:
+			retry = time.After(time.Until(c.nextRetry))
+		}
+		select {
+		case <-ctx.Done():
+			log.Debugf("cancelled main loop")
+			return ctx.Err()
+		case msg := <-c.inChan:
+			if err := c.handleMsg(msg); err != nil {
+				return err
+			}
+		case <-retry:
+			if c.requester != nil {
+				if err := c.requestCompat(); err != nil {
 					return err
 				}
-			default:
-				switch c.state {
-				case stateInit:
-					seq, err := c.sendGeneralMsg(reqUnicast(c.clockID, c.cfg.Duration, ptp.MessageAnnounce))
-					if err != nil {
-						return err
-					}
-					c.logSent(ptp.MessageSignaling, "for %s, seq=%d", ptp.MessageAnnounce, seq)
-					time.Sleep(time.Second)
-				case stateDone:
-					cancel()
-					return nil
-				}
+				continue
+			}
+			seq, err := c.sendGeneralMsg(reqUnicast(c.clockID, c.cfg.DomainNumber, c.cfg.Duration, ptp.MessageAnnounce))
+			if err != nil {
+				return err
 			}
+			c.logSent(ptp.MessageSignaling, "for %s, seq=%d", ptp.MessageAnnounce, seq)
+			c.nextRetry = time.Now().Add(c.retryInterval())
+			c.retries++
 		}
-	})
-	return eg.Wait()
+	}
+}
+
+// retryInterval returns how long to wait for the server to answer before requesting again
+func (c *Client) retryInterval() time.Duration {
+	max := c.cfg.MaxRetryInterval
+	if max == 0 {
+		max = DefaultMaxRetryInterval
+	}
+	return negotiation.Backoff(retryInterval, max, c.retries, retryJitter)
 }
 
 // Close connections
@@ -26,8 +26,10 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 
 	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/facebook/time/timestamp"
 )
 
 func grantUnicastPkt(seq int, clockID ptp.ClockIdentity, duration time.Duration, what ptp.MessageType) *ptp.Signaling {
@@ -88,7 +90,7 @@ func cancelUnicastPkt(seq int, clockID ptp.ClockIdentity, what ptp.MessageType)
 }
 
 func announcePkt(seq int) *ptp.Announce {
-	l := binary.Size(ptp.Announce{})
+	l := binary.Size(ptp.Header{}) + binary.Size(ptp.AnnounceBody{})
 	return &ptp.Announce{
 		Header: ptp.Header{
 			SdoIDAndMsgType:    ptp.NewSdoIDAndMsgType(ptp.MessageAnnounce, 0),
@@ -306,3 +308,45 @@ func TestClientTimeout(t *testing.T) {
 	require.Error(t, err, "full client run should fail")
 	assert.Equal(t, 0, len(history))
 }
+
+func TestLocalAddr(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.ParseIP("192.168.0.1")}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}
+
+	network, ip := localAddr([]*net.UDPAddr{v4})
+	require.Equal(t, "udp4", network)
+	require.True(t, ip.Equal(net.IPv4zero))
+
+	network, ip = localAddr([]*net.UDPAddr{v4, v6})
+	require.Equal(t, "udp", network)
+	require.True(t, ip.Equal(net.IPv6unspecified))
+}
+
+func TestEnableDSCP(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, enableDSCP(46, []*net.UDPAddr{v4}, conn))
+	fd, err := timestamp.ConnFd(conn)
+	require.NoError(t, err)
+	tos, err := unix.GetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS)
+	require.NoError(t, err)
+	require.Equal(t, 46<<2, tos)
+}
+
+func TestClientRetryInterval(t *testing.T) {
+	c := New(&Config{}, func(*MeasurementResult) {})
+	require.Equal(t, time.Second, c.retryInterval())
+	c.retries = 100
+	require.GreaterOrEqual(t, c.retryInterval(), DefaultMaxRetryInterval)
+
+	c.cfg.MaxRetryInterval = 4 * time.Second
+	c.retries = 1
+	require.GreaterOrEqual(t, c.retryInterval(), 2*time.Second)
+	require.Less(t, c.retryInterval(), 3*time.Second)
+	c.retries = 5
+	require.GreaterOrEqual(t, c.retryInterval(), 4*time.Second)
+	require.Less(t, c.retryInterval(), 5*time.Second)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/facebook/time/ptp/negotiation"
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// compatDenialBackoff is how long to wait before requesting again after the server denied or cancelled transmission
+const compatDenialBackoff = 10 * time.Second
+
+// newCompatRequester returns requester of all messages needed for two-step exchanges,
+// renewing grants once half of their duration is left
+func newCompatRequester(cfg *Config) *negotiation.Requester {
+	max := cfg.MaxRetryInterval
+	if max == 0 {
+		max = DefaultMaxRetryInterval
+	}
+	r := negotiation.NewRequester(negotiation.RequesterConfig{
+		Duration:        cfg.Duration,
+		GrantTimeout:    retryInterval,
+		MaxGrantTimeout: max,
+		BackoffJitter:   retryJitter,
+		DenialBackoff:   compatDenialBackoff,
+		// capped at half of the granted duration
+		RenewalMargin: cfg.Duration,
+	})
+	for _, msgType := range []ptp.MessageType{ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp} {
+		r.Add(msgType, 1)
+	}
+	return r
+}
+
+// requestCompat sends requests which are due, be it initial requests, renewals or retries
+func (c *Client) requestCompat() error {
+	for _, tlv := range c.requester.Due(time.Now()) {
+		seq, err := c.sendGeneralMsg(reqTLV(c.clockID, c.cfg.DomainNumber, tlv))
+		if err != nil {
+			return err
+		}
+		c.logSent(ptp.MessageSignaling, "for %s, seq=%d", tlv.MsgTypeAndReserved.MsgType(), seq)
+	}
+	return nil
+}
+
+// handleGrantCompat handles grant in compat mode, where denials are requested again later instead of failing
+func (c *Client) handleGrantCompat(tlv *ptp.GrantUnicastTransmissionTLV) error {
+	msgType := tlv.MsgTypeAndReserved.MsgType()
+	if err := c.requester.HandleGrant(tlv, time.Now()); err != nil {
+		log.Warningf("ignoring grant: %v", err)
+		return nil
+	}
+	if tlv.DurationField == 0 {
+		log.Warningf("server denied us grant for %s, requesting again in %v", msgType, compatDenialBackoff)
+		return nil
+	}
+	if msgType == ptp.MessageAnnounce {
+		c.setState(stateInProgress)
+	}
+	return nil
+}
+
+// handleCancelCompat acknowledges cancellation in compat mode, cancelled transmission is requested again later
+func (c *Client) handleCancelCompat(tlv *ptp.CancelUnicastTransmissionTLV) error {
+	msgType := tlv.MsgTypeAndFlags.MsgType()
+	c.logReceive(ptp.MessageSignaling, "unicast transmission of %s cancelled, requesting again in %v", msgType, compatDenialBackoff)
+	ack := c.requester.HandleCancel(tlv, time.Now())
+	seq, err := c.sendGeneralMsg(reqTLV(c.clockID, c.cfg.DomainNumber, ack))
+	if err != nil {
+		return err
+	}
+	c.logSent(ptp.MessageSignaling, "ACK CANCEL for %s, seq=%d", msgType, seq)
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+func TestClientCompat(t *testing.T) {
+	cfg := &Config{Duration: time.Minute, Compat: true}
+	history := []*MeasurementResult{}
+	c := New(cfg, func(m *MeasurementResult) {
+		history = append(history, m)
+	})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	genConn := NewMockUDPConn(ctrl)
+	c.genConn = genConn
+	sent := []*ptp.Signaling{}
+	genConn.EXPECT().WriteTo(gomock.Any(), gomock.Any()).DoAndReturn(func(b []byte, _ net.Addr) (int, error) {
+		signaling := &ptp.Signaling{}
+		require.NoError(t, ptp.FromBytes(b, signaling))
+		sent = append(sent, signaling)
+		return len(b), nil
+	}).AnyTimes()
+	eventConn := NewMockUDPConnWithTS(ctrl)
+	c.eventConn = eventConn
+
+	// everything is requested at once
+	require.NoError(t, c.requestCompat())
+	require.Len(t, sent, 3)
+	for i, msgType := range []ptp.MessageType{ptp.MessageSync, ptp.MessageDelayResp, ptp.MessageAnnounce} {
+		tlv, ok := sent[i].TLVs[0].(*ptp.RequestUnicastTransmissionTLV)
+		require.True(t, ok)
+		require.Equal(t, msgType, tlv.MsgTypeAndReserved.MsgType())
+		require.Equal(t, uint32(60), tlv.DurationField)
+	}
+
+	handle := func(p ptp.Packet, ts time.Time) {
+		b, err := ptp.Bytes(p)
+		require.NoError(t, err)
+		require.NoError(t, c.handleMsg(&inPacket{data: b, ts: ts}))
+	}
+
+	// denial is not fatal, unexpected grants and unknown TLVs are skipped
+	handle(grantUnicastPkt(0, c.clockID, 0, ptp.MessageSync), time.Time{})
+	s, _ := c.requester.Subscription(ptp.MessageSync)
+	require.Equal(t, "DENIED", s.State.String())
+	handle(grantUnicastPkt(1, c.clockID, time.Minute, ptp.MessageManagement), time.Time{})
+	handle(reqUnicast(c.clockID, 0, time.Minute, ptp.MessageSync), time.Time{})
+	for _, msgType := range []ptp.MessageType{ptp.MessageAnnounce, ptp.MessageSync, ptp.MessageDelayResp} {
+		handle(grantUnicastPkt(2, c.clockID, time.Minute, msgType), time.Time{})
+	}
+	require.Equal(t, state(stateInProgress), c.state)
+	require.True(t, c.requester.NextDue().After(time.Now().Add(20*time.Second)))
+
+	// one-step sync, delay_req is sent right away
+	now := time.Now()
+	eventConn.EXPECT().WriteToWithTS(gomock.Any(), gomock.Any()).Return(10, now.Add(time.Millisecond), nil)
+	sync := syncPkt(4)
+	sync.OriginTimestamp = ptp.NewTimestamp(now.Add(-105 * time.Microsecond))
+	sync.CorrectionField = ptp.NewCorrection(5000)
+	handle(sync, now)
+	delayResp := delayRespPkt(0)
+	delayResp.ReceiveTimestamp = ptp.NewTimestamp(now.Add(time.Millisecond + 100*time.Microsecond))
+	handle(delayResp, time.Time{})
+	require.Len(t, history, 1)
+	require.Equal(t, 100*time.Microsecond, history[0].Delay)
+	require.Equal(t, time.Duration(0), history[0].Offset)
+
+	// cancellation is acknowledged and requested again later
+	sent = sent[:0]
+	handle(cancelUnicastPkt(5, c.clockID, ptp.MessageAnnounce), time.Time{})
+	require.Len(t, sent, 1)
+	_, ok := sent[0].TLVs[0].(*ptp.AcknowledgeCancelUnicastTransmissionTLV)
+	require.True(t, ok)
+	s, _ = c.requester.Subscription(ptp.MessageAnnounce)
+	require.Equal(t, "CANCELLED", s.State.String())
+}
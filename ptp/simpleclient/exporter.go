@@ -0,0 +1,146 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultExportInterval is how often the state of grandmasters is passed to exporters
+const DefaultExportInterval = 10 * time.Second
+
+// prometheusPrefix is a prefix of all exported Prometheus metrics
+const prometheusPrefix = "simpleclient_"
+
+// Exporter is a sink of the state of grandmasters polled by MultiClient.
+// Implement it to plug the client into monitoring other than built-in Prometheus and JSON push.
+type Exporter interface {
+	// Export reports the state of all grandmasters, in order of priority
+	Export(stats []GMStats) error
+}
+
+// export passes the state of grandmasters to every exporter, failed ones don't stop the others
+func (m *MultiClient) export(now time.Time) {
+	if len(m.cfg.Exporters) == 0 {
+		return
+	}
+	stats := m.Stats(now)
+	for _, e := range m.cfg.Exporters {
+		if err := e.Export(stats); err != nil {
+			log.Errorf("failed to export state of grandmasters: %v", err)
+		}
+	}
+}
+
+// PrometheusExporter keeps the last exported state and serves it to Prometheus scrapes
+type PrometheusExporter struct {
+	mux   sync.Mutex
+	stats []GMStats
+}
+
+// Export stores the state to serve
+func (p *PrometheusExporter) Export(stats []GMStats) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.stats = stats
+	return nil
+}
+
+// ServeHTTP serves the last exported state in Prometheus text exposition format
+func (p *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.Lock()
+	stats := p.stats
+	p.mux.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := writePrometheus(w, stats); err != nil {
+		log.Errorf("failed to reply: %v", err)
+	}
+}
+
+// boolGauge converts flag to gauge value
+func boolGauge(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writePrometheus writes one metric per field of GMStats, labeled by address of the grandmaster
+func writePrometheus(w io.Writer, stats []GMStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	bw := bufio.NewWriter(w)
+	for _, m := range []struct {
+		name, kind, help string
+		value            func(s *GMStats) int64
+	}{
+		{"offset_nanoseconds", "gauge", "Last offset measured against the grandmaster", func(s *GMStats) int64 { return s.Offset }},
+		{"mean_path_delay_nanoseconds", "gauge", "Last mean path delay to the grandmaster", func(s *GMStats) int64 { return s.MeanPathDelay }},
+		{"selected", "gauge", "Grandmaster is the selected one", func(s *GMStats) int64 { return boolGauge(s.Selected) }},
+		{"usable", "gauge", "Grandmaster produces measurements and may be selected", func(s *GMStats) int64 { return boolGauge(s.Usable) }},
+		{"lost_total", "counter", "Times the grandmaster was lost", func(s *GMStats) int64 { return int64(s.Lost) }},
+		{"dropped_total", "counter", "Packets of the grandmaster dropped while its client wasn't keeping up", func(s *GMStats) int64 { return int64(s.Dropped) }},
+		{"unauthenticated_total", "counter", "Messages of the grandmaster dropped for failed authentication", func(s *GMStats) int64 { return int64(s.Unauthenticated) }},
+		{"clock_class", "gauge", "Clock class announced by the grandmaster", func(s *GMStats) int64 { return int64(s.ClockClass) }},
+		{"clock_accuracy", "gauge", "Clock accuracy announced by the grandmaster", func(s *GMStats) int64 { return int64(s.ClockAccuracy) }},
+		{"steps_removed", "gauge", "Steps removed announced by the grandmaster", func(s *GMStats) int64 { return int64(s.StepsRemoved) }},
+	} {
+		fmt.Fprintf(bw, "# HELP %s%s %s\n# TYPE %s%s %s\n", prometheusPrefix, m.name, m.help, prometheusPrefix, m.name, m.kind)
+		for i := range stats {
+			fmt.Fprintf(bw, "%s%s{address=%q} %d\n", prometheusPrefix, m.name, stats[i].Address, m.value(&stats[i]))
+		}
+	}
+	return bw.Flush()
+}
+
+// JSONPushExporter posts the state of grandmasters as JSON to the URL
+type JSONPushExporter struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewJSONPushExporter returns exporter posting to the url, giving up on requests after timeout
+func NewJSONPushExporter(url string, timeout time.Duration) *JSONPushExporter {
+	return &JSONPushExporter{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+// Export posts the state, same as served by MultiClient
+func (j *JSONPushExporter) Export(stats []GMStats) error {
+	js, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	resp, err := j.Client.Post(j.URL, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushing to %s: %s", j.URL, resp.Status)
+	}
+	return nil
+}
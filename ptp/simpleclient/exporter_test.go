@@ -0,0 +1,111 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingExporter keeps everything it was given, failing if told to
+type recordingExporter struct {
+	exported [][]GMStats
+	err      error
+}
+
+func (r *recordingExporter) Export(stats []GMStats) error {
+	r.exported = append(r.exported, stats)
+	return r.err
+}
+
+func TestMultiClientExport(t *testing.T) {
+	failing := &recordingExporter{err: fmt.Errorf("monitoring is down")}
+	working := &recordingExporter{}
+	cfg := &MultiConfig{Addresses: []string{"a", "b"}, Exporters: []Exporter{failing, working}}
+	m := NewMulti(cfg, func(string, *MeasurementResult) {})
+	require.Equal(t, DefaultExportInterval, cfg.ExportInterval)
+	m.clients[0].callback(&MeasurementResult{Offset: 5, Delay: 100})
+
+	// failed exporter doesn't stop the others
+	m.export(time.Now())
+	require.Len(t, failing.exported, 1)
+	require.Len(t, working.exported, 1)
+	require.Equal(t, m.Stats(time.Now()), working.exported[0])
+}
+
+func TestPrometheusExporter(t *testing.T) {
+	p := &PrometheusExporter{}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Body.String())
+
+	require.NoError(t, p.Export([]GMStats{
+		{Address: "a", Selected: true, Usable: true, Offset: -42, MeanPathDelay: 1000, ClockClass: 6},
+		{Address: "b", Lost: 2},
+	}))
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, "text/plain; version=0.0.4", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE simpleclient_offset_nanoseconds gauge",
+		`simpleclient_offset_nanoseconds{address="a"} -42`,
+		`simpleclient_mean_path_delay_nanoseconds{address="a"} 1000`,
+		`simpleclient_selected{address="a"} 1`,
+		`simpleclient_selected{address="b"} 0`,
+		"# TYPE simpleclient_lost_total counter",
+		`simpleclient_lost_total{address="b"} 2`,
+		`simpleclient_clock_class{address="a"} 6`,
+	} {
+		require.Contains(t, strings.Split(body, "\n"), line)
+	}
+}
+
+func TestJSONPushExporter(t *testing.T) {
+	var pushed []map[string]interface{}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(b, &pushed))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	j := NewJSONPushExporter(srv.URL, time.Second)
+	require.NoError(t, j.Export([]GMStats{{Address: "a", Offset: 5}}))
+	require.Len(t, pushed, 1)
+	require.Equal(t, "a", pushed[0]["address"])
+	require.Equal(t, 5.0, pushed[0]["offset_ns"])
+
+	status = http.StatusServiceUnavailable
+	require.Error(t, j.Export([]GMStats{}))
+
+	j = NewJSONPushExporter("http://127.0.0.1:0/", time.Second)
+	require.Error(t, j.Export([]GMStats{}))
+}
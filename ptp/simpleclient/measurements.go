@@ -27,6 +27,14 @@ type mData struct {
 	seq       uint16
 	sendTS    time.Time
 	receiveTS time.Time
+	// correctionField of messages carrying the timestamps, residence time of transparent clocks on the path
+	sendCorrection    time.Duration
+	receiveCorrection time.Duration
+}
+
+// diff returns how long it took the packet to get from sender to receiver
+func (d *mData) diff() time.Duration {
+	return d.receiveTS.Sub(d.sendTS) - d.sendCorrection - d.receiveCorrection
 }
 
 // MeasurementResult is a single measured datapoint
@@ -47,27 +55,29 @@ type measurements struct {
 	clientToServer   map[uint16]*mData
 }
 
-// addSync stores ts and seq of SYNC packet
-func (m *measurements) addSync(seq uint16, ts time.Time) {
+// addSync stores ts, seq and correction of SYNC packet
+func (m *measurements) addSync(seq uint16, ts time.Time, correction time.Duration) {
 	m.Lock()
 	defer m.Unlock()
 	v, found := m.serverToClient[seq]
 	if found {
 		v.receiveTS = ts
+		v.receiveCorrection = correction
 	} else {
-		m.serverToClient[seq] = &mData{seq: seq, receiveTS: ts}
+		m.serverToClient[seq] = &mData{seq: seq, receiveTS: ts, receiveCorrection: correction}
 	}
 }
 
-// addFollowUp stores ts and seq of FOLLOW_UP packet
-func (m *measurements) addFollowUp(seq uint16, ts time.Time) {
+// addFollowUp stores ts, seq and correction of FOLLOW_UP packet
+func (m *measurements) addFollowUp(seq uint16, ts time.Time, correction time.Duration) {
 	m.Lock()
 	defer m.Unlock()
 	v, found := m.serverToClient[seq]
 	if found {
 		v.sendTS = ts
+		v.sendCorrection = correction
 	} else {
-		m.serverToClient[seq] = &mData{seq: seq, sendTS: ts}
+		m.serverToClient[seq] = &mData{seq: seq, sendTS: ts, sendCorrection: correction}
 	}
 }
 
@@ -83,15 +93,16 @@ func (m *measurements) addDelayReq(seq uint16, ts time.Time) {
 	}
 }
 
-// addDelayResp stores ts and seq of DELAY_RESP packet and updates history with latest measurements
-func (m *measurements) addDelayResp(seq uint16, ts time.Time) {
+// addDelayResp stores ts, seq and correction of DELAY_RESP packet and updates history with latest measurements
+func (m *measurements) addDelayResp(seq uint16, ts time.Time, correction time.Duration) {
 	m.Lock()
 	defer m.Unlock()
 	v, found := m.clientToServer[seq]
 	if found {
 		v.receiveTS = ts
+		v.receiveCorrection = correction
 	} else {
-		m.clientToServer[seq] = &mData{seq: seq, receiveTS: ts}
+		m.clientToServer[seq] = &mData{seq: seq, receiveTS: ts, receiveCorrection: correction}
 	}
 }
 
@@ -122,8 +133,8 @@ func (m *measurements) latest() (*MeasurementResult, error) {
 	if lastClientToServer == nil {
 		return nil, fmt.Errorf("no delay data yet")
 	}
-	clientToServerDiff := lastClientToServer.receiveTS.Sub(lastClientToServer.sendTS)
-	serverToClientDiff := lastServerToClient.receiveTS.Sub(lastServerToClient.sendTS)
+	clientToServerDiff := lastClientToServer.diff()
+	serverToClientDiff := lastServerToClient.diff()
 	delay := (clientToServerDiff + serverToClientDiff) / 2
 	offset := serverToClientDiff - delay
 	// or this expression of same formula
@@ -36,15 +36,15 @@ func TestMeasurementsFullRun(t *testing.T) {
 		require.Nil(t, err)
 
 		// time when we received SYNC
-		m.addSync(syncSeq, timeSync)
+		m.addSync(syncSeq, timeSync, 0)
 		// time when SYNC was actually sent by GM
-		m.addFollowUp(syncSeq, timeSync.Add(-netDelay))
+		m.addFollowUp(syncSeq, timeSync.Add(-netDelay), 0)
 		// time when we sent out DELAY_REQ
 		timeDelaySent := timeSync.Add(10 * time.Millisecond)
 		m.addDelayReq(delaySeq, timeDelaySent)
 		// time when DELAY_REQ was received by GM
 		timeLastPacket := timeDelaySent.Add(netDelayBack)
-		m.addDelayResp(delaySeq, timeLastPacket)
+		m.addDelayResp(delaySeq, timeLastPacket, 0)
 
 		got, err := m.latest()
 		require.Nil(t, err)
@@ -67,15 +67,15 @@ func TestMeasurementsFullRun(t *testing.T) {
 		require.Nil(t, err)
 
 		// time when we received SYNC
-		m.addSync(syncSeq, timeSync)
+		m.addSync(syncSeq, timeSync, 0)
 		// time when SYNC was actually sent by GM
-		m.addFollowUp(syncSeq, timeSync.Add(-netDelay))
+		m.addFollowUp(syncSeq, timeSync.Add(-netDelay), 0)
 		// time when we sent out DELAY_REQ
 		timeDelaySent := timeSync.Add(10 * time.Millisecond)
 		m.addDelayReq(delaySeq, timeDelaySent)
 		// time when DELAY_REQ was received by GM
 		timeLastPacket := timeDelaySent.Add(netDelayBack)
-		m.addDelayResp(delaySeq, timeLastPacket)
+		m.addDelayResp(delaySeq, timeLastPacket, 0)
 
 		got, err := m.latest()
 		require.Nil(t, err)
@@ -88,4 +88,32 @@ func TestMeasurementsFullRun(t *testing.T) {
 		}
 		assert.Equal(t, want, got)
 	})
+
+	t.Run("residence time in correction fields", func(t *testing.T) {
+		netDelay := 100 * time.Millisecond
+		residence := 30 * time.Millisecond
+
+		timeSync, err := time.Parse(time.RFC3339, "2021-05-21T13:32:05+01:00")
+		require.Nil(t, err)
+
+		// transparent clock held SYNC for residence time, split between SYNC and FOLLOW_UP
+		m.addSync(syncSeq, timeSync, residence/3)
+		m.addFollowUp(syncSeq, timeSync.Add(-netDelay-residence), 2*residence/3)
+		timeDelaySent := timeSync.Add(10 * time.Millisecond)
+		m.addDelayReq(delaySeq, timeDelaySent)
+		// and DELAY_REQ as well
+		timeLastPacket := timeDelaySent.Add(netDelay + residence)
+		m.addDelayResp(delaySeq, timeLastPacket, residence)
+
+		got, err := m.latest()
+		require.Nil(t, err)
+		want := &MeasurementResult{
+			Delay:              netDelay,
+			ServerToClientDiff: netDelay,
+			ClientToServerDiff: netDelay,
+			Offset:             0,
+			Timestamp:          timeLastPacket,
+		}
+		assert.Equal(t, want, got)
+	})
 }
@@ -0,0 +1,417 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/facebook/time/ptp/bmca"
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+// DefaultStaleAfter is for how long grandmaster without new measurements can stay selected.
+// Client asks for Sync every 2 seconds, so it's 3 missed measurements.
+const DefaultStaleAfter = 6 * time.Second
+
+// Candidate is a grandmaster polled by MultiClient
+type Candidate struct {
+	// Address of the grandmaster
+	Address string
+	// Priority is the position of the grandmaster in configured list, lower is preferred
+	Priority int
+	// Announce is the last Announce received from the grandmaster
+	Announce *ptp.Announce
+	// Measurement is the last measurement against the grandmaster
+	Measurement *MeasurementResult
+	// Updated is when the last measurement was collected
+	Updated time.Time
+	// Err is why we stopped talking to the grandmaster
+	Err error
+	// Lost is how many times the grandmaster stopped being usable, by error or going stale
+	Lost int
+
+	wasUsable bool
+}
+
+// usable returns true if the candidate can be selected at the moment
+func (c *Candidate) usable(now time.Time, staleAfter time.Duration) bool {
+	return c.Err == nil && c.Measurement != nil && now.Sub(c.Updated) <= staleAfter
+}
+
+// SelectionPolicy returns true if grandmaster a is better than b, both a and b are usable.
+// Equal grandmasters must not be better than each other, so the selected one is kept.
+type SelectionPolicy func(a, b *Candidate) bool
+
+// PolicyBMCA selects grandmaster by data set comparison of their Announce messages
+func PolicyBMCA(a, b *Candidate) bool {
+	if a.Announce == nil || b.Announce == nil {
+		return a.Announce != nil
+	}
+	r, _ := bmca.Compare(bmca.FromAnnounce(a.Announce, ptp.PortIdentity{}), bmca.FromAnnounce(b.Announce, ptp.PortIdentity{}))
+	return r == bmca.ABetter || r == bmca.ABetterByTopology
+}
+
+// PolicyDelay selects grandmaster with the lowest path delay
+func PolicyDelay(a, b *Candidate) bool {
+	return a.Measurement.Delay < b.Measurement.Delay
+}
+
+// PolicyPriority selects grandmaster by its position in configured list
+func PolicyPriority(a, b *Candidate) bool {
+	return a.Priority < b.Priority
+}
+
+// Policies maps names of selection policies to implementations
+var Policies = map[string]SelectionPolicy{
+	"bmca":     PolicyBMCA,
+	"delay":    PolicyDelay,
+	"priority": PolicyPriority,
+}
+
+// PolicyNames returns sorted names of selection policies
+func PolicyNames() []string {
+	names := make([]string, 0, len(Policies))
+	for name := range Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PolicyByName returns selection policy by its name
+func PolicyByName(name string) (SelectionPolicy, error) {
+	p, ok := Policies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown selection policy %q, must be one of %s", name, strings.Join(PolicyNames(), ", "))
+	}
+	return p, nil
+}
+
+// MultiConfig specifies MultiClient run options
+type MultiConfig struct {
+	// Config is used for every grandmaster, except for the Address
+	Config
+	// Addresses of grandmasters, in order of priority
+	Addresses []string
+	// Policy selects the grandmaster to use
+	Policy SelectionPolicy
+	// StaleAfter is for how long grandmaster without new measurements can stay selected
+	StaleAfter time.Duration
+	// Exporters get the state of grandmasters every ExportInterval and once the client stops
+	Exporters      []Exporter
+	ExportInterval time.Duration
+	// GMKeys are keys grandmasters sign their messages with, overriding Config.AuthKeys of those having any
+	GMKeys GMKeys
+}
+
+// lockedConnTS serializes writes to event connection shared by clients, so they read TX timestamps of their own packets
+type lockedConnTS struct {
+	sync.Mutex
+	UDPConnWithTS
+}
+
+func (c *lockedConnTS) WriteToWithTS(b []byte, addr net.Addr) (int, time.Time, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.UDPConnWithTS.WriteToWithTS(b, addr)
+}
+
+// MultiClient talks to multiple grandmasters at once, and reports measurements of the one selected by the policy.
+// Once selected grandmaster stops producing measurements, cancels transmission or gets worse than another one,
+// next best grandmaster is selected right away.
+type MultiClient struct {
+	cfg *MultiConfig
+
+	clients []*Client
+	// clients by IP address of the grandmaster
+	byIP map[string]*Client
+
+	mux        sync.Mutex
+	candidates []*Candidate
+	selected   *Candidate
+
+	genConn   UDPConn
+	eventConn UDPConnWithTS
+	// what to do when we receive latest measurement from selected grandmaster
+	callback func(address string, m *MeasurementResult)
+}
+
+// NewMulti initializes new multi-grandmaster PTPv2 unicast client
+func NewMulti(cfg *MultiConfig, callback func(address string, m *MeasurementResult)) *MultiClient {
+	if cfg.StaleAfter == 0 {
+		cfg.StaleAfter = DefaultStaleAfter
+	}
+	if cfg.ExportInterval == 0 {
+		cfg.ExportInterval = DefaultExportInterval
+	}
+	if cfg.Policy == nil {
+		cfg.Policy = PolicyBMCA
+	}
+	m := &MultiClient{
+		cfg:      cfg,
+		byIP:     map[string]*Client{},
+		callback: callback,
+	}
+	for i, address := range cfg.Addresses {
+		ccfg := cfg.Config
+		ccfg.Address = address
+		if keys := cfg.GMKeys.For(address); keys != nil {
+			ccfg.AuthKeys = keys
+		}
+		cand := &Candidate{Address: address, Priority: i}
+		c := New(&ccfg, func(r *MeasurementResult) {
+			now := time.Now()
+			m.update(cand, now, func() {
+				cand.Measurement = r
+				cand.Updated = now
+			})
+		})
+		c.announceCallback = func(a *ptp.Announce) {
+			m.update(cand, time.Now(), func() {
+				cand.Announce = a
+			})
+		}
+		m.clients = append(m.clients, c)
+		m.candidates = append(m.candidates, cand)
+	}
+	return m
+}
+
+// Selected returns the copy of selected grandmaster, nil if there is none
+func (m *MultiClient) Selected() *Candidate {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.selected == nil {
+		return nil
+	}
+	c := *m.selected
+	return &c
+}
+
+// update changes data of the candidate with fn and selects the grandmaster again.
+// Measurement of the candidate is passed to the callback if it's selected.
+func (m *MultiClient) update(cand *Candidate, now time.Time, fn func()) {
+	m.mux.Lock()
+	measurement := cand.Measurement
+	fn()
+	m.reselect(now)
+	report := m.selected == cand && cand.Measurement != measurement
+	measurement = cand.Measurement
+	m.mux.Unlock()
+	if report {
+		m.callback(cand.Address, measurement)
+	}
+}
+
+// check selects the grandmaster again, as the selected one may have gone stale
+func (m *MultiClient) check(now time.Time) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.reselect(now)
+}
+
+// reselect selects the best usable grandmaster, keeping the current one if nothing is better. Must be called under lock.
+func (m *MultiClient) reselect(now time.Time) {
+	var best *Candidate
+	if m.selected != nil && m.selected.usable(now, m.cfg.StaleAfter) {
+		best = m.selected
+	}
+	for _, c := range m.candidates {
+		usable := c.usable(now, m.cfg.StaleAfter)
+		if c.wasUsable && !usable {
+			c.Lost++
+		}
+		c.wasUsable = usable
+		if !usable {
+			continue
+		}
+		if best == nil || m.cfg.Policy(c, best) {
+			best = c
+		}
+	}
+	if best == m.selected {
+		return
+	}
+	switch {
+	case best == nil:
+		log.Warningf("no usable grandmaster, %s is lost", m.selected.Address)
+	case m.selected == nil:
+		log.Infof("selected grandmaster %s", best.Address)
+	default:
+		log.Warningf("switching grandmaster from %s to %s", m.selected.Address, best.Address)
+	}
+	m.selected = best
+}
+
+// setup resolves grandmaster addresses and binds ports shared by all clients
+func (m *MultiClient) setup(ctx context.Context, eg *errgroup.Group) error {
+	cid, err := clockIdentity(m.cfg.Iface)
+	if err != nil {
+		return err
+	}
+	log.Infof("using ClockIdentity %s, talking to %s using Two-Step Unicast PTPv2 protocol", cid, strings.Join(m.cfg.Addresses, ", "))
+	servers := make([]*net.UDPAddr, 0, len(m.clients))
+	for _, c := range m.clients {
+		genAddr, eventAddr, err := serverAddrs(c.cfg.Address)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, genAddr)
+		c.clockID = cid
+		c.genAddr = genAddr
+		c.eventAddr = eventAddr
+		c.setAsymmetry()
+	}
+	genConn, eventConn, connFd, err := listen(&m.cfg.Config, servers)
+	if err != nil {
+		return err
+	}
+	m.genConn = genConn
+	m.eventConn = &lockedConnTS{UDPConnWithTS: &udpConnTS{eventConn}}
+	m.share()
+
+	receive(ctx, eg, genConn, connFd, m.deliver)
+	return nil
+}
+
+// share makes all clients use connections of the MultiClient
+func (m *MultiClient) share() {
+	for _, c := range m.clients {
+		c.genConn = m.genConn
+		c.eventConn = m.eventConn
+		m.byIP[c.genAddr.IP.String()] = c
+	}
+}
+
+// deliver passes the packet to the client talking to the grandmaster which sent it.
+// It never blocks, so a client which is behind or stopped talking to its grandmaster doesn't hold up packets of the others
+func (m *MultiClient) deliver(ip net.IP, p *inPacket) {
+	c, found := m.byIP[ip.String()]
+	if !found {
+		log.Warningf("ignoring packets from server %v", ip)
+		return
+	}
+	select {
+	case c.inChan <- p:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		log.Debugf("dropping packet from server %v, its client is not keeping up", ip)
+	}
+}
+
+// Dropped returns how many packets of the grandmaster MultiClient dropped while the client wasn't keeping up with them
+func (c *Client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Run makes client talk to all grandmasters provided in config until the timeout
+func (m *MultiClient) Run() error {
+	return m.runInternal(false)
+}
+
+// runInternal allows us to skip setup for unittests
+func (m *MultiClient) runInternal(skipSetup bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout)
+	defer cancel()
+	eg, ctx := errgroup.WithContext(ctx)
+
+	if !skipSetup {
+		if err := m.setup(ctx, eg); err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range m.clients {
+		c, cand := c, m.candidates[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx, ccancel := context.WithCancel(ctx)
+			defer ccancel()
+			err := c.loop(cctx, ccancel)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = fmt.Errorf("transmission cancelled")
+			}
+			log.Warningf("stopped talking to grandmaster %s: %v", cand.Address, err)
+			// fail over right away instead of waiting for measurements to get stale
+			m.update(cand, time.Now(), func() {
+				cand.Err = err
+			})
+		}()
+	}
+	// grandmaster goes stale without any event, so check it regularly
+	eg.Go(func() error {
+		ticker := time.NewTicker(m.cfg.StaleAfter / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+				m.check(time.Now())
+			}
+		}
+	})
+	if len(m.cfg.Exporters) > 0 {
+		// final state is exported even if the client fails
+		defer m.export(time.Now())
+		eg.Go(func() error {
+			ticker := time.NewTicker(m.cfg.ExportInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-ticker.C:
+					m.export(time.Now())
+				}
+			}
+		})
+	}
+	eg.Go(func() error {
+		wg.Wait()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("all grandmasters failed")
+	})
+	return eg.Wait()
+}
+
+// Close connections
+func (m *MultiClient) Close() {
+	if m.eventConn != nil {
+		m.eventConn.Close()
+	}
+	if m.genConn != nil {
+		m.genConn.Close()
+	}
+}
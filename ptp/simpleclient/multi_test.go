@@ -0,0 +1,178 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+)
+
+func announceFrom(gm ptp.ClockIdentity, priority1 uint8) *ptp.Announce {
+	a := announcePkt(0)
+	a.SourcePortIdentity = ptp.PortIdentity{ClockIdentity: gm, PortNumber: 1}
+	a.GrandmasterIdentity = gm
+	a.GrandmasterPriority1 = priority1
+	return a
+}
+
+func TestPolicies(t *testing.T) {
+	a := &Candidate{Address: "a", Priority: 0, Measurement: &MeasurementResult{Delay: 2 * time.Microsecond}}
+	b := &Candidate{Address: "b", Priority: 1, Measurement: &MeasurementResult{Delay: time.Microsecond}}
+
+	require.True(t, PolicyPriority(a, b))
+	require.False(t, PolicyPriority(b, a))
+	require.False(t, PolicyPriority(a, a))
+
+	require.True(t, PolicyDelay(b, a))
+	require.False(t, PolicyDelay(a, b))
+	require.False(t, PolicyDelay(a, a))
+
+	// grandmaster which didn't announce itself yet is worse
+	b.Announce = announceFrom(2, 128)
+	require.True(t, PolicyBMCA(b, a))
+	require.False(t, PolicyBMCA(a, b))
+	a.Announce = announceFrom(1, 128)
+	require.True(t, PolicyBMCA(a, b))
+	b.Announce = announceFrom(2, 127)
+	require.True(t, PolicyBMCA(b, a))
+	require.False(t, PolicyBMCA(a, b))
+}
+
+func TestPolicyByName(t *testing.T) {
+	require.Equal(t, []string{"bmca", "delay", "priority"}, PolicyNames())
+	for _, name := range PolicyNames() {
+		p, err := PolicyByName(name)
+		require.NoError(t, err)
+		require.NotNil(t, p)
+	}
+	_, err := PolicyByName("random")
+	require.Error(t, err)
+}
+
+func TestMultiClientFailover(t *testing.T) {
+	cfg := &MultiConfig{
+		Addresses: []string{"a", "b"},
+		Policy:    PolicyPriority,
+	}
+	reported := []string{}
+	m := NewMulti(cfg, func(address string, _ *MeasurementResult) {
+		reported = append(reported, address)
+	})
+	require.Equal(t, DefaultStaleAfter, cfg.StaleAfter)
+	require.Nil(t, m.Selected())
+
+	// only measurements of selected grandmaster are reported
+	m.clients[1].callback(&MeasurementResult{})
+	require.Equal(t, "b", m.Selected().Address)
+	m.clients[0].callback(&MeasurementResult{})
+	require.Equal(t, "a", m.Selected().Address)
+	m.clients[1].callback(&MeasurementResult{})
+	require.Equal(t, []string{"b", "a"}, reported)
+
+	// announce doesn't produce measurement
+	m.clients[0].announceCallback(announcePkt(1))
+	require.Equal(t, []string{"b", "a"}, reported)
+	require.NotNil(t, m.Selected().Announce)
+
+	// failed grandmaster is replaced right away
+	m.update(m.candidates[0], time.Now(), func() {
+		m.candidates[0].Err = context.Canceled
+	})
+	require.Equal(t, "b", m.Selected().Address)
+
+	// stale one once it runs out of time
+	m.check(time.Now().Add(DefaultStaleAfter + time.Second))
+	require.Nil(t, m.Selected())
+}
+
+func TestMultiClientKeepsSelected(t *testing.T) {
+	cfg := &MultiConfig{
+		Addresses: []string{"a", "b"},
+		Policy:    PolicyDelay,
+	}
+	m := NewMulti(cfg, func(string, *MeasurementResult) {})
+	m.clients[1].callback(&MeasurementResult{Delay: time.Microsecond})
+	m.clients[0].callback(&MeasurementResult{Delay: time.Microsecond})
+	require.Equal(t, "b", m.Selected().Address)
+	m.clients[0].callback(&MeasurementResult{Delay: time.Microsecond - 1})
+	require.Equal(t, "a", m.Selected().Address)
+}
+
+func TestMultiClientDeliver(t *testing.T) {
+	m := NewMulti(&MultiConfig{Addresses: []string{"a", "b"}}, func(string, *MeasurementResult) {})
+	m.clients[0].genAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.1"), Port: ptp.PortGeneral}
+	m.clients[1].genAddr = &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: ptp.PortGeneral}
+	m.share()
+
+	p := &inPacket{}
+	m.deliver(net.ParseIP("::ffff:192.168.0.1"), p)
+	require.Equal(t, p, <-m.clients[0].inChan)
+	m.deliver(net.ParseIP("2001:db8::1"), p)
+	require.Equal(t, p, <-m.clients[1].inChan)
+	m.deliver(net.ParseIP("2001:db8::2"), p)
+	require.Empty(t, m.clients[0].inChan)
+	require.Empty(t, m.clients[1].inChan)
+
+	// client which doesn't read its packets doesn't block delivery to the others
+	for i := 0; i <= cap(m.clients[0].inChan); i++ {
+		m.deliver(net.ParseIP("192.168.0.1"), p)
+	}
+	require.Len(t, m.clients[0].inChan, cap(m.clients[0].inChan))
+	m.deliver(net.ParseIP("2001:db8::1"), p)
+	require.Equal(t, p, <-m.clients[1].inChan)
+
+	// dropped packets are counted per grandmaster
+	require.Equal(t, uint64(1), m.clients[0].Dropped())
+	require.Equal(t, uint64(0), m.clients[1].Dropped())
+	stats := m.Stats(time.Now())
+	require.Equal(t, uint64(1), stats[0].Dropped)
+	require.Equal(t, uint64(0), stats[1].Dropped)
+}
+
+func TestMultiClientTimeout(t *testing.T) {
+	cfg := &MultiConfig{
+		Config: Config{
+			Timeout:  500 * time.Millisecond,
+			Duration: 500 * time.Millisecond,
+		},
+		Addresses: []string{"a", "b"},
+	}
+	m := NewMulti(cfg, func(string, *MeasurementResult) {})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	genConn := NewMockUDPConn(ctrl)
+	// both grandmasters are asked for Announce
+	genConn.EXPECT().WriteTo(gomock.Any(), gomock.Any()).Times(2)
+	m.genConn = genConn
+	m.eventConn = NewMockUDPConnWithTS(ctrl)
+	m.clients[0].genAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.1")}
+	m.clients[1].genAddr = &net.UDPAddr{IP: net.ParseIP("192.168.0.2")}
+	m.share()
+
+	err := m.runInternal(true)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Nil(t, m.Selected())
+}
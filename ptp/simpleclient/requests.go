@@ -24,12 +24,13 @@ import (
 )
 
 // reqUnicast is a helper to build ptp.RequestUnicastTransmission
-func reqUnicast(clockID ptp.ClockIdentity, duration time.Duration, what ptp.MessageType) *ptp.Signaling {
+func reqUnicast(clockID ptp.ClockIdentity, domain uint8, duration time.Duration, what ptp.MessageType) *ptp.Signaling {
 	l := binary.Size(ptp.Header{}) + binary.Size(ptp.PortIdentity{}) + binary.Size(ptp.RequestUnicastTransmissionTLV{})
 	return &ptp.Signaling{
 		Header: ptp.Header{
 			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageSignaling, 0),
 			Version:         ptp.Version,
+			DomainNumber:    domain,
 			SequenceID:      0, // will be populated on sending
 			MessageLength:   uint16(l),
 			FlagField:       ptp.FlagUnicast,
@@ -57,13 +58,23 @@ func reqUnicast(clockID ptp.ClockIdentity, duration time.Duration, what ptp.Mess
 	}
 }
 
+// reqTLV is a helper to build ptp.Signaling carrying a single TLV
+func reqTLV(clockID ptp.ClockIdentity, domain uint8, tlv ptp.TLV) *ptp.Signaling {
+	l := binary.Size(ptp.Header{}) + binary.Size(ptp.PortIdentity{}) + binary.Size(tlv)
+	s := reqUnicast(clockID, domain, 0, ptp.MessageAnnounce)
+	s.MessageLength = uint16(l)
+	s.TLVs = []ptp.TLV{tlv}
+	return s
+}
+
 // reqAckCancelUnicast is a helper to build ptp.AcknowledgeCancelUnicastTransmission
-func reqAckCancelUnicast(clockID ptp.ClockIdentity, what ptp.MessageType) *ptp.Signaling {
+func reqAckCancelUnicast(clockID ptp.ClockIdentity, domain uint8, what ptp.MessageType) *ptp.Signaling {
 	l := binary.Size(ptp.Header{}) + binary.Size(ptp.PortIdentity{}) + binary.Size(ptp.AcknowledgeCancelUnicastTransmissionTLV{})
 	return &ptp.Signaling{
 		Header: ptp.Header{
 			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageSignaling, 0),
 			Version:         ptp.Version,
+			DomainNumber:    domain,
 			SequenceID:      0, // will be populated on sending
 			MessageLength:   uint16(l),
 			FlagField:       ptp.FlagUnicast,
@@ -90,19 +101,8 @@ func reqAckCancelUnicast(clockID ptp.ClockIdentity, what ptp.MessageType) *ptp.S
 }
 
 // reqDelay is a helper to build ptp.SyncDelayReq
-func reqDelay(clockID ptp.ClockIdentity) *ptp.SyncDelayReq {
-	return &ptp.SyncDelayReq{
-		Header: ptp.Header{
-			SdoIDAndMsgType: ptp.NewSdoIDAndMsgType(ptp.MessageDelayReq, 0),
-			Version:         ptp.Version,
-			SequenceID:      0, // will be populated on sending
-			MessageLength:   uint16(binary.Size(ptp.SyncDelayReq{})),
-			FlagField:       ptp.FlagUnicast,
-			SourcePortIdentity: ptp.PortIdentity{
-				PortNumber:    1,
-				ClockIdentity: clockID,
-			},
-			LogMessageInterval: 0x7f,
-		},
-	}
+func reqDelay(clockID ptp.ClockIdentity, domain uint8) *ptp.SyncDelayReq {
+	b := ptp.NewBuilder(ptp.PortIdentity{PortNumber: 1, ClockIdentity: clockID}, domain)
+	b.Unicast = true
+	return b.DelayReq() // sequence will be populated on sending
 }
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// GMStats is the state of a grandmaster polled by MultiClient
+type GMStats struct {
+	Address  string `json:"address"`
+	Selected bool   `json:"selected"`
+	Usable   bool   `json:"usable"`
+	// last measurement
+	Offset        int64     `json:"offset_ns"`
+	MeanPathDelay int64     `json:"mean_path_delay_ns"`
+	Updated       time.Time `json:"updated"`
+	// data set of the last Announce
+	GrandmasterIdentity     string `json:"gm_identity,omitempty"`
+	Priority1               uint8  `json:"priority1"`
+	Priority2               uint8  `json:"priority2"`
+	ClockClass              uint8  `json:"clock_class"`
+	ClockAccuracy           uint8  `json:"clock_accuracy"`
+	OffsetScaledLogVariance uint16 `json:"offset_scaled_log_variance"`
+	StepsRemoved            uint16 `json:"steps_removed"`
+	// alternate timescales of the last Announce
+	AlternateTimeOffsets []AlternateTimeOffset `json:"alternate_time_offsets,omitempty"`
+	// errors
+	Lost            int    `json:"lost"`
+	Unauthenticated uint64 `json:"unauthenticated"`
+	Dropped         uint64 `json:"dropped"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Stats returns the state of all grandmasters, in order of priority
+func (m *MultiClient) Stats(now time.Time) []GMStats {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	stats := make([]GMStats, 0, len(m.candidates))
+	for i, c := range m.candidates {
+		s := GMStats{
+			Address:         c.Address,
+			Selected:        c == m.selected,
+			Usable:          c.usable(now, m.cfg.StaleAfter),
+			Updated:         c.Updated,
+			Lost:            c.Lost,
+			Unauthenticated: m.clients[i].Unauthenticated(),
+			Dropped:         m.clients[i].Dropped(),
+		}
+		if c.Measurement != nil {
+			s.Offset = c.Measurement.Offset.Nanoseconds()
+			s.MeanPathDelay = c.Measurement.Delay.Nanoseconds()
+		}
+		if a := c.Announce; a != nil {
+			s.GrandmasterIdentity = a.GrandmasterIdentity.String()
+			s.Priority1 = a.GrandmasterPriority1
+			s.Priority2 = a.GrandmasterPriority2
+			s.ClockClass = a.GrandmasterClockQuality.ClockClass
+			s.ClockAccuracy = a.GrandmasterClockQuality.ClockAccuracy
+			s.OffsetScaledLogVariance = a.GrandmasterClockQuality.OffsetScaledLogVariance
+			s.StepsRemoved = a.StepsRemoved
+			s.AlternateTimeOffsets = alternateTimeOffsets(a)
+		}
+		if c.Err != nil {
+			s.Error = c.Err.Error()
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// ServeHTTP returns the state of all grandmasters as JSON
+func (m *MultiClient) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	js, err := json.Marshal(m.Stats(time.Now()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(js); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
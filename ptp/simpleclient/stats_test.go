@@ -0,0 +1,89 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simpleclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiClientStats(t *testing.T) {
+	cfg := &MultiConfig{
+		Addresses: []string{"a", "b"},
+		Policy:    PolicyPriority,
+	}
+	m := NewMulti(cfg, func(string, *MeasurementResult) {})
+	a := announcePkt(1)
+	a.GrandmasterIdentity = ptp.ClockIdentity(0xc42a1fffe6d7ca6)
+	a.GrandmasterClockQuality = ptp.ClockQuality{ClockClass: 6, ClockAccuracy: 0x21}
+	a.StepsRemoved = 1
+	a.TLVs = []ptp.TLV{ptp.NewAlternateTimeOffsetIndicatorTLV(2, -5*time.Hour, 0, time.Time{}, "EST")}
+	m.clients[0].announceCallback(a)
+	m.clients[0].callback(&MeasurementResult{Offset: -42, Delay: 1000})
+	m.clients[1].callback(&MeasurementResult{Offset: 10, Delay: 2000})
+
+	stats := m.Stats(time.Now())
+	require.Len(t, stats, 2)
+	require.Equal(t, "a", stats[0].Address)
+	require.True(t, stats[0].Selected)
+	require.True(t, stats[0].Usable)
+	require.Equal(t, int64(-42), stats[0].Offset)
+	require.Equal(t, int64(1000), stats[0].MeanPathDelay)
+	require.Equal(t, "0c42a1.fffe.6d7ca6", stats[0].GrandmasterIdentity)
+	require.Equal(t, uint8(6), stats[0].ClockClass)
+	require.Equal(t, uint8(0x21), stats[0].ClockAccuracy)
+	require.Equal(t, uint16(1), stats[0].StepsRemoved)
+	require.Equal(t, []AlternateTimeOffset{{Key: 2, Name: "EST", Offset: -5 * time.Hour}}, stats[0].AlternateTimeOffsets)
+	require.Empty(t, stats[1].AlternateTimeOffsets)
+	require.False(t, stats[1].Selected)
+	require.Equal(t, "", stats[1].GrandmasterIdentity)
+
+	// failed grandmaster counts as lost
+	m.update(m.candidates[0], time.Now(), func() {
+		m.candidates[0].Err = context.Canceled
+	})
+	stats = m.Stats(time.Now())
+	require.False(t, stats[0].Usable)
+	require.Equal(t, 1, stats[0].Lost)
+	require.Equal(t, context.Canceled.Error(), stats[0].Error)
+	require.True(t, stats[1].Selected)
+	require.Equal(t, 0, stats[1].Lost)
+}
+
+func TestMultiClientServeHTTP(t *testing.T) {
+	m := NewMulti(&MultiConfig{Addresses: []string{"a"}}, func(string, *MeasurementResult) {})
+	m.clients[0].callback(&MeasurementResult{Offset: 5, Delay: 100})
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	stats := []map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.Len(t, stats, 1)
+	require.Equal(t, "a", stats[0]["address"])
+	require.Equal(t, true, stats[0]["selected"])
+	require.Equal(t, 5.0, stats[0]["offset_ns"])
+	require.Equal(t, 100.0, stats[0]["mean_path_delay_ns"])
+}
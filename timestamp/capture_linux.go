@@ -0,0 +1,194 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// from include/uapi/linux/if_ether.h, include/uapi/linux/ip.h and include/uapi/linux/ipv6.h
+const (
+	ipv4MinHeaderSizeBytes = 20
+	ipv6HeaderSizeBytes    = 40
+	udpHeaderSizeBytes     = 8
+)
+
+// captureProgram returns BPF program which only accepts unfragmented UDP over IPv4/IPv6 (without extension headers) to or from the port.
+// Packets are expected to start with the IP header as we capture with SOCK_DGRAM.
+func captureProgram(port int) []bpf.Instruction {
+	p := uint32(port)
+	return []bpf.Instruction{
+		// IP version
+		bpf.LoadAbsolute{Off: 0, Size: 1},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 4, SkipFalse: 9},
+		// IPv4: protocol, fragment offset, then ports after the variable length header
+		bpf.LoadAbsolute{Off: 9, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.IPPROTO_UDP, SkipTrue: 14},
+		bpf.LoadAbsolute{Off: 6, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 12},
+		bpf.LoadMemShift{Off: 0},
+		bpf.LoadIndirect{Off: 0, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: p, SkipTrue: 10},
+		bpf.LoadIndirect{Off: 2, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: p, SkipTrue: 8, SkipFalse: 7},
+		// IPv6: next header, then ports after the fixed header
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 6, SkipTrue: 6},
+		bpf.LoadAbsolute{Off: 6, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: unix.IPPROTO_UDP, SkipTrue: 4},
+		bpf.LoadAbsolute{Off: ipv6HeaderSizeBytes, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: p, SkipTrue: 3},
+		bpf.LoadAbsolute{Off: ipv6HeaderSizeBytes + 2, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: p, SkipTrue: 1},
+		// drop
+		bpf.RetConstant{Val: 0},
+		// accept
+		bpf.RetConstant{Val: 0x40000},
+	}
+}
+
+// captureFilter returns assembled captureProgram
+func captureFilter(port int) ([]unix.SockFilter, error) {
+	raw, err := bpf.Assemble(captureProgram(port))
+	if err != nil {
+		return nil, err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, r := range raw {
+		filter[i] = unix.SockFilter{Code: r.Op, Jt: r.Jt, Jf: r.Jf, K: r.K}
+	}
+	return filter, nil
+}
+
+// Capture is a fallback timestamping backend for the environments where SO_TIMESTAMPING is not available,
+// like some containers and virtual NICs. It sniffs UDP packets to and from the port on the interface via AF_PACKET socket
+// and returns the time they were captured at, for both incoming and outgoing packets.
+// Outgoing packets are captured when they are handed to the driver, so the timestamp is a bit later than one from SO_TIMESTAMPING.
+// It's up to the caller to match captured packets with sent ones, i.e. by PTP sequence ID.
+type Capture struct {
+	connFd int
+}
+
+// NewCapture opens AF_PACKET socket capturing UDP packets to and from the port on the interface
+func NewCapture(iface string, port int) (*Capture, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := captureFilter(port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assemble BPF filter: %w", err)
+	}
+	// no protocol yet, so we don't receive anything before the filter is attached
+	connFd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AF_PACKET socket: %w", err)
+	}
+	c := &Capture{connFd: connFd}
+	prog := &unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(connFd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, prog); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to attach BPF filter: %w", err)
+	}
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to enable capture timestamps: %w", err)
+	}
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ALL),
+		Ifindex:  ifi.Index,
+	}
+	if err := unix.Bind(connFd, sa); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to bind AF_PACKET socket to %s: %w", iface, err)
+	}
+	return c, nil
+}
+
+// captureTimestamp finds SCM_TIMESTAMPNS in socket control message
+func captureTimestamp(b []byte) (time.Time, error) {
+	mlen := 0
+	for i := 0; i+socketControlMessageHeaderOffset <= len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		if int(h.Len) < socketControlMessageHeaderOffset || i+int(h.Len) > len(b) {
+			break
+		}
+		mlen = unix.CmsgSpace(int(h.Len) - socketControlMessageHeaderOffset)
+
+		if h.Level == unix.SOL_SOCKET && h.Type == unix.SCM_TIMESTAMPNS {
+			ts := (*unix.Timespec)(unsafe.Pointer(&b[i+socketControlMessageHeaderOffset]))
+			return time.Unix(ts.Unix()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to find timestamp in socket control message")
+}
+
+// udpPayloadOffset returns the offset of UDP payload in IP packet
+func udpPayloadOffset(packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, fmt.Errorf("empty packet")
+	}
+	offset := 0
+	switch packet[0] >> 4 {
+	case 4:
+		offset = int(packet[0]&0xf) * 4
+		if offset < ipv4MinHeaderSizeBytes {
+			return 0, fmt.Errorf("invalid IPv4 header length %d", offset)
+		}
+	case 6:
+		offset = ipv6HeaderSizeBytes
+	default:
+		return 0, fmt.Errorf("unsupported IP version %d", packet[0]>>4)
+	}
+	offset += udpHeaderSizeBytes
+	if offset > len(packet) {
+		return 0, fmt.Errorf("packet is too short: %d bytes", len(packet))
+	}
+	return offset, nil
+}
+
+// ReadPacketBuf writes UDP payload of the next captured packet into provided buffer buf, and returns number of bytes copied to the buffer,
+// whether the packet was sent by this host and the time it was captured at.
+// buf must fit IP and UDP headers as well. oob buffer can be reused after ReadPacketBuf call.
+func (c *Capture) ReadPacketBuf(buf, oob []byte) (int, bool, time.Time, error) {
+	bbuf, boob, _, saddr, err := unix.Recvmsg(c.connFd, buf, oob, 0)
+	if err != nil {
+		return 0, false, time.Time{}, fmt.Errorf("failed to read packet: %w", err)
+	}
+	outgoing := false
+	if sa, ok := saddr.(*unix.SockaddrLinklayer); ok {
+		outgoing = sa.Pkttype == unix.PACKET_OUTGOING
+	}
+	offset, err := udpPayloadOffset(buf[:bbuf])
+	if err != nil {
+		return 0, outgoing, time.Time{}, err
+	}
+	n := copy(buf, buf[offset:bbuf])
+	timestamp, err := captureTimestamp(oob[:boob])
+	return n, outgoing, timestamp, err
+}
+
+// Close closes the capture socket
+func (c *Capture) Close() error {
+	return unix.Close(c.connFd)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+func udp4Packet(srcPort, dstPort int) []byte {
+	b := make([]byte, ipv4MinHeaderSizeBytes+udpHeaderSizeBytes+3)
+	b[0] = 0x45
+	b[9] = unix.IPPROTO_UDP
+	b[20], b[21] = byte(srcPort>>8), byte(srcPort)
+	b[22], b[23] = byte(dstPort>>8), byte(dstPort)
+	copy(b[28:], []byte{1, 2, 3})
+	return b
+}
+
+func udp6Packet(srcPort, dstPort int) []byte {
+	b := make([]byte, ipv6HeaderSizeBytes+udpHeaderSizeBytes+3)
+	b[0] = 0x60
+	b[6] = unix.IPPROTO_UDP
+	b[40], b[41] = byte(srcPort>>8), byte(srcPort)
+	b[42], b[43] = byte(dstPort>>8), byte(dstPort)
+	copy(b[48:], []byte{1, 2, 3})
+	return b
+}
+
+func TestCaptureProgram(t *testing.T) {
+	vm, err := bpf.NewVM(captureProgram(319))
+	require.Nil(t, err)
+
+	fragmented := udp4Packet(12345, 319)
+	fragmented[7] = 1
+	tcp := udp4Packet(12345, 319)
+	tcp[9] = unix.IPPROTO_TCP
+	tcp6 := udp6Packet(12345, 319)
+	tcp6[6] = unix.IPPROTO_TCP
+
+	tests := []struct {
+		name   string
+		packet []byte
+		accept bool
+	}{
+		{name: "ipv4 to port", packet: udp4Packet(12345, 319), accept: true},
+		{name: "ipv4 from port", packet: udp4Packet(319, 12345), accept: true},
+		{name: "ipv4 other port", packet: udp4Packet(12345, 320), accept: false},
+		{name: "ipv4 fragment", packet: fragmented, accept: false},
+		{name: "ipv4 tcp", packet: tcp, accept: false},
+		{name: "ipv6 to port", packet: udp6Packet(12345, 319), accept: true},
+		{name: "ipv6 from port", packet: udp6Packet(319, 12345), accept: true},
+		{name: "ipv6 other port", packet: udp6Packet(12345, 320), accept: false},
+		{name: "ipv6 tcp", packet: tcp6, accept: false},
+		{name: "not ip", packet: []byte{0x10, 0, 0, 0}, accept: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := vm.Run(tt.packet)
+			require.Nil(t, err)
+			require.Equal(t, tt.accept, n > 0)
+		})
+	}
+}
+
+func Test_udpPayloadOffset(t *testing.T) {
+	offset, err := udpPayloadOffset(udp4Packet(1, 2))
+	require.Nil(t, err)
+	require.Equal(t, 28, offset)
+
+	offset, err = udpPayloadOffset(udp6Packet(1, 2))
+	require.Nil(t, err)
+	require.Equal(t, 48, offset)
+
+	_, err = udpPayloadOffset([]byte{})
+	require.Error(t, err)
+	_, err = udpPayloadOffset([]byte{0x45, 0})
+	require.Error(t, err)
+	_, err = udpPayloadOffset([]byte{0x41, 0})
+	require.Error(t, err)
+}
+
+func TestCapture(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	c, err := NewCapture("lo", port)
+	if err != nil {
+		t.Skipf("can't open AF_PACKET socket: %v", err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+
+	// on loopback we see the packet twice: when it's sent and when it's received
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	directions := []bool{}
+	for i := 0; i < 2; i++ {
+		n, outgoing, ts, err := c.ReadPacketBuf(buf, oob)
+		require.Nil(t, err)
+		require.Equal(t, []byte{1, 2, 3}, buf[:n])
+		require.False(t, ts.Before(start.Truncate(time.Microsecond)))
+		directions = append(directions, outgoing)
+	}
+	require.ElementsMatch(t, []bool{true, false}, directions)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TimestampedConn is a net.PacketConn which also provides kernel RX and TX timestamps of the packets
+type TimestampedConn struct {
+	*net.UDPConn
+	connFd int
+	ts     Timestamp
+}
+
+// NewTimestampedConn enables timestamps of the requested type on the connection and wraps it.
+// Same as EnableTimestamps, it falls back to software timestamps if hardware ones are not available.
+func NewTimestampedConn(conn *net.UDPConn, iface string, ts Timestamp) (*TimestampedConn, error) {
+	connFd, err := ConnFd(conn)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := EnableTimestamps(connFd, iface, ts)
+	if err != nil {
+		return nil, err
+	}
+	return &TimestampedConn{UDPConn: conn, connFd: connFd, ts: enabled}, nil
+}
+
+// AttachTimestamping returns a function to be used as net.ListenConfig.Control, which enables timestamps of the requested type on the socket before it's bound.
+// Unlike EnableTimestamps it doesn't fall back to software timestamps, creating the listener fails instead.
+func AttachTimestamping(iface string, ts Timestamp) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var enabled Timestamp
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			enabled, err = EnableTimestamps(int(fd), iface, ts)
+		}); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return err
+		}
+		if enabled != ts {
+			return fmt.Errorf("failed to enable %s timestamps, only %s are available", ts, enabled)
+		}
+		return nil
+	}
+}
+
+// TimestampType returns type of timestamps enabled on the connection
+func (c *TimestampedConn) TimestampType() Timestamp {
+	return c.ts
+}
+
+// ReadFromWithTimestamp reads a packet into b and returns number of bytes read, source address and RX timestamp
+func (c *TimestampedConn) ReadFromWithTimestamp(b []byte) (int, net.Addr, time.Time, error) {
+	oob := make([]byte, ControlSizeBytes)
+	n, oobn, _, addr, err := c.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, nil, time.Time{}, fmt.Errorf("failed to read packet: %w", err)
+	}
+	timestamp, err := socketControlMessageTimestamp(oob[:oobn])
+	return n, addr, timestamp, err
+}
+
+// WriteToWithTimestamp writes a packet to addr and returns number of bytes written and TX timestamp
+func (c *TimestampedConn) WriteToWithTimestamp(b []byte, addr net.Addr) (int, time.Time, error) {
+	if c.ts != SW && c.ts != HW {
+		return 0, time.Time{}, fmt.Errorf("TX timestamps are not enabled, timestamp type is %s", c.ts)
+	}
+	var n int
+	err := retryAfterDrain(c.connFd, func() (err error) {
+		n, err = c.WriteTo(b, addr)
+		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	timestamp, _, err := ReadTXtimestamp(c.connFd)
+	return n, timestamp, err
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampedConn(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	tc, err := NewTimestampedConn(conn, "lo", SW)
+	require.Nil(t, err)
+	require.Equal(t, SW, tc.TimestampType())
+	waitForRXTimestamps(t, conn, tc.connFd)
+
+	var pc net.PacketConn = tc
+	require.Equal(t, conn.LocalAddr(), pc.LocalAddr())
+
+	start := time.Now()
+	n, txts, err := tc.WriteToWithTimestamp([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+	require.False(t, txts.Before(start.Truncate(time.Microsecond)))
+
+	buf := make([]byte, PayloadSizeBytes)
+	n, addr, rxts, err := tc.ReadFromWithTimestamp(buf)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.Equal(t, conn.LocalAddr().String(), addr.String())
+	require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+}
+
+func TestTimestampedConnRXOnly(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	tc, err := NewTimestampedConn(conn, "lo", SWRX)
+	require.Nil(t, err)
+
+	_, _, err = tc.WriteToWithTimestamp([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Error(t, err)
+}
+
+func TestAttachTimestamping(t *testing.T) {
+	lc := net.ListenConfig{Control: AttachTimestamping("lo", SWRX)}
+	pc, err := lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer pc.Close()
+	conn := pc.(*net.UDPConn)
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	waitForRXTimestamps(t, conn, connFd)
+
+	start := time.Now()
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	_, oobn, _, _, err := conn.ReadMsgUDP(buf, oob)
+	require.Nil(t, err)
+	ts, err := ParseTimestamps(oob[:oobn])
+	require.Nil(t, err)
+	require.False(t, ts.Software.Before(start.Truncate(time.Microsecond)))
+
+	// no hardware timestamps on loopback and we don't fall back
+	lc = net.ListenConfig{Control: AttachTimestamping("lo", HW)}
+	_, err = lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	require.Error(t, err)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+// Here we have helpers for PTP over IEEE 802.3 (layer 2) transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// PTPEtherType is the EtherType of PTP over IEEE 802.3
+const PTPEtherType = 0x88F7
+
+// EthernetHeaderSizeBytes is the size of ethernet frame header preceding the PTP message in frames read from L2 socket
+const EthernetHeaderSizeBytes = 14
+
+// htons converts short integer to network byte order
+func htons(i uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, i)
+	return nativeEndian.Uint16(b)
+}
+
+// OpenL2Socket opens AF_PACKET socket bound to the interface which receives frames of PTP EtherType only.
+// Frames read from the socket include ethernet header.
+// Timestamps are enabled on it the same way as on UDP sockets, for example with EnableTimestamps.
+func OpenL2Socket(iface string) (int, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return -1, err
+	}
+	connFd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(PTPEtherType)))
+	if err != nil {
+		return -1, fmt.Errorf("failed to create AF_PACKET socket: %w", err)
+	}
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(PTPEtherType),
+		Ifindex:  ifi.Index,
+	}
+	if err := unix.Bind(connFd, sa); err != nil {
+		unix.Close(connFd)
+		return -1, fmt.Errorf("failed to bind AF_PACKET socket to %s: %w", iface, err)
+	}
+	return connFd, nil
+}
+
+// JoinL2Multicast subscribes L2 socket to the multicast MAC address on the interface, i.e. protocol.MulticastMAC
+func JoinL2Multicast(connFd int, iface string, mac net.HardwareAddr) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	mreq := &unix.PacketMreq{
+		Ifindex: int32(ifi.Index),
+		Type:    unix.PACKET_MR_MULTICAST,
+		Alen:    uint16(len(mac)),
+	}
+	copy(mreq.Address[:], mac)
+	if err := unix.SetsockoptPacketMreq(connFd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq); err != nil {
+		return fmt.Errorf("failed to join multicast group %s on %s: %w", mac, iface, err)
+	}
+	return nil
+}
+
+// ReadL2FrameWithRXTimestampBuf writes ethernet frame into provided buffer buf, and returns number of bytes copied to the buffer, source MAC address and RX timestamp.
+// oob buffer can be reused after ReadL2FrameWithRXTimestampBuf call.
+func ReadL2FrameWithRXTimestampBuf(connFd int, buf, oob []byte) (int, net.HardwareAddr, time.Time, error) {
+	var rsa unix.RawSockaddrAny
+	bbuf, timestamp, err := ReadPacketWithRXTimestampBufAddr(connFd, buf, oob, &rsa)
+	if rsa.Addr.Family == unix.AF_UNSPEC {
+		return 0, nil, time.Time{}, err
+	}
+	if rsa.Addr.Family != unix.AF_PACKET {
+		return 0, nil, time.Time{}, fmt.Errorf("unexpected address family %d", rsa.Addr.Family)
+	}
+	sa := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&rsa))
+	hwaddr := make(net.HardwareAddr, sa.Halen)
+	copy(hwaddr, sa.Addr[:])
+	return bbuf, hwaddr, timestamp, err
+}
+
+// SendL2Frame sends ethernet frame b (including ethernet header) to dst MAC address via L2 socket on the interface with index ifindex.
+// TX timestamp of the frame can be read afterwards with ReadTXtimestampBuf the same way as for UDP sockets.
+func SendL2Frame(connFd int, b []byte, ifindex int, dst net.HardwareAddr) error {
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(PTPEtherType),
+		Ifindex:  ifindex,
+		Halen:    uint8(len(dst)),
+	}
+	copy(sa.Addr[:], dst)
+	return unix.Sendto(connFd, b, 0, sa)
+}
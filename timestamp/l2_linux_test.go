@@ -0,0 +1,87 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	ptp "github.com/facebook/time/ptp/protocol"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestHtons(t *testing.T) {
+	// in memory it must be in network byte order regardless of the host
+	n := htons(PTPEtherType)
+	require.Equal(t, []byte{0x88, 0xF7}, (*[2]byte)(unsafe.Pointer(&n))[:])
+}
+
+func TestReadL2FrameWithRXTimestamp(t *testing.T) {
+	connFd, err := OpenL2Socket("lo")
+	if err != nil {
+		t.Skipf("can't open AF_PACKET socket: %v", err)
+	}
+	defer unix.Close(connFd)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+
+	ifi, err := net.InterfaceByName("lo")
+	require.Nil(t, err)
+	frame := make([]byte, EthernetHeaderSizeBytes+3)
+	copy(frame[0:6], ptp.MulticastMAC)
+	frame[12] = PTPEtherType >> 8
+	frame[13] = PTPEtherType & 0xff
+	copy(frame[EthernetHeaderSizeBytes:], []byte{1, 2, 3})
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	// linux enables timestamping asynchronously, first frames may come without timestamps
+	for i := 0; i < 100; i++ {
+		start := time.Now()
+		err = SendL2Frame(connFd, frame, ifi.Index, ptp.MulticastMAC)
+		require.Nil(t, err)
+
+		n, mac, rxts, err := ReadL2FrameWithRXTimestampBuf(connFd, buf, oob)
+		if err != nil && mac != nil {
+			continue
+		}
+		require.Nil(t, err)
+		require.Equal(t, frame, buf[:n])
+		require.Len(t, mac, 6)
+		require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+		return
+	}
+	require.FailNow(t, "no RX timestamps received")
+}
+
+func TestJoinL2Multicast(t *testing.T) {
+	connFd, err := OpenL2Socket("lo")
+	if err != nil {
+		t.Skipf("can't open AF_PACKET socket: %v", err)
+	}
+	defer unix.Close(connFd)
+
+	err = JoinL2Multicast(connFd, "lo", ptp.MulticastMAC)
+	require.Nil(t, err)
+
+	err = JoinL2Multicast(connFd, "nosuchiface", ptp.MulticastMAC)
+	require.Error(t, err)
+}
@@ -0,0 +1,207 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+// Here we have TX timestamp diagnostics: which stage of TX path the timestamp was taken at and SOF_TIMESTAMPING_OPT_STATS
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// from include/uapi/linux/tcp.h
+const (
+	tcpNLABusy          = 1
+	tcpNLARwndLimited   = 2
+	tcpNLASndbufLimited = 3
+	tcpNLADataSegsOut   = 4
+	tcpNLATotalRetrans  = 5
+	tcpNLASndqSize      = 13
+	tcpNLABytesSent     = 18
+)
+
+// size of struct nlattr from include/uapi/linux/netlink.h
+const nlattrSize = 4
+
+// TXStage is a stage of the TX path a timestamp was taken at
+type TXStage uint32
+
+// TX stages as per SCM_TSTAMP_* from include/uapi/linux/errqueue.h
+const (
+	// TXStageSnd is when packet was passed to the driver (software timestamp) or sent by the NIC (hardware timestamp)
+	TXStageSnd TXStage = unix.SCM_TSTAMP_SND
+	// TXStageSched is when packet entered the packet scheduler (qdisc)
+	TXStageSched TXStage = unix.SCM_TSTAMP_SCHED
+	// TXStageAck is when all data in the packet was acknowledged by the peer, TCP only
+	TXStageAck TXStage = unix.SCM_TSTAMP_ACK
+)
+
+// TXStageToString is a map from TXStage to string
+var TXStageToString = map[TXStage]string{
+	TXStageSnd:   "snd",
+	TXStageSched: "sched",
+	TXStageAck:   "ack",
+}
+
+func (s TXStage) String() string {
+	v, found := TXStageToString[s]
+	if !found {
+		return "unknown"
+	}
+	return v
+}
+
+// OptStats are socket statistics kernel attaches to TX timestamps when SOF_TIMESTAMPING_OPT_STATS is enabled.
+// Kernel only provides them for TCP sockets.
+type OptStats struct {
+	// Busy is time the socket was busy sending data
+	Busy time.Duration
+	// RwndLimited is time sending was limited by the receive window
+	RwndLimited time.Duration
+	// SndbufLimited is time sending was limited by the send buffer
+	SndbufLimited time.Duration
+	// DataSegsOut is a number of data segments sent
+	DataSegsOut uint64
+	// TotalRetrans is a number of retransmitted segments
+	TotalRetrans uint64
+	// SndqSize is a number of bytes in the send queue
+	SndqSize uint32
+	// BytesSent is a number of bytes sent, including retransmissions
+	BytesSent uint64
+	// Attrs are all raw TCP_NLA_* attributes keyed by type, including ones not decoded above
+	Attrs map[uint16][]byte
+}
+
+// TXTimestampInfo is a TX timestamp together with diagnostics of where the time was spent
+type TXTimestampInfo struct {
+	Timestamp time.Time
+	Stage     TXStage
+	// Stats is nil unless SOF_TIMESTAMPING_OPT_STATS is enabled on a TCP socket
+	Stats *OptStats
+}
+
+// EnableTXTimestampsOptStats adds SOF_TIMESTAMPING_OPT_STATS to the timestamping flags already enabled on the socket.
+// It only has effect on TCP sockets.
+func EnableTXTimestampsOptStats(connFd int) error {
+	return addTXTimestampingFlags(connFd, unix.SOF_TIMESTAMPING_OPT_STATS|unix.SOF_TIMESTAMPING_OPT_TSONLY)
+}
+
+// EnableTXTimestampsSched adds SOF_TIMESTAMPING_TX_SCHED to the timestamping flags already enabled on the socket,
+// so there is an extra software TX timestamp taken when the packet enters the qdisc.
+// Comparing it with TXStageSnd one tells how much time the packet spent in the qdisc.
+// As every packet now has more than one TX timestamp, they have to be read with ReadTXTimestampInfo rather than ReadTXtimestamp.
+func EnableTXTimestampsSched(connFd int) error {
+	return addTXTimestampingFlags(connFd, unix.SOF_TIMESTAMPING_TX_SCHED)
+}
+
+// parseOptStats decodes netlink attributes of SCM_TIMESTAMPING_OPT_STATS message
+func parseOptStats(data []byte) (*OptStats, error) {
+	stats := &OptStats{Attrs: map[uint16][]byte{}}
+	for i := 0; i+nlattrSize <= len(data); {
+		alen := int(nativeEndian.Uint16(data[i:]))
+		atype := nativeEndian.Uint16(data[i+2:])
+		if alen < nlattrSize || i+alen > len(data) {
+			return nil, fmt.Errorf("malformed attribute at offset %d", i)
+		}
+		v := data[i+nlattrSize : i+alen]
+		stats.Attrs[atype] = v
+		switch {
+		case atype == tcpNLABusy && len(v) >= 8:
+			stats.Busy = time.Duration(nativeEndian.Uint64(v)) * time.Microsecond
+		case atype == tcpNLARwndLimited && len(v) >= 8:
+			stats.RwndLimited = time.Duration(nativeEndian.Uint64(v)) * time.Microsecond
+		case atype == tcpNLASndbufLimited && len(v) >= 8:
+			stats.SndbufLimited = time.Duration(nativeEndian.Uint64(v)) * time.Microsecond
+		case atype == tcpNLADataSegsOut && len(v) >= 8:
+			stats.DataSegsOut = nativeEndian.Uint64(v)
+		case atype == tcpNLATotalRetrans && len(v) >= 8:
+			stats.TotalRetrans = nativeEndian.Uint64(v)
+		case atype == tcpNLASndqSize && len(v) >= 4:
+			stats.SndqSize = nativeEndian.Uint32(v)
+		case atype == tcpNLABytesSent && len(v) >= 8:
+			stats.BytesSent = nativeEndian.Uint64(v)
+		}
+		// attributes are 4 bytes aligned
+		i += (alen + nlattrSize - 1) &^ (nlattrSize - 1)
+	}
+	return stats, nil
+}
+
+// ParseTXTimestampInfo parses TX timestamp, its stage and OPT_STATS from a socket control message read from the error queue
+func ParseTXTimestampInfo(b []byte) (*TXTimestampInfo, error) {
+	info := &TXTimestampInfo{}
+	found := false
+	mlen := 0
+	for i := 0; i+socketControlMessageHeaderOffset <= len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		if int(h.Len) < socketControlMessageHeaderOffset || i+int(h.Len) > len(b) {
+			break
+		}
+		// IP_RECVERR and OPT_STATS message lengths are not aligned, next message starts at the aligned offset
+		mlen = unix.CmsgSpace(int(h.Len) - socketControlMessageHeaderOffset)
+		data := b[i+socketControlMessageHeaderOffset : i+int(h.Len)]
+
+		switch {
+		case h.Level == unix.SOL_SOCKET && (int(h.Type) == unix.SO_TIMESTAMPING_NEW || int(h.Type) == unix.SO_TIMESTAMPING):
+			ts, err := scmDataToTime(data)
+			if err != nil {
+				return nil, err
+			}
+			info.Timestamp = ts
+			found = true
+		case h.Level == unix.SOL_SOCKET && h.Type == unix.SCM_TIMESTAMPING_OPT_STATS:
+			stats, err := parseOptStats(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse OPT_STATS: %w", err)
+			}
+			info.Stats = stats
+		case (h.Level == unix.SOL_IP && h.Type == unix.IP_RECVERR) || (h.Level == unix.SOL_IPV6 && h.Type == unix.IPV6_RECVERR):
+			if len(data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+				break
+			}
+			serr := (*unix.SockExtendedErr)(unsafe.Pointer(&data[0]))
+			if serr.Origin == unix.SO_EE_ORIGIN_TIMESTAMPING {
+				info.Stage = TXStage(serr.Info)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("failed to find timestamp in socket control message")
+	}
+	return info, nil
+}
+
+// ReadTXTimestampInfo returns the oldest TX timestamp in the socket error queue together with its diagnostics.
+// Like ReadTXtimestampWithIDBuf it doesn't drain the queue. OPT_STATS make socket control messages bigger,
+// so oob should be larger than ControlSizeBytes, for example 1024 bytes.
+func ReadTXTimestampInfo(connFd int, oob []byte) (*TXTimestampInfo, error) {
+	for attempts := 1; attempts <= maxTXTS; attempts++ {
+		if ready, err := waitForHWTS(connFd, DefaultTXTimestampConfig.RetryInterval); err == nil && !ready {
+			continue
+		}
+		boob, err := recvoob(connFd, oob)
+		if err != nil {
+			continue
+		}
+		return ParseTXTimestampInfo(oob[:boob])
+	}
+	getStats().IncTXTSMissing()
+	return nil, fmt.Errorf("no TX timestamp found after %d tries", maxTXTS)
+}
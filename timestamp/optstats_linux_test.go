@@ -0,0 +1,106 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptStats(t *testing.T) {
+	data := make([]byte, 0, 64)
+	attr := func(atype uint16, v []byte) {
+		h := make([]byte, nlattrSize)
+		nativeEndian.PutUint16(h[0:], uint16(nlattrSize+len(v)))
+		nativeEndian.PutUint16(h[2:], atype)
+		data = append(data, h...)
+		data = append(data, v...)
+		for len(data)%nlattrSize != 0 {
+			data = append(data, 0)
+		}
+	}
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		nativeEndian.PutUint64(b, v)
+		return b
+	}
+	u32 := func(v uint32) []byte {
+		b := make([]byte, 4)
+		nativeEndian.PutUint32(b, v)
+		return b
+	}
+	attr(tcpNLABusy, u64(42))
+	attr(tcpNLADataSegsOut, u64(3))
+	attr(tcpNLASndqSize, u32(100))
+	// unaligned unknown attribute
+	attr(99, []byte{1, 2, 3})
+	attr(tcpNLABytesSent, u64(1500))
+
+	stats, err := parseOptStats(data)
+	require.Nil(t, err)
+	require.Equal(t, 42*time.Microsecond, stats.Busy)
+	require.Equal(t, uint64(3), stats.DataSegsOut)
+	require.Equal(t, uint32(100), stats.SndqSize)
+	require.Equal(t, uint64(1500), stats.BytesSent)
+	require.Equal(t, []byte{1, 2, 3}, stats.Attrs[99])
+
+	_, err = parseOptStats([]byte{42, 0, 1, 0})
+	require.Error(t, err)
+}
+
+func TestTXStageString(t *testing.T) {
+	require.Equal(t, "sched", TXStageSched.String())
+	require.Equal(t, "snd", TXStageSnd.String())
+	require.Equal(t, "unknown", TXStage(42).String())
+}
+
+func TestReadTXTimestampInfo(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := SyscallConnFd(conn)
+	require.Nil(t, err)
+	require.Error(t, EnableTXTimestampsOptStats(connFd))
+	require.Nil(t, EnableSWTimestampsTx(connFd))
+	require.Nil(t, EnableTXTimestampsOptStats(connFd))
+	require.Nil(t, EnableTXTimestampsSched(connFd))
+
+	start := time.Now()
+	_, err = conn.Write([]byte{1, 2, 3})
+	require.Nil(t, err)
+
+	oob := make([]byte, 1024)
+	stages := map[TXStage]*TXTimestampInfo{}
+	for i := 0; i < 2; i++ {
+		info, err := ReadTXTimestampInfo(connFd, oob)
+		require.Nil(t, err)
+		require.False(t, info.Timestamp.Before(start.Truncate(time.Microsecond)))
+		require.NotNil(t, info.Stats)
+		stages[info.Stage] = info
+	}
+	require.Contains(t, stages, TXStageSched)
+	require.Contains(t, stages, TXStageSnd)
+	require.False(t, stages[TXStageSnd].Timestamp.Before(stages[TXStageSched].Timestamp))
+	require.Equal(t, uint64(1), stages[TXStageSnd].Stats.DataSegsOut)
+}
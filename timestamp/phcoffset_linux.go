@@ -0,0 +1,115 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+// Here we have conversion of hardware timestamps to system time
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/facebook/time/phc"
+)
+
+// PHCOffset keeps a recent measurement of the offset between PHC of an interface and CLOCK_REALTIME,
+// and uses it to convert hardware timestamps to system time.
+// It's safe for concurrent use.
+type PHCOffset struct {
+	device string
+	maxAge time.Duration
+
+	sync.Mutex
+	sysoff   phc.SysoffResult
+	measured time.Time
+}
+
+// NewPHCOffset returns PHCOffset for the PHC of the interface, measurements older than maxAge are refreshed on use.
+// It takes the first measurement right away.
+func NewPHCOffset(iface string, maxAge time.Duration) (*PHCOffset, error) {
+	info, err := phc.IfaceInfo(iface)
+	if err != nil {
+		return nil, fmt.Errorf("getting interface info: %w", err)
+	}
+	if !info.HasPHC() {
+		return nil, fmt.Errorf("%s doesn't support PHC", iface)
+	}
+	p := &PHCOffset{
+		device: fmt.Sprintf("/dev/ptp%d", info.PHCIndex),
+		maxAge: maxAge,
+	}
+	if err := p.Update(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Update takes a new PHC to system clock offset measurement
+func (p *PHCOffset) Update() error {
+	sysoff, err := phc.TimeAndOffsetFromDevice(p.device, phc.MethodIoctlSysOffsetExtended)
+	if err != nil {
+		// not all drivers support PTP_SYS_OFFSET_EXTENDED
+		sysoff, err = phc.TimeAndOffsetFromDevice(p.device, phc.MethodSyscallClockGettime)
+		if err != nil {
+			return fmt.Errorf("failed to measure PHC offset of %s: %w", p.device, err)
+		}
+	}
+	p.Lock()
+	p.sysoff = sysoff
+	p.measured = time.Now()
+	p.Unlock()
+	return nil
+}
+
+// Offset returns the latest offset of system clock from PHC, refreshing it if it's too old
+func (p *PHCOffset) Offset() (time.Duration, error) {
+	p.Lock()
+	stale := time.Since(p.measured) > p.maxAge
+	p.Unlock()
+	if stale {
+		if err := p.Update(); err != nil {
+			return 0, err
+		}
+	}
+	p.Lock()
+	defer p.Unlock()
+	return p.sysoff.Offset, nil
+}
+
+// ToSystem converts hardware timestamp to system time
+func (p *PHCOffset) ToSystem(hw time.Time) (time.Time, error) {
+	offset, err := p.Offset()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return hw.Add(offset), nil
+}
+
+// ParseTimestamps is like ParseTimestamps, but also fills HardwareSystem if hardware timestamp is present
+func (p *PHCOffset) ParseTimestamps(oob []byte) (*Timestamps, error) {
+	ts, err := ParseTimestamps(oob)
+	if err != nil {
+		return nil, err
+	}
+	if ts.Hardware.UnixNano() == 0 {
+		return ts, nil
+	}
+	if ts.HardwareSystem, err = p.ToSystem(ts.Hardware); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
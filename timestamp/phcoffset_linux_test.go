@@ -0,0 +1,65 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/facebook/time/phc"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestNewPHCOffsetNoPHC(t *testing.T) {
+	_, err := NewPHCOffset("lo", time.Second)
+	require.Error(t, err)
+}
+
+func TestPHCOffsetParseTimestamps(t *testing.T) {
+	p := &PHCOffset{
+		maxAge:   time.Hour,
+		sysoff:   phc.SysoffResult{Offset: -37 * time.Second},
+		measured: time.Now(),
+	}
+	hw := time.Unix(1612028772, 717200426)
+	sw := time.Unix(1612028735, 717200436)
+
+	oob := make([]byte, unix.CmsgSpace(48))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = int32(timestamping)
+	h.SetLen(unix.CmsgLen(48))
+	data := oob[unix.CmsgLen(0):]
+	nativeEndian.PutUint64(data[0:], uint64(sw.Unix()))
+	nativeEndian.PutUint64(data[8:], uint64(sw.Nanosecond()))
+	nativeEndian.PutUint64(data[32:], uint64(hw.Unix()))
+	nativeEndian.PutUint64(data[40:], uint64(hw.Nanosecond()))
+
+	ts, err := p.ParseTimestamps(oob)
+	require.Nil(t, err)
+	require.Equal(t, hw.UnixNano(), ts.Hardware.UnixNano())
+	require.Equal(t, hw.Add(-37*time.Second).UnixNano(), ts.HardwareSystem.UnixNano())
+
+	// software only
+	copy(data[32:], make([]byte, 16))
+	ts, err = p.ParseTimestamps(oob)
+	require.Nil(t, err)
+	require.True(t, ts.HardwareSystem.IsZero())
+	require.Equal(t, SW, ts.Selected)
+}
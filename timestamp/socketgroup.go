@@ -0,0 +1,114 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// SocketGroup is a group of UDP sockets bound to the same address with SO_REUSEPORT and identical timestamping options.
+// On linux kernel spreads incoming packets between the sockets, so each of them can be served by its own worker.
+type SocketGroup struct {
+	// Fds are file descriptors of the sockets
+	Fds []int
+	// Timestamp is the type of timestamps enabled on all the sockets
+	Timestamp Timestamp
+	// Port is the port all the sockets are bound to
+	Port int
+}
+
+// NewSocketGroup creates n UDP sockets bound to the ip and port with SO_REUSEPORT and enables timestamps on each of them.
+// If port is 0, the sockets share the ephemeral port picked for the first one.
+// Same as EnableTimestamps, it falls back to software timestamps if hardware ones are not available, Timestamp of the group reports the result.
+// Sockets are blocking. On error all the sockets created so far are closed.
+func NewSocketGroup(ip net.IP, port int, n int, iface string, ts Timestamp) (*SocketGroup, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("socket group needs at least one socket, got %d", n)
+	}
+	// socket domain differs depending whether we are listening on ipv4 or ipv6
+	domain := unix.AF_INET6
+	if ip.To4() != nil {
+		domain = unix.AF_INET
+	}
+	g := &SocketGroup{Port: port, Timestamp: ts}
+	for i := 0; i < n; i++ {
+		connFd, err := g.listen(domain, ip, iface)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("socket #%d: %w", i, err)
+		}
+		g.Fds = append(g.Fds, connFd)
+	}
+	return g, nil
+}
+
+// listen creates a single socket of the group
+func (g *SocketGroup) listen(domain int, ip net.IP, iface string) (int, error) {
+	connFd, err := unix.Socket(domain, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create socket: %w", err)
+	}
+	// needs to be set before we bind to a port
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+		unix.Close(connFd)
+		return -1, fmt.Errorf("failed to set SO_REUSEPORT: %w", err)
+	}
+	if err := unix.Bind(connFd, IPToSockaddr(ip, g.Port)); err != nil {
+		unix.Close(connFd)
+		return -1, fmt.Errorf("failed to bind: %w", err)
+	}
+	if g.Port == 0 {
+		sa, err := unix.Getsockname(connFd)
+		if err != nil {
+			unix.Close(connFd)
+			return -1, fmt.Errorf("failed to get local address: %w", err)
+		}
+		switch v := sa.(type) {
+		case *unix.SockaddrInet4:
+			g.Port = v.Port
+		case *unix.SockaddrInet6:
+			g.Port = v.Port
+		}
+	}
+	// first socket decides which timestamps the whole group gets
+	enabled, err := EnableTimestamps(connFd, iface, g.Timestamp)
+	if err != nil {
+		unix.Close(connFd)
+		return -1, err
+	}
+	if len(g.Fds) > 0 && enabled != g.Timestamp {
+		unix.Close(connFd)
+		return -1, fmt.Errorf("got %s timestamps while the rest of the group has %s", enabled, g.Timestamp)
+	}
+	g.Timestamp = enabled
+	return connFd, nil
+}
+
+// Close closes all the sockets of the group
+func (g *SocketGroup) Close() error {
+	var err error
+	for _, connFd := range g.Fds {
+		if cerr := unix.Close(connFd); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	g.Fds = nil
+	return err
+}
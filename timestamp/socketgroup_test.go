@@ -0,0 +1,80 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestNewSocketGroup(t *testing.T) {
+	g, err := NewSocketGroup(net.ParseIP("127.0.0.1"), 0, 4, "lo", SWRX)
+	require.Nil(t, err)
+	defer g.Close()
+
+	require.Equal(t, 4, len(g.Fds))
+	require.Equal(t, SWRX, g.Timestamp)
+	require.NotEqual(t, 0, g.Port)
+	for _, connFd := range g.Fds {
+		sa, err := unix.Getsockname(connFd)
+		require.Nil(t, err)
+		require.Equal(t, g.Port, sa.(*unix.SockaddrInet4).Port)
+	}
+
+	client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: g.Port})
+	require.Nil(t, err)
+	defer client.Close()
+	start := time.Now()
+	_, err = client.Write([]byte{1, 2, 3})
+	require.Nil(t, err)
+
+	// the packet lands on one of the sockets, which one is up to the kernel
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	fds := make([]unix.PollFd, len(g.Fds))
+	for i, connFd := range g.Fds {
+		fds[i] = unix.PollFd{Fd: int32(connFd), Events: unix.POLLIN}
+	}
+	n, err := unix.Poll(fds, 1000)
+	require.Nil(t, err)
+	require.Equal(t, 1, n)
+	for _, fd := range fds {
+		if fd.Revents&unix.POLLIN == 0 {
+			continue
+		}
+		// timestamps might not be enabled yet for the very first packet
+		bbuf, _, rxts, err := ReadPacketWithRXTimestampBuf(int(fd.Fd), buf, oob)
+		require.Equal(t, []byte{1, 2, 3}, buf[:bbuf])
+		if err == nil {
+			require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+		}
+	}
+
+	require.Nil(t, g.Close())
+	require.Nil(t, g.Fds)
+}
+
+func TestNewSocketGroupError(t *testing.T) {
+	_, err := NewSocketGroup(net.ParseIP("127.0.0.1"), 0, 0, "lo", SW)
+	require.Error(t, err)
+	_, err = NewSocketGroup(net.ParseIP("127.0.0.1"), 0, 1, "lo", Timestamp(42))
+	require.Error(t, err)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"sync/atomic"
+)
+
+// Stats is an interface timestamping failures are reported to.
+// Implementations must be safe for concurrent use.
+type Stats interface {
+	// IncTXTSMissing is called when no TX timestamp was found for a sent packet
+	IncTXTSMissing()
+
+	// IncTSZero is called when kernel returned a zero timestamp
+	IncTSZero()
+
+	// IncTXTSDrained is called for every stale TX timestamp drained from the error queue
+	IncTXTSDrained()
+
+	// IncSWFallback is called when hardware timestamps can't be enabled and software ones are used instead
+	IncSWFallback()
+}
+
+// noopStats discards all the events
+type noopStats struct{}
+
+func (noopStats) IncTXTSMissing() {}
+func (noopStats) IncTSZero()      {}
+func (noopStats) IncTXTSDrained() {}
+func (noopStats) IncSWFallback()  {}
+
+// statsHolder allows to store any Stats implementation in atomic.Value
+type statsHolder struct {
+	Stats
+}
+
+var stats atomic.Value
+
+func init() {
+	stats.Store(statsHolder{noopStats{}})
+}
+
+// SetStats sets Stats timestamping failures are reported to. Passing nil disables reporting.
+func SetStats(s Stats) {
+	if s == nil {
+		s = noopStats{}
+	}
+	stats.Store(statsHolder{s})
+}
+
+// getStats returns currently used Stats
+func getStats() Stats {
+	return stats.Load().(statsHolder).Stats
+}
+
+// Counters is a simple Stats implementation which counts the events
+type Counters struct {
+	TXTSMissing int64
+	TSZero      int64
+	TXTSDrained int64
+	SWFallback  int64
+}
+
+// IncTXTSMissing atomically add 1 to the counter
+func (c *Counters) IncTXTSMissing() {
+	atomic.AddInt64(&c.TXTSMissing, 1)
+}
+
+// IncTSZero atomically add 1 to the counter
+func (c *Counters) IncTSZero() {
+	atomic.AddInt64(&c.TSZero, 1)
+}
+
+// IncTXTSDrained atomically add 1 to the counter
+func (c *Counters) IncTXTSDrained() {
+	atomic.AddInt64(&c.TXTSDrained, 1)
+}
+
+// IncSWFallback atomically add 1 to the counter
+func (c *Counters) IncSWFallback() {
+	atomic.AddInt64(&c.SWFallback, 1)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounters(t *testing.T) {
+	c := &Counters{}
+	c.IncTXTSMissing()
+	c.IncTSZero()
+	c.IncTSZero()
+	c.IncTXTSDrained()
+	c.IncSWFallback()
+	require.Equal(t, Counters{TXTSMissing: 1, TSZero: 2, TXTSDrained: 1, SWFallback: 1}, *c)
+}
+
+func TestSetStats(t *testing.T) {
+	require.Equal(t, noopStats{}, getStats())
+
+	c := &Counters{}
+	SetStats(c)
+	defer SetStats(nil)
+	require.Equal(t, c, getStats())
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	// loopback has no hardware timestamping
+	ts, err := EnableTimestamps(connFd, "lo", HW)
+	require.Nil(t, err)
+	require.Equal(t, SW, ts)
+	require.Equal(t, int64(1), c.SWFallback)
+
+	SetStats(nil)
+	require.Equal(t, noopStats{}, getStats())
+}
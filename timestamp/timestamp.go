@@ -19,25 +19,17 @@ package timestamp
 // Here we have basic HW and SW timestamping support
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"syscall"
 	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
-// from include/uapi/linux/net_tstamp.h
-const (
-	// HWTSTAMP_TX_ON int 1
-	hwtstampTXON int32 = 0x00000001
-	// HWTSTAMP_FILTER_ALL int 1
-	hwtstampFilterAll int32 = 0x00000001
-	// HWTSTAMP_FILTER_PTP_V2_EVENT int 12
-	hwtstampFilterPTPv2Event int32 = 0x0000000c
-)
-
 const (
 	// Control is a socket control message containing TX/RX timestamp
 	// If the read fails we may endup with multiple timestamps in the buffer
@@ -45,14 +37,8 @@ const (
 	ControlSizeBytes = 128
 	// ptp packets usually up to 66 bytes
 	PayloadSizeBytes = 128
-	// look only for X sequential TS
-	maxTXTS = 100
-	// Socket Control Message Header Offset on Linux
 )
 
-// unix.Cmsghdr size differs depending on platform
-var socketControlMessageHeaderOffset = binary.Size(unix.Cmsghdr{})
-
 const (
 	// HWTIMESTAMP is a hardware timestamp
 	HWTIMESTAMP = "hardware"
@@ -60,34 +46,167 @@ const (
 	SWTIMESTAMP = "software"
 )
 
-var timestamping = unix.SO_TIMESTAMPING_NEW
+// look only for X sequential TS
+const maxTXTS = 100
+
+// TXTimestampConfig controls how long we wait for TX timestamp to appear
+type TXTimestampConfig struct {
+	// RetryInterval is how long a single attempt waits for the timestamp
+	RetryInterval time.Duration
+	// MaxAttempts is a number of attempts, 0 means retry until context is done
+	MaxAttempts int
+}
 
-func init() {
-	// if kernel is older than 5, it doesn't support unix.SO_TIMESTAMPING_NEW
-	var uname unix.Utsname
-	if err := unix.Uname(&uname); err == nil {
-		if uname.Release[0] < '5' {
-			// reading such timestamps on 32bit machines will not work, but we can't support everything
-			timestamping = unix.SO_TIMESTAMPING
+// DefaultTXTimestampConfig is used by ReadTXtimestamp and ReadTXtimestampBuf
+var DefaultTXTimestampConfig = TXTimestampConfig{
+	RetryInterval: time.Millisecond,
+	MaxAttempts:   maxTXTS,
+}
+
+// Timestamp is a type of timestamping we enable on the socket
+type Timestamp int
+
+const (
+	// SW is a software TX and RX timestamp
+	SW Timestamp = iota
+	// SWRX is a software RX timestamp
+	SWRX
+	// HW is a hardware TX and RX timestamp
+	HW
+	// HWRX is a hardware RX timestamp
+	HWRX
+)
+
+// TimestampToString is a map from Timestamp to string
+var TimestampToString = map[Timestamp]string{
+	SW:   SWTIMESTAMP,
+	SWRX: "software_rx",
+	HW:   HWTIMESTAMP,
+	HWRX: "hardware_rx",
+}
+
+func (t Timestamp) String() string {
+	s, found := TimestampToString[t]
+	if !found {
+		return "unsupported"
+	}
+	return s
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t Timestamp) MarshalText() ([]byte, error) {
+	if _, found := TimestampToString[t]; !found {
+		return nil, fmt.Errorf("unknown timestamp type %d", t)
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *Timestamp) UnmarshalText(value []byte) error {
+	for k, v := range TimestampToString {
+		if v == string(value) {
+			*t = k
+			return nil
 		}
 	}
+	return fmt.Errorf("unknown timestamp type %q", value)
+}
+
+// Set implements flag.Value
+func (t *Timestamp) Set(value string) error {
+	return t.UnmarshalText([]byte(value))
+}
+
+// Type implements pflag.Value
+func (t *Timestamp) Type() string {
+	return "timestamp"
+}
+
+// fallback returns the software counterpart of hardware timestamp type
+func (t Timestamp) fallback() Timestamp {
+	switch t {
+	case HW:
+		return SW
+	case HWRX:
+		return SWRX
+	}
+	return t
+}
+
+// EnableTimestamps enables timestamps of the requested type on the socket.
+// If hardware timestamps can't be enabled (not supported by the NIC or platform) it falls back to software ones.
+// Returned value is the type of timestamps that was actually enabled.
+func EnableTimestamps(connFd int, iface string, ts Timestamp) (Timestamp, error) {
+	var err error
+	switch ts {
+	case SW:
+		return ts, EnableSWTimestampsSocket(connFd)
+	case SWRX:
+		return ts, EnableSWTimestampsRx(connFd)
+	case HW:
+		err = EnableHWTimestampsSocket(connFd, iface)
+	case HWRX:
+		err = EnableHWTimestampsRx(connFd, iface)
+	default:
+		return ts, fmt.Errorf("unknown timestamp type %d", ts)
+	}
+	if err == nil {
+		return ts, nil
+	}
+	fb := ts.fallback()
+	if _, fberr := EnableTimestamps(connFd, iface, fb); fberr != nil {
+		return ts, fmt.Errorf("failed to enable %s timestamps: %v, failed to fall back to %s timestamps: %w", ts, err, fb, fberr)
+	}
+	getStats().IncSWFallback()
+	return fb, nil
 }
 
-// Ifreq is a struct for ioctl ethernet manipulation syscalls.
-type ifreq struct {
-	name [unix.IFNAMSIZ]byte
-	data uintptr
+// HWCapabilities is what hardware timestamping an interface supports
+type HWCapabilities struct {
+	// TX is true if the interface can do hardware TX timestamps
+	TX bool
+	// RX is true if the interface can do hardware RX timestamps of PTP packets
+	RX bool
+	// OneStepSync is true if the interface can insert TX timestamps into Sync packets on the fly
+	OneStepSync bool
 }
 
-// from include/uapi/linux/net_tstamp.h
-type hwtstampСonfig struct {
-	flags    int32
-	txType   int32
-	rxFilter int32
+// Timestamps holds all timestamps found in a socket control message
+type Timestamps struct {
+	// Software is a software timestamp
+	Software time.Time
+	// Legacy is a deprecated hardware timestamp converted to system time, linux only, usually zero
+	Legacy time.Time
+	// Hardware is a raw hardware timestamp, linux only
+	Hardware time.Time
+	// HardwareSystem is Hardware converted to system time, only set when parsed by PHCOffset
+	HardwareSystem time.Time
+	// Selected is the type of the timestamp which is returned by ReadPacketWithRXTimestamp and ReadTXtimestamp, HW or SW
+	Selected Timestamp
+}
+
+// Time returns the selected timestamp
+func (t *Timestamps) Time() time.Time {
+	if t.Selected == HW {
+		return t.Hardware
+	}
+	return t.Software
+}
+
+// ParseTimestamps returns all timestamps from a socket control message, for example one returned by net.UDPConn.ReadMsgUDP.
+// Software and hardware timestamps are both present only if both were enabled on the socket.
+func ParseTimestamps(oob []byte) (*Timestamps, error) {
+	return socketControlMessageTimestamps(oob)
 }
 
 // ConnFd returns file descriptor of a connection
 func ConnFd(conn *net.UDPConn) (int, error) {
+	return SyscallConnFd(conn)
+}
+
+// SyscallConnFd is like ConnFd, but works with any connection providing access to its file descriptor,
+// e.g. *net.TCPConn, *net.UnixConn or *net.IPConn
+func SyscallConnFd(conn syscall.Conn) (int, error) {
 	sc, err := conn.SyscallConn()
 	if err != nil {
 		return -1, err
@@ -102,214 +221,332 @@ func ConnFd(conn *net.UDPConn) (int, error) {
 	return intfd, nil
 }
 
-func ioctlTimestamp(fd int, ifname string, filter int32) error {
-	hw := &hwtstampСonfig{
-		flags:    0,
-		txType:   hwtstampTXON,
-		rxFilter: filter,
+// ReadTXtimestamp returns HW TX timestamp
+func ReadTXtimestamp(connFd int) (time.Time, int, error) {
+	// Accessing hw timestamp
+	oob := make([]byte, ControlSizeBytes)
+	// TMP buffers
+	toob := make([]byte, ControlSizeBytes)
+
+	return ReadTXtimestampBuf(connFd, oob, toob)
+}
+
+// ReadTXtimestampContext is like ReadTXtimestamp, but gives up when ctx is done and retries as per cfg
+func ReadTXtimestampContext(ctx context.Context, connFd int, cfg TXTimestampConfig) (time.Time, int, error) {
+	oob := make([]byte, ControlSizeBytes)
+	toob := make([]byte, ControlSizeBytes)
+
+	return ReadTXtimestampContextBuf(ctx, connFd, oob, toob, cfg)
+}
+
+// ReadTXtimestampWithID returns the oldest TX timestamp along with the ID of the packet it belongs to
+func ReadTXtimestampWithID(connFd int) (time.Time, uint32, int, error) {
+	oob := make([]byte, ControlSizeBytes)
+	return ReadTXtimestampWithIDBuf(connFd, oob)
+}
+
+// ReadPacketWithRXTimestamp returns byte packet and HW RX timestamp
+func ReadPacketWithRXTimestamp(connFd int) ([]byte, unix.Sockaddr, time.Time, error) {
+	// Accessing hw timestamp
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+
+	bbuf, sa, t, err := ReadPacketWithRXTimestampBuf(connFd, buf, oob)
+	return buf[:bbuf], sa, t, err
+}
+
+// ReadPacketWithRXTimestampBuf writes byte packet into provide buffer buf, and returns number of bytes copied to the buffer, client ip and HW RX timestamp.
+// oob buffer can be reaused after ReadPacketWithRXTimestampBuf call.
+func ReadPacketWithRXTimestampBuf(connFd int, buf, oob []byte) (int, unix.Sockaddr, time.Time, error) {
+	var rsa unix.RawSockaddrAny
+	bbuf, timestamp, err := ReadPacketWithRXTimestampBufAddr(connFd, buf, oob, &rsa)
+	return bbuf, rawToSockaddr(&rsa), timestamp, err
+}
+
+// ReadPacketWithRXTimestampBufAddr is like ReadPacketWithRXTimestampBuf, but writes client address into provided rsa instead of allocating unix.Sockaddr.
+// It makes no heap allocations unless it fails, which makes it suitable for the hot path of high-rate servers.
+// buf, oob and rsa can be reused after ReadPacketWithRXTimestampBufAddr call.
+func ReadPacketWithRXTimestampBufAddr(connFd int, buf, oob []byte, rsa *unix.RawSockaddrAny) (int, time.Time, error) {
+	n, oobn, err := recvmsgRaw(connFd, buf, oob, rsa)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read timestamp: %v", err)
 	}
 
-	i := &ifreq{data: uintptr(unsafe.Pointer(hw))}
-	copy(i.name[:unix.IFNAMSIZ-1], ifname)
+	timestamp, err := socketControlMessageTimestamp(oob[:oobn])
+	return n, timestamp, err
+}
+
+// ReadPacketBufAddr reads a packet without timestamp into buf and writes client address into rsa.
+// Like ReadPacketWithRXTimestampBufAddr it makes no heap allocations unless it fails.
+func ReadPacketBufAddr(connFd int, buf []byte, rsa *unix.RawSockaddrAny) (int, error) {
+	n, _, err := recvmsgRaw(connFd, buf, nil, rsa)
+	return n, err
+}
 
-	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCSHWTSTAMP, uintptr(unsafe.Pointer(i))); errno != 0 {
-		return fmt.Errorf("failed to run ioctl SIOCSHWTSTAMP: %s (%d)", unix.ErrnoName(errno), errno)
+// ReadPacketWithInfoBuf reads a packet without timestamp into buf, writes client address into rsa and fills info with the destination address and interface of the packet.
+// Requires EnablePacketInfo, info.Dst is left empty if the socket reports no packet info.
+// If info.Dst has enough capacity it's reused, in which case no heap allocations are made unless the call fails.
+func ReadPacketWithInfoBuf(connFd int, buf, oob []byte, rsa *unix.RawSockaddrAny, info *PacketInfo) (int, error) {
+	n, oobn, err := recvmsgRaw(connFd, buf, oob, rsa)
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	info.Dst = info.Dst[:0]
+	// sockets without RX timestamps have no timestamp to find, packet info is parsed regardless
+	_, _ = socketControlMessageTimestampInfo(oob[:oobn], info)
+	return n, nil
 }
 
-// EnableHWTimestampsSocket enables HW timestamps on the socket
-func EnableHWTimestampsSocket(connFd int, iface string) error {
-	if err := ioctlTimestamp(connFd, iface, hwtstampFilterAll); err != nil {
-		if err := ioctlTimestamp(connFd, iface, hwtstampFilterPTPv2Event); err != nil {
-			return err
-		}
+// recvmsgRaw reads a packet into buf and oob, writing client address into rsa unless it's nil. Returns number of bytes read into buf and oob.
+func recvmsgRaw(connFd int, buf, oob []byte, rsa *unix.RawSockaddrAny) (int, int, error) {
+	var iov unix.Iovec
+	if len(buf) > 0 {
+		iov.Base = &buf[0]
+		iov.SetLen(len(buf))
+	}
+	var msg unix.Msghdr
+	if rsa != nil {
+		msg.Name = (*byte)(unsafe.Pointer(rsa))
+		msg.Namelen = uint32(unix.SizeofSockaddrAny)
+	}
+	msg.Iov = &iov
+	msg.SetIovlen(1)
+	if len(oob) > 0 {
+		msg.Control = &oob[0]
+		msg.SetControllen(len(oob))
 	}
 
-	// Enable hardware timestamp capabilities on socket
-	flags := unix.SOF_TIMESTAMPING_TX_HARDWARE |
-		unix.SOF_TIMESTAMPING_RX_HARDWARE |
-		unix.SOF_TIMESTAMPING_RAW_HARDWARE |
-		unix.SOF_TIMESTAMPING_OPT_TSONLY // Makes the kernel return the timestamp as a cmsg alongside an empty packet, as opposed to alongside the original packet.
-	// Allow reading of HW timestamps via socket
-	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags); err != nil {
-		return err
+	r, _, e1 := unix.Syscall(unix.SYS_RECVMSG, uintptr(connFd), uintptr(unsafe.Pointer(&msg)), 0)
+	if e1 != 0 {
+		return 0, 0, e1
 	}
+	return int(r), int(msg.Controllen), nil
+}
 
-	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_SELECT_ERR_QUEUE, 1); err != nil {
-		return err
+// ReadWithRXTimestamp reads a packet from connected socket and returns it along with RX timestamp
+func ReadWithRXTimestamp(connFd int) ([]byte, time.Time, error) {
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+
+	n, t, err := ReadWithRXTimestampBuf(connFd, buf, oob)
+	return buf[:n], t, err
+}
+
+// ReadWithRXTimestampBuf is like ReadPacketWithRXTimestampBuf, but for connected sockets where the peer is fixed, so its address is not read at all.
+// buf and oob can be reused after ReadWithRXTimestampBuf call.
+func ReadWithRXTimestampBuf(connFd int, buf, oob []byte) (int, time.Time, error) {
+	n, oobn, err := recvmsgRaw(connFd, buf, oob, nil)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read timestamp: %v", err)
 	}
-	return nil
+
+	timestamp, err := socketControlMessageTimestamp(oob[:oobn])
+	return n, timestamp, err
 }
 
-// EnableSWTimestampsSocket enables SW timestamps on the socket
-func EnableSWTimestampsSocket(connFd int) error {
-	flags := unix.SOF_TIMESTAMPING_TX_SOFTWARE |
-		unix.SOF_TIMESTAMPING_RX_SOFTWARE |
-		unix.SOF_TIMESTAMPING_SOFTWARE |
-		unix.SOF_TIMESTAMPING_OPT_TSONLY // Makes the kernel return the timestamp as a cmsg alongside an empty packet, as opposed to alongside the original packet.
-	// Allow reading of SW timestamps via socket
-	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags); err != nil {
+// WriteAndReadTX sends a packet over connected socket and returns number of bytes sent and TX timestamp of the packet
+func WriteAndReadTX(connFd int, b []byte) (int, time.Time, error) {
+	oob := make([]byte, ControlSizeBytes)
+	toob := make([]byte, ControlSizeBytes)
+
+	return WriteAndReadTXBuf(connFd, b, oob, toob)
+}
+
+// WriteAndReadTXBuf is like WriteAndReadTX, but uses provided buffers to read TX timestamp, see ReadTXtimestampBuf
+func WriteAndReadTXBuf(connFd int, b, oob, toob []byte) (int, time.Time, error) {
+	// send(2) on connected socket, no destination address to pass
+	var n int
+	err := retryAfterDrain(connFd, func() (err error) {
+		n, err = unix.Write(connFd, b)
 		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to send packet: %w", err)
 	}
+	timestamp, _, err := ReadTXtimestampBuf(connFd, oob, toob)
+	return n, timestamp, err
+}
 
-	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_SELECT_ERR_QUEUE, 1); err != nil {
+// retryAfterDrain calls send and if it fails with ENOBUFS or EMSGSIZE, which some drivers return when there are too many unread TX timestamps,
+// drains the socket error queue and tries once more
+func retryAfterDrain(connFd int, send func() error) error {
+	err := send()
+	if !errors.Is(err, unix.ENOBUFS) && !errors.Is(err, unix.EMSGSIZE) {
 		return err
 	}
-	return nil
+	if _, derr := DrainErrQueue(connFd); derr != nil {
+		return err
+	}
+	return send()
 }
 
-// byteToTime converts LittleEndian bytes into a timestamp
-func byteToTime(data []byte) (time.Time, error) {
-	// __kernel_timespec from linux/time_types.h
-	// can't use unix.Timespec which is old timespec that uses 32bit ints on 386 platform.
-	sec := int64(binary.LittleEndian.Uint64(data[0:8]))
-	nsec := int64(binary.LittleEndian.Uint64(data[8:]))
-	return time.Unix(sec, nsec), nil
+// SendtoWithDrain is like unix.Sendto, but recovers from the error queue overflow with DrainErrQueue
+func SendtoWithDrain(connFd int, b []byte, to unix.Sockaddr) error {
+	return retryAfterDrain(connFd, func() error {
+		return unix.Sendto(connFd, b, 0, to)
+	})
 }
 
-// socketControlMessageTimestamp is a very optimised version of ParseSocketControlMessage
-// https://github.com/golang/go/blob/2ebe77a2fda1ee9ff6fd9a3e08933ad1ebaea039/src/syscall/sockcmsg_unix.go#L40
-// which only parses the timestamp message type.
-func socketControlMessageTimestamp(b []byte) (time.Time, error) {
-	mlen := 0
-	for i := 0; i < len(b); i += mlen {
-		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
-		mlen = int(h.Len)
+// SendmsgWithDrain is like SendtoWithDrain, but also passes control messages in oob along with the packet
+func SendmsgWithDrain(connFd int, b, oob []byte, to unix.Sockaddr) error {
+	return retryAfterDrain(connFd, func() error {
+		_, err := unix.SendmsgN(connFd, b, oob, to, 0)
+		return err
+	})
+}
 
-		// depending on the kernel version, when we ask for SO_TIMESTAMPING_NEW we still might get messages with type SO_TIMESTAMPING
-		if h.Level == unix.SOL_SOCKET && int(h.Type) == unix.SO_TIMESTAMPING_NEW || int(h.Type) == unix.SO_TIMESTAMPING {
-			return scmDataToTime(b[i+socketControlMessageHeaderOffset : i+mlen])
-		}
-	}
-	return time.Time{}, fmt.Errorf("failed to find timestamp in socket control message")
+// PacketInfo is the destination address and the interface of a received packet
+type PacketInfo struct {
+	// Dst is the destination address from the packet IP header. It's IPv4-mapped for IPv4 packets received by IPv6 socket on linux.
+	Dst net.IP
+	// Ifindex is the index of the interface the packet was received on
+	Ifindex int
+	// TrafficClass is IPv4 TOS or IPv6 traffic class of the packet, only set if EnableTrafficClassInfo was used
+	TrafficClass uint8
+	// FlowLabel is IPv6 flow label of the packet, only set if EnableTrafficClassInfo was used, linux only
+	FlowLabel uint32
 }
 
-/*
-scmDataToTime parses SocketControlMessage Data field into time.Time.
-The structure can return up to three timestamps. This is a legacy
-feature. Only one field is non-zero at any time. Most timestamps
-are passed in ts[0]. Hardware timestamps are passed in ts[2].
-*/
-func scmDataToTime(data []byte) (ts time.Time, err error) {
-	// 2 x 64bit ints
-	size := 16
-	// first, try to use hardware timestamps
-	ts, err = byteToTime(data[size*2 : size*3])
+// DSCP returns DSCP part of the packet traffic class
+func (i *PacketInfo) DSCP() uint8 {
+	return i.TrafficClass >> 2
+}
+
+// setDst copies the address into info.Dst reusing its memory when possible
+func (i *PacketInfo) setDst(addr []byte) {
+	i.Dst = append(i.Dst[:0], addr...)
+}
+
+// EnablePacketInfo enables reporting of the packet destination address and interface on the socket,
+// which is required by ReadPacketWithRXTimestampAndInfoBuf.
+// Multi-homed servers need it to reply from the address the request was sent to.
+func EnablePacketInfo(connFd int) error {
+	sa, err := unix.Getsockname(connFd)
 	if err != nil {
-		return ts, err
+		return err
 	}
-	// if hw timestamps aren't present, use software timestamps
-	// we can't use ts.IsZero because for some crazy reason timestamp parsed using time.Unix()
-	// reports IsZero() == false, even if seconds and nanoseconds are zero.
-	if ts.UnixNano() == 0 {
-		ts, err = byteToTime(data[0:size])
-		if err != nil {
-			return ts, err
-		}
-		if ts.UnixNano() == 0 {
-			return ts, fmt.Errorf("got zero timestamp")
-		}
+	switch sa.(type) {
+	case *unix.SockaddrInet4:
+		return enablePacketInfo4(connFd)
+	case *unix.SockaddrInet6:
+		return enablePacketInfo6(connFd)
 	}
-
-	return ts, nil
+	return fmt.Errorf("unsupported socket address type %T", sa)
 }
 
-func waitForHWTS(connFd int) error {
-	// Wait until TX timestamp is ready
-	fds := []unix.PollFd{{Fd: int32(connFd), Events: unix.POLLPRI, Revents: 0}}
-	if _, err := unix.Poll(fds, 1); err != nil { // Timeout is 1 ms
+// EnableTrafficClassInfo enables reporting of the packet IPv4 TOS or IPv6 traffic class (and flow label on linux) on the socket,
+// which ReadPacketWithRXTimestampAndInfoBuf then returns as a part of PacketInfo.
+// Monitoring tools can use it to verify packets traverse the network in the expected QoS class.
+// Extra control messages may not fit into ControlSizeBytes oob buffer together with everything else, use twice as big one.
+func EnableTrafficClassInfo(connFd int) error {
+	sa, err := unix.Getsockname(connFd)
+	if err != nil {
 		return err
 	}
-	return nil
+	switch sa.(type) {
+	case *unix.SockaddrInet4:
+		return enableTrafficClassInfo4(connFd)
+	case *unix.SockaddrInet6:
+		// dual stack socket receives IPv4 packets too, but IPv6-only socket may not accept IPv4 options
+		_ = enableTrafficClassInfo4(connFd)
+		return enableTrafficClassInfo6(connFd)
+	}
+	return fmt.Errorf("unsupported socket address type %T", sa)
 }
 
-// recvoob receives only OOB message from the socket
-// This is used for TX timestamp read of MSG_ERRQUEUE where we couldn't care less about other data.
-// This is partially based on Recvmsg
-// https://github.com/golang/go/blob/2ebe77a2fda1ee9ff6fd9a3e08933ad1ebaea039/src/syscall/syscall_linux.go#L647
-func recvoob(connFd int, oob []byte) (oobn int, err error) {
-	var msg unix.Msghdr
-	msg.Control = &oob[0]
-	msg.SetControllen(len(oob))
-	_, _, e1 := unix.Syscall(unix.SYS_RECVMSG, uintptr(connFd), uintptr(unsafe.Pointer(&msg)), uintptr(unix.MSG_ERRQUEUE))
-	if e1 != 0 {
-		return 0, e1
+// ReadPacketWithRXTimestampAndInfoBuf is like ReadPacketWithRXTimestampBufAddr, but also fills info with the destination address and interface of the packet.
+// All are parsed from socket control messages in the same pass as the timestamp. Requires EnablePacketInfo and optionally EnableTrafficClassInfo.
+// If info.Dst has enough capacity it's reused, in which case no heap allocations are made unless the call fails.
+func ReadPacketWithRXTimestampAndInfoBuf(connFd int, buf, oob []byte, rsa *unix.RawSockaddrAny, info *PacketInfo) (int, time.Time, error) {
+	n, oobn, err := recvmsgRaw(connFd, buf, oob, rsa)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to read timestamp: %v", err)
 	}
-	return int(msg.Controllen), nil
+	timestamp, err := socketControlMessageTimestampInfo(oob[:oobn], info)
+	return n, timestamp, err
 }
 
-// ReadTXtimestampBuf returns HW TX timestamp, needs to be provided 2 buffers which all can be re-used after ReadTXtimestampBuf finishes.
-func ReadTXtimestampBuf(connFd int, oob, toob []byte) (time.Time, int, error) {
-	// Accessing hw timestamp
-	var boob int
-
-	txfound := false
-
-	// Sometimes we end up with more than 1 TX TS in the buffer.
-	// We need to empty it and completely otherwise we end up with a shifted queue read:
-	// Sync is out -> read TS from the previous Sync
-	// Because we always perform at least 2 tries we start with 0 so on success we are at 1.
-	attempts := 0
-	for ; attempts < maxTXTS; attempts++ {
-		if !txfound {
-			// Wait for the poll event, ignore the error
-			_ = waitForHWTS(connFd)
-		}
+// RawSockaddrToSockaddr converts client address written by ReadPacketWithRXTimestampBufAddr into unix.Sockaddr.
+// It returns nil for unsupported address families.
+func RawSockaddrToSockaddr(rsa *unix.RawSockaddrAny) unix.Sockaddr {
+	return rawToSockaddr(rsa)
+}
 
-		tboob, err := recvoob(connFd, toob)
-		if err != nil {
-			// We've already seen the valid TX TS and now we have an empty queue.
-			// All good
-			if txfound {
-				break
-			}
-			// Keep looking for a valid TX TS
-			continue
-		}
-		// We found a valid TX TS. Still check more if there is a newer one
-		txfound = true
-		boob = tboob
-		copy(oob, toob)
+// rawToSockaddr converts raw socket address returned by kernel into unix.Sockaddr
+// Somewhat copy from https://github.com/golang/sys/blob/af8b64212486af4b6dd9d6e2a6fcac165d5c9bc6/unix/syscall_linux.go#L964
+func rawToSockaddr(rsa *unix.RawSockaddrAny) unix.Sockaddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		pp := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet4{}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		sa.Addr = pp.Addr
+		return sa
+	case unix.AF_INET6:
+		pp := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa := &unix.SockaddrInet6{}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		sa.ZoneId = pp.Scope_id
+		sa.Addr = pp.Addr
+		return sa
 	}
+	return nil
+}
 
-	if !txfound {
-		return time.Time{}, attempts, fmt.Errorf("no TX timestamp found after %d tries", maxTXTS)
-	}
-	timestamp, err := socketControlMessageTimestamp(oob[:boob])
-	return timestamp, attempts, err
+// PacketReader reads packets together with their RX timestamps
+type PacketReader interface {
+	ReadPacketWithRXTimestampBuf(buf, oob []byte) (int, unix.Sockaddr, time.Time, error)
+	// ReadPacketWithRXTimestampBufAddr writes client address into rsa, making no heap allocations unless it fails
+	ReadPacketWithRXTimestampBufAddr(buf, oob []byte, rsa *unix.RawSockaddrAny) (int, time.Time, error)
+	Close() error
 }
 
-// ReadTXtimestamp returns HW TX timestamp
-func ReadTXtimestamp(connFd int) (time.Time, int, error) {
-	// Accessing hw timestamp
-	oob := make([]byte, ControlSizeBytes)
-	// TMP buffers
-	toob := make([]byte, ControlSizeBytes)
+// recvmsgReader is a PacketReader doing a recvmsg syscall per packet
+type recvmsgReader struct {
+	connFd int
+}
 
-	return ReadTXtimestampBuf(connFd, oob, toob)
+// NewRecvmsgReader returns PacketReader doing a recvmsg syscall per packet
+func NewRecvmsgReader(connFd int) PacketReader {
+	return &recvmsgReader{connFd: connFd}
 }
 
-// ReadPacketWithRXTimestamp returns byte packet and HW RX timestamp
-func ReadPacketWithRXTimestamp(connFd int) ([]byte, unix.Sockaddr, time.Time, error) {
-	// Accessing hw timestamp
-	buf := make([]byte, PayloadSizeBytes)
-	oob := make([]byte, ControlSizeBytes)
+// ReadPacketWithRXTimestampBuf reads packet using ReadPacketWithRXTimestampBuf
+func (r *recvmsgReader) ReadPacketWithRXTimestampBuf(buf, oob []byte) (int, unix.Sockaddr, time.Time, error) {
+	return ReadPacketWithRXTimestampBuf(r.connFd, buf, oob)
+}
 
-	bbuf, sa, t, err := ReadPacketWithRXTimestampBuf(connFd, buf, oob)
-	return buf[:bbuf], sa, t, err
+// ReadPacketWithRXTimestampBufAddr reads packet using ReadPacketWithRXTimestampBufAddr
+func (r *recvmsgReader) ReadPacketWithRXTimestampBufAddr(buf, oob []byte, rsa *unix.RawSockaddrAny) (int, time.Time, error) {
+	return ReadPacketWithRXTimestampBufAddr(r.connFd, buf, oob, rsa)
 }
 
-// ReadPacketWithRXTimestampBuf writes byte packet into provide buffer buf, and returns number of bytes copied to the buffer, client ip and HW RX timestamp.
-// oob buffer can be reaused after ReadPacketWithRXTimestampBuf call.
-func ReadPacketWithRXTimestampBuf(connFd int, buf, oob []byte) (int, unix.Sockaddr, time.Time, error) {
-	bbuf, boob, _, saddr, err := unix.Recvmsg(connFd, buf, oob, 0)
-	if err != nil {
-		return 0, nil, time.Time{}, fmt.Errorf("failed to read timestamp: %v", err)
+// Close is a noop, the underlying socket is owned by the caller
+func (r *recvmsgReader) Close() error {
+	return nil
+}
+
+// RXPacket is a packet received by ReadPacketsWithRXTimestamps
+type RXPacket struct {
+	Data      []byte
+	Addr      unix.Sockaddr
+	Timestamp time.Time
+}
+
+// ReadPacketsWithRXTimestamps reads up to n packets in one go and returns them together with their RX timestamps
+func ReadPacketsWithRXTimestamps(connFd int, n int) ([]RXPacket, error) {
+	bufs := make([][]byte, n)
+	oobs := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		bufs[i] = make([]byte, PayloadSizeBytes)
+		oobs[i] = make([]byte, ControlSizeBytes)
 	}
+	packets := make([]RXPacket, n)
 
-	timestamp, err := socketControlMessageTimestamp(oob[:boob])
-	return bbuf, saddr, timestamp, err
+	got, err := ReadPacketsWithRXTimestampsBuf(connFd, bufs, oobs, packets)
+	return packets[:got], err
 }
 
 // IPToSockaddr converts IP + port into a socket address
@@ -0,0 +1,154 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func Test_byteToTime(t *testing.T) {
+	timeval := unix.NsecToTimeval(1612028735717200000)
+	b := (*[unsafe.Sizeof(timeval)]byte)(unsafe.Pointer(&timeval))[:]
+	res, err := byteToTime(b)
+	require.Nil(t, err)
+
+	require.Equal(t, int64(1612028735717200000), res.UnixNano())
+}
+
+func TestSocketControlMessageTimestamp(t *testing.T) {
+	timeval := unix.NsecToTimeval(1628091622667374000)
+	data := (*[unsafe.Sizeof(timeval)]byte)(unsafe.Pointer(&timeval))[:]
+
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = unix.SCM_TIMESTAMP
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+
+	ts, err := socketControlMessageTimestamp(b)
+	require.NoError(t, err)
+	require.Equal(t, int64(1628091622667374000), ts.UnixNano())
+
+	_, err = socketControlMessageTimestamp(make([]byte, unix.CmsgSpace(len(data))))
+	require.Error(t, err)
+}
+
+func TestEnableSWTimestampsRx(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+
+	// Check that socket option is set
+	kernelTimestampsEnabled, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_TIMESTAMP)
+	require.Nil(t, err)
+	require.Greater(t, kernelTimestampsEnabled, 0, "Kernel timestamps are not enabled")
+}
+
+func Test_ReadTXtimestamp(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	txts, _, err := ReadTXtimestamp(connFd)
+	require.Equal(t, time.Time{}, txts)
+	require.Error(t, err)
+
+	err = EnableSWTimestampsSocket(connFd)
+	require.Nil(t, err)
+
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	start := time.Now()
+	_, err = conn.WriteTo([]byte{}, addr)
+	require.Nil(t, err)
+	txts, attempts, err := ReadTXtimestamp(connFd)
+
+	require.Nil(t, err)
+	require.Equal(t, 1, attempts)
+	require.True(t, txts.After(start))
+}
+
+func Test_ReadPacketWithRXTimestamp(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+
+	start := time.Now()
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+
+	data, _, rxts, err := ReadPacketWithRXTimestamp(connFd)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, data)
+	require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+}
+
+func TestReadTXtimestampContext(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsTx(connFd)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	txts, _, err := ReadTXtimestampContext(ctx, connFd, DefaultTXTimestampConfig)
+	require.Nil(t, err)
+	require.NotEqual(t, time.Time{}, txts)
+
+	cancel()
+	_, _, err = ReadTXtimestampContext(ctx, connFd, DefaultTXTimestampConfig)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestEnableBusyPoll(t *testing.T) {
+	require.Error(t, EnableBusyPoll(0, time.Microsecond, 0))
+}
+
+func TestEnableOneStepSync(t *testing.T) {
+	_, err := GetHWCapabilities("lo0")
+	require.Error(t, err)
+	_, err = EnableOneStepSync(0, "lo0")
+	require.Error(t, err)
+}
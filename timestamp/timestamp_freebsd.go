@@ -0,0 +1,255 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// unix.Cmsghdr size differs depending on platform, data is aligned as per CMSG_DATA
+var socketControlMessageHeaderOffset = unix.CmsgSpace(0)
+
+// EnableHWTimestampsSocket is not supported on FreeBSD
+func EnableHWTimestampsSocket(connFd int, iface string) error {
+	return fmt.Errorf("hardware timestamps are not supported on freebsd")
+}
+
+// GetHWCapabilities is not supported on FreeBSD
+func GetHWCapabilities(iface string) (*HWCapabilities, error) {
+	return nil, fmt.Errorf("hardware timestamps are not supported on freebsd")
+}
+
+// EnableOneStepSync is not supported on FreeBSD
+func EnableOneStepSync(connFd int, iface string) (*HWCapabilities, error) {
+	return nil, fmt.Errorf("hardware timestamps are not supported on freebsd")
+}
+
+// EnableHWTimestampsRx is not supported on FreeBSD
+func EnableHWTimestampsRx(connFd int, iface string) error {
+	return fmt.Errorf("hardware timestamps are not supported on freebsd")
+}
+
+// EnableSWTimestampsSocket enables SW timestamps on the socket
+func EnableSWTimestampsSocket(connFd int) error {
+	if err := EnableSWTimestampsRx(connFd); err != nil {
+		return err
+	}
+	return EnableSWTimestampsTx(connFd)
+}
+
+// EnableSWTimestampsRx enables SW RX timestamps on the socket
+func EnableSWTimestampsRx(connFd int) error {
+	// Allow reading of SW timestamps via socket
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_TIMESTAMP, 1); err != nil {
+		return err
+	}
+	// Ask for nanosecond SCM_REALTIME timestamps. Older kernels don't support SO_TS_CLOCK,
+	// in which case we keep getting microsecond SCM_TIMESTAMP ones.
+	_ = unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_TS_CLOCK, unix.SO_TS_REALTIME)
+	return nil
+}
+
+// EnableSWTimestampsTx enables emulated SW TX timestamps on the socket.
+// See ReadTXtimestampBuf for the precision of such timestamps.
+func EnableSWTimestampsTx(connFd int) error {
+	// make sure we are dealing with an actual socket
+	if _, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_TYPE); err != nil {
+		return err
+	}
+	return enableTXEmulation(connFd)
+}
+
+// byteToTime converts bytes into a timestamp
+func byteToTime(data []byte) (time.Time, error) {
+	// SCM_TIMESTAMP returns timeval
+	timeval := (*unix.Timeval)(unsafe.Pointer(&data[0]))
+	return time.Unix(timeval.Unix()), nil
+}
+
+// timespecToTime converts bytes of SCM_REALTIME message into a timestamp
+func timespecToTime(data []byte) (time.Time, error) {
+	timespec := (*unix.Timespec)(unsafe.Pointer(&data[0]))
+	return time.Unix(timespec.Unix()), nil
+}
+
+// socketControlMessageTimestamp is a very optimised version of ParseSocketControlMessage
+// https://github.com/golang/go/blob/2ebe77a2fda1ee9ff6fd9a3e08933ad1ebaea039/src/syscall/sockcmsg_unix.go#L40
+// which only parses the timestamp message type.
+func socketControlMessageTimestamp(b []byte) (time.Time, error) {
+	mlen := 0
+	for i := 0; i+socketControlMessageHeaderOffset <= len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		if int(h.Len) < socketControlMessageHeaderOffset || i+int(h.Len) > len(b) {
+			break
+		}
+		// next message starts at the aligned offset
+		mlen = unix.CmsgSpace(int(h.Len) - socketControlMessageHeaderOffset)
+
+		if h.Level != unix.SOL_SOCKET {
+			continue
+		}
+		switch h.Type {
+		case unix.SCM_REALTIME:
+			return timespecToTime(b[i+socketControlMessageHeaderOffset : i+int(h.Len)])
+		case unix.SCM_TIMESTAMP:
+			return byteToTime(b[i+socketControlMessageHeaderOffset : i+int(h.Len)])
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to find timestamp in socket control message")
+}
+
+// enablePacketInfo4 enables IP_RECVDSTADDR and IP_RECVIF messages on IPv4 socket, freebsd has no IP_PKTINFO
+func enablePacketInfo4(connFd int) error {
+	if err := unix.SetsockoptInt(connFd, unix.IPPROTO_IP, unix.IP_RECVDSTADDR, 1); err != nil {
+		return err
+	}
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IP, unix.IP_RECVIF, 1)
+}
+
+// enablePacketInfo6 enables IPV6_PKTINFO messages on IPv6 socket
+func enablePacketInfo6(connFd int) error {
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, 1)
+}
+
+// enableTrafficClassInfo4 enables IP_RECVTOS messages on the socket
+func enableTrafficClassInfo4(connFd int) error {
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+}
+
+// enableTrafficClassInfo6 enables IPV6_TCLASS messages on IPv6 socket
+func enableTrafficClassInfo6(connFd int) error {
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, 1)
+}
+
+// socketControlMessageTimestampInfo is like socketControlMessageTimestamp, but also fills info from IP_RECVDSTADDR/IP_RECVIF/IPV6_PKTINFO and IP_RECVTOS/IPV6_TCLASS messages
+func socketControlMessageTimestampInfo(b []byte, info *PacketInfo) (time.Time, error) {
+	var ts time.Time
+	var err error
+	found := false
+	mlen := 0
+	for i := 0; i+socketControlMessageHeaderOffset <= len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		if int(h.Len) < socketControlMessageHeaderOffset || i+int(h.Len) > len(b) {
+			break
+		}
+		// next message starts at the aligned offset
+		mlen = unix.CmsgSpace(int(h.Len) - socketControlMessageHeaderOffset)
+		data := b[i+socketControlMessageHeaderOffset : i+int(h.Len)]
+
+		switch {
+		case h.Level == unix.SOL_SOCKET && h.Type == unix.SCM_REALTIME:
+			ts, err = timespecToTime(data)
+			found = true
+		case h.Level == unix.SOL_SOCKET && h.Type == unix.SCM_TIMESTAMP:
+			ts, err = byteToTime(data)
+			found = true
+		case h.Level == unix.IPPROTO_IP && h.Type == unix.IP_RECVDSTADDR && len(data) >= 4:
+			info.setDst(data[:4])
+		case h.Level == unix.IPPROTO_IP && h.Type == unix.IP_RECVIF && len(data) >= unix.SizeofSockaddrDatalink:
+			sdl := (*unix.RawSockaddrDatalink)(unsafe.Pointer(&data[0]))
+			info.Ifindex = int(sdl.Index)
+		case h.Level == unix.IPPROTO_IPV6 && h.Type == unix.IPV6_PKTINFO && len(data) >= unix.SizeofInet6Pktinfo:
+			pi := (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0]))
+			info.setDst(pi.Addr[:])
+			info.Ifindex = int(pi.Ifindex)
+		case h.Level == unix.IPPROTO_IP && h.Type == unix.IP_RECVTOS && len(data) >= 1:
+			info.TrafficClass = data[0]
+		case h.Level == unix.IPPROTO_IPV6 && h.Type == unix.IPV6_TCLASS && len(data) >= 4:
+			info.TrafficClass = uint8(*(*int32)(unsafe.Pointer(&data[0])))
+		}
+	}
+	if !found {
+		return ts, fmt.Errorf("failed to find timestamp in socket control message")
+	}
+	return ts, err
+}
+
+// socketControlMessageTimestamps returns software timestamp from the message, freebsd has no other ones
+func socketControlMessageTimestamps(b []byte) (*Timestamps, error) {
+	ts, err := socketControlMessageTimestamp(b)
+	if err != nil {
+		return nil, err
+	}
+	return &Timestamps{Software: ts, Selected: SW}, nil
+}
+
+/*
+ReadTXtimestampBuf returns emulated SW TX timestamp. Buffers are not used and only kept for API compatibility.
+The timestamp is taken from the system clock when ReadTXtimestampBuf is called, thus it must be called right after the send call returns.
+The returned value is always later than the moment the packet was handed to the kernel:
+the error is bounded by the time it takes to return from the send syscall plus any scheduling delay before this call,
+which is normally within tens of microseconds on an idle system. Time the packet spends in the driver and NIC queues is not accounted for.
+*/
+func ReadTXtimestampBuf(connFd int, oob, toob []byte) (time.Time, int, error) {
+	ts := time.Now()
+	if !txEmulatedOn(connFd) {
+		return time.Time{}, 1, fmt.Errorf("no TX timestamp found: TX timestamps are not enabled on the socket")
+	}
+	return ts, 1, nil
+}
+
+// ReadTXtimestampContextBuf is like ReadTXtimestampBuf, TX timestamps are emulated so there is nothing to wait for
+func ReadTXtimestampContextBuf(ctx context.Context, connFd int, oob, toob []byte, cfg TXTimestampConfig) (time.Time, int, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, 0, fmt.Errorf("no TX timestamp found after 0 tries: %w", err)
+	}
+	return ReadTXtimestampBuf(connFd, oob, toob)
+}
+
+// ReadPacketsWithRXTimestampsBuf reads packets into provided buffers and fills packets with the payload, client address and RX timestamp.
+// Returns number of packets read. At most one packet is read per call: x/sys/unix doesn't wrap recvmmsg on FreeBSD, where it's a libc loop over recvmsg anyway.
+func ReadPacketsWithRXTimestampsBuf(connFd int, bufs, oobs [][]byte, packets []RXPacket) (int, error) {
+	if len(bufs) == 0 || len(bufs) != len(oobs) || len(bufs) != len(packets) {
+		return 0, fmt.Errorf("buffers length mismatch: %d bufs, %d oobs, %d packets", len(bufs), len(oobs), len(packets))
+	}
+	bbuf, sa, t, err := ReadPacketWithRXTimestampBuf(connFd, bufs[0], oobs[0])
+	if err != nil {
+		return 0, err
+	}
+	packets[0] = RXPacket{Data: bufs[0][:bbuf], Addr: sa, Timestamp: t}
+	return 1, nil
+}
+
+// NewPacketReader returns PacketReader doing a recvmsg syscall per packet, FreeBSD has no io_uring
+func NewPacketReader(connFd int) PacketReader {
+	return NewRecvmsgReader(connFd)
+}
+
+// DrainErrQueue is a noop on freebsd, there is no socket error queue where TX timestamps could pile up
+func DrainErrQueue(connFd int) (int, error) {
+	return 0, nil
+}
+
+// EnableBusyPoll is not supported on freebsd
+func EnableBusyPoll(connFd int, timeout time.Duration, budget int) error {
+	return fmt.Errorf("busy polling is not supported on freebsd")
+}
+
+// EnableTXTimestampsOptID is not supported on freebsd, TX timestamps are emulated
+func EnableTXTimestampsOptID(connFd int) error {
+	return fmt.Errorf("TX timestamp IDs are not supported on freebsd")
+}
+
+// ReadTXtimestampWithIDBuf is not supported on freebsd, TX timestamps are emulated
+func ReadTXtimestampWithIDBuf(connFd int, oob []byte) (time.Time, uint32, int, error) {
+	return time.Time{}, 0, 0, fmt.Errorf("TX timestamp IDs are not supported on freebsd")
+}
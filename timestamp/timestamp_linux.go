@@ -0,0 +1,697 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/facebook/time/phc"
+	"golang.org/x/sys/unix"
+)
+
+// from include/uapi/linux/net_tstamp.h
+const (
+	// HWTSTAMP_TX_ON int 1
+	hwtstampTXON int32 = 0x00000001
+	// HWTSTAMP_TX_ONESTEP_SYNC int 2
+	hwtstampTXOneStepSync int32 = 0x00000002
+	// HWTSTAMP_FILTER_ALL int 1
+	hwtstampFilterAll int32 = 0x00000001
+	// HWTSTAMP_FILTER_PTP_V2_EVENT int 12
+	hwtstampFilterPTPv2Event int32 = 0x0000000c
+)
+
+// unix.Cmsghdr size differs depending on platform
+var socketControlMessageHeaderOffset = binary.Size(unix.Cmsghdr{})
+
+var timestamping = unix.SO_TIMESTAMPING_NEW
+
+// nativeEndian is the byte order of the host. Kernel structures we parse are in it.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	var i uint16 = 1
+	if (*[2]byte)(unsafe.Pointer(&i))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+func init() {
+	// if kernel is older than 5, it doesn't support unix.SO_TIMESTAMPING_NEW
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err == nil {
+		if uname.Release[0] < '5' {
+			// reading such timestamps on 32bit machines will not work, but we can't support everything
+			timestamping = unix.SO_TIMESTAMPING
+		}
+	}
+}
+
+// Ifreq is a struct for ioctl ethernet manipulation syscalls.
+type ifreq struct {
+	name [unix.IFNAMSIZ]byte
+	data uintptr
+}
+
+// from include/uapi/linux/net_tstamp.h
+type hwtstampСonfig struct {
+	flags    int32
+	txType   int32
+	rxFilter int32
+}
+
+func ioctlTimestamp(fd int, ifname string, txType, filter int32) error {
+	hw := &hwtstampСonfig{
+		flags:    0,
+		txType:   txType,
+		rxFilter: filter,
+	}
+
+	i := &ifreq{data: uintptr(unsafe.Pointer(hw))}
+	copy(i.name[:unix.IFNAMSIZ-1], ifname)
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCSHWTSTAMP, uintptr(unsafe.Pointer(i))); errno != 0 {
+		return fmt.Errorf("failed to run ioctl SIOCSHWTSTAMP: %s (%d)", unix.ErrnoName(errno), errno)
+	}
+	return nil
+}
+
+// sysClassNet is where linux exposes network devices
+var sysClassNet = "/sys/class/net"
+
+// how deep we go looking for physical devices, protects from loops
+const maxDeviceDepth = 8
+
+// physicalDevices returns network devices the interface is stacked upon, i.e. for VLAN on top of a bond it's all bond slaves.
+// Interface which is not stacked upon any other device is returned as is.
+func physicalDevices(iface string) ([]string, error) {
+	return physicalDevicesDepth(iface, 0)
+}
+
+func physicalDevicesDepth(iface string, depth int) ([]string, error) {
+	if depth > maxDeviceDepth {
+		return nil, fmt.Errorf("too many stacked devices under %s", iface)
+	}
+	lowers, err := filepath.Glob(filepath.Join(sysClassNet, iface, "lower_*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(lowers) == 0 {
+		return []string{iface}, nil
+	}
+	res := []string{}
+	for _, l := range lowers {
+		devs, err := physicalDevicesDepth(strings.TrimPrefix(filepath.Base(l), "lower_"), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, devs...)
+	}
+	return res, nil
+}
+
+// ioctlHWTimestamps enables HW timestamps with txType TX mode on all physical devices behind the interface
+func ioctlHWTimestamps(connFd int, iface string, txType int32) error {
+	devs, err := physicalDevices(iface)
+	if err != nil {
+		return err
+	}
+	for _, dev := range devs {
+		if err := ioctlTimestamp(connFd, dev, txType, hwtstampFilterAll); err != nil {
+			if err := ioctlTimestamp(connFd, dev, txType, hwtstampFilterPTPv2Event); err != nil {
+				if dev != iface {
+					return fmt.Errorf("%s (under %s): %w", dev, iface, err)
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EnableHWTimestampsSocket enables HW timestamps on the socket.
+// If iface is a VLAN, bond, etc. HW timestamps are enabled on all the physical devices behind it.
+func EnableHWTimestampsSocket(connFd int, iface string) error {
+	return enableHWTimestampsSocket(connFd, iface, hwtstampTXON)
+}
+
+// GetHWCapabilities returns hardware timestamping capabilities of the interface.
+// For VLANs, bonds, etc. only capabilities supported by all the physical devices behind it are reported.
+func GetHWCapabilities(iface string) (*HWCapabilities, error) {
+	devs, err := physicalDevices(iface)
+	if err != nil {
+		return nil, err
+	}
+	caps := &HWCapabilities{TX: true, RX: true, OneStepSync: true}
+	for _, dev := range devs {
+		info, err := phc.IfaceInfo(dev)
+		if err != nil {
+			return nil, err
+		}
+		caps.TX = caps.TX && info.HWTXTimestamping()
+		caps.RX = caps.RX && info.HWRXTimestamping()
+		caps.OneStepSync = caps.OneStepSync && info.HWTXTimestamping() && info.OneStepTX()
+	}
+	return caps, nil
+}
+
+// EnableOneStepSync checks whether the interface supports HWTSTAMP_TX_ONESTEP_SYNC and if it does, enables HW timestamps on the socket
+// with the NIC inserting TX timestamps into Sync packets, so no Follow Up messages are needed.
+// If one-step isn't supported nothing is changed, which is reflected by OneStepSync in returned capabilities,
+// and the caller is expected to fall back to two-step operation, for example with EnableHWTimestampsSocket.
+func EnableOneStepSync(connFd int, iface string) (*HWCapabilities, error) {
+	caps, err := GetHWCapabilities(iface)
+	if err != nil {
+		return nil, err
+	}
+	if !caps.OneStepSync || !caps.RX {
+		caps.OneStepSync = false
+		return caps, nil
+	}
+	if err := enableHWTimestampsSocket(connFd, iface, hwtstampTXOneStepSync); err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// enableHWTimestampsSocket enables HW timestamps with txType TX mode on the socket
+func enableHWTimestampsSocket(connFd int, iface string, txType int32) error {
+	if err := ioctlHWTimestamps(connFd, iface, txType); err != nil {
+		return err
+	}
+
+	// Enable hardware timestamp capabilities on socket
+	flags := unix.SOF_TIMESTAMPING_TX_HARDWARE |
+		unix.SOF_TIMESTAMPING_RX_HARDWARE |
+		unix.SOF_TIMESTAMPING_RAW_HARDWARE |
+		unix.SOF_TIMESTAMPING_OPT_TSONLY // Makes the kernel return the timestamp as a cmsg alongside an empty packet, as opposed to alongside the original packet.
+	// Allow reading of HW timestamps via socket
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags); err != nil {
+		return err
+	}
+
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_SELECT_ERR_QUEUE, 1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EnableHWTimestampsRx enables HW RX timestamps on the socket.
+// It replaces any timestamping flags previously set on the socket.
+func EnableHWTimestampsRx(connFd int, iface string) error {
+	if err := ioctlHWTimestamps(connFd, iface, hwtstampTXON); err != nil {
+		return err
+	}
+
+	flags := unix.SOF_TIMESTAMPING_RX_HARDWARE |
+		unix.SOF_TIMESTAMPING_RAW_HARDWARE
+	// Allow reading of HW timestamps via socket
+	return unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags)
+}
+
+// EnableSWTimestampsSocket enables SW timestamps on the socket
+func EnableSWTimestampsSocket(connFd int) error {
+	flags := unix.SOF_TIMESTAMPING_TX_SOFTWARE |
+		unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+		unix.SOF_TIMESTAMPING_SOFTWARE |
+		unix.SOF_TIMESTAMPING_OPT_TSONLY // Makes the kernel return the timestamp as a cmsg alongside an empty packet, as opposed to alongside the original packet.
+	// Allow reading of SW timestamps via socket
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags); err != nil {
+		return err
+	}
+
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_SELECT_ERR_QUEUE, 1); err != nil {
+		return err
+	}
+	return nil
+}
+
+// EnableSWTimestampsRx enables SW RX timestamps on the socket.
+// It replaces any timestamping flags previously set on the socket, use EnableSWTimestampsSocket to get both RX and TX timestamps.
+func EnableSWTimestampsRx(connFd int) error {
+	flags := unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+		unix.SOF_TIMESTAMPING_SOFTWARE
+	// Allow reading of SW timestamps via socket
+	return unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags)
+}
+
+// EnableSWTimestampsTx enables SW TX timestamps on the socket.
+// It replaces any timestamping flags previously set on the socket, use EnableSWTimestampsSocket to get both RX and TX timestamps.
+func EnableSWTimestampsTx(connFd int) error {
+	flags := unix.SOF_TIMESTAMPING_TX_SOFTWARE |
+		unix.SOF_TIMESTAMPING_SOFTWARE |
+		unix.SOF_TIMESTAMPING_OPT_TSONLY
+	// Allow reading of SW timestamps via socket
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags); err != nil {
+		return err
+	}
+
+	return unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_SELECT_ERR_QUEUE, 1)
+}
+
+// EnableTXTimestampsOptID adds SOF_TIMESTAMPING_OPT_ID to the timestamping flags already enabled on the socket.
+// Every TX timestamp then carries the ID of the packet, which is a counter of packets sent via the socket starting from 0.
+func EnableTXTimestampsOptID(connFd int) error {
+	return addTXTimestampingFlags(connFd, unix.SOF_TIMESTAMPING_OPT_ID)
+}
+
+// addTXTimestampingFlags adds flags to the timestamping flags already enabled on the socket, TX timestamps must be enabled
+func addTXTimestampingFlags(connFd int, add int) error {
+	flags, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, timestamping)
+	if err != nil {
+		return err
+	}
+	if flags&(unix.SOF_TIMESTAMPING_TX_HARDWARE|unix.SOF_TIMESTAMPING_TX_SOFTWARE) == 0 {
+		return fmt.Errorf("TX timestamps are not enabled on the socket")
+	}
+	return unix.SetsockoptInt(connFd, unix.SOL_SOCKET, timestamping, flags|add)
+}
+
+// EnableBusyPoll enables busy polling of the device queue on the socket for up to timeout when there is no data,
+// which reduces RX latency and its jitter at the cost of CPU. budget is a number of packets processed per poll, 0 keeps the kernel default.
+// Raising either of them above the current value requires CAP_NET_ADMIN.
+func EnableBusyPoll(connFd int, timeout time.Duration, budget int) error {
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_BUSY_POLL, int(timeout.Microseconds())); err != nil {
+		return fmt.Errorf("failed to set SO_BUSY_POLL: %w", err)
+	}
+	if budget == 0 {
+		return nil
+	}
+	if err := unix.SetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_BUSY_POLL_BUDGET, budget); err != nil {
+		return fmt.Errorf("failed to set SO_BUSY_POLL_BUDGET: %w", err)
+	}
+	return nil
+}
+
+// byteToTime converts bytes in host byte order into a timestamp
+func byteToTime(data []byte) (time.Time, error) {
+	// __kernel_timespec from linux/time_types.h
+	// can't use unix.Timespec which is old timespec that uses 32bit ints on 386 platform.
+	sec := int64(nativeEndian.Uint64(data[0:8]))
+	nsec := int64(nativeEndian.Uint64(data[8:]))
+	return time.Unix(sec, nsec), nil
+}
+
+// socketControlMessageTimestamp is a very optimised version of ParseSocketControlMessage
+// https://github.com/golang/go/blob/2ebe77a2fda1ee9ff6fd9a3e08933ad1ebaea039/src/syscall/sockcmsg_unix.go#L40
+// which only parses the timestamp message type.
+func socketControlMessageTimestamp(b []byte) (time.Time, error) {
+	mlen := 0
+	for i := 0; i < len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		mlen = int(h.Len)
+
+		// depending on the kernel version, when we ask for SO_TIMESTAMPING_NEW we still might get messages with type SO_TIMESTAMPING
+		if h.Level == unix.SOL_SOCKET && int(h.Type) == unix.SO_TIMESTAMPING_NEW || int(h.Type) == unix.SO_TIMESTAMPING {
+			return scmDataToTime(b[i+socketControlMessageHeaderOffset : i+mlen])
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to find timestamp in socket control message")
+}
+
+/*
+scmDataToTime parses SocketControlMessage Data field into time.Time.
+The structure can return up to three timestamps. This is a legacy
+feature. Only one field is non-zero at any time. Most timestamps
+are passed in ts[0]. Hardware timestamps are passed in ts[2].
+*/
+func scmDataToTime(data []byte) (ts time.Time, err error) {
+	// 2 x 64bit ints
+	size := 16
+	// first, try to use hardware timestamps
+	ts, err = byteToTime(data[size*2 : size*3])
+	if err != nil {
+		return ts, err
+	}
+	// if hw timestamps aren't present, use software timestamps
+	// we can't use ts.IsZero because for some crazy reason timestamp parsed using time.Unix()
+	// reports IsZero() == false, even if seconds and nanoseconds are zero.
+	if ts.UnixNano() == 0 {
+		ts, err = byteToTime(data[0:size])
+		if err != nil {
+			return ts, err
+		}
+		if ts.UnixNano() == 0 {
+			getStats().IncTSZero()
+			return ts, fmt.Errorf("got zero timestamp")
+		}
+	}
+
+	return ts, nil
+}
+
+// socketControlMessageTimestamps is like socketControlMessageTimestamp, but returns all timestamps from the message
+func socketControlMessageTimestamps(b []byte) (*Timestamps, error) {
+	mlen := 0
+	for i := 0; i < len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		mlen = int(h.Len)
+
+		if h.Level == unix.SOL_SOCKET && (int(h.Type) == unix.SO_TIMESTAMPING_NEW || int(h.Type) == unix.SO_TIMESTAMPING) {
+			return scmDataToTimestamps(b[i+socketControlMessageHeaderOffset : i+mlen])
+		}
+	}
+	return nil, fmt.Errorf("failed to find timestamp in socket control message")
+}
+
+// scmDataToTimestamps parses all three timestamps of SocketControlMessage Data field
+func scmDataToTimestamps(data []byte) (*Timestamps, error) {
+	// 2 x 64bit ints
+	size := 16
+	if len(data) < size*3 {
+		return nil, fmt.Errorf("timestamping message is too short: %d bytes", len(data))
+	}
+	ts := &Timestamps{}
+	ts.Software, _ = byteToTime(data[0:size])
+	ts.Legacy, _ = byteToTime(data[size : size*2])
+	ts.Hardware, _ = byteToTime(data[size*2 : size*3])
+	switch {
+	case ts.Hardware.UnixNano() != 0:
+		ts.Selected = HW
+	case ts.Software.UnixNano() != 0:
+		ts.Selected = SW
+	default:
+		getStats().IncTSZero()
+		return ts, fmt.Errorf("got zero timestamp")
+	}
+	return ts, nil
+}
+
+// enablePacketInfo4 enables IP_PKTINFO messages on IPv4 socket
+func enablePacketInfo4(connFd int) error {
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IP, unix.IP_PKTINFO, 1)
+}
+
+// enablePacketInfo6 enables IPV6_PKTINFO messages on IPv6 socket, linux sends them for IPv4 packets as well
+func enablePacketInfo6(connFd int) error {
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IPV6, unix.IPV6_RECVPKTINFO, 1)
+}
+
+// from include/uapi/linux/in6.h, not available in golang.org/x/sys
+const (
+	ipv6FlowInfo      = 11
+	ipv6FlowLabelMask = 0x000FFFFF
+)
+
+// enableTrafficClassInfo4 enables IP_TOS messages on the socket
+func enableTrafficClassInfo4(connFd int) error {
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+}
+
+// enableTrafficClassInfo6 enables IPV6_TCLASS and IPV6_FLOWINFO messages on IPv6 socket
+func enableTrafficClassInfo6(connFd int) error {
+	if err := unix.SetsockoptInt(connFd, unix.IPPROTO_IPV6, unix.IPV6_RECVTCLASS, 1); err != nil {
+		return err
+	}
+	return unix.SetsockoptInt(connFd, unix.IPPROTO_IPV6, ipv6FlowInfo, 1)
+}
+
+// socketControlMessageTimestampInfo is like socketControlMessageTimestamp, but also fills info from IP_PKTINFO/IPV6_PKTINFO,
+// IP_TOS/IPV6_TCLASS and IPV6_FLOWINFO messages
+func socketControlMessageTimestampInfo(b []byte, info *PacketInfo) (time.Time, error) {
+	var ts time.Time
+	var err error
+	found := false
+	mlen := 0
+	for i := 0; i+socketControlMessageHeaderOffset <= len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		if int(h.Len) < socketControlMessageHeaderOffset || i+int(h.Len) > len(b) {
+			break
+		}
+		// IP_PKTINFO message length is not aligned, next message starts at the aligned offset
+		mlen = unix.CmsgSpace(int(h.Len) - socketControlMessageHeaderOffset)
+		data := b[i+socketControlMessageHeaderOffset : i+int(h.Len)]
+
+		switch {
+		case h.Level == unix.SOL_SOCKET && (int(h.Type) == unix.SO_TIMESTAMPING_NEW || int(h.Type) == unix.SO_TIMESTAMPING):
+			ts, err = scmDataToTime(data)
+			found = true
+		case h.Level == unix.IPPROTO_IP && h.Type == unix.IP_PKTINFO && len(data) >= unix.SizeofInet4Pktinfo:
+			pi := (*unix.Inet4Pktinfo)(unsafe.Pointer(&data[0]))
+			info.setDst(pi.Addr[:])
+			info.Ifindex = int(pi.Ifindex)
+		case h.Level == unix.IPPROTO_IPV6 && h.Type == unix.IPV6_PKTINFO && len(data) >= unix.SizeofInet6Pktinfo:
+			pi := (*unix.Inet6Pktinfo)(unsafe.Pointer(&data[0]))
+			info.setDst(pi.Addr[:])
+			info.Ifindex = int(pi.Ifindex)
+		case h.Level == unix.IPPROTO_IP && h.Type == unix.IP_TOS && len(data) >= 1:
+			info.TrafficClass = data[0]
+		case h.Level == unix.IPPROTO_IPV6 && h.Type == unix.IPV6_TCLASS && len(data) >= 4:
+			info.TrafficClass = uint8(nativeEndian.Uint32(data))
+		case h.Level == unix.IPPROTO_IPV6 && h.Type == ipv6FlowInfo && len(data) >= 4:
+			// flow info is in network byte order
+			info.FlowLabel = binary.BigEndian.Uint32(data) & ipv6FlowLabelMask
+		}
+	}
+	if !found {
+		return ts, fmt.Errorf("failed to find timestamp in socket control message")
+	}
+	return ts, err
+}
+
+// socketControlMessageTXID returns ID of the packet from IP_RECVERR/IPV6_RECVERR message which comes along with TX timestamp
+// when SOF_TIMESTAMPING_OPT_ID is enabled.
+func socketControlMessageTXID(b []byte) (uint32, error) {
+	mlen := 0
+	for i := 0; i+socketControlMessageHeaderOffset <= len(b); i += mlen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&b[i]))
+		if int(h.Len) < socketControlMessageHeaderOffset || i+int(h.Len) > len(b) {
+			break
+		}
+		// IPV6_RECVERR message length is not aligned, next message starts at the aligned offset
+		mlen = unix.CmsgSpace(int(h.Len) - socketControlMessageHeaderOffset)
+
+		if (h.Level == unix.SOL_IP && h.Type == unix.IP_RECVERR) || (h.Level == unix.SOL_IPV6 && h.Type == unix.IPV6_RECVERR) {
+			if int(h.Len)-socketControlMessageHeaderOffset < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+				break
+			}
+			serr := (*unix.SockExtendedErr)(unsafe.Pointer(&b[i+socketControlMessageHeaderOffset]))
+			if serr.Origin == unix.SO_EE_ORIGIN_TIMESTAMPING {
+				return serr.Data, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("failed to find packet ID in socket control message")
+}
+
+// waitForHWTS waits up to timeout for something to appear in the socket error queue and reports whether it did.
+// Error queue is always reported as POLLERR, and also as POLLPRI if SO_SELECT_ERR_QUEUE is set.
+func waitForHWTS(connFd int, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	fds := []unix.PollFd{{Fd: int32(connFd), Events: unix.POLLPRI | unix.POLLERR, Revents: 0}}
+	for {
+		ts := unix.NsecToTimespec(timeout.Nanoseconds())
+		n, err := unix.Ppoll(fds, &ts, nil)
+		if err == unix.EINTR {
+			// interrupted by a signal, keep waiting for the rest of the time
+			if timeout = time.Until(deadline); timeout < 0 {
+				timeout = 0
+			}
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return n > 0 && fds[0].Revents&(unix.POLLPRI|unix.POLLERR) != 0, nil
+	}
+}
+
+// recvoob receives only OOB message from the socket
+// This is used for TX timestamp read of MSG_ERRQUEUE where we couldn't care less about other data.
+// This is partially based on Recvmsg
+// https://github.com/golang/go/blob/2ebe77a2fda1ee9ff6fd9a3e08933ad1ebaea039/src/syscall/syscall_linux.go#L647
+func recvoob(connFd int, oob []byte) (oobn int, err error) {
+	var msg unix.Msghdr
+	msg.Control = &oob[0]
+	msg.SetControllen(len(oob))
+	_, _, e1 := unix.Syscall(unix.SYS_RECVMSG, uintptr(connFd), uintptr(unsafe.Pointer(&msg)), uintptr(unix.MSG_ERRQUEUE))
+	if e1 != 0 {
+		return 0, e1
+	}
+	return int(msg.Controllen), nil
+}
+
+// maximum number of messages DrainErrQueue discards in one go, protects from spinning on a busy socket forever
+const maxDrain = 1024
+
+// DrainErrQueue discards everything currently in the socket error queue, such as TX timestamps nobody read, without blocking.
+// Returns the number of messages discarded.
+func DrainErrQueue(connFd int) (int, error) {
+	oob := make([]byte, ControlSizeBytes)
+	var msg unix.Msghdr
+	drained := 0
+	for ; drained < maxDrain; drained++ {
+		msg.Control = &oob[0]
+		msg.SetControllen(len(oob))
+		_, _, e1 := unix.Syscall(unix.SYS_RECVMSG, uintptr(connFd), uintptr(unsafe.Pointer(&msg)), uintptr(unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT))
+		if e1 == unix.EAGAIN {
+			break
+		}
+		if e1 != 0 {
+			return drained, fmt.Errorf("failed to drain error queue: %w", e1)
+		}
+		getStats().IncTXTSDrained()
+	}
+	return drained, nil
+}
+
+// ReadTXtimestampBuf returns HW TX timestamp, needs to be provided 2 buffers which all can be re-used after ReadTXtimestampBuf finishes.
+func ReadTXtimestampBuf(connFd int, oob, toob []byte) (time.Time, int, error) {
+	return ReadTXtimestampContextBuf(context.Background(), connFd, oob, toob, DefaultTXTimestampConfig)
+}
+
+// ReadTXtimestampContextBuf is like ReadTXtimestampBuf, but gives up when ctx is done and retries as per cfg.
+func ReadTXtimestampContextBuf(ctx context.Context, connFd int, oob, toob []byte, cfg TXTimestampConfig) (time.Time, int, error) {
+	// Accessing hw timestamp
+	var boob int
+
+	txfound := false
+
+	// Sometimes we end up with more than 1 TX TS in the buffer.
+	// We need to empty it and completely otherwise we end up with a shifted queue read:
+	// Sync is out -> read TS from the previous Sync
+	// Because we always perform at least 2 tries we start with 0 so on success we are at 1.
+	attempts := 0
+	for ; cfg.MaxAttempts == 0 || attempts < cfg.MaxAttempts; attempts++ {
+		if !txfound {
+			if err := ctx.Err(); err != nil {
+				getStats().IncTXTSMissing()
+				return time.Time{}, attempts, fmt.Errorf("no TX timestamp found after %d tries: %w", attempts, err)
+			}
+			timeout := cfg.RetryInterval
+			if deadline, ok := ctx.Deadline(); ok {
+				if left := time.Until(deadline); left < timeout {
+					timeout = left
+				}
+			}
+			if timeout < 0 {
+				timeout = 0
+			}
+			// Wait for the poll event. If nothing shows up there is no point in reading the queue
+			if ready, err := waitForHWTS(connFd, timeout); err == nil && !ready {
+				continue
+			}
+		}
+
+		tboob, err := recvoob(connFd, toob)
+		if err != nil {
+			// We've already seen the valid TX TS and now we have an empty queue.
+			// All good
+			if txfound {
+				break
+			}
+			// Keep looking for a valid TX TS
+			continue
+		}
+		// We found a valid TX TS. Still check more if there is a newer one
+		if txfound {
+			// The one we found before belongs to some previous packet
+			getStats().IncTXTSDrained()
+		}
+		txfound = true
+		boob = tboob
+		copy(oob, toob)
+	}
+
+	if !txfound {
+		getStats().IncTXTSMissing()
+		return time.Time{}, attempts, fmt.Errorf("no TX timestamp found after %d tries", attempts)
+	}
+	timestamp, err := socketControlMessageTimestamp(oob[:boob])
+	return timestamp, attempts, err
+}
+
+// from include/linux/socket.h
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// ReadPacketsWithRXTimestampsBuf reads up to len(bufs) packets with a single recvmmsg call.
+// It writes packets into provided buffers and fills packets with the payload, client address and RX timestamp.
+// Blocks until at least one packet is available. Returns number of packets read.
+// If a timestamp of any of the packets can't be parsed, all packets are still returned along with the first error.
+// bufs and oobs can be reused after ReadPacketsWithRXTimestampsBuf call.
+func ReadPacketsWithRXTimestampsBuf(connFd int, bufs, oobs [][]byte, packets []RXPacket) (int, error) {
+	n := len(bufs)
+	if n == 0 || n != len(oobs) || n != len(packets) {
+		return 0, fmt.Errorf("buffers length mismatch: %d bufs, %d oobs, %d packets", len(bufs), len(oobs), len(packets))
+	}
+
+	msgs := make([]mmsghdr, n)
+	iovs := make([]unix.Iovec, n)
+	names := make([]unix.RawSockaddrAny, n)
+	for i := 0; i < n; i++ {
+		iovs[i].Base = &bufs[i][0]
+		iovs[i].SetLen(len(bufs[i]))
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		msgs[i].hdr.Namelen = uint32(unix.SizeofSockaddrAny)
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.SetIovlen(1)
+		msgs[i].hdr.Control = &oobs[i][0]
+		msgs[i].hdr.SetControllen(len(oobs[i]))
+	}
+
+	r, _, e1 := unix.Syscall6(unix.SYS_RECVMMSG, uintptr(connFd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(n), uintptr(unix.MSG_WAITFORONE), 0, 0)
+	if e1 != 0 {
+		return 0, fmt.Errorf("failed to read packets: %v", e1)
+	}
+
+	var err error
+	got := int(r)
+	for i := 0; i < got; i++ {
+		packets[i].Data = bufs[i][:msgs[i].len]
+		packets[i].Addr = rawToSockaddr(&names[i])
+		ts, terr := socketControlMessageTimestamp(oobs[i][:msgs[i].hdr.Controllen])
+		if terr != nil && err == nil {
+			err = terr
+		}
+		packets[i].Timestamp = ts
+	}
+	return got, err
+}
+
+// ReadTXtimestampWithIDBuf returns the oldest TX timestamp in the socket error queue along with the ID of the packet it belongs to.
+// Unlike ReadTXtimestampBuf it doesn't drain the queue, so callers can match timestamps of multiple packets in flight.
+// Requires EnableTXTimestampsOptID. oob buffer can be reused after ReadTXtimestampWithIDBuf call.
+func ReadTXtimestampWithIDBuf(connFd int, oob []byte) (time.Time, uint32, int, error) {
+	attempts := 1
+	for ; attempts <= maxTXTS; attempts++ {
+		if ready, err := waitForHWTS(connFd, DefaultTXTimestampConfig.RetryInterval); err == nil && !ready {
+			continue
+		}
+
+		boob, err := recvoob(connFd, oob)
+		if err != nil {
+			continue
+		}
+		id, err := socketControlMessageTXID(oob[:boob])
+		if err != nil {
+			return time.Time{}, 0, attempts, err
+		}
+		timestamp, err := socketControlMessageTimestamp(oob[:boob])
+		return timestamp, id, attempts, err
+	}
+	getStats().IncTXTSMissing()
+	return time.Time{}, 0, maxTXTS, fmt.Errorf("no TX timestamp found after %d tries", maxTXTS)
+}
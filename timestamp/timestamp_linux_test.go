@@ -0,0 +1,529 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func Test_byteToTimeNativeEndian(t *testing.T) {
+	timeb := make([]byte, 16)
+	nativeEndian.PutUint64(timeb[0:8], 1612028735)
+	nativeEndian.PutUint64(timeb[8:], 717200436)
+	res, err := byteToTime(timeb)
+	require.Nil(t, err)
+
+	require.Equal(t, int64(1612028735717200436), res.UnixNano())
+}
+
+func TestNativeEndian(t *testing.T) {
+	switch runtime.GOARCH {
+	case "s390x", "ppc64", "mips", "mips64":
+		require.Equal(t, binary.BigEndian, nativeEndian)
+	case "amd64", "386", "arm", "arm64", "ppc64le", "riscv64":
+		require.Equal(t, binary.LittleEndian, nativeEndian)
+	}
+}
+
+// Testing conversion so if Packet structure changes we notice
+func Test_byteToTime(t *testing.T) {
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("This test uses little-endian sample")
+	}
+	timeb := []byte{63, 155, 21, 96, 0, 0, 0, 0, 52, 156, 191, 42, 0, 0, 0, 0}
+	res, err := byteToTime(timeb)
+	require.Nil(t, err)
+
+	require.Equal(t, int64(1612028735717200436), res.UnixNano())
+}
+
+func Test_ReadTXtimestamp(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	txts, attempts, err := ReadTXtimestamp(connFd)
+	require.Equal(t, time.Time{}, txts)
+	require.Equal(t, maxTXTS, attempts)
+	require.Equal(t, fmt.Errorf("no TX timestamp found after %d tries", maxTXTS), err)
+
+	err = EnableSWTimestampsSocket(connFd)
+	require.Nil(t, err)
+
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	_, err = conn.WriteTo([]byte{}, addr)
+	require.Nil(t, err)
+	txts, attempts, err = ReadTXtimestamp(connFd)
+
+	require.NotEqual(t, time.Time{}, txts)
+	require.Equal(t, 1, attempts)
+	require.Nil(t, err)
+
+}
+
+func Test_scmDataToTime(t *testing.T) {
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("This test uses little-endian sample")
+	}
+	hwData := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		63, 155, 21, 96, 0, 0, 0, 0, 52, 156, 191, 42, 0, 0, 0, 0,
+	}
+	swData := []byte{
+		63, 155, 21, 96, 0, 0, 0, 0, 52, 156, 191, 42, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	noData := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    int64
+		wantErr bool
+	}{
+		{
+			name:    "hardware timestamp",
+			data:    hwData,
+			want:    1612028735717200436,
+			wantErr: false,
+		},
+		{
+			name:    "software timestamp",
+			data:    swData,
+			want:    1612028735717200436,
+			wantErr: false,
+		},
+		{
+			name:    "zero timestamp",
+			data:    noData,
+			want:    0,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := scmDataToTime(tt.data)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.Nil(t, err)
+				require.Equal(t, tt.want, res.UnixNano())
+			}
+		})
+	}
+}
+
+func Test_scmDataToTimestamps(t *testing.T) {
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("This test uses little-endian sample")
+	}
+	data := []byte{
+		63, 155, 21, 96, 0, 0, 0, 0, 52, 156, 191, 42, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		63, 155, 21, 96, 0, 0, 0, 0, 42, 156, 191, 42, 0, 0, 0, 0,
+	}
+	ts, err := scmDataToTimestamps(data)
+	require.Nil(t, err)
+	require.Equal(t, int64(1612028735717200436), ts.Software.UnixNano())
+	require.Equal(t, int64(0), ts.Legacy.UnixNano())
+	require.Equal(t, int64(1612028735717200426), ts.Hardware.UnixNano())
+	require.Equal(t, HW, ts.Selected)
+	require.Equal(t, ts.Hardware, ts.Time())
+
+	// no hardware timestamp
+	copy(data[32:], make([]byte, 16))
+	ts, err = scmDataToTimestamps(data)
+	require.Nil(t, err)
+	require.Equal(t, SW, ts.Selected)
+	require.Equal(t, ts.Software, ts.Time())
+
+	// no timestamps at all
+	copy(data[0:], make([]byte, 16))
+	_, err = scmDataToTimestamps(data)
+	require.Error(t, err)
+
+	_, err = scmDataToTimestamps(data[:16])
+	require.Error(t, err)
+}
+
+func TestSocketControlMessageTimestamp(t *testing.T) {
+	if timestamping != unix.SO_TIMESTAMPING_NEW {
+		t.Skip("This test supports SO_TIMESTAMPING_NEW only. No sample of SO_TIMESTAMPING")
+	}
+
+	var b []byte
+
+	// unix.Cmsghdr used in socketControlMessageTimestamp differs depending on platform
+	switch runtime.GOARCH {
+	case "amd64":
+		b = []byte{60, 0, 0, 0, 0, 0, 0, 0, 41, 0, 0, 0, 25, 0, 0, 0, 42, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 64, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 65, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 230, 180, 10, 97, 0, 0, 0, 0, 239, 83, 199, 39, 0, 0, 0, 0}
+	case "386":
+		b = []byte{56, 0, 0, 0, 41, 0, 0, 0, 25, 0, 0, 0, 42, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 60, 0, 0, 0, 1, 0, 0, 0, 65, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 230, 180, 10, 97, 0, 0, 0, 0, 239, 83, 199, 39, 0, 0, 0, 0}
+	default:
+		t.Skip("This test supports amd64/386 platforms only")
+	}
+
+	ts, err := socketControlMessageTimestamp(b)
+	require.NoError(t, err)
+	require.Equal(t, int64(1628091622667374575), ts.UnixNano())
+}
+
+func TestEnableSWTimestampsRx(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+
+	flags, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, timestamping)
+	require.Nil(t, err)
+	require.Equal(t, unix.SOF_TIMESTAMPING_RX_SOFTWARE|unix.SOF_TIMESTAMPING_SOFTWARE, flags)
+}
+
+func TestEnableSWTimestampsTx(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsTx(connFd)
+	require.Nil(t, err)
+
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	_, err = conn.WriteTo([]byte{}, addr)
+	require.Nil(t, err)
+	txts, attempts, err := ReadTXtimestamp(connFd)
+	require.Nil(t, err)
+	require.Equal(t, 1, attempts)
+	require.NotEqual(t, time.Time{}, txts)
+}
+
+func TestReadTXtimestampWithID(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableTXTimestampsOptID(connFd)
+	require.Error(t, err)
+
+	err = EnableSWTimestampsSocket(connFd)
+	require.Nil(t, err)
+	err = EnableTXTimestampsOptID(connFd)
+	require.Nil(t, err)
+
+	flags, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, timestamping)
+	require.Nil(t, err)
+	require.NotZero(t, flags&unix.SOF_TIMESTAMPING_OPT_ID)
+
+	// several packets in flight
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	for i := 0; i < 3; i++ {
+		_, err = conn.WriteTo([]byte{}, addr)
+		require.Nil(t, err)
+	}
+	for i := uint32(0); i < 3; i++ {
+		txts, id, _, err := ReadTXtimestampWithID(connFd)
+		require.Nil(t, err)
+		require.NotEqual(t, time.Time{}, txts)
+		require.Equal(t, i, id)
+	}
+}
+
+func TestSocketControlMessageTXID(t *testing.T) {
+	serr := unix.SockExtendedErr{Errno: uint32(unix.ENOMSG), Origin: unix.SO_EE_ORIGIN_TIMESTAMPING, Data: 42}
+	data := (*[unsafe.Sizeof(serr)]byte)(unsafe.Pointer(&serr))[:]
+
+	// IPV6_RECVERR with sockaddr_in6 offender, which makes the length unaligned
+	b := make([]byte, unix.CmsgSpace(len(data)+unix.SizeofSockaddrInet6))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.SOL_IPV6
+	h.Type = unix.IPV6_RECVERR
+	h.SetLen(unix.CmsgLen(len(data) + unix.SizeofSockaddrInet6))
+	copy(b[unix.CmsgLen(0):], data)
+
+	id, err := socketControlMessageTXID(b)
+	require.Nil(t, err)
+	require.Equal(t, uint32(42), id)
+
+	_, err = socketControlMessageTXID(make([]byte, 64))
+	require.Error(t, err)
+}
+
+func TestReadTXtimestampContext(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	cfg := TXTimestampConfig{RetryInterval: 100 * time.Microsecond, MaxAttempts: 5}
+	_, attempts, err := ReadTXtimestampContext(context.Background(), connFd, cfg)
+	require.Equal(t, 5, attempts)
+	require.EqualError(t, err, "no TX timestamp found after 5 tries")
+
+	// no limit on attempts, context decides
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	cfg = TXTimestampConfig{RetryInterval: time.Millisecond}
+	start := time.Now()
+	_, _, err = ReadTXtimestampContext(ctx, connFd, cfg)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	err = EnableSWTimestampsSocket(connFd)
+	require.Nil(t, err)
+
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	_, err = conn.WriteTo([]byte{}, addr)
+	require.Nil(t, err)
+	txts, attempts, err := ReadTXtimestampContext(context.Background(), connFd, DefaultTXTimestampConfig)
+	require.Nil(t, err)
+	require.NotEqual(t, time.Time{}, txts)
+	require.Equal(t, 1, attempts)
+}
+
+func TestTXTimestampStats(t *testing.T) {
+	c := &Counters{}
+	SetStats(c)
+	defer SetStats(nil)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	_, _, err = ReadTXtimestampContext(context.Background(), connFd, TXTimestampConfig{RetryInterval: 100 * time.Microsecond, MaxAttempts: 2})
+	require.Error(t, err)
+	require.Equal(t, int64(1), c.TXTSMissing)
+
+	err = EnableSWTimestampsSocket(connFd)
+	require.Nil(t, err)
+
+	// two packets in flight, TX timestamp of the first one is stale by the time we read
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
+	for i := 0; i < 2; i++ {
+		_, err = conn.WriteTo([]byte{}, addr)
+		require.Nil(t, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, _, err = ReadTXtimestamp(connFd)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), c.TXTSDrained)
+
+	_, err = scmDataToTime(make([]byte, 48))
+	require.Error(t, err)
+	require.Equal(t, int64(1), c.TSZero)
+}
+
+func TestPhysicalDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysclassnet")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(old string) { sysClassNet = old }(sysClassNet)
+	sysClassNet = dir
+
+	// vlan100 -> bond0 -> eth0, eth1, plus standalone eth2
+	for _, d := range []string{"vlan100", "bond0", "eth0", "eth1", "eth2"} {
+		require.Nil(t, os.Mkdir(filepath.Join(dir, d), 0755))
+	}
+	require.Nil(t, os.Symlink("../bond0", filepath.Join(dir, "vlan100", "lower_bond0")))
+	require.Nil(t, os.Symlink("../eth0", filepath.Join(dir, "bond0", "lower_eth0")))
+	require.Nil(t, os.Symlink("../eth1", filepath.Join(dir, "bond0", "lower_eth1")))
+
+	devs, err := physicalDevices("eth2")
+	require.Nil(t, err)
+	require.Equal(t, []string{"eth2"}, devs)
+
+	devs, err = physicalDevices("bond0")
+	require.Nil(t, err)
+	require.Equal(t, []string{"eth0", "eth1"}, devs)
+
+	devs, err = physicalDevices("vlan100")
+	require.Nil(t, err)
+	require.Equal(t, []string{"eth0", "eth1"}, devs)
+
+	// loop
+	require.Nil(t, os.Symlink("../vlan100", filepath.Join(dir, "eth2", "lower_vlan100")))
+	require.Nil(t, os.Symlink("../eth2", filepath.Join(dir, "eth1", "lower_eth2")))
+	_, err = physicalDevices("vlan100")
+	require.Error(t, err)
+}
+
+func TestEnableBusyPoll(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableBusyPoll(connFd, 50*time.Microsecond, 0)
+	if errors.Is(err, unix.EPERM) {
+		t.Skip("no CAP_NET_ADMIN")
+	}
+	require.Nil(t, err)
+	v, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_BUSY_POLL)
+	require.Nil(t, err)
+	require.Equal(t, 50, v)
+
+	// kernel doesn't allow reading SO_BUSY_POLL_BUDGET back
+	err = EnableBusyPoll(connFd, 50*time.Microsecond, 16)
+	require.Nil(t, err)
+}
+
+func TestEnableOneStepSync(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	// loopback has no hardware timestamps, so nothing is enabled and the caller is expected to fall back
+	caps, err := GetHWCapabilities("lo")
+	require.Nil(t, err)
+	require.Equal(t, &HWCapabilities{}, caps)
+
+	caps, err = EnableOneStepSync(connFd, "lo")
+	require.Nil(t, err)
+	require.False(t, caps.OneStepSync)
+	flags, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, timestamping)
+	require.Nil(t, err)
+	require.Equal(t, 0, flags)
+
+	_, err = EnableOneStepSync(connFd, "nonexistent0")
+	require.Error(t, err)
+}
+
+func TestWaitForHWTS(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	err = EnableSWTimestampsTx(connFd)
+	require.Nil(t, err)
+
+	// nothing in the error queue
+	start := time.Now()
+	ready, err := waitForHWTS(connFd, 10*time.Millisecond)
+	require.Nil(t, err)
+	require.False(t, ready)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(10*time.Millisecond))
+
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+	ready, err = waitForHWTS(connFd, time.Second)
+	require.Nil(t, err)
+	require.True(t, ready)
+}
+
+func TestSocketControlMessageTimestampInfoFlowLabel(t *testing.T) {
+	oob := make([]byte, 2*ControlSizeBytes)
+	n := 0
+	cmsg := func(level, typ int32, data []byte) {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[n]))
+		h.Level = level
+		h.Type = typ
+		h.SetLen(unix.CmsgLen(len(data)))
+		copy(oob[n+unix.CmsgLen(0):], data)
+		n += unix.CmsgSpace(len(data))
+	}
+	tclass := make([]byte, 4)
+	nativeEndian.PutUint32(tclass, 46<<2)
+	cmsg(unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tclass)
+	// traffic class 0xb8 and flow label 0x12345 in network byte order
+	cmsg(unix.IPPROTO_IPV6, ipv6FlowInfo, []byte{0x0b, 0x81, 0x23, 0x45})
+	ts := make([]byte, 48)
+	nativeEndian.PutUint64(ts[0:], 1612028735)
+	cmsg(unix.SOL_SOCKET, int32(timestamping), ts)
+
+	info := PacketInfo{}
+	rxts, err := socketControlMessageTimestampInfo(oob[:n], &info)
+	require.Nil(t, err)
+	require.Equal(t, int64(1612028735), rxts.Unix())
+	require.Equal(t, uint8(46), info.DSCP())
+	require.Equal(t, uint32(0x12345), info.FlowLabel)
+}
+
+func TestDrainErrQueue(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	err = EnableSWTimestampsTx(connFd)
+	require.Nil(t, err)
+
+	drained, err := DrainErrQueue(connFd)
+	require.Nil(t, err)
+	require.Equal(t, 0, drained)
+
+	c := &Counters{}
+	SetStats(c)
+	defer SetStats(nil)
+	for i := 0; i < 5; i++ {
+		_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+		require.Nil(t, err)
+	}
+	// software TX timestamps on loopback are there by the time send returns
+	drained, err = DrainErrQueue(connFd)
+	require.Nil(t, err)
+	require.Equal(t, 5, drained)
+	require.Equal(t, int64(5), c.TXTSDrained)
+
+	ready, err := waitForHWTS(connFd, time.Millisecond)
+	require.Nil(t, err)
+	require.False(t, ready)
+}
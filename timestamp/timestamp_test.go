@@ -17,9 +17,10 @@ limitations under the License.
 package timestamp
 
 import (
-	"fmt"
+	"io/ioutil"
 	"net"
-	"runtime"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -27,16 +28,97 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// Testing conversion so if Packet structure changes we notice
-func Test_byteToTime(t *testing.T) {
-	timeb := []byte{63, 155, 21, 96, 0, 0, 0, 0, 52, 156, 191, 42, 0, 0, 0, 0}
-	res, err := byteToTime(timeb)
+func TestIPToSockaddr(t *testing.T) {
+	ip4 := net.ParseIP("127.0.0.1")
+	ip6 := net.ParseIP("::1")
+	port := 123
+
+	expectedSA4 := &unix.SockaddrInet4{Port: port}
+	copy(expectedSA4.Addr[:], ip4.To4())
+
+	expectedSA6 := &unix.SockaddrInet6{Port: port}
+	copy(expectedSA6.Addr[:], ip6.To16())
+
+	sa4 := IPToSockaddr(ip4, port)
+	sa6 := IPToSockaddr(ip6, port)
+
+	require.Equal(t, expectedSA4, sa4)
+	require.Equal(t, expectedSA6, sa6)
+}
+
+func TestSockaddrToIP(t *testing.T) {
+	ip4 := net.ParseIP("127.0.0.1")
+	ip6 := net.ParseIP("::1")
+	port := 123
+
+	sa4 := IPToSockaddr(ip4, port)
+	sa6 := IPToSockaddr(ip6, port)
+
+	require.Equal(t, ip4.String(), SockaddrToIP(sa4).String())
+	require.Equal(t, ip6.String(), SockaddrToIP(sa6).String())
+}
+
+func TestSyscallConnFd(t *testing.T) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer ln.Close()
+	tcp, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	require.Nil(t, err)
+	defer tcp.Close()
+
+	connFd, err := SyscallConnFd(tcp)
+	require.Nil(t, err)
+	sotype, err := unix.GetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_TYPE)
+	require.Nil(t, err)
+	require.Equal(t, unix.SOCK_STREAM, sotype)
+	require.Nil(t, EnableSWTimestampsRx(connFd))
+
+	dir, err := ioutil.TempDir("", "connfd")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	uconn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "sock"), Net: "unixgram"})
+	require.Nil(t, err)
+	defer uconn.Close()
+
+	connFd, err = SyscallConnFd(uconn)
+	require.Nil(t, err)
+	sotype, err = unix.GetsockoptInt(connFd, unix.SOL_SOCKET, unix.SO_TYPE)
+	require.Nil(t, err)
+	require.Equal(t, unix.SOCK_DGRAM, sotype)
+}
+
+func TestTimestampMarshalText(t *testing.T) {
+	for ts, s := range TimestampToString {
+		b, err := ts.MarshalText()
+		require.Nil(t, err)
+		require.Equal(t, s, string(b))
+		require.Equal(t, s, ts.String())
+	}
+
+	_, err := Timestamp(42).MarshalText()
+	require.Error(t, err)
+	require.Equal(t, "unsupported", Timestamp(42).String())
+}
+
+func TestTimestampUnmarshalText(t *testing.T) {
+	for expected, s := range TimestampToString {
+		var ts Timestamp
+		err := ts.UnmarshalText([]byte(s))
+		require.Nil(t, err)
+		require.Equal(t, expected, ts)
+	}
+
+	var ts Timestamp
+	err := ts.Set("hardware_rx")
 	require.Nil(t, err)
+	require.Equal(t, HWRX, ts)
 
-	require.Equal(t, int64(1612028735717200436), res.UnixNano())
+	err = ts.UnmarshalText([]byte("magic"))
+	require.Error(t, err)
+	require.Equal(t, HWRX, ts)
 }
 
-func Test_ReadTXtimestamp(t *testing.T) {
+func TestEnableTimestamps(t *testing.T) {
 	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
 	require.Nil(t, err)
 	defer conn.Close()
@@ -44,128 +126,517 @@ func Test_ReadTXtimestamp(t *testing.T) {
 	connFd, err := ConnFd(conn)
 	require.Nil(t, err)
 
-	txts, attempts, err := ReadTXtimestamp(connFd)
-	require.Equal(t, time.Time{}, txts)
-	require.Equal(t, maxTXTS, attempts)
-	require.Equal(t, fmt.Errorf("no TX timestamp found after %d tries", maxTXTS), err)
+	ts, err := EnableTimestamps(connFd, "lo", SW)
+	require.Nil(t, err)
+	require.Equal(t, SW, ts)
 
-	err = EnableSWTimestampsSocket(connFd)
+	ts, err = EnableTimestamps(connFd, "lo", SWRX)
 	require.Nil(t, err)
+	require.Equal(t, SWRX, ts)
 
-	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 12345}
-	_, err = conn.WriteTo([]byte{}, addr)
+	// loopback has no hardware timestamping, expect fallback to software
+	ts, err = EnableTimestamps(connFd, "lo", HW)
 	require.Nil(t, err)
-	txts, attempts, err = ReadTXtimestamp(connFd)
+	require.Equal(t, SW, ts)
 
-	require.NotEqual(t, time.Time{}, txts)
-	require.Equal(t, 1, attempts)
+	ts, err = EnableTimestamps(connFd, "lo", HWRX)
 	require.Nil(t, err)
+	require.Equal(t, SWRX, ts)
 
+	_, err = EnableTimestamps(connFd, "lo", Timestamp(42))
+	require.Error(t, err)
 }
 
-func Test_scmDataToTime(t *testing.T) {
-	hwData := []byte{
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		63, 155, 21, 96, 0, 0, 0, 0, 52, 156, 191, 42, 0, 0, 0, 0,
+func TestReadPacketsWithRXTimestamps(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+	waitForRXTimestamps(t, conn, connFd)
+
+	start := time.Now()
+	for i := byte(0); i < 3; i++ {
+		_, err = conn.WriteTo([]byte{i, 2, 3}, conn.LocalAddr())
+		require.Nil(t, err)
 	}
-	swData := []byte{
-		63, 155, 21, 96, 0, 0, 0, 0, 52, 156, 191, 42, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+
+	// on some platforms we only get one packet per call
+	packets := []RXPacket{}
+	for len(packets) < 3 {
+		p, err := ReadPacketsWithRXTimestamps(connFd, 10)
+		require.Nil(t, err)
+		require.NotEmpty(t, p)
+		packets = append(packets, p...)
 	}
-	noData := []byte{
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	require.Len(t, packets, 3)
+	for i, p := range packets {
+		require.Equal(t, []byte{byte(i), 2, 3}, p.Data)
+		require.Equal(t, "127.0.0.1", SockaddrToIP(p.Addr).String())
+		require.False(t, p.Timestamp.Before(start.Truncate(time.Microsecond)))
 	}
 
-	tests := []struct {
-		name    string
-		data    []byte
-		want    int64
-		wantErr bool
-	}{
-		{
-			name:    "hardware timestamp",
-			data:    hwData,
-			want:    1612028735717200436,
-			wantErr: false,
-		},
-		{
-			name:    "software timestamp",
-			data:    swData,
-			want:    1612028735717200436,
-			wantErr: false,
-		},
-		{
-			name:    "zero timestamp",
-			data:    noData,
-			want:    0,
-			wantErr: true,
-		},
+	_, err = ReadPacketsWithRXTimestampsBuf(connFd, [][]byte{{}}, nil, nil)
+	require.Error(t, err)
+}
+
+func TestNewPacketReader(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+	waitForRXTimestamps(t, conn, connFd)
+
+	r := NewPacketReader(connFd)
+	defer r.Close()
+
+	start := time.Now()
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	n, sa, rxts, err := r.ReadPacketWithRXTimestampBuf(buf, oob)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.Equal(t, "127.0.0.1", SockaddrToIP(sa).String())
+	require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+}
+
+func TestReadPacketWithRXTimestampBufAddr(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+	waitForRXTimestamps(t, conn, connFd)
+
+	start := time.Now()
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	var rsa unix.RawSockaddrAny
+	n, rxts, err := ReadPacketWithRXTimestampBufAddr(connFd, buf, oob, &rsa)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.Equal(t, "127.0.0.1", SockaddrToIP(rawToSockaddr(&rsa)).String())
+	require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+
+	// queue all packets upfront so only reading is measured
+	runs := 100
+	for i := 0; i <= runs; i++ {
+		_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+		require.Nil(t, err)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			res, err := scmDataToTime(tt.data)
-			if tt.wantErr {
-				require.Error(t, err)
-			} else {
+	allocs := testing.AllocsPerRun(runs, func() {
+		_, _, err = ReadPacketWithRXTimestampBufAddr(connFd, buf, oob, &rsa)
+	})
+	require.Nil(t, err)
+	require.Equal(t, float64(0), allocs)
+}
+
+func TestReadPacketBufAddr(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+	buf := make([]byte, PayloadSizeBytes)
+	var rsa unix.RawSockaddrAny
+	n, err := ReadPacketBufAddr(connFd, buf, &rsa)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	sa := RawSockaddrToSockaddr(&rsa)
+	require.Equal(t, "127.0.0.1", SockaddrToIP(sa).String())
+	require.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, sa.(*unix.SockaddrInet4).Port)
+
+	require.Nil(t, RawSockaddrToSockaddr(&unix.RawSockaddrAny{}))
+}
+
+func TestReadPacketWithRXTimestampBufNoAddr(t *testing.T) {
+	// unnamed unix sockets deliver packets without a source address
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	require.Nil(t, err)
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	err = EnableSWTimestampsRx(fds[1])
+	require.Nil(t, err)
+	// unix sockets only stamp packets with SO_TIMESTAMP enabled
+	err = unix.SetsockoptInt(fds[1], unix.SOL_SOCKET, unix.SO_TIMESTAMP, 1)
+	require.Nil(t, err)
+
+	start := time.Now()
+	_, err = unix.Write(fds[0], []byte{1, 2, 3})
+	require.Nil(t, err)
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	n, sa, rxts, err := ReadPacketWithRXTimestampBuf(fds[1], buf, oob)
+	require.Nil(t, err)
+	require.Nil(t, sa)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+}
+
+func TestReadPacketWithRXTimestampAndInfoBuf(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		lo, err = net.InterfaceByName("lo0")
+	}
+	require.Nil(t, err)
+
+	for _, ip := range []string{"127.0.0.1", "::1"} {
+		t.Run(ip, func(t *testing.T) {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: 0})
+			if err != nil {
+				t.Skipf("can't listen on %s: %v", ip, err)
+			}
+			defer conn.Close()
+
+			connFd, err := ConnFd(conn)
+			require.Nil(t, err)
+
+			err = EnableSWTimestampsRx(connFd)
+			require.Nil(t, err)
+			err = EnablePacketInfo(connFd)
+			require.Nil(t, err)
+			err = unix.SetNonblock(connFd, false)
+			require.Nil(t, err)
+			waitForRXTimestamps(t, conn, connFd)
+
+			start := time.Now()
+			_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+			require.Nil(t, err)
+
+			buf := make([]byte, PayloadSizeBytes)
+			oob := make([]byte, ControlSizeBytes)
+			var rsa unix.RawSockaddrAny
+			info := PacketInfo{Dst: make(net.IP, 0, net.IPv6len)}
+			n, rxts, err := ReadPacketWithRXTimestampAndInfoBuf(connFd, buf, oob, &rsa, &info)
+			require.Nil(t, err)
+			require.Equal(t, []byte{1, 2, 3}, buf[:n])
+			require.Equal(t, ip, info.Dst.String())
+			require.Equal(t, lo.Index, info.Ifindex)
+			require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+
+			// one packet for the warm-up run, one for the measured one
+			for i := 0; i < 2; i++ {
+				_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
 				require.Nil(t, err)
-				require.Equal(t, tt.want, res.UnixNano())
 			}
+			allocs := testing.AllocsPerRun(1, func() {
+				_, _, err = ReadPacketWithRXTimestampAndInfoBuf(connFd, buf, oob, &rsa, &info)
+			})
+			require.Nil(t, err)
+			require.Equal(t, float64(0), allocs)
 		})
 	}
 }
 
-func TestIPToSockaddr(t *testing.T) {
-	ip4 := net.ParseIP("127.0.0.1")
-	ip6 := net.ParseIP("::1")
-	port := 123
+func TestReadPacketWithInfoBuf(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "::1"} {
+		t.Run(ip, func(t *testing.T) {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: 0})
+			if err != nil {
+				t.Skipf("can't listen on %s: %v", ip, err)
+			}
+			defer conn.Close()
 
-	expectedSA4 := &unix.SockaddrInet4{Port: port}
-	copy(expectedSA4.Addr[:], ip4.To4())
+			connFd, err := ConnFd(conn)
+			require.Nil(t, err)
+			err = unix.SetNonblock(connFd, false)
+			require.Nil(t, err)
 
-	expectedSA6 := &unix.SockaddrInet6{Port: port}
-	copy(expectedSA6.Addr[:], ip6.To16())
+			buf := make([]byte, PayloadSizeBytes)
+			oob := make([]byte, ControlSizeBytes)
+			var rsa unix.RawSockaddrAny
+			info := PacketInfo{Dst: make(net.IP, 0, net.IPv6len)}
 
-	sa4 := IPToSockaddr(ip4, port)
-	sa6 := IPToSockaddr(ip6, port)
+			// without packet info enabled Dst stays empty
+			info.Dst = append(info.Dst, 1, 2, 3, 4)
+			_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+			require.Nil(t, err)
+			n, err := ReadPacketWithInfoBuf(connFd, buf, oob, &rsa, &info)
+			require.Nil(t, err)
+			require.Equal(t, []byte{1, 2, 3}, buf[:n])
+			require.Empty(t, info.Dst)
 
-	require.Equal(t, expectedSA4, sa4)
-	require.Equal(t, expectedSA6, sa6)
+			err = EnablePacketInfo(connFd)
+			require.Nil(t, err)
+			_, err = conn.WriteTo([]byte{4, 5}, conn.LocalAddr())
+			require.Nil(t, err)
+			n, err = ReadPacketWithInfoBuf(connFd, buf, oob, &rsa, &info)
+			require.Nil(t, err)
+			require.Equal(t, []byte{4, 5}, buf[:n])
+			require.Equal(t, ip, info.Dst.String())
+			require.Equal(t, ip, SockaddrToIP(RawSockaddrToSockaddr(&rsa)).String())
+		})
+	}
 }
 
-func TestSockaddrToIP(t *testing.T) {
-	ip4 := net.ParseIP("127.0.0.1")
-	ip6 := net.ParseIP("::1")
-	port := 123
+func TestSendmsgWithDrain(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
 
-	sa4 := IPToSockaddr(ip4, port)
-	sa6 := IPToSockaddr(ip6, port)
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
 
-	require.Equal(t, ip4.String(), SockaddrToIP(sa4).String())
-	require.Equal(t, ip6.String(), SockaddrToIP(sa6).String())
+	to := &unix.SockaddrInet4{Port: conn.LocalAddr().(*net.UDPAddr).Port, Addr: [4]byte{127, 0, 0, 1}}
+	err = SendmsgWithDrain(connFd, []byte{1, 2, 3}, nil, to)
+	require.Nil(t, err)
+
+	buf := make([]byte, PayloadSizeBytes)
+	n, _, err := conn.ReadFrom(buf)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+}
+
+func TestReadPacketWithRXTimestampAndInfoBufTrafficClass(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "::1"} {
+		t.Run(ip, func(t *testing.T) {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: 0})
+			if err != nil {
+				t.Skipf("can't listen on %s: %v", ip, err)
+			}
+			defer conn.Close()
+
+			connFd, err := ConnFd(conn)
+			require.Nil(t, err)
+
+			err = EnableSWTimestampsRx(connFd)
+			require.Nil(t, err)
+			err = EnablePacketInfo(connFd)
+			require.Nil(t, err)
+			err = EnableTrafficClassInfo(connFd)
+			require.Nil(t, err)
+			err = unix.SetNonblock(connFd, false)
+			require.Nil(t, err)
+			waitForRXTimestamps(t, conn, connFd)
+
+			// send packets marked with DSCP 46 (EF) to ourselves
+			if net.ParseIP(ip).To4() != nil {
+				err = unix.SetsockoptInt(connFd, unix.IPPROTO_IP, unix.IP_TOS, 46<<2)
+			} else {
+				err = unix.SetsockoptInt(connFd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, 46<<2)
+			}
+			require.Nil(t, err)
+			_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+			require.Nil(t, err)
+
+			buf := make([]byte, PayloadSizeBytes)
+			oob := make([]byte, 2*ControlSizeBytes)
+			var rsa unix.RawSockaddrAny
+			info := PacketInfo{}
+			n, _, err := ReadPacketWithRXTimestampAndInfoBuf(connFd, buf, oob, &rsa, &info)
+			require.Nil(t, err)
+			require.Equal(t, []byte{1, 2, 3}, buf[:n])
+			require.Equal(t, ip, info.Dst.String())
+			require.Equal(t, uint8(46), info.DSCP())
+		})
+	}
+}
+
+func benchmarkRead(b *testing.B, read func(connFd int, buf, oob []byte) error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(b, err)
+	defer conn.Close()
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	require.Nil(b, err)
+	defer client.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(b, err)
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(b, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(b, err)
+
+	payload := []byte{1, 2, 3}
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		_, err = client.Write(payload)
+		require.Nil(b, err)
+		b.StartTimer()
+		// first packets may come without timestamps, we only care about the cost here
+		if err := read(connFd, buf, oob); err != nil && i > 100 {
+			require.Nil(b, err)
+		}
+	}
+}
+
+func BenchmarkReadPacketWithRXTimestampBuf(b *testing.B) {
+	benchmarkRead(b, func(connFd int, buf, oob []byte) error {
+		_, _, _, err := ReadPacketWithRXTimestampBuf(connFd, buf, oob)
+		return err
+	})
+}
+
+func BenchmarkReadPacketWithRXTimestampBufAddr(b *testing.B) {
+	var rsa unix.RawSockaddrAny
+	benchmarkRead(b, func(connFd int, buf, oob []byte) error {
+		_, _, err := ReadPacketWithRXTimestampBufAddr(connFd, buf, oob, &rsa)
+		return err
+	})
 }
 
-func TestSocketControlMessageTimestamp(t *testing.T) {
-	if timestamping != unix.SO_TIMESTAMPING_NEW {
-		t.Skip("This test supports SO_TIMESTAMPING_NEW only. No sample of SO_TIMESTAMPING")
+// linux enables timestamping asynchronously, so the first packets after enabling it may come without timestamps.
+// waitForRXTimestamps sends packets over the connection until timestamped one is received.
+func waitForRXTimestamps(t *testing.T, conn *net.UDPConn, connFd int) {
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	for i := 0; i < 100; i++ {
+		_, err := conn.WriteTo([]byte{0}, conn.LocalAddr())
+		require.Nil(t, err)
+		// socket may be non-blocking
+		_, boob, _, _, err := unix.Recvmsg(connFd, buf, oob, 0)
+		for err == unix.EAGAIN {
+			time.Sleep(time.Millisecond)
+			_, boob, _, _, err = unix.Recvmsg(connFd, buf, oob, 0)
+		}
+		require.Nil(t, err)
+		if _, err = socketControlMessageTimestamp(oob[:boob]); err == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
 	}
+	require.FailNow(t, "no RX timestamps received")
+}
+
+func TestParseTimestamps(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+	waitForRXTimestamps(t, conn, connFd)
+
+	start := time.Now()
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	_, oobn, _, _, err := conn.ReadMsgUDP(buf, oob)
+	require.Nil(t, err)
+
+	ts, err := ParseTimestamps(oob[:oobn])
+	require.Nil(t, err)
+	require.Equal(t, SW, ts.Selected)
+	require.False(t, ts.Software.Before(start.Truncate(time.Microsecond)))
+	require.Equal(t, ts.Software, ts.Time())
+
+	_, err = ParseTimestamps([]byte{})
+	require.Error(t, err)
+}
+
+func TestConnectedSocket(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer server.Close()
+	conn, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	err = EnableSWTimestampsSocket(connFd)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+
+	start := time.Now()
+	n, txts, err := WriteAndReadTX(connFd, []byte{1, 2, 3})
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+	require.False(t, txts.Before(start.Truncate(time.Microsecond)))
 
-	var b []byte
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	// linux enables timestamping asynchronously, first packets may come without timestamps
+	for i := 0; i < 100; i++ {
+		_, addr, err := server.ReadFrom(buf)
+		require.Nil(t, err)
+		start = time.Now()
+		_, err = server.WriteTo([]byte{4, 5, 6}, addr)
+		require.Nil(t, err)
 
-	// unix.Cmsghdr used in socketControlMessageTimestamp differs depending on platform
-	switch runtime.GOARCH {
-	case "amd64":
-		b = []byte{60, 0, 0, 0, 0, 0, 0, 0, 41, 0, 0, 0, 25, 0, 0, 0, 42, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 64, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 65, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 230, 180, 10, 97, 0, 0, 0, 0, 239, 83, 199, 39, 0, 0, 0, 0}
-	case "386":
-		b = []byte{56, 0, 0, 0, 41, 0, 0, 0, 25, 0, 0, 0, 42, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 60, 0, 0, 0, 1, 0, 0, 0, 65, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 230, 180, 10, 97, 0, 0, 0, 0, 239, 83, 199, 39, 0, 0, 0, 0}
-	default:
-		t.Skip("This test supports amd64/386 platforms only")
+		var rxts time.Time
+		n, rxts, err = ReadWithRXTimestampBuf(connFd, buf, oob)
+		require.Equal(t, []byte{4, 5, 6}, buf[:n])
+		if err == nil {
+			require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+			return
+		}
+		_, err = conn.Write([]byte{1, 2, 3})
+		require.Nil(t, err)
+		_, _, _ = ReadTXtimestamp(connFd)
 	}
+	require.FailNow(t, "no RX timestamps received")
+}
+
+func TestRetryAfterDrain(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
 
-	ts, err := socketControlMessageTimestamp(b)
-	require.NoError(t, err)
-	require.Equal(t, int64(1628091622667374575), ts.UnixNano())
+	calls := 0
+	err = retryAfterDrain(connFd, func() error {
+		calls++
+		if calls == 1 {
+			return unix.ENOBUFS
+		}
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, calls)
+
+	calls = 0
+	err = retryAfterDrain(connFd, func() error {
+		calls++
+		return unix.EPERM
+	})
+	require.Equal(t, unix.EPERM, err)
+	require.Equal(t, 1, calls)
+
+	err = SendtoWithDrain(connFd, []byte{1, 2, 3}, IPToSockaddr(net.ParseIP("127.0.0.1"), conn.LocalAddr().(*net.UDPAddr).Port))
+	require.Nil(t, err)
 }
@@ -0,0 +1,79 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Darwin and FreeBSD can't return TX timestamps via socket error queue.
+// Instead we emulate them by reading the system clock right after the packet was handed to the kernel.
+// txEmulated keeps inode numbers of sockets we do it for by their descriptors.
+// Descriptors of closed sockets get reused, so an entry only counts while the inode matches, stale ones are deleted once found.
+var txEmulated = struct {
+	sync.Mutex
+	m map[int]uint64
+}{m: map[int]uint64{}}
+
+// socketInode returns inode number which identifies the socket while it's open
+func socketInode(connFd int) (uint64, error) {
+	var st unix.Stat_t
+	if err := unix.Fstat(connFd, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Ino), nil
+}
+
+// enableTXEmulation starts emulating TX timestamps on the socket, forgetting sockets closed since it was started on them
+func enableTXEmulation(connFd int) error {
+	ino, err := socketInode(connFd)
+	if err != nil {
+		return err
+	}
+	txEmulated.Lock()
+	defer txEmulated.Unlock()
+	for fd := range txEmulated.m {
+		txEmulatedLocked(fd)
+	}
+	txEmulated.m[connFd] = ino
+	return nil
+}
+
+// txEmulatedOn checks if TX timestamps are emulated on the socket
+func txEmulatedOn(connFd int) bool {
+	txEmulated.Lock()
+	defer txEmulated.Unlock()
+	return txEmulatedLocked(connFd)
+}
+
+// txEmulatedLocked is txEmulatedOn which expects txEmulated to be locked. It deletes the entry if the socket was closed.
+func txEmulatedLocked(connFd int) bool {
+	want, ok := txEmulated.m[connFd]
+	if !ok {
+		return false
+	}
+	if ino, err := socketInode(connFd); err != nil || ino != want {
+		delete(txEmulated.m, connFd)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestTXEmulatedClosedSocket(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	err = EnableSWTimestampsTx(connFd)
+	require.Nil(t, err)
+	_, _, err = ReadTXtimestamp(connFd)
+	require.Nil(t, err)
+
+	// the socket is forgotten once closed
+	require.Nil(t, conn.Close())
+	_, _, err = ReadTXtimestamp(connFd)
+	require.Error(t, err)
+	txEmulated.Lock()
+	require.NotContains(t, txEmulated.m, connFd)
+	txEmulated.Unlock()
+}
+
+func TestTXEmulatedReusedDescriptor(t *testing.T) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	require.Nil(t, err)
+	err = EnableSWTimestampsTx(fd)
+	require.Nil(t, err)
+	ino, err := socketInode(fd)
+	require.Nil(t, err)
+	require.Nil(t, unix.Close(fd))
+
+	// the lowest free descriptor is reused by the next socket
+	reused, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	require.Nil(t, err)
+	defer unix.Close(reused)
+	reusedIno, err := socketInode(reused)
+	require.Nil(t, err)
+	if reused != fd || reusedIno == ino {
+		t.Skip("descriptor was not reused by a socket with a different inode")
+	}
+	require.False(t, txEmulatedOn(reused))
+	_, _, err = ReadTXtimestamp(reused)
+	require.Error(t, err)
+}
+
+func TestTXEmulatedPruned(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	err = EnableSWTimestampsTx(connFd)
+	require.Nil(t, err)
+	require.Nil(t, conn.Close())
+
+	// enabling emulation on another socket forgets closed ones
+	other, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer other.Close()
+	otherFd, err := ConnFd(other)
+	require.Nil(t, err)
+	err = EnableSWTimestampsTx(otherFd)
+	require.Nil(t, err)
+
+	txEmulated.Lock()
+	defer txEmulated.Unlock()
+	if otherFd != connFd {
+		require.NotContains(t, txEmulated.m, connFd)
+	}
+	require.Contains(t, txEmulated.m, otherFd)
+}
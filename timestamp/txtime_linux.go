@@ -0,0 +1,101 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+// Here we have SO_TXTIME support which allows scheduling packets departure, usually with ETF qdisc
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// from include/uapi/asm-generic/socket.h, not available in golang.org/x/sys yet
+const (
+	soTXTime  = 61
+	scmTXTime = soTXTime
+)
+
+// from include/uapi/linux/net_tstamp.h
+const (
+	// TXTimeDeadlineMode makes the departure time a deadline rather than the exact launch time
+	TXTimeDeadlineMode uint32 = 1 << 0
+	// TXTimeReportErrors makes kernel report packets dropped because of missed or invalid departure time via error queue
+	TXTimeReportErrors uint32 = 1 << 1
+)
+
+// TXTimeSizeBytes is the size of socket control message carrying the departure time
+var TXTimeSizeBytes = unix.CmsgSpace(8)
+
+// sockTXTime is struct sock_txtime from include/uapi/linux/net_tstamp.h
+type sockTXTime struct {
+	clockid int32
+	flags   uint32
+}
+
+// EnableTXTime enables SO_TXTIME on the socket.
+// Departure times passed along with the packets are interpreted in clockid clock, ETF qdisc normally expects unix.CLOCK_TAI.
+// Clocks other than unix.CLOCK_MONOTONIC require CAP_NET_ADMIN.
+func EnableTXTime(connFd int, clockid int32, flags uint32) error {
+	st := sockTXTime{clockid: clockid, flags: flags}
+	b := (*[unsafe.Sizeof(st)]byte)(unsafe.Pointer(&st))[:]
+	// there is no generic setsockopt in golang.org/x/sys, it's a way to pass raw bytes
+	if err := unix.SetsockoptString(connFd, unix.SOL_SOCKET, soTXTime, string(b)); err != nil {
+		return fmt.Errorf("failed to enable SO_TXTIME: %v", err)
+	}
+	return nil
+}
+
+// TXTimeControlMessage writes socket control message with the departure time t into oob and returns it.
+// t must be in the timescale of the clock passed to EnableTXTime.
+func TXTimeControlMessage(oob []byte, t time.Time) ([]byte, error) {
+	if len(oob) < TXTimeSizeBytes {
+		return nil, fmt.Errorf("oob buffer is too small: %d < %d", len(oob), TXTimeSizeBytes)
+	}
+	oob = oob[:TXTimeSizeBytes]
+	for i := range oob {
+		oob[i] = 0
+	}
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = scmTXTime
+	h.SetLen(unix.CmsgLen(8))
+	nativeEndian.PutUint64(oob[unix.CmsgLen(0):], uint64(t.UnixNano()))
+	return oob, nil
+}
+
+// WriteToWithTXTime sends the packet to addr scheduling its departure at t.
+// SO_TXTIME must be enabled on the socket with EnableTXTime.
+func WriteToWithTXTime(connFd int, b []byte, addr unix.Sockaddr, t time.Time) (int, error) {
+	oob := make([]byte, TXTimeSizeBytes)
+	return WriteToWithTXTimeBuf(connFd, b, oob, addr, t)
+}
+
+// WriteToWithTXTimeBuf is like WriteToWithTXTime, but uses provided oob buffer of at least TXTimeSizeBytes
+func WriteToWithTXTimeBuf(connFd int, b, oob []byte, addr unix.Sockaddr, t time.Time) (int, error) {
+	oob, err := TXTimeControlMessage(oob, t)
+	if err != nil {
+		return 0, err
+	}
+	n, err := unix.SendmsgN(connFd, b, oob, addr, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send packet: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestTXTimeControlMessage(t *testing.T) {
+	departure := time.Unix(1647357705, 123456789)
+	oob := make([]byte, ControlSizeBytes)
+	oob, err := TXTimeControlMessage(oob, departure)
+	require.Nil(t, err)
+	require.Equal(t, TXTimeSizeBytes, len(oob))
+
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(msgs))
+	require.Equal(t, int32(unix.SOL_SOCKET), msgs[0].Header.Level)
+	require.Equal(t, int32(scmTXTime), msgs[0].Header.Type)
+	require.Equal(t, uint64(departure.UnixNano()), nativeEndian.Uint64(msgs[0].Data))
+
+	_, err = TXTimeControlMessage(make([]byte, 1), departure)
+	require.Error(t, err)
+}
+
+func TestWriteToWithTXTime(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableTXTime(connFd, unix.CLOCK_MONOTONIC, TXTimeReportErrors)
+	require.Nil(t, err)
+
+	// without ETF qdisc on the loopback packet departs right away
+	var now unix.Timespec
+	err = unix.ClockGettime(unix.CLOCK_MONOTONIC, &now)
+	require.Nil(t, err)
+	addr := IPToSockaddr(net.ParseIP("127.0.0.1"), conn.LocalAddr().(*net.UDPAddr).Port)
+	n, err := WriteToWithTXTime(connFd, []byte{1, 2, 3}, addr, time.Unix(now.Unix()))
+	require.Nil(t, err)
+	require.Equal(t, 3, n)
+
+	buf := make([]byte, PayloadSizeBytes)
+	err = conn.SetReadDeadline(time.Now().Add(time.Second))
+	require.Nil(t, err)
+	n, _, err = conn.ReadFromUDP(buf)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+}
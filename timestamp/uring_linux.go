@@ -0,0 +1,288 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// from include/uapi/linux/io_uring.h
+const (
+	ioringOffSQRing uint64 = 0
+	ioringOffCQRing uint64 = 0x8000000
+	ioringOffSQEs   uint64 = 0x10000000
+
+	ioringOpRecvmsg      uint8  = 10
+	ioringEnterGetevents uint32 = 1
+)
+
+// number of receive requests we keep in flight
+const uringEntries = 64
+
+// from include/uapi/linux/io_uring.h
+type ioSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+// from include/uapi/linux/io_uring.h
+type ioCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+// from include/uapi/linux/io_uring.h
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+// from include/uapi/linux/io_uring.h
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	msgFlags    uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	pad         [2]uint64
+}
+
+// from include/uapi/linux/io_uring.h
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uringSlot holds buffers of a single in-flight receive request
+type uringSlot struct {
+	buf  []byte
+	oob  []byte
+	name unix.RawSockaddrAny
+	iov  unix.Iovec
+	hdr  unix.Msghdr
+}
+
+// URingReader reads packets with RX timestamps using io_uring.
+// It keeps multiple receive requests in flight, so under load most reads don't need a syscall.
+// URingReader is not safe for concurrent use.
+type URingReader struct {
+	connFd int
+	ringFd int
+
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray []uint32
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqes    uintptr
+
+	slots   []uringSlot
+	pending uint32
+}
+
+// NewURingReader sets up io_uring for reading packets from connFd.
+// Returns an error if io_uring is not supported by the kernel.
+func NewURingReader(connFd int) (*URingReader, error) {
+	var p ioUringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("failed to set up io_uring: %w", errno)
+	}
+	r := &URingReader{connFd: connFd, ringFd: int(fd)}
+	if err := r.mmap(&p); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	r.slots = make([]uringSlot, p.sqEntries)
+	for i := range r.slots {
+		s := &r.slots[i]
+		s.buf = make([]byte, PayloadSizeBytes)
+		s.oob = make([]byte, ControlSizeBytes)
+		s.iov.Base = &s.buf[0]
+		s.hdr.Name = (*byte)(unsafe.Pointer(&s.name))
+		s.hdr.Iov = &s.iov
+		s.hdr.SetIovlen(1)
+		s.hdr.Control = &s.oob[0]
+		r.queue(uint64(i))
+	}
+	if err := r.enter(0); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *URingReader) mmap(p *ioUringParams) error {
+	var err error
+	r.sqRing, err = unix.Mmap(r.ringFd, int64(ioringOffSQRing), int(p.sqOff.array+p.sqEntries*4), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("failed to mmap io_uring submission queue: %w", err)
+	}
+	r.cqRing, err = unix.Mmap(r.ringFd, int64(ioringOffCQRing), int(p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(ioUringCQE{}))), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("failed to mmap io_uring completion queue: %w", err)
+	}
+	r.sqes, err = unix.Mmap(r.ringFd, int64(ioringOffSQEs), int(p.sqEntries*uint32(unsafe.Sizeof(ioUringSQE{}))), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("failed to mmap io_uring submission entries: %w", err)
+	}
+
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = (*[1 << 16]uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array]))[:p.sqEntries:p.sqEntries]
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = uintptr(p.cqOff.cqes)
+	return nil
+}
+
+// queue adds receive request for the slot to the submission queue
+func (r *URingReader) queue(slot uint64) {
+	s := &r.slots[slot]
+	s.iov.SetLen(len(s.buf))
+	s.hdr.Namelen = uint32(unix.SizeofSockaddrAny)
+	s.hdr.SetControllen(len(s.oob))
+
+	tail := *r.sqTail
+	idx := tail & r.sqMask
+	sqe := (*ioUringSQE)(unsafe.Pointer(&r.sqes[uintptr(idx)*unsafe.Sizeof(ioUringSQE{})]))
+	*sqe = ioUringSQE{
+		opcode:   ioringOpRecvmsg,
+		fd:       int32(r.connFd),
+		addr:     uint64(uintptr(unsafe.Pointer(&s.hdr))),
+		len:      1,
+		userData: slot,
+	}
+	r.sqArray[idx] = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+	r.pending++
+}
+
+// enter submits queued requests and waits for at least minComplete completions
+func (r *URingReader) enter(minComplete uint32) error {
+	var flags uint32
+	if minComplete > 0 {
+		flags = ioringEnterGetevents
+	}
+	for {
+		n, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.ringFd), uintptr(r.pending), uintptr(minComplete), uintptr(flags), 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("failed to enter io_uring: %w", errno)
+		}
+		r.pending -= uint32(n)
+		return nil
+	}
+}
+
+// ReadPacketWithRXTimestampBuf writes byte packet into provide buffer buf, and returns number of bytes copied to the buffer, client ip and RX timestamp.
+// oob buffer can be reaused after ReadPacketWithRXTimestampBuf call.
+func (r *URingReader) ReadPacketWithRXTimestampBuf(buf, oob []byte) (int, unix.Sockaddr, time.Time, error) {
+	var rsa unix.RawSockaddrAny
+	bbuf, timestamp, err := r.ReadPacketWithRXTimestampBufAddr(buf, oob, &rsa)
+	return bbuf, rawToSockaddr(&rsa), timestamp, err
+}
+
+// ReadPacketWithRXTimestampBufAddr is like ReadPacketWithRXTimestampBuf, but writes client address into provided rsa instead of allocating unix.Sockaddr.
+// It makes no heap allocations unless it fails.
+func (r *URingReader) ReadPacketWithRXTimestampBufAddr(buf, oob []byte, rsa *unix.RawSockaddrAny) (int, time.Time, error) {
+	head := *r.cqHead
+	for head == atomic.LoadUint32(r.cqTail) {
+		if err := r.enter(1); err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	cqe := *(*ioUringCQE)(unsafe.Pointer(&r.cqRing[r.cqes+uintptr(head&r.cqMask)*unsafe.Sizeof(ioUringCQE{})]))
+	atomic.StoreUint32(r.cqHead, head+1)
+
+	s := &r.slots[cqe.userData]
+	if cqe.res < 0 {
+		r.queue(cqe.userData)
+		return 0, time.Time{}, fmt.Errorf("failed to read timestamp: %v", unix.Errno(-cqe.res))
+	}
+	bbuf := copy(buf, s.buf[:cqe.res])
+	boob := copy(oob, s.oob[:s.hdr.Controllen])
+	*rsa = s.name
+	r.queue(cqe.userData)
+
+	timestamp, err := socketControlMessageTimestamp(oob[:boob])
+	return bbuf, timestamp, err
+}
+
+// Close tears down io_uring. It doesn't close the underlying socket.
+func (r *URingReader) Close() error {
+	err := unix.Close(r.ringFd)
+	for _, m := range [][]byte{r.sqes, r.cqRing, r.sqRing} {
+		if m != nil {
+			_ = unix.Munmap(m)
+		}
+	}
+	return err
+}
+
+// NewPacketReader returns io_uring based PacketReader if it's supported by the kernel, plain recvmsg one otherwise
+func NewPacketReader(connFd int) PacketReader {
+	r, err := NewURingReader(connFd)
+	if err != nil {
+		return NewRecvmsgReader(connFd)
+	}
+	return r
+}
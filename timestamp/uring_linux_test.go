@@ -0,0 +1,114 @@
+/*
+Copyright (c) Facebook, Inc. and its affiliates.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package timestamp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestURingReader(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+	waitForRXTimestamps(t, conn, connFd)
+
+	r, err := NewURingReader(connFd)
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	// more packets than slots to make sure requests are resubmitted
+	for i := 0; i < 2*uringEntries; i++ {
+		start := time.Now()
+		_, err = conn.WriteTo([]byte{byte(i), 2, 3}, conn.LocalAddr())
+		require.Nil(t, err)
+
+		n, sa, rxts, err := r.ReadPacketWithRXTimestampBuf(buf, oob)
+		require.Nil(t, err)
+		require.Equal(t, []byte{byte(i), 2, 3}, buf[:n])
+		require.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, sa.(*unix.SockaddrInet4).Port)
+		require.False(t, rxts.Before(start.Truncate(time.Microsecond)))
+	}
+
+	// burst of packets, completions are already waiting in the queue
+	for i := 0; i < 10; i++ {
+		_, err = conn.WriteTo([]byte{byte(i), 2, 3}, conn.LocalAddr())
+		require.Nil(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		n, _, _, err := r.ReadPacketWithRXTimestampBuf(buf, oob)
+		require.Nil(t, err)
+		require.Equal(t, []byte{byte(i), 2, 3}, buf[:n])
+	}
+}
+
+func TestURingReaderBufAddr(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	connFd, err := ConnFd(conn)
+	require.Nil(t, err)
+	err = EnableSWTimestampsRx(connFd)
+	require.Nil(t, err)
+	err = unix.SetNonblock(connFd, false)
+	require.Nil(t, err)
+	waitForRXTimestamps(t, conn, connFd)
+
+	r, err := NewURingReader(connFd)
+	if err != nil {
+		t.Skipf("io_uring is not available: %v", err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, PayloadSizeBytes)
+	oob := make([]byte, ControlSizeBytes)
+	var rsa unix.RawSockaddrAny
+	_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+	require.Nil(t, err)
+	n, _, err := r.ReadPacketWithRXTimestampBufAddr(buf, oob, &rsa)
+	require.Nil(t, err)
+	require.Equal(t, []byte{1, 2, 3}, buf[:n])
+	require.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, RawSockaddrToSockaddr(&rsa).(*unix.SockaddrInet4).Port)
+
+	runs := 100
+	for i := 0; i <= runs; i++ {
+		_, err = conn.WriteTo([]byte{1, 2, 3}, conn.LocalAddr())
+		require.Nil(t, err)
+	}
+	allocs := testing.AllocsPerRun(runs, func() {
+		_, _, err = r.ReadPacketWithRXTimestampBufAddr(buf, oob, &rsa)
+	})
+	require.Nil(t, err)
+	require.Equal(t, float64(0), allocs)
+}